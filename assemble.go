@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/assembler"
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/listing"
+	"github.com/robertjshirts/rogasmic/object"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// assembleFile lexes, parses and assembles a source file into an unlinked object.
+func assembleFile(inputFile string, verbose bool) (*object.Object, error) {
+	a, err := assembleSource(inputFile, verbose)
+	if err != nil {
+		return nil, err
+	}
+	return a.Object, nil
+}
+
+// assembleSource is assembleFile, but keeps the parser output around for listings.
+func assembleSource(inputFile string, verbose bool) (*assembler.Source, error) {
+	file, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	a, err := assembler.AssembleSource(inputFile, string(file))
+	if err != nil {
+		return nil, err
+	}
+
+	if verbose {
+		fmt.Printf("Tokenized %d tokens:\n", len(a.Tokens))
+		for _, token := range a.Tokens {
+			fmt.Printf("Token Type=%s, Literal=%s, Line=%d, Col=%d\n", types.TokenToLiteral[token.Type], token.Literal, token.Line, token.Col)
+		}
+		fmt.Printf("Parsed %d instructions:\n", len(a.Instructions))
+	}
+	return a, nil
+}
+
+// sourceListing lays the source out next to where it was placed in a linked image, or next to the object file bytes
+// at address 0 if image is nil.
+func sourceListing(a *assembler.Source, image *linker.Image) *listing.Listing {
+	l := listing.New(a.File, a.Text, a.Instructions, a.Sections, a.Symbols)
+	for _, section := range a.Object.Sections {
+		l.Place(section.Name, 0, section.Data)
+	}
+	if image == nil {
+		return l
+	}
+	for _, section := range image.Sections {
+		for _, input := range section.Inputs {
+			if input.File == a.File {
+				l.Place(input.Section, input.Address, image.Data[input.Address-image.Base:input.Address-image.Base+input.Size])
+			}
+		}
+	}
+	return l
+}
+
+// writeListing writes the listing to a file.
+func writeListing(listingFile string, l *listing.Listing) error {
+	file, err := os.Create(listingFile)
+	if err != nil {
+		return err
+	}
+	if err := l.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// objectPath swaps the extension of a source file for .o
+func objectPath(inputFile string) string {
+	return strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".o"
+}
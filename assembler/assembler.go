@@ -9,7 +9,7 @@ import (
 // Assembler is responsible for converting parsed instructions into machine code.
 type Assembler struct {
 	instructions []types.Instruction
-	labels       map[string]uint32 // Maps label names to instruction numbers
+	labels       map[string]uint32 // Maps label names to byte offsets
 }
 
 func NewAssembler(instructions []types.Instruction, labels map[string]uint32) *Assembler {
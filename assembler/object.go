@@ -0,0 +1,103 @@
+package assembler
+
+import (
+	"fmt"
+
+	"github.com/robertjshirts/rogasmic/object"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// AssembleObject converts the instructions into an object that can be linked with others. Branches to labels in the
+// same section are resolved here, every other symbol reference becomes a relocation for the linker. References to
+// names that are neither defined nor declared with .extern (or .weak) are errors, but declared externs can stay
+// undefined until the final link.
+func (a *Assembler) AssembleObject(file string, sections []types.Section, symbols []types.Symbol) (*object.Object, error) {
+	obj := object.New(file)
+
+	bySymbol := make(map[string]types.Symbol)
+	for _, symbol := range symbols {
+		bySymbol[symbol.Name] = symbol
+	}
+
+	for _, section := range sections {
+		data := make([]byte, 0, (section.End-section.Start)*4)
+		var thumb []types.Range
+		for i := section.Start; i < section.End; i++ {
+			instruction := a.instructions[i]
+			offset := uint32(len(data))
+			address := section.Offset + offset
+
+			labels := a.labels
+			if relocatable, ok := instruction.(types.Relocatable); ok {
+				token, relocationType := relocatable.Relocation()
+				symbol, known := bySymbol[token.Literal]
+				switch {
+				case token.Literal == "":
+					// Doesn't reference a symbol
+				case !known:
+					return nil, fmt.Errorf("undefined symbol %s at line %d, col %d (use .extern to reference symbols from other files)", token.Literal, token.Line, token.Col)
+				case relocationType == types.RelocationBranch && symbol.Defined && symbol.Section == section.Name && symbol.Binding != types.BindingWeak && !symbol.Thumb:
+					// Branch within the section, the offset won't change when the section moves. Weak symbols might be
+					// replaced by a definition in another file, so the linker has to resolve those, and calls to Thumb
+					// code are left to it too since it's the linker that turns them into BLX.
+				case relocationType == types.RelocationThumbBranch && symbol.Defined && symbol.Section == section.Name && symbol.Binding != types.BindingWeak:
+					// Thumb branches know whether their label is ARM or Thumb code, so they can switch by themselves
+				case relocationType == types.RelocationThumbBranch && types.Size(instruction) != 4:
+					return nil, fmt.Errorf("16 bit branch at line %d, col %d can't reach %s, which the linker places", token.Line, token.Col, token.Literal)
+				default:
+					obj.Relocations = append(obj.Relocations, object.Relocation{
+						Section: section.Name,
+						Offset:  offset,
+						Type:    relocationType,
+						Symbol:  token.Literal,
+						Line:    token.Line,
+					})
+					switch relocationType {
+					case types.RelocationBranch:
+						// Point the branch at itself until the linker fills in the real offset
+						labels = map[string]uint32{token.Literal: address}
+					case types.RelocationThumbBranch:
+						labels = map[string]uint32{token.Literal: address | 1}
+					}
+				}
+			}
+
+			code, err := instruction.ToMachineCode(labels)
+			if err != nil {
+				return nil, fmt.Errorf("error converting instruction %T to machine code: %w", instruction, err)
+			}
+			data = append(data, code...)
+
+			if thumbCode, ok := instruction.(types.ThumbCode); !ok || !thumbCode.IsThumb() || offset == uint32(len(data)) {
+				continue
+			}
+			if n := len(thumb); n > 0 && thumb[n-1].End == offset {
+				thumb[n-1].End = uint32(len(data))
+			} else {
+				thumb = append(thumb, types.Range{Start: offset, End: uint32(len(data))})
+			}
+		}
+		obj.Sections = append(obj.Sections, object.Section{Name: section.Name, Data: data, Thumb: thumb})
+	}
+
+	for _, symbol := range symbols {
+		objSymbol := object.Symbol{
+			Name:    symbol.Name,
+			Defined: symbol.Defined,
+			Binding: symbol.Binding,
+			Type:    symbol.Type,
+			Size:    symbol.Size,
+			Thumb:   symbol.Thumb,
+			Line:    symbol.Token.Line,
+		}
+		if symbol.Defined {
+			objSymbol.Section = symbol.Section
+			objSymbol.Offset = symbol.Offset
+		} else if symbol.Binding == types.BindingGlobal {
+			return nil, fmt.Errorf("symbol %s declared .global at line %d but never defined", symbol.Name, symbol.Token.Line)
+		}
+		obj.Symbols = append(obj.Symbols, objSymbol)
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,47 @@
+package assembler
+
+import (
+	"fmt"
+
+	"github.com/robertjshirts/rogasmic/lexer"
+	"github.com/robertjshirts/rogasmic/object"
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Source is a source file along with everything it was parsed and assembled into.
+type Source struct {
+	File         string
+	Text         string
+	Tokens       []types.Token
+	Instructions []types.Instruction
+	Sections     []types.Section
+	Symbols      []types.Symbol
+	Object       *object.Object
+}
+
+// AssembleSource lexes, parses and assembles the text of a source file into an unlinked object.
+func AssembleSource(file string, text string) (*Source, error) {
+	tokens, err := lexer.NewLexer(text).Tokenize()
+	if err != nil {
+		return nil, err
+	}
+	p := parser.NewParser(tokens)
+	instructions, labels, err := p.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing instructions: %w", err)
+	}
+	obj, err := NewAssembler(instructions, labels).AssembleObject(file, p.Sections(), p.Symbols())
+	if err != nil {
+		return nil, fmt.Errorf("error assembling instructions: %w", err)
+	}
+	return &Source{
+		File:         file,
+		Text:         text,
+		Tokens:       tokens,
+		Instructions: instructions,
+		Sections:     p.Sections(),
+		Symbols:      p.Symbols(),
+		Object:       obj,
+	}, nil
+}
@@ -0,0 +1,34 @@
+package assembler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/lexer"
+)
+
+func TestAssembleSourceKeepsTokens(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+	}{
+		{name: "ARM", input: "start:\nMOVW R0, #1\nB start"},
+		{name: "Thumb qualifiers", input: ".thumb\nADDS.W R0, R0, #1\nB.N start\nstart:\nSUBS.N R1, R1, #1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			expected, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			source, err := AssembleSource("test.asm", c.input)
+			if err != nil {
+				t.Fatalf("unexpected error assembling: %v", err)
+			}
+			if !reflect.DeepEqual(source.Tokens, expected) {
+				t.Errorf("tokens changed by parsing\nexpected: %+v\ngot:      %+v", expected, source.Tokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,957 @@
+package bcm
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/internal/emutest"
+)
+
+func TestGPIO(t *testing.T) {
+	cases := []struct {
+		name     string
+		source   string
+		inputs   map[int]bool
+		expected []Transition
+		levels   map[int]bool
+		fsel     map[int]Function
+		r0       uint32 // Whatever the program loaded into R0
+	}{
+		{
+			name:     "set and clear output",
+			source:   "MOV32 R4, #0x3F200000\nMOVW R0, #0x8\nSTR R0, [R4]\nMOVW R0, #0x2\nADD R1, R4, #0x1C\nSTR R0, [R1]\nADD R1, R4, #0x28\nSTR R0, [R1]\nend: B end",
+			expected: []Transition{{Time: 7, Pin: 1, Level: true}, {Time: 9, Pin: 1, Level: false}},
+			fsel:     map[int]Function{0: FunctionInput, 1: FunctionOutput},
+			r0:       0x2,
+		},
+		{
+			name:     "latch shows up when the pin becomes an output",
+			source:   "MOV32 R4, #0x3F200000\nMOVW R0, #0x0\nMOVT R0, #0x8\nADD R1, R4, #0x20\nSTR R0, [R1]\nMOVW R0, #0x8\nADD R1, R4, #0x14\nSTR R0, [R1]\nend: B end",
+			expected: []Transition{{Time: 9, Pin: 51, Level: true}},
+			levels:   map[int]bool{51: true},
+			fsel:     map[int]Function{51: FunctionOutput},
+			r0:       0x8,
+		},
+		{
+			name:   "inputs read through GPLEV",
+			source: "MOV32 R4, #0x3F200000\nADD R1, R4, #0x34\nLDR R0, [R1]\nend: B end",
+			inputs: map[int]bool{3: true, 4: true},
+			levels: map[int]bool{3: true, 4: true, 5: false},
+			r0:     0x18,
+		},
+		{
+			name:     "outputs ignore inputs",
+			source:   "MOV32 R4, #0x3F200000\nMOVW R0, #0x1000\nSTR R0, [R4]\nADD R1, R4, #0x34\nLDR R0, [R1]\nend: B end",
+			inputs:   map[int]bool{3: true, 4: true},
+			expected: []Transition{{Time: 4, Pin: 4, Level: false}},
+			levels:   map[int]bool{3: true, 4: false},
+			fsel:     map[int]Function{4: FunctionOutput},
+			r0:       0x8,
+		},
+		{
+			name:   "alternate functions",
+			source: "MOV32 R4, #0x3F200000\nMOVW R0, #0x4000\nORR R0, R0, #0x24000\nADD R1, R4, #0x4\nSTR R0, [R1]\nLDR R0, [R1]\nend: B end",
+			fsel:   map[int]Function{14: FunctionAlt0, 15: FunctionAlt0},
+			r0:     0x24000,
+		},
+		{
+			name:   "other registers hold their value",
+			source: "MOV32 R4, #0x3F200000\nMOVW R0, #0x2\nADD R1, R4, #0x94\nSTR R0, [R1]\nLDR R0, [R1]\nend: B end",
+			r0:     0x2,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := NewMachine()
+			m.Load(emutest.Image(t, c.source))
+			for pin, level := range c.inputs {
+				m.GPIO.SetInput(pin, level)
+			}
+			if err := m.Run(100); err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+
+			trace := m.GPIO.Trace()
+			if len(c.inputs) > 0 {
+				trace = trace[len(c.inputs):] // Skip the inputs going high before the program ran
+			}
+			if len(trace) != 0 || len(c.expected) != 0 {
+				if !reflect.DeepEqual(trace, c.expected) {
+					t.Errorf("expected trace %v, got %v", c.expected, trace)
+				}
+			}
+			for pin, level := range c.levels {
+				if m.GPIO.Level(pin) != level {
+					t.Errorf("GPIO%d: expected level %v, got %v", pin, level, m.GPIO.Level(pin))
+				}
+			}
+			for pin, function := range c.fsel {
+				if m.GPIO.Function(pin) != function {
+					t.Errorf("GPIO%d: expected function %s, got %s", pin, function, m.GPIO.Function(pin))
+				}
+			}
+			if m.CPU.R[0] != c.r0 {
+				t.Errorf("expected R0 0x%08X, got 0x%08X", c.r0, m.CPU.R[0])
+			}
+		})
+	}
+}
+
+func TestBlink(t *testing.T) {
+	source, err := os.ReadFile("../blink.asm")
+	if err != nil {
+		t.Fatalf("unexpected error reading blink.asm: %v", err)
+	}
+	m := NewMachine()
+	m.Load(emutest.Image(t, string(source)))
+	if err := m.Run(10000000); err == nil {
+		t.Fatalf("expected blink.asm to run forever, it halted: %s", m.CPU.HaltReason)
+	}
+
+	trace := m.GPIO.PinTrace(21)
+	if len(trace) < 4 {
+		t.Fatalf("expected pin 21 to toggle at least 4 times, got %v", trace)
+	}
+	if len(trace) != len(m.GPIO.Trace()) {
+		t.Errorf("expected only pin 21 to change, got %v", m.GPIO.Trace())
+	}
+	for i, transition := range trace {
+		if transition.Level != (i%2 == 0) {
+			t.Errorf("transition %d: expected GPIO21 to alternate starting high, got %v", i, trace)
+			break
+		}
+		if i > 2 && transition.Time-trace[i-2].Time != trace[i-1].Time-trace[i-3].Time {
+			t.Errorf("transition %d: expected a steady period, got %v", i, trace)
+			break
+		}
+	}
+}
+
+func TestBlinkFastForward(t *testing.T) {
+	source, err := os.ReadFile("../blink.asm")
+	if err != nil {
+		t.Fatalf("unexpected error reading blink.asm: %v", err)
+	}
+	slow, fast := NewMachine(), NewMachine()
+	slow.Load(emutest.Image(t, string(source)))
+	fast.Load(emutest.Image(t, string(source)))
+	fast.CPU.FastForward = true
+	slow.Run(10000000)
+	fast.Run(10000000)
+
+	if !reflect.DeepEqual(fast.GPIO.Trace(), slow.GPIO.Trace()) {
+		t.Errorf("expected the same trace as running every instruction\nslow: %v\nfast: %v", slow.GPIO.Trace(), fast.GPIO.Trace())
+	}
+	if fast.CPU.R != slow.CPU.R || fast.Now() != slow.Now() {
+		t.Errorf("expected the same registers and clock, got %08X at %d and %08X at %d", slow.CPU.R, slow.Now(), fast.CPU.R, fast.Now())
+	}
+}
+
+func TestSystemTimer(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		r0     uint32
+		steps  uint64
+	}{
+		{
+			name:   "counter",
+			source: "MOV32 R4, #0x3F003000\nADD R1, R4, #0x4\nLDR R0, [R1]\nend: B end",
+			r0:     4,
+			steps:  5,
+		},
+		{
+			name:   "poll for a compare match",
+			source: "MOV32 R4, #0x3F003000\nMOVW R0, #1000\nADD R1, R4, #0x10\nSTR R0, [R1]\nwait:\nLDR R0, [R4]\nANDS R0, R0, #0x2\nBEQ wait\nSTR R0, [R4]\nLDR R0, [R4]\nend: B end",
+			r0:     0,
+			steps:  1007,
+		},
+		{
+			name:   "high word",
+			source: "MOV32 R4, #0x3F003000\nADD R1, R4, #0x8\nLDR R0, [R1]\nend: B end",
+			r0:     0,
+			steps:  5,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := NewMachine()
+			m.CPUClock = SystemTimerClock // One instruction per microsecond
+			m.Load(emutest.Image(t, c.source))
+			if err := m.Run(100000); err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			if m.CPU.R[0] != c.r0 {
+				t.Errorf("expected R0 0x%08X, got 0x%08X", c.r0, m.CPU.R[0])
+			}
+			if m.Now() != c.steps {
+				t.Errorf("expected to finish after %d steps, got %d", c.steps, m.Now())
+			}
+		})
+	}
+}
+
+func TestSystemTimerCompare(t *testing.T) {
+	now := uint64(0xFFFFFFF0)
+	s := NewSystemTimer(func() uint64 { return now })
+	s.Write32(TimerC0, 0xFFFFFFF8)
+	s.Write32(TimerC0+4, 0x00000004) // After the low word wraps
+	s.Write32(TimerC0+8, 0xFFFFFFF0) // Already passed
+
+	for _, step := range []struct {
+		now     uint64
+		matched uint32
+	}{
+		{now: 0xFFFFFFF7, matched: 0},
+		{now: 0xFFFFFFF8, matched: 0b0001},
+		{now: 0x100000003, matched: 0b1001}, // C3 is still 0, which the low word just went through
+		{now: 0x100000010, matched: 0b1011},
+		{now: 0x300000000, matched: 0b1111}, // Everything goes past in a full wrap
+	} {
+		now = step.now
+		if got := s.Read32(TimerCS); got != step.matched {
+			t.Errorf("at 0x%X: expected matches 0b%04b, got 0b%04b", now, step.matched, got)
+		}
+	}
+	if s.Read32(TimerCLO) != 0 || s.Read32(TimerCHI) != 3 {
+		t.Errorf("expected counter 0x300000000, got 0x%08X%08X", s.Read32(TimerCHI), s.Read32(TimerCLO))
+	}
+	s.Write32(TimerCS, 0b0101)
+	if !s.Matched(1) || s.Matched(0) || s.Matched(2) {
+		t.Errorf("expected writing 1s to CS to clear only those matches, got 0b%04b", s.Read32(TimerCS))
+	}
+}
+
+func TestARMTimer(t *testing.T) {
+	type write struct {
+		offset uint32
+		value  uint32
+	}
+	cases := []struct {
+		name    string
+		writes  []write
+		ticks   uint64 // APB ticks after the writes
+		value   uint32
+		raw     bool
+		pending bool
+		freeRun uint32
+	}{
+		{
+			name:   "disabled",
+			writes: []write{{ARMTimerLoad, 100}},
+			ticks:  1000,
+			value:  100,
+		},
+		{
+			name:   "counting down",
+			writes: []write{{ARMTimerPreDiv, 0}, {ARMTimerLoad, 100}, {ARMTimerControl, ARMTimer32Bit | ARMTimerEnable}},
+			ticks:  40,
+			value:  60,
+		},
+		{
+			name:   "reaches zero",
+			writes: []write{{ARMTimerPreDiv, 0}, {ARMTimerLoad, 100}, {ARMTimerControl, ARMTimer32Bit | ARMTimerEnable}},
+			ticks:  100,
+			value:  0,
+			raw:    true,
+		},
+		{
+			name:    "reloads",
+			writes:  []write{{ARMTimerPreDiv, 0}, {ARMTimerLoad, 100}, {ARMTimerControl, ARMTimer32Bit | ARMTimerEnable | ARMTimerIRQEnable}},
+			ticks:   111,
+			value:   90,
+			raw:     true,
+			pending: true,
+		},
+		{
+			name:   "reload register",
+			writes: []write{{ARMTimerPreDiv, 0}, {ARMTimerLoad, 100}, {ARMTimerReload, 10}, {ARMTimerControl, ARMTimer32Bit | ARMTimerEnable}},
+			ticks:  103,
+			value:  8,
+			raw:    true,
+		},
+		{
+			name:   "default pre-divider and prescaler",
+			writes: []write{{ARMTimerLoad, 100}, {ARMTimerControl, ARMTimer32Bit | ARMTimerEnable | 1<<2}},
+			ticks:  126 * 16 * 10,
+			value:  90,
+		},
+		{
+			name:   "16 bit counter",
+			writes: []write{{ARMTimerPreDiv, 0}, {ARMTimerLoad, 0x10010}, {ARMTimerControl, ARMTimerEnable}},
+			ticks:  0x20, // Only 0x10 of the load counts, so it reaches 0 and reloads
+			value:  1,
+			raw:    true,
+		},
+		{
+			name:    "free running counter",
+			writes:  []write{{ARMTimerControl, ARMTimerFreeRunOn | 4<<16}},
+			ticks:   52,
+			value:   0xFFFFFFFF,
+			freeRun: 10,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now := uint64(1000)
+			a := NewARMTimer(func() uint64 { return now })
+			for _, w := range c.writes {
+				a.Write32(w.offset, w.value)
+			}
+			now += c.ticks
+			if got := a.Read32(ARMTimerValue); got != c.value {
+				t.Errorf("expected value %d, got %d", c.value, got)
+			}
+			if got := a.Read32(ARMTimerRawIRQ) == 1; got != c.raw {
+				t.Errorf("expected raw IRQ %v, got %v", c.raw, got)
+			}
+			if got := a.Read32(ARMTimerMaskedIRQ) == 1; got != c.pending || a.Pending() != c.pending {
+				t.Errorf("expected masked IRQ %v, got %v", c.pending, got)
+			}
+			if got := a.Read32(ARMTimerFreeRun); got != c.freeRun {
+				t.Errorf("expected free running counter %d, got %d", c.freeRun, got)
+			}
+			a.Write32(ARMTimerIRQClear, 1)
+			if a.Read32(ARMTimerRawIRQ) != 0 {
+				t.Errorf("expected the IRQ to clear")
+			}
+		})
+	}
+}
+
+func TestInterruptController(t *testing.T) {
+	type write struct {
+		offset uint32
+		value  uint32
+	}
+	cases := []struct {
+		name     string
+		raised   []int
+		writes   []write
+		basic    uint32
+		pending1 uint32
+		pending2 uint32
+		irq      bool
+		fiq      bool
+	}{
+		{
+			name:   "disabled",
+			raised: []int{1, IRQARMTimer},
+		},
+		{
+			name:     "enabled",
+			raised:   []int{1, 3, IRQARMTimer},
+			writes:   []write{{EnableIRQs1, 1 << 1}, {EnableBasicIRQs, 1}},
+			basic:    1<<8 | 1,
+			pending1: 1 << 1,
+			irq:      true,
+		},
+		{
+			name:     "shortcuts",
+			raised:   []int{IRQUART, 9},
+			writes:   []write{{EnableIRQs2, 1 << (IRQUART - 32)}, {EnableIRQs1, 1 << 9}},
+			basic:    1<<19 | 1<<11,
+			pending1: 1 << 9,
+			pending2: 1 << (IRQUART - 32),
+			irq:      true,
+		},
+		{
+			name:     "second pending register",
+			raised:   []int{IRQAux, 49},
+			writes:   []write{{EnableIRQs2, 1 << (49 - 32)}},
+			basic:    1 << 9,
+			pending2: 1 << (49 - 32),
+			irq:      true,
+		},
+		{
+			name:   "disable",
+			raised: []int{1},
+			writes: []write{{EnableIRQs1, 1<<1 | 1<<3}, {DisableIRQs1, 1 << 1}},
+		},
+		{
+			name:   "FIQ",
+			raised: []int{IRQARMTimer},
+			writes: []write{{FIQControl, FIQControlEnable | IRQARMTimer}},
+			fiq:    true,
+		},
+		{
+			name:   "FIQ source not raised",
+			raised: []int{IRQARMTimer},
+			writes: []write{{FIQControl, FIQControlEnable | IRQUART}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ic := NewInterruptController()
+			for irq := 0; irq < 72; irq++ {
+				raised := false
+				for _, r := range c.raised {
+					raised = raised || r == irq
+				}
+				ic.Connect(irq, func() bool { return raised })
+			}
+			for _, w := range c.writes {
+				ic.Write32(w.offset, w.value)
+			}
+			if got := ic.Read32(IRQBasicPending); got != c.basic {
+				t.Errorf("expected basic pending 0x%08X, got 0x%08X", c.basic, got)
+			}
+			if got := ic.Read32(IRQPending1); got != c.pending1 {
+				t.Errorf("expected pending 1 0x%08X, got 0x%08X", c.pending1, got)
+			}
+			if got := ic.Read32(IRQPending2); got != c.pending2 {
+				t.Errorf("expected pending 2 0x%08X, got 0x%08X", c.pending2, got)
+			}
+			if ic.IRQ() != c.irq || ic.FIQ() != c.fiq {
+				t.Errorf("expected IRQ %v and FIQ %v, got %v and %v", c.irq, c.fiq, ic.IRQ(), ic.FIQ())
+			}
+		})
+	}
+}
+
+func TestLocalPeripherals(t *testing.T) {
+	type write struct {
+		offset uint32
+		value  uint32
+	}
+	timer := uint32(LocalTimerEnable | LocalTimerIRQEnable | 100)
+	cases := []struct {
+		name    string
+		gpu     bool // Whether the interrupt controller is raising its IRQ and FIQ
+		writes  []write
+		ticks   uint64 // Local timer ticks after the writes
+		irq     [Cores]uint32
+		fiq     [Cores]uint32
+		flag    bool
+		counter uint64
+	}{
+		{
+			name:   "mailbox IRQ",
+			writes: []write{{LocalMailboxIRQ + 4, 1 << 2}, {LocalMailboxSet + 0x10 + 8, 5}},
+			irq:    [Cores]uint32{1: SourceMailbox << 2},
+		},
+		{
+			name:   "mailbox FIQ wins",
+			writes: []write{{LocalMailboxIRQ, 0x11}, {LocalMailboxSet, 1}},
+			fiq:    [Cores]uint32{SourceMailbox},
+		},
+		{
+			name:   "mailbox cleared",
+			writes: []write{{LocalMailboxIRQ, 1}, {LocalMailboxSet, 3}, {LocalMailboxClear, 3}},
+		},
+		{
+			name:   "mailbox without its interrupt",
+			writes: []write{{LocalMailboxSet + 4, 1}},
+		},
+		{
+			name: "GPU",
+			gpu:  true,
+			irq:  [Cores]uint32{SourceGPU},
+			fiq:  [Cores]uint32{SourceGPU},
+		},
+		{
+			name:   "GPU routed",
+			gpu:    true,
+			writes: []write{{LocalGPURouting, 3<<2 | 2}},
+			irq:    [Cores]uint32{2: SourceGPU},
+			fiq:    [Cores]uint32{3: SourceGPU},
+		},
+		{
+			name:    "local timer",
+			writes:  []write{{LocalTimerControl, timer}},
+			ticks:   100,
+			irq:     [Cores]uint32{SourceLocalTimer},
+			flag:    true,
+			counter: 50,
+		},
+		{
+			name:    "local timer not there yet",
+			writes:  []write{{LocalTimerControl, timer}},
+			ticks:   99,
+			counter: 49,
+		},
+		{
+			name:    "local timer FIQ",
+			writes:  []write{{LocalTimerRouting, 5}, {LocalTimerControl, timer}},
+			ticks:   250,
+			fiq:     [Cores]uint32{1: SourceLocalTimer},
+			flag:    true,
+			counter: 125,
+		},
+		{
+			name:    "local timer interrupt disabled",
+			writes:  []write{{LocalTimerControl, LocalTimerEnable | 100}},
+			ticks:   100,
+			flag:    true,
+			counter: 50,
+		},
+		{
+			name:    "local timer cleared",
+			writes:  []write{{LocalTimerControl, timer | 10}, {LocalTimerClear, LocalTimerFlag}},
+			counter: 0,
+		},
+		{
+			name:    "core timer set and prescaled",
+			writes:  []write{{LocalPrescaler, 0x40000000}, {LocalCoreTimerLS, 0xFFFFFFFF}, {LocalCoreTimerMS, 1}},
+			ticks:   1000,
+			counter: 0x1FFFFFFFF + 250,
+		},
+		{
+			name:    "core timer on the APB clock by 2",
+			writes:  []write{{LocalControl, LocalControlAPB | LocalControlIncrement}},
+			ticks:   192,
+			counter: 2500,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now := uint64(0)
+			ic := NewInterruptController()
+			ic.Connect(IRQARMTimer, func() bool { return c.gpu })
+			ic.Write32(EnableBasicIRQs, 1)
+			ic.Write32(FIQControl, FIQControlEnable|IRQARMTimer)
+			l := NewLocalPeripherals(func(hz uint64) uint64 { return now * hz / LocalTimerClock }, ic)
+			for _, w := range c.writes {
+				l.Write32(w.offset, w.value)
+			}
+			now += c.ticks
+			for core := 0; core < Cores; core++ {
+				irq, fiq := l.Read32(LocalIRQSource+4*uint32(core)), l.Read32(LocalFIQSource+4*uint32(core))
+				if irq != c.irq[core] || fiq != c.fiq[core] {
+					t.Errorf("core %d: expected IRQ sources 0x%X and FIQ sources 0x%X, got 0x%X and 0x%X", core, c.irq[core], c.fiq[core], irq, fiq)
+				}
+				if irqLine, fiqLine := l.Lines(core); irqLine != (irq != 0) || fiqLine != (fiq != 0) {
+					t.Errorf("core %d: expected the lines to follow the sources, got %v and %v", core, irqLine, fiqLine)
+				}
+			}
+			if got := l.Read32(LocalTimerControl)&LocalTimerFlag != 0; got != c.flag {
+				t.Errorf("expected local timer flag %v, got %v", c.flag, got)
+			}
+			counter := uint64(l.Read32(LocalCoreTimerLS))
+			counter |= uint64(l.Read32(LocalCoreTimerMS)) << 32
+			if counter != c.counter {
+				t.Errorf("expected core timer 0x%X, got 0x%X", c.counter, counter)
+			}
+		})
+	}
+}
+
+// timerBlink toggles GPIO21 from the ARM timer interrupt every 1000 APB ticks, while the main program runs a delay
+// loop and then idles. The vector table is at the start of the image.
+const timerBlink = `B start
+B hang
+B hang
+B hang
+B hang
+B hang
+B irq
+B hang
+hang: B hang
+
+start:
+MOV32 R4, #0x3F200000
+MOVW R0, #0x8
+ADD R1, R4, #0x8
+STR R0, [R1]
+ADD R9, R4, #0x1C
+ADD R10, R4, #0x28
+MOV32 R8, #0x200000
+MOV32 R5, #0x3F00B400
+MOVW R0, #0
+ADD R1, R5, #0x1C
+STR R0, [R1]
+MOVW R0, #999
+STR R0, [R5]
+MOVW R0, #0xA2
+ADD R1, R5, #0x8
+STR R0, [R1]
+ADD R11, R5, #0xC
+MOV32 R6, #0x3F00B200
+MOVW R0, #1
+ADD R1, R6, #0x18
+STR R0, [R1]
+MOV32 R3, #20000
+delay:
+SUBS R3, R3, #1
+BPL delay
+end: B end
+
+irq:
+STR R0, [R11]
+ADD R7, R7, #1
+ANDS R2, R7, #1
+STRNE R8, [R9]
+STREQ R8, [R10]
+SUBS pc, lr, #4
+`
+
+func TestTimerInterrupt(t *testing.T) {
+	slow, fast := NewMachine(), NewMachine()
+	for _, m := range []*Machine{slow, fast} {
+		m.Load(emutest.Image(t, timerBlink))
+		m.CPU.VBAR = 0x8000
+		m.CPU.CPSR &^= emu.FlagI
+	}
+	fast.CPU.FastForward = true
+	for _, m := range []*Machine{slow, fast} {
+		if err := m.Run(200000); err != emu.ErrStepLimit {
+			t.Fatalf("expected to run until the step limit, got %v", err)
+		}
+	}
+
+	trace := slow.GPIO.PinTrace(21)
+	if len(trace) != 41 {
+		t.Fatalf("expected 41 transitions, got %d: %v", len(trace), trace)
+	}
+	for i, transition := range trace {
+		if transition.Level != (i%2 == 0) {
+			t.Errorf("transition %d: expected GPIO21 to alternate starting high, got %v", i, trace)
+			break
+		}
+		if i > 1 && transition.Time-trace[i-2].Time != 9600 {
+			t.Errorf("transition %d: expected a period of 9600 instructions, got %v", i, trace)
+			break
+		}
+	}
+	if !reflect.DeepEqual(fast.GPIO.Trace(), slow.GPIO.Trace()) {
+		t.Errorf("expected the same trace fast-forwarding\nslow: %v\nfast: %v", slow.GPIO.Trace(), fast.GPIO.Trace())
+	}
+	if fast.CPU.R != slow.CPU.R || fast.CPU.CPSR != slow.CPU.CPSR || fast.Now() != slow.Now() {
+		t.Errorf("expected the same registers and clock, got %08X at %d and %08X at %d", slow.CPU.R, slow.Now(), fast.CPU.R, fast.Now())
+	}
+}
+
+// smp has every core read its number from the MPIDR. Core 0 checks in at 0x9000 and wakes the others by writing to
+// their mailbox 3, they wait for it and then check in at 0x9000+4*n with what it held.
+const smp = `start:
+MRC p15, 0, R0, c0, c0, 5
+ANDS R0, R0, #3
+BEQ primary
+MOV32 R4, #0x400000CC
+MOVW R5, #0x9000
+MOVW R2, #0
+ADD R2, R0, #0
+next:
+ADD R4, R4, #0x10
+ADD R5, R5, #4
+SUBS R2, R2, #1
+BNE next
+wait:
+LDR R1, [R4]
+ORRS R1, R1, #0
+BEQ wait
+STR R1, [R4]
+STR R1, [R5]
+halt: B halt
+
+primary:
+MOVW R5, #0x9000
+MOVW R1, #0xC0DE
+STR R1, [R5]
+MOV32 R4, #0x4000009C
+STR R1, [R4]
+ADD R4, R4, #0x10
+STR R1, [R4]
+ADD R4, R4, #0x10
+STR R1, [R4]
+end: B end
+`
+
+func TestCores(t *testing.T) {
+	cases := []struct {
+		name    string
+		cores   int
+		seed    int64
+		checkIn []uint32 // Expected at 0x9000 onwards
+	}{
+		{name: "core 0 alone", cores: 1, checkIn: []uint32{0xC0DE, 0, 0, 0}},
+		{name: "in turn", cores: 4, checkIn: []uint32{0xC0DE, 0xC0DE, 0xC0DE, 0xC0DE}},
+		{name: "two cores", cores: 2, checkIn: []uint32{0xC0DE, 0xC0DE, 0, 0}},
+		{name: "random", cores: 4, seed: 1, checkIn: []uint32{0xC0DE, 0xC0DE, 0xC0DE, 0xC0DE}},
+		{name: "another seed", cores: 4, seed: 2, checkIn: []uint32{0xC0DE, 0xC0DE, 0xC0DE, 0xC0DE}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			run := func() *Machine {
+				m := NewMachine()
+				m.Load(emutest.Image(t, smp))
+				for n := 1; n < c.cores; n++ {
+					m.Start(n, m.CPU.R[emu.PC])
+				}
+				if c.seed != 0 {
+					m.Rand = rand.New(rand.NewSource(c.seed))
+				}
+				if err := m.Run(10000); err != nil {
+					t.Fatalf("unexpected error running: %v", err)
+				}
+				return m
+			}
+			m := run()
+			for n, expected := range c.checkIn {
+				if got := m.CPU.Memory.Read32(0x9000 + 4*uint32(n)); got != expected {
+					t.Errorf("core %d: expected to check in with 0x%X, got 0x%X", n, expected, got)
+				}
+			}
+			for n, cpu := range m.Cores {
+				if m.Started(n) != (n < c.cores) || cpu.Halted != (n < c.cores) {
+					t.Errorf("core %d: expected started and halted to be %v, got %v and %v", n, n < c.cores, m.Started(n), cpu.Halted)
+				}
+				if n < c.cores && cpu.R[0] != uint32(n) {
+					t.Errorf("core %d: expected to read its number from the MPIDR, got %d", n, cpu.R[0])
+				}
+			}
+			if c.cores > 1 && m.Local.Mailbox(1, 3) != 0 {
+				t.Errorf("expected core 1 to clear its mailbox, got 0x%X", m.Local.Mailbox(1, 3))
+			}
+
+			again := run()
+			for n, cpu := range m.Cores {
+				if again.Cores[n].Steps != cpu.Steps {
+					t.Errorf("core %d: expected the same interleaving running again, got %d steps then %d", n, cpu.Steps, again.Cores[n].Steps)
+				}
+			}
+		})
+	}
+}
+
+// spinlock has every core add 1 to the word at 0x9004 fifty times, holding the lock at 0x9000 while it does. Waiting
+// cores sleep in WFE until the holder releases the lock with SEV.
+const spinlock = `start:
+MOVW R4, #0x9000
+MOVW R5, #0x9004
+MOVW R6, #50
+acquire:
+LDREX R0, [R4]
+ORRS R0, R0, #0
+BEQ try
+WFE
+B acquire
+try:
+MOVW R0, #1
+STREX R1, R0, [R4]
+ORRS R1, R1, #0
+BNE acquire
+DMB ISH
+LDR R2, [R5]
+ADD R2, R2, #1
+STR R2, [R5]
+DMB ISH
+MOVW R0, #0
+STR R0, [R4]
+DSB ISH
+SEV
+SUBS R6, R6, #1
+BNE acquire
+halt: B halt
+`
+
+func TestSpinlock(t *testing.T) {
+	for _, seed := range []int64{0, 1, 2, 3} {
+		m := NewMachine()
+		m.Load(emutest.Image(t, spinlock))
+		m.StartAll(m.CPU.R[emu.PC])
+		if seed != 0 {
+			m.Rand = rand.New(rand.NewSource(seed))
+		}
+		if err := m.Run(100000); err != nil {
+			t.Fatalf("seed %d: unexpected error running: %v", seed, err)
+		}
+		if count := m.CPU.Memory.Read32(0x9004); count != 200 {
+			t.Errorf("seed %d: expected every increment to count, got %d", seed, count)
+		}
+	}
+
+	// Core 1's store lands between core 0's LDREX and STREX, so the STREX fails and leaves it be
+	m := NewMachine()
+	m.Load(emutest.Image(t, "MRC R0, MPIDR\nANDS R0, R0, #3\nMOVW R4, #0x9000\nBNE other\nLDREX R1, [R4]\nNOP\nMOVW R0, #7\nSTREX R1, R0, [R4]\nend: B end\nother:\nSTR R0, [R4]\nhalt: B halt"))
+	m.Start(1, m.CPU.R[emu.PC])
+	if err := m.Run(1000); err != nil {
+		t.Fatalf("unexpected error running: %v", err)
+	}
+	if m.CPU.R[1] != 1 || m.CPU.Memory.Read32(0x9000) != 1 {
+		t.Errorf("expected STREX to fail and core 1's store to stay, got status %d and 0x%X", m.CPU.R[1], m.CPU.Memory.Read32(0x9000))
+	}
+}
+
+func TestCoreFault(t *testing.T) {
+	m := NewMachine()
+	m.Load(emutest.Image(t, "MRC R0, MPIDR\nANDS R0, R0, #2\nBNE fault\nend: B end\nfault: .word 0xFFFFFFFF"))
+	m.StartAll(m.CPU.R[emu.PC])
+	err := m.Run(1000)
+	var coreErr *CoreError
+	var fault *emu.Fault
+	if !errors.As(err, &coreErr) || coreErr.Core != 2 || !errors.As(err, &fault) {
+		t.Fatalf("expected core 2 to fault, got %v", err)
+	}
+	if !m.Cores[1].Halted || m.Cores[3].Halted || m.Cores[3].Steps != 3 {
+		t.Errorf("expected core 1 to have halted and core 3 to be yet to run, got %v and %v after %d steps", m.Cores[1].Halted, m.Cores[3].Halted, m.Cores[3].Steps)
+	}
+
+	if err := m.Run(1000); err == nil {
+		t.Errorf("expected to fault again")
+	}
+
+	m = NewMachine()
+	m.Load(emutest.Image(t, ".word 0xFFFFFFFF"))
+	if err := m.Run(1000); !errors.As(err, &fault) || errors.As(err, &coreErr) {
+		t.Errorf("expected a fault on its own with only core 0 started, got %v", err)
+	}
+}
+
+func TestHello(t *testing.T) {
+	source, err := os.ReadFile("../hello.asm")
+	if err != nil {
+		t.Fatalf("unexpected error reading hello.asm: %v", err)
+	}
+	m := NewMachine()
+	m.Load(emutest.Image(t, string(source)))
+	if err := m.Run(10000); err != nil {
+		t.Fatalf("unexpected error running: %v", err)
+	}
+	if got := string(m.Terminal.Sent()); got != "Hello, world!\n" {
+		t.Errorf("expected %q, got %q", "Hello, world!\n", got)
+	}
+}
+
+// Echo programs, each byte read is written back
+const (
+	pl011Echo = `MOV32 R4, #0x3F201000
+MOVW R0, #0x0301
+ADD R1, R4, #0x30
+STR R0, [R1]
+ADD R5, R4, #0x18
+loop:
+LDR R1, [R5]
+ANDS R1, R1, #0x10
+BNE loop
+LDR R0, [R4]
+wait:
+LDR R1, [R5]
+ANDS R1, R1, #0x20
+BNE wait
+STR R0, [R4]
+B loop`
+	miniUARTEcho = `MOV32 R4, #0x3F215000
+MOVW R0, #1
+ADD R1, R4, #0x4
+STR R0, [R1]
+ADD R5, R4, #0x54
+ADD R6, R4, #0x40
+loop:
+LDR R1, [R5]
+ANDS R1, R1, #0x1
+BEQ loop
+LDR R0, [R6]
+STR R0, [R6]
+B loop`
+)
+
+func TestUART(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		input  string
+	}{
+		{name: "PL011 echo", source: pl011Echo, input: "echo this\r\n"},
+		{name: "mini UART echo", source: miniUARTEcho, input: "and this\n"},
+		{name: "nothing typed", source: pl011Echo},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := NewMachine()
+			m.Load(emutest.Image(t, c.source))
+			m.Terminal.Type([]byte(c.input))
+			if err := m.Run(10000); err != emu.ErrStepLimit {
+				t.Fatalf("expected the echo loop to keep running, got %v", err)
+			}
+			if got := string(m.Terminal.Sent()); got != c.input {
+				t.Errorf("expected %q echoed, got %q", c.input, got)
+			}
+		})
+	}
+}
+
+func TestPL011Flags(t *testing.T) {
+	terminal := NewTerminal(nil)
+	u := NewPL011(terminal)
+	if got := u.Read32(UARTFR); got != UARTFRRXFE|UARTFRTXFE {
+		t.Errorf("expected empty FIFOs, got 0x%02X", got)
+	}
+
+	// Disabled, so nothing goes out and the single byte holding register fills up
+	u.Write32(UARTDR, 'a')
+	u.Write32(UARTDR, 'b')
+	if got := u.Read32(UARTFR); got != UARTFRRXFE|UARTFRTXFF {
+		t.Errorf("expected a full transmit FIFO, got 0x%02X", got)
+	}
+	u.Write32(UARTLCRH, UARTLCRHFEN)
+	u.Write32(UARTDR, 'b')
+	u.Write32(UARTCR, UARTCREnable|UARTCRTXE|UARTCRRXE)
+	if got := string(terminal.Sent()); got != "ab" {
+		t.Errorf("expected the FIFO to drain once enabled, got %q", got)
+	}
+
+	terminal.Type([]byte("0123456789abcdefXYZ"))
+	if got := u.Read32(UARTFR); got != UARTFRRXFF|UARTFRTXFE {
+		t.Errorf("expected a full receive FIFO, got 0x%02X", got)
+	}
+	u.Write32(UARTIMSC, UARTIntRX)
+	if !u.Pending() || u.Read32(UARTRIS) != UARTIntRX|UARTIntTX {
+		t.Errorf("expected receive and transmit interrupts, got 0x%02X", u.Read32(UARTRIS))
+	}
+	var received []byte
+	for u.Read32(UARTFR)&UARTFRRXFE == 0 {
+		received = append(received, byte(u.Read32(UARTDR)))
+	}
+	if string(received) != "0123456789abcdefXYZ" || u.Pending() {
+		t.Errorf("expected to read everything typed, got %q", received)
+	}
+}
+
+func TestMiniUARTRegisters(t *testing.T) {
+	terminal := NewTerminal(nil)
+	u := NewMiniUART(terminal)
+	u.Write32(AUXMUIO, 'x')
+	if u.Read32(AUXMULSR) != 0 || len(terminal.Sent()) != 0 {
+		t.Errorf("expected the mini UART to do nothing until enabled")
+	}
+
+	u.Write32(AUXENABLES, 1)
+	if got := u.Read32(AUXMULSR); got != AUXMULSRTXEmpty|AUXMULSRTXIdle {
+		t.Errorf("expected an idle transmitter, got 0x%02X", got)
+	}
+	u.Write32(AUXMULCR, AUXMULCRDLAB|3)
+	u.Write32(AUXMUIO, 0x0E)
+	u.Write32(AUXMUIER, 0x01)
+	u.Write32(AUXMULCR, 3)
+	if got := u.Read32(AUXMUBAUD); got != 270 {
+		t.Errorf("expected baud 270 set through DLAB, got %d", got)
+	}
+
+	terminal.Type([]byte("hi"))
+	if got := u.Read32(AUXMUIIR); got != 0xC1 || u.Pending() {
+		t.Errorf("expected no interrupts before enabling them, got 0x%02X", got)
+	}
+	u.Write32(AUXMUIER, AUXMUIERRX)
+	if got := u.Read32(AUXMUIIR); got != 0xC4 || u.Read32(AUXIRQ) != 1 {
+		t.Errorf("expected a receive interrupt, got 0x%02X", got)
+	}
+	if got := u.Read32(AUXMUSTAT) >> 16 & 0xF; got != 2 {
+		t.Errorf("expected 2 bytes in the receive FIFO, got %d", got)
+	}
+	u.Write32(AUXMUIIR, 0b110)
+	if u.Read32(AUXMULSR)&AUXMULSRDataReady != 0 {
+		t.Errorf("expected clearing the FIFOs to drop what was received")
+	}
+}
@@ -0,0 +1,138 @@
+package bcm
+
+import (
+	"fmt"
+
+	"github.com/robertjshirts/rogasmic/emu"
+)
+
+// DefaultSkew is how far ahead of the core furthest behind the random scheduler lets the others get.
+const DefaultSkew = 16
+
+// CoreError is a fault on one of several running cores.
+type CoreError struct {
+	Core int
+	Err  error
+}
+
+func (e *CoreError) Error() string {
+	return fmt.Sprintf("core %d: %v", e.Core, e.Err)
+}
+
+func (e *CoreError) Unwrap() error {
+	return e.Err
+}
+
+// Start points a core's PC at address and has it run along with the others.
+func (m *Machine) Start(core int, address uint32) {
+	m.Cores[core].R[emu.PC] = address
+	m.started[core] = true
+}
+
+// StartAll starts every core at address, the way the firmware starts them all at the kernel's entry point.
+func (m *Machine) StartAll(address uint32) {
+	for n := range m.Cores {
+		m.Start(n, address)
+	}
+}
+
+// Started reports whether a core runs. Core 0 always does.
+func (m *Machine) Started(core int) bool {
+	return m.started[core]
+}
+
+// running returns the started cores that haven't halted.
+func (m *Machine) running() []*emu.CPU {
+	var running []*emu.CPU
+	for n, cpu := range m.Cores {
+		if m.started[n] && !cpu.Halted {
+			running = append(running, cpu)
+		}
+	}
+	return running
+}
+
+// Next picks the core to execute the next instruction and moves the clock to it, or returns nil if every started
+// core has halted. It's the one that's executed the fewest instructions, the lowest numbered on a tie, so cores
+// take turns an instruction at a time. With Rand set it's any of the ones less than Skew instructions ahead of that.
+func (m *Machine) Next() *emu.CPU {
+	running := m.running()
+	if len(running) == 0 {
+		return nil
+	}
+	behind := running[0]
+	for _, cpu := range running {
+		if cpu.Steps < behind.Steps {
+			behind = cpu
+		}
+	}
+	if m.Rand != nil {
+		var near []*emu.CPU
+		for _, cpu := range running {
+			if cpu.Steps-behind.Steps < max(m.Skew, 1) {
+				near = append(near, cpu)
+			}
+		}
+		behind = near[m.Rand.Intn(len(near))]
+	}
+	m.current = behind
+	return behind
+}
+
+// Run runs the started cores until they've all halted, one of them faults or one has executed limit more
+// instructions. A limit of 0 means no limit. It returns nil once they've all halted, emu.ErrStepLimit when a core
+// reaches the limit and otherwise the fault, as a *CoreError when more than one core was started. A single core runs
+// exactly like emu.CPU.Run. Several take turns in the order Next picks them, a core running for as long as Next
+// would keep picking it.
+func (m *Machine) Run(limit uint64) error {
+	start := make([]uint64, len(m.Cores))
+	started := 0
+	for n, cpu := range m.Cores {
+		start[n] = cpu.Steps
+		if m.started[n] {
+			started++
+		}
+	}
+
+	for {
+		cpu := m.Next()
+		if cpu == nil {
+			return nil
+		}
+		turn := uint64(0)
+		if limit != 0 {
+			done := cpu.Steps - start[cpu.ID]
+			if done >= limit {
+				return emu.ErrStepLimit
+			}
+			turn = limit - done
+		}
+		for _, other := range m.running() {
+			if other == cpu {
+				continue
+			}
+			// Run until Next would pick the other core, or a single instruction when picking at random
+			catchUp := uint64(1)
+			if m.Rand == nil {
+				catchUp = other.Steps - cpu.Steps
+				if other.ID > cpu.ID {
+					catchUp++
+				}
+			}
+			if turn == 0 || catchUp < turn {
+				turn = catchUp
+			}
+		}
+
+		err := cpu.Run(turn)
+		if err == emu.ErrStepLimit {
+			continue
+		}
+		if err != nil && started > 1 {
+			return &CoreError{Core: int(cpu.ID), Err: err}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
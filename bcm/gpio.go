@@ -0,0 +1,168 @@
+package bcm
+
+import "fmt"
+
+// GPIO register offsets
+const (
+	GPFSEL0 = 0x00 // Function select, 3 bits per pin, 10 pins per register up to GPFSEL5
+	GPSET0  = 0x1C // Write 1s to set output pins 0-31
+	GPSET1  = 0x20 // Write 1s to set output pins 32-53
+	GPCLR0  = 0x28 // Write 1s to clear output pins 0-31
+	GPCLR1  = 0x2C // Write 1s to clear output pins 32-53
+	GPLEV0  = 0x34 // Levels of pins 0-31
+	GPLEV1  = 0x38 // Levels of pins 32-53
+)
+
+// Pins is the number of GPIO pins on the BCM2836 and BCM2837.
+const Pins = 54
+
+// Function is what a pin has been selected to do.
+type Function uint32
+
+const (
+	FunctionInput  Function = 0b000
+	FunctionOutput Function = 0b001
+	FunctionAlt0   Function = 0b100
+	FunctionAlt1   Function = 0b101
+	FunctionAlt2   Function = 0b110
+	FunctionAlt3   Function = 0b111
+	FunctionAlt4   Function = 0b011
+	FunctionAlt5   Function = 0b010
+)
+
+var functionNames = map[Function]string{
+	FunctionInput:  "input",
+	FunctionOutput: "output",
+	FunctionAlt0:   "alt0",
+	FunctionAlt1:   "alt1",
+	FunctionAlt2:   "alt2",
+	FunctionAlt3:   "alt3",
+	FunctionAlt4:   "alt4",
+	FunctionAlt5:   "alt5",
+}
+
+func (f Function) String() string {
+	return functionNames[f&0b111]
+}
+
+// Transition is a pin changing level.
+type Transition struct {
+	Time  uint64 // Clock when it happened
+	Pin   int
+	Level bool
+}
+
+func (t Transition) String() string {
+	level := "low"
+	if t.Level {
+		level = "high"
+	}
+	return fmt.Sprintf("%d: GPIO%d %s", t.Time, t.Pin, level)
+}
+
+// GPIO models the function select, set, clear and level registers of the GPIO block. Output pins follow the latch
+// written through GPSET and GPCLR, every other pin reads whatever is driven onto it with SetInput. Every change in level
+// is recorded in the trace. The remaining registers (events, pulls) just hold what was written to them.
+type GPIO struct {
+	clock  func() uint64
+	fsel   [6]uint32
+	latch  uint64
+	input  uint64
+	level  uint64
+	others map[uint32]uint32
+	trace  []Transition
+}
+
+// NewGPIO creates a GPIO block with every pin an input, timestamping transitions with clock.
+func NewGPIO(clock func() uint64) *GPIO {
+	return &GPIO{clock: clock, others: make(map[uint32]uint32)}
+}
+
+func (g *GPIO) Read32(offset uint32) uint32 {
+	switch {
+	case offset <= GPFSEL0+5*4:
+		return g.fsel[offset/4]
+	case offset == GPSET0, offset == GPSET1, offset == GPCLR0, offset == GPCLR1:
+		return 0 // Write only
+	case offset == GPLEV0:
+		return uint32(g.level)
+	case offset == GPLEV1:
+		return uint32(g.level >> 32)
+	default:
+		return g.others[offset]
+	}
+}
+
+func (g *GPIO) Write32(offset uint32, value uint32) {
+	switch {
+	case offset <= GPFSEL0+5*4:
+		g.fsel[offset/4] = value
+	case offset == GPSET0:
+		g.latch |= uint64(value)
+	case offset == GPSET1:
+		g.latch |= uint64(value) << 32
+	case offset == GPCLR0:
+		g.latch &^= uint64(value)
+	case offset == GPCLR1:
+		g.latch &^= uint64(value) << 32
+	case offset == GPLEV0, offset == GPLEV1:
+		return // Read only
+	default:
+		g.others[offset] = value
+	}
+	g.update()
+}
+
+// Function returns what pin has been selected to do.
+func (g *GPIO) Function(pin int) Function {
+	return Function(g.fsel[pin/10] >> (pin % 10 * 3) & 0b111)
+}
+
+// Level returns the current level of pin.
+func (g *GPIO) Level(pin int) bool {
+	return g.level&(1<<pin) != 0
+}
+
+// SetInput drives a level onto pin from outside. It only shows up while the pin isn't an output.
+func (g *GPIO) SetInput(pin int, level bool) {
+	if level {
+		g.input |= 1 << pin
+	} else {
+		g.input &^= 1 << pin
+	}
+	g.update()
+}
+
+// Trace returns every level change so far, oldest first.
+func (g *GPIO) Trace() []Transition {
+	return g.trace
+}
+
+// PinTrace returns the level changes of a single pin.
+func (g *GPIO) PinTrace(pin int) []Transition {
+	var out []Transition
+	for _, t := range g.trace {
+		if t.Pin == pin {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// update recomputes the pin levels and records any that changed.
+func (g *GPIO) update() {
+	var outputs uint64
+	for pin := 0; pin < Pins; pin++ {
+		if g.Function(pin) == FunctionOutput {
+			outputs |= 1 << pin
+		}
+	}
+	level := g.latch&outputs | g.input&^outputs
+	changed := level ^ g.level
+	g.level = level
+	for pin := 0; pin < Pins; pin++ {
+		if changed&(1<<pin) != 0 {
+			g.trace = append(g.trace, Transition{Time: g.clock(), Pin: pin, Level: level&(1<<pin) != 0})
+		}
+	}
+}
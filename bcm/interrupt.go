@@ -0,0 +1,122 @@
+package bcm
+
+// Interrupt controller register offsets
+const (
+	IRQBasicPending  = 0x00
+	IRQPending1      = 0x04 // GPU interrupts 0-31
+	IRQPending2      = 0x08 // GPU interrupts 32-63
+	FIQControl       = 0x0C // Source in bits 0-6, enable in bit 7
+	EnableIRQs1      = 0x10 // Write 1s to enable
+	EnableIRQs2      = 0x14
+	EnableBasicIRQs  = 0x18
+	DisableIRQs1     = 0x1C // Write 1s to disable
+	DisableIRQs2     = 0x20
+	DisableBasicIRQs = 0x24
+)
+
+// Interrupt numbers, as used by Connect and the FIQ source. 0-63 are the GPU interrupts, 64 up the basic ones.
+const (
+	IRQSystemTimer0 = 0 // Compare register n of the system timer is IRQSystemTimer0+n
+	IRQAux          = 29
+	IRQUART         = 57
+	IRQARMTimer     = 64
+)
+
+// FIQControlEnable routes the interrupt in the bottom bits of FIQ control to the FIQ.
+const FIQControlEnable = 1 << 7
+
+// basicShortcuts are the GPU interrupts that also show up in bits 10-20 of the basic pending register, and don't set
+// its bits 8 and 9 saying there's more in the other pending registers.
+var basicShortcuts = []int{7, 9, 10, 18, 19, 53, 54, 55, 56, 57, 62}
+
+// InterruptController is the ARM interrupt controller. Sources connected to it raise their interrupt, the enable
+// registers decide which of them get through to the IRQ line, and one of them can be picked to go to the FIQ line
+// instead.
+type InterruptController struct {
+	sources    []source
+	enabled    [3]uint32 // Enable registers 1 and 2, then basic
+	fiqControl uint32
+}
+
+type source struct {
+	irq     int
+	pending func() bool
+}
+
+// NewInterruptController creates an interrupt controller with nothing connected and everything disabled.
+func NewInterruptController() *InterruptController {
+	return &InterruptController{}
+}
+
+// Connect makes pending the source of an interrupt, polled whenever the controller needs to know if it's raised.
+func (ic *InterruptController) Connect(irq int, pending func() bool) {
+	ic.sources = append(ic.sources, source{irq: irq, pending: pending})
+}
+
+// pending returns the raised interrupts that are enabled, in the same layout as the enable registers. Only the
+// enabled sources are polled.
+func (ic *InterruptController) pending() [3]uint32 {
+	var pending [3]uint32
+	for _, s := range ic.sources {
+		bit := uint32(1) << (s.irq % 32)
+		if ic.enabled[s.irq/32]&bit != 0 && s.pending() {
+			pending[s.irq/32] |= bit
+		}
+	}
+	return pending
+}
+
+func (ic *InterruptController) Read32(offset uint32) uint32 {
+	switch offset {
+	case IRQBasicPending:
+		pending := ic.pending()
+		basic := pending[2] & 0xFF
+		for i, irq := range basicShortcuts {
+			if pending[irq/32]&(1<<(irq%32)) != 0 {
+				basic |= 1 << (10 + i)
+				pending[irq/32] &^= 1 << (irq % 32)
+			}
+		}
+		basic |= boolBit(pending[0] != 0)<<8 | boolBit(pending[1] != 0)<<9
+		return basic
+	case IRQPending1, IRQPending2:
+		return ic.pending()[(offset-IRQPending1)/4]
+	case FIQControl:
+		return ic.fiqControl
+	case EnableIRQs1, EnableIRQs2, EnableBasicIRQs, DisableIRQs1, DisableIRQs2, DisableBasicIRQs:
+		return ic.enabled[(offset-EnableIRQs1)/4%3]
+	default:
+		return 0
+	}
+}
+
+func (ic *InterruptController) Write32(offset uint32, value uint32) {
+	switch offset {
+	case FIQControl:
+		ic.fiqControl = value & 0xFF
+	case EnableIRQs1, EnableIRQs2, EnableBasicIRQs:
+		ic.enabled[(offset-EnableIRQs1)/4] |= value
+	case DisableIRQs1, DisableIRQs2, DisableBasicIRQs:
+		ic.enabled[(offset-DisableIRQs1)/4] &^= value
+	}
+	ic.enabled[2] &= 0xFF
+}
+
+// IRQ reports whether an enabled interrupt is raised.
+func (ic *InterruptController) IRQ() bool {
+	return ic.pending() != [3]uint32{}
+}
+
+// FIQ reports whether the interrupt picked for the FIQ is raised.
+func (ic *InterruptController) FIQ() bool {
+	if ic.fiqControl&FIQControlEnable == 0 {
+		return false
+	}
+	irq := int(ic.fiqControl &^ FIQControlEnable)
+	for _, s := range ic.sources {
+		if s.irq == irq && s.pending() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package bcm
+
+import "math/bits"
+
+// LocalBase is where the BCM2836 and BCM2837 map the ARM local peripherals, the ones each core has its own copy of.
+const LocalBase = 0x40000000
+
+// Clocks the local peripherals count
+const (
+	CrystalClock    = 19200000 // Core timer, with a prescaler of 0x80000000
+	LocalTimerClock = 38400000
+)
+
+// Cores is how many cores the local peripherals route interrupts to.
+const Cores = 4
+
+// Local peripheral register offsets. The per-core registers are 4 bytes apart, starting with core 0.
+const (
+	LocalControl        = 0x00 // Core timer clock source and increment
+	LocalPrescaler      = 0x08 // Core timer counts prescaler/2^31 per clock tick
+	LocalGPURouting     = 0x0C // Core the GPU IRQ goes to in bits 0-1, the GPU FIQ in bits 2-3
+	LocalCoreTimerLS    = 0x1C // Reading latches the top half, writing waits for it
+	LocalCoreTimerMS    = 0x20
+	LocalTimerRouting   = 0x24 // 0-3 for core n's IRQ, 4-7 for core n-4's FIQ
+	LocalTimerControl   = 0x34 // Reload value in bits 0-27, then enable, interrupt enable and the interrupt flag
+	LocalTimerClear     = 0x38 // Write to clear the interrupt flag and reload
+	LocalCoreTimerIRQ   = 0x40 // Core timer interrupt control per core
+	LocalMailboxIRQ     = 0x50 // Mailbox interrupt control per core, IRQ for mailbox n in bit n, FIQ in bit n+4
+	LocalIRQSource      = 0x60 // Interrupt sources per core
+	LocalFIQSource      = 0x70
+	LocalMailboxSet     = 0x80 // Mailbox m of core n at 0x10*n+4*m, write 1s to set bits
+	LocalMailboxClear   = 0xC0 // Mailbox m of core n at 0x10*n+4*m, read it or write 1s to clear bits
+	localPeripheralSize = 0x100
+)
+
+// Local control bits
+const (
+	LocalControlAPB       = 1 << 8 // Core timer counts the APB clock instead of the crystal
+	LocalControlIncrement = 1 << 9 // Core timer counts up by 2
+)
+
+// Local timer control bits
+const (
+	LocalTimerReload    = 0x0FFFFFFF
+	LocalTimerEnable    = 1 << 28
+	LocalTimerIRQEnable = 1 << 29
+	LocalTimerFlag      = 1 << 31 // Also the bit that clears it in LocalTimerClear
+	LocalTimerReloadNow = 1 << 30 // In LocalTimerClear
+)
+
+// Interrupt source bits
+const (
+	SourceCoreTimer  = 1 << 0 // 4 bits, one per timer
+	SourceMailbox    = 1 << 4 // 4 bits, one per mailbox
+	SourceGPU        = 1 << 8
+	SourceLocalTimer = 1 << 11
+)
+
+// LocalPeripherals are the core timer, local timer and mailboxes, and the routing of their interrupts and the GPU's
+// to each core's IRQ and FIQ lines. The core timer interrupt control registers hold what's written to them, there's
+// no generic timer driving them.
+type LocalPeripherals struct {
+	clock func(hz uint64) uint64 // Ticks of a clock running at hz
+	gpu   *InterruptController
+
+	control      uint32
+	prescaler    uint32
+	gpuRouting   uint32
+	counter      uint64
+	counterFrac  uint64 // Fraction of a count left over, out of 2^31
+	counterLast  uint64 // Clock tick the counter is up to date with
+	latchedMS    uint32
+	writtenLS    uint32
+	timerRouting uint32
+	coreTimerIRQ [Cores]uint32
+	mailboxIRQ   [Cores]uint32
+	mailbox      [Cores][4]uint32
+
+	timerControl uint32
+	timerFlag    bool
+	timerValue   uint32 // Count left until the local timer fires
+	timerLast    uint64 // Local timer tick the count is up to date with
+}
+
+// NewLocalPeripherals creates the local peripherals the way the firmware leaves them: the core timer counting the
+// crystal and everything routed to core 0. The GPU interrupts come from gpu.
+func NewLocalPeripherals(clock func(hz uint64) uint64, gpu *InterruptController) *LocalPeripherals {
+	return &LocalPeripherals{clock: clock, gpu: gpu, prescaler: 0x80000000}
+}
+
+func (l *LocalPeripherals) Read32(offset uint32) uint32 {
+	l.update()
+	switch {
+	case offset == LocalControl:
+		return l.control
+	case offset == LocalPrescaler:
+		return l.prescaler
+	case offset == LocalGPURouting:
+		return l.gpuRouting
+	case offset == LocalCoreTimerLS:
+		l.latchedMS = uint32(l.counter >> 32)
+		return uint32(l.counter)
+	case offset == LocalCoreTimerMS:
+		return l.latchedMS
+	case offset == LocalTimerRouting:
+		return l.timerRouting
+	case offset == LocalTimerControl:
+		return l.timerControl | boolBit(l.timerFlag)<<31
+	case offset >= LocalCoreTimerIRQ && offset < LocalCoreTimerIRQ+4*Cores:
+		return l.coreTimerIRQ[(offset-LocalCoreTimerIRQ)/4]
+	case offset >= LocalMailboxIRQ && offset < LocalMailboxIRQ+4*Cores:
+		return l.mailboxIRQ[(offset-LocalMailboxIRQ)/4]
+	case offset >= LocalIRQSource && offset < LocalIRQSource+4*Cores:
+		irq, _ := l.sources(int(offset-LocalIRQSource) / 4)
+		return irq
+	case offset >= LocalFIQSource && offset < LocalFIQSource+4*Cores:
+		_, fiq := l.sources(int(offset-LocalFIQSource) / 4)
+		return fiq
+	case offset >= LocalMailboxClear && offset < LocalMailboxClear+0x10*Cores:
+		n := (offset - LocalMailboxClear) / 4
+		return l.mailbox[n/4][n%4]
+	default:
+		return 0
+	}
+}
+
+func (l *LocalPeripherals) Write32(offset uint32, value uint32) {
+	l.update()
+	switch {
+	case offset == LocalControl:
+		l.control = value & (LocalControlAPB | LocalControlIncrement)
+		l.counterLast = l.clock(l.counterClock())
+	case offset == LocalPrescaler:
+		l.prescaler = value
+	case offset == LocalGPURouting:
+		l.gpuRouting = value & 0xF
+	case offset == LocalCoreTimerLS:
+		l.writtenLS = value
+	case offset == LocalCoreTimerMS:
+		l.counter = uint64(value)<<32 | uint64(l.writtenLS)
+		l.counterFrac = 0
+	case offset == LocalTimerRouting:
+		l.timerRouting = value & 7
+	case offset == LocalTimerControl:
+		if l.timerControl&LocalTimerEnable == 0 {
+			l.timerValue = value & LocalTimerReload
+		}
+		l.timerControl = value & (LocalTimerReload | LocalTimerEnable | LocalTimerIRQEnable)
+	case offset == LocalTimerClear:
+		if value&LocalTimerFlag != 0 {
+			l.timerFlag = false
+		}
+		if value&LocalTimerReloadNow != 0 {
+			l.timerValue = l.timerControl & LocalTimerReload
+		}
+	case offset >= LocalCoreTimerIRQ && offset < LocalCoreTimerIRQ+4*Cores:
+		l.coreTimerIRQ[(offset-LocalCoreTimerIRQ)/4] = value & 0xFF
+	case offset >= LocalMailboxIRQ && offset < LocalMailboxIRQ+4*Cores:
+		l.mailboxIRQ[(offset-LocalMailboxIRQ)/4] = value & 0xFF
+	case offset >= LocalMailboxSet && offset < LocalMailboxSet+0x10*Cores:
+		n := (offset - LocalMailboxSet) / 4
+		l.mailbox[n/4][n%4] |= value
+	case offset >= LocalMailboxClear && offset < LocalMailboxClear+0x10*Cores:
+		n := (offset - LocalMailboxClear) / 4
+		l.mailbox[n/4][n%4] &^= value
+	}
+}
+
+// Mailbox returns the contents of one of a core's 4 mailboxes.
+func (l *LocalPeripherals) Mailbox(core int, n int) uint32 {
+	return l.mailbox[core][n]
+}
+
+// Lines reports whether a core's IRQ and FIQ lines are raised.
+func (l *LocalPeripherals) Lines(core int) (irq bool, fiq bool) {
+	l.update()
+	irqSources, fiqSources := l.sources(core)
+	return irqSources != 0, fiqSources != 0
+}
+
+// sources returns the interrupt sources raising a core's IRQ and FIQ, in the layout of the source registers.
+func (l *LocalPeripherals) sources(core int) (irq uint32, fiq uint32) {
+	// A source enabled for both goes to the FIQ
+	route := func(control uint32, n int, bit uint32) {
+		switch {
+		case control&(1<<(n+4)) != 0:
+			fiq |= bit
+		case control&(1<<n) != 0:
+			irq |= bit
+		}
+	}
+	for n, value := range l.mailbox[core] {
+		if value != 0 {
+			route(l.mailboxIRQ[core], n, SourceMailbox<<n)
+		}
+	}
+	if l.gpuRouting&3 == uint32(core) && l.gpu.IRQ() {
+		irq |= SourceGPU
+	}
+	if l.gpuRouting>>2 == uint32(core) && l.gpu.FIQ() {
+		fiq |= SourceGPU
+	}
+	if l.timerFlag && l.timerControl&LocalTimerIRQEnable != 0 {
+		switch l.timerRouting {
+		case uint32(core):
+			irq |= SourceLocalTimer
+		case uint32(core) + 4:
+			fiq |= SourceLocalTimer
+		}
+	}
+	return irq, fiq
+}
+
+// NextTimerIRQ returns the local timer tick at which the local timer next sets its interrupt flag, reporting false if
+// it's stopped or the flag is already set.
+func (l *LocalPeripherals) NextTimerIRQ() (uint64, bool) {
+	l.update()
+	if l.timerFlag || l.timerControl&LocalTimerEnable == 0 || l.timerControl&LocalTimerReload == 0 {
+		return 0, false
+	}
+	return l.timerLast + uint64(l.timerValue), true
+}
+
+func (l *LocalPeripherals) counterClock() uint64 {
+	if l.control&LocalControlAPB != 0 {
+		return APBClock
+	}
+	return CrystalClock
+}
+
+// update brings the core timer and the local timer up to date with the clock.
+func (l *LocalPeripherals) update() {
+	now := l.clock(l.counterClock())
+	hi, lo := bits.Mul64(now-l.counterLast, uint64(l.prescaler))
+	lo, carry := bits.Add64(lo, l.counterFrac, 0)
+	counts := (hi+carry)<<33 | lo>>31
+	if l.control&LocalControlIncrement != 0 {
+		counts *= 2
+	}
+	l.counter += counts
+	l.counterFrac = lo & (1<<31 - 1)
+	l.counterLast = now
+
+	now = l.clock(LocalTimerClock)
+	elapsed := now - l.timerLast
+	l.timerLast = now
+	reload := uint64(l.timerControl & LocalTimerReload)
+	if l.timerControl&LocalTimerEnable == 0 || reload == 0 {
+		return
+	}
+	// Reaching 0 sets the flag and reloads straight away
+	if elapsed < uint64(l.timerValue) {
+		l.timerValue -= uint32(elapsed)
+		return
+	}
+	l.timerFlag = true
+	elapsed -= uint64(l.timerValue)
+	l.timerValue = uint32(reload - elapsed%reload)
+}
@@ -0,0 +1,158 @@
+package bcm
+
+import (
+	"math/bits"
+	"math/rand"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/linker"
+)
+
+// PeripheralBase is where the BCM2836 and BCM2837 map their peripherals for the ARM cores.
+const PeripheralBase = 0x3F000000
+
+// Peripheral addresses
+const (
+	SystemTimerBase = PeripheralBase + 0x3000
+	InterruptBase   = PeripheralBase + 0xB200
+	ARMTimerBase    = PeripheralBase + 0xB400
+	GPIOBase        = PeripheralBase + 0x200000
+	UARTBase        = PeripheralBase + 0x201000
+	AUXBase         = PeripheralBase + 0x215000
+)
+
+// Clock rates. The CPU runs one instruction per cycle.
+const (
+	DefaultCPUClock  = 1200000000 // Raspberry Pi 3 ARM clock
+	APBClock         = 250000000  // Clock the ARM timer counts
+	SystemTimerClock = 1000000    // The system timer counts microseconds
+)
+
+// Machine is the 4 cores of a Raspberry Pi 2/3 with its peripherals mapped in. Only core 0 runs unless the others are
+// started. Its clock counts executed instructions, so runs are deterministic. The peripherals' interrupts reach the
+// cores through the interrupt controller and the local peripherals, which route them all to core 0 until told
+// otherwise.
+type Machine struct {
+	CPU         *emu.CPU   // Core 0
+	Cores       []*emu.CPU // Every core, sharing memory
+	CPUClock    uint64     // Instructions per second, the peripheral clocks are derived from it
+	GPIO        *GPIO
+	SystemTimer *SystemTimer
+	ARMTimer    *ARMTimer
+	Interrupts  *InterruptController
+	Local       *LocalPeripherals
+	Terminal    *Terminal // What's connected to both UARTs
+	UART        *PL011
+	MiniUART    *MiniUART
+
+	// Rand, if set, has the scheduler pick at random between the cores less than Skew instructions ahead of the one
+	// furthest behind, rather than always running the one furthest behind. Seeding it makes the interleaving
+	// reproducible.
+	Rand *rand.Rand
+	Skew uint64
+
+	started []bool
+	current *emu.CPU // Core being run, whose time the clock is at
+	time    uint64
+}
+
+func NewMachine() *Machine {
+	memory := emu.NewMemory()
+	m := &Machine{CPUClock: DefaultCPUClock, Skew: DefaultSkew, started: make([]bool, Cores)}
+	for n := 0; n < Cores; n++ {
+		cpu := emu.New(memory)
+		cpu.ID = uint32(n)
+		m.Cores = append(m.Cores, cpu)
+	}
+	m.CPU, m.current = m.Cores[0], m.Cores[0]
+	m.started[0] = true
+	m.GPIO = NewGPIO(m.Now)
+	m.SystemTimer = NewSystemTimer(func() uint64 { return m.Ticks(SystemTimerClock) })
+	m.ARMTimer = NewARMTimer(func() uint64 { return m.Ticks(APBClock) })
+	memory.Map(SystemTimerBase, 0x1C, m.SystemTimer)
+	memory.Map(ARMTimerBase, 0x24, m.ARMTimer)
+	m.Terminal = NewTerminal(nil)
+	m.UART = NewPL011(m.Terminal)
+	m.MiniUART = NewMiniUART(m.Terminal)
+	memory.Map(GPIOBase, 0x100, m.GPIO)
+	memory.Map(UARTBase, 0x90, m.UART)
+	memory.Map(AUXBase, 0x80, m.MiniUART)
+
+	m.Interrupts = NewInterruptController()
+	for n := 0; n < 4; n++ {
+		m.Interrupts.Connect(IRQSystemTimer0+n, func() bool { return m.SystemTimer.Matched(n) })
+	}
+	m.Interrupts.Connect(IRQAux, m.MiniUART.Pending)
+	m.Interrupts.Connect(IRQUART, m.UART.Pending)
+	m.Interrupts.Connect(IRQARMTimer, m.ARMTimer.Pending)
+	m.Local = NewLocalPeripherals(m.Ticks, m.Interrupts)
+	memory.Map(InterruptBase, 0x28, m.Interrupts)
+	memory.Map(LocalBase, localPeripheralSize, m.Local)
+	memory.MapAbort(PeripheralBase+0x1000000, 0xC0000000) // Nothing answers above the peripherals
+	for n, cpu := range m.Cores {
+		cpu.InterruptLines = func() (bool, bool) { return m.Local.Lines(n) }
+		cpu.NextInterrupt = m.NextInterrupt
+		cpu.SendEvent = m.SendEvent
+	}
+	return m
+}
+
+// SendEvent sets the event register of every core, as SEV does, waking any of them waiting in WFE.
+func (m *Machine) SendEvent() {
+	for _, cpu := range m.Cores {
+		cpu.Event = true
+	}
+}
+
+// Now is the current time on the machine's clock, the number of instructions executed so far by the core that's run
+// the furthest.
+func (m *Machine) Now() uint64 {
+	m.time = max(m.time, m.current.Steps)
+	return m.time
+}
+
+// Ticks converts the instructions executed so far into ticks of a clock running at hz.
+func (m *Machine) Ticks(hz uint64) uint64 {
+	hi, lo := bits.Mul64(m.Now(), hz)
+	if hi >= m.CPUClock {
+		return ^uint64(0)
+	}
+	ticks, _ := bits.Div64(hi, lo, m.CPUClock)
+	return ticks
+}
+
+// stepAt converts ticks of a clock running at hz into the first step at which Ticks reaches them.
+func (m *Machine) stepAt(ticks uint64, hz uint64) uint64 {
+	hi, lo := bits.Mul64(ticks, m.CPUClock)
+	if hi >= hz {
+		return ^uint64(0)
+	}
+	step, remainder := bits.Div64(hi, lo, hz)
+	if remainder != 0 {
+		step++
+	}
+	return step
+}
+
+// NextInterrupt returns the step at which the next of the timers raises its interrupt, whether or not it's enabled
+// anywhere, or 0 if none of them is counting towards one. It's what the CPU fast-forwards to.
+func (m *Machine) NextInterrupt() uint64 {
+	next := uint64(0)
+	earliest := func(ticks uint64, ok bool, hz uint64) {
+		if step := m.stepAt(ticks, hz); ok && (next == 0 || step < next) {
+			next = step
+		}
+	}
+	ticks, ok := m.SystemTimer.NextMatch()
+	earliest(ticks, ok, SystemTimerClock)
+	ticks, ok = m.ARMTimer.NextIRQ()
+	earliest(ticks, ok, APBClock)
+	ticks, ok = m.Local.NextTimerIRQ()
+	earliest(ticks, ok, LocalTimerClock)
+	return next
+}
+
+// Load loads a linked image and points core 0's PC at its entry point.
+func (m *Machine) Load(image *linker.Image) {
+	m.CPU.LoadLinked(image)
+}
@@ -0,0 +1,261 @@
+package bcm
+
+// System timer register offsets
+const (
+	TimerCS  = 0x00 // Match flags of the 4 compare registers, write 1s to clear
+	TimerCLO = 0x04 // Counter bits 0-31
+	TimerCHI = 0x08 // Counter bits 32-63
+	TimerC0  = 0x0C // Compare registers C0-C3
+)
+
+// SystemTimer is the free running 1 MHz counter and its 4 compare registers. A compare register matches when the
+// bottom 32 bits of the counter pass its value, which sets its bit in CS until it's cleared.
+type SystemTimer struct {
+	clock   func() uint64 // Microseconds
+	compare [4]uint32
+	matched uint32
+	last    uint64 // Counter value the match flags are up to date with
+}
+
+// NewSystemTimer creates a system timer counting the microseconds of clock.
+func NewSystemTimer(clock func() uint64) *SystemTimer {
+	return &SystemTimer{clock: clock}
+}
+
+func (s *SystemTimer) Read32(offset uint32) uint32 {
+	now := s.update()
+	switch {
+	case offset == TimerCS:
+		return s.matched
+	case offset == TimerCLO:
+		return uint32(now)
+	case offset == TimerCHI:
+		return uint32(now >> 32)
+	case offset >= TimerC0 && offset < TimerC0+4*4:
+		return s.compare[(offset-TimerC0)/4]
+	default:
+		return 0
+	}
+}
+
+func (s *SystemTimer) Write32(offset uint32, value uint32) {
+	s.update()
+	switch {
+	case offset == TimerCS:
+		s.matched &^= value & 0xF
+	case offset >= TimerC0 && offset < TimerC0+4*4:
+		s.compare[(offset-TimerC0)/4] = value
+	}
+}
+
+// Matched reports whether compare register n has matched since its flag was last cleared.
+func (s *SystemTimer) Matched(n int) bool {
+	s.update()
+	return s.matched&(1<<n) != 0
+}
+
+// NextMatch returns the counter value at which the next compare register whose flag is clear matches, reporting
+// false if they're all set.
+func (s *SystemTimer) NextMatch() (uint64, bool) {
+	now := s.update()
+	next, ok := uint64(0), false
+	for n, compare := range s.compare {
+		if s.matched&(1<<n) != 0 {
+			continue
+		}
+		wait := uint64(compare - uint32(now))
+		if wait == 0 {
+			wait = 1 << 32 // Just went past, it's a full turn of the bottom 32 bits away
+		}
+		if !ok || now+wait < next {
+			next, ok = now+wait, true
+		}
+	}
+	return next, ok
+}
+
+// update sets the flags of every compare register the counter went past since the last update.
+func (s *SystemTimer) update() uint64 {
+	now := s.clock()
+	elapsed := now - s.last
+	for n, compare := range s.compare {
+		// The counter took every value from last+1 to now
+		if elapsed > 0 && (elapsed >= 1<<32 || uint64(compare-uint32(s.last)-1) < elapsed) {
+			s.matched |= 1 << n
+		}
+	}
+	s.last = now
+	return now
+}
+
+// ARM timer register offsets
+const (
+	ARMTimerLoad      = 0x00 // Sets the counter and the value it reloads with
+	ARMTimerValue     = 0x04 // Current count
+	ARMTimerControl   = 0x08
+	ARMTimerIRQClear  = 0x0C // Write anything to clear the interrupt
+	ARMTimerRawIRQ    = 0x10
+	ARMTimerMaskedIRQ = 0x14
+	ARMTimerReload    = 0x18 // Sets the value it reloads with, without touching the count
+	ARMTimerPreDiv    = 0x1C
+	ARMTimerFreeRun   = 0x20 // Free running counter
+)
+
+// ARM timer control bits
+const (
+	ARMTimer32Bit     = 1 << 1 // 32 bit counter, 16 bit otherwise
+	ARMTimerPrescale  = 3 << 2 // Divide by 1, 16 or 256
+	ARMTimerIRQEnable = 1 << 5
+	ARMTimerEnable    = 1 << 7
+	ARMTimerFreeRunOn = 1 << 9 // Free running counter enabled
+)
+
+// ARMTimer is the SP804 style countdown timer. It counts down from Load at the APB clock divided by the pre-divider
+// and prescaler, raises its interrupt when it reaches 0 and reloads on the next tick. The free running counter next to
+// it counts up at the APB clock divided by the prescaler in bits 16-23 of the control register.
+type ARMTimer struct {
+	clock     func() uint64 // APB clock ticks
+	load      uint32
+	value     uint32
+	control   uint32
+	preDiv    uint32
+	raw       bool
+	freeRun   uint32
+	last      uint64 // APB tick the state is up to date with
+	remainder uint64 // APB ticks left over towards the next count
+	freeRem   uint64 // APB ticks left over towards the next free running count
+}
+
+// NewARMTimer creates an ARM timer in its reset state, counting the APB ticks of clock.
+func NewARMTimer(clock func() uint64) *ARMTimer {
+	return &ARMTimer{clock: clock, control: 0x3E0020, preDiv: 0x7D, value: 0xFFFFFFFF, load: 0xFFFFFFFF}
+}
+
+func (a *ARMTimer) Read32(offset uint32) uint32 {
+	a.update()
+	switch offset {
+	case ARMTimerLoad, ARMTimerReload:
+		return a.load
+	case ARMTimerValue:
+		return a.value
+	case ARMTimerControl:
+		return a.control
+	case ARMTimerRawIRQ:
+		return boolBit(a.raw)
+	case ARMTimerMaskedIRQ:
+		return boolBit(a.Pending())
+	case ARMTimerPreDiv:
+		return a.preDiv
+	case ARMTimerFreeRun:
+		return a.freeRun
+	default:
+		return 0
+	}
+}
+
+func (a *ARMTimer) Write32(offset uint32, value uint32) {
+	a.update()
+	switch offset {
+	case ARMTimerLoad:
+		a.load, a.value = value, value
+		a.remainder = 0
+	case ARMTimerReload:
+		a.load = value
+	case ARMTimerControl:
+		a.control = value
+	case ARMTimerIRQClear:
+		a.raw = false
+	case ARMTimerPreDiv:
+		a.preDiv = value & 0x3FF
+	}
+}
+
+// Pending reports whether the timer is raising its interrupt.
+func (a *ARMTimer) Pending() bool {
+	a.update()
+	return a.raw && a.control&ARMTimerIRQEnable != 0
+}
+
+// NextIRQ returns the APB tick at which the timer next reaches 0 and raises its interrupt, reporting false if it's
+// stopped or the interrupt is already raised.
+func (a *ARMTimer) NextIRQ() (uint64, bool) {
+	a.update()
+	if a.raw || a.control&ARMTimerEnable == 0 {
+		return 0, false
+	}
+	counts := uint64(a.value & a.mask())
+	if counts == 0 {
+		counts = uint64(a.load&a.mask()) + 1 // Reloads first
+	}
+	return a.last + counts*a.divider() - a.remainder, true
+}
+
+// divider returns how many APB ticks the counter takes to count once.
+func (a *ARMTimer) divider() uint64 {
+	divider := uint64(a.preDiv) + 1
+	switch a.control & ARMTimerPrescale {
+	case 1 << 2:
+		divider *= 16
+	case 2 << 2:
+		divider *= 256
+	}
+	return divider
+}
+
+// mask returns the bits of the counter in use.
+func (a *ARMTimer) mask() uint32 {
+	if a.control&ARMTimer32Bit != 0 {
+		return 0xFFFFFFFF
+	}
+	return 0xFFFF
+}
+
+// update counts the APB ticks since the last update.
+func (a *ARMTimer) update() {
+	now := a.clock()
+	elapsed := now - a.last
+	a.last = now
+
+	if a.control&ARMTimerFreeRunOn != 0 {
+		divider := uint64(a.control>>16&0xFF) + 1
+		a.freeRem += elapsed
+		a.freeRun += uint32(a.freeRem / divider)
+		a.freeRem %= divider
+	}
+	if a.control&ARMTimerEnable == 0 {
+		return
+	}
+
+	divider := a.divider()
+	a.remainder += elapsed
+	ticks := a.remainder / divider
+	a.remainder %= divider
+
+	value, load := uint64(a.value&a.mask()), uint64(a.load&a.mask())
+	if ticks == 0 {
+		return
+	}
+	if value == 0 {
+		// Sitting at 0 since the last update, the next tick reloads
+		ticks--
+		value = load
+	}
+	if ticks < value {
+		a.value = uint32(value - ticks)
+		return
+	}
+	// Reached 0, every tick after that reloads and counts down again
+	a.raw = true
+	ticks -= value
+	a.value = 0
+	if ticks > 0 {
+		a.value = uint32(load - (ticks-1)%(load+1))
+	}
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
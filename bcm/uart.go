@@ -0,0 +1,357 @@
+package bcm
+
+import (
+	"io"
+	"sync"
+)
+
+// Terminal is the other end of the serial ports. Bytes the UARTs transmit are kept and written to the output, bytes
+// typed into it go to whichever UART reads them first.
+type Terminal struct {
+	out   io.Writer
+	mu    sync.Mutex // The input can be filled from another goroutine
+	input []byte
+	sent  []byte
+}
+
+// NewTerminal creates a terminal writing what it receives to out, which can be nil.
+func NewTerminal(out io.Writer) *Terminal {
+	return &Terminal{out: out}
+}
+
+// SetOutput changes where transmitted bytes are written.
+func (t *Terminal) SetOutput(out io.Writer) {
+	t.out = out
+}
+
+// Type queues data to be received by the UARTs.
+func (t *Terminal) Type(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.input = append(t.input, data...)
+}
+
+// Attach types everything read from r, in the background, until it runs out.
+func (t *Terminal) Attach(r io.Reader) {
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := r.Read(buf)
+			t.Type(buf[:n])
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// Sent returns everything the UARTs have transmitted.
+func (t *Terminal) Sent() []byte {
+	return t.sent
+}
+
+// receive takes up to n typed bytes.
+func (t *Terminal) receive(n int) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n = min(n, len(t.input))
+	data := t.input[:n:n]
+	t.input = t.input[n:]
+	return data
+}
+
+func (t *Terminal) transmit(b byte) {
+	t.sent = append(t.sent, b)
+	if t.out != nil {
+		t.out.Write([]byte{b})
+	}
+}
+
+// PL011 register offsets
+const (
+	UARTDR   = 0x00 // Data
+	UARTFR   = 0x18 // Flags
+	UARTIBRD = 0x24 // Integer baud rate divisor
+	UARTFBRD = 0x28 // Fractional baud rate divisor
+	UARTLCRH = 0x2C // Line control
+	UARTCR   = 0x30 // Control
+	UARTIFLS = 0x34 // Interrupt FIFO level select
+	UARTIMSC = 0x38 // Interrupt mask
+	UARTRIS  = 0x3C // Raw interrupt status
+	UARTMIS  = 0x40 // Masked interrupt status
+	UARTICR  = 0x44 // Interrupt clear
+)
+
+// PL011 flag, line control and control bits
+const (
+	UARTFRRXFE   = 1 << 4 // Receive FIFO empty
+	UARTFRTXFF   = 1 << 5 // Transmit FIFO full
+	UARTFRRXFF   = 1 << 6 // Receive FIFO full
+	UARTFRTXFE   = 1 << 7 // Transmit FIFO empty
+	UARTLCRHFEN  = 1 << 4 // FIFOs enabled, otherwise they hold a single byte
+	UARTCREnable = 1 << 0
+	UARTCRTXE    = 1 << 8
+	UARTCRRXE    = 1 << 9
+	UARTIntRX    = 1 << 4
+	UARTIntTX    = 1 << 5
+)
+
+const pl011FIFODepth = 16
+
+// PL011 models the primary UART. Transmitting takes no time, so the transmit FIFO only fills up while the UART or
+// its transmitter is disabled. Interrupts follow the FIFO levels: receive while there's something to read, transmit
+// while the transmit FIFO is empty.
+type PL011 struct {
+	terminal *Terminal
+	tx       []byte
+	rx       []byte
+	regs     map[uint32]uint32 // Registers that just hold their value
+}
+
+func NewPL011(terminal *Terminal) *PL011 {
+	return &PL011{terminal: terminal, regs: map[uint32]uint32{UARTCR: UARTCRTXE | UARTCRRXE}}
+}
+
+func (u *PL011) depth() int {
+	if u.regs[UARTLCRH]&UARTLCRHFEN != 0 {
+		return pl011FIFODepth
+	}
+	return 1
+}
+
+func (u *PL011) enabled(bit uint32) bool {
+	return u.regs[UARTCR]&UARTCREnable != 0 && u.regs[UARTCR]&bit != 0
+}
+
+// update moves bytes between the FIFOs and the terminal.
+func (u *PL011) update() {
+	if u.enabled(UARTCRTXE) {
+		for _, b := range u.tx {
+			u.terminal.transmit(b)
+		}
+		u.tx = u.tx[:0]
+	}
+	if u.enabled(UARTCRRXE) && len(u.rx) < u.depth() {
+		u.rx = append(u.rx, u.terminal.receive(u.depth()-len(u.rx))...)
+	}
+}
+
+func (u *PL011) interrupts() uint32 {
+	var raw uint32
+	if len(u.rx) > 0 {
+		raw |= UARTIntRX
+	}
+	if len(u.tx) == 0 {
+		raw |= UARTIntTX
+	}
+	return raw
+}
+
+func (u *PL011) Read32(offset uint32) uint32 {
+	u.update()
+	switch offset {
+	case UARTDR:
+		if len(u.rx) == 0 {
+			return 0
+		}
+		b := u.rx[0]
+		u.rx = u.rx[1:]
+		return uint32(b)
+	case UARTFR:
+		var flags uint32
+		if len(u.rx) == 0 {
+			flags |= UARTFRRXFE
+		}
+		if len(u.rx) >= u.depth() {
+			flags |= UARTFRRXFF
+		}
+		if len(u.tx) == 0 {
+			flags |= UARTFRTXFE
+		}
+		if len(u.tx) >= u.depth() {
+			flags |= UARTFRTXFF
+		}
+		return flags
+	case UARTRIS:
+		return u.interrupts()
+	case UARTMIS:
+		return u.interrupts() & u.regs[UARTIMSC]
+	case UARTICR:
+		return 0
+	default:
+		return u.regs[offset]
+	}
+}
+
+func (u *PL011) Write32(offset uint32, value uint32) {
+	switch offset {
+	case UARTDR:
+		if len(u.tx) < u.depth() {
+			u.tx = append(u.tx, byte(value))
+		}
+	case UARTFR, UARTRIS, UARTMIS, UARTICR:
+		// Read only, and the interrupts clear themselves with the FIFO levels
+	default:
+		u.regs[offset] = value
+	}
+	u.update()
+}
+
+// Pending reports whether the UART is raising an unmasked interrupt.
+func (u *PL011) Pending() bool {
+	return u.Read32(UARTMIS) != 0
+}
+
+// AUX and mini UART register offsets, from the AUX base
+const (
+	AUXIRQ     = 0x00
+	AUXENABLES = 0x04
+	AUXMUIO    = 0x40 // Data, or the bottom of the baud rate with DLAB set
+	AUXMUIER   = 0x44 // Interrupt enable
+	AUXMUIIR   = 0x48 // Interrupt identify, writes clear the FIFOs
+	AUXMULCR   = 0x4C // Line control
+	AUXMUMCR   = 0x50 // Modem control
+	AUXMULSR   = 0x54 // Line status
+	AUXMUMSR   = 0x58 // Modem status
+	AUXMUCNTL  = 0x60 // Extra control
+	AUXMUSTAT  = 0x64 // Extra status
+	AUXMUBAUD  = 0x68 // Baud rate
+)
+
+// Mini UART status bits
+const (
+	AUXMULSRDataReady = 1 << 0
+	AUXMULSRTXEmpty   = 1 << 5
+	AUXMULSRTXIdle    = 1 << 6
+	AUXMUCNTLRXE      = 1 << 0
+	AUXMUCNTLTXE      = 1 << 1
+	AUXMULCRDLAB      = 1 << 7
+	AUXMUIERRX        = 1 << 0
+	AUXMUIERTX        = 1 << 1
+)
+
+const miniUARTFIFODepth = 8
+
+// MiniUART models the AUX block with its mini UART. Like the PL011 it transmits instantly, and its registers read 0
+// until it's enabled in AUX_ENABLES.
+type MiniUART struct {
+	terminal *Terminal
+	rx       []byte
+	regs     map[uint32]uint32
+}
+
+func NewMiniUART(terminal *Terminal) *MiniUART {
+	return &MiniUART{terminal: terminal, regs: map[uint32]uint32{AUXMUCNTL: AUXMUCNTLRXE | AUXMUCNTLTXE}}
+}
+
+func (u *MiniUART) enabled() bool {
+	return u.regs[AUXENABLES]&1 != 0
+}
+
+func (u *MiniUART) update() {
+	if u.enabled() && u.regs[AUXMUCNTL]&AUXMUCNTLRXE != 0 && len(u.rx) < miniUARTFIFODepth {
+		u.rx = append(u.rx, u.terminal.receive(miniUARTFIFODepth-len(u.rx))...)
+	}
+}
+
+// interrupts returns the enabled interrupts that are pending, receive while there's something to read and transmit
+// all the time since the FIFO is always empty.
+func (u *MiniUART) interrupts() uint32 {
+	pending := uint32(AUXMUIERTX)
+	if len(u.rx) > 0 {
+		pending |= AUXMUIERRX
+	}
+	return pending & u.regs[AUXMUIER]
+}
+
+func (u *MiniUART) Read32(offset uint32) uint32 {
+	if offset == AUXENABLES {
+		return u.regs[AUXENABLES]
+	}
+	if offset == AUXIRQ {
+		return boolBit(u.Pending())
+	}
+	if !u.enabled() {
+		return 0
+	}
+	u.update()
+	switch offset {
+	case AUXMUIO:
+		if u.regs[AUXMULCR]&AUXMULCRDLAB != 0 {
+			return u.regs[AUXMUBAUD] & 0xFF
+		}
+		if len(u.rx) == 0 {
+			return 0
+		}
+		b := u.rx[0]
+		u.rx = u.rx[1:]
+		return uint32(b)
+	case AUXMUIER:
+		if u.regs[AUXMULCR]&AUXMULCRDLAB != 0 {
+			return u.regs[AUXMUBAUD] >> 8 & 0xFF
+		}
+		return u.regs[AUXMUIER]
+	case AUXMUIIR:
+		// FIFOs are always on, bit 0 clear means an interrupt is pending with the receiver taking priority
+		switch pending := u.interrupts(); {
+		case pending&AUXMUIERRX != 0:
+			return 0xC4
+		case pending&AUXMUIERTX != 0:
+			return 0xC2
+		default:
+			return 0xC1
+		}
+	case AUXMULSR:
+		status := uint32(AUXMULSRTXEmpty | AUXMULSRTXIdle)
+		if len(u.rx) > 0 {
+			status |= AUXMULSRDataReady
+		}
+		return status
+	case AUXMUMSR:
+		return 1 << 5 // CTS
+	case AUXMUSTAT:
+		status := uint32(1<<1 | 1<<3 | 1<<8 | 1<<9) // Space available, transmitter idle, transmit FIFO empty and done
+		if len(u.rx) > 0 {
+			status |= 1 << 0
+		} else {
+			status |= 1 << 2
+		}
+		return status | uint32(len(u.rx))<<16
+	default:
+		return u.regs[offset]
+	}
+}
+
+func (u *MiniUART) Write32(offset uint32, value uint32) {
+	if offset != AUXENABLES && !u.enabled() {
+		return
+	}
+	switch offset {
+	case AUXIRQ, AUXMULSR, AUXMUMSR, AUXMUSTAT:
+		// Read only
+	case AUXMUIO:
+		if u.regs[AUXMULCR]&AUXMULCRDLAB != 0 {
+			u.regs[AUXMUBAUD] = u.regs[AUXMUBAUD]&^0xFF | value&0xFF
+		} else if u.regs[AUXMUCNTL]&AUXMUCNTLTXE != 0 {
+			u.terminal.transmit(byte(value))
+		}
+	case AUXMUIER:
+		if u.regs[AUXMULCR]&AUXMULCRDLAB != 0 {
+			u.regs[AUXMUBAUD] = u.regs[AUXMUBAUD]&^0xFF00 | value&0xFF<<8
+		} else {
+			u.regs[AUXMUIER] = value & 0b11
+		}
+	case AUXMUIIR:
+		if value&(1<<1) != 0 {
+			u.rx = u.rx[:0]
+		}
+	default:
+		u.regs[offset] = value
+	}
+	u.update()
+}
+
+// Pending reports whether the mini UART is raising an enabled interrupt.
+func (u *MiniUART) Pending() bool {
+	return u.enabled() && u.interrupts() != 0
+}
@@ -1,23 +1,26 @@
-MOVW R4, 0
-MOVT R4, 0x3F20
-ADD R2, R4, 0x08
-LDR R3, (R2)
-ORR R3, R3, 0x00000008
-STR R3, (R2)
-ADD R3, R4, 0x1C
-MOVW R2, 0x0000
-MOVT R2, 0x0020
-STR R2, (R3)
-MOVW R5, 0xFFFF
-MOVT R5, 0x000F
-SUB S R5, R5, 0x01
-B PL L 0xFFFFFD
-ADD R3, R4, 0x28
-MOVW R2, 0x0000
-MOVT R2, 0x0020
-STR R2, (R3)
-MOVW R5, 0xFFFF
-MOVT R5, 0x000F
-SUB S R5, R5, 0x01
-B PL 0xFFFFFD
-B 0xFFFFEE
\ No newline at end of file
+MOVW R4, #0x0000
+MOVT R4, #0x3F20
+ADD R2, R4, #0x08
+LDR R3, [R2]
+ORR R3, R3, #0x08
+STR R3, [R2]
+on:
+ADD R3, R4, #0x1C
+MOVW R2, #0x0000
+MOVT R2, #0x0020
+STR R2, [R3]
+MOVW R5, #0xFFFF
+MOVT R5, #0x000F
+wait_on:
+SUBS R5, R5, #0x01
+BPL wait_on
+ADD R3, R4, #0x28
+MOVW R2, #0x0000
+MOVT R2, #0x0020
+STR R2, [R3]
+MOVW R5, #0xFFFF
+MOVT R5, #0x000F
+wait_off:
+SUBS R5, R5, #0x01
+BPL wait_off
+B on
@@ -20,7 +20,7 @@ str r2, [r3]        ; write r2 to GPSET
 stmea sp!, {r0-r12} ; store everything
 movw r5, #0x4f20    ; store 1 mil
 movt r5, #0x000f    ; store 1 mil
-str r5, [sp]!, #4   ; pass value to delay
+str r5, [sp], #4    ; pass value to delay
 bl >delay            ; delay subroutine
 ldmea sp!, {r0-r12} ; restore all registers
 
@@ -30,7 +30,7 @@ str r2, [r3]        ; write r2 to GPCLR
 stmea sp!, {r0-r12} ; store everything
 movw r5, #0x4f20    ; store 1 mil
 movt r5, #0x000f    ; store 1 mil
-str r5, [sp]!, #4   ; pass value to delay
+str r5, [sp], #4    ; pass value to delay
 bl >delay            ; delay subroutine
 ldmea sp!, {r0-r12} ; restore all registers
 
@@ -42,7 +42,7 @@ bgt >blink_loop      ; blink again if counter is positive (but not zero)
 stmea sp!, {r0-r12} ; store everything
 movw r5, #0x0900     ; store 4 mil
 movt r5, #0x003d     ; store 4 mil
-str r5, [sp]!, #4   ; pass value to delay
+str r5, [sp], #4    ; pass value to delay
 bl >delay            ; delay subroutine
 ldmea sp!, {r0-r12} ; restore all registers
 
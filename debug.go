@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/robertjshirts/rogasmic/bcm"
+	"github.com/robertjshirts/rogasmic/debugger"
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/object"
+)
+
+// runDebug implements "rogasmic debug [-T script.ld] [-steps n] [-fast] [-serial-in file] [-input text]
+// [-serial-out file] program.asm". It assembles and links the program, loads it into a machine like run does and
+// reads debugger commands from stdin.
+func runDebug(args []string) error {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	scriptFile := flags.String("T", "", "linker script (default places everything at 0x8000)")
+	steps := flags.Uint64("steps", 10000000, "instructions continue and next run before giving up, 0 for no limit")
+	fast := flags.Bool("fast", false, "skip countdown delay loops when continuing")
+	serialIn := flags.String("serial-in", "", "file the UARTs receive from")
+	input := flags.String("input", "", "text the UARTs receive, Go escapes like \\n work")
+	serialOut := flags.String("serial-out", "-", "file the UARTs transmit to, - for stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: rogasmic debug [-T script.ld] [-steps n] [-fast] [-serial-in file] [-input text] [-serial-out file] program.asm")
+	}
+	if *serialIn == "-" {
+		return fmt.Errorf("stdin is for debugger commands, use -serial-in with a file")
+	}
+
+	a, err := assembleSource(flags.Arg(0), false)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	script, err := loadScript(*scriptFile)
+	if err != nil {
+		return err
+	}
+	image, err := linker.NewLinker([]*object.Object{a.Object}, script).Link()
+	if err != nil {
+		return err
+	}
+
+	machine := bcm.NewMachine()
+	machine.CPU.FastForward = *fast
+	machine.Load(image)
+	closeSerial, err := connectSerial(machine.Terminal, *serialIn, *input, *serialOut)
+	if err != nil {
+		return err
+	}
+	defer closeSerial()
+
+	source := debugger.NewSource(a.File, a.Text, a.Instructions, a.Sections, image)
+	d := debugger.New(machine.CPU, source, os.Stdout)
+	d.StepLimit = *steps
+	return d.Run(os.Stdin)
+}
@@ -0,0 +1,545 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Debugger drives a CPU from commands, stopping at breakpoints and watchpoints and showing where it is in the source.
+type Debugger struct {
+	cpu    *emu.CPU
+	source *Source
+	out    io.Writer
+
+	// StepLimit is how many instructions continue and next run before giving up, 0 for no limit.
+	StepLimit uint64
+
+	breakpoints []*breakpoint
+	watchpoints []*watchpoint
+	nextID      int
+	written     []*watchpoint // Watchpoints hit by the instruction being run
+	trapped     string        // Trap raised by the instruction being run, as it's shown
+	last        string        // Last command, repeated by an empty line
+}
+
+type breakpoint struct {
+	id        int
+	address   uint32
+	condition *expr // Nil if it always stops
+}
+
+// watchpoint stops after any write to the word at address. value is what the word held when last checked.
+type watchpoint struct {
+	id      int
+	address uint32
+	value   uint32
+}
+
+// New creates a debugger for a CPU with a program loaded, writing everything it shows to out.
+func New(cpu *emu.CPU, source *Source, out io.Writer) *Debugger {
+	d := &Debugger{cpu: cpu, source: source, out: out, nextID: 1}
+	cpu.Memory.WriteHook = d.checkWrite
+	cpu.ExceptionHook = d.trap
+	return d
+}
+
+// errQuit is returned by quit to end Run.
+var errQuit = errors.New("quit")
+
+type command struct {
+	names []string
+	usage string
+	run   func(d *Debugger, args string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{[]string{"step", "s"}, "step [n]              execute n instructions, 1 by default", (*Debugger).step},
+		{[]string{"next", "n"}, "next                  execute an instruction, running a BL until it returns", (*Debugger).next},
+		{[]string{"continue", "c"}, "continue              run until a breakpoint, watchpoint, halt or fault", (*Debugger).cont},
+		{[]string{"break", "b"}, "break <loc> [if expr] stop at a label, address, file:line or :line", (*Debugger).addBreakpoint},
+		{[]string{"watch", "w"}, "watch <addr>          stop after the word at an address is written", (*Debugger).addWatchpoint},
+		{[]string{"delete", "d"}, "delete [id]           delete a breakpoint or watchpoint, or all of them", (*Debugger).delete},
+		{[]string{"info", "i"}, "info                  list breakpoints and watchpoints", (*Debugger).info},
+		{[]string{"regs", "r"}, "regs                  show the registers and flags", (*Debugger).regs},
+		{[]string{"x"}, "x <addr> [bytes]      hex dump memory, 64 bytes by default", (*Debugger).dump},
+		{[]string{"print", "p"}, "print <expr>          evaluate an expression", (*Debugger).print},
+		{[]string{"list", "l"}, "list [loc]            show the source around the PC or a location", (*Debugger).list},
+		{[]string{"where"}, "where                 show the current instruction", (*Debugger).where},
+		{[]string{"help", "h", "?"}, "help                  show this help", (*Debugger).help},
+		{[]string{"quit", "q"}, "quit                  leave the debugger", func(*Debugger, string) error { return errQuit }},
+	}
+}
+
+// Run reads commands from in until it runs out or a quit command, printing a prompt before each.
+func (d *Debugger) Run(in io.Reader) error {
+	d.where("")
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(d.out, "(rogasmic) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(d.out)
+			return scanner.Err()
+		}
+		err := d.Execute(scanner.Text())
+		if err == errQuit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(d.out, "Error: %v\n", err)
+		}
+	}
+}
+
+// Execute runs a single command line. An empty line repeats the last command.
+func (d *Debugger) Execute(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = d.last
+		if line == "" {
+			return nil
+		}
+	}
+	d.last = line
+
+	name, args, _ := strings.Cut(line, " ")
+	for _, c := range commands {
+		for _, alias := range c.names {
+			if alias == name {
+				return c.run(d, strings.TrimSpace(args))
+			}
+		}
+	}
+	return fmt.Errorf("unknown command %q, try help", name)
+}
+
+func (d *Debugger) help(string) error {
+	for _, c := range commands {
+		fmt.Fprintf(d.out, "  %s\n", c.usage)
+	}
+	fmt.Fprintln(d.out, "Expressions use registers (r0-r15, sp, lr, pc, cpsr, spsr), flags (n, z, c, v), numbers, labels, [addr] for")
+	fmt.Fprintln(d.out, "a word of memory and the operators ! ~ - + & ^ | == != < <= > >= && ||. Comparisons are unsigned.")
+	return nil
+}
+
+// checkWrite is the memory write hook. It notes the watchpoints a write touches and stops the CPU after the
+// instruction.
+func (d *Debugger) checkWrite(address uint32, size uint32) {
+	for _, w := range d.watchpoints {
+		if address < w.address+4 && w.address < address+size {
+			d.written = append(d.written, w)
+			d.cpu.Halt("watchpoint")
+		}
+	}
+}
+
+// trap is the exception hook. Undefined instructions and aborts stop the CPU at their handler, so the line that
+// raised them can be shown. Interrupts and supervisor calls are left to run.
+func (d *Debugger) trap(e emu.Exception, address uint32) {
+	switch e {
+	case emu.ExceptionUndefined, emu.ExceptionPrefetchAbort:
+		d.trapped = fmt.Sprintf("%s at %s", e, d.describe(address))
+	case emu.ExceptionDataAbort:
+		d.trapped = fmt.Sprintf("%s accessing 0x%08X at %s", e, d.cpu.FaultAddress, d.describe(address))
+	default:
+		return
+	}
+	d.cpu.Halt(e.String())
+}
+
+// reportWatchpoints shows the old and new values of the watchpoints the last instruction wrote, and reports whether
+// there were any.
+func (d *Debugger) reportWatchpoints() bool {
+	if len(d.written) == 0 {
+		return false
+	}
+	reported := make(map[*watchpoint]bool)
+	for _, w := range d.written {
+		if reported[w] {
+			continue
+		}
+		reported[w] = true
+		if d.cpu.Memory.IsDevice(w.address) {
+			fmt.Fprintf(d.out, "Watchpoint %d: 0x%08X written\n", w.id, w.address)
+			continue
+		}
+		value := d.cpu.Memory.Read32(w.address)
+		fmt.Fprintf(d.out, "Watchpoint %d: [0x%08X] 0x%08X -> 0x%08X\n", w.id, w.address, w.value, value)
+		w.value = value
+	}
+	d.written = nil
+	return true
+}
+
+// resume clears a halt left over from the last stop, so the program can keep going.
+func (d *Debugger) resume() {
+	d.cpu.Halted = false
+	d.cpu.HaltReason = ""
+	d.written = nil
+	d.trapped = ""
+}
+
+// report shows why the CPU stopped, if it wasn't just a step or a breakpoint, and reports whether it should stop
+// going.
+func (d *Debugger) report(err error) bool {
+	var fault *emu.Fault
+	switch {
+	case errors.As(err, &fault):
+		fmt.Fprintf(d.out, "Fault: %v\n", fault)
+		return true
+	case err != nil:
+		fmt.Fprintf(d.out, "Stopped after %d steps: %v\n", d.cpu.Steps, err)
+		return true
+	case d.trapped != "":
+		fmt.Fprintf(d.out, "Trap: %s\n", d.trapped)
+		d.reportWatchpoints()
+		return true
+	case d.reportWatchpoints():
+		return true
+	case d.cpu.Halted && !strings.HasPrefix(d.cpu.HaltReason, "reached "):
+		fmt.Fprintf(d.out, "Halted: %s\n", d.cpu.HaltReason)
+		return true
+	}
+	return false
+}
+
+func (d *Debugger) step(args string) error {
+	count := uint64(1)
+	if args != "" {
+		n, err := strconv.ParseUint(args, 0, 64)
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid step count %q", args)
+		}
+		count = n
+	}
+	for range count {
+		d.resume()
+		if d.report(d.cpu.Step()) {
+			break
+		}
+	}
+	return d.where("")
+}
+
+func (d *Debugger) next(string) error {
+	pc := d.cpu.R[emu.PC]
+	if branch, ok := d.decode(pc).(*parser.InstructionBranch); !ok || branch.LBit == 0 {
+		return d.step("")
+	}
+	d.cpu.HaltAt(pc + 4)
+	defer func() {
+		if d.breakpointAt(pc+4) == nil {
+			d.cpu.ClearHaltAt(pc + 4)
+		}
+	}()
+	return d.run(func() bool { return d.cpu.R[emu.PC] == pc+4 })
+}
+
+func (d *Debugger) cont(string) error {
+	return d.run(func() bool { return false })
+}
+
+// run executes until a breakpoint whose condition holds, a watchpoint, a halt, a fault, the step limit or until
+// returns true. It only stops at an address Run halts at, so until has to be one of them.
+func (d *Debugger) run(until func() bool) error {
+	start := d.cpu.Steps
+	for first := true; ; first = false {
+		if !first {
+			if until() {
+				break
+			}
+			if b := d.breakpointAt(d.cpu.R[emu.PC]); b != nil && (b.condition == nil || b.condition.eval(d.cpu) != 0) {
+				fmt.Fprintf(d.out, "Breakpoint %d, %s\n", b.id, d.describe(b.address))
+				break
+			}
+		}
+		var limit uint64
+		if d.StepLimit != 0 {
+			if d.cpu.Steps-start >= d.StepLimit {
+				fmt.Fprintf(d.out, "Stopped after %d steps: %v\n", d.cpu.Steps, emu.ErrStepLimit)
+				break
+			}
+			limit = d.StepLimit - (d.cpu.Steps - start)
+		}
+
+		d.resume()
+		var err error
+		if first || d.breakpointAt(d.cpu.R[emu.PC]) != nil {
+			// Get off the breakpoint we're stopped at before Run halts at it again
+			err = d.cpu.Step()
+		} else {
+			err = d.cpu.Run(limit)
+		}
+		if err == emu.ErrStepLimit {
+			err = nil
+		}
+		if d.report(err) {
+			break
+		}
+	}
+	return d.where("")
+}
+
+// breakpointAt returns the first breakpoint at an address.
+func (d *Debugger) breakpointAt(address uint32) *breakpoint {
+	for _, b := range d.breakpoints {
+		if b.address == address {
+			return b
+		}
+	}
+	return nil
+}
+
+// location resolves file:line, :line or an expression into an address.
+func (d *Debugger) location(text string) (uint32, error) {
+	if file, lineText, ok := strings.Cut(text, ":"); ok {
+		line, err := strconv.Atoi(lineText)
+		if err != nil || (file != "" && file != d.source.File) {
+			return 0, fmt.Errorf("invalid location %q", text)
+		}
+		address, ok := d.source.Address(line)
+		if !ok {
+			return 0, fmt.Errorf("no instructions on line %d", line)
+		}
+		return address, nil
+	}
+	e, err := parseExpr(text, d.source.Label)
+	if err != nil {
+		return 0, err
+	}
+	return e.eval(d.cpu), nil
+}
+
+func (d *Debugger) addBreakpoint(args string) error {
+	where, condition, hasCondition := strings.Cut(args, " if ")
+	where = strings.TrimSpace(where)
+	if where == "" {
+		return fmt.Errorf("usage: break <label|addr|file:line> [if expr]")
+	}
+	address, err := d.location(where)
+	if err != nil {
+		return err
+	}
+	if address%4 != 0 {
+		return fmt.Errorf("0x%08X isn't word aligned", address)
+	}
+	b := &breakpoint{id: d.nextID, address: address}
+	if hasCondition {
+		if b.condition, err = parseExpr(strings.TrimSpace(condition), d.source.Label); err != nil {
+			return err
+		}
+	}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, b)
+	d.cpu.HaltAt(address)
+	fmt.Fprintf(d.out, "Breakpoint %d at %s\n", b.id, d.describe(address))
+	return nil
+}
+
+func (d *Debugger) addWatchpoint(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: watch <addr>")
+	}
+	address, err := d.location(args)
+	if err != nil {
+		return err
+	}
+	w := &watchpoint{id: d.nextID, address: address &^ 3}
+	if !d.cpu.Memory.IsDevice(w.address) {
+		w.value = d.cpu.Memory.Read32(w.address)
+	}
+	d.nextID++
+	d.watchpoints = append(d.watchpoints, w)
+	fmt.Fprintf(d.out, "Watchpoint %d at 0x%08X\n", w.id, w.address)
+	return nil
+}
+
+func (d *Debugger) delete(args string) error {
+	if args == "" {
+		for _, b := range d.breakpoints {
+			d.cpu.ClearHaltAt(b.address)
+		}
+		d.breakpoints, d.watchpoints = nil, nil
+		return nil
+	}
+	id, err := strconv.Atoi(args)
+	if err != nil {
+		return fmt.Errorf("invalid id %q", args)
+	}
+	for i, b := range d.breakpoints {
+		if b.id == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			if d.breakpointAt(b.address) == nil {
+				d.cpu.ClearHaltAt(b.address)
+			}
+			return nil
+		}
+	}
+	for i, w := range d.watchpoints {
+		if w.id == id {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no breakpoint or watchpoint %d", id)
+}
+
+func (d *Debugger) info(string) error {
+	if len(d.breakpoints) == 0 && len(d.watchpoints) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints or watchpoints")
+		return nil
+	}
+	type entry struct {
+		id   int
+		text string
+	}
+	var entries []entry
+	for _, b := range d.breakpoints {
+		text := fmt.Sprintf("%-3d breakpoint  %s", b.id, d.describe(b.address))
+		if b.condition != nil {
+			text += " if " + b.condition.String()
+		}
+		entries = append(entries, entry{b.id, text})
+	}
+	for _, w := range d.watchpoints {
+		entries = append(entries, entry{w.id, fmt.Sprintf("%-3d watchpoint  0x%08X", w.id, w.address)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+	for _, e := range entries {
+		fmt.Fprintln(d.out, e.text)
+	}
+	return nil
+}
+
+func (d *Debugger) regs(string) error {
+	d.cpu.WriteRegisters(d.out)
+	fmt.Fprintf(d.out, "Steps %d\n", d.cpu.Steps)
+	return nil
+}
+
+func (d *Debugger) print(args string) error {
+	e, err := parseExpr(args, d.source.Label)
+	if err != nil {
+		return err
+	}
+	value := e.eval(d.cpu)
+	fmt.Fprintf(d.out, "0x%08X (%d)\n", value, int32(value))
+	return nil
+}
+
+// dump hex dumps memory 16 bytes to a line, with the printable bytes alongside. Devices show as -- since reading them
+// can change their state.
+func (d *Debugger) dump(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return fmt.Errorf("usage: x <addr> [bytes]")
+	}
+	size := uint32(64)
+	if len(fields) > 1 {
+		n, err := strconv.ParseUint(fields[len(fields)-1], 0, 32)
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid byte count %q", fields[len(fields)-1])
+		}
+		size = uint32(n)
+		fields = fields[:len(fields)-1]
+	}
+	address, err := d.location(strings.Join(fields, " "))
+	if err != nil {
+		return err
+	}
+
+	for offset := uint32(0); offset < size; offset += 16 {
+		var hex, text strings.Builder
+		for i := offset; i < offset+16 && i < size; i++ {
+			at := address + i
+			if d.cpu.Memory.IsDevice(at) {
+				hex.WriteString("-- ")
+				text.WriteByte('.')
+				continue
+			}
+			b := d.cpu.Memory.Read8(at)
+			fmt.Fprintf(&hex, "%02X ", b)
+			if b >= 0x20 && b < 0x7F {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(d.out, "0x%08X  %-48s |%s|\n", address+offset, hex.String(), text.String())
+	}
+	return nil
+}
+
+func (d *Debugger) list(args string) error {
+	address := d.cpu.R[emu.PC]
+	if args != "" {
+		var err error
+		if address, err = d.location(args); err != nil {
+			return err
+		}
+	}
+	center, ok := d.source.Line(address)
+	if !ok {
+		return fmt.Errorf("0x%08X isn't in %s", address, d.source.File)
+	}
+	current, _ := d.source.Line(d.cpu.R[emu.PC])
+	for line := max(1, center-5); line <= min(d.source.Lines(), center+5); line++ {
+		marker := "  "
+		if line == current {
+			marker = "=>"
+		}
+		if address, ok := d.source.Address(line); ok && d.breakpointAt(address) != nil {
+			marker = marker[:1] + "*"
+		}
+		fmt.Fprintf(d.out, "%s %4d  %s\n", marker, line, strings.TrimRight(d.source.lines[line-1], " \t\r"))
+	}
+	return nil
+}
+
+// where shows the instruction at the PC.
+func (d *Debugger) where(string) error {
+	fmt.Fprintf(d.out, "=> %s\n", d.describe(d.cpu.R[emu.PC]))
+	return nil
+}
+
+// describe shows an address with its symbol and source, e.g. "0x00008008 <start+0x8>  prog.asm:12  STR R2, [R3]".
+// Addresses outside the source are disassembled instead.
+func (d *Debugger) describe(address uint32) string {
+	text := fmt.Sprintf("0x%08X", address)
+	if symbol := d.source.Symbolize(address); symbol != "" {
+		text += " <" + symbol + ">"
+	}
+	if line, ok := d.source.Line(address); ok {
+		return fmt.Sprintf("%s  %s:%d  %s", text, d.source.File, line, d.source.Text(line))
+	}
+	if !d.cpu.Memory.Mapped(address) {
+		return text
+	}
+	if instruction := d.decode(address); instruction != nil {
+		return text + "  " + instruction.String()
+	}
+	return fmt.Sprintf("%s  .word 0x%08X", text, d.cpu.Memory.Read32(address))
+}
+
+// decode decodes the instruction at address, or returns nil if it doesn't decode.
+func (d *Debugger) decode(address uint32) types.Instruction {
+	if d.cpu.Memory.IsDevice(address) {
+		return nil
+	}
+	instruction, err := parser.Decode(d.cpu.Memory.Read32(address))
+	if err != nil {
+		return nil
+	}
+	return instruction
+}
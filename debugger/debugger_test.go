@@ -0,0 +1,249 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/internal/emutest"
+)
+
+// start assembles and links source at the default address, loads it and attaches a debugger writing to a buffer.
+func start(t *testing.T, source string) (*Debugger, *bytes.Buffer) {
+	t.Helper()
+	assembled, image := emutest.Link(t, source)
+	cpu := emu.New(emu.NewMemory())
+	cpu.LoadLinked(image)
+	out := &bytes.Buffer{}
+	d := New(cpu, NewSource(assembled.File, assembled.Text, assembled.Instructions, assembled.Sections, image), out)
+	d.StepLimit = 100000
+	return d, out
+}
+
+const program = `MOVW R0, #0
+MOV32 R1, #0x9000
+loop:
+ADD R0, R0, #1
+STR R0, [R1]
+BL check
+SUBS R2, R0, #5
+BNE loop
+end: B end
+
+check:
+ADD R3, R3, #2
+BX lr
+`
+
+func TestSource(t *testing.T) {
+	d, _ := start(t, program)
+	lines := map[uint32]int{0x8000: 1, 0x8004: 2, 0x8008: 2, 0x800C: 4, 0x8020: 9, 0x8024: 12, 0x8028: 13}
+	for address, expected := range lines {
+		if line, ok := d.source.Line(address); !ok || line != expected {
+			t.Errorf("expected 0x%08X on line %d, got %d (%t)", address, expected, line, ok)
+		}
+	}
+	if address, ok := d.source.Address(2); !ok || address != 0x8004 {
+		t.Errorf("expected line 2 at 0x8004, got 0x%08X (%t)", address, ok)
+	}
+	if _, ok := d.source.Address(3); ok {
+		t.Errorf("expected no instructions on line 3")
+	}
+	symbols := map[uint32]string{0x8000: "", 0x800C: "loop", 0x8014: "loop+0x8", 0x8028: "check+0x4"}
+	for address, expected := range symbols {
+		if symbol := d.source.Symbolize(address); symbol != expected {
+			t.Errorf("expected 0x%08X to be %q, got %q", address, expected, symbol)
+		}
+	}
+}
+
+func TestExpr(t *testing.T) {
+	d, _ := start(t, program)
+	d.cpu.R[0] = 5
+	d.cpu.R[emu.SP] = 0x9000
+	d.cpu.CPSR |= emu.FlagZ
+	d.cpu.Memory.Write32(0x9000, 0x1234)
+
+	cases := []struct {
+		expr     string
+		expected uint32
+		err      string
+	}{
+		{expr: "r0", expected: 5},
+		{expr: "R0 == 5", expected: 1},
+		{expr: "r0 != 5 || z", expected: 1},
+		{expr: "r0 >= 6 && [0]", expected: 0},
+		{expr: "!c && z", expected: 1},
+		{expr: "[sp] & 0xFF", expected: 0x34},
+		{expr: "[sp + 4 - 4] == #0x1234", expected: 1},
+		{expr: "loop + 4", expected: 0x8010},
+		{expr: "(1 + 2) & ~1", expected: 2},
+		{expr: "-1", expected: 0xFFFFFFFF},
+		{expr: "1 | 2 ^ 3", expected: 1},
+		{expr: "r0 < 0b110", expected: 1},
+		{expr: "pc", expected: 0x8000},
+		{expr: "missing", err: `unknown symbol "missing" at col 1`},
+		{expr: "r0 ==", err: "missing operand at end of expression"},
+		{expr: "(r0", err: `expected ")" at end of expression`},
+		{expr: "r0 r1", err: `unexpected "r1" at col 4`},
+		{expr: "r0 $ 1", err: `unexpected '$' at col 4`},
+		{expr: "0xZZ", err: `invalid number "0xZZ" at col 1`},
+		{expr: "", err: "empty expression"},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			e, err := parseExpr(c.expr, d.source.Label)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("expected error %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value := e.eval(d.cpu); value != c.expected {
+				t.Errorf("expected 0x%08X, got 0x%08X", c.expected, value)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	cases := []struct {
+		name     string
+		commands []string
+		expected []string // Pieces of the output, in order
+		r0       uint32
+	}{
+		{
+			name:     "step",
+			commands: []string{"step", "s 2", ""},
+			expected: []string{"=> 0x00008004  test.asm:2  MOV32 R1, #0x9000", "=> 0x0000800C <loop>  test.asm:4  ADD R0, R0, #1", "=> 0x00008014 <loop+0x8>  test.asm:6  BL check"},
+			r0:       1,
+		},
+		{
+			name:     "breakpoint on a label",
+			commands: []string{"break check", "continue", "c"},
+			expected: []string{"Breakpoint 1 at 0x00008024 <check>", "Breakpoint 1, 0x00008024 <check>  test.asm:12  ADD R3, R3, #2", "Breakpoint 1, 0x00008024"},
+			r0:       2,
+		},
+		{
+			name:     "conditional breakpoint",
+			commands: []string{"b loop+8 if r0 == 3 && z == 0", "c", "p r0"},
+			expected: []string{"Breakpoint 1, 0x00008014 <loop+0x8>  test.asm:6  BL check", "0x00000003 (3)"},
+			r0:       3,
+		},
+		{
+			name:     "breakpoint on a line",
+			commands: []string{"b :7", "b test.asm:8", "c", "c", "info"},
+			expected: []string{"Breakpoint 1, 0x00008018", "Breakpoint 2, 0x0000801C <loop+0x10>  test.asm:8  BNE loop", "1   breakpoint  0x00008018", "2   breakpoint  0x0000801C"},
+			r0:       1,
+		},
+		{
+			name:     "next over BL",
+			commands: []string{"b 0x8014", "c", "next", "p r3"},
+			expected: []string{"=> 0x00008018 <loop+0xC>  test.asm:7", "0x00000002 (2)"},
+			r0:       1,
+		},
+		{
+			name:     "watchpoint",
+			commands: []string{"watch 0x9000", "c", "c"},
+			expected: []string{"Watchpoint 1 at 0x00009000", "Watchpoint 1: [0x00009000] 0x00000000 -> 0x00000001", "=> 0x00008014", "Watchpoint 1: [0x00009000] 0x00000001 -> 0x00000002"},
+			r0:       2,
+		},
+		{
+			name:     "delete",
+			commands: []string{"b check", "w 0x9000", "d 1", "d 2", "c", "info"},
+			expected: []string{"Halted: idle loop at 0x00008020", "No breakpoints or watchpoints"},
+			r0:       5,
+		},
+		{
+			name:     "registers and memory",
+			commands: []string{"s 5", "r", "x r1 8", "x 0x8000 4"},
+			expected: []string{"R0  0x00000001  R1  0x00009000", "CPSR 0x000000D3  nzcv  svc", "Steps 5", "0x00009000  01 00 00 00 00 00 00 00  ", "|........|", "0x00008000  00 00 00 E3"},
+			r0:       1,
+		},
+		{
+			name:     "list",
+			commands: []string{"b check", "s", "list"},
+			expected: []string{"      1  MOVW R0, #0", "=>    2  MOV32 R1, #0x9000", "      6  BL check"},
+		},
+		{
+			name:     "errors",
+			commands: []string{"frobnicate", "b nowhere", "b :3", "b 0x8002", "d 9", "s x"},
+			expected: []string{`Error: unknown command "frobnicate", try help`, `Error: unknown symbol "nowhere" at col 1`, "Error: no instructions on line 3", "Error: 0x00008002 isn't word aligned", "Error: no breakpoint or watchpoint 9", `Error: invalid step count "x"`},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, out := start(t, program)
+			script := strings.Join(c.commands, "\n") + "\nquit\n"
+			if err := d.Run(strings.NewReader(script)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			output := out.String()
+			rest := output
+			for _, expected := range c.expected {
+				i := strings.Index(rest, expected)
+				if i < 0 {
+					t.Fatalf("expected %q in the output, in order:\n%s", expected, output)
+				}
+				rest = rest[i+len(expected):]
+			}
+			if d.cpu.R[0] != c.r0 {
+				t.Errorf("expected R0 0x%08X, got 0x%08X", c.r0, d.cpu.R[0])
+			}
+		})
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	d, out := start(t, "loop: ADD R0, R0, #1\nB loop")
+	d.StepLimit = 100
+	if err := d.Execute("continue"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Stopped after 100 steps: step limit reached") {
+		t.Errorf("expected to stop at the step limit, got:\n%s", out.String())
+	}
+}
+
+func TestTraps(t *testing.T) {
+	source := `B start
+undefined: B handler
+start:
+MOVW R0, #1
+.word 0xE7F000F0
+end: B end
+handler:
+ADD R0, R0, #1
+SUBS pc, lr, #0
+`
+	d, out := start(t, source)
+	d.cpu.VBAR = 0x8000
+	for _, command := range []string{"c", "p spsr", "c"} {
+		if err := d.Execute(command); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expected := []string{
+		"Trap: undefined instruction at 0x0000800C <start+0x4>  test.asm:5  .word 0xE7F000F0",
+		"=> 0x00008004 <undefined>",
+		"0x000000D3 (211)",
+		"Halted: idle loop at 0x00008010",
+	}
+	rest := out.String()
+	for _, e := range expected {
+		i := strings.Index(rest, e)
+		if i < 0 {
+			t.Fatalf("expected %q in the output, in order:\n%s", e, out.String())
+		}
+		rest = rest[i+len(e):]
+	}
+	if d.cpu.R[0] != 2 {
+		t.Errorf("expected the handler to run once, got R0 %d", d.cpu.R[0])
+	}
+}
@@ -0,0 +1,319 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// expr is a parsed expression over the CPU state, e.g. "r0 == 3 && [sp] > 0x100". Operands are registers (r0-r15,
+// sp, lr, pc, cpsr, spsr), flags (n, z, c, v), numbers, labels and [address] for the word in memory there. Values are
+// unsigned 32 bit numbers, comparisons and logical operators give 0 or 1.
+type expr struct {
+	text string
+	root node
+}
+
+type node interface {
+	eval(c *emu.CPU) uint32
+}
+
+type constant uint32
+
+func (n constant) eval(c *emu.CPU) uint32 { return uint32(n) }
+
+type register uint32
+
+func (n register) eval(c *emu.CPU) uint32 { return c.R[n] }
+
+type cpsr struct{}
+
+func (cpsr) eval(c *emu.CPU) uint32 { return c.CPSR }
+
+// spsr is the SPSR of the current mode, 0 in modes without one.
+type spsr struct{}
+
+func (spsr) eval(c *emu.CPU) uint32 {
+	value, _ := c.SPSR()
+	return value
+}
+
+type flag uint32
+
+func (n flag) eval(c *emu.CPU) uint32 { return boolValue(c.Flag(uint32(n))) }
+
+// load reads a word of memory. Devices read as 0 so evaluating an expression can't change their state.
+type load struct{ address node }
+
+func (n load) eval(c *emu.CPU) uint32 {
+	address := n.address.eval(c)
+	if c.Memory.IsDevice(address) {
+		return 0
+	}
+	return c.Memory.Read32(address)
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (n unary) eval(c *emu.CPU) uint32 {
+	value := n.operand.eval(c)
+	switch n.op {
+	case "!":
+		return boolValue(value == 0)
+	case "-":
+		return -value
+	default: // ~
+		return ^value
+	}
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) eval(c *emu.CPU) uint32 {
+	left := n.left.eval(c)
+	// Logical operators short circuit, so [r0] isn't read unless r0 was checked first
+	switch n.op {
+	case "&&":
+		return boolValue(left != 0 && n.right.eval(c) != 0)
+	case "||":
+		return boolValue(left != 0 || n.right.eval(c) != 0)
+	}
+	right := n.right.eval(c)
+	switch n.op {
+	case "==":
+		return boolValue(left == right)
+	case "!=":
+		return boolValue(left != right)
+	case "<":
+		return boolValue(left < right)
+	case "<=":
+		return boolValue(left <= right)
+	case ">":
+		return boolValue(left > right)
+	case ">=":
+		return boolValue(left >= right)
+	case "|":
+		return left | right
+	case "^":
+		return left ^ right
+	case "&":
+		return left & right
+	case "+":
+		return left + right
+	default: // -
+		return left - right
+	}
+}
+
+func boolValue(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Binary operators from the lowest precedence to the highest
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<=", ">=", "<", ">"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"+", "-"},
+}
+
+var flagNames = map[string]uint32{"n": emu.FlagN, "z": emu.FlagZ, "c": emu.FlagC, "v": emu.FlagV}
+
+// token is a piece of an expression and the column it starts at.
+type token struct {
+	text string
+	col  int
+}
+
+// parseExpr parses an expression, looking labels up as it goes.
+func parseExpr(text string, label func(name string) (uint32, bool)) (*expr, error) {
+	tokens, err := tokenizeExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	p := &exprParser{tokens: tokens, label: label}
+	root, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at col %d", p.tokens[p.pos].text, p.tokens[p.pos].col)
+	}
+	return &expr{text: text, root: root}, nil
+}
+
+func (e *expr) eval(c *emu.CPU) uint32 {
+	return e.root.eval(c)
+}
+
+func (e *expr) String() string {
+	return e.text
+}
+
+func tokenizeExpr(text string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(text); {
+		ch := text[i]
+		start := i
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+			continue
+		case utils.IsLiteralChar(ch) || ch == '#' || ch == '.':
+			i++
+			for i < len(text) && (utils.IsLiteralChar(text[i]) || text[i] == '.') {
+				i++
+			}
+		case strings.ContainsRune("=!<>&|", rune(ch)) && i+1 < len(text) && isTwoCharOperator(text[i:i+2]):
+			i += 2
+		case strings.ContainsRune("!<>&|^+-~()[]", rune(ch)):
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q at col %d", ch, i+1)
+		}
+		tokens = append(tokens, token{text: text[start:i], col: start + 1})
+	}
+	return tokens, nil
+}
+
+func isTwoCharOperator(op string) bool {
+	switch op {
+	case "==", "!=", "<=", ">=", "&&", "||":
+		return true
+	}
+	return false
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+	label  func(name string) (uint32, bool)
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+// errorf reports an error at the current token.
+func (p *exprParser) errorf(format string, args ...any) error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf(format+" at end of expression", args...)
+	}
+	return fmt.Errorf(format+" at col %d", append(args, p.tokens[p.pos].col)...)
+}
+
+// binary parses the operators of one precedence level and everything that binds tighter.
+func (p *exprParser) binary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, candidate := range precedence[level] {
+			found = found || op == candidate
+		}
+		if !found {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) unary() (node, error) {
+	switch op := p.peek(); op {
+	case "!", "-", "~":
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: op, operand: operand}, nil
+	}
+	return p.operand()
+}
+
+func (p *exprParser) operand() (node, error) {
+	text := p.peek()
+	switch text {
+	case "":
+		return nil, p.errorf("missing operand")
+	case "(", "[":
+		p.pos++
+		inner, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		closing := map[string]string{"(": ")", "[": "]"}[text]
+		if p.peek() != closing {
+			return nil, p.errorf("expected %q", closing)
+		}
+		p.pos++
+		if text == "[" {
+			return load{address: inner}, nil
+		}
+		return inner, nil
+	}
+
+	lower := strings.ToLower(text)
+	switch {
+	case strings.HasPrefix(text, "#") || (text[0] >= '0' && text[0] <= '9'):
+		value, err := strconv.ParseUint(strings.TrimPrefix(text, "#"), 0, 32)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", text)
+		}
+		p.pos++
+		return constant(value), nil
+	case utils.IsRegister(text):
+		r, err := utils.ParseRegister(utils.NormalizeRegister(lower))
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		p.pos++
+		return register(r), nil
+	case lower == "cpsr":
+		p.pos++
+		return cpsr{}, nil
+	case lower == "spsr":
+		p.pos++
+		return spsr{}, nil
+	case flagNames[lower] != 0:
+		p.pos++
+		return flag(flagNames[lower]), nil
+	}
+	if address, ok := p.label(text); ok {
+		p.pos++
+		return constant(address), nil
+	}
+	return nil, p.errorf("unknown symbol %q", text)
+}
@@ -0,0 +1,124 @@
+package debugger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Source maps the addresses of a linked program back to the lines of the file they were assembled from, using the
+// position every instruction's token carries.
+type Source struct {
+	File    string
+	lines   []string
+	byAddr  map[uint32]int    // Address of each instruction to its source line
+	byLine  map[int][]uint32  // Source line to the addresses of its instructions, in order
+	labels  map[string]uint32 // Every symbol in the image
+	symbols []linker.ImageSymbol
+}
+
+// NewSource builds the address map of a parsed file from where the linker placed its sections.
+func NewSource(file string, source string, instructions []types.Instruction, sections []types.Section, image *linker.Image) *Source {
+	s := &Source{
+		File:   file,
+		lines:  strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n"),
+		byAddr: make(map[uint32]int),
+		byLine: make(map[int][]uint32),
+		labels: make(map[string]uint32),
+	}
+
+	placed := make(map[string]uint32)
+	for _, section := range image.Sections {
+		for _, input := range section.Inputs {
+			if input.File == file {
+				placed[input.Section] = input.Address
+			}
+		}
+	}
+	for _, section := range sections {
+		address, ok := placed[section.Name]
+		if !ok {
+			continue
+		}
+		for i := section.Start; i < section.End; i++ {
+			size := types.Size(instructions[i])
+			if size == 0 {
+				continue // Padding that wasn't needed
+			}
+			line := instructions[i].SourceToken().Line
+			s.byAddr[address] = line
+			s.byLine[line] = append(s.byLine[line], address)
+			address += size
+		}
+	}
+
+	for _, symbol := range image.Symbols {
+		if symbol.File != "" && symbol.File != file {
+			continue
+		}
+		if _, ok := s.labels[symbol.Name]; !ok {
+			s.labels[symbol.Name] = symbol.Address
+		}
+		if symbol.File == file {
+			s.symbols = append(s.symbols, symbol) // Script symbols mark the ends of regions, not code
+		}
+	}
+	sort.SliceStable(s.symbols, func(i, j int) bool { return s.symbols[i].Address < s.symbols[j].Address })
+	return s
+}
+
+// Line returns the source line an address was assembled from.
+func (s *Source) Line(address uint32) (int, bool) {
+	line, ok := s.byAddr[address]
+	return line, ok
+}
+
+// Text returns a source line without its surrounding whitespace, or "" if it's out of range.
+func (s *Source) Text(line int) string {
+	if line < 1 || line > len(s.lines) {
+		return ""
+	}
+	return strings.TrimSpace(s.lines[line-1])
+}
+
+// Lines is the number of lines in the file.
+func (s *Source) Lines() int {
+	return len(s.lines)
+}
+
+// Address returns the address of the first instruction on a line.
+func (s *Source) Address(line int) (uint32, bool) {
+	addresses := s.byLine[line]
+	if len(addresses) == 0 {
+		return 0, false
+	}
+	return addresses[0], true
+}
+
+// Label looks up the address of a symbol.
+func (s *Source) Label(name string) (uint32, bool) {
+	address, ok := s.labels[name]
+	return address, ok
+}
+
+// Symbolize names an address after the closest symbol at or below it, like start or start+0x8. It returns "" if
+// there's no symbol below the address.
+func (s *Source) Symbolize(address uint32) string {
+	i := sort.Search(len(s.symbols), func(i int) bool { return s.symbols[i].Address > address })
+	if i == 0 {
+		return ""
+	}
+	symbol := s.symbols[i-1]
+	// Prefer the first of several names for the same address
+	for i > 1 && s.symbols[i-2].Address == symbol.Address {
+		i--
+		symbol = s.symbols[i-1]
+	}
+	if symbol.Address == address {
+		return symbol.Name
+	}
+	return fmt.Sprintf("%s+0x%X", symbol.Name, address-symbol.Address)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/robertjshirts/rogasmic/disasm"
+	"github.com/robertjshirts/rogasmic/format"
+)
+
+// runDisasm implements "rogasmic disasm [-a] [-b] [-T script.ld] [-base addr] [-o out.asm] file". The file can be a
+// source or object file, which is linked first so the disassembler knows where its Thumb code is, or a flat binary,
+// Intel HEX or S-record image. The text formats carry their own load address, and images are taken as ARM code.
+func runDisasm(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	addresses := flags.Bool("a", false, "print the address of every word")
+	raw := flags.Bool("b", false, "print the raw bytes of every word")
+	scriptFile := flags.String("T", "", "linker script for source and object files (default: built in script)")
+	base := flags.Uint("base", 0x8000, "load address of a flat binary")
+	outputFile := flags.String("o", "", "output file (default stdout)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: rogasmic disasm [-a] [-b] [-T script.ld] [-base addr] [-o out.asm] file")
+	}
+
+	options := disasm.Options{Addresses: *addresses, Bytes: *raw}
+	var data []byte
+	var address uint32
+	if linkable(flags.Arg(0)) {
+		_, image, err := linkFile(flags.Arg(0), *scriptFile)
+		if err != nil {
+			return err
+		}
+		data, address, options.Thumb = image.Data, image.Base, image.Thumb
+	} else {
+		contents, err := os.ReadFile(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		in := format.Detect(contents)
+		image, err := format.Read(bytes.NewReader(contents), in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", flags.Arg(0), err)
+		}
+		if in == format.FormatBinary {
+			image.Address = uint32(*base)
+		}
+		data, address = image.Data, image.Address
+	}
+
+	var out io.Writer = os.Stdout
+	if *outputFile != "" {
+		file, err := os.Create(*outputFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return disasm.Write(out, data, address, options)
+}
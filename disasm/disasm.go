@@ -0,0 +1,170 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// Options controls what's printed next to each instruction. Both go in a trailing comment so the output still
+// assembles.
+type Options struct {
+	Addresses bool          // Print the address of every word
+	Bytes     bool          // Print the raw bytes of every word, in memory order
+	Thumb     []types.Range // Addresses of Thumb code, like linker.Image.Thumb. Everything else is taken as ARM code
+}
+
+// Line is a single disassembled word, or a Thumb instruction.
+type Line struct {
+	Address     uint32
+	Word        uint32            // A Thumb instruction's first halfword is in the top half, like utils.ThumbFromBytes
+	Size        uint32            // 4 for ARM words, 2 or 4 for Thumb instructions
+	Thumb       bool              // Written as .hword values after a .thumb directive
+	Label       string            // Synthesized label if something branches here
+	Text        string            // Source text, .word for anything that doesn't decode
+	Instruction types.Instruction // Nil for words that don't decode
+}
+
+// Disassemble decodes an image loaded at base into source lines. Every branch target inside the image gets a
+// synthesized label, and anything the assembler couldn't have produced is kept as a .word so the output assembles
+// back into the same bytes. Thumb code isn't decoded, the instructions in the thumb ranges are kept as .hword values,
+// one line per instruction. Bytes outside them that aren't a whole aligned word, like data between two runs of Thumb
+// code, are kept as .hword too.
+func Disassemble(data []byte, base uint32, thumb []types.Range) ([]Line, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("image is %d bytes, not a whole number of words", len(data))
+	}
+	// thumbEnd returns the end of the Thumb code at address, or 0 if it's ARM code.
+	thumbEnd := func(address uint32) uint32 {
+		for _, r := range thumb {
+			if address >= r.Start && address < r.End {
+				return r.End
+			}
+		}
+		return 0
+	}
+	// armWord reports whether the 4 bytes at address can be an ARM word.
+	armWord := func(address uint32) bool {
+		if address%4 != 0 {
+			return false
+		}
+		for _, r := range thumb {
+			if r.Start < address+4 && r.End > address {
+				return false
+			}
+		}
+		return true
+	}
+
+	var lines []Line
+	starts := make(map[uint32]int) // Index of the ARM line starting at each address
+	for offset := uint32(0); offset < uint32(len(data)); {
+		line := Line{Address: base + offset, Size: 2, Thumb: true}
+		halfword := uint32(data[offset]) | uint32(data[offset+1])<<8
+		switch end := thumbEnd(line.Address); {
+		case end == 0 && armWord(line.Address):
+			line.Size, line.Thumb = 4, false
+			line.Word = uint32(data[offset]) | uint32(data[offset+1])<<8 | uint32(data[offset+2])<<16 | uint32(data[offset+3])<<24
+			if instruction, err := parser.Decode(line.Word); err == nil {
+				line.Instruction = instruction
+			}
+			starts[line.Address] = len(lines)
+		case end != 0 && halfword>>11 >= 0x1D && end-line.Address >= 4:
+			// The first halfword of a 32 bit Thumb instruction starts with 0b11101, 0b11110 or 0b11111
+			line.Size, line.Word = 4, utils.ThumbFromBytes(data[offset:offset+4])
+			line.Text = fmt.Sprintf(".hword 0x%04X, 0x%04X", line.Word>>16, line.Word&0xFFFF)
+		case end == 0:
+			line.Thumb, line.Word = false, halfword
+			line.Text = fmt.Sprintf(".hword 0x%04X", halfword)
+		default:
+			line.Word = halfword
+			line.Text = fmt.Sprintf(".hword 0x%04X", halfword)
+		}
+		lines = append(lines, line)
+		offset += line.Size
+	}
+
+	// Branches to Thumb code can't be written with a label, the label would make B an error and BL a BLX. BLX
+	// targets are Thumb code and keep their offset, BLX with a label would assemble to a BL.
+	labelled := func(branch *parser.InstructionBranch, address uint32) bool {
+		_, ok := starts[branch.Target(address)]
+		return ok && branch.Mnemonic != types.MnemonicBLX
+	}
+	for i := range lines {
+		if branch, ok := lines[i].Instruction.(*parser.InstructionBranch); ok && labelled(branch, lines[i].Address) {
+			target := &lines[starts[branch.Target(lines[i].Address)]]
+			target.Label = label(target.Address)
+		}
+	}
+
+	for i := range lines {
+		line := &lines[i]
+		if line.Text != "" {
+			continue
+		}
+		if line.Instruction == nil {
+			line.Text = fmt.Sprintf(".word 0x%08X", line.Word)
+			continue
+		}
+		if branch, ok := line.Instruction.(*parser.InstructionBranch); ok && labelled(branch, line.Address) {
+			branch.Label = types.Token{Type: types.TokenIdentifier, Literal: label(branch.Target(line.Address))}
+		}
+		line.Text = line.Instruction.String()
+	}
+
+	return lines, nil
+}
+
+// Write disassembles an image and writes it as source. Thumb code is preceded by .thumb and followed by .arm.
+func Write(w io.Writer, data []byte, base uint32, options Options) error {
+	lines, err := Disassemble(data, base, options.Thumb)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "; %d bytes at 0x%08X\n", len(data), base)
+	thumb := false
+	for _, line := range lines {
+		if line.Thumb != thumb {
+			thumb = line.Thumb
+			if thumb {
+				fmt.Fprintln(out, ".thumb")
+			} else {
+				fmt.Fprintln(out, ".arm")
+			}
+		}
+		if line.Label != "" {
+			fmt.Fprintf(out, "%s:\n", line.Label)
+		}
+
+		var comment []string
+		if options.Addresses {
+			comment = append(comment, fmt.Sprintf("%08X", line.Address))
+		}
+		if options.Bytes {
+			raw := data[line.Address-base : line.Address-base+line.Size]
+			hex := make([]string, len(raw))
+			for i, b := range raw {
+				hex[i] = fmt.Sprintf("%02X", b)
+			}
+			comment = append(comment, strings.Join(hex, " "))
+		}
+		if len(comment) == 0 {
+			fmt.Fprintf(out, "    %s\n", line.Text)
+		} else {
+			fmt.Fprintf(out, "    %-32s ; %s\n", line.Text, strings.Join(comment, "  "))
+		}
+	}
+	return out.Flush()
+}
+
+// label is the name given to a branch target.
+func label(address uint32) string {
+	return fmt.Sprintf("loc_%08X", address)
+}
@@ -0,0 +1,365 @@
+package disasm
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/assembler"
+	"github.com/robertjshirts/rogasmic/internal/emutest"
+	"github.com/robertjshirts/rogasmic/lexer"
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+func assemble(t *testing.T, source string) []byte {
+	t.Helper()
+	toks, err := lexer.NewLexer(source).Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error tokenizing: %v\n%s", err, source)
+	}
+	instructions, labels, err := parser.NewParser(toks).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v\n%s", err, source)
+	}
+	code, err := assembler.NewAssembler(instructions, labels).Assemble()
+	if err != nil {
+		t.Fatalf("unexpected error assembling: %v\n%s", err, source)
+	}
+	return code
+}
+
+func image(words ...uint32) []byte {
+	var data []byte
+	for _, word := range words {
+		data = append(data, utils.BitsToBytes(word)...)
+	}
+	return data
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name     string
+		word     uint32
+		expected string
+	}{
+		{name: "MOVW", word: 0xE3004000, expected: "MOVW R4, #0x0000"},
+		{name: "MOVT", word: 0xE3434F20, expected: "MOVT R4, #0x3F20"},
+		{name: "MOVW with condition", word: 0x53005FFF, expected: "MOVWPL R5, #0x0FFF"},
+		{name: "ADD", word: 0xE284301C, expected: "ADD R3, R4, #0x1C"},
+		{name: "SUBS", word: 0xE2555001, expected: "SUBS R5, R5, #0x1"},
+		{name: "ORR", word: 0xE3833008, expected: "ORR R3, R3, #0x8"},
+		{name: "AND with condition", word: 0x0201100F, expected: "ANDEQ R1, R1, #0xF"},
+		{name: "LDR without offset", word: 0xE5923000, expected: "LDR R3, [R2]"},
+		{name: "LDR with offset", word: 0xE5923008, expected: "LDR R3, [R2, #0x8]"},
+		{name: "LDR with offset and writeback", word: 0xE5B23008, expected: "LDR R3, [R2, #0x8]!"},
+		{name: "LDR with writeback", word: 0xE53D6004, expected: "LDR R6, [SP]!, #0x4"},
+		{name: "LDR pre indexed", word: 0xE5126008, expected: "LDR R6, [R2], #0x8"},
+		{name: "STR without offset", word: 0xE5823000, expected: "STR R3, [R2]"},
+		{name: "STR post indexed", word: 0xE48D5004, expected: "STR R5, [SP], #0x4"},
+		{name: "STRT", word: 0xE4AD5004, expected: ".word 0xE4AD5004"},
+		{name: "STM", word: 0xE8AD1FFF, expected: "STMEA SP!, {R0-R12}"},
+		{name: "LDM", word: 0xE93D1FFF, expected: "LDMEA SP!, {R0-R12}"},
+		{name: "LDM with condition and short runs", word: 0xC9104003, expected: "LDMGT R0, {R0, R1, LR}"},
+		{name: "BX", word: 0xE12FFF1E, expected: "BX LR"},
+		{name: "BX with condition", word: 0x012FFF13, expected: "BXEQ R3"},
+		{name: "BLX register", word: 0xE12FFF3C, expected: "BLX R12"},
+		{name: "BLX to the PC", word: 0xE12FFF3F, expected: ".word 0xE12FFF3F"},
+		{name: "BLX inside the image keeps its offset", word: 0xFAFFFFFE, expected: "BLX #0x1FFFFFC"},
+		{name: "SVC", word: 0xEF123456, expected: "SVC #0x123456"},
+		{name: "MRS", word: 0xE10F0000, expected: "MRS R0, CPSR"},
+		{name: "MRS SPSR", word: 0x414F3000, expected: "MRSMI R3, SPSR"},
+		{name: "MSR", word: 0xE121F000, expected: "MSR CPSR_c, R0"},
+		{name: "MSR SPSR", word: 0xE16FF00E, expected: "MSR SPSR_fsxc, LR"},
+		{name: "MSR immediate", word: 0xE328F20F, expected: "MSR CPSR_f, #0xF0000000"},
+		{name: "CPSID", word: 0xF10C0080, expected: "CPSID i"},
+		{name: "CPSIE with mode", word: 0xF10A01D3, expected: "CPSIE aif, #0x13"},
+		{name: "CPS", word: 0xF102001F, expected: "CPS #0x1F"},
+		{name: "SETEND", word: 0xF1010200, expected: "SETEND BE"},
+		{name: "MSR with no fields", word: 0xE320F000, expected: "NOP"},
+		{name: "unknown hint", word: 0xE320F005, expected: ".word 0xE320F005"},
+		{name: "WFI", word: 0x0320F003, expected: "WFIEQ"},
+		{name: "DMB", word: 0xF57FF05B, expected: "DMB ISH"},
+		{name: "DSB reserved option", word: 0xF57FF040, expected: "DSB #0x0"},
+		{name: "ISB", word: 0xF57FF06F, expected: "ISB SY"},
+		{name: "CLREX", word: 0xF57FF01F, expected: "CLREX"},
+		{name: "LDREX", word: 0xE1910F9F, expected: "LDREX R0, [R1]"},
+		{name: "STREX", word: 0xE1842F93, expected: "STREX R2, R3, [R4]"},
+		{name: "STREX status is the value", word: 0xE1842F92, expected: ".word 0xE1842F92"},
+		{name: "CLZ", word: 0xE16F0F11, expected: "CLZ R0, R1"},
+		{name: "REV16", word: 0x16BF2FB3, expected: "REV16NE R2, R3"},
+		{name: "UXTB with rotation", word: 0xE6EF0871, expected: "UXTB R0, R1, ROR #16"},
+		{name: "UBFX", word: 0xE7E70251, expected: "UBFX R0, R1, #4, #8"},
+		{name: "BFI", word: 0xE7CB0211, expected: "BFI R0, R1, #4, #8"},
+		{name: "BFC", word: 0xE7CB021F, expected: ".word 0xE7CB021F"},
+		{name: "SDIV", word: 0xE710F211, expected: "SDIV R0, R1, R2"},
+		{name: "VADD", word: 0xEE300A81, expected: "VADD.F32 S0, S1, S2"},
+		{name: "VDIV with condition", word: 0x0EC1FB20, expected: "VDIVEQ.F64 D31, D1, D16"},
+		{name: "VCVT", word: 0xEEBD0BC1, expected: "VCVT.S32.F64 S0, D1"},
+		{name: "VABS", word: 0xEEB00AC0, expected: ".word 0xEEB00AC0"},
+		{name: "VMOV double", word: 0xEC543B15, expected: "VMOV R3, R4, D5"},
+		{name: "VMRS flags", word: 0xEEF1FA10, expected: "VMRS APSR_nzcv, FPSCR"},
+		{name: "VMSR", word: 0xEEE81A10, expected: "VMSR FPEXC, R1"},
+		{name: "VLDR", word: 0xEDD11A02, expected: "VLDR S3, [R1, #8]"},
+		{name: "VLDR negative offset", word: 0xED511A02, expected: ".word 0xED511A02"},
+		{name: "NEON VSUB", word: 0xF334286E, expected: "VSUB.I64 Q1, Q2, Q15"},
+		{name: "VLD1", word: 0xF421068F, expected: "VLD1.32 {D0-D2}, [R1]"},
+		{name: "VST1 with index", word: 0xF4024A84, expected: "VST1.32 {D4, D5}, [R2], R4"},
+		{name: "MRC", word: 0xEE100FB0, expected: "MRC p15, 0, R0, c0, c0, 5"},
+		{name: "MCRR", word: 0x1C410F02, expected: "MCRRNE p15, 0, R0, R1, c2"},
+		{name: "unknown CP15 register", word: 0xEE190F19, expected: ".word 0xEE190F19"},
+		{name: "branch outside the image", word: 0xEA000010, expected: "B #0x000010"},
+		{name: "BL outside the image", word: 0x5BFFFF00, expected: "BLPL #0xFFFF00"},
+		{name: "unsupported condition", word: 0xF3004000, expected: ".word 0xF3004000"},
+		{name: "MOVW with NE condition", word: 0x13004000, expected: "MOVWNE R4, #0x0000"},
+		{name: "ADD with rotated immediate", word: 0xE280043F, expected: "ADD R0, R0, #0x3F000000"},
+		{name: "non-canonical immediate", word: 0xE2811F01, expected: ".word 0xE2811F01"},
+		{name: "BLT", word: 0xBAFFFFFE, expected: "BLT loc_00008000"},
+		{name: "register offset", word: 0xE7923001, expected: ".word 0xE7923001"},
+		{name: "byte transfer", word: 0xE4D23000, expected: ".word 0xE4D23000"},
+		{name: "unsupported LDM mode", word: 0xE8BD1FFF, expected: ".word 0xE8BD1FFF"},
+		{name: "unsupported opcode", word: 0xE3A00001, expected: ".word 0xE3A00001"},
+		{name: "register operand", word: 0xE0821003, expected: ".word 0xE0821003"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lines, err := Disassemble(image(tc.word), 0x8000, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if lines[0].Text != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, lines[0].Text)
+			}
+			if (lines[0].Instruction == nil) != strings.HasPrefix(tc.expected, ".word") {
+				t.Errorf("expected only .word lines to have no instruction, got %v", lines[0].Instruction)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	cases := []struct {
+		name     string
+		words    []uint32
+		options  Options
+		expected string
+	}{
+		{
+			name:  "synthesized labels",
+			words: []uint32{0xE2555001, 0x5AFFFFFD, 0xEBFFFFFC, 0xE12FFF1E},
+			expected: "; 16 bytes at 0x00008000\n" +
+				"loc_00008000:\n" +
+				"    SUBS R5, R5, #0x1\n" +
+				"    BPL loc_00008000\n" +
+				"    BL loc_00008000\n" +
+				"    BX LR\n",
+		},
+		{
+			name:    "addresses and bytes",
+			words:   []uint32{0xEAFFFFFE, 0x12345678},
+			options: Options{Addresses: true, Bytes: true},
+			expected: "; 8 bytes at 0x00008000\n" +
+				"loc_00008000:\n" +
+				"    B loc_00008000                   ; 00008000  FE FF FF EA\n" +
+				"    .word 0x12345678                 ; 00008004  78 56 34 12\n",
+		},
+		{
+			name:    "addresses only",
+			words:   []uint32{0xE12FFF1E},
+			options: Options{Addresses: true},
+			expected: "; 4 bytes at 0x00008000\n" +
+				"    BX LR                            ; 00008000\n",
+		},
+		{
+			name:    "Thumb code",
+			words:   []uint32{0xEAFFFFFF, 0xF1091C40, 0x477028FF, 0xE12FFF1E},
+			options: Options{Bytes: true, Thumb: []types.Range{{Start: 0x8004, End: 0x800C}}},
+			expected: "; 16 bytes at 0x00008000\n" +
+				"    B #0xFFFFFF                      ; FF FF FF EA\n" +
+				".thumb\n" +
+				"    .hword 0x1C40                    ; 40 1C\n" +
+				"    .hword 0xF109, 0x28FF            ; 09 F1 FF 28\n" +
+				"    .hword 0x4770                    ; 70 47\n" +
+				".arm\n" +
+				"    BX LR                            ; 1E FF 2F E1\n",
+		},
+		{
+			name:    "data between Thumb code",
+			words:   []uint32{0x78564770, 0xBF001234},
+			options: Options{Thumb: []types.Range{{Start: 0x8000, End: 0x8002}, {Start: 0x8006, End: 0x8008}}},
+			expected: "; 8 bytes at 0x00008000\n" +
+				".thumb\n" +
+				"    .hword 0x4770\n" +
+				".arm\n" +
+				"    .hword 0x7856\n" +
+				"    .hword 0x1234\n" +
+				".thumb\n" +
+				"    .hword 0xBF00\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, image(tc.words...), 0x8000, tc.options); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteOddLength(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, []byte{1, 2, 3}, 0x8000, Options{}); err == nil {
+		t.Errorf("expected error for an image that isn't a whole number of words")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+	}{
+		{
+			name: "blink loop",
+			source: `MOVW R4, #0x0000
+MOVT R4, #0x3F20
+ADD R2, R4, #0x8
+LDR R3, [R2]
+ORR R3, R3, #0x8
+STR R3, [R2]
+start:
+ADD R3, R4, #0x1C
+STMEA sp!, {R0-R12}
+STR R5, [sp], #4
+BL delay
+LDMEA sp!, {R0-R12}
+LDR R6, [sp]!, #4
+B start
+delay:
+SUBS R6, R6, #0x01
+BPL delay
+BX lr`,
+		},
+		{
+			name:   "data and pseudo-instructions",
+			source: "MOV32 R0, #0x3F200000\nBGT end\n.word 0xFFFFFFFF, 0, 0xE3A00001\nend:\nBXEQ R0",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code := assemble(t, tc.source)
+			var buf bytes.Buffer
+			if err := Write(&buf, code, 0x8000, Options{Addresses: true, Bytes: true}); err != nil {
+				t.Fatalf("unexpected error disassembling: %v", err)
+			}
+			reassembled := assemble(t, buf.String())
+			if !bytes.Equal(code, reassembled) {
+				t.Errorf("reassembled image differs\noriginal:    %X\nreassembled: %X\n%s", code, reassembled, buf.String())
+			}
+		})
+	}
+}
+
+// TestThumbRoundTrip links mixed ARM and Thumb code so the disassembler gets the image's Thumb mapping, and checks
+// the output assembles back to the same image.
+func TestThumbRoundTrip(t *testing.T) {
+	source := `start:
+MOVW R0, #1
+BL f
+loop: B loop
+.thumb
+.thumb_func
+f:
+ADDS R0, R0, #1
+ADD R8, R9, #0xFF00FF00
+BEQ f
+BX LR
+.word 0x12345678
+NOP
+.arm
+BX lr
+`
+	_, image := emutest.Link(t, source)
+	if len(image.Thumb) == 0 {
+		t.Fatalf("expected the image to have Thumb code")
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, image.Data, image.Base, Options{Thumb: image.Thumb}); err != nil {
+		t.Fatalf("unexpected error disassembling: %v", err)
+	}
+	for _, expected := range []string{".thumb\n    .hword 0x1C40\n    .hword 0xF109, 0x28FF\n", ".arm\n    BX LR\n"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+	reassembled := assemble(t, buf.String())
+	if !bytes.Equal(image.Data, reassembled) {
+		t.Errorf("reassembled image differs\noriginal:    %X\nreassembled: %X\n%s", image.Data, reassembled, buf.String())
+	}
+}
+
+// TestRandomRoundTrip disassembles random words, which mostly end up as .word, along with words built from the
+// fields of every instruction family the assembler knows, and checks the output assembles back to the same image.
+func TestRandomRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	conditions := []uint32{0x0, 0x1, 0x3, 0x5, 0x8, 0x9, 0xB, 0xC, 0xD, 0xE}
+	families := []func() uint32{
+		func() uint32 { return rng.Uint32() },
+		func() uint32 { return 0x03000000 | rng.Uint32()&0x004FFFFF },                               // MOVW, MOVT
+		func() uint32 { return 0x02000000 | rng.Uint32()&0x01FFFFFF },                               // Data processing
+		func() uint32 { return 0x04000000 | rng.Uint32()&0x03FFFFFF },                               // LDR, STR
+		func() uint32 { return 0x08000000 | rng.Uint32()&0x01FFFFFF },                               // LDM, STM
+		func() uint32 { return 0x0A000000 | rng.Uint32()&0x01000000 | (rng.Uint32()%8-4)&0xFFFFFF }, // B, BL
+		func() uint32 { return 0x0A000000 | rng.Uint32()&0x01FFFFFF },                               // B, BL anywhere
+		func() uint32 { return 0x012FFF10 | rng.Uint32()&0xF },                                      // BX
+		func() uint32 { return 0x012FFF30 | rng.Uint32()&0xF },                                      // BLX register
+		func() uint32 { return 0xFA000000 | rng.Uint32()&0x01FFFFFF },                               // BLX immediate, any condition keeps 0xF
+		func() uint32 { return 0x0F000000 | rng.Uint32()&0xFFFFFF },                                 // SVC
+		func() uint32 { return 0x010F0000 | rng.Uint32()&0x0040F000 },                               // MRS
+		func() uint32 { return 0x0120F000 | rng.Uint32()&0x024F0FFF },                               // MSR
+		func() uint32 { return 0xF1000000 | rng.Uint32()&0x000F03DF },                               // CPS, SETEND
+		func() uint32 { return 0x0E000F10 | rng.Uint32()&0x00FFF0EF },                               // MCR, MRC
+		func() uint32 { return 0x0C400F00 | rng.Uint32()&0x001FF0FF },                               // MCRR, MRRC
+		func() uint32 { return 0xF57FF000 | rng.Uint32()&0x7F },                                     // DMB, DSB, ISB, CLREX
+		func() uint32 { return 0x0320F000 | rng.Uint32()&0x7 },                                      // Hints
+		func() uint32 { return 0x01800F90 | rng.Uint32()&0x001FF00F },                               // LDREX, STREX
+		func() uint32 { return 0x016F0F10 | rng.Uint32()&0x0000F00F },                               // CLZ
+		func() uint32 { return 0x06BF0F30 | rng.Uint32()&0x0000F08F },                               // REV, REV16
+		func() uint32 { return 0x06AF0070 | rng.Uint32()&0x0050FC0F },                               // Extends
+		func() uint32 { return 0x07A00050 | rng.Uint32()&0x005FFF8F },                               // UBFX, SBFX
+		func() uint32 { return 0x07C00010 | rng.Uint32()&0x001FFF8F },                               // BFI
+		func() uint32 { return 0x0710F010 | rng.Uint32()&0x002F0F0F },                               // SDIV, UDIV
+		func() uint32 { return 0x0E000A00 | rng.Uint32()&0x00FFF1EF },                               // VFP data processing
+		func() uint32 { return 0x0EB00A40 | rng.Uint32()&0x004FF1AF },                               // VMOV, VCVT
+		func() uint32 { return 0x0E000A10 | rng.Uint32()&0x001FF080 },                               // VMOV single
+		func() uint32 { return 0x0C400B10 | rng.Uint32()&0x001FF02F },                               // VMOV double
+		func() uint32 { return 0x0EE00A10 | rng.Uint32()&0x001FF000 },                               // VMRS, VMSR
+		func() uint32 { return 0x0D000A00 | rng.Uint32()&0x00FFF1FF },                               // VLDR, VSTR
+		func() uint32 { return 0xF2000800 | rng.Uint32()&0x017FF0EF },                               // NEON VADD, VSUB
+		func() uint32 { return 0xF4000000 | rng.Uint32()&0x006FFFFF },                               // VLD1, VST1
+	}
+
+	for run := 0; run < 200; run++ {
+		words := make([]uint32, 16)
+		for i := range words {
+			words[i] = families[rng.Intn(len(families))]() | conditions[rng.Intn(len(conditions))]<<28
+		}
+		code := image(words...)
+
+		var buf bytes.Buffer
+		if err := Write(&buf, code, 0x8000, Options{}); err != nil {
+			t.Fatalf("unexpected error disassembling: %v", err)
+		}
+		reassembled := assemble(t, buf.String())
+		if !bytes.Equal(code, reassembled) {
+			t.Fatalf("reassembled image differs\noriginal:    %X\nreassembled: %X\n%s", code, reassembled, buf.String())
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package emu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Mode is a processor mode, the bottom 5 bits of the CPSR.
+type Mode uint32
+
+const (
+	ModeUser       Mode = 0x10
+	ModeFIQ        Mode = 0x11
+	ModeIRQ        Mode = 0x12
+	ModeSupervisor Mode = 0x13
+	ModeAbort      Mode = 0x17
+	ModeUndefined  Mode = 0x1B
+	ModeSystem     Mode = 0x1F
+)
+
+var modeNames = map[Mode]string{
+	ModeUser:       "usr",
+	ModeFIQ:        "fiq",
+	ModeIRQ:        "irq",
+	ModeSupervisor: "svc",
+	ModeAbort:      "abt",
+	ModeUndefined:  "und",
+	ModeSystem:     "sys",
+}
+
+func (m Mode) String() string {
+	if name, ok := modeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", uint32(m))
+}
+
+// CPSR bits
+const (
+	FlagN    uint32 = 1 << 31
+	FlagZ    uint32 = 1 << 30
+	FlagC    uint32 = 1 << 29
+	FlagV    uint32 = 1 << 28
+	FlagE    uint32 = 1 << 9 // Big endian data accesses
+	FlagA    uint32 = 1 << 8 // Asynchronous aborts masked
+	FlagI    uint32 = 1 << 7 // IRQs masked
+	FlagF    uint32 = 1 << 6 // FIQs masked
+	FlagT    uint32 = 1 << 5 // Thumb state
+	ModeMask uint32 = 0x1F
+)
+
+// Register numbers with special meanings
+const (
+	SP = 13
+	LR = 14
+	PC = 15
+)
+
+// ErrStepLimit is returned by Run when the program is still going after the step limit.
+var ErrStepLimit = errors.New("step limit reached")
+
+// Fault is an error executing the instruction at Address.
+type Fault struct {
+	Address uint32
+	Word    uint32
+	Reason  string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%s at 0x%08X (0x%08X)", f.Reason, f.Address, f.Word)
+}
+
+// bank is the SP, LR and SPSR of a mode. User and system mode share one, and don't have an SPSR.
+type bank struct {
+	sp   uint32
+	lr   uint32
+	spsr uint32
+}
+
+// CPU is a single ARMv7-A core running in ARM state.
+type CPU struct {
+	R      [16]uint32 // Registers of the current mode, R15 is the address of the next instruction to run
+	CPSR   uint32
+	Memory *Memory // Shared with the other cores, if there are any
+	Steps  uint64  // Instructions executed so far, including ones whose condition failed or that raised an exception
+	ID     uint32  // Core number, which the MPIDR reports
+
+	// FastForward skips countdown delay loops (SUBS then a conditional branch back to it) in one step, and with
+	// interrupts unmasked jumps a branch to itself ahead to the next interrupt. The registers and Steps end up as if
+	// every iteration ran.
+	FastForward bool
+
+	Halted     bool
+	HaltReason string
+
+	// VBAR is the base of the exception vector table, 0 after reset like the CP15 register it stands for.
+	VBAR uint32
+	// IRQ and FIQ are the interrupt lines, taken before the next instruction while they're set and not masked.
+	IRQ bool
+	FIQ bool
+	// InterruptLines, if set, drives IRQ and FIQ, e.g. from an interrupt controller. It's polled before every
+	// instruction that runs with IRQs or FIQs unmasked.
+	InterruptLines func() (irq bool, fiq bool)
+	// NextInterrupt, if set, returns the value of Steps at which the interrupt lines can next change by themselves,
+	// e.g. when a timer fires, or 0 if nothing is counting. Fast-forwarding with interrupts unmasked stops there.
+	NextInterrupt func() uint64
+	// FaultAddress is the address of the last access that raised a data abort, like the CP15 DFAR.
+	FaultAddress uint32
+	// SupervisorCall, if set, is offered every SVC whose condition passes, with its 24 bit number, before the
+	// exception is taken. Returning true handles it in Go, e.g. as a semihosting call, and the core carries on after
+	// the SVC instead. An error stops Run.
+	SupervisorCall func(number uint32) (handled bool, err error)
+	// ExceptionHook, if set, is called whenever an exception is taken, with the address of the instruction that
+	// raised it or, for interrupts, of the one that would have run next.
+	ExceptionHook func(e Exception, address uint32)
+	// Event is the event register, which SEV and exception returns set and WFE waits for and clears.
+	Event bool
+	// SendEvent, if set, is called by SEV to signal an event to every core, this one included. Without it SEV only
+	// sets this core's Event.
+	SendEvent func()
+	// D is the VFP and NEON register file. S2n and S2n+1 are the low and high halves of Dn, and Qn is D2n and D2n+1.
+	D [32]uint64
+	// FPSCR holds the VFP flags and modes, and FPEXC whether VFP and NEON are enabled, 0 after reset.
+	FPSCR uint32
+	FPEXC uint32
+
+	banks   map[Mode]*bank
+	fiqHigh [5]uint32 // R8-R12 of the mode that isn't current: FIQ mode's outside it, everyone else's inside it
+	haltAt  map[uint32]bool
+	decoded map[uint32]decodedWord
+	cp15    map[string]uint64       // CP15 registers that just hold what's written to them, by name
+	current uint32                  // Address of the instruction being executed
+	waiting *parser.InstructionHint // The WFI or WFE the core is asleep in, nil while it's running
+	polled  uint64                  // Steps+1 when InterruptLines was last polled, so it's only polled once per instruction
+
+	stepLimit uint64 // Steps Run stops at, 0 if there's no limit
+}
+
+// decodedWord caches the decoded instruction at an address, as long as memory there still holds word.
+type decodedWord struct {
+	word        uint32
+	instruction types.Instruction
+}
+
+// New creates a CPU in the reset state: supervisor mode with IRQs and FIQs masked, executing from address 0.
+func New(memory *Memory) *CPU {
+	c := &CPU{
+		Memory:  memory,
+		CPSR:    uint32(ModeSupervisor) | FlagI | FlagF,
+		banks:   make(map[Mode]*bank),
+		haltAt:  make(map[uint32]bool),
+		decoded: make(map[uint32]decodedWord),
+		cp15:    make(map[string]uint64),
+	}
+	for _, mode := range []Mode{ModeUser, ModeFIQ, ModeIRQ, ModeSupervisor, ModeAbort, ModeUndefined} {
+		c.banks[mode] = &bank{}
+	}
+	c.banks[ModeSystem] = c.banks[ModeUser]
+	return c
+}
+
+// Mode returns the current processor mode.
+func (c *CPU) Mode() Mode {
+	return Mode(c.CPSR & ModeMask)
+}
+
+// SetMode switches mode, swapping the banked SP and LR, and R8-R12 when entering or leaving FIQ mode.
+func (c *CPU) SetMode(mode Mode) error {
+	to, ok := c.banks[mode]
+	if !ok {
+		return fmt.Errorf("invalid mode 0x%02X", uint32(mode))
+	}
+	from := c.banks[c.Mode()]
+	from.sp, from.lr = c.R[SP], c.R[LR]
+	c.R[SP], c.R[LR] = to.sp, to.lr
+	if (c.Mode() == ModeFIQ) != (mode == ModeFIQ) {
+		high := c.fiqHigh
+		copy(c.fiqHigh[:], c.R[8:13])
+		copy(c.R[8:13], high[:])
+	}
+	c.CPSR = c.CPSR&^ModeMask | uint32(mode)
+	return nil
+}
+
+// SPSR returns the saved program status register of the current mode. User and system mode don't have one.
+func (c *CPU) SPSR() (uint32, bool) {
+	if c.banks[c.Mode()] == c.banks[ModeUser] {
+		return 0, false
+	}
+	return c.banks[c.Mode()].spsr, true
+}
+
+// SetSPSR writes the SPSR of the current mode, reporting false in user and system mode.
+func (c *CPU) SetSPSR(value uint32) bool {
+	if c.banks[c.Mode()] == c.banks[ModeUser] {
+		return false
+	}
+	c.banks[c.Mode()].spsr = value
+	return true
+}
+
+// userRegister returns where the user mode copy of a register lives, for LDM and STM with ^. The PC isn't banked.
+func (c *CPU) userRegister(r uint32) *uint32 {
+	user := c.banks[ModeUser]
+	switch {
+	case r >= 8 && r <= 12 && c.Mode() == ModeFIQ:
+		return &c.fiqHigh[r-8]
+	case r == SP && c.banks[c.Mode()] != user:
+		return &user.sp
+	case r == LR && c.banks[c.Mode()] != user:
+		return &user.lr
+	}
+	return &c.R[r]
+}
+
+// Banked returns the SP and LR of mode, whether or not it's the current one.
+func (c *CPU) Banked(mode Mode) (sp uint32, lr uint32) {
+	if c.banks[mode] == c.banks[c.Mode()] {
+		return c.R[SP], c.R[LR]
+	}
+	if b, ok := c.banks[mode]; ok {
+		return b.sp, b.lr
+	}
+	return 0, 0
+}
+
+// WriteRegisters prints the registers four to a line, then the CPSR with its flags (capitalized when set) and mode,
+// and the SPSR in modes that have one.
+func (c *CPU) WriteRegisters(w io.Writer) {
+	names := []string{"R0", "R1", "R2", "R3", "R4", "R5", "R6", "R7", "R8", "R9", "R10", "R11", "R12", "SP", "LR", "PC"}
+	for i, name := range names {
+		fmt.Fprintf(w, "%-3s 0x%08X", name, c.R[i])
+		if i%4 == 3 {
+			fmt.Fprintln(w)
+		} else {
+			fmt.Fprint(w, "  ")
+		}
+	}
+	fmt.Fprintf(w, "CPSR 0x%08X  %s  %s", c.CPSR, Flags(c.CPSR), c.Mode())
+	if spsr, ok := c.SPSR(); ok {
+		fmt.Fprintf(w, "  SPSR 0x%08X  %s  %s", spsr, Flags(spsr), Mode(spsr&ModeMask))
+	}
+	fmt.Fprintln(w)
+}
+
+// Flags spells out the NZCV flags of a CPSR value, capitalized when set, e.g. nZCv.
+func Flags(cpsr uint32) string {
+	flags := []byte("nzcv")
+	for i, flag := range []uint32{FlagN, FlagZ, FlagC, FlagV} {
+		if cpsr&flag != 0 {
+			flags[i] -= 'a' - 'A'
+		}
+	}
+	return string(flags)
+}
+
+// HaltAt stops Run before the instruction at address executes.
+func (c *CPU) HaltAt(address uint32) {
+	c.haltAt[address] = true
+}
+
+// ClearHaltAt removes an address added with HaltAt.
+func (c *CPU) ClearHaltAt(address uint32) {
+	delete(c.haltAt, address)
+}
+
+// Halt stops the CPU, Run returns after the current instruction.
+func (c *CPU) Halt(reason string) {
+	c.Halted = true
+	c.HaltReason = reason
+}
+
+// Flag reports whether a CPSR flag is set.
+func (c *CPU) Flag(flag uint32) bool {
+	return c.CPSR&flag != 0
+}
+
+func (c *CPU) setFlag(flag uint32, set bool) {
+	if set {
+		c.CPSR |= flag
+	} else {
+		c.CPSR &^= flag
+	}
+}
+
+// setNZ sets N and Z from a result.
+func (c *CPU) setNZ(result uint32) {
+	c.setFlag(FlagN, result&(1<<31) != 0)
+	c.setFlag(FlagZ, result == 0)
+}
+
+// ConditionPassed evaluates a condition against the current flags.
+func (c *CPU) ConditionPassed(condition types.ConditionType) bool {
+	n, z, cf, v := c.Flag(FlagN), c.Flag(FlagZ), c.Flag(FlagC), c.Flag(FlagV)
+	switch condition {
+	case types.ConditionEQ:
+		return z
+	case types.ConditionNE:
+		return !z
+	case types.ConditionCS:
+		return cf
+	case types.ConditionCC:
+		return !cf
+	case types.ConditionMI:
+		return n
+	case types.ConditionPL:
+		return !n
+	case types.ConditionVS:
+		return v
+	case types.ConditionVC:
+		return !v
+	case types.ConditionHI:
+		return cf && !z
+	case types.ConditionLS:
+		return !cf || z
+	case types.ConditionGE:
+		return n == v
+	case types.ConditionLT:
+		return n != v
+	case types.ConditionGT:
+		return !z && n == v
+	case types.ConditionLE:
+		return z || n != v
+	default:
+		return true
+	}
+}
+
+// Run executes instructions until the CPU halts, faults or has executed limit more instructions. A limit of 0 means no
+// limit. Halting returns nil, running out of steps returns ErrStepLimit and anything else is a *Fault.
+func (c *CPU) Run(limit uint64) error {
+	c.stepLimit = 0
+	if limit != 0 {
+		c.stepLimit = c.Steps + limit
+	}
+	defer func() { c.stepLimit = 0 }()
+
+	for c.stepLimit == 0 || c.Steps < c.stepLimit {
+		if c.Halted {
+			return nil
+		}
+		c.TakeInterrupt() // First, so breakpoints in handlers are hit
+		if c.waiting == nil && c.haltAt[c.R[PC]] {
+			c.Halt(fmt.Sprintf("reached 0x%08X", c.R[PC]))
+			return nil
+		}
+		if c.FastForward && (c.fastForwardIdle() || c.fastForward()) {
+			continue
+		}
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	if c.Halted {
+		return nil
+	}
+	return ErrStepLimit
+}
@@ -0,0 +1,1061 @@
+package emu
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/linker"
+)
+
+// build assembles and links source at the default address.
+func build(t *testing.T, source string) *linker.Image {
+	t.Helper()
+	_, image, err := linker.LinkSource("test.asm", source)
+	if err != nil {
+		t.Fatalf("unexpected error building: %v", err)
+	}
+	return image
+}
+
+// load assembles and links source at the default address and loads it into a new CPU.
+func load(t *testing.T, source string) *CPU {
+	t.Helper()
+	c, _, err := LoadSource("test.asm", source)
+	if err != nil {
+		t.Fatalf("unexpected error building: %v", err)
+	}
+	return c
+}
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		name      string
+		source    string
+		registers map[int]uint32
+		flags     uint32 // Expected NZCV
+		memory    map[uint32]uint32
+	}{
+		{
+			name:      "MOVW and MOVT",
+			source:    "MOVW R0, #0x5678\nMOVT R0, #0x1234\nMOV32 R1, #0xCAFEF00D\nend: B end",
+			registers: map[int]uint32{0: 0x12345678, 1: 0xCAFEF00D},
+		},
+		{
+			name:      "ADD and SUB",
+			source:    "MOVW R0, #10\nADD R1, R0, #0x3F000000\nSUB R2, R0, #3\nend: B end",
+			registers: map[int]uint32{1: 0x3F00000A, 2: 7},
+		},
+		{
+			name:      "SUBS to zero",
+			source:    "MOVW R0, #1\nSUBS R0, R0, #1\nend: B end",
+			registers: map[int]uint32{0: 0},
+			flags:     FlagZ | FlagC,
+		},
+		{
+			name:      "SUBS borrow",
+			source:    "MOVW R0, #0\nSUBS R0, R0, #1\nend: B end",
+			registers: map[int]uint32{0: 0xFFFFFFFF},
+			flags:     FlagN,
+		},
+		{
+			name:      "ADDS carry",
+			source:    "MOV32 R0, #0xFFFFFFFF\nADDS R0, R0, #1\nend: B end",
+			registers: map[int]uint32{0: 0},
+			flags:     FlagZ | FlagC,
+		},
+		{
+			name:      "ADDS overflow",
+			source:    "MOV32 R0, #0x7FFFFFFF\nADDS R0, R0, #1\nend: B end",
+			registers: map[int]uint32{0: 0x80000000},
+			flags:     FlagN | FlagV,
+		},
+		{
+			name:      "ANDS and ORRS",
+			source:    "MOVW R0, #0xF0\nANDS R1, R0, #0x0F\nORRS R2, R0, #0xF0000000\nend: B end",
+			registers: map[int]uint32{1: 0, 2: 0xF00000F0},
+			flags:     FlagN | FlagC,
+		},
+		{
+			name:      "conditions",
+			source:    "MOVW R0, #5\nSUBS R1, R0, #7\nMOVWLT R2, #1\nMOVWGE R3, #1\nMOVWNE R4, #1\nMOVWEQ R5, #1\nMOVWLO R6, #1\nMOVWHI R7, #1\nend: B end",
+			registers: map[int]uint32{2: 1, 3: 0, 4: 1, 5: 0, 6: 1, 7: 0},
+			flags:     FlagN,
+		},
+		{
+			name:      "loop",
+			source:    "MOVW R0, #10\nMOVW R1, #0\nloop:\nADD R1, R1, #2\nSUBS R0, R0, #1\nBNE loop\nend: B end",
+			registers: map[int]uint32{0: 0, 1: 20},
+			flags:     FlagZ | FlagC,
+		},
+		{
+			name:      "BL and BX",
+			source:    "BL double\nend: B end\ndouble:\nADD R0, R0, #4\nBX lr",
+			registers: map[int]uint32{0: 4, 14: 0x8004},
+		},
+		{
+			name:      "BLX register",
+			source:    "MOV32 R1, double\nBLX R1\nend: B end\ndouble:\nADD R0, R0, #4\nBX lr",
+			registers: map[int]uint32{0: 4, 14: 0x800C},
+		},
+		{
+			name:      "BLX reads the register before setting LR",
+			source:    "MOV32 lr, double\nBLX lr\nend: B end\ndouble:\nADD R0, R0, #4\nBX lr",
+			registers: map[int]uint32{0: 4, 14: 0x800C},
+		},
+		{
+			name:      "LDR and STR",
+			source:    "MOVW R0, #0x9000\nMOVW R1, #0x55\nSTR R1, [R0]\nLDR R2, [R0]\nSTR R1, [R0], #4\nLDR R3, [R0]!, #4\nend: B end",
+			registers: map[int]uint32{0: 0x9000, 2: 0x55, 3: 0x55},
+			memory:    map[uint32]uint32{0x9000: 0x55},
+		},
+		{
+			name:      "STMEA and LDMEA",
+			source:    "MOVW sp, #0x9000\nMOVW R0, #1\nMOVW R1, #2\nMOVW R2, #3\nSTMEA sp!, {R0-R2}\nMOVW R0, #0\nMOVW R1, #0\nMOVW R2, #0\nLDMEA sp!, {R0-R2}\nend: B end",
+			registers: map[int]uint32{0: 1, 1: 2, 2: 3, 13: 0x9000},
+			memory:    map[uint32]uint32{0x9000: 1, 0x9004: 2, 0x9008: 3},
+		},
+		{
+			name:      "CLZ, REV and REV16",
+			source:    "MOVW R0, #0xF0\nCLZ R1, R0\nCLZ R2, R3\nMOV32 R0, #0x12345678\nREV R4, R0\nREV16 R5, R0\nend: B end",
+			registers: map[int]uint32{1: 24, 2: 32, 4: 0x78563412, 5: 0x34127856},
+		},
+		{
+			name:      "extends",
+			source:    "MOV32 R0, #0x1234F680\nUXTB R1, R0\nSXTB R2, R0\nUXTH R3, R0, ROR #16\nSXTH R4, R0\nUXTB R5, R0, ROR #8\nend: B end",
+			registers: map[int]uint32{1: 0x80, 2: 0xFFFFFF80, 3: 0x1234, 4: 0xFFFFF680, 5: 0xF6},
+		},
+		{
+			name:      "bit fields",
+			source:    "MOV32 R0, #0x12345678\nUBFX R1, R0, #4, #8\nSBFX R2, R0, #8, #8\nSBFX R3, R0, #3, #4\nMOV32 R4, #0xFFFFFFFF\nBFI R4, R0, #8, #12\nUBFX R5, R0, #0, #32\nend: B end",
+			registers: map[int]uint32{1: 0x67, 2: 0x56, 3: 0xFFFFFFFF, 4: 0xFFF678FF, 5: 0x12345678},
+		},
+		{
+			name:      "SDIV and UDIV",
+			source:    "MOVW R0, #100\nMOV32 R1, #0xFFFFFFF9\nSDIV R2, R0, R1\nUDIV R3, R1, R0\nMOVW R4, #0\nSDIV R5, R0, R4\nMOV32 R6, #0x80000000\nSDIV R7, R6, R1\nMOV32 R8, #0xFFFFFFFF\nSDIV R9, R6, R8\nUDIV R10, R0, R4\nend: B end",
+			registers: map[int]uint32{2: 0xFFFFFFF2, 3: 0x028F5C28, 5: 0, 7: 0x12492492, 9: 0x80000000, 10: 0},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cpu := load(t, c.source)
+			if err := cpu.Run(1000); err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			if !cpu.Halted || !strings.Contains(cpu.HaltReason, "idle loop") {
+				t.Errorf("expected to halt in an idle loop, got %q", cpu.HaltReason)
+			}
+			for r, expected := range c.registers {
+				if cpu.R[r] != expected {
+					t.Errorf("R%d: expected 0x%08X, got 0x%08X", r, expected, cpu.R[r])
+				}
+			}
+			if flags := cpu.CPSR & (FlagN | FlagZ | FlagC | FlagV); flags != c.flags {
+				t.Errorf("expected flags 0x%08X, got 0x%08X", c.flags, flags)
+			}
+			for address, expected := range c.memory {
+				if got := cpu.Memory.Read32(address); got != expected {
+					t.Errorf("0x%08X: expected 0x%08X, got 0x%08X", address, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	cases := []struct {
+		name    string
+		source  string
+		haltAt  uint32
+		err     error
+		abort   bool // Accesses from 0x40000000 abort
+		fault   string
+		address uint32
+	}{
+		{name: "step limit", source: "loop:\nADD R0, R0, #1\nB loop", err: ErrStepLimit},
+		{name: "halt address", source: "MOVW R0, #1\nMOVW R1, #2\nB loop\nloop: B loop", haltAt: 0x8004},
+		{name: "undefined instruction", source: "MOVW R0, #1\n.word 0xE7F000F0", fault: "undefined instruction", address: 0x8004},
+		{name: "unmapped memory", source: "MOV32 R0, #0x20000\nBX R0", fault: "executing unmapped memory", address: 0x20000},
+		{name: "BX to thumb", source: "MOVW R0, #0x9001\nBX R0", fault: "thumb state", address: 0x9000},
+		{name: "BLX to thumb", source: "MOVW R0, #1\nBLX #0x803", fault: "thumb state", address: 0x9012},
+		{name: "exception return without one", source: "MOVW R0, #1\nSUBS pc, lr, #4", fault: "exception return to invalid mode", address: 0x8004},
+		{name: "unhandled data abort", source: "MOV32 R0, #0x40000000\nLDR R1, [R0]", abort: true, fault: "data abort accessing 0x40000000", address: 0x8008},
+		{name: "unhandled supervisor call", source: ".word 0xEF000000", fault: "supervisor call", address: 0x8000},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cpu := load(t, c.source)
+			if c.haltAt != 0 {
+				cpu.HaltAt(c.haltAt)
+			}
+			if c.abort {
+				cpu.Memory.MapAbort(0x40000000, 0x1000)
+			}
+			err := cpu.Run(100)
+			var fault *Fault
+			switch {
+			case c.fault != "":
+				if !errors.As(err, &fault) || !strings.Contains(fault.Reason, c.fault) || fault.Address != c.address {
+					t.Fatalf("expected fault %q at 0x%08X, got %v", c.fault, c.address, err)
+				}
+			case err != c.err:
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			case c.haltAt != 0 && (!cpu.Halted || cpu.R[PC] != c.haltAt):
+				t.Fatalf("expected to halt at 0x%08X, got PC 0x%08X (%q)", c.haltAt, cpu.R[PC], cpu.HaltReason)
+			}
+		})
+	}
+}
+
+func TestBankedRegisters(t *testing.T) {
+	c := New(NewMemory())
+	if c.Mode() != ModeSupervisor {
+		t.Fatalf("expected to reset into supervisor mode, got %s", c.Mode())
+	}
+	c.R[SP], c.R[LR] = 0x1000, 0x2000
+	if err := c.SetMode(ModeIRQ); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.R[SP] != 0 || c.R[LR] != 0 {
+		t.Errorf("expected fresh IRQ bank, got SP 0x%08X LR 0x%08X", c.R[SP], c.R[LR])
+	}
+	c.R[SP] = 0x3000
+	if err := c.SetMode(ModeSystem); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.R[SP] = 0x4000
+	if sp, _ := c.Banked(ModeUser); sp != 0x4000 {
+		t.Errorf("expected user and system modes to share SP, got 0x%08X", sp)
+	}
+	if sp, lr := c.Banked(ModeSupervisor); sp != 0x1000 || lr != 0x2000 {
+		t.Errorf("expected supervisor SP 0x1000 LR 0x2000, got 0x%08X 0x%08X", sp, lr)
+	}
+	if sp, _ := c.Banked(ModeIRQ); sp != 0x3000 {
+		t.Errorf("expected IRQ SP 0x3000, got 0x%08X", sp)
+	}
+	if err := c.SetMode(Mode(0x15)); err == nil {
+		t.Errorf("expected error switching to an invalid mode")
+	}
+
+	// R8-R12 are only banked in FIQ mode, and user and system mode have no SPSR
+	c.R[8], c.R[12] = 8, 12
+	if _, ok := c.SPSR(); ok || c.SetSPSR(1) {
+		t.Errorf("expected no SPSR in system mode")
+	}
+	c.SetMode(ModeFIQ)
+	if c.R[8] != 0 || c.R[12] != 0 || !c.SetSPSR(0x10) {
+		t.Errorf("expected fresh FIQ R8-R12 and an SPSR, got 0x%08X 0x%08X", c.R[8], c.R[12])
+	}
+	c.R[8] = 0x88
+	c.SetMode(ModeIRQ)
+	if c.R[8] != 8 || c.R[12] != 12 {
+		t.Errorf("expected R8-R12 back outside FIQ mode, got 0x%08X 0x%08X", c.R[8], c.R[12])
+	}
+	c.SetMode(ModeFIQ)
+	if spsr, _ := c.SPSR(); c.R[8] != 0x88 || spsr != 0x10 {
+		t.Errorf("expected FIQ R8 0x88 and SPSR 0x10, got 0x%08X 0x%08X", c.R[8], spsr)
+	}
+}
+
+// vectors is a vector table for exception tests, loaded at the start of the program with VBAR pointing at it.
+// Handlers a test doesn't define hang.
+const vectors = `B start
+B undefined
+B supervisor
+B prefetch
+B data
+B start
+B irq
+B fiq
+`
+
+// ack is an interrupt controller register: writing bit 0 drops the IRQ line and bit 1 the FIQ line.
+type ack struct{ cpu *CPU }
+
+func (a ack) Read32(offset uint32) uint32 { return 0 }
+
+func (a ack) Write32(offset uint32, value uint32) {
+	a.cpu.IRQ = a.cpu.IRQ && value&1 == 0
+	a.cpu.FIQ = a.cpu.FIQ && value&2 == 0
+}
+
+type taken struct {
+	exception Exception
+	address   uint32
+}
+
+func TestExceptions(t *testing.T) {
+	cases := []struct {
+		name      string
+		source    string
+		setup     func(c *CPU)
+		registers map[int]uint32
+		banked    map[Mode][2]uint32 // SP and LR
+		memory    map[uint32]uint32
+		taken     []taken
+	}{
+		{
+			name:      "undefined instruction",
+			source:    "start:\nMOVW R0, #1\n.word 0xE7F000F0\nMOVW R2, #2\nend: B end\nundefined:\nMOVW R1, #1\nSUBS pc, lr, #0",
+			registers: map[int]uint32{0: 1, 1: 1, 2: 2},
+			banked:    map[Mode][2]uint32{ModeUndefined: {0, 0x8028}},
+			taken:     []taken{{ExceptionUndefined, 0x8024}},
+		},
+		{
+			name:      "data abort",
+			source:    "start:\nMOV32 R0, #0x40000000\nMOVW R1, #7\nLDR R1, [R0]\nMOVW R2, #2\nend: B end\ndata:\nMOVW R3, #3\nSUBS pc, lr, #4",
+			registers: map[int]uint32{1: 7, 2: 2, 3: 3},
+			banked:    map[Mode][2]uint32{ModeAbort: {0, 0x8034}},
+			taken:     []taken{{ExceptionDataAbort, 0x802C}},
+		},
+		{
+			name:      "data abort in the middle of STM",
+			source:    "start:\nMOV32 R0, #0x3FFFFFFC\nSTMEA R0!, {R0-R1}\nend: B end\ndata:\nSUBS pc, lr, #4",
+			registers: map[int]uint32{0: 0x3FFFFFFC},
+			memory:    map[uint32]uint32{0x3FFFFFFC: 0},
+			taken:     []taken{{ExceptionDataAbort, 0x8028}},
+		},
+		{
+			name:      "supervisor call",
+			source:    "start:\n.word 0x0F000000\n.word 0xEF000042\nMOVW R2, #2\nend: B end\nsupervisor:\nADD R1, R1, #1\nSUBS pc, lr, #0",
+			registers: map[int]uint32{1: 1, 2: 2},
+			taken:     []taken{{ExceptionSupervisorCall, 0x8024}},
+		},
+		{
+			name:   "prefetch abort",
+			source: "start:\nMOV32 R0, #0x40000000\nBX R0\nend: B end\nprefetch:\nMOVW R1, #1\nSUB lr, lr, #4\nMOV32 R2, end\nSTMEA sp!, {R2}\nLDMEA sp!, {pc}^",
+			setup: func(c *CPU) {
+				c.SetMode(ModeAbort)
+				c.R[SP] = 0x9000
+				c.SetMode(ModeSupervisor)
+			},
+			registers: map[int]uint32{1: 1},
+			banked:    map[Mode][2]uint32{ModeAbort: {0x9000, 0x40000000}},
+			taken:     []taken{{ExceptionPrefetchAbort, 0x40000000}},
+		},
+		{
+			name:   "IRQ returning with LDM",
+			source: "start:\nMOVW R0, #5\nMOVW R2, #2\nend: B end\nirq:\nSUB lr, lr, #4\nSTMEA sp!, {R0, lr}\nMOV32 R0, #0x20000000\nMOVW R3, #1\nSTR R3, [R0]\nADD R4, R4, #1\nLDMEA sp!, {R0, pc}^",
+			setup: func(c *CPU) {
+				c.SetMode(ModeIRQ)
+				c.R[SP] = 0x9000
+				c.SetMode(ModeSupervisor)
+				c.CPSR &^= FlagI
+				c.IRQ = true
+			},
+			registers: map[int]uint32{0: 5, 2: 2, 4: 1},
+			banked:    map[Mode][2]uint32{ModeIRQ: {0x9000, 0x8000}},
+			memory:    map[uint32]uint32{0x9000: 0, 0x9004: 0x8000},
+			taken:     []taken{{ExceptionIRQ, 0x8000}},
+		},
+		{
+			name:   "FIQ first, with its own R8-R12",
+			source: "start:\nMOVW R2, #2\nend: B end\nfiq:\nMOVW R8, #1\nMOV32 R9, #0x20000000\nMOVW R10, #2\nSTR R10, [R9]\nSUBS pc, lr, #4\nirq:\nADD R4, R8, #4\nMOV32 R0, #0x20000000\nMOVW R1, #1\nSTR R1, [R0]\nSUBS pc, lr, #4",
+			setup: func(c *CPU) {
+				c.R[8] = 0x80
+				c.CPSR &^= FlagI | FlagF
+				c.IRQ, c.FIQ = true, true
+			},
+			registers: map[int]uint32{2: 2, 4: 0x84, 8: 0x80},
+			taken:     []taken{{ExceptionFIQ, 0x8000}, {ExceptionIRQ, 0x8000}},
+		},
+		{
+			name:   "user bank STM and LDM",
+			source: "start:\nMOVW R0, #0x9000\nSTMEA R0, {sp, lr}^\nMOVW R5, #0x5555\nSTR R5, [R0]\nADD R0, R0, #4\nLDMEA R0, {lr}^\nend: B end",
+			setup: func(c *CPU) {
+				c.SetMode(ModeSystem)
+				c.R[SP], c.R[LR] = 0x1111, 0x2222
+				c.SetMode(ModeSupervisor)
+			},
+			registers: map[int]uint32{13: 0, 14: 0},
+			banked:    map[Mode][2]uint32{ModeUser: {0x1111, 0x5555}},
+			memory:    map[uint32]uint32{0x9000: 0x5555, 0x9004: 0x2222},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			source := vectors + c.source
+			for _, handler := range []string{"undefined", "supervisor", "prefetch", "data", "irq", "fiq"} {
+				if !strings.Contains(source, "\n"+handler+":") {
+					source += "\n" + handler + ": B " + handler
+				}
+			}
+			cpu := load(t, source)
+			cpu.VBAR = 0x8000
+			cpu.Memory.MapAbort(0x40000000, 0x1000)
+			cpu.Memory.Map(0x20000000, 4, ack{cpu})
+			var got []taken
+			cpu.ExceptionHook = func(e Exception, address uint32) { got = append(got, taken{e, address}) }
+			if c.setup != nil {
+				c.setup(cpu)
+			}
+
+			if err := cpu.Run(1000); err != nil && err != ErrStepLimit {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			if cpu.Mode() != ModeSupervisor || cpu.Memory.Read32(cpu.R[PC]) != 0xEAFFFFFE {
+				t.Errorf("expected to end up in the idle loop in svc mode, got 0x%08X in %s", cpu.R[PC], cpu.Mode())
+			}
+			for r, expected := range c.registers {
+				if cpu.R[r] != expected {
+					t.Errorf("R%d: expected 0x%08X, got 0x%08X", r, expected, cpu.R[r])
+				}
+			}
+			for mode, expected := range c.banked {
+				if sp, lr := cpu.Banked(mode); sp != expected[0] || lr != expected[1] {
+					t.Errorf("%s: expected SP 0x%08X LR 0x%08X, got 0x%08X 0x%08X", mode, expected[0], expected[1], sp, lr)
+				}
+			}
+			for address, expected := range c.memory {
+				if got := cpu.Memory.Read32(address); got != expected {
+					t.Errorf("0x%08X: expected 0x%08X, got 0x%08X", address, expected, got)
+				}
+			}
+			if !reflect.DeepEqual(got, c.taken) {
+				t.Errorf("expected exceptions %v, got %v", c.taken, got)
+			}
+		})
+	}
+}
+
+func TestSupervisorCall(t *testing.T) {
+	source := vectors + `start:
+SVC #0x10
+SVCEQ #0x11
+SWI #0x42
+MOVW R2, #2
+end: B end
+supervisor:
+ADD R1, R1, #1
+SUBS pc, lr, #0
+undefined: B undefined
+prefetch: B prefetch
+data: B data
+irq: B irq
+fiq: B fiq`
+	cpu := load(t, source)
+	cpu.VBAR = 0x8000
+	var calls []uint32
+	cpu.SupervisorCall = func(number uint32) (bool, error) {
+		calls = append(calls, number)
+		cpu.R[0] += number
+		return number == 0x10, nil
+	}
+	var got []taken
+	cpu.ExceptionHook = func(e Exception, address uint32) { got = append(got, taken{e, address}) }
+	if err := cpu.Run(1000); err != nil {
+		t.Fatalf("unexpected error running: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []uint32{0x10, 0x42}) {
+		t.Errorf("expected calls 0x10 and 0x42, got %X", calls)
+	}
+	if cpu.R[0] != 0x52 || cpu.R[1] != 1 || cpu.R[2] != 2 {
+		t.Errorf("expected R0 0x52, R1 1 and R2 2, got 0x%X %d %d", cpu.R[0], cpu.R[1], cpu.R[2])
+	}
+	if expected := []taken{{ExceptionSupervisorCall, 0x8028}}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected exceptions %v, got %v", expected, got)
+	}
+
+	cpu = load(t, "SVC #1\nMOVW R0, #1")
+	stop := errors.New("stop")
+	cpu.SupervisorCall = func(uint32) (bool, error) { return false, stop }
+	if err := cpu.Run(10); err != stop || cpu.Steps != 1 || cpu.R[PC] != 0x8004 {
+		t.Errorf("expected the handler's error after the SVC, got %v at 0x%08X after %d steps", err, cpu.R[PC], cpu.Steps)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	if m.Read32(0x1234) != 0 || m.Mapped(0x1234) {
+		t.Fatalf("expected untouched memory to read 0 and be unmapped")
+	}
+	m.Write32(0x8000, 0x11223344)
+	if m.Read8(0x8000) != 0x44 || m.Read8(0x8003) != 0x11 {
+		t.Errorf("expected little-endian bytes, got 0x%02X 0x%02X", m.Read8(0x8000), m.Read8(0x8003))
+	}
+	m.Write32(0x8FFE, 0xAABBCCDD) // Straddles a page boundary
+	if got := m.Read32(0x8FFE); got != 0xAABBCCDD {
+		t.Errorf("expected 0xAABBCCDD across pages, got 0x%08X", got)
+	}
+	m.Load(0xFFFFFFFE, []byte{1, 2})
+	if m.Read8(0xFFFFFFFF) != 2 {
+		t.Errorf("expected load at the top of memory")
+	}
+
+	device := &registers{}
+	m.Map(0x3F000000, 8, device)
+	m.Write32(0x3F000004, 0x12345678)
+	m.Write8(0x3F000001, 0xAB)
+	if device[1] != 0x12345678 || device[0] != 0xAB00 {
+		t.Errorf("expected writes to reach the device, got 0x%08X 0x%08X", device[0], device[1])
+	}
+	if m.Read32(0x3F000004) != 0x12345678 || m.Read8(0x3F000007) != 0x12 || m.Mapped(0x3F000000) {
+		t.Errorf("expected reads from the device without mapping memory")
+	}
+	if m.Write32(0x3F000008, 1); device[1] != 0x12345678 || m.Read32(0x3F000008) != 1 {
+		t.Errorf("expected memory past the end of the device")
+	}
+
+	m.MapAbort(0x3F000000, 0x1000)
+	if !m.Aborts(0x3F000008) || m.Aborts(0x3F000004) || m.Aborts(0x3F001000) {
+		t.Errorf("expected accesses to abort only where no device answers")
+	}
+}
+
+// registers is a device with two plain registers.
+type registers [2]uint32
+
+func (r *registers) Read32(offset uint32) uint32 {
+	return r[offset/4]
+}
+
+func (r *registers) Write32(offset uint32, value uint32) {
+	r[offset/4] = value
+}
+
+// elfFile builds a minimal ARM executable with a single segment.
+func elfFile(entry uint32, address uint32, code []byte, memsz uint32) []byte {
+	const headerSize, progSize = 52, 32
+	data := make([]byte, headerSize+progSize, headerSize+progSize+len(code))
+	copy(data, []byte{0x7F, 'E', 'L', 'F', 1, 1, 1})
+	le := binary.LittleEndian
+	le.PutUint16(data[16:], 2)  // ET_EXEC
+	le.PutUint16(data[18:], 40) // EM_ARM
+	le.PutUint32(data[20:], 1)
+	le.PutUint32(data[24:], entry)
+	le.PutUint32(data[28:], headerSize) // Program headers
+	le.PutUint16(data[40:], headerSize)
+	le.PutUint16(data[42:], progSize)
+	le.PutUint16(data[44:], 1)
+
+	prog := data[headerSize:]
+	le.PutUint32(prog[0:], 1) // PT_LOAD
+	le.PutUint32(prog[4:], headerSize+progSize)
+	le.PutUint32(prog[8:], address)
+	le.PutUint32(prog[12:], address)
+	le.PutUint32(prog[16:], uint32(len(code)))
+	le.PutUint32(prog[20:], memsz)
+	le.PutUint32(prog[24:], 5)
+	return append(data, code...)
+}
+
+func TestLoadELF(t *testing.T) {
+	image := build(t, "MOVW R0, #0x42\nend: B end\n.word 0x11111111")
+	data := elfFile(0x10000, 0x10000, image.Data[:8], 16)
+	if !IsELF(data) || IsELF(image.Data) {
+		t.Fatalf("expected only the ELF file to be detected")
+	}
+
+	c := New(NewMemory())
+	c.Memory.Write32(0x10008, 0xFFFFFFFF) // .bss should be cleared
+	if err := c.LoadELF(data); err != nil {
+		t.Fatalf("unexpected error loading: %v", err)
+	}
+	if c.R[PC] != 0x10000 {
+		t.Errorf("expected PC at the entry point, got 0x%08X", c.R[PC])
+	}
+	if c.Memory.Read32(0x10008) != 0 {
+		t.Errorf("expected zero filled .bss, got 0x%08X", c.Memory.Read32(0x10008))
+	}
+	if err := c.Run(10); err != nil || c.R[0] != 0x42 {
+		t.Errorf("expected R0 0x42, got 0x%08X (%v)", c.R[0], err)
+	}
+
+	data[18] = 3 // EM_386
+	if err := New(NewMemory()).LoadELF(data); err == nil || !strings.Contains(err.Error(), "ARM") {
+		t.Errorf("expected error loading a non-ARM file, got %v", err)
+	}
+}
+
+func TestFastForward(t *testing.T) {
+	cases := []struct {
+		name    string
+		source  string
+		skipped bool // Whether the loop can be fast forwarded
+	}{
+		{name: "BPL", source: "MOV32 R5, #0x000FFFFF\nloop:\nSUBS R5, R5, #1\nBPL loop\nend: B end", skipped: true},
+		{name: "BNE", source: "MOV32 R5, #300000\nloop:\nSUBS R5, R5, #3\nBNE loop\nend: B end", skipped: true},
+		{name: "BGT", source: "MOV32 R5, #100001\nloop:\nSUBS R5, R5, #4\nBGT loop\nend: B end", skipped: true},
+		{name: "BGE", source: "MOV32 R5, #100001\nloop:\nSUBS R5, R5, #4\nBGE loop\nend: B end", skipped: true},
+		{name: "BHI", source: "MOV32 R5, #0x80000010\nloop:\nSUBS R5, R5, #0x10000\nBHI loop\nend: B end", skipped: true},
+		{name: "BCS", source: "MOV32 R5, #0x80000010\nloop:\nSUBS R5, R5, #0x10000\nBCS loop\nend: B end", skipped: true},
+		{name: "BNE that wraps", source: "MOV32 R5, #0x40000000\nloop:\nSUBS R5, R5, #0xC0000000\nBNE loop\nend: B end"},
+		{name: "BPL from negative", source: "MOV32 R5, #0xFFFFFFF0\nloop:\nSUBS R5, R5, #1\nBPL loop\nend: B end"},
+		{name: "loop with a body", source: "MOVW R5, #1000\nloop:\nADD R6, R6, #1\nSUBS R5, R5, #1\nBPL loop\nend: B end"},
+		{name: "nested", source: "MOVW R4, #3\nouter:\nMOVW R5, #1000\ninner:\nSUBS R5, R5, #1\nBPL inner\nSUBS R4, R4, #1\nBNE outer\nend: B end", skipped: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			slow := load(t, c.source)
+			if err := slow.Run(0); err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			fast := load(t, c.source)
+			fast.FastForward = true
+			if err := fast.Run(0); err != nil {
+				t.Fatalf("unexpected error fast forwarding: %v", err)
+			}
+			if fast.R != slow.R || fast.CPSR != slow.CPSR || fast.Steps != slow.Steps {
+				t.Errorf("expected the same state as running every instruction\nslow: %08X %08X %d\nfast: %08X %08X %d", slow.R, slow.CPSR, slow.Steps, fast.R, fast.CPSR, fast.Steps)
+			}
+
+			// Step through again to see whether any of it was skipped
+			stepped := load(t, c.source)
+			skipped := false
+			for !stepped.Halted {
+				if stepped.fastForward() {
+					skipped = true
+				} else if err := stepped.Step(); err != nil {
+					t.Fatalf("unexpected error stepping: %v", err)
+				}
+			}
+			if skipped != c.skipped {
+				t.Errorf("expected skipped %v, got %v", c.skipped, skipped)
+			}
+		})
+	}
+}
+
+func TestFastForwardStepLimit(t *testing.T) {
+	source := "MOV32 R5, #0x000FFFFF\nloop:\nSUBS R5, R5, #1\nBPL loop\nend: B end"
+	slow, fast := load(t, source), load(t, source)
+	fast.FastForward = true
+	for _, limit := range []uint64{1, 2, 3, 1001, 50000, 1 << 20} {
+		if err := slow.Run(limit); err != ErrStepLimit {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := fast.Run(limit); err != ErrStepLimit {
+			t.Fatalf("unexpected error fast forwarding: %v", err)
+		}
+		if fast.R != slow.R || fast.Steps != slow.Steps {
+			t.Fatalf("limit %d: expected R5 0x%08X after %d steps, got 0x%08X after %d", limit, slow.R[5], slow.Steps, fast.R[5], fast.Steps)
+		}
+	}
+}
+
+// ticker raises the IRQ every 1000 steps until it's acknowledged by writing to it.
+type ticker struct {
+	cpu    *CPU
+	next   uint64
+	raised bool
+}
+
+func (t *ticker) Read32(offset uint32) uint32 { return 0 }
+
+func (t *ticker) Write32(offset uint32, value uint32) { t.raised = false }
+
+func (t *ticker) lines() (bool, bool) {
+	if t.cpu.Steps >= t.next {
+		t.raised = true
+		t.next += 1000
+	}
+	return t.raised, false
+}
+
+func TestFastForwardInterrupts(t *testing.T) {
+	source := vectors + `start:
+MOV32 R5, #0x3000
+loop:
+SUBS R5, R5, #1
+BPL loop
+end: B end
+irq:
+MOV32 R0, #0x20000000
+STR R0, [R0]
+ADD R4, R4, #1
+SUBS pc, lr, #4
+undefined: B undefined
+supervisor: B supervisor
+prefetch: B prefetch
+data: B data
+fiq: B fiq`
+	run := func(fast bool) (*CPU, []taken) {
+		cpu := load(t, source)
+		cpu.VBAR = 0x8000
+		cpu.CPSR &^= FlagI
+		cpu.FastForward = fast
+		tick := &ticker{cpu: cpu, next: 1000}
+		cpu.Memory.Map(0x20000000, 4, tick)
+		cpu.InterruptLines = tick.lines
+		cpu.NextInterrupt = func() uint64 { return tick.next }
+		var got []taken
+		cpu.ExceptionHook = func(e Exception, address uint32) { got = append(got, taken{e, address}) }
+		if err := cpu.Run(50000); err != ErrStepLimit {
+			t.Fatalf("expected to run until the step limit, got %v", err)
+		}
+		return cpu, got
+	}
+	slow, slowTaken := run(false)
+	fast, fastTaken := run(true)
+	if slow.R[4] != 49 {
+		t.Errorf("expected 49 interrupts, got %d", slow.R[4])
+	}
+	if fast.R != slow.R || fast.CPSR != slow.CPSR || fast.Steps != slow.Steps {
+		t.Errorf("expected the same state as running every instruction\nslow: %08X %08X %d\nfast: %08X %08X %d", slow.R, slow.CPSR, slow.Steps, fast.R, fast.CPSR, fast.Steps)
+	}
+	if !reflect.DeepEqual(fastTaken, slowTaken) {
+		t.Errorf("expected interrupts at the same places\nslow: %v\nfast: %v", slowTaken, fastTaken)
+	}
+}
+
+func TestSystemRegisters(t *testing.T) {
+	cases := []struct {
+		name      string
+		source    string
+		registers map[int]uint32
+		user      bool // Run in user mode
+		err       string
+	}{
+		{name: "MIDR", source: "MRC R3, MIDR\nend: B end", registers: map[int]uint32{3: MIDR}},
+		{name: "MPIDR", source: "MRC p15, 0, R0, c0, c0, 5\nend: B end", registers: map[int]uint32{0: 0x80000F02}},
+		{name: "condition failed", source: "MRCEQ R0, MPIDR\nend: B end", registers: map[int]uint32{0: 0}},
+		{name: "condition passed", source: "MRCNE R5, MPIDR\nend: B end", registers: map[int]uint32{5: 0x80000F02}},
+		{name: "written registers read back", source: "MOVW R0, #0x1005\nMCR R0, SCTLR\nMRC R1, SCTLR\nMRC R2, TTBR0\nend: B end", registers: map[int]uint32{1: 0x1005, 2: 0}},
+		{name: "VBAR", source: "MOVW R0, #0x1234\nMCR R0, VBAR\nMRC R1, VBAR\nend: B end", registers: map[int]uint32{1: 0x1220}},
+		{name: "maintenance operations", source: "MCR R0, ICIALLU\nMCR R0, TLBIALL\nMCR R0, CP15DSB\nend: B end"},
+		{
+			name:      "64 bit registers",
+			source:    "MOVW R0, #0x4000\nMOVW R1, #0x12\nMCRR R0, R1, TTBR0\nMRRC R2, R3, TTBR0\nMRC R4, TTBR0\nend: B end",
+			registers: map[int]uint32{2: 0x4000, 3: 0x12, 4: 0x4000},
+		},
+		{name: "thread ID in user mode", source: "MCR R0, TPIDRURW\nMRC R1, TPIDRURO\nend: B end", user: true},
+		{name: "user mode", source: "MRC R0, MPIDR", user: true, err: "MPIDR access in user mode"},
+		{name: "user mode write to a read only thread ID", source: "MCR R0, TPIDRURO", user: true, err: "TPIDRURO access in user mode"},
+		{name: "PC", source: ".word 0xEE10FFB0", err: "undefined instruction"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cpu := load(t, c.source)
+			cpu.ID = 2
+			if c.user {
+				cpu.SetMode(ModeUser)
+			}
+			err := cpu.Run(100)
+			if c.user && errors.Is(err, ErrStepLimit) {
+				err = nil // User mode can't mask interrupts, so the loop at the end runs on
+			}
+			if c.err == "" && err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			var fault *Fault
+			if c.err != "" && (!errors.As(err, &fault) || fault.Reason != c.err) {
+				t.Fatalf("expected fault %q, got %v", c.err, err)
+			}
+			for r, expected := range c.registers {
+				if cpu.R[r] != expected {
+					t.Errorf("R%d: expected 0x%08X, got 0x%08X", r, expected, cpu.R[r])
+				}
+			}
+		})
+	}
+
+	// The DFAR is the address the last data abort was for
+	cpu := load(t, "MRC R2, DFAR\nend: B end")
+	cpu.FaultAddress = 0x40000000
+	if err := cpu.Run(100); err != nil || cpu.R[2] != 0x40000000 {
+		t.Errorf("expected DFAR 0x40000000, got 0x%08X (%v)", cpu.R[2], err)
+	}
+}
+
+// enableFloat grants full access to cp10 and cp11 and sets FPEXC.EN, as startup code has to before using VFP.
+const enableFloat = "MRC R0, CPACR\nORR R0, R0, #0xF00000\nMCR R0, CPACR\nISB\nMOV32 R0, #0x40000000\nVMSR FPEXC, R0\n"
+
+func TestFloat(t *testing.T) {
+	cases := []struct {
+		name      string
+		source    string
+		registers map[int]uint32
+		d         map[int]uint64 // Expected D registers
+		flags     uint32
+		memory    map[uint32]uint32
+		user      bool // Run in user mode with VFP already enabled
+		err       string
+	}{
+		{
+			name:      "single precision",
+			source:    enableFloat + "MOV32 R1, #0x3FC00000\nMOV32 R2, #0x40200000\nVMOV S0, R1\nVMOV S1, R2\nVADD.F32 S2, S0, S1\nVSUB.F32 S3, S0, S1\nVMUL.F32 S4, S0, S1\nVDIV.F32 S5, S1, S0\nVMOV.F32 S6, S5\nVMOV R3, S2\nVMOV R4, S3\nVMOV R5, S4\nVMOV R6, S6\nend: B end",
+			registers: map[int]uint32{3: 0x40800000, 4: 0xBF800000, 5: 0x40700000, 6: 0x3FD55555},
+		},
+		{
+			name:      "double precision",
+			source:    enableFloat + "MOVW R1, #0\nMOV32 R2, #0x3FF80000\nMOV32 R3, #0x40000000\nVMOV D0, R1, R2\nVMOV D1, R1, R3\nVMUL.F64 D2, D0, D1\nVDIV.F64 D3, D0, D1\nVMOV.F64 D17, D3\nVMOV R4, R5, D2\nend: B end",
+			registers: map[int]uint32{4: 0, 5: 0x40080000},
+			d:         map[int]uint64{3: 0x3FE8000000000000, 17: 0x3FE8000000000000},
+		},
+		{
+			name:   "conversions",
+			source: enableFloat + "MOV32 R1, #0xFFFFFFF9\nVMOV S0, R1\nVCVT.F32.S32 S1, S0\nVCVT.F64.F32 D1, S1\nVCVT.U32.F64 S4, D1\nVCVT.S32.F64 S5, D1\nMOV32 R2, #0x40300000\nVMOV S6, R2\nVCVT.S32.F32 S7, S6\nMOV32 R3, #0x501502F9\nVMOV S8, R3\nVCVT.S32.F32 S9, S8\nVCVT.U32.F32 S10, S8\nend: B end",
+			d:      map[int]uint64{0: 0xC0E00000FFFFFFF9, 1: 0xC01C000000000000, 2: 0xFFFFFFF900000000, 3: 0x0000000240300000, 4: 0x7FFFFFFF501502F9, 5: 0xFFFFFFFF},
+		},
+		{
+			name:      "FPSCR flags",
+			source:    enableFloat + "MOV32 R0, #0x60000000\nVMSR FPSCR, R0\nVMRS APSR_nzcv, FPSCR\nVMRS R1, FPSCR\nVMRS R2, FPSID\nVMRS R3, FPEXC\nend: B end",
+			registers: map[int]uint32{1: 0x60000000, 2: FPSID, 3: FPEXCEnable},
+			flags:     FlagZ | FlagC,
+		},
+		{
+			name:      "VLDR and VSTR",
+			source:    enableFloat + "MOV32 R1, #0x100000\nMOV32 R2, #0x11223344\nMOV32 R3, #0x55667788\nVMOV D0, R2, R3\nVSTR D0, [R1, #8]\nVLDR S4, [R1, #12]\nVSTR S4, [R1]\nVLDR D9, [R1, #8]\nVMOV R4, S4\nend: B end",
+			registers: map[int]uint32{4: 0x55667788},
+			d:         map[int]uint64{9: 0x5566778811223344},
+			memory:    map[uint32]uint32{0x100000: 0x55667788, 0x100008: 0x11223344, 0x10000C: 0x55667788},
+		},
+		{
+			name:   "NEON VADD and VSUB",
+			source: enableFloat + "MOV32 R1, #0xFFFFFFFF\nMOVW R2, #1\nVMOV D0, R1, R2\nVMOV D1, R1, R2\nVADD.I32 D2, D0, D1\nVADD.I64 D3, D0, D1\nVSUB.I16 Q2, Q0, Q3\nVSUB.I8 D6, D2, D0\nend: B end",
+			d:      map[int]uint64{2: 0x00000002FFFFFFFE, 3: 0x00000003FFFFFFFE, 4: 0x00000001FFFFFFFF, 5: 0x00000001FFFFFFFF, 6: 0x00000001000000FF},
+		},
+		{
+			name:      "VLD1 and VST1",
+			source:    enableFloat + "MOV32 R1, #0x100000\nMOV32 R2, #0x11223344\nMOV32 R3, #0x55667788\nVMOV D0, R2, R3\nVMOV D1, R3, R2\nVST1.32 {D0, D1}, [R1]!\nMOV32 R5, #0x100000\nMOVW R4, #4\nVLD1.8 {D2}, [R5], R4\nend: B end",
+			registers: map[int]uint32{1: 0x100010, 5: 0x100004},
+			d:         map[int]uint64{2: 0x5566778811223344},
+			memory:    map[uint32]uint32{0x100000: 0x11223344, 0x100004: 0x55667788, 0x100008: 0x55667788, 0x10000C: 0x11223344},
+		},
+		{name: "condition failed", source: "VADDEQ.F32 S0, S1, S2\nend: B end"},
+		{name: "FPEXC before enabling", source: "MRC R0, CPACR\nORR R0, R0, #0x500000\nMCR R0, CPACR\nVMRS R1, FPEXC\nend: B end", registers: map[int]uint32{1: 0}},
+		{name: "CPACR not set", source: "VADD.F32 S0, S1, S2", err: "VFP access not granted in CPACR"},
+		{name: "FPEXC not set", source: "MRC R0, CPACR\nORR R0, R0, #0x500000\nMCR R0, CPACR\nVLDR S0, [R0]", err: "VFP disabled in FPEXC"},
+		{name: "NEON disabled", source: "VADD.I32 D0, D1, D2", err: "VFP access not granted in CPACR"},
+		{name: "user mode", source: "VMRS R0, FPSCR\nVADD.F32 S0, S1, S2\nend: B end", user: true},
+		{name: "user mode FPEXC", source: "VMRS R0, FPEXC", user: true, err: "FPSID or FPEXC access in user mode"},
+		{name: "unaligned VLDR", source: enableFloat + "MOV32 R1, #0x100002\nVLDR S0, [R1]", err: "data abort accessing 0x00100002"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cpu := load(t, c.source)
+			if c.user {
+				cpu.cp15["CPACR"] = 0xF00000
+				cpu.FPEXC = FPEXCEnable
+				cpu.SetMode(ModeUser)
+			}
+			err := cpu.Run(1000)
+			if c.user && errors.Is(err, ErrStepLimit) {
+				err = nil // User mode can't mask interrupts, so the loop at the end runs on
+			}
+			if c.err == "" && err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			var fault *Fault
+			if c.err != "" && (!errors.As(err, &fault) || fault.Reason != c.err) {
+				t.Fatalf("expected fault %q, got %v", c.err, err)
+			}
+			for r, expected := range c.registers {
+				if cpu.R[r] != expected {
+					t.Errorf("R%d: expected 0x%08X, got 0x%08X", r, expected, cpu.R[r])
+				}
+			}
+			for r, expected := range c.d {
+				if cpu.D[r] != expected {
+					t.Errorf("D%d: expected 0x%016X, got 0x%016X", r, expected, cpu.D[r])
+				}
+			}
+			if flags := cpu.CPSR & (FlagN | FlagZ | FlagC | FlagV); c.err == "" && flags != c.flags {
+				t.Errorf("expected flags 0x%08X, got 0x%08X", c.flags, flags)
+			}
+			for address, expected := range c.memory {
+				if got := cpu.Memory.Read32(address); got != expected {
+					t.Errorf("0x%08X: expected 0x%08X, got 0x%08X", address, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusRegisters(t *testing.T) {
+	cases := []struct {
+		name      string
+		source    string
+		registers map[int]uint32
+		cpsr      uint32
+		err       string
+	}{
+		{name: "MRS", source: "MRS R0, CPSR\nend: B end", registers: map[int]uint32{0: 0xD3}, cpsr: 0xD3},
+		{
+			name:      "MSR switches mode",
+			source:    "MOVW R0, #0xD2\nMSR CPSR_c, R0\nMOVW SP, #0x2000\nMRS R1, CPSR\nMSR CPSR_c, #0xD3\nend: B end",
+			registers: map[int]uint32{1: 0xD2, SP: 0},
+			cpsr:      0xD3,
+		},
+		{name: "MSR flags", source: "MSR CPSR_f, #0xF0000000\nMRS R2, APSR\nend: B end", registers: map[int]uint32{2: 0xF00000D3}, cpsr: 0xF00000D3},
+		{name: "SPSR", source: "MOVW R0, #0x10\nMSR SPSR_fsxc, R0\nMRS R3, SPSR\nend: B end", registers: map[int]uint32{3: 0x10}, cpsr: 0xD3},
+		{
+			name:   "user mode only writes the flags",
+			source: "MSR CPSR_c, #0x10\nMOV32 R0, #0x800001D3\nMSR CPSR_fsxc, R0\nend: B end",
+			cpsr:   0x80000010,
+			err:    "step limit",
+		},
+		{name: "T bit isn't written", source: "MSR CPSR_c, #0xF3\nend: B end", cpsr: 0xD3},
+		{
+			name:      "CPSIE and CPSID",
+			source:    "CPSIE if\nMRS R0, CPSR\nCPSID aif, #0x1F\nend: B end",
+			registers: map[int]uint32{0: 0x13},
+			cpsr:      0x1DF,
+		},
+		{name: "CPS does nothing in user mode", source: "CPS #0x10\nCPSID a\nCPS #0x13\nend: B end", cpsr: 0xD0},
+		{
+			name:      "SETEND swaps data",
+			source:    "MOV32 R0, #0x11223344\nMOV32 R1, data\nSETEND BE\nSTR R0, [R1]\nLDR R2, [R1]\nSETEND LE\nLDR R3, [R1]\nend: B end\ndata: .word 0",
+			registers: map[int]uint32{2: 0x11223344, 3: 0x44332211},
+			cpsr:      0xD3,
+		},
+		{name: "MRS SPSR in system mode", source: "CPS #0x1F\nMRS R0, SPSR", cpsr: 0xDF, err: "no SPSR in sys mode"},
+		{name: "MSR to invalid mode", source: "MSR CPSR_c, #0xD5", cpsr: 0xD3, err: "MSR to invalid mode 0x15"},
+		{name: "CPS to invalid mode", source: "CPSIE i, #0x15", cpsr: 0xD3, err: "CPS to invalid mode 0x15"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cpu := load(t, c.source)
+			err := cpu.Run(100)
+			if c.err == "" && err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+				t.Fatalf("expected error containing %q, got %v", c.err, err)
+			}
+			for r, expected := range c.registers {
+				if cpu.R[r] != expected {
+					t.Errorf("R%d: expected 0x%08X, got 0x%08X", r, expected, cpu.R[r])
+				}
+			}
+			if cpu.CPSR != c.cpsr {
+				t.Errorf("expected CPSR 0x%08X, got 0x%08X", c.cpsr, cpu.CPSR)
+			}
+		})
+	}
+
+	// MSR to the CPSR from supervisor mode leaves the IRQ bank as it found it
+	cpu := load(t, "MSR CPSR_c, #0xD2\nMOVW SP, #0x2000\nMSR CPSR_c, #0xD3\nend: B end")
+	if err := cpu.Run(100); err != nil {
+		t.Fatalf("unexpected error running: %v", err)
+	}
+	if sp, _ := cpu.Banked(ModeIRQ); sp != 0x2000 {
+		t.Errorf("expected IRQ SP 0x2000, got 0x%08X", sp)
+	}
+}
+
+func TestSynchronization(t *testing.T) {
+	cases := []struct {
+		name      string
+		source    string
+		registers map[int]uint32
+		halt      string // Expected halt reason, the idle loop at the end by default
+		err       string
+	}{
+		{
+			name:      "exclusive increment",
+			source:    "MOV32 R1, data\nLDREX R0, [R1]\nADD R0, R0, #1\nSTREX R2, R0, [R1]\nLDR R3, [R1]\nend: B end\ndata: .word 5",
+			registers: map[int]uint32{2: 0, 3: 6},
+		},
+		{
+			name:      "STREX without LDREX",
+			source:    "MOV32 R1, data\nMOVW R0, #7\nSTREX R2, R0, [R1]\nLDR R3, [R1]\nend: B end\ndata: .word 5",
+			registers: map[int]uint32{2: 1, 3: 5},
+		},
+		{
+			name:      "store in between",
+			source:    "MOVW R1, #0x1000\nLDREX R0, [R1]\nMOVW R3, #0x1038\nSTR R0, [R3]\nSTREX R2, R0, [R1]\nend: B end",
+			registers: map[int]uint32{2: 1},
+		},
+		{
+			name:      "store to another granule",
+			source:    "MOVW R1, #0x1000\nLDREX R0, [R1]\nMOVW R3, #0x1040\nSTR R0, [R3]\nSTREX R2, R0, [R1]\nend: B end",
+			registers: map[int]uint32{2: 0},
+		},
+		{
+			name:      "CLREX",
+			source:    "MOV32 R1, data\nLDREX R0, [R1]\nCLREX\nSTREX R2, R0, [R1]\nend: B end\ndata: .word 5",
+			registers: map[int]uint32{2: 1},
+		},
+		{
+			name:      "STREX releases the reservation",
+			source:    "MOV32 R1, data\nLDREX R0, [R1]\nSTREX R2, R0, [R1]\nSTREX R3, R0, [R1]\nend: B end\ndata: .word 5",
+			registers: map[int]uint32{2: 0, 3: 1},
+		},
+		{name: "condition failed", source: "MOVW R2, #9\nMOVW R1, #0x100\nSTREXEQ R2, R0, [R1]\nend: B end", registers: map[int]uint32{2: 9}},
+		{name: "unaligned LDREX", source: "MOVW R1, #0x102\nLDREX R0, [R1]", err: "data abort accessing 0x00000102"},
+		{name: "barriers and hints", source: "DMB\nDSB ISH\nISB\nNOP\nYIELD\nMOVW R0, #1\nend: B end", registers: map[int]uint32{0: 1}},
+		{name: "WFI with nothing to wake it", source: "WFI\nMOVW R0, #1", registers: map[int]uint32{0: 0}, halt: "waiting for an interrupt"},
+		{name: "SEV then WFE", source: "SEV\nWFE\nMOVW R0, #1\nWFE\nMOVW R0, #2", registers: map[int]uint32{0: 1}, halt: "waiting for an event"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cpu := load(t, c.source)
+			err := cpu.Run(100)
+			if c.err == "" && err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+				t.Fatalf("expected error containing %q, got %v", c.err, err)
+			}
+			if c.err == "" {
+				halt := c.halt
+				if halt == "" {
+					halt = "idle loop"
+				}
+				if !cpu.Halted || !strings.Contains(cpu.HaltReason, halt) {
+					t.Errorf("expected to halt %s, got %q", halt, cpu.HaltReason)
+				}
+			}
+			for r, expected := range c.registers {
+				if cpu.R[r] != expected {
+					t.Errorf("R%d: expected 0x%08X, got 0x%08X", r, expected, cpu.R[r])
+				}
+			}
+		})
+	}
+
+	// WFI wakes up for a masked interrupt, without taking it
+	cpu := load(t, "WFI\nMOVW R0, #1\nend: B end")
+	cpu.InterruptLines = func() (bool, bool) { return cpu.Steps >= 50, false }
+	if err := cpu.Run(100); err != nil || cpu.R[0] != 1 || cpu.Steps != 52 {
+		t.Errorf("expected WFI to wait 50 steps, got R0 %d after %d steps (%v)", cpu.R[0], cpu.Steps, err)
+	}
+
+	// Waiting in WFI fast-forwards to the next interrupt like an idle loop
+	source := vectors + `start:
+CPSIE i
+end:
+WFI
+B end
+irq:
+MOV32 R0, #0x20000000
+STR R0, [R0]
+ADD R4, R4, #1
+SUBS pc, lr, #4
+undefined: B undefined
+supervisor: B supervisor
+prefetch: B prefetch
+data: B data
+fiq: B fiq`
+	run := func(fast bool) *CPU {
+		cpu := load(t, source)
+		cpu.VBAR = 0x8000
+		cpu.FastForward = fast
+		tick := &ticker{cpu: cpu, next: 1000}
+		cpu.Memory.Map(0x20000000, 4, tick)
+		cpu.InterruptLines = tick.lines
+		cpu.NextInterrupt = func() uint64 { return tick.next }
+		if err := cpu.Run(20000); err != ErrStepLimit {
+			t.Fatalf("expected to run until the step limit, got %v", err)
+		}
+		return cpu
+	}
+	slow, fast := run(false), run(true)
+	if slow.R[4] != 19 {
+		t.Errorf("expected 19 interrupts, got %d", slow.R[4])
+	}
+	if fast.R != slow.R || fast.CPSR != slow.CPSR || fast.Steps != slow.Steps {
+		t.Errorf("expected the same state as running every instruction\nslow: %08X %08X %d\nfast: %08X %08X %d", slow.R, slow.CPSR, slow.Steps, fast.R, fast.CPSR, fast.Steps)
+	}
+}
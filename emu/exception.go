@@ -0,0 +1,116 @@
+package emu
+
+import "fmt"
+
+// Exception is one of the ways the CPU can be diverted to a handler in the vector table.
+type Exception int
+
+const (
+	ExceptionUndefined Exception = iota
+	ExceptionSupervisorCall
+	ExceptionPrefetchAbort
+	ExceptionDataAbort
+	ExceptionIRQ
+	ExceptionFIQ
+)
+
+// exceptionInfo is how each exception is taken: the mode it enters, its offset in the vector table, what the
+// preferred return address is offset by in LR and whether it masks asynchronous aborts and FIQs as well as IRQs.
+// The offsets make the usual returns work: SUBS pc, lr, #0 for undefined instructions and supervisor calls,
+// SUBS pc, lr, #8 for data aborts and SUBS pc, lr, #4 for everything else.
+var exceptionInfo = map[Exception]struct {
+	name     string
+	mode     Mode
+	vector   uint32
+	lrOffset uint32
+	maskA    bool
+	maskF    bool
+}{
+	ExceptionUndefined:      {name: "undefined instruction", mode: ModeUndefined, vector: 0x04, lrOffset: 4},
+	ExceptionSupervisorCall: {name: "supervisor call", mode: ModeSupervisor, vector: 0x08, lrOffset: 4},
+	ExceptionPrefetchAbort:  {name: "prefetch abort", mode: ModeAbort, vector: 0x0C, lrOffset: 4, maskA: true},
+	ExceptionDataAbort:      {name: "data abort", mode: ModeAbort, vector: 0x10, lrOffset: 8, maskA: true},
+	ExceptionIRQ:            {name: "irq", mode: ModeIRQ, vector: 0x18, lrOffset: 4, maskA: true},
+	ExceptionFIQ:            {name: "fiq", mode: ModeFIQ, vector: 0x1C, lrOffset: 4, maskA: true, maskF: true},
+}
+
+func (e Exception) String() string {
+	if info, ok := exceptionInfo[e]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("exception %d", int(e))
+}
+
+// Vector returns the address of an exception's entry in the vector table.
+func (c *CPU) Vector(e Exception) uint32 {
+	return c.VBAR + exceptionInfo[e].vector
+}
+
+// exception takes an exception raised by the instruction at address, or for interrupts before the instruction at
+// address ran: the CPSR is saved in the SPSR of the exception's mode, LR is set up for the return sequence and the
+// PC goes to the vector in ARM state with little endian data. Without a handler mapped at the vector there's nothing to take it, so it
+// reports false and the caller treats it as a fault.
+func (c *CPU) exception(e Exception, address uint32) bool {
+	vector := c.Vector(e)
+	if !c.Memory.Mapped(vector) || c.Memory.Aborts(vector) {
+		return false
+	}
+	info := exceptionInfo[e]
+	cpsr := c.CPSR
+	c.SetMode(info.mode) // Every exception mode is valid
+	c.SetSPSR(cpsr)
+	c.R[LR] = address + info.lrOffset
+	c.CPSR = c.CPSR&^(FlagT|FlagE) | FlagI
+	if info.maskA {
+		c.CPSR |= FlagA
+	}
+	if info.maskF {
+		c.CPSR |= FlagF
+	}
+	c.R[PC] = vector
+	c.waiting = nil
+	if c.ExceptionHook != nil {
+		c.ExceptionHook(e, address)
+	}
+	return true
+}
+
+// trap takes an exception raised by the current instruction, or returns it as a fault if there's no handler.
+func (c *CPU) trap(e Exception, word uint32, reason string) error {
+	if c.exception(e, c.current) {
+		return nil
+	}
+	return &Fault{Address: c.current, Word: word, Reason: reason}
+}
+
+// TakeInterrupt takes a pending FIQ, or failing that a pending IRQ, as long as it isn't masked and there's a handler
+// for it. Step calls it before every instruction. It reports whether an interrupt was taken.
+func (c *CPU) TakeInterrupt() bool {
+	if c.InterruptLines != nil && !(c.Flag(FlagI) && c.Flag(FlagF)) && c.polled != c.Steps+1 {
+		c.IRQ, c.FIQ = c.InterruptLines()
+		c.polled = c.Steps + 1
+	}
+	if c.FIQ && !c.Flag(FlagF) && c.exception(ExceptionFIQ, c.R[PC]) {
+		return true
+	}
+	return c.IRQ && !c.Flag(FlagI) && c.exception(ExceptionIRQ, c.R[PC])
+}
+
+// returnFromException restores the CPSR from the SPSR and jumps to target, the end of SUBS pc, lr and LDM with ^.
+func (c *CPU) returnFromException(target uint32, word uint32) error {
+	spsr, ok := c.SPSR()
+	if !ok {
+		return &Fault{Address: c.current, Word: word, Reason: fmt.Sprintf("exception return in %s mode", c.Mode())}
+	}
+	if err := c.SetMode(Mode(spsr & ModeMask)); err != nil {
+		return &Fault{Address: c.current, Word: word, Reason: fmt.Sprintf("exception return to %v", err)}
+	}
+	c.CPSR = spsr
+	c.Event = true
+	if spsr&FlagT != 0 {
+		c.R[PC] = target &^ 1
+	} else {
+		c.R[PC] = target &^ 3
+	}
+	return nil
+}
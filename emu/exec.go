@@ -0,0 +1,479 @@
+package emu
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Step executes a single instruction, after taking any pending interrupt. Instructions that raise an exception
+// count as executed when there's a handler to take it, and return a *Fault without changing anything otherwise.
+func (c *CPU) Step() error {
+	c.TakeInterrupt()
+	if c.waiting != nil {
+		if !c.woken(c.waiting) {
+			c.Steps++ // Time passes while the core sleeps
+			return nil
+		}
+		c.waiting = nil
+	}
+	address := c.R[PC]
+	if c.CPSR&FlagT != 0 {
+		return &Fault{Address: address, Reason: "thumb state isn't supported"}
+	}
+	if address%4 != 0 {
+		return &Fault{Address: address, Reason: "unaligned PC"}
+	}
+	c.current = address
+	if !c.Memory.Mapped(address) || c.Memory.Aborts(address) {
+		if !c.exception(ExceptionPrefetchAbort, address) {
+			return &Fault{Address: address, Reason: "executing unmapped memory"}
+		}
+		c.Steps++
+		return nil
+	}
+	word := c.Memory.Fetch32(address)
+	instruction := c.decode(address, word)
+	if instruction == nil {
+		return c.undefined(word)
+	}
+	if svc, ok := instruction.(*parser.InstructionSupervisorCall); ok {
+		return c.supervisorCall(svc, word)
+	}
+
+	c.Steps++
+	c.R[PC] = address + 4
+	return c.execute(instruction, word)
+}
+
+// undefined handles a word that doesn't decode, as an undefined instruction.
+func (c *CPU) undefined(word uint32) error {
+	if !c.exception(ExceptionUndefined, c.current) {
+		return &Fault{Address: c.current, Word: word, Reason: "undefined instruction"}
+	}
+	c.Steps++
+	return nil
+}
+
+// supervisorCall runs an SVC. When its condition passes SupervisorCall gets the first go at it, and if that doesn't
+// handle it the core traps to the supervisor call handler.
+func (c *CPU) supervisorCall(i *parser.InstructionSupervisorCall, word uint32) error {
+	if !c.ConditionPassed(i.Condition) {
+		c.Steps++
+		c.R[PC] = c.current + 4
+		return nil
+	}
+	if c.SupervisorCall != nil {
+		handled, err := c.SupervisorCall(i.Immediate)
+		if handled || err != nil {
+			c.Steps++
+			c.R[PC] = c.current + 4
+			return err
+		}
+	}
+	if !c.exception(ExceptionSupervisorCall, c.current) {
+		return &Fault{Address: c.current, Word: word, Reason: "supervisor call"}
+	}
+	c.Steps++
+	return nil
+}
+
+// decode decodes the word at address, reusing the last result while the word there doesn't change.
+func (c *CPU) decode(address uint32, word uint32) types.Instruction {
+	if cached, ok := c.decoded[address]; ok && cached.word == word {
+		return cached.instruction
+	}
+	instruction, err := parser.Decode(word)
+	if err != nil {
+		instruction = nil
+	}
+	c.decoded[address] = decodedWord{word: word, instruction: instruction}
+	return instruction
+}
+
+// reg reads a register as an operand, the PC reads as the address of the current instruction plus 8.
+func (c *CPU) reg(r uint32) uint32 {
+	if r == PC {
+		return c.current + 8
+	}
+	return c.R[r]
+}
+
+// setReg writes a register, writing the PC branches to the value.
+func (c *CPU) setReg(r uint32, value uint32) {
+	if r == PC {
+		c.branch(value)
+		return
+	}
+	c.R[r] = value
+}
+
+// branch jumps to target. Bit 0 selects Thumb state, like BX and loads into the PC do on ARMv7.
+func (c *CPU) branch(target uint32) {
+	c.setFlag(FlagT, target&1 != 0)
+	c.R[PC] = target &^ 1
+}
+
+func (c *CPU) execute(instruction types.Instruction, word uint32) error {
+	switch i := instruction.(type) {
+	case *parser.InstructionMOV:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		if i.Mnemonic == types.MnemonicMOVT {
+			c.setReg(i.DestRegister, c.reg(i.DestRegister)&0xFFFF|i.Immediate<<16)
+		} else {
+			c.setReg(i.DestRegister, i.Immediate)
+		}
+	case *parser.InstructionArithmetic:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		return c.arithmetic(i, word)
+	case *parser.InstructionMemory:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		return c.loadStore(i, word)
+	case *parser.InstructionMemoryMultiple:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		return c.loadStoreMultiple(i, word)
+	case *parser.InstructionBranch:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		target := i.Target(c.current)
+		if i.Mnemonic == types.MnemonicBLX {
+			// Always a call into Thumb code
+			c.R[LR] = c.current + 4
+			c.branch(target | 1)
+			return nil
+		}
+		if i.LBit == 1 {
+			c.R[LR] = c.current + 4
+		} else if target == c.current && c.Flag(FlagI) && c.Flag(FlagF) {
+			// Only an interrupt can get out of a branch to itself
+			c.Halt(fmt.Sprintf("idle loop at 0x%08X", c.current))
+		}
+		c.R[PC] = target
+	case *parser.InstructionBranchExchange:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		target := c.reg(i.BaseRegister)
+		if i.Mnemonic == types.MnemonicBLX {
+			c.R[LR] = c.current + 4
+		}
+		c.branch(target)
+	case *parser.InstructionStatus:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		return c.status(i, word)
+	case *parser.InstructionChangeState:
+		return c.changeState(i, word)
+	case *parser.InstructionCoprocessor:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		return c.coprocessor(i, word)
+	case *parser.InstructionBarrier:
+		if i.Mnemonic == types.MnemonicCLREX {
+			c.Memory.Release(c.ID)
+		}
+		// Every access completes before the next instruction runs, so the barriers have nothing to wait for
+	case *parser.InstructionHint:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		c.hint(i)
+	case *parser.InstructionExclusive:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		return c.exclusive(i, word)
+	case *parser.InstructionMedia:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		c.R[i.DestRegister] = c.media(i)
+	case *parser.InstructionFloat:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		if reason := c.floatDenied(nil); reason != "" {
+			return c.trap(ExceptionUndefined, word, reason)
+		}
+		c.float(i)
+	case *parser.InstructionFloatTransfer:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		if reason := c.floatDenied(nil); reason != "" {
+			return c.trap(ExceptionUndefined, word, reason)
+		}
+		c.floatTransfer(i)
+	case *parser.InstructionFloatSystem:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		if reason := c.floatDenied(i); reason != "" {
+			return c.trap(ExceptionUndefined, word, reason)
+		}
+		c.floatSystem(i)
+	case *parser.InstructionFloatMemory:
+		if !c.ConditionPassed(i.Condition) {
+			return nil
+		}
+		if reason := c.floatDenied(nil); reason != "" {
+			return c.trap(ExceptionUndefined, word, reason)
+		}
+		return c.floatMemory(i, word)
+	case *parser.InstructionVector:
+		if reason := c.floatDenied(nil); reason != "" {
+			return c.trap(ExceptionUndefined, word, reason)
+		}
+		c.vector(i)
+	case *parser.InstructionVectorMemory:
+		if reason := c.floatDenied(nil); reason != "" {
+			return c.trap(ExceptionUndefined, word, reason)
+		}
+		return c.vectorMemory(i, word)
+	default:
+		return &Fault{Address: c.current, Word: word, Reason: "unsupported instruction"}
+	}
+	return nil
+}
+
+// arithmetic runs ADD, SUB, AND and ORR, setting the flags when the S bit is set. With the S bit and the PC as the
+// destination it returns from an exception instead, as in SUBS pc, lr, #4.
+func (c *CPU) arithmetic(i *parser.InstructionArithmetic, word uint32) error {
+	a, b := c.reg(i.BaseRegister), i.Immediate
+	var result uint32
+	var carry, overflow bool
+	switch i.Mnemonic {
+	case types.MnemonicADD:
+		var carryOut uint32
+		result, carryOut = bits.Add32(a, b, 0)
+		carry = carryOut != 0
+		overflow = (a^result)&(b^result)&(1<<31) != 0
+	case types.MnemonicSUB:
+		var borrow uint32
+		result, borrow = bits.Sub32(a, b, 0)
+		carry = borrow == 0
+		overflow = (a^b)&(a^result)&(1<<31) != 0
+	case types.MnemonicAND, types.MnemonicORR:
+		if i.Mnemonic == types.MnemonicAND {
+			result = a & b
+		} else {
+			result = a | b
+		}
+		// Logical operations only touch C when the immediate was rotated, and leave V alone
+		carry, overflow = c.Flag(FlagC), c.Flag(FlagV)
+		if b > 0xFF {
+			carry = b&(1<<31) != 0
+		}
+	}
+
+	if i.SBit == 1 && i.DestRegister == PC {
+		return c.returnFromException(result, word)
+	}
+	c.setReg(i.DestRegister, result)
+	if i.SBit == 1 {
+		c.setNZ(result)
+		c.setFlag(FlagC, carry)
+		c.setFlag(FlagV, overflow)
+	}
+	return nil
+}
+
+// media returns the result of CLZ, REV, REV16, an extend, a bit field instruction, SDIV or UDIV. Dividing by zero
+// gives 0, as it does on cores that don't trap it.
+func (c *CPU) media(i *parser.InstructionMedia) uint32 {
+	value := c.R[i.OperandRegister]
+	mask := uint32(uint64(1)<<i.Width - 1) // Bit field only
+	switch i.Mnemonic {
+	case types.MnemonicCLZ:
+		return uint32(bits.LeadingZeros32(value))
+	case types.MnemonicREV:
+		return bits.ReverseBytes32(value)
+	case types.MnemonicREV16:
+		return value&0xFF00FF00>>8 | value&0x00FF00FF<<8
+	case types.MnemonicUXTB:
+		return bits.RotateLeft32(value, -int(i.Rotation)) & 0xFF
+	case types.MnemonicUXTH:
+		return bits.RotateLeft32(value, -int(i.Rotation)) & 0xFFFF
+	case types.MnemonicSXTB:
+		return uint32(int8(bits.RotateLeft32(value, -int(i.Rotation))))
+	case types.MnemonicSXTH:
+		return uint32(int16(bits.RotateLeft32(value, -int(i.Rotation))))
+	case types.MnemonicUBFX:
+		return c.R[i.BaseRegister] >> i.LSB & mask
+	case types.MnemonicSBFX:
+		return uint32(int32(c.R[i.BaseRegister]<<(32-i.LSB-i.Width)) >> (32 - i.Width))
+	case types.MnemonicBFI:
+		return c.R[i.DestRegister]&^(mask<<i.LSB) | (c.R[i.BaseRegister]&mask)<<i.LSB
+	case types.MnemonicSDIV:
+		if value == 0 {
+			return 0
+		}
+		return uint32(int32(c.R[i.BaseRegister]) / int32(value)) // The most negative number divided by -1 wraps back to itself
+	case types.MnemonicUDIV:
+		if value == 0 {
+			return 0
+		}
+		return c.R[i.BaseRegister] / value
+	}
+	return 0
+}
+
+// read32 loads a data word, byte swapped when SETEND BE has made data accesses big endian.
+func (c *CPU) read32(address uint32) uint32 {
+	if c.Flag(FlagE) {
+		return bits.ReverseBytes32(c.Memory.Read32(address))
+	}
+	return c.Memory.Read32(address)
+}
+
+// write32 stores a data word, byte swapped when data accesses are big endian.
+func (c *CPU) write32(address uint32, value uint32) {
+	if c.Flag(FlagE) {
+		value = bits.ReverseBytes32(value)
+	}
+	c.Memory.Write32(address, value)
+}
+
+// dataAbort raises a data abort for an access to address.
+func (c *CPU) dataAbort(address uint32, word uint32) error {
+	c.FaultAddress = address
+	return c.trap(ExceptionDataAbort, word, fmt.Sprintf("data abort accessing 0x%08X", address))
+}
+
+// loadStore runs LDR and STR. Pre-indexed transfers (P=1) use the offset address and write it back if W is set,
+// post-indexed ones use the base address and always write back. Nothing changes when the access aborts.
+func (c *CPU) loadStore(i *parser.InstructionMemory, word uint32) error {
+	base := c.reg(i.BaseRegister)
+	offsetAddress := base + i.Offset
+	if i.UBit == 0 {
+		offsetAddress = base - i.Offset
+	}
+	address := base
+	if i.PBit == 1 {
+		address = offsetAddress
+	}
+	writeback := i.PBit == 0 || i.WBit == 1
+	if c.Memory.Aborts(address) || c.Memory.Aborts(address+3) {
+		return c.dataAbort(address, word)
+	}
+
+	if i.Mnemonic == types.MnemonicSTR {
+		c.write32(address, c.reg(i.DestRegister))
+		if writeback {
+			c.setReg(i.BaseRegister, offsetAddress)
+		}
+		return nil
+	}
+	value := c.read32(address)
+	if writeback {
+		c.setReg(i.BaseRegister, offsetAddress)
+	}
+	c.setReg(i.DestRegister, value)
+	return nil
+}
+
+// loadStoreMultiple runs LDM and STM in any of the IA, IB, DA and DB modes. The lowest register always goes to the
+// lowest address. With the S bit, LDM with the PC in the list returns from an exception once everything is loaded,
+// and otherwise both transfer the user mode registers. Nothing changes when any of the accesses aborts.
+func (c *CPU) loadStoreMultiple(i *parser.InstructionMemoryMultiple, word uint32) error {
+	list := i.Offset & 0xFFFF
+	size := uint32(bits.OnesCount32(list)) * 4
+	base := c.reg(i.BaseRegister)
+
+	start, end := base, base+size
+	if i.UBit == 0 {
+		start, end = base-size, base-size
+	}
+	if i.PBit == i.UBit {
+		start += 4 // IB and DB skip the word at the base
+	}
+	address := start &^ 3
+	for a := address; a < address+size; a += 4 {
+		if c.Memory.Aborts(a) {
+			return c.dataAbort(a, word)
+		}
+	}
+	exceptionReturn := i.SBit == 1 && i.Mnemonic == types.MnemonicLDM && list&(1<<PC) != 0
+	userBank := i.SBit == 1 && !exceptionReturn
+
+	if i.Mnemonic == types.MnemonicSTM {
+		for r := uint32(0); r < 16; r++ {
+			if list&(1<<r) != 0 {
+				value := c.reg(r)
+				if userBank && r != PC {
+					value = *c.userRegister(r)
+				}
+				c.write32(address, value)
+				address += 4
+			}
+		}
+		if i.WBit == 1 {
+			c.setReg(i.BaseRegister, end)
+		}
+		return nil
+	}
+
+	values := make([]uint32, 0, 16)
+	for r := uint32(0); r < 16; r++ {
+		if list&(1<<r) != 0 {
+			values = append(values, c.read32(address))
+			address += 4
+		}
+	}
+	if i.WBit == 1 {
+		c.setReg(i.BaseRegister, end)
+	}
+	for r := uint32(0); r < 16; r++ {
+		if list&(1<<r) == 0 {
+			continue
+		}
+		switch {
+		case userBank:
+			*c.userRegister(r) = values[0]
+		case exceptionReturn && r == PC:
+			return c.returnFromException(values[0], word)
+		default:
+			c.setReg(r, values[0])
+		}
+		values = values[1:]
+	}
+	return nil
+}
+
+// exclusive runs LDREX, which loads a word and reserves it for this core, and STREX, which only stores if nothing has
+// written to the reserved granule since, setting Rd to 0 if it did and 1 if it didn't. Exclusive accesses have to be
+// aligned.
+func (c *CPU) exclusive(i *parser.InstructionExclusive, word uint32) error {
+	address := c.R[i.BaseRegister]
+	if address%4 != 0 || c.Memory.Aborts(address) {
+		return c.dataAbort(address, word)
+	}
+	if i.Mnemonic == types.MnemonicLDREX {
+		value := c.read32(address)
+		c.Memory.Reserve(c.ID, address)
+		c.R[i.DestRegister] = value
+		return nil
+	}
+
+	status := uint32(1)
+	if c.Memory.Reserved(c.ID, address) {
+		c.write32(address, c.R[i.DestRegister])
+		status = 0
+	}
+	c.Memory.Release(c.ID)
+	c.R[i.StatusRegister] = status
+	return nil
+}
@@ -0,0 +1,135 @@
+package emu
+
+import (
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// fastForward skips most of a countdown loop starting at the PC:
+//
+//	loop:
+//	SUBS Rn, Rn, #k
+//	B<cond> loop
+//
+// Every iteration but the last two is done at once, counting the instructions they would have taken, so the result
+// and the clock are the same as running them. It reports whether anything was skipped.
+func (c *CPU) fastForward() bool {
+	address := c.R[PC]
+	if c.haltAt[address] || c.haltAt[address+4] || !c.Memory.Mapped(address+4) {
+		return false
+	}
+	subs, ok := c.decode(address, c.Memory.Fetch32(address)).(*parser.InstructionArithmetic)
+	if !ok || subs.Mnemonic != types.MnemonicSUB || subs.SBit != 1 || subs.Condition != types.ConditionAL ||
+		subs.DestRegister != subs.BaseRegister || subs.DestRegister == PC || subs.Immediate == 0 {
+		return false
+	}
+	branch, ok := c.decode(address+4, c.Memory.Fetch32(address+4)).(*parser.InstructionBranch)
+	if !ok || branch.LBit != 0 || branch.Target(address+4) != address {
+		return false
+	}
+
+	iterations := countdownIterations(c.R[subs.DestRegister], subs.Immediate, branch.Condition)
+	if iterations < 4 {
+		return false
+	}
+	skip := iterations - 2 // Leave the last two to run normally so the flags end up right
+	if c.stepLimit != 0 {
+		skip = min(skip, (c.stepLimit-c.Steps)/2)
+	}
+	if steps, ok := c.untilInterrupt(); ok {
+		skip = min(skip, steps/2)
+	}
+	if skip == 0 {
+		return false
+	}
+	c.R[subs.DestRegister] -= uint32(skip) * subs.Immediate
+	c.Steps += skip * 2
+	return true
+}
+
+// fastForwardIdle skips a branch to itself, or sleeping in WFI, ahead to the next time an interrupt can arrive,
+// counting the iterations in between. It reports whether anything was skipped.
+func (c *CPU) fastForwardIdle() bool {
+	address := c.R[PC]
+	if c.waiting != nil {
+		if c.waiting.Mnemonic != types.MnemonicWFI || c.woken(c.waiting) {
+			return false
+		}
+	} else {
+		if !c.Memory.Mapped(address) {
+			return false
+		}
+		branch, ok := c.decode(address, c.Memory.Fetch32(address)).(*parser.InstructionBranch)
+		if !ok || branch.LBit != 0 || branch.Condition != types.ConditionAL || branch.Target(address) != address {
+			return false
+		}
+	}
+	skip, ok := c.untilInterrupt()
+	if !ok {
+		return false
+	}
+	if c.stepLimit != 0 {
+		skip = min(skip, c.stepLimit-c.Steps)
+	}
+	if skip == 0 {
+		return false
+	}
+	c.Steps += skip
+	return true
+}
+
+// untilInterrupt returns how many instructions can run before the interrupt lines might change. It reports false
+// when there's no such limit, because interrupts are masked or nothing is counting towards one.
+func (c *CPU) untilInterrupt() (uint64, bool) {
+	if c.NextInterrupt == nil || c.Flag(FlagI) && c.Flag(FlagF) {
+		return 0, false
+	}
+	next := c.NextInterrupt()
+	if next == 0 {
+		return 0, false
+	}
+	if next <= c.Steps {
+		return 0, true
+	}
+	return next - c.Steps, true
+}
+
+// countdownIterations returns how many times a SUBS of k from value runs before the branch condition fails, or 0 if
+// it's not a loop that can be worked out without running it.
+func countdownIterations(value uint32, k uint32, condition types.ConditionType) uint64 {
+	if k >= 1<<31 {
+		return 0 // Negative as a signed value, which turns the signed conditions around
+	}
+	signed := int64(int32(value))
+	switch condition {
+	case types.ConditionNE:
+		// Stops at exactly zero, anything that doesn't divide evenly wraps around first
+		if value%k != 0 {
+			return 0
+		}
+		return uint64(value / k)
+	case types.ConditionPL, types.ConditionGE:
+		// Stops once the result goes negative, as long as it starts at zero or above
+		if signed < 0 {
+			return 0
+		}
+		return uint64(signed/int64(k)) + 1
+	case types.ConditionGT:
+		// Stops once the result reaches zero or below
+		if signed <= 0 {
+			return 0
+		}
+		return uint64((signed + int64(k) - 1) / int64(k))
+	case types.ConditionHI:
+		// Unsigned version of GT
+		if value == 0 {
+			return 0
+		}
+		return (uint64(value) + uint64(k) - 1) / uint64(k)
+	case types.ConditionCS:
+		// Stops when the subtraction borrows
+		return uint64(value/k) + 1
+	default:
+		return 0
+	}
+}
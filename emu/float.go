@@ -0,0 +1,265 @@
+package emu
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// VFP identification and control
+const (
+	FPSID       = 0x41023075 // VFPv4 on a Cortex-A7
+	FPEXCEnable = 1 << 30    // FPEXC.EN, which has to be set for anything but moves to and from FPSID and FPEXC
+)
+
+// floatDenied returns why a VFP or NEON instruction can't run, or "" if it can. CPACR has to grant access to cp10 and
+// cp11, to privileged modes for privileged code and full access for user mode. Then FPEXC.EN has to be set, except
+// for VMRS and VMSR of FPSID and FPEXC, which only privileged code can use at all.
+func (c *CPU) floatDenied(system *parser.InstructionFloatSystem) string {
+	cpacr := c.cp15["CPACR"] >> 20 & 0xF
+	user := c.Mode() == ModeUser
+	if user && cpacr != 0xF || !user && cpacr&0b0101 != 0b0101 {
+		return "VFP access not granted in CPACR"
+	}
+	if system != nil && system.System != parser.FPSCR {
+		if user {
+			return "FPSID or FPEXC access in user mode"
+		}
+		return ""
+	}
+	if c.FPEXC&FPEXCEnable == 0 {
+		return "VFP disabled in FPEXC"
+	}
+	return ""
+}
+
+// single and setSingle read and write S registers, which are the low and high halves of the D registers.
+func (c *CPU) single(r uint32) uint32 {
+	return uint32(c.D[r/2] >> (r % 2 * 32))
+}
+
+func (c *CPU) setSingle(r uint32, value uint32) {
+	shift := r % 2 * 32
+	c.D[r/2] = c.D[r/2]&^(0xFFFFFFFF<<shift) | uint64(value)<<shift
+}
+
+// float runs the VFP data processing instructions, with IEEE 754 round to nearest arithmetic as in the FPSCR's reset
+// state. The cumulative exception flags aren't kept.
+func (c *CPU) float(i *parser.InstructionFloat) {
+	switch i.Mnemonic {
+	case types.MnemonicVMOV:
+		if i.DataType.Size == 64 {
+			c.D[i.DestRegister] = c.D[i.SecondRegister]
+		} else {
+			c.setSingle(i.DestRegister, c.single(i.SecondRegister))
+		}
+	case types.MnemonicVCVT:
+		c.convert(i)
+	default:
+		if i.DataType.Size == 64 {
+			a, b := math.Float64frombits(c.D[i.FirstRegister]), math.Float64frombits(c.D[i.SecondRegister])
+			c.D[i.DestRegister] = math.Float64bits(floatOp(i.Mnemonic, a, b))
+			return
+		}
+		a, b := math.Float32frombits(c.single(i.FirstRegister)), math.Float32frombits(c.single(i.SecondRegister))
+		c.setSingle(i.DestRegister, math.Float32bits(float32(floatOp(i.Mnemonic, float64(a), float64(b)))))
+	}
+}
+
+// floatOp adds, subtracts, multiplies or divides. Single precision operands are widened first, which rounds the same
+// as single precision arithmetic once the result is narrowed back.
+func floatOp(mnemonic types.MnemonicType, a float64, b float64) float64 {
+	switch mnemonic {
+	case types.MnemonicVADD:
+		return a + b
+	case types.MnemonicVSUB:
+		return a - b
+	case types.MnemonicVMUL:
+		return a * b
+	}
+	return a / b
+}
+
+// convert runs VCVT. Conversions to integers round towards zero and saturate, with NaN becoming 0.
+func (c *CPU) convert(i *parser.InstructionFloat) {
+	var value float64
+	switch i.SourceType {
+	case types.DataTypeF64:
+		value = math.Float64frombits(c.D[i.SecondRegister])
+	case types.DataTypeF32:
+		value = float64(math.Float32frombits(c.single(i.SecondRegister)))
+	case types.DataTypeS32:
+		value = float64(int32(c.single(i.SecondRegister)))
+	case types.DataTypeU32:
+		value = float64(c.single(i.SecondRegister))
+	}
+
+	switch i.DataType {
+	case types.DataTypeF64:
+		c.D[i.DestRegister] = math.Float64bits(value)
+	case types.DataTypeF32:
+		c.setSingle(i.DestRegister, math.Float32bits(float32(value)))
+	case types.DataTypeS32:
+		c.setSingle(i.DestRegister, uint32(int32(saturate(value, math.MinInt32, math.MaxInt32))))
+	case types.DataTypeU32:
+		c.setSingle(i.DestRegister, uint32(saturate(value, 0, math.MaxUint32)))
+	}
+}
+
+// saturate truncates value and clamps it to the range of an integer type.
+func saturate(value float64, low float64, high float64) float64 {
+	switch {
+	case math.IsNaN(value):
+		return 0
+	case value <= low:
+		return low
+	case value >= high:
+		return high
+	}
+	return math.Trunc(value)
+}
+
+// floatTransfer runs VMOV between ARM registers and an S or D register.
+func (c *CPU) floatTransfer(i *parser.InstructionFloatTransfer) {
+	switch {
+	case i.Double && i.ToARM == 1:
+		c.R[i.Register], c.R[i.Register2] = uint32(c.D[i.FPRegister]), uint32(c.D[i.FPRegister]>>32)
+	case i.Double:
+		c.D[i.FPRegister] = uint64(c.R[i.Register2])<<32 | uint64(c.R[i.Register])
+	case i.ToARM == 1:
+		c.R[i.Register] = c.single(i.FPRegister)
+	default:
+		c.setSingle(i.FPRegister, c.R[i.Register])
+	}
+}
+
+// floatSystem runs VMRS and VMSR. Writes to FPSID are ignored and only EN can be set in FPEXC.
+func (c *CPU) floatSystem(i *parser.InstructionFloatSystem) {
+	if i.Mnemonic == types.MnemonicVMSR {
+		switch i.System {
+		case parser.FPSCR:
+			c.FPSCR = c.R[i.Register]
+		case parser.FPEXC:
+			c.FPEXC = c.R[i.Register] & FPEXCEnable
+		}
+		return
+	}
+
+	var value uint32
+	switch i.System {
+	case parser.FPSID:
+		value = FPSID
+	case parser.FPSCR:
+		value = c.FPSCR
+	case parser.FPEXC:
+		value = c.FPEXC
+	}
+	if i.Register == PC {
+		// APSR_nzcv
+		c.CPSR = c.CPSR&^0xF0000000 | value&0xF0000000
+		return
+	}
+	c.R[i.Register] = value
+}
+
+// floatMemory runs VLDR and VSTR, which have to be word aligned. A D register is two words with the low one first,
+// or the high one first when data accesses are big endian.
+func (c *CPU) floatMemory(i *parser.InstructionFloatMemory, word uint32) error {
+	address := c.reg(i.BaseRegister) + i.Offset
+	size := uint32(4)
+	if i.Double {
+		size = 8
+	}
+	if address%4 != 0 || c.Memory.Aborts(address) || c.Memory.Aborts(address+size-1) {
+		return c.dataAbort(address, word)
+	}
+
+	if !i.Double {
+		if i.Mnemonic == types.MnemonicVSTR {
+			c.write32(address, c.single(i.FPRegister))
+		} else {
+			c.setSingle(i.FPRegister, c.read32(address))
+		}
+		return nil
+	}
+	first, second := address, address+4 // Low and high words
+	if c.Flag(FlagE) {
+		first, second = second, first
+	}
+	if i.Mnemonic == types.MnemonicVSTR {
+		c.write32(first, uint32(c.D[i.FPRegister]))
+		c.write32(second, uint32(c.D[i.FPRegister]>>32))
+		return nil
+	}
+	c.D[i.FPRegister] = uint64(c.read32(second))<<32 | uint64(c.read32(first))
+	return nil
+}
+
+// vector runs the NEON VADD and VSUB, lane by lane with each lane wrapping around on its own.
+func (c *CPU) vector(i *parser.InstructionVector) {
+	d, n, m, count := i.DestRegister, i.FirstRegister, i.SecondRegister, uint32(1)
+	if i.Quad {
+		d, n, m, count = d*2, n*2, m*2, 2
+	}
+	size := i.DataType.Size
+	mask := uint64(1)<<size - 1 // All ones for 64 bit lanes, as the shift leaves nothing
+	for r := uint32(0); r < count; r++ {
+		var result uint64
+		for shift := uint32(0); shift < 64; shift += size {
+			a, b := c.D[n+r]>>shift&mask, c.D[m+r]>>shift&mask
+			lane := a + b
+			if i.Mnemonic == types.MnemonicVSUB {
+				lane = a - b
+			}
+			result |= lane & mask << shift
+		}
+		c.D[d+r] = result
+	}
+}
+
+// vectorMemory runs VLD1 and VST1, transferring the list's elements in order from the base address. Elements are
+// byte swapped when data accesses are big endian. Nothing changes when any of the accesses aborts.
+func (c *CPU) vectorMemory(i *parser.InstructionVectorMemory, word uint32) error {
+	base := c.R[i.BaseRegister]
+	length := i.Count * 8
+	for offset := uint32(0); offset < length; offset++ {
+		if c.Memory.Aborts(base + offset) {
+			return c.dataAbort(base+offset, word)
+		}
+	}
+
+	size := i.DataType.Size / 8
+	mask := uint64(1)<<(size*8) - 1 // All ones for 64 bit elements
+	for offset := uint32(0); offset < length; offset += size {
+		r, shift := i.FirstRegister+offset/8, offset%8*8
+		if i.Mnemonic == types.MnemonicVST1 {
+			element := c.D[r] >> shift & mask
+			if c.Flag(FlagE) {
+				element = bits.ReverseBytes64(element) >> (64 - size*8)
+			}
+			for b := uint32(0); b < size; b++ {
+				c.Memory.Write8(base+offset+b, uint8(element>>(b*8)))
+			}
+			continue
+		}
+		var element uint64
+		for b := uint32(0); b < size; b++ {
+			element |= uint64(c.Memory.Read8(base+offset+b)) << (b * 8)
+		}
+		if c.Flag(FlagE) {
+			element = bits.ReverseBytes64(element) >> (64 - size*8)
+		}
+		c.D[r] = c.D[r]&^(mask<<shift) | element<<shift
+	}
+
+	switch i.IndexRegister {
+	case PC:
+	case SP:
+		c.R[i.BaseRegister] = base + length
+	default:
+		c.R[i.BaseRegister] = base + c.R[i.IndexRegister]
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package emu
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+
+	"github.com/robertjshirts/rogasmic/linker"
+)
+
+// LoadImage copies a flat image into memory at base and starts executing from there.
+func (c *CPU) LoadImage(data []byte, base uint32) {
+	c.Memory.Load(base, data)
+	c.R[PC] = base
+}
+
+// LoadLinked copies a linked image into memory at its base address and starts executing from its entry point.
+func (c *CPU) LoadLinked(image *linker.Image) {
+	c.LoadImage(image.Data, image.Base)
+	c.R[PC] = image.Entry
+}
+
+// LoadSource assembles the text of a source file, links it on its own with the default script and loads it into a new
+// CPU. The image is returned for its symbols.
+func LoadSource(file string, text string) (*CPU, *linker.Image, error) {
+	_, image, err := linker.LinkSource(file, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := New(NewMemory())
+	c.LoadLinked(image)
+	return c, image, nil
+}
+
+// LoadELF loads the PT_LOAD segments of a 32 bit little-endian ARM ELF file at their physical addresses and starts
+// executing from the entry point.
+func (c *CPU) LoadELF(data []byte) error {
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if file.Class != elf.ELFCLASS32 || file.Data != elf.ELFDATA2LSB || file.Machine != elf.EM_ARM {
+		return fmt.Errorf("expected a 32 bit little-endian ARM ELF file, got %s %s %s", file.Class, file.Data, file.Machine)
+	}
+
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		segment := make([]byte, prog.Memsz) // Anything past Filesz is .bss and stays zero
+		if _, err := io.ReadFull(prog.Open(), segment[:prog.Filesz]); err != nil {
+			return fmt.Errorf("error reading segment at 0x%08X: %w", prog.Paddr, err)
+		}
+		c.Memory.Load(uint32(prog.Paddr), segment)
+	}
+	c.R[PC] = uint32(file.Entry)
+	return nil
+}
+
+// IsELF reports whether data starts with the ELF magic number.
+func IsELF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(elf.ELFMAG))
+}
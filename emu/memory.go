@@ -0,0 +1,222 @@
+package emu
+
+import "encoding/binary"
+
+const pageSize = 4096
+
+// Memory is a sparse, little-endian 32 bit address space. Pages are allocated the first time they're written, reads
+// from anywhere else return 0. Devices can be mapped over ranges of it.
+type Memory struct {
+	pages   map[uint32]*[pageSize]byte
+	devices []mapping
+	aborts  []mapping // Ranges nothing answers on, without a device
+
+	// reservations are the exclusive monitors, the granule each core last loaded from with LDREX, by core number. A
+	// write anywhere in the granule, by any core, clears them.
+	reservations map[uint32]uint32
+
+	// WriteHook, if set, is called with the address and size of every write, e.g. for watchpoints.
+	WriteHook func(address uint32, size uint32)
+	// ReadHook, if set, is called with the address and size of every read except instruction fetches, see Fetch32.
+	ReadHook func(address uint32, size uint32)
+}
+
+// Device is a memory-mapped peripheral. Offsets are relative to where it's mapped and always word aligned.
+type Device interface {
+	Read32(offset uint32) uint32
+	Write32(offset uint32, value uint32)
+}
+
+type mapping struct {
+	base   uint32
+	size   uint32
+	device Device
+}
+
+func NewMemory() *Memory {
+	return &Memory{pages: make(map[uint32]*[pageSize]byte)}
+}
+
+// Map places a device over size bytes starting at base. Accesses there go to the device instead of memory.
+func (m *Memory) Map(base uint32, size uint32, device Device) {
+	m.devices = append(m.devices, mapping{base: base, size: size, device: device})
+}
+
+// MapAbort marks size bytes starting at base as somewhere nothing answers, so the CPU raises an abort when it
+// accesses them. Devices mapped over the range still answer.
+func (m *Memory) MapAbort(base uint32, size uint32) {
+	m.aborts = append(m.aborts, mapping{base: base, size: size})
+}
+
+// Aborts reports whether accessing address raises an abort. The memory itself never refuses an access, it's up to
+// the CPU to check.
+func (m *Memory) Aborts(address uint32) bool {
+	for _, a := range m.aborts {
+		if address-a.base < a.size {
+			return !m.IsDevice(address)
+		}
+	}
+	return false
+}
+
+// device finds the device mapped at address, along with the word aligned offset into it.
+func (m *Memory) device(address uint32) (Device, uint32) {
+	for _, d := range m.devices {
+		if address-d.base < d.size {
+			return d.device, (address - d.base) &^ 3
+		}
+	}
+	return nil, 0
+}
+
+// IsDevice reports whether a device is mapped at address. Reading a device can change its state, so anything just
+// looking at memory should leave those addresses alone.
+func (m *Memory) IsDevice(address uint32) bool {
+	device, _ := m.device(address)
+	return device != nil
+}
+
+// Mapped reports whether anything has ever been written to the page holding address.
+func (m *Memory) Mapped(address uint32) bool {
+	_, ok := m.pages[address/pageSize]
+	return ok
+}
+
+func (m *Memory) page(address uint32, allocate bool) *[pageSize]byte {
+	page, ok := m.pages[address/pageSize]
+	if !ok && allocate {
+		page = new([pageSize]byte)
+		m.pages[address/pageSize] = page
+	}
+	return page
+}
+
+func (m *Memory) Read8(address uint32) uint8 {
+	if m.ReadHook != nil {
+		m.ReadHook(address, 1)
+	}
+	return m.read8(address)
+}
+
+func (m *Memory) read8(address uint32) uint8 {
+	if device, offset := m.device(address); device != nil {
+		return uint8(device.Read32(offset) >> (address % 4 * 8))
+	}
+	page := m.page(address, false)
+	if page == nil {
+		return 0
+	}
+	return page[address%pageSize]
+}
+
+// Write8 writes a byte. Devices only take whole words, so the rest of the word is read back and written with it.
+func (m *Memory) Write8(address uint32, value uint8) {
+	if m.WriteHook != nil {
+		m.WriteHook(address, 1)
+	}
+	m.write8(address, value)
+}
+
+func (m *Memory) write8(address uint32, value uint8) {
+	m.clearReservations(address)
+	if device, offset := m.device(address); device != nil {
+		shift := address % 4 * 8
+		device.Write32(offset, device.Read32(offset)&^(0xFF<<shift)|uint32(value)<<shift)
+		return
+	}
+	m.page(address, true)[address%pageSize] = value
+}
+
+// Read32 reads a little-endian word. Unaligned addresses are read byte by byte, like ARMv7 does with alignment
+// checking off.
+func (m *Memory) Read32(address uint32) uint32 {
+	if m.ReadHook != nil {
+		m.ReadHook(address, 4)
+	}
+	return m.Fetch32(address)
+}
+
+// Fetch32 reads an instruction word. It's Read32 without the read hook, so read watchpoints only see data accesses.
+func (m *Memory) Fetch32(address uint32) uint32 {
+	if device, offset := m.device(address); device != nil && address%4 == 0 {
+		return device.Read32(offset)
+	}
+	if address%4 == 0 {
+		page := m.page(address, false)
+		if page == nil {
+			return 0
+		}
+		return binary.LittleEndian.Uint32(page[address%pageSize:])
+	}
+	var buf [4]byte
+	for i := range buf {
+		buf[i] = m.read8(address + uint32(i))
+	}
+	return binary.LittleEndian.Uint32(buf[:])
+}
+
+func (m *Memory) Write32(address uint32, value uint32) {
+	if m.WriteHook != nil {
+		m.WriteHook(address, 4)
+	}
+	m.clearReservations(address)
+	if device, offset := m.device(address); device != nil && address%4 == 0 {
+		device.Write32(offset, value)
+		return
+	}
+	if address%4 == 0 {
+		binary.LittleEndian.PutUint32(m.page(address, true)[address%pageSize:], value)
+		return
+	}
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], value)
+	for i, b := range buf {
+		m.write8(address+uint32(i), b)
+	}
+}
+
+// exclusiveGranule is the size of the block an exclusive monitor covers, the Cortex-A7's 16 words.
+const exclusiveGranule = 64
+
+// Reserve marks the granule holding address as loaded exclusively by core, for LDREX.
+func (m *Memory) Reserve(core uint32, address uint32) {
+	if m.reservations == nil {
+		m.reservations = make(map[uint32]uint32)
+	}
+	m.reservations[core] = address &^ (exclusiveGranule - 1)
+}
+
+// Reserved reports whether core still holds a reservation on the granule holding address, which STREX needs to
+// store.
+func (m *Memory) Reserved(core uint32, address uint32) bool {
+	granule, ok := m.reservations[core]
+	return ok && granule == address&^(exclusiveGranule-1)
+}
+
+// Release clears the reservation of core, as STREX and CLREX do.
+func (m *Memory) Release(core uint32) {
+	delete(m.reservations, core)
+}
+
+// clearReservations clears every reservation on the granule holding address, after a write there.
+func (m *Memory) clearReservations(address uint32) {
+	if len(m.reservations) == 0 {
+		return
+	}
+	granule := address &^ (exclusiveGranule - 1)
+	for core, reserved := range m.reservations {
+		if reserved == granule {
+			delete(m.reservations, core)
+		}
+	}
+}
+
+// Load copies data into memory starting at address.
+func (m *Memory) Load(address uint32, data []byte) {
+	for len(data) > 0 {
+		page := m.page(address, true)
+		n := copy(page[address%pageSize:], data)
+		data = data[n:]
+		address += uint32(n)
+	}
+}
@@ -0,0 +1,192 @@
+package emu
+
+import (
+	"fmt"
+
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Identification registers
+const (
+	MIDR = 0x410FC075 // Cortex-A7 r0p5
+)
+
+// CPSR bits MSR can change in user mode: the flags, Q and GE.
+const userWritable = 0xF80F0000
+
+// CPSR bits MSR never changes: J, T and the IT bits, which only branches and exception returns set.
+const executionState = 1<<24 | 3<<25 | 0x3F<<10 | FlagT
+
+// MPIDR returns the multiprocessor affinity register: a Cortex-A7 in cluster 0xF, like on the BCM2836, with the core
+// number in the bottom bits.
+func (c *CPU) MPIDR() uint32 {
+	return 0x80000F00 | c.ID&0xFF
+}
+
+// userCP15 are the CP15 registers user mode can reach, and which way. Any other access from user mode is undefined.
+var userCP15 = map[string]parser.CP15Access{
+	"TPIDRURW": parser.CP15ReadWrite,
+	"TPIDRURO": parser.CP15ReadOnly,
+	"CP15ISB":  parser.CP15WriteOnly,
+	"CP15DSB":  parser.CP15WriteOnly,
+	"CP15DMB":  parser.CP15WriteOnly,
+}
+
+// coprocessor runs MCR, MRC, MCRR and MRRC, which Decode only accepts for the registers in parser.CP15Registers. The
+// identification registers, VBAR and DFAR are the core's own, maintenance operations do nothing as nothing is
+// cached, and the rest read back whatever was last written to them, 0 at first.
+func (c *CPU) coprocessor(i *parser.InstructionCoprocessor, word uint32) error {
+	register, _ := i.SystemRegister()
+	read := i.Mnemonic == types.MnemonicMRC || i.Mnemonic == types.MnemonicMRRC
+	if c.Mode() == ModeUser {
+		access, ok := userCP15[register.Name]
+		if !ok || read && access == parser.CP15WriteOnly || !read && access == parser.CP15ReadOnly {
+			return c.trap(ExceptionUndefined, word, fmt.Sprintf("%s access in user mode", register.Name))
+		}
+	}
+
+	if read {
+		value := c.readCP15(register)
+		c.R[i.Register] = uint32(value)
+		if register.Wide {
+			c.R[i.Register2] = uint32(value >> 32)
+		}
+		return nil
+	}
+	value := uint64(c.R[i.Register])
+	if register.Wide {
+		value |= uint64(c.R[i.Register2]) << 32
+	}
+	c.writeCP15(register, value)
+	return nil
+}
+
+// readCP15 returns the value of a CP15 register.
+func (c *CPU) readCP15(register parser.CP15Register) uint64 {
+	switch register.Name {
+	case "MIDR":
+		return MIDR
+	case "MPIDR":
+		return uint64(c.MPIDR())
+	case "VBAR":
+		return uint64(c.VBAR)
+	case "DFAR":
+		return uint64(c.FaultAddress)
+	}
+	return c.cp15[register.Name]
+}
+
+// writeCP15 writes a CP15 register. The 32 and 64 bit forms of TTBR0, TTBR1 and PAR are the same register.
+func (c *CPU) writeCP15(register parser.CP15Register, value uint64) {
+	switch {
+	case register.Name == "VBAR":
+		c.VBAR = uint32(value) &^ 0x1F
+	case register.Name == "DFAR":
+		c.FaultAddress = uint32(value)
+	case register.Access == parser.CP15ReadWrite:
+		c.cp15[register.Name] = value
+	}
+}
+
+// hint runs NOP, YIELD, WFE, WFI and SEV. WFI and WFE put the core to sleep until woken, with the PC at the next
+// instruction, and halt it instead if there's no interrupt controller that could ever wake it.
+func (c *CPU) hint(i *parser.InstructionHint) {
+	switch i.Mnemonic {
+	case types.MnemonicSEV:
+		if c.SendEvent != nil {
+			c.SendEvent()
+		} else {
+			c.Event = true
+		}
+	case types.MnemonicWFI, types.MnemonicWFE:
+		if c.woken(i) {
+			return
+		}
+		if c.InterruptLines == nil {
+			waitingFor := "an event"
+			if i.Mnemonic == types.MnemonicWFI {
+				waitingFor = "an interrupt"
+			}
+			c.Halt(fmt.Sprintf("waiting for %s at 0x%08X", waitingFor, c.current))
+			return
+		}
+		c.waiting = i
+	}
+}
+
+// woken reports whether a core waiting in WFI or WFE can carry on. WFI wakes up when an interrupt is pending, even a
+// masked one, and WFE when an unmasked one is or it can clear the event register.
+func (c *CPU) woken(i *parser.InstructionHint) bool {
+	if i.Mnemonic == types.MnemonicWFI {
+		if c.InterruptLines != nil {
+			c.IRQ, c.FIQ = c.InterruptLines()
+		}
+		return c.IRQ || c.FIQ
+	}
+	if c.Event {
+		c.Event = false
+		return true
+	}
+	return c.IRQ && !c.Flag(FlagI) || c.FIQ && !c.Flag(FlagF)
+}
+
+// status runs MRS and MSR. MSR to the CPSR only changes the fields it names, and in user mode only the flags, and a
+// mode change swaps the banked registers like it would for an exception.
+func (c *CPU) status(i *parser.InstructionStatus, word uint32) error {
+	value := c.CPSR
+	if i.SPSR == 1 {
+		spsr, ok := c.SPSR()
+		if !ok {
+			return &Fault{Address: c.current, Word: word, Reason: fmt.Sprintf("no SPSR in %s mode", c.Mode())}
+		}
+		value = spsr
+	}
+	if i.Mnemonic == types.MnemonicMRS {
+		c.R[i.Register] = value
+		return nil
+	}
+
+	operand := i.Immediate
+	if i.IBit == 0 {
+		operand = c.R[i.Register]
+	}
+	mask := i.ByteMask()
+	if i.SPSR == 1 {
+		c.SetSPSR(value&^mask | operand&mask)
+		return nil
+	}
+	if c.Mode() == ModeUser {
+		mask &= userWritable
+	}
+	mask &^= executionState
+	cpsr := value&^mask | operand&mask
+	if err := c.SetMode(Mode(cpsr & ModeMask)); err != nil {
+		return &Fault{Address: c.current, Word: word, Reason: fmt.Sprintf("MSR to %v", err)}
+	}
+	c.CPSR = cpsr
+	return nil
+}
+
+// changeState runs CPS, which does nothing in user mode, and SETEND.
+func (c *CPU) changeState(i *parser.InstructionChangeState, word uint32) error {
+	if i.Mnemonic == types.MnemonicSETEND {
+		c.setFlag(FlagE, i.BigEndian == 1)
+		return nil
+	}
+	if c.Mode() == ModeUser {
+		return nil
+	}
+	if i.ChangeMode == 1 {
+		if err := c.SetMode(Mode(i.Mode)); err != nil {
+			return &Fault{Address: c.current, Word: word, Reason: fmt.Sprintf("CPS to %v", err)}
+		}
+	}
+	switch i.IMod {
+	case 0b10:
+		c.CPSR &^= i.Flags
+	case 0b11:
+		c.CPSR |= i.Flags
+	}
+	return nil
+}
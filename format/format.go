@@ -0,0 +1,141 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Format is an output file format for a linked image.
+type Format string
+
+const (
+	FormatBinary Format = "bin"  // Raw bytes, the load address is implied
+	FormatIHex   Format = "ihex" // Intel HEX
+	FormatSREC   Format = "srec" // Motorola S-record
+)
+
+// Image is a contiguous block of memory and the address it's loaded at. Entry is only meaningful if HasEntry is set,
+// flat binaries can't carry one.
+type Image struct {
+	Address  uint32
+	Data     []byte
+	Entry    uint32
+	HasEntry bool
+}
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "bin", "binary":
+		return FormatBinary, nil
+	case "ihex", "hex":
+		return FormatIHex, nil
+	case "srec", "s19", "s28", "s37":
+		return FormatSREC, nil
+	}
+	return "", fmt.Errorf("unknown output format %s (expected bin, ihex or srec)", name)
+}
+
+func Write(w io.Writer, format Format, image Image) error {
+	switch format {
+	case FormatBinary:
+		_, err := w.Write(image.Data)
+		return err
+	case FormatIHex:
+		return WriteIHex(w, image)
+	case FormatSREC:
+		return WriteSREC(w, image)
+	}
+	return fmt.Errorf("unknown output format %s", format)
+}
+
+// Read reads an image in the given format. Flat binaries are assumed to load at address 0.
+func Read(r io.Reader, format Format) (Image, error) {
+	switch format {
+	case FormatBinary:
+		data, err := io.ReadAll(r)
+		return Image{Data: data}, err
+	case FormatIHex:
+		return ReadIHex(r)
+	case FormatSREC:
+		return ReadSREC(r)
+	}
+	return Image{}, fmt.Errorf("unknown input format %s", format)
+}
+
+// Detect guesses the format of a file from its first character. Anything that doesn't look like a text format is
+// treated as a flat binary.
+func Detect(data []byte) Format {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) == 0 {
+		return FormatBinary
+	}
+	switch {
+	case trimmed[0] == ':' && isText(trimmed):
+		return FormatIHex
+	case trimmed[0] == 'S' && isText(trimmed):
+		return FormatSREC
+	}
+	return FormatBinary
+}
+
+func isText(data []byte) bool {
+	for _, c := range data {
+		if c != '\r' && c != '\n' && (c < ' ' || c > '~') {
+			return false
+		}
+	}
+	return true
+}
+
+// chunk is a run of bytes read from a text format record.
+type chunk struct {
+	address uint32
+	data    []byte
+}
+
+// flatten joins the chunks of a text format file into one image starting at the lowest address, filling gaps with 0.
+func flatten(chunks []chunk) (Image, error) {
+	if len(chunks) == 0 {
+		return Image{}, nil
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].address < chunks[j].address })
+
+	low := uint64(chunks[0].address)
+	high := low
+	for _, c := range chunks {
+		end := uint64(c.address) + uint64(len(c.data))
+		if end > high {
+			high = end
+		}
+	}
+	if high-low > 1<<30 {
+		return Image{}, fmt.Errorf("image spans 0x%08X-0x%08X, too large to flatten", low, high)
+	}
+
+	data := make([]byte, high-low)
+	for _, c := range chunks {
+		copy(data[uint64(c.address)-low:], c.data)
+	}
+	return Image{Address: uint32(low), Data: data}, nil
+}
+
+// lines reads non-empty, whitespace trimmed lines along with their line numbers.
+func lines(r io.Reader, each func(line string, number int) error) error {
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := each(line, number); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
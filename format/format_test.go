@@ -0,0 +1,169 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func sequence(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func TestWrite(t *testing.T) {
+	cases := []struct {
+		name     string
+		format   Format
+		image    Image
+		expected string
+	}{
+		{
+			name:   "ihex at the default load address",
+			format: FormatIHex,
+			image:  Image{Address: 0x8000, Data: []byte{0x01, 0x50, 0x55, 0xE2, 0x1E, 0xFF, 0x2F, 0xE1}, Entry: 0x8000, HasEntry: true},
+			expected: ":08800000015055E21EFF2FE1C3\n" +
+				":040000050000800077\n" +
+				":00000001FF\n",
+		},
+		{
+			name:   "ihex splits records at 64K with extended linear address",
+			format: FormatIHex,
+			image:  Image{Address: 0x3F20FFFC, Data: sequence(8)},
+			expected: ":020000043F209B\n" +
+				":04FFFC0000010203FB\n" +
+				":020000043F219A\n" +
+				":0400000004050607E6\n" +
+				":00000001FF\n",
+		},
+		{
+			name:   "srec with 16 bit addresses",
+			format: FormatSREC,
+			image:  Image{Address: 0x8000, Data: []byte{0x01, 0x50, 0x55, 0xE2}, Entry: 0x8000, HasEntry: true},
+			expected: "S00B0000726F6761736D69639F\n" +
+				"S1078000015055E2F0\n" +
+				"S5030001FB\n" +
+				"S90380007C\n",
+		},
+		{
+			name:   "srec with 24 bit addresses",
+			format: FormatSREC,
+			image:  Image{Address: 0x10000, Data: []byte{0xAA}},
+			expected: "S00B0000726F6761736D69639F\n" +
+				"S205010000AA4F\n" +
+				"S5030001FB\n" +
+				"S804000000FB\n",
+		},
+		{
+			name:   "srec with 32 bit addresses",
+			format: FormatSREC,
+			image:  Image{Address: 0x3F200000, Data: []byte{0xAA}, Entry: 0x3F200000, HasEntry: true},
+			expected: "S00B0000726F6761736D69639F\n" +
+				"S3063F200000AAF0\n" +
+				"S5030001FB\n" +
+				"S7053F2000009B\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, tc.format, tc.image); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name  string
+		image Image
+	}{
+		{name: "empty", image: Image{Address: 0x8000}},
+		{name: "short", image: Image{Address: 0x8000, Data: sequence(3), Entry: 0x8000, HasEntry: true}},
+		{name: "several records", image: Image{Address: 0x8000, Data: sequence(100), Entry: 0x8004, HasEntry: true}},
+		{name: "crosses 64K", image: Image{Address: 0xFFF0, Data: sequence(0x30), Entry: 0xFFF0, HasEntry: true}},
+		{name: "peripheral space", image: Image{Address: 0x3F200000, Data: sequence(0x20010), Entry: 0x3F200000, HasEntry: true}},
+	}
+
+	for _, tc := range cases {
+		for _, format := range []Format{FormatIHex, FormatSREC} {
+			t.Run(tc.name+"/"+string(format), func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := Write(&buf, format, tc.image); err != nil {
+					t.Fatalf("unexpected error writing: %v", err)
+				}
+				if detected := Detect(buf.Bytes()); detected != format {
+					t.Errorf("detected %s, expected %s", detected, format)
+				}
+				image, err := Read(&buf, format)
+				if err != nil {
+					t.Fatalf("unexpected error reading: %v", err)
+				}
+				if len(tc.image.Data) > 0 && image.Address != tc.image.Address {
+					t.Errorf("expected address 0x%08X, got 0x%08X", tc.image.Address, image.Address)
+				}
+				if !bytes.Equal(image.Data, tc.image.Data) {
+					t.Errorf("data doesn't match, got %d bytes expected %d", len(image.Data), len(tc.image.Data))
+				}
+				if tc.image.HasEntry && (!image.HasEntry || image.Entry != tc.image.Entry) {
+					t.Errorf("expected entry 0x%08X, got 0x%08X", tc.image.Entry, image.Entry)
+				}
+			})
+		}
+	}
+}
+
+func TestReadFillsGaps(t *testing.T) {
+	input := ":02000004000EEC\n:0200000011AA43\n:02001000223399\n:00000001FF\n"
+	image, err := ReadIHex(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if image.Address != 0xE0000 {
+		t.Errorf("expected address 0x000E0000, got 0x%08X", image.Address)
+	}
+	expected := append(append([]byte{0x11, 0xAA}, make([]byte, 14)...), 0x22, 0x33)
+	if !bytes.Equal(image.Data, expected) {
+		t.Errorf("expected %X, got %X", expected, image.Data)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		format Format
+		input  string
+		err    string
+	}{
+		{name: "ihex bad checksum", format: FormatIHex, input: ":0400000501020304EE\n:00000001FF\n", err: "line 1: bad checksum"},
+		{name: "ihex missing colon", format: FormatIHex, input: "00000001FF\n", err: "line 1: record doesn't start with ':'"},
+		{name: "ihex short record", format: FormatIHex, input: ":0400000001FB\n:00000001FF\n", err: "line 1: record length"},
+		{name: "ihex missing eof", format: FormatIHex, input: ":0100000011EE\n", err: "missing end of file record"},
+		{name: "ihex data after eof", format: FormatIHex, input: ":00000001FF\n:0100000011EE\n", err: "line 2: record after end of file"},
+		{name: "ihex unknown record", format: FormatIHex, input: ":00000009F7\n", err: "line 1: unknown record type 0x09"},
+		{name: "srec bad checksum", format: FormatSREC, input: "S1078000015055E2F1\n", err: "line 1: bad checksum"},
+		{name: "srec bad count", format: FormatSREC, input: "S1078000015055E2F0\nS5030002FA\n", err: "line 2: record count 2"},
+		{name: "srec unknown record", format: FormatSREC, input: "S4030000FC\n", err: "line 1: unknown record type S4"},
+		{name: "srec not hex", format: FormatSREC, input: "S1ZZ\n", err: "line 1: encoding/hex"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(tc.input), tc.format)
+			if err == nil {
+				t.Fatalf("expected error containing %q", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package format
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Intel HEX record types
+const (
+	ihexData            = 0x00
+	ihexEOF             = 0x01
+	ihexExtendedSegment = 0x02
+	ihexStartSegment    = 0x03
+	ihexExtendedLinear  = 0x04
+	ihexStartLinear     = 0x05
+	ihexBytesPerRecord  = 16
+	ihexSegmentSize     = 0x10000
+)
+
+// WriteIHex writes the image as Intel HEX. Addresses above 64K get extended linear address (04) records, and data
+// records never cross a 64K boundary. The entry point becomes a start linear address (05) record.
+func WriteIHex(w io.Writer, image Image) error {
+	out := bufio.NewWriter(w)
+	upper := uint32(0)
+
+	for offset := 0; offset < len(image.Data); {
+		address := image.Address + uint32(offset)
+		if address>>16 != upper || (offset == 0 && address>>16 != 0) {
+			upper = address >> 16
+			writeIHexRecord(out, ihexExtendedLinear, 0, []byte{byte(upper >> 8), byte(upper)})
+		}
+
+		count := ihexBytesPerRecord
+		if remaining := len(image.Data) - offset; remaining < count {
+			count = remaining
+		}
+		if toBoundary := int(ihexSegmentSize - address&0xFFFF); toBoundary < count {
+			count = toBoundary
+		}
+		writeIHexRecord(out, ihexData, uint16(address), image.Data[offset:offset+count])
+		offset += count
+	}
+
+	if image.HasEntry {
+		entry := image.Entry
+		writeIHexRecord(out, ihexStartLinear, 0, []byte{byte(entry >> 24), byte(entry >> 16), byte(entry >> 8), byte(entry)})
+	}
+	writeIHexRecord(out, ihexEOF, 0, nil)
+
+	return out.Flush()
+}
+
+func writeIHexRecord(w *bufio.Writer, recordType byte, address uint16, data []byte) {
+	record := append([]byte{byte(len(data)), byte(address >> 8), byte(address), recordType}, data...)
+	fmt.Fprintf(w, ":%X%02X\n", record, checksum(record))
+}
+
+// checksum is the two's complement of the sum of every byte, so the bytes and checksum add up to 0.
+func checksum(record []byte) byte {
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	return -sum
+}
+
+// ReadIHex reads an Intel HEX file, verifying every checksum. Both segment (02/03) and linear (04/05) addressing are
+// supported.
+func ReadIHex(r io.Reader) (Image, error) {
+	var chunks []chunk
+	var base uint32
+	var entry uint32
+	hasEntry := false
+	done := false
+
+	err := lines(r, func(line string, number int) error {
+		if done {
+			return fmt.Errorf("line %d: record after end of file record", number)
+		}
+		if line[0] != ':' {
+			return fmt.Errorf("line %d: record doesn't start with ':'", number)
+		}
+		record, err := hex.DecodeString(line[1:])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+		if len(record) < 5 || len(record) != int(record[0])+5 {
+			return fmt.Errorf("line %d: record length doesn't match its byte count", number)
+		}
+		if checksum(record[:len(record)-1]) != record[len(record)-1] {
+			return fmt.Errorf("line %d: bad checksum 0x%02X (expected 0x%02X)", number, record[len(record)-1], checksum(record[:len(record)-1]))
+		}
+
+		address := uint32(record[1])<<8 | uint32(record[2])
+		data := record[4 : len(record)-1]
+		switch record[3] {
+		case ihexData:
+			chunks = append(chunks, chunk{address: base + address, data: data})
+		case ihexEOF:
+			done = true
+		case ihexExtendedSegment, ihexExtendedLinear:
+			if len(data) != 2 {
+				return fmt.Errorf("line %d: extended address record needs 2 bytes", number)
+			}
+			value := uint32(data[0])<<8 | uint32(data[1])
+			if record[3] == ihexExtendedSegment {
+				base = value << 4
+			} else {
+				base = value << 16
+			}
+		case ihexStartSegment, ihexStartLinear:
+			if len(data) != 4 {
+				return fmt.Errorf("line %d: start address record needs 4 bytes", number)
+			}
+			value := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+			if record[3] == ihexStartSegment {
+				value = (value>>16)<<4 + value&0xFFFF // CS:IP
+			}
+			entry, hasEntry = value, true
+		default:
+			return fmt.Errorf("line %d: unknown record type 0x%02X", number, record[3])
+		}
+		return nil
+	})
+	if err != nil {
+		return Image{}, err
+	}
+	if !done {
+		return Image{}, fmt.Errorf("missing end of file record")
+	}
+
+	image, err := flatten(chunks)
+	image.Entry, image.HasEntry = entry, hasEntry
+	return image, err
+}
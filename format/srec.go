@@ -0,0 +1,126 @@
+package format
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+const srecBytesPerRecord = 16
+
+// WriteSREC writes the image as Motorola S-records. The narrowest address width that fits the whole image is used:
+// S1/S9 for 16 bit addresses, S2/S8 for 24 bit and S3/S7 for 32 bit. A record count (S5/S6) precedes the termination
+// record, which carries the entry point (or 0 without one).
+func WriteSREC(w io.Writer, image Image) error {
+	out := bufio.NewWriter(w)
+
+	end := uint64(image.Address) + uint64(len(image.Data))
+	if image.HasEntry && uint64(image.Entry) >= end {
+		end = uint64(image.Entry) + 1
+	}
+	width := 2
+	switch {
+	case end > 0x1000000:
+		width = 4
+	case end > 0x10000:
+		width = 3
+	}
+
+	writeSRECRecord(out, '0', 2, 0, []byte("rogasmic"))
+	records := 0
+	for offset := 0; offset < len(image.Data); offset += srecBytesPerRecord {
+		count := min(srecBytesPerRecord, len(image.Data)-offset)
+		writeSRECRecord(out, byte('0'+width-1), width, image.Address+uint32(offset), image.Data[offset:offset+count])
+		records++
+	}
+
+	if records <= 0xFFFF {
+		writeSRECRecord(out, '5', 2, uint32(records), nil)
+	} else {
+		writeSRECRecord(out, '6', 3, uint32(records), nil)
+	}
+	writeSRECRecord(out, byte('0'+11-width), width, image.Entry, nil)
+
+	return out.Flush()
+}
+
+func writeSRECRecord(w *bufio.Writer, recordType byte, width int, address uint32, data []byte) {
+	record := []byte{byte(width + len(data) + 1)}
+	for i := width - 1; i >= 0; i-- {
+		record = append(record, byte(address>>(8*i)))
+	}
+	record = append(record, data...)
+	fmt.Fprintf(w, "S%c%X%02X\n", recordType, record, ^sum(record))
+}
+
+func sum(record []byte) byte {
+	var total byte
+	for _, b := range record {
+		total += b
+	}
+	return total
+}
+
+// srecWidths is the address width in bytes of every record type
+var srecWidths = map[byte]int{
+	'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2,
+}
+
+// ReadSREC reads a Motorola S-record file, verifying every checksum and the record count if there is one.
+func ReadSREC(r io.Reader) (Image, error) {
+	var chunks []chunk
+	var entry uint32
+	hasEntry := false
+	records := 0
+
+	err := lines(r, func(line string, number int) error {
+		if len(line) < 2 || line[0] != 'S' {
+			return fmt.Errorf("line %d: record doesn't start with 'S'", number)
+		}
+		recordType := line[1]
+		width, ok := srecWidths[recordType]
+		if !ok {
+			return fmt.Errorf("line %d: unknown record type S%c", number, recordType)
+		}
+		if hasEntry {
+			return fmt.Errorf("line %d: record after termination record", number)
+		}
+		record, err := hex.DecodeString(line[2:])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+		if len(record) < width+2 || len(record) != int(record[0])+1 {
+			return fmt.Errorf("line %d: record length doesn't match its byte count", number)
+		}
+		if expected := ^sum(record[:len(record)-1]); expected != record[len(record)-1] {
+			return fmt.Errorf("line %d: bad checksum 0x%02X (expected 0x%02X)", number, record[len(record)-1], expected)
+		}
+
+		var address uint32
+		for _, b := range record[1 : 1+width] {
+			address = address<<8 | uint32(b)
+		}
+		data := record[1+width : len(record)-1]
+
+		switch recordType {
+		case '1', '2', '3':
+			chunks = append(chunks, chunk{address: address, data: data})
+			records++
+		case '5', '6':
+			if int(address) != records {
+				return fmt.Errorf("line %d: record count %d doesn't match %d data records", number, address, records)
+			}
+		case '7', '8', '9':
+			entry, hasEntry = address, true
+		}
+		return nil
+	})
+	if err != nil {
+		return Image{}, err
+	}
+
+	image, err := flatten(chunks)
+	image.Entry, image.HasEntry = entry, hasEntry
+	return image, err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/robertjshirts/rogasmic/bcm"
+	"github.com/robertjshirts/rogasmic/gdbstub"
+)
+
+// runGDB implements "rogasmic gdb [-listen addr] [-T script.ld] [-base addr] [-fast] [-serial-in file] [-input text]
+// [-serial-out file] program". It loads the program like run does, then waits for GDB to connect with
+// "target remote addr" and serves that one connection.
+func runGDB(args []string) error {
+	flags := flag.NewFlagSet("gdb", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:1234", "address to listen for GDB on")
+	scriptFile := flags.String("T", "", "linker script for sources and objects (default places everything at 0x8000)")
+	base := flags.Uint("base", 0x8000, "load address of a flat binary")
+	fast := flags.Bool("fast", false, "skip countdown delay loops")
+	serialIn := flags.String("serial-in", "", "file the UARTs receive from, - for stdin")
+	input := flags.String("input", "", "text the UARTs receive, Go escapes like \\n work")
+	serialOut := flags.String("serial-out", "-", "file the UARTs transmit to, - for stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: rogasmic gdb [-listen addr] [-T script.ld] [-base addr] [-fast] [-serial-in file] [-input text] [-serial-out file] program")
+	}
+
+	machine := bcm.NewMachine()
+	machine.CPU.FastForward = *fast
+	if _, err := loadProgram(machine.CPU, flags.Arg(0), *scriptFile, uint32(*base)); err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	closeSerial, err := connectSerial(machine.Terminal, *serialIn, *input, *serialOut)
+	if err != nil {
+		return err
+	}
+	defer closeSerial()
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Printf("Waiting for GDB on %s\n", listener.Addr())
+	conn, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return gdbstub.New(machine.CPU).ServeConn(conn)
+}
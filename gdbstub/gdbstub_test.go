@@ -0,0 +1,326 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/internal/emutest"
+)
+
+// client is the GDB end of a connection, sending packets and checking the acks.
+type client struct {
+	t     *testing.T
+	conn  net.Conn
+	in    *bufio.Reader
+	noAck bool
+}
+
+// connect serves a CPU on a local TCP port and connects a client to it.
+func connect(t *testing.T, cpu *emu.CPU) (*client, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		done <- New(cpu).ServeConn(conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error connecting: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, in: bufio.NewReader(conn)}, done
+}
+
+func (c *client) write(data string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(data)); err != nil {
+		c.t.Fatalf("unexpected error writing: %v", err)
+	}
+}
+
+func (c *client) read() byte {
+	c.t.Helper()
+	b, err := c.in.ReadByte()
+	if err != nil {
+		c.t.Fatalf("unexpected error reading: %v", err)
+	}
+	return b
+}
+
+// reply reads a packet, checking its checksum and acknowledging it.
+func (c *client) reply() string {
+	c.t.Helper()
+	for b := c.read(); b != '$'; b = c.read() {
+	}
+	data, err := c.in.ReadString('#')
+	if err != nil {
+		c.t.Fatalf("unexpected error reading: %v", err)
+	}
+	data = strings.TrimSuffix(data, "#")
+	sum := string([]byte{c.read(), c.read()})
+	if sum != fmt.Sprintf("%02x", checksum(data)) {
+		c.t.Fatalf("bad checksum %s for %q", sum, data)
+	}
+	if !c.noAck {
+		c.write("+")
+	}
+	return data
+}
+
+// send sends a packet and returns the reply.
+func (c *client) send(packet string) string {
+	c.t.Helper()
+	c.write(fmt.Sprintf("$%s#%02x", packet, checksum(packet)))
+	if !c.noAck {
+		if ack := c.read(); ack != '+' {
+			c.t.Fatalf("expected + for %q, got %q", packet, ack)
+		}
+	}
+	return c.reply()
+}
+
+const program = `MOVW R0, #0
+MOV32 R1, #0x9000
+loop:
+ADD R0, R0, #1
+STR R0, [R1]
+SUBS R2, R0, #5
+BNE loop
+end: B end
+`
+
+// device is a peripheral register that counts how many times it's been read.
+type device struct{ reads uint32 }
+
+func (d *device) Read32(offset uint32) uint32 {
+	d.reads++
+	return d.reads
+}
+
+func (d *device) Write32(offset uint32, value uint32) {}
+
+func TestSession(t *testing.T) {
+	cpu, _ := emutest.Load(t, program)
+	peripheral := &device{}
+	cpu.Memory.Map(0x3F201000, 0x100, peripheral)
+	c, done := connect(t, cpu)
+
+	steps := []struct {
+		send     string
+		expected string
+	}{
+		{send: "qSupported:multiprocess+;swbreak+;hwbreak+", expected: "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"},
+		{send: "vMustReplyEmpty", expected: ""},
+		{send: "?", expected: "S05"},
+		{send: "Hg0", expected: "OK"},
+		{send: "qAttached", expected: "1"},
+		{send: "pf", expected: "00800000"},
+		{send: "p10", expected: "d3000000"},
+		{send: "p11", expected: "E01"},
+		{send: "s", expected: "S05"},
+		{send: "s", expected: "S05"},
+		{send: "s", expected: "S05"},
+		{send: "pf", expected: "0c800000"},
+		{send: "p1", expected: "00900000"},
+		// Stop before the STR on the third time round
+		{send: "Z0,8010,4", expected: "OK"},
+		{send: "c", expected: "S05"},
+		{send: "c", expected: "S05"},
+		{send: "c", expected: "S05"},
+		{send: "p0", expected: "03000000"},
+		{send: "m9000,4", expected: "02000000"},
+		{send: "z0,8010,4", expected: "OK"},
+		// Then the write that follows
+		{send: "Z2,9000,4", expected: "OK"},
+		{send: "c", expected: "T05watch:9000;"},
+		{send: "m9000,4", expected: "03000000"},
+		{send: "pf", expected: "14800000"},
+		{send: "z2,9000,4", expected: "OK"},
+		{send: "Z5,9000,4", expected: ""},
+		// Runs into the idle loop at the end
+		{send: "c", expected: "S05"},
+		{send: "pf", expected: "1c800000"},
+		{send: "p0", expected: "05000000"},
+		{send: "?", expected: "S05"},
+		// Register and memory writes
+		{send: "P0=efbeadde", expected: "OK"},
+		{send: "p0", expected: "efbeadde"},
+		{send: "M9004,4:01020304", expected: "OK"},
+		{send: "m9002,6", expected: "000001020304"},
+		{send: "X9008,2:}\x03}]", expected: "OK"},
+		{send: "m9008,2", expected: "237d"},
+		{send: "M9004,2:010203", expected: "E01"},
+		{send: "m3f201000,4", expected: "E14"},
+		{send: "m3f200ffe,4", expected: "0000"},
+		{send: "D", expected: "OK"},
+	}
+	for _, step := range steps {
+		if reply := c.send(step.send); reply != step.expected {
+			t.Fatalf("%q: expected %q, got %q", step.send, step.expected, reply)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error serving: %v", err)
+	}
+	if peripheral.reads != 0 {
+		t.Errorf("expected the device not to be read, it was read %d times", peripheral.reads)
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	cpu, _ := emutest.Load(t, `MOV32 R1, #0x9000
+MOVW R0, #7
+LDR R2, [R1]
+STR R0, [R1]
+LDR R3, [R1]
+end: B end
+`)
+	c, _ := connect(t, cpu)
+
+	steps := []struct {
+		send     string
+		expected string
+	}{
+		// Reads stop after the first LDR, but not when GDB reads the memory itself
+		{send: "Z3,9000,4", expected: "OK"},
+		{send: "m9000,4", expected: "00000000"},
+		{send: "c", expected: "T05rwatch:9000;"},
+		{send: "pf", expected: "10800000"},
+		{send: "z3,9000,4", expected: "OK"},
+		// Access watchpoints stop for the STR and again for the LDR after it
+		{send: "Z4,9002,2", expected: "OK"},
+		{send: "c", expected: "T05awatch:9002;"},
+		{send: "pf", expected: "14800000"},
+		{send: "c", expected: "T05awatch:9002;"},
+		{send: "pf", expected: "18800000"},
+		{send: "z4,9002,2", expected: "OK"},
+		// Fetching an instruction isn't a read
+		{send: "Z3,8018,4", expected: "OK"},
+		{send: "c", expected: "S05"},
+		{send: "p3", expected: "07000000"},
+	}
+	for _, step := range steps {
+		if reply := c.send(step.send); reply != step.expected {
+			t.Fatalf("%q: expected %q, got %q", step.send, step.expected, reply)
+		}
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	cpu, _ := emutest.Load(t, program)
+	c, _ := connect(t, cpu)
+
+	registers := c.send("g")
+	if len(registers) != 17*8 {
+		t.Fatalf("expected 17 registers, got %q", registers)
+	}
+	if pc := registers[15*8 : 16*8]; pc != "00800000" {
+		t.Errorf("expected PC 00800000, got %s", pc)
+	}
+
+	// Write them all back with R3 changed and the CPSR switched to IRQ mode
+	changed := registers[:3*8] + "78563412" + registers[4*8:16*8] + "d2000000"
+	if reply := c.send("G" + changed); reply != "OK" {
+		t.Fatalf("expected OK, got %q", reply)
+	}
+	if cpu.R[3] != 0x12345678 || cpu.Mode() != emu.ModeIRQ {
+		t.Errorf("expected R3 0x12345678 in irq mode, got 0x%08X in %s", cpu.R[3], cpu.Mode())
+	}
+	if reply := c.send("P10=05000000"); reply != "E01" {
+		t.Errorf("expected an invalid mode to fail, got %q", reply)
+	}
+	if reply := c.send("G00"); reply != "E01" {
+		t.Errorf("expected a short G to fail, got %q", reply)
+	}
+}
+
+func TestTargetDescription(t *testing.T) {
+	cpu, _ := emutest.Load(t, program)
+	c, _ := connect(t, cpu)
+
+	var document strings.Builder
+	for offset := 0; ; offset += 0x40 {
+		reply := c.send(fmt.Sprintf("qXfer:features:read:target.xml:%x,40", offset))
+		if reply == "" || (reply[0] != 'm' && reply[0] != 'l') {
+			t.Fatalf("unexpected reply %q", reply)
+		}
+		document.WriteString(reply[1:])
+		if reply[0] == 'l' {
+			break
+		}
+	}
+	if document.String() != targetXML {
+		t.Errorf("expected the target description, got:\n%s", document.String())
+	}
+	for _, expected := range []string{`<architecture>arm</architecture>`, `<feature name="org.gnu.gdb.arm.core">`, `<reg name="r12"`, `<reg name="cpsr"`} {
+		if !strings.Contains(targetXML, expected) {
+			t.Errorf("expected %s in the target description", expected)
+		}
+	}
+}
+
+func TestNoAckAndInterrupt(t *testing.T) {
+	cpu, _ := emutest.Load(t, "loop: ADD R0, R0, #1\nB loop")
+	c, done := connect(t, cpu)
+
+	if reply := c.send("QStartNoAckMode"); reply != "OK" {
+		t.Fatalf("expected OK, got %q", reply)
+	}
+	c.noAck = true
+
+	// Bad checksums are dropped without a nak once acks are off
+	c.write("$g#00")
+	c.write(fmt.Sprintf("$c#%02x", checksum("c")))
+	time.Sleep(10 * time.Millisecond)
+	c.write("\x03")
+	if reply := c.reply(); reply != "S02" {
+		t.Fatalf("expected S02, got %q", reply)
+	}
+	if reply := c.send("?"); reply != "S02" {
+		t.Fatalf("expected S02, got %q", reply)
+	}
+	c.write(fmt.Sprintf("$k#%02x", checksum("k")))
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error serving: %v", err)
+	}
+	if cpu.R[0] == 0 {
+		t.Errorf("expected the program to have run")
+	}
+}
+
+func TestFaults(t *testing.T) {
+	cases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{name: "undefined instruction", source: ".word 0xFFFFFFFF", expected: "S04"},
+		{name: "unmapped memory", source: "MOVW R0, #0x4000\nBX R0", expected: "S0b"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cpu, _ := emutest.Load(t, c.source)
+			client, _ := connect(t, cpu)
+			if reply := client.send("c"); reply != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, reply)
+			}
+		})
+	}
+}
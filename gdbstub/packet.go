@@ -0,0 +1,122 @@
+package gdbstub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// interrupt is the byte GDB sends outside of any packet to stop a running target.
+const interrupt = 0x03
+
+// conn frames packets on top of the bytes read from a connection in the background, so a running target can check for
+// an interrupt without blocking.
+type conn struct {
+	in    <-chan byte
+	write func([]byte) error
+	noAck bool // Set once GDB has asked for QStartNoAckMode
+}
+
+// readPacket waits for the next packet and returns its data, acknowledging it unless acks are off. Stray acks and
+// interrupts are skipped. It returns false once the connection is closed.
+func (c *conn) readPacket() (string, bool, error) {
+	for {
+		b, ok := <-c.in
+		if !ok {
+			return "", false, nil
+		}
+		if b != '$' {
+			continue
+		}
+
+		var data strings.Builder
+		for {
+			if b, ok = <-c.in; !ok {
+				return "", false, nil
+			}
+			if b == '#' {
+				break
+			}
+			data.WriteByte(b)
+		}
+		var sum [2]byte
+		for i := range sum {
+			if sum[i], ok = <-c.in; !ok {
+				return "", false, nil
+			}
+		}
+
+		expected, err := strconv.ParseUint(string(sum[:]), 16, 8)
+		if err != nil || byte(expected) != checksum(data.String()) {
+			if !c.noAck {
+				if err := c.write([]byte("-")); err != nil {
+					return "", false, err
+				}
+			}
+			continue
+		}
+		if !c.noAck {
+			if err := c.write([]byte("+")); err != nil {
+				return "", false, err
+			}
+		}
+		return data.String(), true, nil
+	}
+}
+
+// writePacket sends a packet. GDB's ack is picked up and skipped by the next readPacket, it isn't waited for.
+func (c *conn) writePacket(data string) error {
+	return c.write([]byte(fmt.Sprintf("$%s#%02x", data, checksum(data))))
+}
+
+// interrupted reports whether GDB has sent an interrupt, without waiting. Anything else that arrives while the target
+// is running is dropped, GDB doesn't send packets until it gets a stop reply.
+func (c *conn) interrupted() bool {
+	for {
+		select {
+		case b, ok := <-c.in:
+			if !ok || b == interrupt {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// escape escapes the bytes with special meanings in packet data, for replies carrying binary or free-form data.
+func escape(data string) string {
+	var out strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch b := data[i]; b {
+		case '#', '$', '}', '*':
+			out.WriteByte('}')
+			out.WriteByte(b ^ 0x20)
+		default:
+			out.WriteByte(b)
+		}
+	}
+	return out.String()
+}
+
+// unescape reverses escape, for the binary data of X packets.
+func unescape(data string) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
@@ -0,0 +1,402 @@
+package gdbstub
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/emu"
+)
+
+// chunkSteps is how many instructions a continue runs between checks for an interrupt from GDB.
+const chunkSteps = 1 << 20
+
+// registers is how many registers the g packet carries: R0-R15 and the CPSR, in the order of the target description.
+const registers = 17
+
+// targetXML describes the registers to GDB, so it doesn't assume the old FPA layout.
+var targetXML = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+<architecture>arm</architecture>
+<feature name="org.gnu.gdb.arm.core">
+`)
+	for r := 0; r <= 12; r++ {
+		fmt.Fprintf(&b, "<reg name=\"r%d\" bitsize=\"32\" type=\"uint32\"/>\n", r)
+	}
+	b.WriteString(`<reg name="sp" bitsize="32" type="data_ptr"/>
+<reg name="lr" bitsize="32"/>
+<reg name="pc" bitsize="32" type="code_ptr"/>
+<reg name="cpsr" bitsize="32"/>
+</feature>
+</target>
+`)
+	return b.String()
+}()
+
+// Stub is a GDB remote serial protocol target for a CPU. Breakpoints stop before the instruction at their address
+// runs and watchpoints stop after an instruction reads or writes their range, without patching the program.
+// Instruction fetches don't count as reads.
+type Stub struct {
+	cpu         *emu.CPU
+	watchpoints map[watchpoint]uint32 // Length of each watchpoint
+	running     bool                  // Only the running program hits watchpoints, GDB reading or writing memory doesn't
+	hit         string                // Kind of watchpoint that was hit at hitAddress, empty if none was
+	hitAddress  uint32
+	lastStop    string // Stop reply for the ? packet
+}
+
+// watchpoint is where a watchpoint starts and what kind it is, named like the stop reply reports it: watch for
+// writes, rwatch for reads and awatch for both.
+type watchpoint struct {
+	address uint32
+	kind    string
+}
+
+// watchKinds maps the Z packet types of watchpoints to their kinds.
+var watchKinds = map[string]string{"2": "watch", "3": "rwatch", "4": "awatch"}
+
+// New creates a stub for a CPU with a program loaded. It takes over the memory read and write hooks for watchpoints.
+func New(cpu *emu.CPU) *Stub {
+	s := &Stub{
+		cpu:         cpu,
+		watchpoints: make(map[watchpoint]uint32),
+		lastStop:    "S05",
+	}
+	cpu.Memory.ReadHook = func(address uint32, size uint32) { s.check(address, size, "rwatch") }
+	cpu.Memory.WriteHook = func(address uint32, size uint32) { s.check(address, size, "watch") }
+	return s
+}
+
+// ServeConn talks to GDB over a connection until it's closed, or GDB detaches or kills the target. The CPU and
+// breakpoints are left as they are, so another connection can pick up where this one stopped.
+func (s *Stub) ServeConn(rw io.ReadWriter) error {
+	in := make(chan byte, 4096)
+	go func() {
+		defer close(in)
+		buf := make([]byte, 4096)
+		for {
+			n, err := rw.Read(buf)
+			for _, b := range buf[:n] {
+				in <- b
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	c := &conn{in: in, write: func(data []byte) error {
+		_, err := rw.Write(data)
+		return err
+	}}
+
+	for {
+		packet, ok, err := c.readPacket()
+		if err != nil || !ok {
+			return err
+		}
+		switch packet {
+		case "k":
+			return nil
+		case "D":
+			return c.writePacket("OK")
+		}
+		if err := c.writePacket(s.handle(c, packet)); err != nil {
+			return err
+		}
+		if packet == "QStartNoAckMode" {
+			c.noAck = true
+		}
+	}
+}
+
+// handle answers a packet. An empty reply tells GDB the packet isn't supported.
+func (s *Stub) handle(c *conn, packet string) string {
+	switch {
+	case packet == "?":
+		return s.lastStop
+	case packet == "g":
+		var out strings.Builder
+		for r := range registers {
+			out.WriteString(word(s.register(r)))
+		}
+		return out.String()
+	case strings.HasPrefix(packet, "G"):
+		data, err := hex.DecodeString(packet[1:])
+		if err != nil || len(data) < registers*4 {
+			return "E01"
+		}
+		for r := range registers {
+			if err := s.setRegister(r, littleEndian(data[r*4:])); err != nil {
+				return "E01"
+			}
+		}
+		return "OK"
+	case strings.HasPrefix(packet, "p"):
+		r, err := strconv.ParseUint(packet[1:], 16, 32)
+		if err != nil || r >= registers {
+			return "E01"
+		}
+		return word(s.register(int(r)))
+	case strings.HasPrefix(packet, "P"):
+		number, value, _ := strings.Cut(packet[1:], "=")
+		r, err := strconv.ParseUint(number, 16, 32)
+		data, hexErr := hex.DecodeString(value)
+		if err != nil || hexErr != nil || r >= registers || len(data) != 4 {
+			return "E01"
+		}
+		if err := s.setRegister(int(r), littleEndian(data)); err != nil {
+			return "E01"
+		}
+		return "OK"
+	case strings.HasPrefix(packet, "m"):
+		return s.readMemory(packet[1:])
+	case strings.HasPrefix(packet, "M"):
+		location, data, _ := strings.Cut(packet[1:], ":")
+		bytes, err := hex.DecodeString(data)
+		if err != nil {
+			return "E01"
+		}
+		return s.writeMemory(location, bytes)
+	case strings.HasPrefix(packet, "X"):
+		location, data, _ := strings.Cut(packet[1:], ":")
+		return s.writeMemory(location, unescape(data))
+	case strings.HasPrefix(packet, "s"):
+		if err := s.resumeAt(packet[1:]); err != nil {
+			return "E01"
+		}
+		return s.step()
+	case strings.HasPrefix(packet, "c"):
+		if err := s.resumeAt(packet[1:]); err != nil {
+			return "E01"
+		}
+		return s.cont(c)
+	case strings.HasPrefix(packet, "Z"), strings.HasPrefix(packet, "z"):
+		return s.point(packet[0] == 'Z', packet[1:])
+	case strings.HasPrefix(packet, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"
+	case strings.HasPrefix(packet, "qXfer:features:read:target.xml:"):
+		return transfer(targetXML, strings.TrimPrefix(packet, "qXfer:features:read:target.xml:"))
+	case packet == "QStartNoAckMode":
+		return "OK"
+	case packet == "qAttached":
+		return "1"
+	case packet == "qC":
+		return "QC1"
+	case packet == "qfThreadInfo":
+		return "m1"
+	case packet == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(packet, "H"), strings.HasPrefix(packet, "T"):
+		return "OK" // There's only one thread
+	default:
+		return ""
+	}
+}
+
+// register reads a register by its GDB number.
+func (s *Stub) register(r int) uint32 {
+	if r == 16 {
+		return s.cpu.CPSR
+	}
+	return s.cpu.R[r]
+}
+
+// setRegister writes a register by its GDB number. Changing the mode in the CPSR swaps in that mode's registers.
+func (s *Stub) setRegister(r int, value uint32) error {
+	if r != 16 {
+		s.cpu.R[r] = value
+		return nil
+	}
+	if err := s.cpu.SetMode(emu.Mode(value & emu.ModeMask)); err != nil {
+		return err
+	}
+	s.cpu.CPSR = value
+	return nil
+}
+
+// readMemory answers an m packet. Devices can't be read, since that can change their state; a read that runs into
+// one stops short of it.
+func (s *Stub) readMemory(location string) string {
+	address, length, err := parseRange(location)
+	if err != nil {
+		return "E01"
+	}
+	var out strings.Builder
+	for i := range length {
+		if s.cpu.Memory.IsDevice(address + i) {
+			if i == 0 {
+				return "E14"
+			}
+			break
+		}
+		fmt.Fprintf(&out, "%02x", s.cpu.Memory.Read8(address+i))
+	}
+	return out.String()
+}
+
+// writeMemory answers M and X packets.
+func (s *Stub) writeMemory(location string, data []byte) string {
+	address, length, err := parseRange(location)
+	if err != nil || uint32(len(data)) != length {
+		return "E01"
+	}
+	for i, b := range data {
+		s.cpu.Memory.Write8(address+uint32(i), b)
+	}
+	return "OK"
+}
+
+// resumeAt handles the optional address of s and c packets.
+func (s *Stub) resumeAt(address string) error {
+	if address == "" {
+		return nil
+	}
+	value, err := strconv.ParseUint(address, 16, 32)
+	if err != nil {
+		return err
+	}
+	s.cpu.R[emu.PC] = uint32(value)
+	return nil
+}
+
+// point answers Z and z packets. Software and hardware breakpoints work the same way.
+func (s *Stub) point(insert bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 3 {
+		return "E01"
+	}
+	address, err := strconv.ParseUint(fields[1], 16, 32)
+	length, lengthErr := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil || lengthErr != nil {
+		return "E01"
+	}
+	switch kind, watch := watchKinds[fields[0]]; {
+	case fields[0] == "0" || fields[0] == "1":
+		if insert {
+			s.cpu.HaltAt(uint32(address))
+		} else {
+			s.cpu.ClearHaltAt(uint32(address))
+		}
+	case watch:
+		if insert {
+			s.watchpoints[watchpoint{uint32(address), kind}] = uint32(length)
+		} else {
+			delete(s.watchpoints, watchpoint{uint32(address), kind})
+		}
+	default:
+		return ""
+	}
+	return "OK"
+}
+
+// check is the memory read and write hook, stopping the CPU after an instruction that accesses a watchpoint of the
+// given kind, or an access watchpoint.
+func (s *Stub) check(address uint32, size uint32, kind string) {
+	if !s.running {
+		return
+	}
+	for point, length := range s.watchpoints {
+		if (point.kind == kind || point.kind == "awatch") && address < point.address+length && point.address < address+size {
+			s.hit = point.kind
+			s.hitAddress = max(address, point.address)
+			s.cpu.Halt("watchpoint")
+		}
+	}
+}
+
+// prepare clears whatever stopped the CPU last time, so it can keep going.
+func (s *Stub) prepare() {
+	s.cpu.Halted = false
+	s.cpu.HaltReason = ""
+	s.hit = ""
+	s.running = true
+}
+
+func (s *Stub) step() string {
+	s.prepare()
+	defer func() { s.running = false }()
+	return s.stopped(s.cpu.Step())
+}
+
+// cont runs until a breakpoint, watchpoint, halt or fault, or until GDB interrupts it.
+func (s *Stub) cont(c *conn) string {
+	s.prepare()
+	defer func() { s.running = false }()
+
+	// Step off a breakpoint at the PC first, Run would stop at it straight away
+	err := s.cpu.Step()
+	for err == nil && !s.cpu.Halted {
+		if err = s.cpu.Run(chunkSteps); errors.Is(err, emu.ErrStepLimit) {
+			err = nil
+			if c.interrupted() {
+				s.lastStop = "S02"
+				return s.lastStop
+			}
+		}
+	}
+	return s.stopped(err)
+}
+
+// stopped builds the stop reply for the way the CPU stopped: SIGTRAP for steps, breakpoints, watchpoints and halts,
+// SIGILL for undefined instructions and SIGSEGV for other faults.
+func (s *Stub) stopped(err error) string {
+	var fault *emu.Fault
+	switch {
+	case errors.As(err, &fault) && fault.Reason == "undefined instruction":
+		s.lastStop = "S04"
+	case err != nil:
+		s.lastStop = "S0b"
+	case s.hit != "":
+		s.lastStop = fmt.Sprintf("T05%s:%x;", s.hit, s.hitAddress)
+	default:
+		s.lastStop = "S05"
+	}
+	return s.lastStop
+}
+
+// transfer answers a qXfer read of offset,length from a document.
+func transfer(document string, location string) string {
+	offsetText, lengthText, _ := strings.Cut(location, ",")
+	offset, err := strconv.ParseUint(offsetText, 16, 32)
+	length, lengthErr := strconv.ParseUint(lengthText, 16, 32)
+	if err != nil || lengthErr != nil {
+		return "E01"
+	}
+	if offset >= uint64(len(document)) {
+		return "l"
+	}
+	end := offset + length
+	if end >= uint64(len(document)) {
+		return "l" + escape(document[offset:])
+	}
+	return "m" + escape(document[offset:end])
+}
+
+// parseRange parses the addr,length of memory packets.
+func parseRange(location string) (uint32, uint32, error) {
+	addressText, lengthText, _ := strings.Cut(location, ",")
+	address, err := strconv.ParseUint(addressText, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(lengthText, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(address), uint32(length), nil
+}
+
+// word encodes a register value the way the g and p packets carry it, as target order (little-endian) hex.
+func word(value uint32) string {
+	return fmt.Sprintf("%02x%02x%02x%02x", byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+func littleEndian(data []byte) uint32 {
+	return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+}
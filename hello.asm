@@ -0,0 +1,45 @@
+; Prints "Hello, world!" on the PL011 UART, then stops
+MOV32 R4, #0x3F201000 ; PL011 base
+MOVW R0, #0x0301 ; UARTEN, TXE and RXE
+ADD R1, R4, #0x30 ; UART_CR
+STR R0, [R1]
+ADD R5, R4, #0x18 ; UART_FR
+
+MOVW R0, #0x48 ; H
+BL putc
+MOVW R0, #0x65 ; e
+BL putc
+MOVW R0, #0x6C ; l
+BL putc
+MOVW R0, #0x6C ; l
+BL putc
+MOVW R0, #0x6F ; o
+BL putc
+MOVW R0, #0x2C ; ,
+BL putc
+MOVW R0, #0x20 ;  
+BL putc
+MOVW R0, #0x77 ; w
+BL putc
+MOVW R0, #0x6F ; o
+BL putc
+MOVW R0, #0x72 ; r
+BL putc
+MOVW R0, #0x6C ; l
+BL putc
+MOVW R0, #0x64 ; d
+BL putc
+MOVW R0, #0x21 ; !
+BL putc
+MOVW R0, #0x0A ; \n
+BL putc
+
+end:
+B end
+
+putc:
+LDR R1, [R5] ; wait for room in the transmit FIFO
+ANDS R1, R1, #0x20 ; TXFF
+BNE putc
+STR R0, [R4] ; UART_DR
+BX lr
@@ -14,14 +14,14 @@ ADD R3, R4, #0x1C ; R3 now points to GPSET
 STR R2, [R3]      ; write to GPSET
 MOVW R5, #0x0900  ; store 4 mil delay
 MOVT R5, #0x003D  ; store 4 mil delay
-STR R5, [sp]!, #4 ; store delay at the stack pointer, then increment by 4
+STR R5, [sp], #4  ; store delay at the stack pointer, then increment by 4
 BL delay
 
 ADD R3, R4, #0x28 ; R3 now points to GPCLR
 STR R2, [R3]      ; write to GPCLR
 MOVW R5, #0x4F20  ; store 1 mil delay
 MOVT R5, #0x000F  ; store 1 mil delay
-STR R5, [sp]!, #4 ; store delay at the stack pointer, then increment by 4
+STR R5, [sp], #4  ; store delay at the stack pointer, then increment by 4
 BL delay
 
 B start
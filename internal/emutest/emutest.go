@@ -0,0 +1,37 @@
+// Package emutest builds programs for the tests of the packages that run code on the emulator.
+package emutest
+
+import (
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/assembler"
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/linker"
+)
+
+// Link assembles source and links it on its own with the default script, failing the test on any error.
+func Link(t testing.TB, source string) (*assembler.Source, *linker.Image) {
+	t.Helper()
+	assembled, image, err := linker.LinkSource("test.asm", source)
+	if err != nil {
+		t.Fatalf("unexpected error building: %v", err)
+	}
+	return assembled, image
+}
+
+// Image is Link for tests that only need the linked image.
+func Image(t testing.TB, source string) *linker.Image {
+	t.Helper()
+	_, image := Link(t, source)
+	return image
+}
+
+// Load links source and loads it into a new CPU, with the PC at the entry point.
+func Load(t testing.TB, source string) (*emu.CPU, *linker.Image) {
+	t.Helper()
+	cpu, image, err := emu.LoadSource("test.asm", source)
+	if err != nil {
+		t.Fatalf("unexpected error building: %v", err)
+	}
+	return cpu, image
+}
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"strings"
 	"unicode"
 
 	"github.com/robertjshirts/rogasmic/types"
@@ -79,6 +80,29 @@ func (l *lexer) consumeComment() {
 	}
 }
 
+// consumeQualifiers consumes the .N or .W and the data types such as .F32 or .I32 straight after a mnemonic, and
+// appends a token for each
+func (l *lexer) consumeQualifiers() {
+	for l.current() == '.' {
+		end := l.pos + 1
+		for end < len(l.input) && utils.IsLiteralChar(l.input[end]) {
+			end++
+		}
+		word := strings.ToUpper(l.input[l.pos+1 : end])
+		switch {
+		case word == "N" || word == "W":
+			l.appendToken(types.TokenQualifier, "."+word, l.line, l.col)
+		case utils.IsDataType(word):
+			l.appendToken(types.TokenDataType, "."+word, l.line, l.col)
+		default:
+			return // Not a qualifier, or the start of a longer word like .word
+		}
+		for l.pos < end {
+			l.consume()
+		}
+	}
+}
+
 // appendToken appends a new token to the lexer tokens slice. sets the column to the start of the literal.
 func (l *lexer) appendToken(tokenType types.TokenType, literal string, startRow, startCol int) {
 	l.tokens = append(l.tokens, types.Token{
@@ -93,6 +117,9 @@ func (l *lexer) appendToken(tokenType types.TokenType, literal string, startRow,
 func (l *lexer) Tokenize() ([]types.Token, error) {
 	for l.current() != 0 {
 		l.skipWhitespace()
+		if l.current() == 0 {
+			break // Trailing whitespace
+		}
 		startRow := l.line // Store the starting row for the token
 		startCol := l.col  // Store the starting column for the token
 		switch l.current() {
@@ -111,13 +138,33 @@ func (l *lexer) Tokenize() ([]types.Token, error) {
 		case '!':
 			l.appendToken(types.TokenBang, string(l.current()), startRow, startCol)
 			l.consume()
+		case '^':
+			l.appendToken(types.TokenCaret, string(l.current()), startRow, startCol)
+			l.consume()
 		case '>': // Identifier
 			l.consume() // consume the '>'
 			lit := l.consumeLit()
 			l.appendToken(types.TokenIdentifier, lit, startRow, startCol)
 		case '#':
 			l.consume() // Skip the #
+			if l.current() == ':' {
+				// Relocation specifier, e.g. #:lower16:label
+				l.consume() // consume the opening ':'
+				lit := l.consumeLit()
+				if lit == "" || l.current() != ':' {
+					return nil, fmt.Errorf("invalid relocation specifier at line %d, col %d", l.line, l.col)
+				}
+				l.consume() // consume the closing ':'
+				l.appendToken(types.TokenRelocation, lit, startRow, startCol)
+				continue
+			}
 			lit := l.consumeLit()
+			if lit == "" && l.current() == '-' {
+				return nil, fmt.Errorf("negative immediate values aren't supported at line %d, col %d", startRow, startCol)
+			}
+			if lit == "" {
+				return nil, fmt.Errorf("expected immediate value after # at line %d, col %d", startRow, startCol)
+			}
 			if !utils.IsImmediate(lit) {
 				return nil, fmt.Errorf("invalid immediate value: %s at line %d, col %d", lit, l.line, l.col)
 			}
@@ -131,6 +178,17 @@ func (l *lexer) Tokenize() ([]types.Token, error) {
 		case '-':
 			l.appendToken(types.TokenDash, string(l.current()), startRow, startCol)
 			l.consume()
+		case '.': // Directive, or the current location on its own (as in .size label, .-label)
+			l.consume() // consume the '.'
+			lit := l.consumeLit()
+			l.appendToken(types.TokenDirective, "."+lit, startRow, startCol)
+		case '%': // Symbol type, as in .type label, %function
+			l.consume() // consume the '%'
+			lit := l.consumeLit()
+			if lit == "" {
+				return nil, fmt.Errorf("expected symbol type after '%%' at line %d, col %d", l.line, l.col)
+			}
+			l.appendToken(types.TokenIdentifier, lit, startRow, startCol)
 		default: // Handle registers, mnemonics, and labels/identifiers
 			lit := l.consumeLit()
 			if lit == "" {
@@ -143,8 +201,15 @@ func (l *lexer) Tokenize() ([]types.Token, error) {
 			} else if utils.IsRegister(lit) {
 				lit := utils.NormalizeRegister(lit) // For lr, sp, and pc, switch the actual register nums
 				l.appendToken(types.TokenRegister, lit, startRow, startCol)
+			} else if utils.IsFPRegister(lit) {
+				l.appendToken(types.TokenFPRegister, lit, startRow, startCol)
 			} else if utils.IsOperation(lit) {
 				l.appendToken(utils.GetMnemonicTokenType(lit), lit, startRow, startCol)
+				l.consumeQualifiers()
+			} else if utils.IsImmediate(lit) {
+				l.appendToken(types.TokenImmediate, lit, startRow, startCol) // Bare numbers, used by directives
+			} else if utils.IsIdentifier(lit) {
+				l.appendToken(types.TokenIdentifier, lit, startRow, startCol)
 			} else {
 				return nil, fmt.Errorf("unexpected identifier %s at line %d, col %d", lit, l.line, l.col)
 			}
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/robertjshirts/rogasmic/types"
@@ -145,6 +146,29 @@ func TestLexerBasics(t *testing.T) {
 				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
 			},
 		},
+		{
+			name:  "extension registers",
+			input: "s31 D0 q15 d32",
+			expectedTokens: []types.Token{
+				{Type: types.TokenFPRegister, Literal: "s31", Line: 1, Col: 1},
+				{Type: types.TokenFPRegister, Literal: "D0", Line: 1, Col: 5},
+				{Type: types.TokenFPRegister, Literal: "q15", Line: 1, Col: 8},
+				{Type: types.TokenIdentifier, Literal: "d32", Line: 1, Col: 12},
+				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
+			},
+		},
+		{
+			name:  "data types",
+			input: "vcvt.s32.f64 vld1.8",
+			expectedTokens: []types.Token{
+				{Type: types.TokenVCVT, Literal: "vcvt", Line: 1, Col: 1},
+				{Type: types.TokenDataType, Literal: ".S32", Line: 1, Col: 5},
+				{Type: types.TokenDataType, Literal: ".F64", Line: 1, Col: 9},
+				{Type: types.TokenVLD1, Literal: "vld1", Line: 1, Col: 14},
+				{Type: types.TokenDataType, Literal: ".8", Line: 1, Col: 18},
+				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
+			},
+		},
 		{
 			name:  "r bracket",
 			input: "]",
@@ -204,6 +228,65 @@ func TestLexerLines(t *testing.T) {
 				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
 			},
 		},
+		{
+			name:  "branch to bare label",
+			input: "bl blink_loop",
+			expectedTokens: []types.Token{
+				{Type: types.TokenBL, Literal: "bl", Line: 1, Col: 1},
+				{Type: types.TokenIdentifier, Literal: "blink_loop", Line: 1, Col: 4},
+				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
+			},
+		},
+		{
+			name:  "directive with values",
+			input: ".word 0x10, table",
+			expectedTokens: []types.Token{
+				{Type: types.TokenDirective, Literal: ".word", Line: 1, Col: 1},
+				{Type: types.TokenImmediate, Literal: "0x10", Line: 1, Col: 7},
+				{Type: types.TokenComma, Literal: ",", Line: 1, Col: 11},
+				{Type: types.TokenIdentifier, Literal: "table", Line: 1, Col: 13},
+				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
+			},
+		},
+		{
+			name:  "movw with relocation specifier",
+			input: "movw r0, #:lower16:table",
+			expectedTokens: []types.Token{
+				{Type: types.TokenMOVW, Literal: "movw", Line: 1, Col: 1},
+				{Type: types.TokenRegister, Literal: "r0", Line: 1, Col: 6},
+				{Type: types.TokenComma, Literal: ",", Line: 1, Col: 8},
+				{Type: types.TokenRelocation, Literal: "lower16", Line: 1, Col: 10},
+				{Type: types.TokenIdentifier, Literal: "table", Line: 1, Col: 20},
+				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
+			},
+		},
+		{
+			name:  "width qualifiers",
+			input: "adds.w r0, r0, #1\nb.n loop",
+			expectedTokens: []types.Token{
+				{Type: types.TokenADD, Literal: "adds", Line: 1, Col: 1},
+				{Type: types.TokenQualifier, Literal: ".W", Line: 1, Col: 5},
+				{Type: types.TokenRegister, Literal: "r0", Line: 1, Col: 8},
+				{Type: types.TokenComma, Literal: ",", Line: 1, Col: 10},
+				{Type: types.TokenRegister, Literal: "r0", Line: 1, Col: 12},
+				{Type: types.TokenComma, Literal: ",", Line: 1, Col: 14},
+				{Type: types.TokenImmediate, Literal: "1", Line: 1, Col: 16},
+				{Type: types.TokenB, Literal: "b", Line: 2, Col: 1},
+				{Type: types.TokenQualifier, Literal: ".N", Line: 2, Col: 2},
+				{Type: types.TokenIdentifier, Literal: "loop", Line: 2, Col: 5},
+				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
+			},
+		},
+		{
+			name:  "directive after a mnemonic isn't a qualifier",
+			input: "nop\n.word 1",
+			expectedTokens: []types.Token{
+				{Type: types.TokenNOP, Literal: "nop", Line: 1, Col: 1},
+				{Type: types.TokenDirective, Literal: ".word", Line: 2, Col: 1},
+				{Type: types.TokenImmediate, Literal: "1", Line: 2, Col: 7},
+				{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -301,3 +384,56 @@ func TestLexerRegisterList(t *testing.T) {
 		}
 	}
 }
+
+func TestLexerRegisterListUserBank(t *testing.T) {
+	input := "{R0-R12}^"
+	expectedTokens := []types.Token{
+		{Type: types.TokenLBrace, Literal: "{", Line: 1, Col: 1},
+		{Type: types.TokenRegister, Literal: "R0", Line: 1, Col: 2},
+		{Type: types.TokenDash, Literal: "-", Line: 1, Col: 4},
+		{Type: types.TokenRegister, Literal: "R12", Line: 1, Col: 5},
+		{Type: types.TokenRBrace, Literal: "}", Line: 1, Col: 8},
+		{Type: types.TokenCaret, Literal: "^", Line: 1, Col: 9},
+		{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1},
+	}
+	l := NewLexer(input)
+	tokens, err := l.Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error tokenizing: %v", err)
+	}
+	if len(tokens) != len(expectedTokens) {
+		t.Fatalf("expected %d tokens, got %d", len(expectedTokens), len(tokens))
+	}
+	for i, token := range tokens {
+		if token.Type != expectedTokens[i].Type || token.Literal != expectedTokens[i].Literal {
+			t.Errorf("token mismatch at index %d: expected %+v, got %+v", i, expectedTokens[i], token)
+		}
+	}
+}
+
+func TestLexerImmediateErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{name: "negative offset", input: "LDR R0, [R1, #-4]", err: "negative immediate values aren't supported at line 1, col 14"},
+		{name: "negative VLDR offset", input: "VLDR S0, [R0, #-8]", err: "negative immediate values aren't supported at line 1, col 15"},
+		{name: "empty SVC number", input: "SVC #", err: "expected immediate value after # at line 1, col 5"},
+		{name: "empty MSR immediate", input: "MSR CPSR_f, #\nMOVW R0, #1", err: "expected immediate value after # at line 1, col 13"},
+		{name: "empty bit field lsb", input: "UBFX R0, R1, #, #3", err: "expected immediate value after # at line 1, col 14"},
+		{name: "hex prefix only", input: "MOVW R0, #0x", err: "invalid immediate value: 0x"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewLexer(c.input).Tokenize()
+			if err == nil {
+				t.Fatalf("expected error but got none for input: %s", c.input)
+			}
+			if !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error containing %q, got %v", c.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/object"
+)
+
+// runLink implements "rogasmic link [-T script.ld] [-o kernel7.img] [-O format] files...". Inputs can be object files from
+// "rogasmic -c" or assembly sources, which are assembled on the fly.
+func runLink(args []string) error {
+	flags := flag.NewFlagSet("link", flag.ExitOnError)
+	scriptFile := flags.String("T", "", "linker script (default places everything at 0x8000)")
+	outputFile := flags.String("o", "kernel7.img", "output file")
+	outputFormat := flags.String("O", "bin", "output format: bin, ihex or srec")
+	mapFile := flags.String("map", "", "write a symbol map")
+	manifestFile := flags.String("manifest", "", "write a JSON manifest of symbols, sections, entry point and checksum")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no input files")
+	}
+
+	var objects []*object.Object
+	for _, input := range flags.Args() {
+		var obj *object.Object
+		var err error
+		if strings.HasSuffix(input, ".o") {
+			obj, err = object.ReadFile(input)
+		} else {
+			obj, err = assembleFile(input, false)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		objects = append(objects, obj)
+	}
+
+	script, err := linker.ParseScript(linker.DefaultScript)
+	if *scriptFile != "" {
+		script, err = linker.ParseScriptFile(*scriptFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	image, err := linker.NewLinker(objects, script).Link()
+	if err != nil {
+		return err
+	}
+
+	written, err := writeImage(*outputFile, *outputFormat, image)
+	if err != nil {
+		return err
+	}
+	if err := writeMaps(*mapFile, *manifestFile, image, *outputFile, *outputFormat, written); err != nil {
+		return err
+	}
+	fmt.Printf("Linked %d files into %s: %d bytes at 0x%08X, entry 0x%08X\n", len(objects), *outputFile, len(image.Data), image.Base, image.Entry)
+	return nil
+}
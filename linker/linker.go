@@ -0,0 +1,581 @@
+package linker
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/object"
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// Linker combines separately assembled objects into a single flat image, placing sections as the script says and
+// resolving symbol references between objects.
+type Linker struct {
+	objects []*object.Object
+	script  *Script
+}
+
+// Image is the linked program. Data starts at Base, gaps between sections are zero filled.
+type Image struct {
+	Base     uint32
+	Data     []byte
+	Entry    uint32
+	Sections []ImageSection
+	Symbols  []ImageSymbol
+	Thumb    []types.Range // Addresses of the Thumb code, in order, for the disassembler
+}
+
+type ImageSection struct {
+	Name    string
+	Address uint32
+	Size    uint32
+	Region  string
+	Inputs  []ImageInput
+}
+
+// ImageInput is an input section from one object, placed inside an output section.
+type ImageInput struct {
+	File    string
+	Section string
+	Address uint32
+	Size    uint32
+}
+
+type ImageSymbol struct {
+	Name    string
+	File    string // Empty for symbols assigned in the linker script
+	Section string
+	Address uint32
+	Binding types.SymbolBinding
+	Type    types.SymbolType
+	Size    uint32
+}
+
+// placement is where an input section of an object ended up. Data is a copy so relocating doesn't modify the object.
+type placement struct {
+	address uint32
+	data    []byte
+}
+
+// definition is a global symbol and where it came from, for duplicate and undefined symbol errors.
+type definition struct {
+	address uint32
+	file    string
+	line    int
+	weak    bool
+}
+
+func NewLinker(objects []*object.Object, script *Script) *Linker {
+	return &Linker{
+		objects: objects,
+		script:  script,
+	}
+}
+
+func (l *Linker) Link() (*Image, error) {
+	placements, image, scriptSymbols, err := l.place()
+	if err != nil {
+		return nil, err
+	}
+
+	locals, globals, err := l.resolveSymbols(placements, scriptSymbols, image)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.relocate(placements, locals, globals); err != nil {
+		return nil, err
+	}
+
+	if err := l.setEntry(image, globals); err != nil {
+		return nil, err
+	}
+
+	l.flatten(image, placements)
+
+	return image, nil
+}
+
+// place walks the SECTIONS statements, assigning every input section an address. Input sections no pattern matches
+// are placed after everything else, grouped by name.
+func (l *Linker) place() (map[*object.Object]map[string]*placement, *Image, map[string]uint32, error) {
+	placements := make(map[*object.Object]map[string]*placement)
+	for _, obj := range l.objects {
+		placements[obj] = make(map[string]*placement)
+	}
+
+	image := &Image{}
+	ctx := &evalContext{regions: l.script.Memory, symbols: make(map[string]uint32)}
+	cursors := make(map[string]uint32)
+	for _, region := range l.script.Memory {
+		cursors[region.Name] = region.Origin
+	}
+
+	assign := func(assignment *Assignment) error {
+		value, err := evaluate(assignment.Expression, ctx)
+		if err != nil {
+			return fmt.Errorf("linker script line %d: %w", assignment.Line, err)
+		}
+		if assignment.Symbol == "." {
+			ctx.dot = value
+		} else {
+			ctx.symbols[assignment.Symbol] = value
+		}
+		return nil
+	}
+
+	placeInput := func(out *ImageSection, obj *object.Object, section *object.Section) {
+		ctx.dot = alignUp(ctx.dot, 4)
+		placements[obj][section.Name] = &placement{address: ctx.dot, data: append([]byte(nil), section.Data...)}
+		out.Inputs = append(out.Inputs, ImageInput{
+			File:    obj.File,
+			Section: section.Name,
+			Address: ctx.dot,
+			Size:    uint32(len(section.Data)),
+		})
+		for _, thumb := range section.Thumb {
+			image.Thumb = append(image.Thumb, types.Range{Start: ctx.dot + thumb.Start, End: ctx.dot + thumb.End})
+		}
+		ctx.dot += uint32(len(section.Data))
+	}
+
+	for _, statement := range l.script.Statements {
+		if statement.Assignment != nil {
+			if err := assign(statement.Assignment); err != nil {
+				return nil, nil, nil, err
+			}
+			continue
+		}
+
+		section := statement.Section
+		if section.Address.Op != "" {
+			address, err := evaluate(section.Address, ctx)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("linker script line %d: %w", section.Line, err)
+			}
+			ctx.dot = address
+		} else if section.Region != "" {
+			cursor, ok := cursors[section.Region]
+			if !ok {
+				return nil, nil, nil, fmt.Errorf("linker script line %d: unknown memory region %s", section.Line, section.Region)
+			}
+			ctx.dot = cursor
+		}
+		ctx.dot = alignUp(ctx.dot, 4)
+
+		out := ImageSection{Name: section.Name, Address: ctx.dot, Region: section.Region}
+		for _, item := range section.Items {
+			if item.Assignment != nil {
+				if err := assign(item.Assignment); err != nil {
+					return nil, nil, nil, err
+				}
+				continue
+			}
+			for _, obj := range l.objects {
+				for i := range obj.Sections {
+					input := &obj.Sections[i]
+					if placements[obj][input.Name] == nil && matchInput(item.Input, obj.File, input.Name) {
+						placeInput(&out, obj, input)
+					}
+				}
+			}
+		}
+		out.Size = ctx.dot - out.Address
+
+		if section.Region != "" {
+			if err := l.checkRegion(section.Region, out); err != nil {
+				return nil, nil, nil, err
+			}
+			cursors[section.Region] = ctx.dot
+		}
+		image.Sections = append(image.Sections, out)
+	}
+
+	// Orphan sections go at the end, in the order they're first seen
+	for _, obj := range l.objects {
+		for i := range obj.Sections {
+			input := &obj.Sections[i]
+			if placements[obj][input.Name] != nil {
+				continue
+			}
+			out := ImageSection{Name: input.Name, Address: alignUp(ctx.dot, 4)}
+			for _, other := range l.objects {
+				if other.Section(input.Name) != nil && placements[other][input.Name] == nil {
+					placeInput(&out, other, other.Section(input.Name))
+				}
+			}
+			out.Size = ctx.dot - out.Address
+			image.Sections = append(image.Sections, out)
+		}
+	}
+	if err := checkOverlaps(image); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Thumb code in neighbouring input sections makes one range
+	sort.Slice(image.Thumb, func(i, j int) bool { return image.Thumb[i].Start < image.Thumb[j].Start })
+	var thumb []types.Range
+	for _, r := range image.Thumb {
+		if n := len(thumb); n > 0 && thumb[n-1].End == r.Start {
+			thumb[n-1].End = r.End
+		} else {
+			thumb = append(thumb, r)
+		}
+	}
+	image.Thumb = thumb
+
+	return placements, image, ctx.symbols, nil
+}
+
+func (l *Linker) checkRegion(name string, section ImageSection) error {
+	for _, region := range l.script.Memory {
+		if region.Name != name {
+			continue
+		}
+		end := uint64(region.Origin) + uint64(region.Length)
+		if uint64(section.Address) < uint64(region.Origin) || uint64(section.Address)+uint64(section.Size) > end {
+			return fmt.Errorf("section %s (0x%08X-0x%08X) doesn't fit in memory region %s (0x%08X-0x%08X)",
+				section.Name, section.Address, uint64(section.Address)+uint64(section.Size), region.Name, region.Origin, end)
+		}
+	}
+	return nil
+}
+
+// checkOverlaps makes sure no two input sections were placed on top of each other, which a script that moves the
+// location counter back can do. Empty sections take up no room, so they can't overlap anything.
+func checkOverlaps(image *Image) error {
+	var inputs []ImageInput
+	for _, section := range image.Sections {
+		for _, input := range section.Inputs {
+			if input.Size > 0 {
+				inputs = append(inputs, input)
+			}
+		}
+	}
+	sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].Address < inputs[j].Address })
+
+	var last ImageInput // The input that reaches furthest so far
+	for i, input := range inputs {
+		end := uint64(last.Address) + uint64(last.Size)
+		if i > 0 && uint64(input.Address) < end {
+			return fmt.Errorf("section %s of %s (0x%08X-0x%08X) overlaps section %s of %s (0x%08X-0x%08X)",
+				input.Section, input.File, input.Address, uint64(input.Address)+uint64(input.Size), last.Section, last.File, last.Address, end)
+		}
+		if i == 0 || uint64(input.Address)+uint64(input.Size) > end {
+			last = input
+		}
+	}
+	return nil
+}
+
+// matchInput checks an input section against a pattern. Objects remember the source file they were assembled from, so
+// file patterns are matched against the source path, its base name, and the base name with a .o extension.
+func matchInput(pattern *InputPattern, file string, section string) bool {
+	base := filepath.Base(file)
+	objectName := strings.TrimSuffix(base, filepath.Ext(base)) + ".o"
+	if !matchGlob(pattern.File, file) && !matchGlob(pattern.File, base) && !matchGlob(pattern.File, objectName) {
+		return false
+	}
+	for _, sectionPattern := range pattern.Sections {
+		if matchGlob(sectionPattern, section) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(pattern string, name string) bool {
+	matched, err := filepath.Match(pattern, name)
+	return err == nil && matched
+}
+
+// resolveSymbols builds each object's local symbol table and the table of global symbols shared by every object.
+// Script assignments are global too. A global defined twice is an error, unless one of the definitions is weak, in
+// which case the other one wins.
+func (l *Linker) resolveSymbols(placements map[*object.Object]map[string]*placement, scriptSymbols map[string]uint32, image *Image) (map[*object.Object]map[string]uint32, map[string]definition, error) {
+	var errs []error
+	locals := make(map[*object.Object]map[string]uint32)
+	globals := make(map[string]definition)
+
+	names := make([]string, 0, len(scriptSymbols))
+	for name := range scriptSymbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		globals[name] = definition{address: scriptSymbols[name], file: "linker script"}
+		image.Symbols = append(image.Symbols, ImageSymbol{Name: name, Address: scriptSymbols[name], Binding: types.BindingGlobal})
+	}
+
+	for _, obj := range l.objects {
+		locals[obj] = make(map[string]uint32)
+		for _, symbol := range obj.Symbols {
+			if !symbol.Defined {
+				continue
+			}
+			place, ok := placements[obj][symbol.Section]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s:%d: symbol %s is in unknown section %s", obj.File, symbol.Line, symbol.Name, symbol.Section))
+				continue
+			}
+			address := place.address + symbol.Offset
+			image.Symbols = append(image.Symbols, ImageSymbol{
+				Name:    symbol.Name,
+				File:    obj.File,
+				Section: symbol.Section,
+				Address: address,
+				Binding: symbol.Binding,
+				Type:    symbol.Type,
+				Size:    symbol.Size,
+			})
+			if symbol.Thumb {
+				address |= 1 // Like in ELF, references to Thumb code get bit 0 set so a BX or BLX switches state
+			}
+
+			if symbol.Binding != types.BindingWeak {
+				// Weak definitions are only used if nothing stronger turns up, so they're looked up globally
+				locals[obj][symbol.Name] = address
+			}
+			if !symbol.IsGlobal() {
+				continue
+			}
+
+			weak := symbol.Binding == types.BindingWeak
+			previous, ok := globals[symbol.Name]
+			switch {
+			case !ok || (previous.weak && !weak):
+				globals[symbol.Name] = definition{address: address, file: obj.File, line: symbol.Line, weak: weak}
+			case !previous.weak && !weak:
+				errs = append(errs, fmt.Errorf("%s:%d: duplicate symbol %s, first defined at %s", obj.File, symbol.Line, symbol.Name, previous.origin()))
+			}
+		}
+	}
+
+	sort.SliceStable(image.Symbols, func(i, j int) bool {
+		if image.Symbols[i].Address != image.Symbols[j].Address {
+			return image.Symbols[i].Address < image.Symbols[j].Address
+		}
+		return image.Symbols[i].Name < image.Symbols[j].Name
+	})
+
+	return locals, globals, errors.Join(errs...)
+}
+
+func (d definition) origin() string {
+	if d.line == 0 {
+		return d.file
+	}
+	return fmt.Sprintf("%s:%d", d.file, d.line)
+}
+
+// relocate patches every relocation site now that symbol addresses are known. Weak references that nothing defines
+// resolve to 0, any other undefined reference is an error. Every undefined symbol is reported, not just the first,
+// including .extern declarations nothing references since the final image can't have undefined symbols.
+func (l *Linker) relocate(placements map[*object.Object]map[string]*placement, locals map[*object.Object]map[string]uint32, globals map[string]definition) error {
+	var errs []error
+	for _, obj := range l.objects {
+		declared := make(map[string]object.Symbol)
+		for _, symbol := range obj.Symbols {
+			declared[symbol.Name] = symbol
+		}
+		referenced := make(map[string]bool)
+
+		for _, relocation := range obj.Relocations {
+			referenced[relocation.Symbol] = true
+			place, ok := placements[obj][relocation.Section]
+			if !ok || relocation.Offset+4 > uint32(len(place.data)) {
+				errs = append(errs, fmt.Errorf("%s:%d: relocation outside of section %s", obj.File, relocation.Line, relocation.Section))
+				continue
+			}
+
+			target, ok := locals[obj][relocation.Symbol]
+			if !ok {
+				global, found := globals[relocation.Symbol]
+				switch {
+				case found:
+					target = global.address
+				case declared[relocation.Symbol].Binding == types.BindingWeak:
+					target = 0
+				default:
+					errs = append(errs, fmt.Errorf("%s:%d: undefined reference to %s", obj.File, relocation.Line, relocation.Symbol))
+					continue
+				}
+			}
+			target += uint32(relocation.Addend)
+
+			site := place.data[relocation.Offset : relocation.Offset+4]
+			if err := applyRelocation(site, relocation.Type, place.address+relocation.Offset, target); err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %w", obj.File, relocation.Line, err))
+			}
+		}
+
+		for _, symbol := range obj.Symbols {
+			if symbol.Binding != types.BindingExtern || referenced[symbol.Name] {
+				continue
+			}
+			if _, found := globals[symbol.Name]; !found {
+				errs = append(errs, fmt.Errorf("%s:%d: undefined symbol %s declared .extern", obj.File, symbol.Line, symbol.Name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyRelocation patches the little endian word at site, which lives at address, to refer to target.
+func applyRelocation(site []byte, relocationType types.RelocationType, address uint32, target uint32) error {
+	word := uint32(site[0]) | uint32(site[1])<<8 | uint32(site[2])<<16 | uint32(site[3])<<24
+
+	switch relocationType {
+	case types.RelocationBranch:
+		var err error
+		if word, err = branchTo(word, address, target); err != nil {
+			return err
+		}
+	case types.RelocationAbs32:
+		word = target
+	case types.RelocationMOVW, types.RelocationMOVT:
+		half := target & 0xFFFF
+		if relocationType == types.RelocationMOVT {
+			half = target >> 16
+		}
+		word = word&0xFFF0F000 | (half>>12)<<16 | half&0xFFF
+	case types.RelocationThumbBranch, types.RelocationThumbMOVW, types.RelocationThumbMOVT:
+		return applyThumbRelocation(site, relocationType, address, target)
+	default:
+		return fmt.Errorf("unknown relocation type %s", relocationType)
+	}
+
+	copy(site, utils.BitsToBytes(word))
+	return nil
+}
+
+// applyThumbRelocation patches the 32 bit Thumb instruction at site, which lives at address, to refer to target.
+func applyThumbRelocation(site []byte, relocationType types.RelocationType, address uint32, target uint32) error {
+	value := utils.ThumbFromBytes(site)
+
+	switch relocationType {
+	case types.RelocationThumbBranch:
+		var err error
+		if value, err = thumbBranchTo(value, address, target); err != nil {
+			return err
+		}
+	case types.RelocationThumbMOVW:
+		value = utils.ThumbMOVImmediate(value, target&0xFFFF)
+	case types.RelocationThumbMOVT:
+		value = utils.ThumbMOVImmediate(value, target>>16)
+	}
+
+	copy(site, utils.ThumbToBytes(value, 4))
+	return nil
+}
+
+// thumbBranchTo points the Thumb B.W, B<c>.W, BL or BLX at address to target. Like branchTo, calls switch between BL
+// and BLX to match the target, and B can't switch to ARM code.
+func thumbBranchTo(value uint32, address uint32, target uint32) (uint32, error) {
+	pc := address + 4 // PC is 4 bytes ahead
+	kind := utils.ThumbBranchKind(value)
+	if kind == utils.ThumbBranchConditional {
+		if target&1 == 0 {
+			return 0, fmt.Errorf("B can't switch to ARM code at 0x%08X, call it with BL or BLX or branch with BX", target)
+		}
+		return utils.ThumbConditionalBranch(value>>22&0xF, int64(target&^1)-int64(pc))
+	}
+
+	switch {
+	case target&1 == 1 && kind == utils.ThumbBranchB:
+		return utils.ThumbBranch(kind, int64(target&^1)-int64(pc))
+	case target&1 == 1:
+		return utils.ThumbBranch(utils.ThumbBranchBL, int64(target&^1)-int64(pc)) // BLX to Thumb code is a BL
+	case kind == utils.ThumbBranchB:
+		return 0, fmt.Errorf("B can't switch to ARM code at 0x%08X, call it with BL or BLX or branch with BX", target)
+	}
+	if target%4 != 0 {
+		return 0, fmt.Errorf("branch target 0x%08X isn't word aligned", target)
+	}
+	return utils.ThumbBranch(utils.ThumbBranchBLX, int64(target)-int64(pc&^3))
+}
+
+// branchTo points the B, BL or BLX word at address to target. A target with bit 0 set is Thumb code, which BL and
+// BLX call with a BLX, and B or a conditional BL can't reach. Calls to ARM code are always BL.
+func branchTo(word uint32, address uint32, target uint32) (uint32, error) {
+	offset := int64(target&^1) - int64(address) - 8 // PC is 8 bytes ahead
+	if offset < -(1<<25) || offset >= 1<<25 {
+		return 0, fmt.Errorf("branch target 0x%08X out of range from 0x%08X", target&^1, address)
+	}
+	call := word>>28 == 0xF || word>>24&1 == 1
+	if target&1 == 0 {
+		if offset%4 != 0 {
+			return 0, fmt.Errorf("branch target 0x%08X isn't word aligned", target)
+		}
+		if word>>28 == 0xF {
+			word = 0xEB000000 // BLX to ARM code is a BL
+		}
+		return word&0xFF000000 | uint32(offset>>2)&0xFFFFFF, nil
+	}
+
+	switch {
+	case !call:
+		return 0, fmt.Errorf("B can't switch to Thumb code at 0x%08X, call it with BL or BLX or branch with BX", target&^1)
+	case word>>28 != 0xF && word>>28 != 0xE:
+		return 0, fmt.Errorf("conditional BL can't call Thumb code at 0x%08X, BLX can't be conditional", target&^1)
+	}
+	return 0xFA000000 | uint32(offset>>1&1)<<24 | uint32(offset>>2)&0xFFFFFF, nil
+}
+
+func (l *Linker) setEntry(image *Image, globals map[string]definition) error {
+	if l.script.Entry != "" {
+		entry, ok := globals[l.script.Entry]
+		if !ok {
+			return fmt.Errorf("entry symbol %s is undefined", l.script.Entry)
+		}
+		image.Entry = entry.address
+		return nil
+	}
+	if start, ok := globals["_start"]; ok {
+		image.Entry = start.address
+		return nil
+	}
+	for _, section := range image.Sections {
+		if section.Size > 0 {
+			image.Entry = section.Address
+			return nil
+		}
+	}
+	return nil
+}
+
+// flatten copies every placed section into one buffer starting at the lowest used address.
+func (l *Linker) flatten(image *Image, placements map[*object.Object]map[string]*placement) {
+	first := true
+	var low, high uint32
+	for _, places := range placements {
+		for _, place := range places {
+			if len(place.data) == 0 {
+				continue
+			}
+			end := place.address + uint32(len(place.data))
+			if first || place.address < low {
+				low = place.address
+			}
+			if first || end > high {
+				high = end
+			}
+			first = false
+		}
+	}
+	if first {
+		return // Nothing to output
+	}
+
+	image.Base = low
+	image.Data = make([]byte, high-low)
+	for _, places := range placements {
+		for _, place := range places {
+			copy(image.Data[place.address-low:], place.data)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package linker
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/assembler"
+	"github.com/robertjshirts/rogasmic/object"
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+type sourceFile struct {
+	name  string
+	input string
+}
+
+func assemble(t *testing.T, file sourceFile) *object.Object {
+	t.Helper()
+	source, err := assembler.AssembleSource(file.name, file.input)
+	if err != nil {
+		t.Fatalf("unexpected error assembling %s: %v", file.name, err)
+	}
+	return source.Object
+}
+
+func words(data []byte) []uint32 {
+	var out []uint32
+	for i := 0; i+4 <= len(data); i += 4 {
+		out = append(out, uint32(data[i])|uint32(data[i+1])<<8|uint32(data[i+2])<<16|uint32(data[i+3])<<24)
+	}
+	return out
+}
+
+func TestLinker(t *testing.T) {
+	cases := []struct {
+		name          string
+		files         []sourceFile
+		script        string
+		expectedBase  uint32
+		expectedEntry uint32
+		expected      []uint32
+		expectedThumb []types.Range
+	}{
+		{
+			name: "single file matches plain assembly",
+			files: []sourceFile{
+				{name: "loop.asm", input: "loop:\nSUBS R5, R5, #0x01\nBPL loop\nBX lr"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xE2555001, 0x5AFFFFFD, 0xE12FFF1E},
+		},
+		{
+			name: "branch to global in another file",
+			files: []sourceFile{
+				{name: "main.asm", input: ".extern delay\nstart:\nBL delay\nB start"},
+				{name: "delay.asm", input: ".global delay\ndelay:\nBX lr"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xEB000000, 0xEAFFFFFD, 0xE12FFF1E},
+		},
+		{
+			name: "absolute and movw/movt relocations",
+			files: []sourceFile{
+				{name: "main.asm", input: ".extern table\nMOVW R0, #:lower16:table\nMOVT R0, #:upper16:table\nptr:\n.word table"},
+				{name: "table.asm", input: ".global table\n.data\ntable:\n.word 0x12345678, ptr2\nptr2:"},
+			},
+			script:        "SECTIONS { . = 0x3F201234; .text : { *(.text) } .data : { *(.data) } }",
+			expectedBase:  0x3F201234,
+			expectedEntry: 0x3F201234,
+			expected:      []uint32{0xE3010240, 0xE3430F20, 0x3F201240, 0x12345678, 0x3F201248},
+		},
+		{
+			name: "sections placed by script and regions",
+			files: []sourceFile{
+				{name: "start.asm", input: ".global _start\n.data\nvalue:\n.word 0xAA\n.text\n_start:\nMOVW R0, #:lower16:value\nMOVT R0, #:upper16:value"},
+			},
+			script: `
+ENTRY(_start)
+MEMORY
+{
+	rom (rx) : ORIGIN = 0x8000, LENGTH = 16K
+	ram (rw) : ORIGIN = 0x8010, LENGTH = 0x100
+}
+SECTIONS
+{
+	.text : { start.o(.text) } > rom
+	.data : { *(.data) } > ram
+}`,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xE3080010, 0xE3400000, 0, 0, 0xAA},
+		},
+		{
+			name: "script symbol assignment",
+			files: []sourceFile{
+				{name: "main.asm", input: ".extern __stack_top\n.word __stack_top"},
+			},
+			script:        "MEMORY { ram : ORIGIN = 0x8000, LENGTH = 0x1000 }\nSECTIONS { .text : { *(.text) } > ram\n__stack_top = ORIGIN(ram) + LENGTH(ram); }",
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0x9000},
+		},
+		{
+			name: "global overrides weak",
+			files: []sourceFile{
+				{name: "main.asm", input: ".weak handler\nhandler:\nB handler\n.word handler"},
+				{name: "irq.asm", input: ".global handler\nhandler:\nBX lr"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xEA000000, 0x00008008, 0xE12FFF1E},
+		},
+		{
+			name: "weak used when nothing else defines it",
+			files: []sourceFile{
+				{name: "main.asm", input: ".weak handler\nhandler:\nB handler"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xEAFFFFFE},
+		},
+		{
+			name: "undefined weak reference is zero",
+			files: []sourceFile{
+				{name: "main.asm", input: ".weak hook\n.word hook"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0},
+		},
+		{
+			name: "calls into Thumb code",
+			files: []sourceFile{
+				{name: "main.asm", input: ".extern thumb\nBL thumb\nBLX thumb\nB arm\narm:\nBLX arm\n.word thumb"},
+				{name: "thumb.asm", input: ".global thumb\n.thumb_func\nthumb:\n.word 0x47702000"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xFA000003, 0xFA000002, 0xEAFFFFFF, 0xEBFFFFFE, 0x00008015, 0x47702000},
+		},
+		{
+			name: "halfword aligned Thumb target sets H",
+			files: []sourceFile{
+				{name: "main.asm", input: ".extern rom_fn\nBL rom_fn"},
+			},
+			script:        "SECTIONS { . = 0x8000; .text : { *(.text) } rom_fn = 0x800F; }",
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xFB000001},
+		},
+		{
+			name: "BLX to ARM code is a BL",
+			files: []sourceFile{
+				{name: "main.asm", input: ".extern delay\nBLX delay"},
+				{name: "delay.asm", input: ".global delay\ndelay:\nBX lr"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xEBFFFFFF, 0xE12FFF1E},
+		},
+		{
+			name: "Thumb code calls and branches across files",
+			files: []sourceFile{
+				{name: "main.asm", input: ".thumb\n.extern arm_fn\n.extern thumb_fn\n.extern table\nBL arm_fn\nBL thumb_fn\nBLX thumb_fn\nB thumb_fn\nBEQ.W thumb_fn\nMOVW R0, #:lower16:table\nMOVT R0, #:upper16:table"},
+				{name: "thumb.asm", input: ".global thumb_fn\n.thumb\n.thumb_func\nthumb_fn:\nBX LR"},
+				{name: "arm.asm", input: ".global arm_fn\n.global table\narm_fn:\nBX lr\ntable:\n.word 0x12345678"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{0xE80EF000, 0xF80AF000, 0xF808F000, 0xB806F000, 0x8004F000, 0x0024F248, 0x0000F2C0, 0xBF004770, 0xE12FFF1E, 0x12345678},
+			expectedThumb: []types.Range{{Start: 0x8000, End: 0x8020}},
+		},
+		{
+			name: "orphan sections go last",
+			files: []sourceFile{
+				{name: "main.asm", input: ".section .vectors\n.word 1\n.text\n.word 2"},
+			},
+			script:        DefaultScript,
+			expectedBase:  0x8000,
+			expectedEntry: 0x8000,
+			expected:      []uint32{2, 1},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var objects []*object.Object
+			for _, file := range c.files {
+				objects = append(objects, assemble(t, file))
+			}
+			script, err := ParseScript(c.script)
+			if err != nil {
+				t.Fatalf("unexpected error parsing script: %v", err)
+			}
+			image, err := NewLinker(objects, script).Link()
+			if err != nil {
+				t.Fatalf("unexpected error linking: %v", err)
+			}
+			if image.Base != c.expectedBase {
+				t.Errorf("expected base 0x%08X, got 0x%08X", c.expectedBase, image.Base)
+			}
+			if image.Entry != c.expectedEntry {
+				t.Errorf("expected entry 0x%08X, got 0x%08X", c.expectedEntry, image.Entry)
+			}
+			if !reflect.DeepEqual(image.Thumb, c.expectedThumb) {
+				t.Errorf("expected Thumb code at %+v, got %+v", c.expectedThumb, image.Thumb)
+			}
+			got := words(image.Data)
+			if len(got) != len(c.expected) {
+				t.Fatalf("expected %d words, got %d: %08X", len(c.expected), len(got), got)
+			}
+			for i := range got {
+				if got[i] != c.expected[i] {
+					t.Errorf("word mismatch at index %d: expected %08X, got %08X", i, c.expected[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLinkerErrors(t *testing.T) {
+	cases := []struct {
+		name          string
+		files         []sourceFile
+		script        string
+		expectedError []string
+	}{
+		{
+			name: "undefined symbol",
+			files: []sourceFile{
+				{name: "main.asm", input: ".extern delay\nMOVW R0, #1\nBL delay\n.word missing_too\n.extern missing_too"},
+			},
+			script:        DefaultScript,
+			expectedError: []string{"main.asm:3: undefined reference to delay", "main.asm:4: undefined reference to missing_too"},
+		},
+		{
+			name: "duplicate symbol",
+			files: []sourceFile{
+				{name: "a.asm", input: ".global delay\ndelay:\nBX lr"},
+				{name: "b.asm", input: "\n\n.global delay\ndelay:\nBX lr"},
+			},
+			script:        DefaultScript,
+			expectedError: []string{"b.asm:4: duplicate symbol delay, first defined at a.asm:2"},
+		},
+		{
+			name: "local symbols aren't shared",
+			files: []sourceFile{
+				{name: "a.asm", input: "delay:\nBX lr"},
+				{name: "b.asm", input: ".extern delay\nBL delay"},
+			},
+			script:        DefaultScript,
+			expectedError: []string{"b.asm:2: undefined reference to delay"},
+		},
+		{
+			name: "unreferenced extern",
+			files: []sourceFile{
+				{name: "a.asm", input: "BX lr\n.extern unused"},
+			},
+			script:        DefaultScript,
+			expectedError: []string{"a.asm:2: undefined symbol unused declared .extern"},
+		},
+		{
+			name: "two weak definitions aren't duplicates",
+			files: []sourceFile{
+				{name: "a.asm", input: ".weak f\nf:\nBX lr\n.extern missing\nBL missing"},
+				{name: "b.asm", input: ".weak f\nf:\nBX lr"},
+			},
+			script:        DefaultScript,
+			expectedError: []string{"a.asm:5: undefined reference to missing"},
+		},
+		{
+			name: "region overflow",
+			files: []sourceFile{
+				{name: "a.asm", input: ".word 1, 2, 3"},
+			},
+			script:        "MEMORY { tiny : ORIGIN = 0, LENGTH = 8 }\nSECTIONS { .text : { *(.text) } > tiny }",
+			expectedError: []string{"doesn't fit in memory region tiny"},
+		},
+		{
+			name: "overlapping sections",
+			files: []sourceFile{
+				{name: "main.asm", input: ".word 1, 2"},
+				{name: "other.asm", input: ".data\n.word 3"},
+			},
+			script:        "SECTIONS { . = 0x8000; .text : { *(.text) } . = 0x8004; .data : { *(.data) } }",
+			expectedError: []string{"section .data of other.asm (0x00008004-0x00008008) overlaps section .text of main.asm (0x00008000-0x00008008)"},
+		},
+		{
+			name: "B to Thumb code",
+			files: []sourceFile{
+				{name: "main.asm", input: ".extern thumb\nB thumb"},
+				{name: "thumb.asm", input: ".global thumb\n.thumb_func\nthumb:\n.word 0x47702000"},
+			},
+			script:        DefaultScript,
+			expectedError: []string{"main.asm:2: B can't switch to Thumb code at 0x00008004"},
+		},
+		{
+			name: "conditional BL to Thumb code",
+			files: []sourceFile{
+				{name: "main.asm", input: ".thumb_func\nthumb:\n.word 0x47702000\nBLEQ thumb"},
+			},
+			script:        DefaultScript,
+			expectedError: []string{"conditional BL can't call Thumb code at 0x00008000"},
+		},
+		{
+			name: "Thumb B to ARM code",
+			files: []sourceFile{
+				{name: "main.asm", input: ".thumb\n.extern arm_fn\nBNE arm_fn"},
+				{name: "arm.asm", input: ".global arm_fn\narm_fn:\nBX lr"},
+			},
+			script:        DefaultScript,
+			expectedError: []string{"main.asm:3: B can't switch to ARM code at 0x00008004"},
+		},
+		{
+			name: "missing entry",
+			files: []sourceFile{
+				{name: "a.asm", input: "BX lr"},
+			},
+			script:        "ENTRY(_start)\nSECTIONS { .text : { *(.text) } }",
+			expectedError: []string{"entry symbol _start is undefined"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var objects []*object.Object
+			for _, file := range c.files {
+				objects = append(objects, assemble(t, file))
+			}
+			script, err := ParseScript(c.script)
+			if err != nil {
+				t.Fatalf("unexpected error parsing script: %v", err)
+			}
+			_, err = NewLinker(objects, script).Link()
+			if err == nil {
+				t.Fatalf("expected error but got none")
+			}
+			for _, expected := range c.expectedError {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected error to contain %q, got %q", expected, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestLinkerObjectRoundTrip(t *testing.T) {
+	obj := assemble(t, sourceFile{name: "main.asm", input: ".extern delay\n.global start\nstart:\nBL delay"})
+
+	var buf bytes.Buffer
+	if err := obj.Write(&buf); err != nil {
+		t.Fatalf("unexpected error writing object: %v", err)
+	}
+	read, err := object.Read(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading object: %v", err)
+	}
+	if len(read.Relocations) != 1 || read.Relocations[0].Symbol != "delay" || read.Relocations[0].Type != obj.Relocations[0].Type {
+		t.Errorf("relocations didn't survive round trip: %+v", read.Relocations)
+	}
+	if !bytes.Equal(read.Sections[0].Data, utils.BitsToBytes(0xEBFFFFFE)) {
+		t.Errorf("expected unresolved branch to point at itself, got %x", read.Sections[0].Data)
+	}
+}
+
+func TestLinkerSymbolTable(t *testing.T) {
+	objects := []*object.Object{
+		assemble(t, sourceFile{name: "a.asm", input: ".global main\n.type main, %function\nmain:\nBX lr\n.size main, .-main\n.data\n.weak buf\n.type buf, %object\nbuf:\n.word 0, 0\n.size buf, 8"}),
+	}
+	script, err := ParseScript(DefaultScript)
+	if err != nil {
+		t.Fatalf("unexpected error parsing script: %v", err)
+	}
+	image, err := NewLinker(objects, script).Link()
+	if err != nil {
+		t.Fatalf("unexpected error linking: %v", err)
+	}
+
+	expected := []ImageSymbol{
+		{Name: "main", File: "a.asm", Section: ".text", Address: 0x8000, Binding: types.BindingGlobal, Type: types.SymbolFunc, Size: 4},
+		{Name: "buf", File: "a.asm", Section: ".data", Address: 0x8004, Binding: types.BindingWeak, Type: types.SymbolObject, Size: 8},
+	}
+	if len(image.Symbols) != len(expected) {
+		t.Fatalf("expected %d symbols, got %d: %+v", len(expected), len(image.Symbols), image.Symbols)
+	}
+	for i := range expected {
+		if image.Symbols[i] != expected[i] {
+			t.Errorf("symbol mismatch at index %d: expected %+v, got %+v", i, expected[i], image.Symbols[i])
+		}
+	}
+}
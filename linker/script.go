@@ -0,0 +1,557 @@
+package linker
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultScript places every section at the Pi 2/3 kernel load address, code first.
+const DefaultScript = `
+SECTIONS
+{
+	. = 0x8000;
+	.text : { *(.text) }
+	.rodata : { *(.rodata) }
+	.data : { *(.data) }
+}
+`
+
+// Script is the subset of a GNU ld linker script that rogasmic understands: ENTRY, MEMORY and SECTIONS with output
+// sections, input section patterns and symbol (or location counter) assignments.
+type Script struct {
+	Entry      string
+	Memory     []MemoryRegion
+	Statements []Statement // Contents of SECTIONS, in order
+}
+
+type MemoryRegion struct {
+	Name       string
+	Attributes string
+	Origin     uint32
+	Length     uint32
+}
+
+// Statement is either an assignment or an output section, depending on which field is set.
+type Statement struct {
+	Assignment *Assignment
+	Section    *OutputSection
+}
+
+// Assignment sets a symbol, or the location counter when the symbol is ".".
+type Assignment struct {
+	Symbol     string
+	Expression Expression
+	Line       int
+}
+
+type OutputSection struct {
+	Name    string
+	Address Expression // Optional, overrides the location counter
+	Region  string     // Optional, from "> region"
+	Items   []SectionItem
+	Line    int
+}
+
+// SectionItem is either an input section pattern or an assignment inside an output section.
+type SectionItem struct {
+	Input      *InputPattern
+	Assignment *Assignment
+}
+
+// InputPattern selects input sections by file and section name, e.g. *(.text) or start.o(.text .text.*).
+type InputPattern struct {
+	File     string
+	Sections []string
+}
+
+// Expression is a linker script expression tree. Leaves are numbers, symbols and ".", interior nodes are binary
+// operators or the ORIGIN/LENGTH/ALIGN functions.
+type Expression struct {
+	Op       string // "num", "sym", "+", "-", "*", "&", "|", "ORIGIN", "LENGTH", "ALIGN"
+	Value    uint32
+	Name     string
+	Operands []Expression
+}
+
+func ParseScriptFile(path string) (*Script, error) {
+	input, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	script, err := ParseScript(string(input))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return script, nil
+}
+
+func ParseScript(input string) (*Script, error) {
+	tokens, err := tokenizeScript(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &scriptParser{tokens: tokens}
+	return p.parse()
+}
+
+type scriptToken struct {
+	text string
+	line int
+}
+
+// isScriptWordChar checks for characters that can appear in names, numbers and file or section patterns.
+func isScriptWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_.*?$", c)
+}
+
+func tokenizeScript(input string) ([]scriptToken, error) {
+	var tokens []scriptToken
+	line := 1
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(input[i:], "/*"):
+			end := strings.Index(input[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			comment := input[i : i+2+end+2]
+			line += strings.Count(comment, "\n")
+			i += len(comment)
+		case isScriptWordChar(c):
+			start := i
+			for i < len(input) && isScriptWordChar(rune(input[i])) {
+				i++
+			}
+			tokens = append(tokens, scriptToken{text: input[start:i], line: line})
+		case strings.ContainsRune("{}():;=,>+-&|", c):
+			tokens = append(tokens, scriptToken{text: string(c), line: line})
+			i++
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+	}
+	return tokens, nil
+}
+
+type scriptParser struct {
+	tokens []scriptToken
+	pos    int
+}
+
+func (p *scriptParser) current() scriptToken {
+	if p.pos >= len(p.tokens) {
+		line := 0
+		if len(p.tokens) > 0 {
+			line = p.tokens[len(p.tokens)-1].line
+		}
+		return scriptToken{text: "", line: line}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *scriptParser) consume() {
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+}
+
+func (p *scriptParser) expect(text string) error {
+	if p.current().text != text {
+		return fmt.Errorf("line %d: expected %q, got %q", p.current().line, text, p.current().text)
+	}
+	p.consume()
+	return nil
+}
+
+func (p *scriptParser) parse() (*Script, error) {
+	script := &Script{}
+	for p.current().text != "" {
+		switch p.current().text {
+		case "ENTRY":
+			p.consume() // consume ENTRY
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			script.Entry = p.current().text
+			p.consume() // consume entry symbol
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		case "MEMORY":
+			p.consume() // consume MEMORY
+			regions, err := p.parseMemory()
+			if err != nil {
+				return nil, err
+			}
+			script.Memory = append(script.Memory, regions...)
+		case "SECTIONS":
+			p.consume() // consume SECTIONS
+			statements, err := p.parseSections(script)
+			if err != nil {
+				return nil, err
+			}
+			script.Statements = append(script.Statements, statements...)
+		case ";":
+			p.consume()
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", p.current().line, p.current().text)
+		}
+	}
+	return script, nil
+}
+
+// parseMemory parses the body of MEMORY { name (attrs) : ORIGIN = expr, LENGTH = expr ... }
+func (p *scriptParser) parseMemory() ([]MemoryRegion, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var regions []MemoryRegion
+	for p.current().text != "}" {
+		if p.current().text == "" {
+			return nil, fmt.Errorf("line %d: unterminated MEMORY block", p.current().line)
+		}
+		region := MemoryRegion{Name: p.current().text}
+		p.consume() // consume region name
+
+		if p.current().text == "(" {
+			p.consume() // consume (
+			for p.current().text != ")" && p.current().text != "" {
+				region.Attributes += p.current().text
+				p.consume()
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+
+		for _, field := range []string{"ORIGIN", "LENGTH"} {
+			name := p.current().text
+			if !isMemoryField(name, field) {
+				return nil, fmt.Errorf("line %d: expected %s in region %s, got %q", p.current().line, field, region.Name, name)
+			}
+			p.consume() // consume field name
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			expr, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			value, err := evaluate(expr, &evalContext{regions: regions})
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", p.current().line, err)
+			}
+			if field == "ORIGIN" {
+				region.Origin = value
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			} else {
+				region.Length = value
+			}
+		}
+		regions = append(regions, region)
+	}
+	p.consume() // consume }
+
+	return regions, nil
+}
+
+func isMemoryField(name string, field string) bool {
+	switch field {
+	case "ORIGIN":
+		return name == "ORIGIN" || name == "org" || name == "o"
+	case "LENGTH":
+		return name == "LENGTH" || name == "len" || name == "l"
+	}
+	return false
+}
+
+// parseSections parses the body of SECTIONS { ... }
+func (p *scriptParser) parseSections(script *Script) ([]Statement, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var statements []Statement
+	for p.current().text != "}" {
+		if p.current().text == "" {
+			return nil, fmt.Errorf("line %d: unterminated SECTIONS block", p.current().line)
+		}
+		if p.current().text == ";" {
+			p.consume()
+			continue
+		}
+		if p.current().text == "ENTRY" {
+			p.consume() // consume ENTRY
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			script.Entry = p.current().text
+			p.consume() // consume entry symbol
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		name := p.current()
+		p.consume() // consume symbol or section name
+		if p.current().text == "=" {
+			assignment, err := p.parseAssignment(name)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, Statement{Assignment: assignment})
+			continue
+		}
+
+		section, err := p.parseOutputSection(name)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, Statement{Section: section})
+	}
+	p.consume() // consume }
+
+	return statements, nil
+}
+
+func (p *scriptParser) parseAssignment(symbol scriptToken) (*Assignment, error) {
+	p.consume() // consume =
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return &Assignment{Symbol: symbol.text, Expression: expr, Line: symbol.line}, nil
+}
+
+// parseOutputSection parses name [address] : { items } [> region]
+func (p *scriptParser) parseOutputSection(name scriptToken) (*OutputSection, error) {
+	section := &OutputSection{Name: name.text, Line: name.line}
+
+	if p.current().text != ":" {
+		address, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		section.Address = address
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	for p.current().text != "}" {
+		if p.current().text == "" {
+			return nil, fmt.Errorf("line %d: unterminated output section %s", p.current().line, section.Name)
+		}
+		if p.current().text == ";" {
+			p.consume()
+			continue
+		}
+
+		token := p.current()
+		p.consume() // consume file pattern or symbol
+		if p.current().text == "=" {
+			assignment, err := p.parseAssignment(token)
+			if err != nil {
+				return nil, err
+			}
+			section.Items = append(section.Items, SectionItem{Assignment: assignment})
+			continue
+		}
+
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		input := &InputPattern{File: token.text}
+		for p.current().text != ")" {
+			if p.current().text == "" {
+				return nil, fmt.Errorf("line %d: unterminated input section list", p.current().line)
+			}
+			input.Sections = append(input.Sections, p.current().text)
+			p.consume() // consume section pattern
+		}
+		p.consume() // consume )
+		section.Items = append(section.Items, SectionItem{Input: input})
+	}
+	p.consume() // consume }
+
+	if p.current().text == ">" {
+		p.consume() // consume >
+		section.Region = p.current().text
+		p.consume() // consume region name
+	}
+
+	return section, nil
+}
+
+// parseExpression parses binary operators left to right. Scripts are small enough that precedence isn't worth it,
+// use parentheses instead.
+func (p *scriptParser) parseExpression() (Expression, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return Expression{}, err
+	}
+	for {
+		op := p.current().text
+		if op != "+" && op != "-" && op != "*" && op != "&" && op != "|" {
+			return left, nil
+		}
+		p.consume() // consume operator
+		right, err := p.parseTerm()
+		if err != nil {
+			return Expression{}, err
+		}
+		left = Expression{Op: op, Operands: []Expression{left, right}}
+	}
+}
+
+func (p *scriptParser) parseTerm() (Expression, error) {
+	token := p.current()
+	switch {
+	case token.text == "(":
+		p.consume() // consume (
+		expr, err := p.parseExpression()
+		if err != nil {
+			return Expression{}, err
+		}
+		return expr, p.expect(")")
+	case token.text == "ORIGIN" || token.text == "LENGTH" || token.text == "ALIGN":
+		p.consume() // consume function name
+		if err := p.expect("("); err != nil {
+			return Expression{}, err
+		}
+		expr := Expression{Op: token.text}
+		if token.text == "ALIGN" {
+			operand, err := p.parseExpression()
+			if err != nil {
+				return Expression{}, err
+			}
+			expr.Operands = []Expression{operand}
+		} else {
+			expr.Name = p.current().text
+			p.consume() // consume region name
+		}
+		return expr, p.expect(")")
+	case token.text != "" && unicode.IsDigit(rune(token.text[0])):
+		p.consume() // consume number
+		value, err := parseScriptNumber(token.text)
+		if err != nil {
+			return Expression{}, fmt.Errorf("line %d: %w", token.line, err)
+		}
+		return Expression{Op: "num", Value: value}, nil
+	case token.text != "" && isScriptWordChar(rune(token.text[0])):
+		p.consume() // consume symbol
+		return Expression{Op: "sym", Name: token.text}, nil
+	default:
+		return Expression{}, fmt.Errorf("line %d: expected expression, got %q", token.line, token.text)
+	}
+}
+
+// parseScriptNumber parses decimal, hex (0x) and octal numbers with an optional K or M multiplier.
+func parseScriptNumber(text string) (uint32, error) {
+	multiplier := uint64(1)
+	if strings.HasSuffix(text, "K") || strings.HasSuffix(text, "k") {
+		multiplier = 1024
+		text = text[:len(text)-1]
+	} else if strings.HasSuffix(text, "M") || strings.HasSuffix(text, "m") {
+		multiplier = 1024 * 1024
+		text = text[:len(text)-1]
+	}
+	value, err := strconv.ParseUint(text, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", text)
+	}
+	value *= multiplier
+	if value > 0xFFFFFFFF {
+		return 0, fmt.Errorf("number %q out of range", text)
+	}
+	return uint32(value), nil
+}
+
+type evalContext struct {
+	dot     uint32
+	regions []MemoryRegion
+	symbols map[string]uint32
+}
+
+func evaluate(expr Expression, ctx *evalContext) (uint32, error) {
+	switch expr.Op {
+	case "num":
+		return expr.Value, nil
+	case "sym":
+		if expr.Name == "." {
+			return ctx.dot, nil
+		}
+		value, ok := ctx.symbols[expr.Name]
+		if !ok {
+			return 0, fmt.Errorf("undefined symbol %s in expression", expr.Name)
+		}
+		return value, nil
+	case "ORIGIN", "LENGTH":
+		for _, region := range ctx.regions {
+			if region.Name == expr.Name {
+				if expr.Op == "ORIGIN" {
+					return region.Origin, nil
+				}
+				return region.Length, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown memory region %s", expr.Name)
+	case "ALIGN":
+		align, err := evaluate(expr.Operands[0], ctx)
+		if err != nil {
+			return 0, err
+		}
+		return alignUp(ctx.dot, align), nil
+	}
+
+	left, err := evaluate(expr.Operands[0], ctx)
+	if err != nil {
+		return 0, err
+	}
+	right, err := evaluate(expr.Operands[1], ctx)
+	if err != nil {
+		return 0, err
+	}
+	switch expr.Op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "&":
+		return left & right, nil
+	case "|":
+		return left | right, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", expr.Op)
+}
+
+func alignUp(value uint32, align uint32) uint32 {
+	if align <= 1 {
+		return value
+	}
+	return (value + align - 1) / align * align
+}
@@ -0,0 +1,23 @@
+package linker
+
+import (
+	"github.com/robertjshirts/rogasmic/assembler"
+	"github.com/robertjshirts/rogasmic/object"
+)
+
+// LinkSource assembles the text of a source file and links it on its own with the default script.
+func LinkSource(file string, text string) (*assembler.Source, *Image, error) {
+	source, err := assembler.AssembleSource(file, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	script, err := ParseScript(DefaultScript)
+	if err != nil {
+		return nil, nil, err
+	}
+	image, err := NewLinker([]*object.Object{source.Object}, script).Link()
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, image, nil
+}
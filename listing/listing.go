@@ -0,0 +1,186 @@
+package listing
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Listing lays the source of a file out next to the address and encoding of everything it assembled to.
+type Listing struct {
+	file         string
+	source       []string
+	instructions []types.Instruction
+	sections     []types.Section
+	symbols      []types.Symbol
+	placements   map[string]placement
+}
+
+// placement is where a section ended up and the bytes it holds there.
+type placement struct {
+	address uint32
+	data    []byte
+}
+
+// line is a single instruction as it appears in the listing.
+type line struct {
+	address  uint32
+	encoding string // Hex digits of the word, or of the halfwords of a Thumb instruction in the order they're executed
+	pseudo   bool
+}
+
+// New creates a listing for a parsed file. Sections need to be placed before the listing is written.
+func New(file string, source string, instructions []types.Instruction, sections []types.Section, symbols []types.Symbol) *Listing {
+	return &Listing{
+		file:         file,
+		source:       strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n"),
+		instructions: instructions,
+		sections:     sections,
+		symbols:      symbols,
+		placements:   make(map[string]placement),
+	}
+}
+
+// Place records the address a section was placed at and its bytes there. For a linked image these are the final,
+// relocated bytes; for a partial build they're the object file bytes at address 0.
+func (l *Listing) Place(section string, address uint32, data []byte) {
+	l.placements[section] = placement{address: address, data: data}
+}
+
+// Write writes the listing: every source line with the address and encoding of the instructions on it, followed by
+// the symbol table.
+func (l *Listing) Write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	byLine := make(map[int][]line)
+	for _, section := range l.sections {
+		placed, ok := l.placements[section.Name]
+		if !ok {
+			return fmt.Errorf("section %s hasn't been placed", section.Name)
+		}
+		offset := uint32(0)
+		for i := section.Start; i < section.End; i++ {
+			instruction := l.instructions[i]
+			size := types.Size(instruction)
+			if int(offset+size) > len(placed.data) {
+				return fmt.Errorf("section %s is shorter than its instructions", section.Name)
+			}
+			if size == 0 {
+				continue // Padding that wasn't needed
+			}
+			number := instruction.SourceToken().Line
+			byLine[number] = append(byLine[number], line{
+				address:  placed.address + offset,
+				encoding: encoding(placed.data[offset:offset+size], parser.IsThumb(instruction)),
+				pseudo:   parser.IsPseudo(instruction),
+			})
+			offset += size
+		}
+	}
+
+	labels := make(map[int]uint32)
+	for _, symbol := range l.symbols {
+		if address, ok := l.address(symbol); ok {
+			labels[symbol.Token.Line] = address
+		}
+	}
+
+	fmt.Fprintf(out, "rogasmic listing of %s\n\n", l.file)
+	fmt.Fprintf(out, "%5s  %-8s  %-8s    %s\n", "Line", "Address", "Encoding", "Source")
+	pseudo := false
+	for i, source := range l.source {
+		number := i + 1
+		if i == len(l.source)-1 && source == "" {
+			break // Trailing newline
+		}
+		instructions := byLine[number]
+		if len(instructions) == 0 {
+			if address, ok := labels[number]; ok {
+				writeLine(out, "%5d  %08X  %-8s    %s", number, address, "", source)
+			} else {
+				writeLine(out, "%5d  %-8s  %-8s    %s", number, "", "", source)
+			}
+			continue
+		}
+		for j, instruction := range instructions {
+			mark := " "
+			if instruction.pseudo {
+				mark = "+"
+				pseudo = true
+			}
+			if j == 0 {
+				writeLine(out, "%5d  %08X  %-8s %s  %s", number, instruction.address, instruction.encoding, mark, source)
+			} else {
+				writeLine(out, "%5s  %08X  %-8s %s", "", instruction.address, instruction.encoding, mark)
+			}
+		}
+	}
+	if pseudo {
+		fmt.Fprintf(out, "\n+ expanded from a pseudo-instruction\n")
+	}
+
+	l.writeSymbols(out)
+	return out.Flush()
+}
+
+// encoding formats the bytes of an instruction. Words are printed as a little endian value, and so is each halfword
+// of Thumb code, first halfword first.
+func encoding(data []byte, thumb bool) string {
+	if thumb {
+		var out strings.Builder
+		for n := 0; n < len(data); n += 2 {
+			fmt.Fprintf(&out, "%02X%02X", data[n+1], data[n])
+		}
+		return out.String()
+	}
+	return fmt.Sprintf("%08X", uint32(data[0])|uint32(data[1])<<8|uint32(data[2])<<16|uint32(data[3])<<24)
+}
+
+// writeLine writes a formatted line without trailing whitespace, which the padded columns leave on short lines.
+func writeLine(out *bufio.Writer, format string, args ...any) {
+	out.WriteString(strings.TrimRight(fmt.Sprintf(format, args...), " \t"))
+	out.WriteByte('\n')
+}
+
+// writeSymbols appends the symbol table, sorted by address with undefined symbols last.
+func (l *Listing) writeSymbols(out *bufio.Writer) {
+	symbols := append([]types.Symbol(nil), l.symbols...)
+	sort.SliceStable(symbols, func(i, j int) bool {
+		a, aOk := l.address(symbols[i])
+		b, bOk := l.address(symbols[j])
+		if aOk != bOk {
+			return aOk
+		}
+		return a < b
+	})
+
+	fmt.Fprintf(out, "\nSymbols:\n")
+	fmt.Fprintf(out, "%-8s  %-10s %-6s %-6s %6s  %s\n", "Address", "Section", "Bind", "Type", "Size", "Name")
+	for _, symbol := range symbols {
+		address, ok := l.address(symbol)
+		if !ok {
+			fmt.Fprintf(out, "%-8s  %-10s %-6s %-6s %6d  %s\n", "", "*UND*", symbol.Binding, symbol.Type, symbol.Size, symbol.Name)
+			continue
+		}
+		fmt.Fprintf(out, "%08X  %-10s %-6s %-6s %6d  %s\n", address, symbol.Section, symbol.Binding, symbol.Type, symbol.Size, symbol.Name)
+	}
+}
+
+// address returns where a defined symbol ended up.
+func (l *Listing) address(symbol types.Symbol) (uint32, bool) {
+	if !symbol.Defined {
+		return 0, false
+	}
+	for _, section := range l.sections {
+		if section.Name == symbol.Section {
+			placed, ok := l.placements[section.Name]
+			return placed.address + symbol.Offset, ok
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,158 @@
+package listing
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/assembler"
+	"github.com/robertjshirts/rogasmic/lexer"
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/object"
+	"github.com/robertjshirts/rogasmic/parser"
+)
+
+func TestListing(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		link     bool
+		expected string
+	}{
+		{
+			name:  "linked image",
+			input: "; delay loop\n.global delay\ndelay:\n    MOV32 R5, #0x003D0900 ; count\nloop: SUBS R5, R5, #0x01\n    BPL loop\n    BX lr\n",
+			link:  true,
+			expected: `rogasmic listing of test.asm
+
+ Line  Address   Encoding    Source
+    1                        ; delay loop
+    2                        .global delay
+    3  00008000              delay:
+    4  00008000  E3005900 +      MOV32 R5, #0x003D0900 ; count
+       00008004  E340503D +
+    5  00008008  E2555001    loop: SUBS R5, R5, #0x01
+    6  0000800C  5AFFFFFD        BPL loop
+    7  00008010  E12FFF1E        BX lr
+
++ expanded from a pseudo-instruction
+
+Symbols:
+Address   Section    Bind   Type     Size  Name
+00008000  .text      global notype      0  delay
+00008008  .text      local  notype      0  loop
+`,
+		},
+		{
+			name:  "relocations filled in by the linker",
+			input: ".text\nMOVW R0, #:lower16:value\nMOVT R0, #:upper16:value\n.data\nvalue: .word value\n",
+			link:  true,
+			expected: `rogasmic listing of test.asm
+
+ Line  Address   Encoding    Source
+    1                        .text
+    2  00008000  E3080008    MOVW R0, #:lower16:value
+    3  00008004  E3400000    MOVT R0, #:upper16:value
+    4                        .data
+    5  00008008  00008008    value: .word value
+
+Symbols:
+Address   Section    Bind   Type     Size  Name
+00008008  .data      local  notype      0  value
+`,
+		},
+		{
+			name:  "object file at address 0",
+			input: ".extern delay\n.data\ncount: .word 3\n.text\nstart: BL delay\n",
+			expected: `rogasmic listing of test.asm
+
+ Line  Address   Encoding    Source
+    1                        .extern delay
+    2                        .data
+    3  00000000  00000003    count: .word 3
+    4                        .text
+    5  00000000  EBFFFFFE    start: BL delay
+
+Symbols:
+Address   Section    Bind   Type     Size  Name
+00000000  .data      local  notype      0  count
+00000000  .text      local  notype      0  start
+          *UND*      extern notype      0  delay
+`,
+		},
+		{
+			name:  "Thumb code",
+			input: ".thumb\n.thumb_func\nstart: ADDS R0, R0, #1\n    BL.W start\n.arm\n    BX lr\n",
+			link:  true,
+			expected: `rogasmic listing of test.asm
+
+ Line  Address   Encoding    Source
+    1                        .thumb
+    2                        .thumb_func
+    3  00008000  1C40        start: ADDS R0, R0, #1
+    4  00008002  F7FFFFFD        BL.W start
+    5                        .arm
+    6  00008006  BF00            BX lr
+       00008008  E12FFF1E
+
+Symbols:
+Address   Section    Bind   Type     Size  Name
+00008000  .text      local  func        0  start
+`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source, err := assembler.AssembleSource("test.asm", tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error assembling: %v", err)
+			}
+
+			l := New(source.File, source.Text, source.Instructions, source.Sections, source.Symbols)
+			for _, section := range source.Object.Sections {
+				l.Place(section.Name, 0, section.Data)
+			}
+			if tc.link {
+				script, err := linker.ParseScript(linker.DefaultScript)
+				if err != nil {
+					t.Fatalf("unexpected error parsing script: %v", err)
+				}
+				image, err := linker.NewLinker([]*object.Object{source.Object}, script).Link()
+				if err != nil {
+					t.Fatalf("unexpected error linking: %v", err)
+				}
+				for _, section := range image.Sections {
+					for _, input := range section.Inputs {
+						start := input.Address - image.Base
+						l.Place(input.Section, input.Address, image.Data[start:start+input.Size])
+					}
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := l.Write(&buf); err != nil {
+				t.Fatalf("unexpected error writing listing: %v", err)
+			}
+			if buf.String() != tc.expected {
+				t.Errorf("listing mismatch\nexpected:\n%s\ngot:\n%s", tc.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestListingUnplacedSection(t *testing.T) {
+	toks, err := lexer.NewLexer("MOVW R0, #1").Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error tokenizing: %v", err)
+	}
+	p := parser.NewParser(toks)
+	instructions, _, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	err = New("test.asm", "MOVW R0, #1", instructions, p.Sections(), p.Symbols()).Write(&bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "section .text hasn't been placed") {
+		t.Errorf("expected unplaced section error, got %v", err)
+	}
+}
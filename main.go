@@ -1,62 +1,152 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
-	"github.com/robertjshirts/rogasmic/assembler"
-	"github.com/robertjshirts/rogasmic/lexer"
-	"github.com/robertjshirts/rogasmic/parser"
-	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/object"
 )
 
 func main() {
-	inputFile := "labels.asm"
-	if len(os.Args) > 1 {
-		inputFile = os.Args[1]
+	if len(os.Args) > 1 && os.Args[1] == "link" {
+		if err := runLink(os.Args[2:]); err != nil {
+			fmt.Printf("Error linking: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-	file, err := os.ReadFile(inputFile)
-	if err != nil {
-		panic(err)
+	if len(os.Args) > 1 && os.Args[1] == "nm" {
+		if err := runNM(os.Args[2:]); err != nil {
+			fmt.Printf("Error reading symbols: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-	l := lexer.NewLexer(string(file))
-	tokens, err := l.Tokenize()
-	if err != nil {
-		panic(err)
+
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		if err := runConvert(os.Args[2:]); err != nil {
+			fmt.Printf("Error converting: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	fmt.Printf("Tokenized %d tokens:\n", len(tokens))
-	for _, token := range tokens {
-		fmt.Printf("Token Type=%s, Literal=%s, Line=%d, Col=%d\n", types.TokenToLiteral[token.Type], token.Literal, token.Line, token.Col)
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		if err := runDisasm(os.Args[2:]); err != nil {
+			fmt.Printf("Error disassembling: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	p := parser.NewParser(tokens)
-	instructions, labelMap, err := p.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		if err := runRun(os.Args[2:]); err != nil {
+			fmt.Printf("Error running: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "debug" {
+		if err := runDebug(os.Args[2:]); err != nil {
+			fmt.Printf("Error debugging: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "gdb" {
+		if err := runGDB(os.Args[2:]); err != nil {
+			fmt.Printf("Error serving GDB: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "trace" {
+		if err := runTrace(os.Args[2:]); err != nil {
+			fmt.Printf("Error tracing: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	objectOnly := flag.Bool("c", false, "partial build: assemble to an object file for the linker, .extern symbols may stay undefined")
+	outputFlag := flag.String("o", "", "output file (default kernel7.img, or <input>.o with -c)")
+	outputFormat := flag.String("O", "bin", "output format: bin, ihex or srec")
+	listingFile := flag.String("l", "", "write a listing with addresses, encodings and source side by side")
+	mapFile := flag.String("map", "", "write a symbol map")
+	manifestFile := flag.String("manifest", "", "write a JSON manifest of symbols, sections, entry point and checksum")
+	flag.Parse()
+
+	inputFile := "labels.asm"
+	if flag.NArg() > 0 {
+		inputFile = flag.Arg(0)
+	}
+
+	assembled, err := assembleSource(inputFile, true)
 	if err != nil {
-		fmt.Printf("Error parsing instructions: %v\n", err)
+		fmt.Printf("Error assembling %s: %v\n", inputFile, err)
 		return
 	}
+	obj := assembled.Object
 
-	fmt.Printf("Parsed %d instructions:\n", len(instructions))
+	if *objectOnly {
+		outputFile := *outputFlag
+		if outputFile == "" {
+			outputFile = objectPath(inputFile)
+		}
+		if err := object.WriteFile(outputFile, obj); err != nil {
+			fmt.Printf("Error writing object file %s: %v\n", outputFile, err)
+			return
+		}
+		fmt.Printf("Wrote object file %s\n", outputFile)
+		if *listingFile != "" {
+			if err := writeListing(*listingFile, sourceListing(assembled, nil)); err != nil {
+				fmt.Printf("Error writing listing %s: %v\n", *listingFile, err)
+			}
+		}
+		return
+	}
 
-	a := assembler.NewAssembler(instructions, labelMap)
-	machineCode, err := a.Assemble()
+	script, err := linker.ParseScript(linker.DefaultScript)
+	if err != nil {
+		panic(err)
+	}
+	image, err := linker.NewLinker([]*object.Object{obj}, script).Link()
 	if err != nil {
-		fmt.Printf("Error assembling instructions: %v\n", err)
+		fmt.Printf("Error linking: %v\n", err)
 		return
 	}
+	machineCode := image.Data
 	fmt.Printf("Assembled machine code: %x\n", machineCode)
 
 	fmt.Printf("Writing kernel7.img...\n")
 	outputFile := "kernel7.img"
-	if len(os.Args) > 2 {
-		outputFile = os.Args[2]
+	if flag.NArg() > 1 {
+		outputFile = flag.Arg(1)
 	}
-	err = os.WriteFile(outputFile, machineCode, 0644)
+	if *outputFlag != "" {
+		outputFile = *outputFlag
+	}
+	written, err := writeImage(outputFile, *outputFormat, image)
 	if err != nil {
 		fmt.Printf("Error writing output file %s: %v\n", outputFile, err)
 		return
 	}
 	fmt.Printf("Successfully wrote %d bytes to %s\n", len(machineCode), outputFile)
+	if err := writeMaps(*mapFile, *manifestFile, image, outputFile, *outputFormat, written); err != nil {
+		fmt.Printf("Error writing symbol map: %v\n", err)
+		return
+	}
+	if *listingFile != "" {
+		if err := writeListing(*listingFile, sourceListing(assembled, image)); err != nil {
+			fmt.Printf("Error writing listing %s: %v\n", *listingFile, err)
+			return
+		}
+		fmt.Printf("Wrote listing %s\n", *listingFile)
+	}
 	fmt.Printf("Done!\n")
 }
@@ -0,0 +1,208 @@
+package mapfile
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Map describes where everything in a linked image ended up. It's written as a text .map file for people and as a
+// JSON manifest for tools.
+type Map struct {
+	Output   string    `json:"output"`
+	Format   string    `json:"format"`
+	Base     uint32    `json:"base"`
+	Size     uint32    `json:"size"`
+	Entry    uint32    `json:"entry"`
+	Checksum Checksum  `json:"checksum"`
+	Sections []Section `json:"sections"`
+	Symbols  []Symbol  `json:"symbols"`
+}
+
+// Checksum of the output file as written, so it covers the HEX/SREC text rather than the image for those formats.
+type Checksum struct {
+	CRC32  string `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+type Section struct {
+	Name   string  `json:"name"`
+	Start  uint32  `json:"start"`
+	End    uint32  `json:"end"` // Exclusive
+	Size   uint32  `json:"size"`
+	Region string  `json:"region,omitempty"`
+	Inputs []Input `json:"inputs"`
+}
+
+// Input is a section from one source file placed inside an output section.
+type Input struct {
+	File    string `json:"file"`
+	Section string `json:"section"`
+	Start   uint32 `json:"start"`
+	Size    uint32 `json:"size"`
+}
+
+type Symbol struct {
+	Name    string              `json:"name"`
+	Address uint32              `json:"address"`
+	Section string              `json:"section,omitempty"` // Empty for symbols assigned in the linker script
+	Size    uint32              `json:"size"`
+	Binding types.SymbolBinding `json:"binding"`
+	Type    types.SymbolType    `json:"type"`
+	File    string              `json:"file,omitempty"`
+}
+
+// New builds the map of a linked image. The symbols are the labels from each file's label map (plus linker script
+// assignments) at their final addresses. output is the name of the output file and data what was written to it.
+func New(image *linker.Image, output string, format string, data []byte) *Map {
+	sum := sha256.Sum256(data)
+	m := &Map{
+		Output: output,
+		Format: format,
+		Base:   image.Base,
+		Size:   uint32(len(image.Data)),
+		Entry:  image.Entry,
+		Checksum: Checksum{
+			CRC32:  fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		},
+		Sections: make([]Section, 0, len(image.Sections)),
+		Symbols:  make([]Symbol, 0, len(image.Symbols)),
+	}
+
+	for _, section := range image.Sections {
+		out := Section{
+			Name:   section.Name,
+			Start:  section.Address,
+			End:    section.Address + section.Size,
+			Size:   section.Size,
+			Region: section.Region,
+			Inputs: make([]Input, 0, len(section.Inputs)),
+		}
+		for _, input := range section.Inputs {
+			out.Inputs = append(out.Inputs, Input{File: input.File, Section: input.Section, Start: input.Address, Size: input.Size})
+		}
+		m.Sections = append(m.Sections, out)
+	}
+
+	for _, symbol := range image.Symbols {
+		m.Symbols = append(m.Symbols, Symbol{
+			Name:    symbol.Name,
+			Address: symbol.Address,
+			Section: symbol.Section,
+			Size:    symbol.Size,
+			Binding: symbol.Binding,
+			Type:    symbol.Type,
+			File:    symbol.File,
+		})
+	}
+
+	return m
+}
+
+// Lookup finds a symbol by name. Global symbols are preferred over locals of the same name from different files.
+func (m *Map) Lookup(name string) (Symbol, bool) {
+	var found Symbol
+	ok := false
+	for _, symbol := range m.Symbols {
+		if symbol.Name != name {
+			continue
+		}
+		if !ok || (symbol.Binding == types.BindingGlobal && found.Binding != types.BindingGlobal) {
+			found, ok = symbol, true
+		}
+	}
+	return found, ok
+}
+
+// Write writes the map as text.
+func (m *Map) Write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "Output:      %s (%s)\n", m.Output, m.Format)
+	fmt.Fprintf(out, "Load:        0x%08X, %d bytes\n", m.Base, m.Size)
+	fmt.Fprintf(out, "Entry point: 0x%08X\n", m.Entry)
+	fmt.Fprintf(out, "CRC32:       %s\n", m.Checksum.CRC32)
+	fmt.Fprintf(out, "SHA-256:     %s\n", m.Checksum.SHA256)
+
+	fmt.Fprintf(out, "\nSections:\n")
+	fmt.Fprintf(out, "%-12s %-10s %-10s %-10s %s\n", "Name", "Start", "End", "Size", "Region")
+	for _, section := range m.Sections {
+		writeLine(out, "%-12s 0x%08X 0x%08X 0x%08X %s", section.Name, section.Start, section.End, section.Size, section.Region)
+		for _, input := range section.Inputs {
+			writeLine(out, "  %-10s 0x%08X            0x%08X %s", input.Section, input.Start, input.Size, input.File)
+		}
+	}
+
+	fmt.Fprintf(out, "\nSymbols:\n")
+	fmt.Fprintf(out, "%-10s %-12s %-10s %-6s %-6s %-20s %s\n", "Address", "Section", "Size", "Bind", "Type", "Name", "File")
+	for _, symbol := range m.Symbols {
+		section := symbol.Section
+		if section == "" {
+			section = "*ABS*"
+		}
+		writeLine(out, "0x%08X %-12s 0x%08X %-6s %-6s %-20s %s", symbol.Address, section, symbol.Size, symbol.Binding, symbol.Type, symbol.Name, symbol.File)
+	}
+
+	return out.Flush()
+}
+
+// writeLine writes a formatted line without the trailing whitespace empty columns leave behind.
+func writeLine(out *bufio.Writer, format string, args ...any) {
+	out.WriteString(strings.TrimRight(fmt.Sprintf(format, args...), " "))
+	out.WriteByte('\n')
+}
+
+// WriteJSON writes the map as a JSON manifest.
+func (m *Map) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+func (m *Map) WriteFile(path string) error {
+	return writeFile(path, m.Write)
+}
+
+func (m *Map) WriteJSONFile(path string) error {
+	return writeFile(path, m.WriteJSON)
+}
+
+// ReadJSON reads a manifest written by WriteJSON.
+func ReadJSON(r io.Reader) (*Map, error) {
+	m := &Map{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	return m, nil
+}
+
+func ReadJSONFile(path string) (*Map, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadJSON(file)
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,122 @@
+package mapfile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/assembler"
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/object"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+func link(t *testing.T, script string, files map[string]string, order []string) *linker.Image {
+	t.Helper()
+	var objects []*object.Object
+	for _, name := range order {
+		source, err := assembler.AssembleSource(name, files[name])
+		if err != nil {
+			t.Fatalf("unexpected error assembling %s: %v", name, err)
+		}
+		objects = append(objects, source.Object)
+	}
+	s, err := linker.ParseScript(script)
+	if err != nil {
+		t.Fatalf("unexpected error parsing script: %v", err)
+	}
+	image, err := linker.NewLinker(objects, s).Link()
+	if err != nil {
+		t.Fatalf("unexpected error linking: %v", err)
+	}
+	return image
+}
+
+var files = map[string]string{
+	"main.asm":  ".global _start\n.extern delay\n_start:\nBL delay\nB _start\n.data\ncount: .word 3",
+	"delay.asm": ".global delay\n.type delay, %function\ndelay:\nloop: SUBS R5, R5, #1\nBPL loop\nBX lr\n.size delay, .-delay",
+}
+
+func TestMapLookup(t *testing.T) {
+	image := link(t, linker.DefaultScript, files, []string{"main.asm", "delay.asm"})
+	m := New(image, "kernel7.img", "bin", image.Data)
+
+	cases := []struct {
+		name     string
+		expected Symbol
+		found    bool
+	}{
+		{name: "_start", expected: Symbol{Name: "_start", Address: 0x8000, Section: ".text", Binding: types.BindingGlobal, File: "main.asm"}, found: true},
+		{name: "delay", expected: Symbol{Name: "delay", Address: 0x8008, Section: ".text", Size: 12, Binding: types.BindingGlobal, Type: types.SymbolFunc, File: "delay.asm"}, found: true},
+		{name: "loop", expected: Symbol{Name: "loop", Address: 0x8008, Section: ".text", File: "delay.asm"}, found: true},
+		{name: "count", expected: Symbol{Name: "count", Address: 0x8014, Section: ".data", File: "main.asm"}, found: true},
+		{name: "missing"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			symbol, found := m.Lookup(tc.name)
+			if found != tc.found {
+				t.Fatalf("expected found %v, got %v", tc.found, found)
+			}
+			if found && symbol != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, symbol)
+			}
+		})
+	}
+}
+
+func TestMapText(t *testing.T) {
+	image := link(t, "ENTRY(delay)\nSECTIONS { . = 0x10000; .text : { *(.text) } .data : { *(.data) } __end = .; }", files, []string{"main.asm", "delay.asm"})
+	m := New(image, "kernel7.img", "bin", []byte("123456789"))
+
+	expected := `Output:      kernel7.img (bin)
+Load:        0x00010000, 24 bytes
+Entry point: 0x00010008
+CRC32:       cbf43926
+SHA-256:     15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225
+
+Sections:
+Name         Start      End        Size       Region
+.text        0x00010000 0x00010014 0x00000014
+  .text      0x00010000            0x00000008 main.asm
+  .text      0x00010008            0x0000000C delay.asm
+.data        0x00010014 0x00010018 0x00000004
+  .data      0x00010014            0x00000004 main.asm
+
+Symbols:
+Address    Section      Size       Bind   Type   Name                 File
+0x00010000 .text        0x00000000 global notype _start               main.asm
+0x00010008 .text        0x0000000C global func   delay                delay.asm
+0x00010008 .text        0x00000000 local  notype loop                 delay.asm
+0x00010014 .data        0x00000000 local  notype count                main.asm
+0x00010018 *ABS*        0x00000000 global notype __end
+`
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatalf("unexpected error writing map: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("map mismatch\nexpected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestMapJSONRoundTrip(t *testing.T) {
+	image := link(t, linker.DefaultScript, files, []string{"main.asm", "delay.asm"})
+	m := New(image, "kernel7.hex", "ihex", []byte(":00000001FF\n"))
+
+	var buf bytes.Buffer
+	if err := m.WriteJSON(&buf); err != nil {
+		t.Fatalf("unexpected error writing manifest: %v", err)
+	}
+	read, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading manifest: %v", err)
+	}
+	if !reflect.DeepEqual(m, read) {
+		t.Errorf("manifest doesn't round trip\nexpected: %+v\ngot: %+v", m, read)
+	}
+	if delay, ok := read.Lookup("delay"); !ok || delay.Address != 0x8008 {
+		t.Errorf("expected delay at 0x00008008, got %+v", delay)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/object"
+)
+
+// runNM implements "rogasmic nm files...", printing the symbol table of object files or sources.
+func runNM(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no input files")
+	}
+
+	for _, input := range args {
+		var obj *object.Object
+		var err error
+		if strings.HasSuffix(input, ".o") {
+			obj, err = object.ReadFile(input)
+		} else {
+			obj, err = assembleFile(input, false)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+
+		fmt.Printf("%s:\n", input)
+		for _, symbol := range obj.Symbols {
+			if !symbol.Defined {
+				fmt.Printf("%8s %-10s %-6s %-6s %6d %s\n", "", "*UND*", symbol.Binding, symbol.Type, symbol.Size, symbol.Name)
+				continue
+			}
+			fmt.Printf("%08x %-10s %-6s %-6s %6d %s\n", symbol.Offset, symbol.Section, symbol.Binding, symbol.Type, symbol.Size, symbol.Name)
+		}
+	}
+	return nil
+}
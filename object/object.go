@@ -0,0 +1,119 @@
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Magic identifies rogasmic object files. Bump the version when the layout changes.
+const (
+	Magic   = "rogasmic-object"
+	Version = 4
+)
+
+// Object is a single assembled source file that hasn't been placed in memory yet. Section contents are final except
+// at relocation sites, which the linker patches once it knows where every symbol ended up.
+type Object struct {
+	Magic       string       `json:"magic"`
+	Version     int          `json:"version"`
+	File        string       `json:"file"` // Source file the object was assembled from
+	Sections    []Section    `json:"sections"`
+	Symbols     []Symbol     `json:"symbols"`
+	Relocations []Relocation `json:"relocations"`
+}
+
+type Section struct {
+	Name  string        `json:"name"`
+	Data  []byte        `json:"data"`
+	Thumb []types.Range `json:"thumb,omitempty"` // Offsets of the Thumb code in Data, everything else is ARM code or data
+}
+
+type Symbol struct {
+	Name    string              `json:"name"`
+	Section string              `json:"section,omitempty"` // Empty for undefined symbols
+	Offset  uint32              `json:"offset"`            // Byte offset into the section
+	Defined bool                `json:"defined"`
+	Binding types.SymbolBinding `json:"binding"`
+	Type    types.SymbolType    `json:"type"`
+	Size    uint32              `json:"size"`
+	Thumb   bool                `json:"thumb,omitempty"` // Entry point of Thumb code, its address has bit 0 set
+	Line    int                 `json:"line"`
+}
+
+// IsGlobal checks if the symbol can be referenced from or resolved by other objects.
+func (s Symbol) IsGlobal() bool {
+	return s.Binding == types.BindingGlobal || s.Binding == types.BindingWeak
+}
+
+type Relocation struct {
+	Section string               `json:"section"`
+	Offset  uint32               `json:"offset"` // Byte offset of the instruction or word to patch
+	Type    types.RelocationType `json:"type"`
+	Symbol  string               `json:"symbol"`
+	Addend  int32                `json:"addend"`
+	Line    int                  `json:"line"`
+}
+
+func New(file string) *Object {
+	return &Object{
+		Magic:   Magic,
+		Version: Version,
+		File:    file,
+	}
+}
+
+// Section returns the section with the given name, or nil if the object doesn't have one.
+func (o *Object) Section(name string) *Section {
+	for i := range o.Sections {
+		if o.Sections[i].Name == name {
+			return &o.Sections[i]
+		}
+	}
+	return nil
+}
+
+func (o *Object) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(o)
+}
+
+func Read(r io.Reader) (*Object, error) {
+	var o Object
+	if err := json.NewDecoder(r).Decode(&o); err != nil {
+		return nil, fmt.Errorf("error decoding object: %w", err)
+	}
+	if o.Magic != Magic {
+		return nil, fmt.Errorf("not a rogasmic object file")
+	}
+	if o.Version != Version {
+		return nil, fmt.Errorf("unsupported object version %d (expected %d)", o.Version, Version)
+	}
+	return &o, nil
+}
+
+func WriteFile(path string, o *Object) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return o.Write(file)
+}
+
+func ReadFile(path string) (*Object, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	o, err := Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return o, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/robertjshirts/rogasmic/format"
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/mapfile"
+)
+
+// writeImage writes a linked image in the requested output format, at the load address the linker placed it at. It
+// returns what was written so it can be checksummed.
+func writeImage(outputFile string, outputFormat string, image *linker.Image) ([]byte, error) {
+	f, err := format.ParseFormat(outputFormat)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = format.Write(&buf, f, format.Image{Address: image.Base, Data: image.Data, Entry: image.Entry, HasEntry: true})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), os.WriteFile(outputFile, buf.Bytes(), 0644)
+}
+
+// writeMaps writes the text symbol map and JSON manifest of a linked image, skipping either if its path is empty.
+func writeMaps(mapFile string, manifestFile string, image *linker.Image, outputFile string, outputFormat string, data []byte) error {
+	m := mapfile.New(image, outputFile, outputFormat, data)
+	if mapFile != "" {
+		if err := m.WriteFile(mapFile); err != nil {
+			return err
+		}
+	}
+	if manifestFile != "" {
+		if err := m.WriteJSONFile(manifestFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runConvert implements "rogasmic convert [-I format] [-O format] input output". The input format is detected from
+// the file contents unless given, and the output defaults to a flat binary.
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	inputFormat := flags.String("I", "", "input format: bin, ihex or srec (default detected from the contents)")
+	outputFormat := flags.String("O", "bin", "output format: bin, ihex or srec")
+	base := flags.Uint("base", 0x8000, "load address of a flat binary input")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: rogasmic convert [-I format] [-O format] input output")
+	}
+	inputFile, outputFile := flags.Arg(0), flags.Arg(1)
+
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	in := format.Detect(data)
+	if *inputFormat != "" {
+		if in, err = format.ParseFormat(*inputFormat); err != nil {
+			return err
+		}
+	}
+	out, err := format.ParseFormat(*outputFormat)
+	if err != nil {
+		return err
+	}
+
+	image, err := format.Read(bytes.NewReader(data), in)
+	if err != nil {
+		return fmt.Errorf("%s: %w", inputFile, err)
+	}
+	if in == format.FormatBinary {
+		image.Address = uint32(*base)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Write(&buf, out, image); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Converted %s (%s) to %s (%s): %d bytes at 0x%08X\n", inputFile, in, outputFile, out, len(image.Data), image.Address)
+	return nil
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"math/bits"
 
 	"github.com/robertjshirts/rogasmic/types"
 	"github.com/robertjshirts/rogasmic/utils"
@@ -12,12 +13,14 @@ type InstructionArithmetic struct {
 	Condition    types.ConditionType
 	DestRegister uint32
 	BaseRegister uint32
-	Immediate    uint32
+	Immediate    uint32 // The operand value, encoded as an 8 bit value rotated right by an even amount
 	SBit         uint32
+	Token        types.Token // Mnemonic token the instruction was parsed from
 }
 
 func (p *Parser) parseArithmetic() (types.Instruction, error) {
 	// Mnemonic
+	token := p.current()
 	mnemonic := types.TokenToMnemonic[p.current().Type]
 	category, ok := types.MnemonicToCategory[mnemonic]
 	if !ok || category != types.MnemonicCategoryArithmetic {
@@ -71,13 +74,17 @@ func (p *Parser) parseArithmetic() (types.Instruction, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error parsing immediate value: %w", err)
 	}
+	if _, ok := encodeImmediate(immediate); !ok && !p.thumb { // Thumb immediates are checked when they're encoded
+		return nil, fmt.Errorf("immediate value %s can't be encoded as an 8 bit value rotated by an even amount at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
 	p.consume() // consume immediate token
 
 	instruction := &InstructionArithmetic{
+		Token:        token,
 		Mnemonic:     mnemonic,
 		Condition:    condition,
-		DestRegister: baseReg,
-		BaseRegister: destReg,
+		DestRegister: destReg,
+		BaseRegister: baseReg,
 		Immediate:    immediate,
 		SBit:         sBit,
 	}
@@ -85,16 +92,79 @@ func (p *Parser) parseArithmetic() (types.Instruction, error) {
 	return instruction, nil
 }
 
+// encodeImmediate finds the 12 bit rotate and imm8 encoding of value, using the smallest rotation that fits.
+func encodeImmediate(value uint32) (uint32, bool) {
+	for rotate := uint32(0); rotate < 16; rotate++ {
+		imm8 := bits.RotateLeft32(value, int(rotate*2))
+		if imm8 <= 0xFF {
+			return rotate<<8 | imm8, true
+		}
+	}
+	return 0, false
+}
+
+// decodeImmediate expands a 12 bit rotate and imm8 encoding into its value.
+func decodeImmediate(encoded uint32) uint32 {
+	return bits.RotateLeft32(encoded&0xFF, -int(encoded>>8&0xF)*2)
+}
+
+func (i *InstructionArithmetic) SourceToken() types.Token {
+	return i.Token
+}
+
 func (i *InstructionArithmetic) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	immediate, ok := encodeImmediate(i.Immediate)
+	if !ok {
+		return nil, fmt.Errorf("immediate value 0x%X can't be encoded as an 8 bit value rotated by an even amount at line %d, col %d", i.Immediate, i.Token.Line, i.Token.Col)
+	}
+
 	var binary uint32
 	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
 	binary |= 0 << 26                                  // Always 0 for arithmetic instructions
 	binary |= 1 << 25                                  // I Bit, we always gonna use immediate values
 	binary |= types.MnemonicToBits[i.Mnemonic] << 21   // Mnemonic bits
 	binary |= i.SBit << 20                             // S Bit
-	binary |= i.DestRegister << 16                     // Destination register bits
-	binary |= i.BaseRegister << 12                     // Base register bits
-	binary |= i.Immediate & 0xFFF                      // Immediate value bits (lower 12 bits, top 4 are used for something called rotate)
+	binary |= i.BaseRegister << 16                     // Base register (Rn) bits
+	binary |= i.DestRegister << 12                     // Destination register (Rd) bits
+	binary |= immediate                                // Rotate (top 4 bits) and imm8 (bottom 8 bits)
 
 	return utils.BitsToBytes(binary), nil
 }
+
+// Decode fills in an ADD, SUB, AND or ORR with an immediate operand from a machine word.
+func (i *InstructionArithmetic) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	if word>>25&0b111 != 0b001 {
+		return fmt.Errorf("0x%08X isn't a data processing instruction with an immediate", word)
+	}
+	mnemonic, ok := arithmeticOpcodes[word>>21&0xF]
+	if !ok {
+		return fmt.Errorf("unsupported data processing opcode 0b%04b", word>>21&0xF)
+	}
+	// Only the smallest rotation is ever assembled, anything else wouldn't survive a round trip
+	immediate := decodeImmediate(word & 0xFFF)
+	if encoded, _ := encodeImmediate(immediate); encoded != word&0xFFF {
+		return fmt.Errorf("non-canonical immediate encoding 0x%03X in 0x%08X", word&0xFFF, word)
+	}
+
+	*i = InstructionArithmetic{
+		Mnemonic:     mnemonic,
+		Condition:    condition,
+		DestRegister: word >> 12 & 0xF,
+		BaseRegister: word >> 16 & 0xF,
+		Immediate:    immediate,
+		SBit:         word >> 20 & 1,
+	}
+	return nil
+}
+
+func (i *InstructionArithmetic) String() string {
+	suffix := ""
+	if i.SBit == 1 {
+		suffix = "S"
+	}
+	return fmt.Sprintf("%s %s, %s, #0x%X", mnemonic(i.Mnemonic, suffix, i.Condition), registerName(i.DestRegister), registerName(i.BaseRegister), i.Immediate)
+}
@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// Barrier options, which say which observers and which accesses a DMB or DSB orders
+const (
+	BarrierOSHST = 0x2 // Outer shareable, stores only
+	BarrierOSH   = 0x3 // Outer shareable
+	BarrierNSHST = 0x6 // Non-shareable, stores only
+	BarrierNSH   = 0x7 // Non-shareable
+	BarrierISHST = 0xA // Inner shareable, stores only, enough to publish data to the other cores
+	BarrierISH   = 0xB // Inner shareable
+	BarrierST    = 0xE // Full system, stores only
+	BarrierSY    = 0xF // Full system, the default
+)
+
+// barrierOptions are the names a barrier option can be given. SH, SHST, UN and UNST are the old names for ISH, ISHST,
+// NSH and NSHST.
+var barrierOptions = map[string]uint32{
+	"sy":    BarrierSY,
+	"st":    BarrierST,
+	"ish":   BarrierISH,
+	"ishst": BarrierISHST,
+	"nsh":   BarrierNSH,
+	"nshst": BarrierNSHST,
+	"osh":   BarrierOSH,
+	"oshst": BarrierOSHST,
+	"sh":    BarrierISH,
+	"shst":  BarrierISHST,
+	"un":    BarrierNSH,
+	"unst":  BarrierNSHST,
+}
+
+// barrierNames are the names barrier options are printed with. The reserved values are printed as immediates.
+var barrierNames = map[uint32]string{
+	BarrierSY:    "SY",
+	BarrierST:    "ST",
+	BarrierISH:   "ISH",
+	BarrierISHST: "ISHST",
+	BarrierNSH:   "NSH",
+	BarrierNSHST: "NSHST",
+	BarrierOSH:   "OSH",
+	BarrierOSHST: "OSHST",
+}
+
+type InstructionBarrier struct {
+	Mnemonic types.MnemonicType
+	Option   uint32      // Barrier option, unused by CLREX
+	Token    types.Token // Mnemonic token the instruction was parsed from
+}
+
+type InstructionHint struct {
+	Mnemonic  types.MnemonicType
+	Condition types.ConditionType
+	Token     types.Token // Mnemonic token the instruction was parsed from
+}
+
+func (p *Parser) parseBarrier() (types.Instruction, error) {
+	// Mnemonic, which can't be conditional
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryBarrier {
+		return nil, fmt.Errorf("wrong instruction type! expected barrier mnemonic, got %s", p.current().Literal)
+	}
+	if !strings.EqualFold(p.current().Literal, types.MnemonicToLiteral[mnemonic]) {
+		return nil, fmt.Errorf("%s can't have a condition or suffix", p.current().Literal)
+	}
+	p.consume() // consume barrier mnemonic token
+
+	instruction := &InstructionBarrier{Token: token, Mnemonic: mnemonic, Option: BarrierSY}
+	if mnemonic == types.MnemonicCLREX {
+		instruction.Option = 0
+		return instruction, nil
+	}
+
+	// The option is optional, and has to be on the same line
+	if p.current().Line != token.Line {
+		return instruction, nil
+	}
+	switch p.current().Type {
+	case types.TokenIdentifier:
+		option, ok := barrierOptions[strings.ToLower(p.current().Literal)]
+		if !ok {
+			return nil, fmt.Errorf("unknown barrier option %s, expected SY, ST, ISH, ISHST, NSH, NSHST, OSH or OSHST at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		if mnemonic == types.MnemonicISB && option != BarrierSY {
+			return nil, fmt.Errorf("ISB only takes SY, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		instruction.Option = option
+	case types.TokenImmediate:
+		option, err := utils.ParseImmediate(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing barrier option: %w", err)
+		}
+		if option > 0xF {
+			return nil, fmt.Errorf("barrier option 0x%X doesn't fit in 4 bits at line %d, col %d", option, p.current().Line, p.current().Col)
+		}
+		instruction.Option = option
+	default:
+		return instruction, nil
+	}
+	p.consume() // consume option token
+
+	return instruction, nil
+}
+
+func (p *Parser) parseHint() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryHint {
+		return nil, fmt.Errorf("wrong instruction type! expected hint mnemonic, got %s", p.current().Literal)
+	}
+
+	// Get condition code
+	condition, err := utils.ParseHintSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing hint suffixes: %w", err)
+	}
+	p.consume() // consume hint mnemonic token
+
+	return &InstructionHint{Token: token, Mnemonic: mnemonic, Condition: condition}, nil
+}
+
+func (i *InstructionBarrier) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionBarrier) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	binary := types.MnemonicToBits[i.Mnemonic] // Fixed bits, including the 0b1111 condition
+	if i.Mnemonic != types.MnemonicCLREX {
+		binary |= i.Option & 0xF // Option
+	}
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a DMB, DSB, ISB or CLREX from a machine word.
+func (i *InstructionBarrier) Decode(word uint32) error {
+	if word == types.MnemonicToBits[types.MnemonicCLREX] {
+		*i = InstructionBarrier{Mnemonic: types.MnemonicCLREX}
+		return nil
+	}
+	for _, m := range []types.MnemonicType{types.MnemonicDMB, types.MnemonicDSB, types.MnemonicISB} {
+		if word&^0xF == types.MnemonicToBits[m] {
+			*i = InstructionBarrier{Mnemonic: m, Option: word & 0xF}
+			return nil
+		}
+	}
+	return fmt.Errorf("0x%08X isn't a barrier", word)
+}
+
+func (i *InstructionBarrier) String() string {
+	if i.Mnemonic == types.MnemonicCLREX {
+		return types.MnemonicToLiteral[i.Mnemonic]
+	}
+	if name, ok := barrierNames[i.Option]; ok && (i.Mnemonic != types.MnemonicISB || i.Option == BarrierSY) {
+		return fmt.Sprintf("%s %s", types.MnemonicToLiteral[i.Mnemonic], name)
+	}
+	return fmt.Sprintf("%s #0x%X", types.MnemonicToLiteral[i.Mnemonic], i.Option)
+}
+
+func (i *InstructionHint) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionHint) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= types.MnemonicToBits[i.Mnemonic]         // Fixed bits and the hint number
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a NOP, YIELD, WFE, WFI or SEV from a machine word.
+func (i *InstructionHint) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	for _, m := range []types.MnemonicType{types.MnemonicNOP, types.MnemonicYIELD, types.MnemonicWFE, types.MnemonicWFI, types.MnemonicSEV} {
+		if word&0x0FFFFFFF == types.MnemonicToBits[m] {
+			*i = InstructionHint{Mnemonic: m, Condition: condition}
+			return nil
+		}
+	}
+	return fmt.Errorf("0x%08X isn't a hint", word)
+}
+
+func (i *InstructionHint) String() string {
+	return mnemonic(i.Mnemonic, "", i.Condition)
+}
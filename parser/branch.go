@@ -8,22 +8,26 @@ import (
 )
 
 type InstructionBranch struct {
-	Mnemonic      types.MnemonicType
-	Condition     types.ConditionType
-	LBit          uint32
-	Offset        uint32
-	Label         string
-	InstructionNo uint32 // Instruction number for relative addressing
+	Mnemonic  types.MnemonicType
+	Condition types.ConditionType
+	LBit      uint32
+	HBit      uint32 // BLX only, bit 1 of the Thumb target, set for targets that are only halfword aligned
+	Offset    uint32
+	Label     types.Token // Identifier token of the target label, empty literal for immediate offsets
+	Address   uint32      // Byte offset in the label map's address space, set by layoutSections
+	Token     types.Token // Mnemonic token the instruction was parsed from
 }
 
 type InstructionBranchExchange struct {
 	Mnemonic     types.MnemonicType
 	Condition    types.ConditionType
 	BaseRegister uint32
+	Token        types.Token // Mnemonic token the instruction was parsed from
 }
 
 func (p *Parser) parseBranch() (types.Instruction, error) {
 	// Mnemonic
+	token := p.current()
 	mnemonic := types.TokenToMnemonic[p.current().Type]
 	category, ok := types.MnemonicToCategory[mnemonic]
 	if !ok || category != types.MnemonicCategoryBranch {
@@ -39,8 +43,7 @@ func (p *Parser) parseBranch() (types.Instruction, error) {
 
 	// Offset/Label
 	var offset uint32
-	var label string
-	var instNo uint32
+	var label types.Token
 	if p.current().Type == types.TokenImmediate {
 		offset, err = utils.ParseImmediate(p.current().Literal)
 		if err != nil {
@@ -48,47 +51,77 @@ func (p *Parser) parseBranch() (types.Instruction, error) {
 		}
 		p.consume() // consume immediate token
 	} else if p.current().Type == types.TokenIdentifier {
-		label = p.current().Literal
-		instNo = uint32(len(p.instructions))
+		label = p.current()
 		p.consume() // consume label identifier token
 	} else {
 		return nil, fmt.Errorf("expected immediate value or label identifier after branch mnemonic, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
 	}
 
 	instruction := &InstructionBranch{
-		Mnemonic:      mnemonic,
-		Condition:     condition,
-		LBit:          lBit,
-		Offset:        offset,
-		Label:         label,
-		InstructionNo: instNo,
+		Token:     token,
+		Mnemonic:  mnemonic,
+		Condition: condition,
+		LBit:      lBit,
+		Offset:    offset,
+		Label:     label,
 	}
 
 	return instruction, nil
 }
 
+func (i *InstructionBranch) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionBranch) locate(address uint32) {
+	i.Address = address
+}
+
 func (i *InstructionBranch) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	condition, bit24 := types.ConditionToBits[i.Condition], i.LBit
+	if i.Mnemonic == types.MnemonicBLX {
+		// Unconditional, with the H bit where the L bit would be
+		condition, bit24 = 0xF, i.HBit
+	}
+
 	// Calculate offset if label is provided
-	if i.Label != "" {
-		labelAddress, ok := labels[i.Label]
+	if i.Label.Literal != "" {
+		labelAddress, ok := labels[i.Label.Literal]
 		if !ok {
-			return nil, fmt.Errorf("label %s not found", i.Label)
+			return nil, fmt.Errorf("label %s not found", i.Label.Literal)
 		}
 
-		i.Offset = labelAddress - i.InstructionNo - 2 // -2 for arm pre-fetching and processing
+		offset := labelAddress&^1 - i.Address - 8 // -8 for arm pre-fetching and processing
+		switch {
+		case labelAddress&1 == 1 && (i.LBit == 0 || i.Condition != types.ConditionAL):
+			return nil, fmt.Errorf("%s can't switch to Thumb code at %s, call it with BL or BLX or branch with BX", mnemonic(i.Mnemonic, "", i.Condition), i.Label.Literal)
+		case labelAddress&1 == 1:
+			// Thumb code is called with a BLX, which can reach halfwords with the H bit
+			condition, bit24 = 0xF, offset>>1&1
+		case offset%4 != 0:
+			return nil, fmt.Errorf("label %s isn't word aligned", i.Label.Literal)
+		case i.Mnemonic == types.MnemonicBLX:
+			condition, bit24 = types.ConditionToBits[types.ConditionAL], 1 // BLX to ARM code is a BL
+		}
+		i.Offset = offset >> 2
 	}
 
 	var binary uint32
-	binary |= types.ConditionToBits[i.Condition] << 28 // Set condition bits
-	binary |= types.MnemonicToBits[i.Mnemonic] << 25
-	binary |= i.LBit << 24        // Set L bit
+	binary |= condition << 28 // Set condition bits
+	binary |= types.MnemonicToBits[types.MnemonicB] << 25
+	binary |= bit24 << 24         // Set L or H bit
 	binary |= i.Offset & 0xFFFFFF // Set offset bits (24 bits)
 
 	return utils.BitsToBytes(binary), nil
 }
 
+func (i *InstructionBranch) Relocation() (types.Token, types.RelocationType) {
+	return i.Label, types.RelocationBranch
+}
+
 func (p *Parser) parseBranchExchange() (types.Instruction, error) {
 	// Mnemonic
+	token := p.current()
 	mnemonic := types.TokenToMnemonic[p.current().Type]
 	category, ok := types.MnemonicToCategory[mnemonic]
 	if !ok || category != types.MnemonicCategoryBranchExchange {
@@ -102,6 +135,10 @@ func (p *Parser) parseBranchExchange() (types.Instruction, error) {
 	}
 	p.consume() // consume branch exchange mnemonic token
 
+	if mnemonic == types.MnemonicBLX && p.current().Type != types.TokenRegister {
+		return p.parseBranchLinkExchange(token, condition)
+	}
+
 	// Base Register
 	if p.current().Type != types.TokenRegister {
 		return nil, fmt.Errorf("expected register after branch exchange mnemonic, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
@@ -110,9 +147,13 @@ func (p *Parser) parseBranchExchange() (types.Instruction, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error parsing base register: %w", err)
 	}
+	if mnemonic == types.MnemonicBLX && baseReg == 15 {
+		return nil, fmt.Errorf("BLX can't branch to the PC at line %d, col %d", p.current().Line, p.current().Col)
+	}
 	p.consume() // consume base register token
 
 	instruction := &InstructionBranchExchange{
+		Token:        token,
 		Mnemonic:     mnemonic,
 		Condition:    condition,
 		BaseRegister: baseReg,
@@ -121,6 +162,37 @@ func (p *Parser) parseBranchExchange() (types.Instruction, error) {
 	return instruction, nil
 }
 
+// parseBranchLinkExchange parses BLX with a label, which calls Thumb code and can't be conditional, or with an offset
+// in halfwords, whose lowest bit is the H bit.
+func (p *Parser) parseBranchLinkExchange(token types.Token, condition types.ConditionType) (types.Instruction, error) {
+	if condition != types.ConditionAL {
+		return nil, fmt.Errorf("BLX with a label or offset can't be conditional at line %d, col %d", token.Line, token.Col)
+	}
+	instruction := &InstructionBranch{Token: token, Mnemonic: types.MnemonicBLX, Condition: condition, LBit: 1}
+	switch p.current().Type {
+	case types.TokenImmediate:
+		offset, err := utils.ParseImmediate(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing immediate value: %w", err)
+		}
+		if offset > 0x1FFFFFF {
+			return nil, fmt.Errorf("BLX offset 0x%X doesn't fit in 25 bits at line %d, col %d", offset, p.current().Line, p.current().Col)
+		}
+		instruction.Offset, instruction.HBit = offset>>1, offset&1
+	case types.TokenIdentifier:
+		instruction.Label = p.current()
+	default:
+		return nil, fmt.Errorf("expected register, immediate value or label identifier after BLX, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume offset or label token
+
+	return instruction, nil
+}
+
+func (i *InstructionBranchExchange) SourceToken() types.Token {
+	return i.Token
+}
+
 func (i *InstructionBranchExchange) ToMachineCode(labels map[string]uint32) ([]byte, error) {
 	var binary uint32
 	binary |= types.ConditionToBits[i.Condition] << 28 // Set condition bits
@@ -129,3 +201,85 @@ func (i *InstructionBranchExchange) ToMachineCode(labels map[string]uint32) ([]b
 
 	return utils.BitsToBytes(binary), nil
 }
+
+// Decode fills in a B or BL from a machine word. The target is kept as a raw offset, callers that know the address
+// of the word can turn it into a label.
+func (i *InstructionBranch) Decode(word uint32) error {
+	if word>>25 == 0xF<<3|types.MnemonicToBits[types.MnemonicB] {
+		*i = InstructionBranch{
+			Mnemonic:  types.MnemonicBLX,
+			Condition: types.ConditionAL,
+			LBit:      1,
+			HBit:      word >> 24 & 1,
+			Offset:    word & 0xFFFFFF,
+		}
+		return nil
+	}
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	if word>>25&0b111 != types.MnemonicToBits[types.MnemonicB] {
+		return fmt.Errorf("0x%08X isn't a branch", word)
+	}
+	mnemonic := types.MnemonicB
+	if word>>24&1 == 1 {
+		mnemonic = types.MnemonicBL
+	}
+
+	*i = InstructionBranch{
+		Mnemonic:  mnemonic,
+		Condition: condition,
+		LBit:      word >> 24 & 1,
+		Offset:    word & 0xFFFFFF,
+	}
+	return nil
+}
+
+// Target returns the address the branch jumps to, if the branch itself is at address. For BLX that's Thumb code,
+// which Target leaves bit 0 of clear.
+func (i *InstructionBranch) Target(address uint32) uint32 {
+	offset := int32(i.Offset<<8) >> 8 // Sign extend the 24 bit word offset
+	return address + 8 + uint32(offset*4) + i.HBit*2
+}
+
+func (i *InstructionBranch) String() string {
+	if i.Label.Literal != "" {
+		return fmt.Sprintf("%s %s", mnemonic(i.Mnemonic, "", i.Condition), i.Label.Literal)
+	}
+	if i.Mnemonic == types.MnemonicBLX {
+		return fmt.Sprintf("BLX #0x%07X", i.Offset&0xFFFFFF<<1|i.HBit)
+	}
+	return fmt.Sprintf("%s #0x%06X", mnemonic(i.Mnemonic, "", i.Condition), i.Offset&0xFFFFFF)
+}
+
+// Decode fills in a BX or BLX with a register from a machine word.
+func (i *InstructionBranchExchange) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	var mnemonic types.MnemonicType
+	switch word & 0x0FFFFFF0 {
+	case types.MnemonicToBits[types.MnemonicBX] << 4:
+		mnemonic = types.MnemonicBX
+	case types.MnemonicToBits[types.MnemonicBLX] << 4:
+		if word&0xF == 15 {
+			return fmt.Errorf("BLX in 0x%08X branches to the PC", word)
+		}
+		mnemonic = types.MnemonicBLX
+	default:
+		return fmt.Errorf("0x%08X isn't a BX or BLX", word)
+	}
+
+	*i = InstructionBranchExchange{
+		Mnemonic:     mnemonic,
+		Condition:    condition,
+		BaseRegister: word & 0xF,
+	}
+	return nil
+}
+
+func (i *InstructionBranchExchange) String() string {
+	return fmt.Sprintf("%s %s", mnemonic(i.Mnemonic, "", i.Condition), registerName(i.BaseRegister))
+}
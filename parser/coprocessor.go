@@ -0,0 +1,314 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+type InstructionCoprocessor struct {
+	Mnemonic    types.MnemonicType
+	Condition   types.ConditionType
+	Coprocessor uint32 // Always 15, the system control coprocessor
+	Opcode1     uint32 // 3 bits for MCR and MRC, 4 for MCRR and MRRC
+	Register    uint32 // Rt
+	Register2   uint32 // Rt2, the high word for MCRR and MRRC
+	CRn         uint32 // Unused by MCRR and MRRC
+	CRm         uint32
+	Opcode2     uint32      // Unused by MCRR and MRRC
+	Token       types.Token // Mnemonic token the instruction was parsed from
+}
+
+// parseCoprocessor parses MCR{cond} p15, opc1, Rt, CRn, CRm{, opc2} and MCRR{cond} p15, opc1, Rt, Rt2, CRm, and
+// the same for MRC and MRRC, or the shorter MCR{cond} Rt, NAME and MCRR{cond} Rt, Rt2, NAME with a CP15 register
+// name in place of the operands that pick it.
+func (p *Parser) parseCoprocessor() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryCoprocessor {
+		return nil, fmt.Errorf("wrong instruction type! expected coprocessor mnemonic, got %s", p.current().Literal)
+	}
+
+	// Get condition code
+	condition, err := utils.ParseCoprocessorSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing coprocessor suffixes: %w", err)
+	}
+	p.consume() // consume MCR, MRC, MCRR or MRRC token
+
+	instruction := &InstructionCoprocessor{Token: token, Mnemonic: mnemonic, Condition: condition, Coprocessor: 15}
+	wide := mnemonic == types.MnemonicMCRR || mnemonic == types.MnemonicMRRC
+	if p.current().Type == types.TokenRegister {
+		// Rt{, Rt2}, NAME
+		if err := p.parseCoprocessorRegisters(instruction, wide); err != nil {
+			return nil, err
+		}
+		if err := p.expectComma("registers"); err != nil {
+			return nil, err
+		}
+		if p.current().Type != types.TokenIdentifier {
+			return nil, fmt.Errorf("expected CP15 register name, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		register, ok := CP15ByName(p.current().Literal, wide)
+		if !ok {
+			return nil, fmt.Errorf("unknown CP15 register %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		instruction.Opcode1, instruction.CRn, instruction.CRm, instruction.Opcode2 = register.Opcode1, register.CRn, register.CRm, register.Opcode2
+		p.consume() // consume register name token
+		if err := checkCP15Access(instruction); err != nil {
+			return nil, fmt.Errorf("%w at line %d, col %d", err, token.Line, token.Col)
+		}
+		return instruction, nil
+	}
+
+	// Coprocessor
+	if p.current().Type != types.TokenIdentifier {
+		return nil, fmt.Errorf("expected coprocessor after %s, got %s at line %d, col %d", token.Literal, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	coprocessor, err := parseNumbered(p.current().Literal, 'p')
+	if err != nil {
+		return nil, fmt.Errorf("%w at line %d, col %d", err, p.current().Line, p.current().Col)
+	}
+	if coprocessor != 15 {
+		return nil, fmt.Errorf("unsupported coprocessor p%d, only p15 is at line %d, col %d", coprocessor, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume coprocessor token
+	if err := p.expectComma("coprocessor"); err != nil {
+		return nil, err
+	}
+
+	// Opcode 1
+	limit := uint32(7)
+	if wide {
+		limit = 15
+	}
+	if instruction.Opcode1, err = p.parseCoprocessorOpcode(limit); err != nil {
+		return nil, err
+	}
+	if err := p.expectComma("opcode"); err != nil {
+		return nil, err
+	}
+
+	// Rt{, Rt2}
+	if err := p.parseCoprocessorRegisters(instruction, wide); err != nil {
+		return nil, err
+	}
+	if err := p.expectComma("registers"); err != nil {
+		return nil, err
+	}
+
+	// {CRn, }CRm
+	if !wide {
+		if instruction.CRn, err = p.parseCoprocessorRegister(); err != nil {
+			return nil, err
+		}
+		if err := p.expectComma("CRn"); err != nil {
+			return nil, err
+		}
+	}
+	if instruction.CRm, err = p.parseCoprocessorRegister(); err != nil {
+		return nil, err
+	}
+
+	// Opcode 2 is optional and defaults to 0
+	if !wide && p.current().Type == types.TokenComma {
+		p.consume() // consume comma token
+		if instruction.Opcode2, err = p.parseCoprocessorOpcode(7); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkCP15Access(instruction); err != nil {
+		return nil, fmt.Errorf("%w at line %d, col %d", err, token.Line, token.Col)
+	}
+	return instruction, nil
+}
+
+// parseCoprocessorRegisters parses Rt, and Rt2 after it for MCRR and MRRC. Neither can be the PC.
+func (p *Parser) parseCoprocessorRegisters(instruction *InstructionCoprocessor, wide bool) error {
+	registers := []*uint32{&instruction.Register}
+	if wide {
+		registers = append(registers, &instruction.Register2)
+	}
+	for n, register := range registers {
+		if n > 0 {
+			if err := p.expectComma("register"); err != nil {
+				return err
+			}
+		}
+		if p.current().Type != types.TokenRegister {
+			return fmt.Errorf("expected register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		reg, err := utils.ParseRegister(p.current().Literal)
+		if err != nil {
+			return fmt.Errorf("error parsing register: %w", err)
+		}
+		if reg == 15 {
+			return fmt.Errorf("coprocessor transfers can't use the PC at line %d, col %d", p.current().Line, p.current().Col)
+		}
+		*register = reg
+		p.consume() // consume register token
+	}
+	if instruction.Mnemonic == types.MnemonicMRRC && instruction.Register == instruction.Register2 {
+		return fmt.Errorf("MRRC can't load both halves into %s at line %d, col %d", registerName(instruction.Register), p.current().Line, p.current().Col)
+	}
+	return nil
+}
+
+// parseCoprocessorOpcode parses an opcode operand, a bare number or an immediate up to limit.
+func (p *Parser) parseCoprocessorOpcode(limit uint32) (uint32, error) {
+	if p.current().Type != types.TokenImmediate {
+		return 0, fmt.Errorf("expected opcode, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	opcode, err := utils.ParseImmediate(p.current().Literal)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing opcode: %w", err)
+	}
+	if opcode > limit {
+		return 0, fmt.Errorf("opcode %d is bigger than %d at line %d, col %d", opcode, limit, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume opcode token
+	return opcode, nil
+}
+
+// parseCoprocessorRegister parses one of the coprocessor's registers, c0 to c15.
+func (p *Parser) parseCoprocessorRegister() (uint32, error) {
+	if p.current().Type != types.TokenIdentifier {
+		return 0, fmt.Errorf("expected coprocessor register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	register, err := parseNumbered(p.current().Literal, 'c')
+	if err != nil {
+		return 0, fmt.Errorf("%w at line %d, col %d", err, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume coprocessor register token
+	return register, nil
+}
+
+// expectComma consumes the comma between operands.
+func (p *Parser) expectComma(after string) error {
+	if p.current().Type != types.TokenComma {
+		return fmt.Errorf("expected comma after %s, got %s at line %d, col %d", after, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume comma token
+	return nil
+}
+
+// parseNumbered parses a coprocessor, p0 to p15, or a coprocessor register, c0 to c15.
+func parseNumbered(literal string, prefix byte) (uint32, error) {
+	literal = strings.ToLower(literal)
+	if len(literal) < 2 || literal[0] != prefix {
+		return 0, fmt.Errorf("expected %c0 to %c15, got %s", prefix, prefix, literal)
+	}
+	n, err := strconv.ParseUint(literal[1:], 10, 32)
+	if err != nil || n > 15 {
+		return 0, fmt.Errorf("expected %c0 to %c15, got %s", prefix, prefix, literal)
+	}
+	return uint32(n), nil
+}
+
+// checkCP15Access checks the operands pick a CP15 register that can be transferred in the instruction's direction.
+func checkCP15Access(i *InstructionCoprocessor) error {
+	register, ok := i.SystemRegister()
+	if !ok {
+		return fmt.Errorf("unknown CP15 register %s", i.operands())
+	}
+	read := i.Mnemonic == types.MnemonicMRC || i.Mnemonic == types.MnemonicMRRC
+	if read && register.Access == CP15WriteOnly {
+		return fmt.Errorf("%s is write only", register.Name)
+	}
+	if !read && register.Access == CP15ReadOnly {
+		return fmt.Errorf("%s is read only", register.Name)
+	}
+	return nil
+}
+
+// SystemRegister returns the CP15 register the instruction transfers.
+func (i *InstructionCoprocessor) SystemRegister() (CP15Register, bool) {
+	if i.Coprocessor != 15 {
+		return CP15Register{}, false
+	}
+	if i.Mnemonic == types.MnemonicMCRR || i.Mnemonic == types.MnemonicMRRC {
+		return LookupCP15Wide(i.Opcode1, i.CRm)
+	}
+	return LookupCP15(i.Opcode1, i.CRn, i.CRm, i.Opcode2)
+}
+
+func (i *InstructionCoprocessor) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionCoprocessor) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= types.MnemonicToBits[i.Mnemonic]         // Fixed bits and direction
+	binary |= i.Register << 12                         // Rt
+	binary |= i.Coprocessor << 8                       // Coprocessor
+	binary |= i.CRm                                    // CRm
+	if i.Mnemonic == types.MnemonicMCRR || i.Mnemonic == types.MnemonicMRRC {
+		binary |= i.Register2 << 16 // Rt2
+		binary |= i.Opcode1 << 4    // Opcode 1
+		return utils.BitsToBytes(binary), nil
+	}
+	binary |= i.Opcode1 << 21 // Opcode 1
+	binary |= i.CRn << 16     // CRn
+	binary |= i.Opcode2 << 5  // Opcode 2
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in an MCR, MRC, MCRR or MRRC of a CP15 register from a machine word.
+func (i *InstructionCoprocessor) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	decoded := InstructionCoprocessor{Condition: condition, Coprocessor: word >> 8 & 0xF, Register: word >> 12 & 0xF, CRm: word & 0xF}
+	switch {
+	case word&0x0F100010 == types.MnemonicToBits[types.MnemonicMCR]:
+		decoded.Mnemonic = types.MnemonicMCR
+	case word&0x0F100010 == types.MnemonicToBits[types.MnemonicMRC]:
+		decoded.Mnemonic = types.MnemonicMRC
+	case word&0x0FF00000 == types.MnemonicToBits[types.MnemonicMCRR]:
+		decoded.Mnemonic = types.MnemonicMCRR
+	case word&0x0FF00000 == types.MnemonicToBits[types.MnemonicMRRC]:
+		decoded.Mnemonic = types.MnemonicMRRC
+	default:
+		return fmt.Errorf("0x%08X isn't a coprocessor register transfer", word)
+	}
+	if decoded.Mnemonic == types.MnemonicMCRR || decoded.Mnemonic == types.MnemonicMRRC {
+		decoded.Register2, decoded.Opcode1 = word>>16&0xF, word>>4&0xF
+	} else {
+		decoded.Opcode1, decoded.CRn, decoded.Opcode2 = word>>21&0x7, word>>16&0xF, word>>5&0x7
+	}
+
+	// Only what the parser accepts: p15, no PC and a register that goes the right way
+	if decoded.Coprocessor != 15 {
+		return fmt.Errorf("unsupported coprocessor p%d in 0x%08X", decoded.Coprocessor, word)
+	}
+	if decoded.Register == 15 || decoded.Register2 == 15 {
+		return fmt.Errorf("coprocessor transfer with the PC in 0x%08X", word)
+	}
+	if decoded.Mnemonic == types.MnemonicMRRC && decoded.Register == decoded.Register2 {
+		return fmt.Errorf("MRRC to the same register twice in 0x%08X", word)
+	}
+	if err := checkCP15Access(&decoded); err != nil {
+		return fmt.Errorf("%w in 0x%08X", err, word)
+	}
+	*i = decoded
+	return nil
+}
+
+// operands returns the operands that pick the register, as they're written after the mnemonic.
+func (i *InstructionCoprocessor) operands() string {
+	if i.Mnemonic == types.MnemonicMCRR || i.Mnemonic == types.MnemonicMRRC {
+		return fmt.Sprintf("p%d, %d, %s, %s, c%d", i.Coprocessor, i.Opcode1, registerName(i.Register), registerName(i.Register2), i.CRm)
+	}
+	return fmt.Sprintf("p%d, %d, %s, c%d, c%d, %d", i.Coprocessor, i.Opcode1, registerName(i.Register), i.CRn, i.CRm, i.Opcode2)
+}
+
+func (i *InstructionCoprocessor) String() string {
+	return mnemonic(i.Mnemonic, "", i.Condition) + " " + i.operands()
+}
@@ -0,0 +1,183 @@
+package parser
+
+import "strings"
+
+// CP15Access is which way a CP15 register can be transferred.
+type CP15Access int
+
+const (
+	CP15ReadOnly  CP15Access = iota + 1 // Identification and status registers, MRC and MRRC only
+	CP15WriteOnly                       // Operations like cache and TLB maintenance, MCR and MCRR only
+	CP15ReadWrite
+)
+
+// CP15Register is a register or operation of the system control coprocessor. Wide registers are 64 bits and moved
+// with MCRR and MRRC, which only have Opcode1 and CRm.
+type CP15Register struct {
+	Name    string
+	Opcode1 uint32
+	CRn     uint32
+	CRm     uint32
+	Opcode2 uint32
+	Access  CP15Access
+	Wide    bool
+}
+
+// CP15Registers are the CP15 registers of the Cortex-A7 in the Pi 2 that MCR and MRC can reach outside Hyp mode,
+// with their names from the ARM ARM. Anything else is rejected rather than assembled blind.
+var CP15Registers = []CP15Register{
+	// c0, identification
+	{Name: "MIDR", CRn: 0, CRm: 0, Opcode2: 0, Access: CP15ReadOnly},
+	{Name: "CTR", CRn: 0, CRm: 0, Opcode2: 1, Access: CP15ReadOnly},
+	{Name: "TCMTR", CRn: 0, CRm: 0, Opcode2: 2, Access: CP15ReadOnly},
+	{Name: "TLBTR", CRn: 0, CRm: 0, Opcode2: 3, Access: CP15ReadOnly},
+	{Name: "MPIDR", CRn: 0, CRm: 0, Opcode2: 5, Access: CP15ReadOnly},
+	{Name: "REVIDR", CRn: 0, CRm: 0, Opcode2: 6, Access: CP15ReadOnly},
+	{Name: "ID_PFR0", CRn: 0, CRm: 1, Opcode2: 0, Access: CP15ReadOnly},
+	{Name: "ID_PFR1", CRn: 0, CRm: 1, Opcode2: 1, Access: CP15ReadOnly},
+	{Name: "ID_DFR0", CRn: 0, CRm: 1, Opcode2: 2, Access: CP15ReadOnly},
+	{Name: "ID_AFR0", CRn: 0, CRm: 1, Opcode2: 3, Access: CP15ReadOnly},
+	{Name: "ID_MMFR0", CRn: 0, CRm: 1, Opcode2: 4, Access: CP15ReadOnly},
+	{Name: "ID_MMFR1", CRn: 0, CRm: 1, Opcode2: 5, Access: CP15ReadOnly},
+	{Name: "ID_MMFR2", CRn: 0, CRm: 1, Opcode2: 6, Access: CP15ReadOnly},
+	{Name: "ID_MMFR3", CRn: 0, CRm: 1, Opcode2: 7, Access: CP15ReadOnly},
+	{Name: "ID_ISAR0", CRn: 0, CRm: 2, Opcode2: 0, Access: CP15ReadOnly},
+	{Name: "ID_ISAR1", CRn: 0, CRm: 2, Opcode2: 1, Access: CP15ReadOnly},
+	{Name: "ID_ISAR2", CRn: 0, CRm: 2, Opcode2: 2, Access: CP15ReadOnly},
+	{Name: "ID_ISAR3", CRn: 0, CRm: 2, Opcode2: 3, Access: CP15ReadOnly},
+	{Name: "ID_ISAR4", CRn: 0, CRm: 2, Opcode2: 4, Access: CP15ReadOnly},
+	{Name: "ID_ISAR5", CRn: 0, CRm: 2, Opcode2: 5, Access: CP15ReadOnly},
+	{Name: "CCSIDR", Opcode1: 1, CRn: 0, CRm: 0, Opcode2: 0, Access: CP15ReadOnly},
+	{Name: "CLIDR", Opcode1: 1, CRn: 0, CRm: 0, Opcode2: 1, Access: CP15ReadOnly},
+	{Name: "AIDR", Opcode1: 1, CRn: 0, CRm: 0, Opcode2: 7, Access: CP15ReadOnly},
+	{Name: "CSSELR", Opcode1: 2, CRn: 0, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+
+	// c1, system control
+	{Name: "SCTLR", CRn: 1, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "ACTLR", CRn: 1, CRm: 0, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "CPACR", CRn: 1, CRm: 0, Opcode2: 2, Access: CP15ReadWrite},
+	{Name: "SCR", CRn: 1, CRm: 1, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "SDER", CRn: 1, CRm: 1, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "NSACR", CRn: 1, CRm: 1, Opcode2: 2, Access: CP15ReadWrite},
+
+	// c2 and c3, translation tables and domains
+	{Name: "TTBR0", CRn: 2, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "TTBR1", CRn: 2, CRm: 0, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "TTBCR", CRn: 2, CRm: 0, Opcode2: 2, Access: CP15ReadWrite},
+	{Name: "DACR", CRn: 3, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+
+	// c5 and c6, faults
+	{Name: "DFSR", CRn: 5, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "IFSR", CRn: 5, CRm: 0, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "ADFSR", CRn: 5, CRm: 1, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "AIFSR", CRn: 5, CRm: 1, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "DFAR", CRn: 6, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "IFAR", CRn: 6, CRm: 0, Opcode2: 2, Access: CP15ReadWrite},
+
+	// c7, cache maintenance, address translation and the old barrier operations
+	{Name: "ICIALLUIS", CRn: 7, CRm: 1, Opcode2: 0, Access: CP15WriteOnly},
+	{Name: "BPIALLIS", CRn: 7, CRm: 1, Opcode2: 6, Access: CP15WriteOnly},
+	{Name: "PAR", CRn: 7, CRm: 4, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "ICIALLU", CRn: 7, CRm: 5, Opcode2: 0, Access: CP15WriteOnly},
+	{Name: "ICIMVAU", CRn: 7, CRm: 5, Opcode2: 1, Access: CP15WriteOnly},
+	{Name: "CP15ISB", CRn: 7, CRm: 5, Opcode2: 4, Access: CP15WriteOnly},
+	{Name: "BPIALL", CRn: 7, CRm: 5, Opcode2: 6, Access: CP15WriteOnly},
+	{Name: "BPIMVA", CRn: 7, CRm: 5, Opcode2: 7, Access: CP15WriteOnly},
+	{Name: "DCIMVAC", CRn: 7, CRm: 6, Opcode2: 1, Access: CP15WriteOnly},
+	{Name: "DCISW", CRn: 7, CRm: 6, Opcode2: 2, Access: CP15WriteOnly},
+	{Name: "ATS1CPR", CRn: 7, CRm: 8, Opcode2: 0, Access: CP15WriteOnly},
+	{Name: "ATS1CPW", CRn: 7, CRm: 8, Opcode2: 1, Access: CP15WriteOnly},
+	{Name: "ATS1CUR", CRn: 7, CRm: 8, Opcode2: 2, Access: CP15WriteOnly},
+	{Name: "ATS1CUW", CRn: 7, CRm: 8, Opcode2: 3, Access: CP15WriteOnly},
+	{Name: "DCCMVAC", CRn: 7, CRm: 10, Opcode2: 1, Access: CP15WriteOnly},
+	{Name: "DCCSW", CRn: 7, CRm: 10, Opcode2: 2, Access: CP15WriteOnly},
+	{Name: "CP15DSB", CRn: 7, CRm: 10, Opcode2: 4, Access: CP15WriteOnly},
+	{Name: "CP15DMB", CRn: 7, CRm: 10, Opcode2: 5, Access: CP15WriteOnly},
+	{Name: "DCCMVAU", CRn: 7, CRm: 11, Opcode2: 1, Access: CP15WriteOnly},
+	{Name: "DCCIMVAC", CRn: 7, CRm: 14, Opcode2: 1, Access: CP15WriteOnly},
+	{Name: "DCCISW", CRn: 7, CRm: 14, Opcode2: 2, Access: CP15WriteOnly},
+
+	// c8, TLB maintenance
+	{Name: "TLBIALLIS", CRn: 8, CRm: 3, Opcode2: 0, Access: CP15WriteOnly},
+	{Name: "TLBIMVAIS", CRn: 8, CRm: 3, Opcode2: 1, Access: CP15WriteOnly},
+	{Name: "TLBIASIDIS", CRn: 8, CRm: 3, Opcode2: 2, Access: CP15WriteOnly},
+	{Name: "TLBIALL", CRn: 8, CRm: 7, Opcode2: 0, Access: CP15WriteOnly},
+	{Name: "TLBIMVA", CRn: 8, CRm: 7, Opcode2: 1, Access: CP15WriteOnly},
+	{Name: "TLBIASID", CRn: 8, CRm: 7, Opcode2: 2, Access: CP15WriteOnly},
+
+	// c9, performance monitors and the L2 cache
+	{Name: "PMCR", CRn: 9, CRm: 12, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "PMCNTENSET", CRn: 9, CRm: 12, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "PMCNTENCLR", CRn: 9, CRm: 12, Opcode2: 2, Access: CP15ReadWrite},
+	{Name: "PMOVSR", CRn: 9, CRm: 12, Opcode2: 3, Access: CP15ReadWrite},
+	{Name: "PMCCNTR", CRn: 9, CRm: 13, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "PMUSERENR", CRn: 9, CRm: 14, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "L2CTLR", Opcode1: 1, CRn: 9, CRm: 0, Opcode2: 2, Access: CP15ReadWrite},
+	{Name: "L2ECTLR", Opcode1: 1, CRn: 9, CRm: 0, Opcode2: 3, Access: CP15ReadWrite},
+
+	// c10, memory attributes
+	{Name: "PRRR", CRn: 10, CRm: 2, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "NMRR", CRn: 10, CRm: 2, Opcode2: 1, Access: CP15ReadWrite},
+
+	// c12, vectors and interrupt status
+	{Name: "VBAR", CRn: 12, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "MVBAR", CRn: 12, CRm: 0, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "ISR", CRn: 12, CRm: 1, Opcode2: 0, Access: CP15ReadOnly},
+
+	// c13, process and thread IDs
+	{Name: "FCSEIDR", CRn: 13, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "CONTEXTIDR", CRn: 13, CRm: 0, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "TPIDRURW", CRn: 13, CRm: 0, Opcode2: 2, Access: CP15ReadWrite},
+	{Name: "TPIDRURO", CRn: 13, CRm: 0, Opcode2: 3, Access: CP15ReadWrite},
+	{Name: "TPIDRPRW", CRn: 13, CRm: 0, Opcode2: 4, Access: CP15ReadWrite},
+
+	// c14, generic timer
+	{Name: "CNTFRQ", CRn: 14, CRm: 0, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "CNTKCTL", CRn: 14, CRm: 1, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "CNTP_TVAL", CRn: 14, CRm: 2, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "CNTP_CTL", CRn: 14, CRm: 2, Opcode2: 1, Access: CP15ReadWrite},
+	{Name: "CNTV_TVAL", CRn: 14, CRm: 3, Opcode2: 0, Access: CP15ReadWrite},
+	{Name: "CNTV_CTL", CRn: 14, CRm: 3, Opcode2: 1, Access: CP15ReadWrite},
+
+	// c15, implementation defined
+	{Name: "CBAR", Opcode1: 4, CRn: 15, CRm: 0, Opcode2: 0, Access: CP15ReadOnly},
+
+	// 64 bit registers for MCRR and MRRC
+	{Name: "TTBR0", CRm: 2, Access: CP15ReadWrite, Wide: true},
+	{Name: "TTBR1", Opcode1: 1, CRm: 2, Access: CP15ReadWrite, Wide: true},
+	{Name: "PAR", CRm: 7, Access: CP15ReadWrite, Wide: true},
+	{Name: "CNTPCT", CRm: 14, Access: CP15ReadOnly, Wide: true},
+	{Name: "CNTVCT", Opcode1: 1, CRm: 14, Access: CP15ReadOnly, Wide: true},
+	{Name: "CNTP_CVAL", Opcode1: 2, CRm: 14, Access: CP15ReadWrite, Wide: true},
+	{Name: "CNTV_CVAL", Opcode1: 3, CRm: 14, Access: CP15ReadWrite, Wide: true},
+}
+
+// LookupCP15 finds the CP15 register MCR and MRC reach with the given operands.
+func LookupCP15(opcode1, crn, crm, opcode2 uint32) (CP15Register, bool) {
+	for _, r := range CP15Registers {
+		if !r.Wide && r.Opcode1 == opcode1 && r.CRn == crn && r.CRm == crm && r.Opcode2 == opcode2 {
+			return r, true
+		}
+	}
+	return CP15Register{}, false
+}
+
+// LookupCP15Wide finds the 64 bit CP15 register MCRR and MRRC reach with the given operands.
+func LookupCP15Wide(opcode1, crm uint32) (CP15Register, bool) {
+	for _, r := range CP15Registers {
+		if r.Wide && r.Opcode1 == opcode1 && r.CRm == crm {
+			return r, true
+		}
+	}
+	return CP15Register{}, false
+}
+
+// CP15ByName finds a CP15 register by name, ignoring case. TTBR0, TTBR1 and PAR have both a 32 and a 64 bit form.
+func CP15ByName(name string, wide bool) (CP15Register, bool) {
+	for _, r := range CP15Registers {
+		if r.Wide == wide && strings.EqualFold(r.Name, name) {
+			return r, true
+		}
+	}
+	return CP15Register{}, false
+}
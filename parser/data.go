@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// InstructionWord isn't really an instruction, it places a 32 bit value (or the address of a label) in the output.
+type InstructionWord struct {
+	Value uint32
+	Label types.Token // Set when the word holds the address of a label
+	Token types.Token // The .word directive token
+}
+
+// parseWord parses a single .word value, either an immediate or a label.
+func (p *Parser) parseWord(directive types.Token) (types.Instruction, error) {
+	switch p.current().Type {
+	case types.TokenImmediate:
+		value, err := utils.ParseImmediate(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing .word value: %w", err)
+		}
+		p.consume() // consume immediate token
+		return &InstructionWord{Value: value, Token: directive}, nil
+	case types.TokenIdentifier:
+		label := p.current()
+		p.consume() // consume identifier token
+		return &InstructionWord{Label: label, Token: directive}, nil
+	default:
+		return nil, fmt.Errorf("expected immediate value or label after .word, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+}
+
+func (i *InstructionWord) SourceToken() types.Token {
+	return i.Token
+}
+
+// ToMachineCode for words. Label addresses aren't known until link time, so they're left as 0 here.
+func (i *InstructionWord) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	return utils.BitsToBytes(i.Value), nil
+}
+
+func (i *InstructionWord) Relocation() (types.Token, types.RelocationType) {
+	return i.Label, types.RelocationAbs32
+}
+
+// Decode for words can't fail, any value can be placed with .word.
+func (i *InstructionWord) Decode(word uint32) error {
+	*i = InstructionWord{Value: word}
+	return nil
+}
+
+func (i *InstructionWord) String() string {
+	if i.Label.Literal != "" {
+		return ".word " + i.Label.Literal
+	}
+	return fmt.Sprintf(".word 0x%08X", i.Value)
+}
+
+// InstructionHalfword places a 16 bit value in the output, which is how the disassembler writes out Thumb code.
+type InstructionHalfword struct {
+	Value uint32
+	Token types.Token // The .hword directive token
+}
+
+// parseHalfword parses a single .hword value. Addresses don't fit in 16 bits, so unlike .word it only takes numbers.
+func (p *Parser) parseHalfword(directive types.Token) (types.Instruction, error) {
+	if p.current().Type != types.TokenImmediate {
+		return nil, fmt.Errorf("expected immediate value after .hword, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	value, err := utils.ParseImmediate(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing .hword value: %w", err)
+	}
+	if value > 0xFFFF {
+		return nil, fmt.Errorf(".hword value %s at line %d, col %d doesn't fit in 16 bits", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume immediate token
+	return &InstructionHalfword{Value: value, Token: directive}, nil
+}
+
+func (i *InstructionHalfword) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionHalfword) Size() uint32 {
+	return 2
+}
+
+func (i *InstructionHalfword) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	return utils.ThumbToBytes(i.Value, 2), nil
+}
+
+// Decode for halfwords takes any value that fits in 16 bits.
+func (i *InstructionHalfword) Decode(word uint32) error {
+	if word > 0xFFFF {
+		return fmt.Errorf("0x%08X doesn't fit in a halfword", word)
+	}
+	*i = InstructionHalfword{Value: word}
+	return nil
+}
+
+func (i *InstructionHalfword) String() string {
+	return fmt.Sprintf(".hword 0x%04X", i.Value)
+}
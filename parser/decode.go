@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Decode builds an instruction from a machine word. Only encodings the assembler can produce are decoded, so the
+// String() of the result always assembles back into the same word.
+func Decode(word uint32) (types.Instruction, error) {
+	candidates := []types.Instruction{
+		&InstructionBranchExchange{},
+		&InstructionBranch{},
+		&InstructionMOV{},
+		&InstructionArithmetic{},
+		&InstructionMemory{},
+		&InstructionMemoryMultiple{},
+		&InstructionSupervisorCall{},
+		&InstructionStatus{},
+		&InstructionChangeState{},
+		&InstructionCoprocessor{},
+		&InstructionBarrier{},
+		&InstructionHint{},
+		&InstructionExclusive{},
+		&InstructionMedia{},
+		&InstructionFloat{},
+		&InstructionFloatTransfer{},
+		&InstructionFloatSystem{},
+		&InstructionFloatMemory{},
+		&InstructionVector{},
+		&InstructionVectorMemory{},
+	}
+	for _, instruction := range candidates {
+		if instruction.Decode(word) == nil {
+			return instruction, nil
+		}
+	}
+	return nil, fmt.Errorf("0x%08X isn't an instruction rogasmic can assemble", word)
+}
+
+// literalConditions maps conditions back to their suffix. Always is the default, so it's left off.
+var literalConditions = func() map[types.ConditionType]string {
+	out := make(map[types.ConditionType]string)
+	for condition, literal := range types.ConditionToLiteral {
+		out[condition] = strings.ToUpper(literal)
+	}
+	out[types.ConditionAL] = ""
+	return out
+}()
+
+// decodeCondition returns the condition field of a word, as long as the assembler supports it.
+func decodeCondition(word uint32) (types.ConditionType, error) {
+	condition := types.ConditionType(word >> 28)
+	if _, ok := literalConditions[condition]; !ok {
+		return 0, fmt.Errorf("unsupported condition 0b%04b", word>>28)
+	}
+	return condition, nil
+}
+
+// mnemonicsByBits maps the opcode bits of a category back to its mnemonics.
+func mnemonicsByBits(category types.MnemonicCategory) map[uint32]types.MnemonicType {
+	out := make(map[uint32]types.MnemonicType)
+	for mnemonic, c := range types.MnemonicToCategory {
+		if c == category {
+			out[types.MnemonicToBits[mnemonic]] = mnemonic
+		}
+	}
+	return out
+}
+
+var (
+	arithmeticOpcodes = mnemonicsByBits(types.MnemonicCategoryArithmetic)
+	loadStoreBits     = mnemonicsByBits(types.MnemonicCategoryLoadStore)
+	loadStoreMultiple = mnemonicsByBits(types.MnemonicCategoryLoadStoreMultiple)
+	mediaBits         = mnemonicsByBits(types.MnemonicCategoryMedia)
+)
+
+// mnemonic joins a mnemonic, its suffixes and condition the way the parser expects them.
+func mnemonic(mnemonic types.MnemonicType, suffix string, condition types.ConditionType) string {
+	return types.MnemonicToLiteral[mnemonic] + suffix + literalConditions[condition]
+}
+
+func registerName(r uint32) string {
+	switch r {
+	case 13:
+		return "SP"
+	case 14:
+		return "LR"
+	case 15:
+		return "PC"
+	}
+	return fmt.Sprintf("R%d", r)
+}
+
+// registerList prints a LDM/STM register mask, collapsing runs of three or more registers into ranges.
+func registerList(mask uint32) string {
+	var parts []string
+	for r := uint32(0); r < 16; {
+		if mask&(1<<r) == 0 {
+			r++
+			continue
+		}
+		end := r
+		for end+1 < 16 && mask&(1<<(end+1)) != 0 {
+			end++
+		}
+		if end-r >= 2 {
+			parts = append(parts, registerName(r)+"-"+registerName(end))
+		} else {
+			for reg := r; reg <= end; reg++ {
+				parts = append(parts, registerName(reg))
+			}
+		}
+		r = end + 1
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
@@ -0,0 +1,395 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+func (p *Parser) parseDirective() error {
+	directive := p.current()
+	p.consume() // consume directive token
+
+	switch strings.ToLower(directive.Literal) {
+	case ".text", ".data", ".section", ".thumb", ".arm", ".code", ".word", ".hword":
+		// Everything in an IT block has to be a Thumb instruction in the same section
+		if err := p.endITBlock(); err != nil {
+			return err
+		}
+	}
+
+	switch strings.ToLower(directive.Literal) {
+	case ".text", ".data":
+		p.switchSection(strings.ToLower(directive.Literal))
+	case ".section":
+		if p.current().Type != types.TokenDirective && p.current().Type != types.TokenIdentifier {
+			return fmt.Errorf("expected section name after .section, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		p.switchSection(p.current().Literal)
+		p.consume() // consume section name token
+	case ".thumb":
+		p.thumb = true
+	case ".arm":
+		p.thumb = false
+	case ".code":
+		switch p.current().Literal {
+		case "16":
+			p.thumb = true
+		case "32":
+			p.thumb = false
+		default:
+			return fmt.Errorf("expected 16 or 32 after .code, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		p.consume() // consume width token
+	case ".syntax":
+		// Only unified syntax is supported, where ARM and Thumb code are written the same way
+		if !strings.EqualFold(p.current().Literal, "unified") {
+			return fmt.Errorf("expected unified after .syntax, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		p.consume() // consume syntax token
+	case ".global", ".globl":
+		return p.parseSymbolList(func(symbol *types.Symbol, token types.Token) error {
+			return setBinding(symbol, types.BindingGlobal, token)
+		})
+	case ".weak":
+		return p.parseSymbolList(func(symbol *types.Symbol, token types.Token) error {
+			return setBinding(symbol, types.BindingWeak, token)
+		})
+	case ".extern":
+		return p.parseSymbolList(func(symbol *types.Symbol, token types.Token) error {
+			return setBinding(symbol, types.BindingExtern, token)
+		})
+	case ".type":
+		return p.parseType()
+	case ".size":
+		return p.parseSize()
+	case ".thumb_func":
+		p.thumbFunc = directive
+	case ".word":
+		if !p.thumb {
+			p.alignARM(directive)
+		}
+		for {
+			instruction, err := p.parseWord(directive)
+			if err != nil {
+				return err
+			}
+			p.instructions = append(p.instructions, instruction)
+			if p.current().Type != types.TokenComma {
+				break
+			}
+			p.consume() // consume comma token
+		}
+	case ".hword":
+		for {
+			instruction, err := p.parseHalfword(directive)
+			if err != nil {
+				return err
+			}
+			p.instructions = append(p.instructions, instruction)
+			p.unaligned[p.section] = true
+			if p.current().Type != types.TokenComma {
+				break
+			}
+			p.consume() // consume comma token
+		}
+	default:
+		return fmt.Errorf("unknown directive %s", directive.Literal)
+	}
+
+	return nil
+}
+
+// parseSymbolList parses a comma separated list of symbol names, calling apply on each one.
+func (p *Parser) parseSymbolList(apply func(symbol *types.Symbol, token types.Token) error) error {
+	for {
+		if p.current().Type != types.TokenIdentifier {
+			return fmt.Errorf("expected symbol name, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		if err := apply(p.symbol(p.current()), p.current()); err != nil {
+			return err
+		}
+		p.consume() // consume identifier token
+
+		if p.current().Type != types.TokenComma {
+			return nil
+		}
+		p.consume() // consume comma token
+	}
+}
+
+// setBinding applies a binding directive to a symbol. .extern doesn't override .global or .weak (they already make
+// the symbol visible to other files), but .global and .weak contradict each other.
+func setBinding(symbol *types.Symbol, binding types.SymbolBinding, token types.Token) error {
+	switch {
+	case symbol.Binding == types.BindingLocal || symbol.Binding == types.BindingExtern:
+		symbol.Binding = binding
+	case binding == types.BindingExtern || binding == symbol.Binding:
+		// Nothing to change
+	default:
+		return fmt.Errorf("symbol %s at line %d, col %d is already %s", token.Literal, token.Line, token.Col, symbol.Binding)
+	}
+	return nil
+}
+
+// parseType parses .type name, %function or .type name, %object
+func (p *Parser) parseType() error {
+	if p.current().Type != types.TokenIdentifier {
+		return fmt.Errorf("expected symbol name after .type, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	symbol := p.symbol(p.current())
+	p.consume() // consume symbol name token
+
+	if p.current().Type != types.TokenComma {
+		return fmt.Errorf("expected comma after symbol name, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume comma token
+
+	switch strings.ToLower(p.current().Literal) {
+	case "function":
+		symbol.Type = types.SymbolFunc
+	case "object":
+		symbol.Type = types.SymbolObject
+	default:
+		return fmt.Errorf("expected %%function or %%object, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume type token
+
+	return nil
+}
+
+// pendingSize is a .size name, .-label directive. Thumb instructions aren't all the same size, so the distance from
+// the label isn't known until layoutSections has run.
+type pendingSize struct {
+	symbol *types.Symbol
+	start  *types.Symbol // The label
+	end    uint32        // Index within the section of the instruction after the directive
+}
+
+// parseSize parses .size name, #bytes or .size name, .-label where .-label is the distance from a label in the
+// current section to here.
+func (p *Parser) parseSize() error {
+	if p.current().Type != types.TokenIdentifier {
+		return fmt.Errorf("expected symbol name after .size, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	symbol := p.symbol(p.current())
+	p.consume() // consume symbol name token
+
+	if p.current().Type != types.TokenComma {
+		return fmt.Errorf("expected comma after symbol name, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume comma token
+
+	if p.current().Type == types.TokenImmediate {
+		size, err := utils.ParseImmediate(p.current().Literal)
+		if err != nil {
+			return fmt.Errorf("error parsing size: %w", err)
+		}
+		symbol.Size = size
+		p.consume() // consume immediate token
+		return nil
+	}
+
+	if p.current().Type != types.TokenDirective || p.current().Literal != "." || p.peek().Type != types.TokenDash {
+		return fmt.Errorf("expected size or .-label, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume '.' token
+	p.consume() // consume '-' token
+
+	if p.current().Type != types.TokenIdentifier {
+		return fmt.Errorf("expected label after .-, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	start, ok := p.symbols[p.current().Literal]
+	if !ok || !start.Defined || start.Section != p.section {
+		return fmt.Errorf("label %s at line %d, col %d must be defined earlier in the same section", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.sizes = append(p.sizes, pendingSize{symbol: symbol, start: start, end: uint32(len(p.instructions))})
+	p.consume() // consume label token
+
+	return nil
+}
+
+// checkSymbols reports symbols that were declared in contradicting ways, and a .thumb_func with no label after it.
+// Only valid after layoutSections.
+func (p *Parser) checkSymbols() error {
+	if p.thumbFunc.Literal != "" {
+		return fmt.Errorf(".thumb_func at line %d, col %d isn't followed by a label", p.thumbFunc.Line, p.thumbFunc.Col)
+	}
+	for _, name := range p.symbolOrder {
+		symbol := p.symbols[name]
+		switch {
+		case symbol.Defined && symbol.Binding == types.BindingExtern:
+			return fmt.Errorf("symbol %s declared .extern but defined at line %d", name, symbol.Token.Line)
+		case !symbol.Defined && symbol.Binding == types.BindingLocal:
+			return fmt.Errorf("symbol %s at line %d is given a type or size but never defined", name, symbol.Token.Line)
+		}
+	}
+	return nil
+}
+
+// symbol returns the symbol for a name, creating an undefined one if it hasn't been seen yet.
+func (p *Parser) symbol(token types.Token) *types.Symbol {
+	symbol, ok := p.symbols[token.Literal]
+	if !ok {
+		symbol = &types.Symbol{Name: token.Literal, Token: token}
+		p.symbols[token.Literal] = symbol
+		p.symbolOrder = append(p.symbolOrder, token.Literal)
+	}
+	return symbol
+}
+
+// defineLabel points a label at the next instruction in the current section. Labels in ARM code after Thumb code
+// point past the padding that puts the ARM code back on a word boundary.
+func (p *Parser) defineLabel(token types.Token) error {
+	symbol := p.symbol(token)
+	if symbol.Defined {
+		return fmt.Errorf("label %s at line %d, col %d already defined at line %d", token.Literal, token.Line, token.Col, symbol.Token.Line)
+	}
+	if !p.thumb {
+		p.alignARM(token)
+	}
+	symbol.Defined = true
+	symbol.Section = p.section
+	symbol.Index = uint32(len(p.instructions)) // Index within the section until layoutSections runs
+	symbol.Token = token
+	p.thumbLabels[token.Literal] = p.thumb
+	if p.thumbFunc.Literal != "" {
+		symbol.Thumb, symbol.Type = true, types.SymbolFunc
+		p.thumbFunc = types.Token{}
+	}
+	return nil
+}
+
+func (p *Parser) switchSection(name string) {
+	if name == p.section {
+		return
+	}
+	p.sections[p.section] = p.instructions
+	if _, ok := p.sections[name]; !ok {
+		p.sectionOrder = append(p.sectionOrder, name)
+	}
+	p.section = name
+	p.instructions = p.sections[name]
+}
+
+// layoutSections flattens the sections into one instruction list, one section after another, and works out the byte
+// offset of every instruction and label. Thumb branches start out 16 bits wide and are widened if their label turns
+// out to be out of reach, which moves everything after them, so the offsets are worked out again until no more
+// branches need widening.
+func (p *Parser) layoutSections() {
+	p.sections[p.section] = p.instructions
+
+	labelled := make(map[string]bool)
+	for _, symbol := range p.symbols {
+		if symbol.Defined {
+			labelled[symbol.Section] = true
+		}
+	}
+
+	var flat []types.Instruction
+	bases := make(map[string]uint32)
+	p.layout = nil
+	for _, name := range p.sectionOrder {
+		instructions := p.sections[name]
+		if p.unaligned[name] {
+			// Sections end on a word boundary, so their offsets in the object match the ones worked out here
+			instructions = append(instructions, &InstructionAlign{Token: instructions[len(instructions)-1].SourceToken()})
+		}
+		base := uint32(len(flat))
+		bases[name] = base
+		flat = append(flat, instructions...)
+		if len(instructions) > 0 || labelled[name] {
+			p.layout = append(p.layout, types.Section{Name: name, Start: base, End: uint32(len(flat))})
+		}
+	}
+	p.instructions = flat
+
+	for _, name := range p.symbolOrder {
+		symbol := p.symbols[name]
+		if symbol.Defined {
+			symbol.Index += bases[symbol.Section]
+		}
+	}
+	for n := range p.sizes {
+		p.sizes[n].end += bases[p.sizes[n].start.Section]
+	}
+
+	sections := make(map[string]*types.Section)
+	for n := range p.layout {
+		sections[p.layout[n].Name] = &p.layout[n]
+	}
+	offsets := make([]uint32, len(flat))
+	ends := make(map[string]uint32)
+	offsetOf := func(section string, index uint32) uint32 {
+		if index == sections[section].End {
+			return ends[section]
+		}
+		return offsets[index]
+	}
+
+	for {
+		offset := uint32(0)
+		for n := range p.layout {
+			section := &p.layout[n]
+			section.Offset = offset
+			for i := section.Start; i < section.End; i++ {
+				if instruction, ok := flat[i].(located); ok {
+					instruction.locate(offset)
+				}
+				offsets[i] = offset
+				offset += types.Size(flat[i])
+			}
+			ends[section.Name] = offset
+		}
+		if !p.widenBranches(func(symbol *types.Symbol) uint32 { return offsetOf(symbol.Section, symbol.Index) }) {
+			break
+		}
+	}
+
+	for _, name := range p.symbolOrder {
+		symbol := p.symbols[name]
+		if !symbol.Defined {
+			continue
+		}
+		offset := offsetOf(symbol.Section, symbol.Index)
+		symbol.Offset = offset - sections[symbol.Section].Offset
+		if symbol.Type == types.SymbolFunc && p.thumbLabels[name] {
+			symbol.Thumb = true
+		}
+		if symbol.Thumb || p.thumbLabels[name] {
+			offset |= 1
+		}
+		p.labels[name] = offset
+	}
+	for _, size := range p.sizes {
+		size.symbol.Size = offsetOf(size.start.Section, size.end) - offsetOf(size.start.Section, size.start.Index)
+	}
+}
+
+// widenBranches makes the 16 bit Thumb branches that can't reach their label 32 bits wide, and reports whether there
+// were any. Branches to labels in other sections, and to weak labels, are always widened since the linker patches
+// them.
+func (p *Parser) widenBranches(offsetOf func(symbol *types.Symbol) uint32) bool {
+	widened := false
+	for _, section := range p.layout {
+		for i := section.Start; i < section.End; i++ {
+			thumb, ok := p.instructions[i].(*InstructionThumb)
+			if !ok || thumb.size != 2 || thumb.Width == 2 {
+				continue
+			}
+			branch, ok := thumb.Instruction.(*InstructionBranch)
+			if !ok {
+				continue
+			}
+			symbol, ok := p.symbols[branch.Label.Literal]
+			local := ok && symbol.Defined && symbol.Section == section.Name && symbol.Binding != types.BindingWeak
+			if !local || !thumb.reaches(int64(offsetOf(symbol))-int64(thumb.Address)-4) {
+				thumb.size = 4
+				widened = true
+			}
+		}
+	}
+	return widened
+}
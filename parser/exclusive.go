@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+type InstructionExclusive struct {
+	Mnemonic       types.MnemonicType
+	Condition      types.ConditionType
+	StatusRegister uint32      // Rd of STREX, set to 0 if the store happened and 1 if it didn't
+	DestRegister   uint32      // Rt, loaded by LDREX and stored by STREX
+	BaseRegister   uint32      // Rn, the address
+	Token          types.Token // Mnemonic token the instruction was parsed from
+}
+
+func (p *Parser) parseExclusive() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryExclusive {
+		return nil, fmt.Errorf("wrong instruction type! expected LDREX or STREX, got %s", p.current().Literal)
+	}
+
+	// Get condition code
+	condition, err := utils.ParseExclusiveSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing exclusive suffixes: %w", err)
+	}
+	p.consume() // consume LDREX or STREX token
+
+	instruction := &InstructionExclusive{Token: token, Mnemonic: mnemonic, Condition: condition}
+	if mnemonic == types.MnemonicSTREX {
+		// Status register
+		if instruction.StatusRegister, err = p.parseExclusiveRegister("status"); err != nil {
+			return nil, err
+		}
+		if err := p.expectComma("status register"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Transfer register
+	if instruction.DestRegister, err = p.parseExclusiveRegister("transfer"); err != nil {
+		return nil, err
+	}
+	if err := p.expectComma("transfer register"); err != nil {
+		return nil, err
+	}
+
+	// Base register, with an optional offset that has to be 0
+	if p.current().Type != types.TokenLBracket {
+		return nil, fmt.Errorf("expected '[' for base register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume LBracket token
+	if instruction.BaseRegister, err = p.parseExclusiveRegister("base"); err != nil {
+		return nil, err
+	}
+	if p.current().Type == types.TokenComma {
+		p.consume() // consume comma token
+		if p.current().Type != types.TokenImmediate {
+			return nil, fmt.Errorf("expected immediate offset after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		offset, err := utils.ParseImmediate(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing immediate offset: %w", err)
+		}
+		if offset != 0 {
+			return nil, fmt.Errorf("%s has no offset, got %s at line %d, col %d", types.MnemonicToLiteral[mnemonic], p.current().Literal, p.current().Line, p.current().Col)
+		}
+		p.consume() // consume offset token
+	}
+	if p.current().Type != types.TokenRBracket {
+		return nil, fmt.Errorf("expected ']' after base register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume RBracket token
+
+	if err := checkExclusiveRegisters(instruction); err != nil {
+		return nil, fmt.Errorf("%w at line %d, col %d", err, token.Line, token.Col)
+	}
+
+	return instruction, nil
+}
+
+// parseExclusiveRegister parses one of the registers of an LDREX or STREX, none of which can be the PC.
+func (p *Parser) parseExclusiveRegister(role string) (uint32, error) {
+	if p.current().Type != types.TokenRegister {
+		return 0, fmt.Errorf("expected %s register, got %s at line %d, col %d", role, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	reg, err := utils.ParseRegister(p.current().Literal)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %s register: %w", role, err)
+	}
+	if reg == 15 {
+		return 0, fmt.Errorf("%s register can't be the PC at line %d, col %d", role, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume register token
+	return reg, nil
+}
+
+// checkExclusiveRegisters rejects a STREX whose status register is also the value or the address, which is
+// unpredictable: the status write could land before the store reads them.
+func checkExclusiveRegisters(i *InstructionExclusive) error {
+	if i.Mnemonic != types.MnemonicSTREX {
+		return nil
+	}
+	switch i.StatusRegister {
+	case i.DestRegister:
+		return fmt.Errorf("STREX status register %s can't also be the transfer register", registerName(i.StatusRegister))
+	case i.BaseRegister:
+		return fmt.Errorf("STREX status register %s can't also be the base register", registerName(i.StatusRegister))
+	}
+	return nil
+}
+
+func (i *InstructionExclusive) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionExclusive) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= types.MnemonicToBits[i.Mnemonic]         // Fixed bits
+	binary |= i.BaseRegister << 16                     // Rn
+	if i.Mnemonic == types.MnemonicLDREX {
+		binary |= i.DestRegister << 12 // Rt
+		return utils.BitsToBytes(binary), nil
+	}
+	binary |= i.StatusRegister << 12 // Rd
+	binary |= i.DestRegister         // Rt
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in an LDREX or STREX from a machine word.
+func (i *InstructionExclusive) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	var decoded InstructionExclusive
+	switch {
+	case word&0x0FF00FFF == types.MnemonicToBits[types.MnemonicLDREX]:
+		decoded = InstructionExclusive{Mnemonic: types.MnemonicLDREX, Condition: condition, DestRegister: word >> 12 & 0xF, BaseRegister: word >> 16 & 0xF}
+	case word&0x0FF00FF0 == types.MnemonicToBits[types.MnemonicSTREX]:
+		decoded = InstructionExclusive{Mnemonic: types.MnemonicSTREX, Condition: condition, StatusRegister: word >> 12 & 0xF, DestRegister: word & 0xF, BaseRegister: word >> 16 & 0xF}
+	default:
+		return fmt.Errorf("0x%08X isn't an LDREX or STREX", word)
+	}
+	if decoded.StatusRegister == 15 || decoded.DestRegister == 15 || decoded.BaseRegister == 15 {
+		return fmt.Errorf("%s in 0x%08X uses the PC", types.MnemonicToLiteral[decoded.Mnemonic], word)
+	}
+	if err := checkExclusiveRegisters(&decoded); err != nil {
+		return err
+	}
+	*i = decoded
+	return nil
+}
+
+func (i *InstructionExclusive) String() string {
+	if i.Mnemonic == types.MnemonicLDREX {
+		return fmt.Sprintf("%s %s, [%s]", mnemonic(i.Mnemonic, "", i.Condition), registerName(i.DestRegister), registerName(i.BaseRegister))
+	}
+	return fmt.Sprintf("%s %s, %s, [%s]", mnemonic(i.Mnemonic, "", i.Condition), registerName(i.StatusRegister), registerName(i.DestRegister), registerName(i.BaseRegister))
+}
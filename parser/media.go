@@ -0,0 +1,270 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+type InstructionMedia struct {
+	Mnemonic        types.MnemonicType
+	Condition       types.ConditionType
+	DestRegister    uint32
+	BaseRegister    uint32      // Rn, the source of the bit field instructions and the dividend of SDIV and UDIV
+	OperandRegister uint32      // Rm, the source of CLZ, REV, REV16 and the extends, and the divisor of SDIV and UDIV
+	Rotation        uint32      // Extends only, how far right Rm is rotated first: 0, 8, 16 or 24
+	LSB             uint32      // Bit field only, the lowest bit of the field
+	Width           uint32      // Bit field only, the number of bits in the field
+	Token           types.Token // Mnemonic token the instruction was parsed from
+}
+
+func (p *Parser) parseMedia() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryMedia {
+		return nil, fmt.Errorf("wrong instruction type! expected media mnemonic, got %s", p.current().Literal)
+	}
+
+	// Get condition code
+	condition, err := utils.ParseMediaSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing media suffixes: %w", err)
+	}
+	p.consume() // consume media mnemonic token
+
+	instruction := &InstructionMedia{Token: token, Mnemonic: mnemonic, Condition: condition}
+
+	// Destination register
+	if instruction.DestRegister, err = p.parseRegisterNotPC("destination"); err != nil {
+		return nil, err
+	}
+	if err := p.expectComma("destination register"); err != nil {
+		return nil, err
+	}
+
+	switch mnemonic {
+	case types.MnemonicUBFX, types.MnemonicSBFX, types.MnemonicBFI:
+		if instruction.BaseRegister, err = p.parseRegisterNotPC("source"); err != nil {
+			return nil, err
+		}
+		if instruction.LSB, instruction.Width, err = p.parseBitField(); err != nil {
+			return nil, err
+		}
+	case types.MnemonicSDIV, types.MnemonicUDIV:
+		if instruction.BaseRegister, err = p.parseRegisterNotPC("dividend"); err != nil {
+			return nil, err
+		}
+		if err := p.expectComma("dividend register"); err != nil {
+			return nil, err
+		}
+		if instruction.OperandRegister, err = p.parseRegisterNotPC("divisor"); err != nil {
+			return nil, err
+		}
+	default:
+		if instruction.OperandRegister, err = p.parseRegisterNotPC("source"); err != nil {
+			return nil, err
+		}
+		if isExtend(mnemonic) && p.current().Type == types.TokenComma {
+			p.consume() // consume comma token
+			if instruction.Rotation, err = p.parseRotation(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return instruction, nil
+}
+
+// parseRegisterNotPC parses an operand register that can't be the PC, like the registers of a media instruction.
+func (p *Parser) parseRegisterNotPC(role string) (uint32, error) {
+	if p.current().Type != types.TokenRegister {
+		return 0, fmt.Errorf("expected %s register, got %s at line %d, col %d", role, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	reg, err := utils.ParseRegister(p.current().Literal)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %s register: %w", role, err)
+	}
+	if reg == 15 {
+		return 0, fmt.Errorf("%s register can't be the PC at line %d, col %d", role, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume register token
+	return reg, nil
+}
+
+// parseBitField parses the , #lsb, #width at the end of UBFX, SBFX and BFI. The field has to fit in the register.
+func (p *Parser) parseBitField() (uint32, uint32, error) {
+	var values [2]uint32
+	var tokens [2]types.Token
+	for n, role := range []string{"lsb", "width"} {
+		if p.current().Type != types.TokenComma {
+			return 0, 0, fmt.Errorf("expected comma before %s, got %s at line %d, col %d", role, p.current().Literal, p.current().Line, p.current().Col)
+		}
+		p.consume() // consume comma token
+		if p.current().Type != types.TokenImmediate {
+			return 0, 0, fmt.Errorf("expected immediate %s, got %s at line %d, col %d", role, p.current().Literal, p.current().Line, p.current().Col)
+		}
+		value, err := utils.ParseImmediate(p.current().Literal)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error parsing %s: %w", role, err)
+		}
+		values[n], tokens[n] = value, p.current()
+		p.consume() // consume immediate token
+	}
+	lsb, width := values[0], values[1]
+	token := tokens[1]
+	if lsb > 31 {
+		token = tokens[0]
+	}
+	if lsb > 31 || width < 1 || width > 32-lsb {
+		return 0, 0, fmt.Errorf("bit field of %d bits from bit %d doesn't fit in a register at line %d, col %d", width, lsb, token.Line, token.Col)
+	}
+	return lsb, width, nil
+}
+
+// parseRotation parses the ROR #n at the end of an extend, where n is 8, 16 or 24.
+func (p *Parser) parseRotation() (uint32, error) {
+	if p.current().Type != types.TokenIdentifier || !strings.EqualFold(p.current().Literal, "ROR") {
+		return 0, fmt.Errorf("expected ROR after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume ROR token
+	if p.current().Type != types.TokenImmediate {
+		return 0, fmt.Errorf("expected immediate rotation after ROR, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	rotation, err := utils.ParseImmediate(p.current().Literal)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing rotation: %w", err)
+	}
+	if rotation != 0 && rotation != 8 && rotation != 16 && rotation != 24 {
+		return 0, fmt.Errorf("rotation has to be 0, 8, 16 or 24, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume rotation token
+	return rotation, nil
+}
+
+// isExtend reports whether mnemonic is UXTB, UXTH, SXTB or SXTH.
+func isExtend(mnemonic types.MnemonicType) bool {
+	switch mnemonic {
+	case types.MnemonicUXTB, types.MnemonicUXTH, types.MnemonicSXTB, types.MnemonicSXTH:
+		return true
+	}
+	return false
+}
+
+// mediaOperandMask has the bits of a media instruction's encoding that hold its registers and fields.
+func mediaOperandMask(mnemonic types.MnemonicType) uint32 {
+	switch mnemonic {
+	case types.MnemonicUBFX, types.MnemonicSBFX, types.MnemonicBFI:
+		return 0x001FFF8F
+	case types.MnemonicSDIV, types.MnemonicUDIV:
+		return 0x000F0F0F
+	}
+	if isExtend(mnemonic) {
+		return 0x0000FC0F
+	}
+	return 0x0000F00F
+}
+
+func (i *InstructionMedia) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionMedia) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= types.MnemonicToBits[i.Mnemonic]         // Fixed bits
+	switch i.Mnemonic {
+	case types.MnemonicUBFX, types.MnemonicSBFX:
+		binary |= (i.Width - 1) << 16 // widthm1
+		binary |= i.DestRegister << 12
+		binary |= i.LSB << 7
+		binary |= i.BaseRegister
+	case types.MnemonicBFI:
+		binary |= (i.LSB + i.Width - 1) << 16 // msb
+		binary |= i.DestRegister << 12
+		binary |= i.LSB << 7
+		binary |= i.BaseRegister
+	case types.MnemonicSDIV, types.MnemonicUDIV:
+		binary |= i.DestRegister << 16
+		binary |= i.OperandRegister << 8
+		binary |= i.BaseRegister
+	default:
+		binary |= i.DestRegister << 12
+		binary |= i.Rotation / 8 << 10 // Always 0 for CLZ, REV and REV16
+		binary |= i.OperandRegister
+	}
+
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a CLZ, REV, REV16, extend, bit field or divide instruction from a machine word.
+func (i *InstructionMedia) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	found := false
+	var decoded InstructionMedia
+	for bits, mnemonic := range mediaBits {
+		if word&0x0FFFFFFF&^mediaOperandMask(mnemonic) == bits {
+			decoded = InstructionMedia{Mnemonic: mnemonic, Condition: condition}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("0x%08X isn't a media instruction", word)
+	}
+
+	registers := []uint32{word >> 12 & 0xF, word & 0xF}
+	switch decoded.Mnemonic {
+	case types.MnemonicUBFX, types.MnemonicSBFX, types.MnemonicBFI:
+		decoded.DestRegister, decoded.BaseRegister = word>>12&0xF, word&0xF
+		decoded.LSB = word >> 7 & 0x1F
+		decoded.Width = (word >> 16 & 0x1F) + 1
+		if decoded.Mnemonic == types.MnemonicBFI {
+			// msb is stored instead of the width, and an msb below the lsb is unpredictable
+			if word>>16&0x1F < decoded.LSB {
+				return fmt.Errorf("BFI in 0x%08X has its msb below its lsb", word)
+			}
+			decoded.Width = (word >> 16 & 0x1F) - decoded.LSB + 1
+		}
+		if decoded.LSB+decoded.Width > 32 {
+			return fmt.Errorf("%s in 0x%08X has a bit field past bit 31", types.MnemonicToLiteral[decoded.Mnemonic], word)
+		}
+	case types.MnemonicSDIV, types.MnemonicUDIV:
+		decoded.DestRegister, decoded.OperandRegister, decoded.BaseRegister = word>>16&0xF, word>>8&0xF, word&0xF
+		registers = []uint32{decoded.DestRegister, decoded.OperandRegister, decoded.BaseRegister}
+	default:
+		decoded.DestRegister, decoded.OperandRegister = word>>12&0xF, word&0xF
+		if isExtend(decoded.Mnemonic) {
+			decoded.Rotation = (word >> 10 & 0b11) * 8
+		}
+	}
+	for _, r := range registers {
+		if r == 15 {
+			// Includes BFI with the PC as its source, which is BFC
+			return fmt.Errorf("%s in 0x%08X uses the PC", types.MnemonicToLiteral[decoded.Mnemonic], word)
+		}
+	}
+
+	*i = decoded
+	return nil
+}
+
+func (i *InstructionMedia) String() string {
+	name := mnemonic(i.Mnemonic, "", i.Condition)
+	switch i.Mnemonic {
+	case types.MnemonicUBFX, types.MnemonicSBFX, types.MnemonicBFI:
+		return fmt.Sprintf("%s %s, %s, #%d, #%d", name, registerName(i.DestRegister), registerName(i.BaseRegister), i.LSB, i.Width)
+	case types.MnemonicSDIV, types.MnemonicUDIV:
+		return fmt.Sprintf("%s %s, %s, %s", name, registerName(i.DestRegister), registerName(i.BaseRegister), registerName(i.OperandRegister))
+	}
+	if i.Rotation != 0 {
+		return fmt.Sprintf("%s %s, %s, ROR #%d", name, registerName(i.DestRegister), registerName(i.OperandRegister), i.Rotation)
+	}
+	return fmt.Sprintf("%s %s, %s", name, registerName(i.DestRegister), registerName(i.OperandRegister))
+}
@@ -17,11 +17,13 @@ type InstructionMemory struct {
 	UBit         uint32
 	BBit         uint32
 	WBit         uint32
-	Offset       uint32 // Offset for memory instructions, not used in this case
+	Offset       uint32      // Offset for memory instructions, not used in this case
+	Token        types.Token // Mnemonic token the instruction was parsed from
 }
 
 func (p *Parser) parseMemory() (types.Instruction, error) {
 	// Mnemonic
+	token := p.current()
 	mnemonic := types.TokenToMnemonic[p.current().Type]
 	category, ok := types.MnemonicToCategory[mnemonic]
 	if !ok || category != types.MnemonicCategoryLoadStore {
@@ -69,32 +71,47 @@ func (p *Parser) parseMemory() (types.Instruction, error) {
 		return nil, fmt.Errorf("error parsing base register: %w", err)
 	}
 	p.consume() // consume base register token
+
+	// [Rn, #imm] is pre indexed with the offset added
+	bracketed := p.current().Type == types.TokenComma
+	if bracketed {
+		pBit, uBit = 1, 1
+		p.consume() // consume comma token
+		if offset, err = p.parseMemoryOffset(); err != nil {
+			return nil, err
+		}
+	}
 	if p.current().Type != types.TokenRBracket {
-		return nil, fmt.Errorf("expected ']' after base register, got %s at line %d, col %d", p.peek().Literal, p.peek().Line, p.peek().Col)
+		return nil, fmt.Errorf("expected ']' after base register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
 	}
 	p.consume() // consume RBracket token
 
-	if p.current().Type == types.TokenBang {
+	bang := p.current()
+	if bang.Type == types.TokenBang {
 		// If we have a '!' after the base register, it means we have a writeback
 		wBit = 1
 		p.consume() // consume '!' token
 	}
 
-	if p.current().Type == types.TokenComma {
-		// If we have a comma after the base register, it means we have an immediate offset
-		p.consume() // consume comma token
-		if p.current().Type != types.TokenImmediate {
-			return nil, fmt.Errorf("expected immediate value after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
-		}
-		offset, err = utils.ParseImmediate(p.current().Literal)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing immediate offset: %w", err)
+	if !bracketed {
+		if p.current().Type == types.TokenComma {
+			// [Rn], #imm takes its indexing from the mnemonic: LDR subtracts the offset before the transfer and STR
+			// adds it after
+			p.consume() // consume comma token
+			if offset, err = p.parseMemoryOffset(); err != nil {
+				return nil, err
+			}
+			if pBit == 0 && wBit == 1 {
+				// W set on a post indexed transfer would make it the user mode STRT
+				return nil, fmt.Errorf("post indexed %s always writes back, '!' isn't allowed at line %d, col %d", token.Literal, bang.Line, bang.Col)
+			}
+		} else {
+			pBit, uBit = 1, 1 // A plain [Rn] is the same as [Rn, #0]
 		}
-		iBit = 0 // Immediate offset
-		p.consume()
 	}
 
 	instruction := &InstructionMemory{
+		Token:        token,
 		Mnemonic:     mnemonic,
 		Condition:    condition,
 		DestRegister: destReg,
@@ -110,6 +127,26 @@ func (p *Parser) parseMemory() (types.Instruction, error) {
 	return instruction, nil
 }
 
+// parseMemoryOffset parses the immediate offset of a LDR or STR, which has to fit in 12 bits.
+func (p *Parser) parseMemoryOffset() (uint32, error) {
+	if p.current().Type != types.TokenImmediate {
+		return 0, fmt.Errorf("expected immediate value after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	offset, err := utils.ParseImmediate(p.current().Literal)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing immediate offset: %w", err)
+	}
+	if offset > 0xFFF {
+		return 0, fmt.Errorf("offset 0x%X doesn't fit in 12 bits at line %d, col %d", offset, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume immediate token
+	return offset, nil
+}
+
+func (i *InstructionMemory) SourceToken() types.Token {
+	return i.Token
+}
+
 func (i *InstructionMemory) ToMachineCode(labels map[string]uint32) ([]byte, error) {
 	var binary uint32
 	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
@@ -122,7 +159,7 @@ func (i *InstructionMemory) ToMachineCode(labels map[string]uint32) ([]byte, err
 	binary |= types.MnemonicToBits[i.Mnemonic] << 20   // Opcode bit (1 bit)
 	binary |= i.BaseRegister << 16                     // Base register
 	binary |= i.DestRegister << 12                     // Destination register
-	binary |= 0 << 11                                  // Offset
+	binary |= i.Offset & 0xFFF                         // Offset
 
 	return utils.BitsToBytes(binary), nil
 }
@@ -137,10 +174,13 @@ type InstructionMemoryMultiple struct {
 	UBit         uint32
 	BBit         uint32
 	WBit         uint32
-	Offset       uint32 // Offset for memory multiple instructions
+	SBit         uint32      // Set by ^: user bank registers, or restoring the CPSR for LDM with the PC in the list
+	Offset       uint32      // Offset for memory multiple instructions
+	Token        types.Token // Mnemonic token the instruction was parsed from
 }
 
 func (p *Parser) parseMemoryMultiple() (types.Instruction, error) {
+	token := p.current()
 	mnemonic := types.TokenToMnemonic[p.current().Type]
 	category, ok := types.MnemonicToCategory[mnemonic]
 	if !ok || category != types.MnemonicCategoryLoadStoreMultiple {
@@ -234,26 +274,52 @@ func (p *Parser) parseMemoryMultiple() (types.Instruction, error) {
 		mask |= 1 << r
 	}
 
+	// User bank or exception return
+	sBit := uint32(0)
+	if p.current().Type == types.TokenCaret {
+		if err := checkUserBank(mnemonic, wBit, mask); err != nil {
+			return nil, fmt.Errorf("%w at line %d, col %d", err, p.current().Line, p.current().Col)
+		}
+		sBit = 1
+		p.consume() // consume '^' token
+	}
+
 	instruction := &InstructionMemoryMultiple{
+		Token:        token,
 		Mnemonic:     mnemonic,
 		Condition:    condition,
 		BaseRegister: baseReg,
 		PBit:         pBit,
 		UBit:         uBit,
 		WBit:         wBit,
+		SBit:         sBit,
 		Offset:       mask,
 	}
 
 	return instruction, nil
 }
 
+// checkUserBank rejects the ^ forms the architecture leaves unpredictable: writeback is only allowed when LDM is
+// returning from an exception, since the base would be written back to the wrong bank.
+func checkUserBank(mnemonic types.MnemonicType, wBit uint32, mask uint32) error {
+	if wBit == 1 && (mnemonic == types.MnemonicSTM || mask&(1<<15) == 0) {
+		return fmt.Errorf("writeback can't be used with a user bank %s", types.MnemonicToLiteral[mnemonic])
+	}
+	return nil
+}
+
 // ToMachineCode for memory multiple instructions
+func (i *InstructionMemoryMultiple) SourceToken() types.Token {
+	return i.Token
+}
+
 func (i *InstructionMemoryMultiple) ToMachineCode(labels map[string]uint32) ([]byte, error) {
 	var binary uint32
 	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
 	binary |= 1 << 27                                  // Block data transfer
 	binary |= i.PBit << 24                             // P bit, pre or post index
 	binary |= i.UBit << 23                             // U bit, add or subtract offset
+	binary |= i.SBit << 22                             // S bit, user bank or exception return
 	binary |= i.WBit << 21                             // W bit, write back
 	binary |= types.MnemonicToBits[i.Mnemonic] << 20   // L bit (1 for LDM, 0 for STM)
 	binary |= i.BaseRegister << 16                     // Base register
@@ -261,3 +327,105 @@ func (i *InstructionMemoryMultiple) ToMachineCode(labels map[string]uint32) ([]b
 
 	return utils.BitsToBytes(binary), nil
 }
+
+// Decode fills in a LDR or STR from a machine word. Only the forms the parser produces are accepted: pre indexed with
+// the offset added, which covers a bare [Rn], or the [Rn], #imm forms where LDR is pre indexed with the offset
+// subtracted and STR is post indexed with the offset added. Post indexed with W set is STRT, which isn't supported.
+func (i *InstructionMemory) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	if word>>26&0b11 != 0b01 {
+		return fmt.Errorf("0x%08X isn't a single data transfer", word)
+	}
+	iBit, pBit, uBit, bBit, wBit := word>>25&1, word>>24&1, word>>23&1, word>>22&1, word>>21&1
+	if iBit != 0 || bBit != 0 {
+		return fmt.Errorf("register offsets and byte transfers aren't supported")
+	}
+	mnemonic := loadStoreBits[word>>20&1]
+
+	added := pBit == 1 && uBit == 1
+	load := mnemonic == types.MnemonicLDR && pBit == 1 && uBit == 0
+	store := mnemonic == types.MnemonicSTR && pBit == 0 && uBit == 1 && wBit == 0
+	if !added && !load && !store {
+		return fmt.Errorf("unsupported %s addressing mode (P=%d, U=%d, W=%d)", types.MnemonicToLiteral[mnemonic], pBit, uBit, wBit)
+	}
+
+	*i = InstructionMemory{
+		Mnemonic:     mnemonic,
+		Condition:    condition,
+		DestRegister: word >> 12 & 0xF,
+		BaseRegister: word >> 16 & 0xF,
+		PBit:         pBit,
+		UBit:         uBit,
+		WBit:         wBit,
+		Offset:       word & 0xFFF,
+	}
+	return nil
+}
+
+func (i *InstructionMemory) String() string {
+	writeback := ""
+	if i.WBit == 1 {
+		writeback = "!"
+	}
+	operands := fmt.Sprintf("%s, [%s]%s, #0x%X", registerName(i.DestRegister), registerName(i.BaseRegister), writeback, i.Offset)
+	switch {
+	case i.PBit == 1 && i.UBit == 1 && i.Offset == 0:
+		operands = fmt.Sprintf("%s, [%s]%s", registerName(i.DestRegister), registerName(i.BaseRegister), writeback)
+	case i.PBit == 1 && i.UBit == 1:
+		operands = fmt.Sprintf("%s, [%s, #0x%X]%s", registerName(i.DestRegister), registerName(i.BaseRegister), i.Offset, writeback)
+	}
+	return fmt.Sprintf("%s %s", mnemonic(i.Mnemonic, "", i.Condition), operands)
+}
+
+// Decode fills in a LDM or STM from a machine word. The parser only produces the EA stack forms, LDMEA (P=1, U=0)
+// and STMEA (P=0, U=1), with or without ^.
+func (i *InstructionMemoryMultiple) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	if word>>25&0b111 != 0b100 {
+		return fmt.Errorf("0x%08X isn't a block data transfer", word)
+	}
+	pBit, uBit, sBit := word>>24&1, word>>23&1, word>>22&1
+	mnemonic := loadStoreMultiple[word>>20&1]
+	if (mnemonic == types.MnemonicLDM && (pBit != 1 || uBit != 0)) || (mnemonic == types.MnemonicSTM && (pBit != 0 || uBit != 1)) {
+		return fmt.Errorf("unsupported %s addressing mode (P=%d, U=%d)", types.MnemonicToLiteral[mnemonic], pBit, uBit)
+	}
+	if sBit == 1 {
+		if err := checkUserBank(mnemonic, word>>21&1, word&0xFFFF); err != nil {
+			return err
+		}
+	}
+
+	*i = InstructionMemoryMultiple{
+		Mnemonic:     mnemonic,
+		Condition:    condition,
+		BaseRegister: word >> 16 & 0xF,
+		PBit:         pBit,
+		UBit:         uBit,
+		WBit:         word >> 21 & 1,
+		SBit:         sBit,
+		Offset:       word & 0xFFFF,
+	}
+	return nil
+}
+
+func (i *InstructionMemoryMultiple) String() string {
+	suffix := ""
+	if i.Condition == types.ConditionAL {
+		suffix = "EA" // The parser takes EA or a condition, not both
+	}
+	writeback := ""
+	if i.WBit == 1 {
+		writeback = "!"
+	}
+	userBank := ""
+	if i.SBit == 1 {
+		userBank = "^"
+	}
+	return fmt.Sprintf("%s %s%s, %s%s", mnemonic(i.Mnemonic, suffix, i.Condition), registerName(i.BaseRegister), writeback, registerList(i.Offset), userBank)
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/robertjshirts/rogasmic/types"
 	"github.com/robertjshirts/rogasmic/utils"
@@ -12,10 +13,13 @@ type InstructionMOV struct {
 	Condition    types.ConditionType
 	DestRegister uint32
 	Immediate    uint32
+	Label        types.Token // Set for #:lower16:label and #:upper16:label operands
+	Token        types.Token // Mnemonic token the instruction was parsed from
 }
 
 func (p *Parser) parseMOV() (types.Instruction, error) {
 	// Mnemonic
+	token := p.current()
 	mnemonic := types.TokenToMnemonic[p.current().Type]
 	category, ok := types.MnemonicToCategory[mnemonic]
 	if !ok || category != types.MnemonicCategoryMOV {
@@ -45,6 +49,21 @@ func (p *Parser) parseMOV() (types.Instruction, error) {
 	}
 	p.consume() // consume comma token
 
+	// Label address half
+	if p.current().Type == types.TokenRelocation {
+		label, err := p.parseMOVLabel(mnemonic)
+		if err != nil {
+			return nil, err
+		}
+		return &InstructionMOV{
+			Token:        token,
+			Mnemonic:     mnemonic,
+			Condition:    condition,
+			DestRegister: reg,
+			Label:        label,
+		}, nil
+	}
+
 	// Immediate
 	if p.current().Type != types.TokenImmediate {
 		return nil, fmt.Errorf("expected immediate value after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
@@ -56,6 +75,7 @@ func (p *Parser) parseMOV() (types.Instruction, error) {
 	p.consume() // consume immediate token
 
 	instruction := &InstructionMOV{
+		Token:        token,
 		Mnemonic:     mnemonic,
 		Condition:    condition,
 		Immediate:    immediate,
@@ -65,6 +85,10 @@ func (p *Parser) parseMOV() (types.Instruction, error) {
 	return instruction, nil
 }
 
+func (i *InstructionMOV) SourceToken() types.Token {
+	return i.Token
+}
+
 func (i *InstructionMOV) ToMachineCode(labels map[string]uint32) ([]byte, error) {
 	var binary uint32
 	binary |= types.ConditionToBits[i.Condition] << 28
@@ -75,3 +99,64 @@ func (i *InstructionMOV) ToMachineCode(labels map[string]uint32) ([]byte, error)
 
 	return utils.BitsToBytes(binary), nil
 }
+
+// parseMOVLabel parses the :lower16:label or :upper16:label operand. MOVW only takes the bottom half of an address
+// and MOVT only takes the top half.
+func (p *Parser) parseMOVLabel(mnemonic types.MnemonicType) (types.Token, error) {
+	specifier := strings.ToLower(p.current().Literal)
+	if (mnemonic == types.MnemonicMOVW && specifier != "lower16") || (mnemonic == types.MnemonicMOVT && specifier != "upper16") {
+		return types.Token{}, fmt.Errorf("invalid relocation specifier :%s: for this MOV at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume relocation specifier token
+
+	if p.current().Type != types.TokenIdentifier {
+		return types.Token{}, fmt.Errorf("expected label after :%s:, got %s at line %d, col %d", specifier, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	label := p.current()
+	p.consume() // consume label token
+
+	return label, nil
+}
+
+func (i *InstructionMOV) Relocation() (types.Token, types.RelocationType) {
+	if i.Mnemonic == types.MnemonicMOVT {
+		return i.Label, types.RelocationMOVT
+	}
+	return i.Label, types.RelocationMOVW
+}
+
+// Decode fills in a MOVW or MOVT from a machine word.
+func (i *InstructionMOV) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	var mnemonic types.MnemonicType
+	switch word >> 20 & 0xFF {
+	case types.MnemonicToBits[types.MnemonicMOVW]:
+		mnemonic = types.MnemonicMOVW
+	case types.MnemonicToBits[types.MnemonicMOVT]:
+		mnemonic = types.MnemonicMOVT
+	default:
+		return fmt.Errorf("0x%08X isn't a MOVW or MOVT", word)
+	}
+
+	*i = InstructionMOV{
+		Mnemonic:     mnemonic,
+		Condition:    condition,
+		DestRegister: word >> 12 & 0xF,
+		Immediate:    word>>4&0xF000 | word&0xFFF,
+	}
+	return nil
+}
+
+func (i *InstructionMOV) String() string {
+	if i.Label.Literal != "" {
+		specifier := "lower16"
+		if i.Mnemonic == types.MnemonicMOVT {
+			specifier = "upper16"
+		}
+		return fmt.Sprintf("%s %s, #:%s:%s", mnemonic(i.Mnemonic, "", i.Condition), registerName(i.DestRegister), specifier, i.Label.Literal)
+	}
+	return fmt.Sprintf("%s %s, #0x%04X", mnemonic(i.Mnemonic, "", i.Condition), registerName(i.DestRegister), i.Immediate)
+}
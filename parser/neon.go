@@ -0,0 +1,325 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// InstructionVector is NEON VADD or VSUB, which add or subtract each lane of two D or Q registers. Like every NEON
+// instruction it can't be conditional.
+type InstructionVector struct {
+	Mnemonic       types.MnemonicType
+	DataType       types.DataType // I8, I16, I32 or I64, the size of a lane
+	Quad           bool           // Q registers rather than D registers
+	DestRegister   uint32
+	FirstRegister  uint32
+	SecondRegister uint32
+	Token          types.Token // Mnemonic token the instruction was parsed from
+}
+
+// InstructionVectorMemory is VLD1 or VST1 of one to four consecutive D registers, elements of DataType's size at a
+// time.
+type InstructionVectorMemory struct {
+	Mnemonic      types.MnemonicType
+	DataType      types.DataType // Only the size matters: 8, 16, 32 or 64
+	FirstRegister uint32         // First D register of the list
+	Count         uint32         // 1-4
+	BaseRegister  uint32
+	IndexRegister uint32      // Rm, 15 for no writeback, 13 to add the size of the list to the base register
+	Token         types.Token // Mnemonic token the instruction was parsed from
+}
+
+// vectorBits are the NEON integer VADD and VSUB, whose mnemonics are shared with the VFP instructions.
+var vectorBits = map[types.MnemonicType]uint32{
+	types.MnemonicVADD: 0xF2000800,
+	types.MnemonicVSUB: 0xF3000800,
+}
+
+// vectorListTypes is the type field of VLD1 and VST1, going by the number of registers in the list.
+var vectorListTypes = map[uint32]uint32{1: 0x7, 2: 0xA, 3: 0x6, 4: 0x2}
+
+// vectorSizes maps the size of an element or lane to the bits that encode it.
+var vectorSizes = map[uint32]uint32{8: 0, 16: 1, 32: 2, 64: 3}
+
+// parseVector parses the rest of VADD.I<size> or VSUB.I<size> once parseFloat has seen the integer data type.
+func (p *Parser) parseVector(token types.Token, mnemonic types.MnemonicType, condition types.ConditionType, dataType types.DataType) (types.Instruction, error) {
+	if condition != types.ConditionAL {
+		return nil, fmt.Errorf("NEON instructions can't be conditional at line %d, col %d", token.Line, token.Col)
+	}
+	instruction := &InstructionVector{Token: token, Mnemonic: mnemonic, DataType: dataType}
+
+	// The first register picks D or Q for all three
+	if p.current().Type != types.TokenFPRegister {
+		return nil, fmt.Errorf("expected D or Q register after %s, got %s at line %d, col %d", token.Literal, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	kind, _, err := utils.ParseFPRegister(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing destination register: %w", err)
+	}
+	if kind == 'S' {
+		return nil, fmt.Errorf("NEON instructions use D or Q registers, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	instruction.Quad = kind == 'Q'
+
+	registers := []*uint32{&instruction.DestRegister, &instruction.FirstRegister, &instruction.SecondRegister}
+	for n, role := range []string{"destination", "first operand", "second operand"} {
+		if n > 0 {
+			if err := p.expectComma(role + " register"); err != nil {
+				return nil, err
+			}
+		}
+		if *registers[n], err = p.parseFPRegister(kind, role); err != nil {
+			return nil, err
+		}
+	}
+
+	return instruction, nil
+}
+
+// parseVectorMemory parses VLD1.<size> and VST1.<size> {list}, [Rn] with an optional ! or Rm after the brackets.
+func (p *Parser) parseVectorMemory() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryVector {
+		return nil, fmt.Errorf("wrong instruction type! expected VLD1 or VST1, got %s", p.current().Literal)
+	}
+	if len(token.Literal) != 4 {
+		return nil, fmt.Errorf("NEON instructions can't be conditional at line %d, col %d", token.Line, token.Col)
+	}
+	p.consume() // consume VLD1 or VST1 token
+	dataTypes, err := p.parseDataTypes()
+	if err != nil {
+		return nil, err
+	}
+	if len(dataTypes) != 1 {
+		return nil, fmt.Errorf("%s needs one data type, such as .32, at line %d, col %d", types.MnemonicToLiteral[mnemonic], token.Line, token.Col)
+	}
+	instruction := &InstructionVectorMemory{Token: token, Mnemonic: mnemonic, DataType: types.DataType{Size: dataTypes[0].Size}}
+
+	// Register list
+	if p.current().Type != types.TokenLBrace {
+		return nil, fmt.Errorf("expected '{' for register list, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume '{' token
+	var regs []uint32
+	for p.current().Type != types.TokenRBrace {
+		start, err := p.parseFPRegister('D', "list")
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if p.current().Type == types.TokenDash {
+			p.consume() // consume '-' token
+			if end, err = p.parseFPRegister('D', "list"); err != nil {
+				return nil, err
+			}
+		}
+		for r := start; r <= end; r++ {
+			regs = append(regs, r)
+		}
+		if p.current().Type == types.TokenComma {
+			p.consume() // consume ',' token
+		} else if p.current().Type != types.TokenRBrace {
+			return nil, fmt.Errorf("expected ',' or '}' in register list, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+	}
+	p.consume() // consume '}' token
+	if len(regs) < 1 || len(regs) > 4 {
+		return nil, fmt.Errorf("%s takes 1 to 4 registers, got %d at line %d, col %d", types.MnemonicToLiteral[mnemonic], len(regs), token.Line, token.Col)
+	}
+	for n, r := range regs {
+		if r != regs[0]+uint32(n) {
+			return nil, fmt.Errorf("%s registers have to be consecutive at line %d, col %d", types.MnemonicToLiteral[mnemonic], token.Line, token.Col)
+		}
+	}
+	instruction.FirstRegister, instruction.Count = regs[0], uint32(len(regs))
+	if err := p.expectComma("register list"); err != nil {
+		return nil, err
+	}
+
+	// [Rn]
+	if p.current().Type != types.TokenLBracket {
+		return nil, fmt.Errorf("expected '[' for base register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume LBracket token
+	if instruction.BaseRegister, err = p.parseRegisterNotPC("base"); err != nil {
+		return nil, err
+	}
+	if p.current().Type != types.TokenRBracket {
+		return nil, fmt.Errorf("expected ']' after base register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume RBracket token
+
+	// Writeback
+	instruction.IndexRegister = 15
+	switch p.current().Type {
+	case types.TokenBang:
+		instruction.IndexRegister = 13
+		p.consume() // consume '!' token
+	case types.TokenComma:
+		p.consume() // consume comma token
+		if instruction.IndexRegister, err = p.parseRegisterNotPC("index"); err != nil {
+			return nil, err
+		}
+		if instruction.IndexRegister == 13 {
+			return nil, fmt.Errorf("index register can't be SP, use ! to add the size of the list at line %d, col %d", token.Line, token.Col)
+		}
+	}
+
+	return instruction, nil
+}
+
+func vectorRegisterName(r uint32, quad bool) string {
+	if quad {
+		return fmt.Sprintf("Q%d", r)
+	}
+	return fmt.Sprintf("D%d", r)
+}
+
+func (i *InstructionVector) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionVector) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	// Q registers are pairs of D registers, Qn is D2n and D2n+1
+	scale := uint32(1)
+	if i.Quad {
+		scale = 2
+	}
+	var binary uint32
+	binary |= vectorBits[i.Mnemonic]             // Fixed bits
+	binary |= vectorSizes[i.DataType.Size] << 20 // Lane size
+	binary |= fpD(i.DestRegister*scale, true)
+	binary |= fpN(i.FirstRegister*scale, true)
+	binary |= fpM(i.SecondRegister*scale, true)
+	if i.Quad {
+		binary |= 1 << 6
+	}
+
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a NEON integer VADD or VSUB from a machine word.
+func (i *InstructionVector) Decode(word uint32) error {
+	found := false
+	var decoded InstructionVector
+	for mnemonic, bits := range vectorBits {
+		if word&0xFF800F10 == bits {
+			decoded = InstructionVector{Mnemonic: mnemonic, DataType: types.DataType{Kind: 'I', Size: 8 << (word >> 20 & 0b11)}}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("0x%08X isn't a NEON VADD or VSUB", word)
+	}
+
+	decoded.Quad = word>>6&1 == 1
+	registers := []uint32{
+		fpRegister(word>>12&0xF, word>>22&1, true),
+		fpRegister(word>>16&0xF, word>>7&1, true),
+		fpRegister(word&0xF, word>>5&1, true),
+	}
+	if decoded.Quad {
+		for n, r := range registers {
+			if r%2 != 0 {
+				return fmt.Errorf("%s in 0x%08X uses half a Q register", types.MnemonicToLiteral[decoded.Mnemonic], word)
+			}
+			registers[n] = r / 2
+		}
+	}
+	decoded.DestRegister, decoded.FirstRegister, decoded.SecondRegister = registers[0], registers[1], registers[2]
+
+	*i = decoded
+	return nil
+}
+
+func (i *InstructionVector) String() string {
+	return fmt.Sprintf("%s%s %s, %s, %s", types.MnemonicToLiteral[i.Mnemonic], i.DataType, vectorRegisterName(i.DestRegister, i.Quad),
+		vectorRegisterName(i.FirstRegister, i.Quad), vectorRegisterName(i.SecondRegister, i.Quad))
+}
+
+func (i *InstructionVectorMemory) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionVectorMemory) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.MnemonicToBits[i.Mnemonic] // Fixed bits
+	binary |= fpD(i.FirstRegister, true)
+	binary |= i.BaseRegister << 16
+	binary |= vectorListTypes[i.Count] << 8
+	binary |= vectorSizes[i.DataType.Size] << 6 // Element size, the alignment in bits 4-5 is left at 0
+	binary |= i.IndexRegister
+
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a VLD1 or VST1 of whole D registers from a machine word.
+func (i *InstructionVectorMemory) Decode(word uint32) error {
+	var mnemonic types.MnemonicType
+	switch word & 0xFFB00000 {
+	case types.MnemonicToBits[types.MnemonicVLD1]:
+		mnemonic = types.MnemonicVLD1
+	case types.MnemonicToBits[types.MnemonicVST1]:
+		mnemonic = types.MnemonicVST1
+	default:
+		return fmt.Errorf("0x%08X isn't a VLD1 or VST1", word)
+	}
+	count := uint32(0)
+	for n, listType := range vectorListTypes {
+		if word>>8&0xF == listType {
+			count = n
+		}
+	}
+	if count == 0 {
+		return fmt.Errorf("0x%08X isn't a %s of whole registers", word, types.MnemonicToLiteral[mnemonic])
+	}
+	if word>>4&0b11 != 0 {
+		return fmt.Errorf("%s in 0x%08X has an alignment rogasmic doesn't assemble", types.MnemonicToLiteral[mnemonic], word)
+	}
+
+	decoded := InstructionVectorMemory{
+		Mnemonic:      mnemonic,
+		DataType:      types.DataType{Size: 8 << (word >> 6 & 0b11)},
+		FirstRegister: fpRegister(word>>12&0xF, word>>22&1, true),
+		Count:         count,
+		BaseRegister:  word >> 16 & 0xF,
+		IndexRegister: word & 0xF,
+	}
+	if decoded.FirstRegister+count > 32 {
+		return fmt.Errorf("%s in 0x%08X goes past D31", types.MnemonicToLiteral[mnemonic], word)
+	}
+	if decoded.BaseRegister == 15 {
+		return fmt.Errorf("%s in 0x%08X uses the PC", types.MnemonicToLiteral[mnemonic], word)
+	}
+
+	*i = decoded
+	return nil
+}
+
+func (i *InstructionVectorMemory) String() string {
+	var list string
+	if i.Count >= 3 {
+		list = fmt.Sprintf("D%d-D%d", i.FirstRegister, i.FirstRegister+i.Count-1)
+	} else {
+		var parts []string
+		for r := i.FirstRegister; r < i.FirstRegister+i.Count; r++ {
+			parts = append(parts, fmt.Sprintf("D%d", r))
+		}
+		list = strings.Join(parts, ", ")
+	}
+	out := fmt.Sprintf("%s%s {%s}, [%s]", types.MnemonicToLiteral[i.Mnemonic], i.DataType, list, registerName(i.BaseRegister))
+	switch i.IndexRegister {
+	case 15:
+		return out
+	case 13:
+		return out + "!"
+	}
+	return out + ", " + registerName(i.IndexRegister)
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/robertjshirts/rogasmic/types"
 )
@@ -9,11 +10,26 @@ import (
 type Parser struct {
 	pos          int
 	tokens       []types.Token
-	instructions []types.Instruction
-	labels       types.LabelMap // Maps label names to instruction numbers
+	instructions []types.Instruction // Instructions in the current section
+	labels       types.LabelMap      // Maps label names to byte offsets, see layoutSections
+	section      string              // Name of the current section
+	sections     map[string][]types.Instruction
+	sectionOrder []string // Section names in the order they first appear
+	symbols      map[string]*types.Symbol
+	symbolOrder  []string
+	layout       []types.Section
+	thumbFunc    types.Token           // .thumb_func directive waiting for the label it applies to, empty literal if there's none
+	thumb        bool                  // Assembling Thumb code, selected with .thumb and .arm
+	itBlock      []types.ConditionType // Conditions of the instructions left in the current IT block
+	itToken      types.Token           // IT instruction of the current block, for diagnostics
+	thumbLabels  map[string]bool       // Labels defined in Thumb code
+	unaligned    map[string]bool       // Sections that might end halfway through a word, after Thumb code
+	sizes        []pendingSize         // .size name, .-label directives, which need the layout to be known
 }
 
 func NewParser(tokens []types.Token) *Parser {
+	// The parser drops .N and .W qualifiers as it goes, so it works on its own copy of the tokens
+	tokens = append(make([]types.Token, 0, len(tokens)+1), tokens...)
 	if len(tokens) == 0 || tokens[len(tokens)-1].Type != types.TokenEOF {
 		// Ensure the last token is EOF
 		tokens = append(tokens, types.Token{Type: types.TokenEOF, Literal: "", Line: -1, Col: -1})
@@ -23,6 +39,12 @@ func NewParser(tokens []types.Token) *Parser {
 		tokens:       tokens,
 		instructions: make([]types.Instruction, 0),
 		labels:       make(types.LabelMap),
+		section:      ".text",
+		sections:     make(map[string][]types.Instruction),
+		sectionOrder: []string{".text"},
+		symbols:      make(map[string]*types.Symbol),
+		thumbLabels:  make(map[string]bool),
+		unaligned:    make(map[string]bool),
 	}
 }
 
@@ -51,57 +73,210 @@ func (p *Parser) Parse() ([]types.Instruction, types.LabelMap, error) {
 	for p.current().Type != types.TokenEOF {
 		instructionCategory, ok := types.MnemonicTokenToCategory[p.current().Type]
 		if !ok {
+			if p.current().Type == types.TokenDirective {
+				token := p.current()
+				if err := p.parseDirective(); err != nil {
+					return nil, nil, fmt.Errorf("error parsing %s directive at line %d, col %d: %w", token.Literal, token.Line, token.Col, err)
+				}
+				continue
+			}
+
 			if p.current().Type != types.TokenLabel {
 				// Any other token is unexpected
 				return nil, nil, fmt.Errorf("unexpected token %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
 			}
 
-			p.labels[p.current().Literal] = uint32(len(p.instructions))
+			if err := p.defineLabel(p.current()); err != nil {
+				return nil, nil, err
+			}
 			p.consume() // consume label token
 			continue    // skip to next token
 		}
 
+		token := p.current()
+		width, err := p.parseQualifier()
+		if err != nil {
+			return nil, nil, err
+		}
+		if !p.thumb {
+			p.alignARM(token)
+		}
+		start := len(p.instructions)
+
 		switch instructionCategory {
 		case types.MnemonicCategoryMOV:
 			instruction, err := p.parseMOV()
 			if err != nil {
-				return nil, nil, fmt.Errorf("error parsing MOV instruction at line %d, col %d: %w", p.current().Line, p.current().Col, err)
+				return nil, nil, fmt.Errorf("error parsing MOV instruction at line %d, col %d: %w", token.Line, token.Col, err)
 			}
 			p.instructions = append(p.instructions, instruction)
 		case types.MnemonicCategoryLoadStore:
 			instruction, err := p.parseMemory()
 			if err != nil {
-				return nil, nil, fmt.Errorf("error parsing memory instruction at line %d, col %d: %w", p.current().Line, p.current().Col, err)
+				return nil, nil, fmt.Errorf("error parsing memory instruction at line %d, col %d: %w", token.Line, token.Col, err)
 			}
 			p.instructions = append(p.instructions, instruction)
 		case types.MnemonicCategoryLoadStoreMultiple:
 			instruction, err := p.parseMemoryMultiple()
 			if err != nil {
-				return nil, nil, fmt.Errorf("error parsing block memory instruction at line %d, col %d: %w", p.current().Line, p.current().Col, err)
+				return nil, nil, fmt.Errorf("error parsing block memory instruction at line %d, col %d: %w", token.Line, token.Col, err)
 			}
 			p.instructions = append(p.instructions, instruction)
 		case types.MnemonicCategoryArithmetic:
 			instruction, err := p.parseArithmetic()
 			if err != nil {
-				return nil, nil, fmt.Errorf("error parsing arithmetic instruction at line %d, col %d: %w", p.current().Line, p.current().Col, err)
+				return nil, nil, fmt.Errorf("error parsing arithmetic instruction at line %d, col %d: %w", token.Line, token.Col, err)
 			}
 			p.instructions = append(p.instructions, instruction)
 		case types.MnemonicCategoryBranch:
 			instruction, err := p.parseBranch()
 			if err != nil {
-				return nil, nil, fmt.Errorf("error parsing branch instruction at line %d, col %d: %w", p.current().Line, p.current().Col, err)
+				return nil, nil, fmt.Errorf("error parsing branch instruction at line %d, col %d: %w", token.Line, token.Col, err)
 			}
 			p.instructions = append(p.instructions, instruction)
 		case types.MnemonicCategoryBranchExchange:
 			instruction, err := p.parseBranchExchange()
 			if err != nil {
-				return nil, nil, fmt.Errorf("error parsing branch exchange instruction at line %d, col %d: %w", p.current().Line, p.current().Col, err)
+				return nil, nil, fmt.Errorf("error parsing branch exchange instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategorySupervisorCall:
+			instruction, err := p.parseSupervisorCall()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing supervisor call at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryStatus:
+			instruction, err := p.parseStatus()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing status register instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryChangeState:
+			instruction, err := p.parseChangeState()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing change state instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryCoprocessor:
+			instruction, err := p.parseCoprocessor()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing coprocessor instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryBarrier:
+			instruction, err := p.parseBarrier()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing barrier instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryHint:
+			instruction, err := p.parseHint()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing hint instruction at line %d, col %d: %w", token.Line, token.Col, err)
 			}
 			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryExclusive:
+			instruction, err := p.parseExclusive()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing exclusive instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryMedia:
+			instruction, err := p.parseMedia()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing media instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryFloat:
+			instruction, err := p.parseFloat()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing floating-point instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryFloatMemory:
+			instruction, err := p.parseFloatMemory()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing floating-point memory instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryFloatSystem:
+			instruction, err := p.parseFloatSystem()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing floating-point system register instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryVector:
+			instruction, err := p.parseVectorMemory()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing NEON memory instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryIfThen:
+			instruction, err := p.parseIfThen()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing IT instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instruction)
+		case types.MnemonicCategoryPseudo:
+			instructions, err := p.parsePseudo()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing pseudo-instruction at line %d, col %d: %w", token.Line, token.Col, err)
+			}
+			p.instructions = append(p.instructions, instructions...)
 		default:
 			return nil, nil, fmt.Errorf("unknown instruction category at line %d, col %d", p.current().Line, p.current().Col)
 		}
+
+		if p.thumb {
+			if err := p.thumbify(start, width); err != nil {
+				return nil, nil, fmt.Errorf("error assembling %s as Thumb at line %d, col %d: %w", token.Literal, token.Line, token.Col, err)
+			}
+		}
+	}
+
+	if err := p.endITBlock(); err != nil {
+		return nil, nil, err
+	}
+	p.layoutSections()
+	if err := p.checkSymbols(); err != nil {
+		return nil, nil, err
 	}
 
 	return p.instructions, p.labels, nil
 }
+
+// parseQualifier takes the .N or .W off the mnemonic about to be parsed, returning the size it asks for in bytes, or
+// 0 if there isn't one.
+func (p *Parser) parseQualifier() (uint32, error) {
+	qualifier := p.peek()
+	if qualifier.Type != types.TokenQualifier {
+		return 0, nil
+	}
+	if !p.thumb {
+		return 0, fmt.Errorf("%s qualifier at line %d, col %d is only valid in Thumb code", qualifier.Literal, qualifier.Line, qualifier.Col)
+	}
+	// Drop the qualifier so the instruction parsers see the operands straight after the mnemonic. The tokens are the
+	// parser's own copy, see NewParser
+	p.tokens = append(p.tokens[:p.pos+1], p.tokens[p.pos+2:]...)
+	if strings.EqualFold(qualifier.Literal, ".W") {
+		return 4, nil
+	}
+	return 2, nil
+}
+
+// Sections returns the instruction range of every non-empty section, in the order they first appear. Only valid after
+// Parse.
+func (p *Parser) Sections() []types.Section {
+	return p.layout
+}
+
+// Symbols returns every label defined in the input and every name declared with .global, .weak or .extern, along with
+// their binding, type and size. Label indexes match the instructions returned by Parse.
+func (p *Parser) Symbols() []types.Symbol {
+	symbols := make([]types.Symbol, 0, len(p.symbolOrder))
+	for _, name := range p.symbolOrder {
+		symbols = append(symbols, *p.symbols[name])
+	}
+	return symbols
+}
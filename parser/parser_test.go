@@ -2,9 +2,16 @@ package parser
 
 import (
 	"bytes"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/robertjshirts/rogasmic/lexer"
+	"github.com/robertjshirts/rogasmic/types"
 )
 
 func TestParserMOV(t *testing.T) {
@@ -50,6 +57,24 @@ func TestParserMOV(t *testing.T) {
 			expected:      [][]byte{},
 			expectedError: true,
 		},
+		{
+			name:          "MOV32 expands to MOVW and MOVT",
+			input:         "MOV32 R4, #0x3F200000",
+			expected:      [][]byte{{0x00, 0x40, 0x00, 0xE3}, {0x20, 0x4F, 0x43, 0xE3}},
+			expectedError: false,
+		},
+		{
+			name:          "MOV32 with condition",
+			input:         "MOV32PL R5, #0x003D0900",
+			expected:      [][]byte{{0x00, 0x59, 0x00, 0x53}, {0x3D, 0x50, 0x40, 0x53}},
+			expectedError: false,
+		},
+		{
+			name:          "MOV32 with invalid condition",
+			input:         "MOV32XY R5, #1",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
 	}
 
 	for _, c := range cases {
@@ -98,15 +123,75 @@ func TestParserMemory(t *testing.T) {
 		{
 			name:          "LDR with base register",
 			input:         "LDR R3, [R2]",
-			expected:      [][]byte{{0x00, 0x30, 0x12, 0xE4}},
+			expected:      [][]byte{{0x00, 0x30, 0x92, 0xE5}},
 			expectedError: false,
 		},
 		{
 			name:          "STR with base register",
 			input:         "STR R3, [R2]",
-			expected:      [][]byte{{0x00, 0x30, 0x02, 0xE4}},
+			expected:      [][]byte{{0x00, 0x30, 0x82, 0xE5}},
+			expectedError: false,
+		},
+		{
+			name:          "LDR into the base register",
+			input:         "LDR R0, [R0]",
+			expected:      [][]byte{{0x00, 0x00, 0x90, 0xE5}},
+			expectedError: false,
+		},
+		{
+			name:          "LDR with offset",
+			input:         "LDR R3, [R2, #8]",
+			expected:      [][]byte{{0x08, 0x30, 0x92, 0xE5}},
+			expectedError: false,
+		},
+		{
+			name:          "STR with 12 bit offset",
+			input:         "STR R3, [R2, #0xFFF]",
+			expected:      [][]byte{{0xFF, 0x3F, 0x82, 0xE5}},
+			expectedError: false,
+		},
+		{
+			name:          "LDR with offset and writeback",
+			input:         "LDR R3, [R2, #8]!",
+			expected:      [][]byte{{0x08, 0x30, 0xB2, 0xE5}},
+			expectedError: false,
+		},
+		{
+			name:          "STR with offset and writeback",
+			input:         "STR R5, [sp, #4]!",
+			expected:      [][]byte{{0x04, 0x50, 0xAD, 0xE5}},
+			expectedError: false,
+		},
+		{
+			name:          "LDR with writeback and offset after the brackets",
+			input:         "LDR R6, [sp]!, #4",
+			expected:      [][]byte{{0x04, 0x60, 0x3D, 0xE5}},
+			expectedError: false,
+		},
+		{
+			name:          "STR with writeback and offset after the brackets",
+			input:         "STR R5, [sp]!, #4",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
+		{
+			name:          "STR with offset after the brackets",
+			input:         "STR R5, [sp], #4",
+			expected:      [][]byte{{0x04, 0x50, 0x8D, 0xE4}},
 			expectedError: false,
 		},
+		{
+			name:          "LDR with offset too large",
+			input:         "LDR R3, [R2, #0x1000]",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
+		{
+			name:          "LDR with unclosed offset",
+			input:         "LDR R3, [R2, #8",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
 		{
 			name:          "STR with invalid register",
 			input:         "STR R16, [R1]",
@@ -119,6 +204,18 @@ func TestParserMemory(t *testing.T) {
 			expected:      [][]byte{},
 			expectedError: true,
 		},
+		{
+			name:          "LDM exception return",
+			input:         "LDMEA sp!, {R0, pc}^",
+			expected:      [][]byte{{0x01, 0x80, 0x7D, 0xE9}},
+			expectedError: false,
+		},
+		{
+			name:          "LDM user bank with writeback",
+			input:         "LDMEA sp!, {R0-R12}^",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
 	}
 
 	for _, c := range cases {
@@ -188,6 +285,24 @@ func TestParserArithmetic(t *testing.T) {
 			expected:      [][]byte{{0x08, 0x30, 0x83, 0xE3}},
 			expectedError: false,
 		},
+		{
+			name:          "ADD with rotated immediate",
+			input:         "ADD R0, R0, #0x3F000000",
+			expected:      [][]byte{{0x3F, 0x04, 0x80, 0xE2}},
+			expectedError: false,
+		},
+		{
+			name:          "SUBNE with rotated immediate",
+			input:         "SUBNE R1, R1, #0x400",
+			expected:      [][]byte{{0x01, 0x1B, 0x41, 0x12}},
+			expectedError: false,
+		},
+		{
+			name:          "ADD with immediate that can't be rotated",
+			input:         "ADD R0, R0, #0x101",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
 		{
 			name:          "ADD with invalid register",
 			input:         "ADD R16, R4, #0x1C",
@@ -269,12 +384,78 @@ func TestParserBranch(t *testing.T) {
 			expected:      [][]byte{{0xEE, 0xFF, 0xFF, 0xEA}},
 			expectedError: false,
 		},
+		{
+			name:          "BLT is B with a condition",
+			input:         "BLT #0xFFFFEE",
+			expected:      [][]byte{{0xEE, 0xFF, 0xFF, 0xBA}},
+			expectedError: false,
+		},
+		{
+			name:          "BLLE is BL with a condition",
+			input:         "BLLE #0xFFFFEE",
+			expected:      [][]byte{{0xEE, 0xFF, 0xFF, 0xDB}},
+			expectedError: false,
+		},
+		{
+			name:          "BNE",
+			input:         "BNE #0xFFFFEE",
+			expected:      [][]byte{{0xEE, 0xFF, 0xFF, 0x1A}},
+			expectedError: false,
+		},
+		{
+			name:          "BHS is BCS",
+			input:         "BHS #0xFFFFEE",
+			expected:      [][]byte{{0xEE, 0xFF, 0xFF, 0x2A}},
+			expectedError: false,
+		},
+		{
+			name:          "BLO is B with a condition",
+			input:         "BLO #0xFFFFEE",
+			expected:      [][]byte{{0xEE, 0xFF, 0xFF, 0x3A}},
+			expectedError: false,
+		},
+		{
+			name:          "BLS is B with a condition",
+			input:         "BLS #0xFFFFEE",
+			expected:      [][]byte{{0xEE, 0xFF, 0xFF, 0x9A}},
+			expectedError: false,
+		},
+		{
+			name:          "BLLO is BL with a condition",
+			input:         "BLLO #0xFFFFEE",
+			expected:      [][]byte{{0xEE, 0xFF, 0xFF, 0x3B}},
+			expectedError: false,
+		},
 		{
 			name:          "B with register (invalid)",
 			input:         "B R0",
 			expected:      [][]byte{},
 			expectedError: true,
 		},
+		{
+			name:          "BLX with word offset",
+			input:         "BLX #0x8",
+			expected:      [][]byte{{0x04, 0x00, 0x00, 0xFA}},
+			expectedError: false,
+		},
+		{
+			name:          "BLX with halfword offset sets H",
+			input:         "BLX #0x1FFFFFF",
+			expected:      [][]byte{{0xFF, 0xFF, 0xFF, 0xFB}},
+			expectedError: false,
+		},
+		{
+			name:          "BLX offset too big",
+			input:         "BLX #0x2000000",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
+		{
+			name:          "conditional BLX with label (invalid)",
+			input:         "thumb:\nBLXEQ thumb",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
 	}
 
 	for _, c := range cases {
@@ -326,6 +507,24 @@ func TestParserBranchExchange(t *testing.T) {
 			expected:      [][]byte{{0x1E, 0xFF, 0x2F, 0xE1}},
 			expectedError: false,
 		},
+		{
+			name:          "BLX with register",
+			input:         "BLX R3",
+			expected:      [][]byte{{0x33, 0xFF, 0x2F, 0xE1}},
+			expectedError: false,
+		},
+		{
+			name:          "BLX with condition",
+			input:         "blxne r0",
+			expected:      [][]byte{{0x30, 0xFF, 0x2F, 0x11}},
+			expectedError: false,
+		},
+		{
+			name:          "BLX to the PC (invalid)",
+			input:         "BLX PC",
+			expected:      [][]byte{},
+			expectedError: true,
+		},
 	}
 
 	for _, c := range cases {
@@ -364,30 +563,92 @@ func TestParserBranchExchange(t *testing.T) {
 	}
 }
 
-func TestParserLabels(t *testing.T) {
+func TestParserSupervisorCall(t *testing.T) {
 	cases := []struct {
-		name string
-		input string
-		expected [][]byte
+		name          string
+		input         string
+		expected      [][]byte
 		expectedError bool
 	}{
-		{
-			name: "Looping label",
-			input: "loop:\nB loop",
-			expected: [][]byte{{0xFE, 0xFF, 0xFF, 0xEA}},
-			expectedError: false,
-		},
+		{name: "SVC", input: "SVC #0x123456", expected: [][]byte{{0x56, 0x34, 0x12, 0xEF}}},
+		{name: "SWI", input: "swi #0", expected: [][]byte{{0x00, 0x00, 0x00, 0xEF}}},
+		{name: "SVC with condition", input: "SVCNE #5", expected: [][]byte{{0x05, 0x00, 0x00, 0x1F}}},
+		{name: "number too big", input: "SVC #0x1000000", expectedError: true},
+		{name: "missing number", input: "SVC R0", expectedError: true},
+		{name: "S suffix", input: "SVCS #1", expectedError: true},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			l := lexer.NewLexer(c.input)
-			toks, err := l.Tokenize()
+			toks, err := lexer.NewLexer(c.input).Tokenize()
 			if err != nil {
 				t.Fatalf("unexpected error tokenizing: %v", err)
 			}
-			p := NewParser(toks)
-			instructions, labelMap, err := p.Parse()
+			instructions, _, err := NewParser(toks).Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(map[string]uint32{})
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+		})
+	}
+}
+
+func TestParserStatus(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		expected      [][]byte
+		expectedError bool
+	}{
+		{name: "MRS CPSR", input: "MRS R0, CPSR", expected: [][]byte{{0x00, 0x00, 0x0F, 0xE1}}},
+		{name: "MRS SPSR", input: "mrs r3, spsr", expected: [][]byte{{0x00, 0x30, 0x4F, 0xE1}}},
+		{name: "MRS APSR with condition", input: "MRSNE R1, APSR", expected: [][]byte{{0x00, 0x10, 0x0F, 0x11}}},
+		{name: "MSR control field", input: "MSR CPSR_c, R0", expected: [][]byte{{0x00, 0xF0, 0x21, 0xE1}}},
+		{name: "MSR every field", input: "MSR spsr_fsxc, lr", expected: [][]byte{{0x0E, 0xF0, 0x6F, 0xE1}}},
+		{name: "MSR without fields", input: "MSR CPSR, R2", expected: [][]byte{{0x02, 0xF0, 0x29, 0xE1}}},
+		{name: "MSR immediate", input: "MSR CPSR_c, #0xD3", expected: [][]byte{{0xD3, 0xF0, 0x21, 0xE3}}},
+		{name: "MSR rotated immediate", input: "MSREQ CPSR_f, #0xF0000000", expected: [][]byte{{0x0F, 0xF2, 0x28, 0x03}}},
+		{name: "APSR flags", input: "MSR APSR_nzcvq, R4", expected: [][]byte{{0x04, 0xF0, 0x28, 0xE1}}},
+		{name: "APSR GE", input: "MSR APSR_g, #0xF0000", expected: [][]byte{{0x0F, 0xF8, 0x24, 0xE3}}},
+		{name: "APSR flags and GE", input: "MSR APSR_nzcvqg, R0", expected: [][]byte{{0x00, 0xF0, 0x2C, 0xE1}}},
+		{name: "MRS to PC", input: "MRS PC, CPSR", expectedError: true},
+		{name: "MRS with fields", input: "MRS R0, CPSR_c", expectedError: true},
+		{name: "MRS from register", input: "MRS R0, R1", expectedError: true},
+		{name: "unknown field", input: "MSR CPSR_q, R0", expectedError: true},
+		{name: "repeated field", input: "MSR CPSR_cc, R0", expectedError: true},
+		{name: "no fields after underscore", input: "MSR CPSR_, R0", expectedError: true},
+		{name: "APSR without fields", input: "MSR APSR, R0", expectedError: true},
+		{name: "APSR with CPSR fields", input: "MSR APSR_c, R0", expectedError: true},
+		{name: "MSR from PC", input: "MSR CPSR_c, PC", expectedError: true},
+		{name: "unencodable immediate", input: "MSR CPSR_c, #0x101", expectedError: true},
+		{name: "operands swapped", input: "MSR R0, CPSR", expectedError: true},
+		{name: "S suffix", input: "MSRS CPSR_c, R0", expectedError: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
 			if c.expectedError {
 				if err == nil {
 					t.Fatalf("expected error but got none for input: %s", c.input)
@@ -397,13 +658,174 @@ func TestParserLabels(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error parsing: %v", err)
 			}
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(map[string]uint32{})
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+		})
+	}
+}
+
+func TestParserStatusFields(t *testing.T) {
+	// Every field mask, for both status registers, written in reverse order to check it's printed in fsxc order
+	letters := "cxsf"
+	for _, register := range []string{"CPSR", "SPSR"} {
+		for mask := uint32(1); mask < 16; mask++ {
+			written, printed := "", ""
+			for n := 0; n < 4; n++ {
+				if mask&(1<<n) != 0 {
+					written += letters[n : n+1]
+					printed = letters[n:n+1] + printed
+				}
+			}
+			source := fmt.Sprintf("MSR %s_%s, R1", strings.ToLower(register), written)
+			toks, err := lexer.NewLexer(source).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing %q: %v", source, err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error parsing %q: %v", source, err)
+			}
+			code, err := instructions[0].ToMachineCode(nil)
+			if err != nil {
+				t.Fatalf("unexpected error encoding %q: %v", source, err)
+			}
+			word := uint32(0xE120F001) | mask<<16
+			if register == "SPSR" {
+				word |= 1 << 22
+			}
+			if got := uint32(code[0]) | uint32(code[1])<<8 | uint32(code[2])<<16 | uint32(code[3])<<24; got != word {
+				t.Errorf("%q: expected 0x%08X, got 0x%08X", source, word, got)
+			}
+			expected := fmt.Sprintf("MSR %s_%s, R1", register, printed)
+			if decoded, err := Decode(word); err != nil || decoded.String() != expected {
+				t.Errorf("0x%08X: expected %q, got %v (%v)", word, expected, decoded, err)
+			}
+		}
+	}
+}
+
+func TestParserChangeState(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		expected      [][]byte
+		expectedError bool
+	}{
+		{name: "CPSID", input: "CPSID i", expected: [][]byte{{0x80, 0x00, 0x0C, 0xF1}}},
+		{name: "CPSIE every mask", input: "cpsie fia", expected: [][]byte{{0xC0, 0x01, 0x08, 0xF1}}},
+		{name: "CPSID with mode", input: "CPSID if, #0x13", expected: [][]byte{{0xD3, 0x00, 0x0E, 0xF1}}},
+		{name: "CPS mode", input: "CPS #0x1F", expected: [][]byte{{0x1F, 0x00, 0x02, 0xF1}}},
+		{name: "SETEND BE", input: "SETEND BE", expected: [][]byte{{0x00, 0x02, 0x01, 0xF1}}},
+		{name: "SETEND LE", input: "setend le", expected: [][]byte{{0x00, 0x00, 0x01, 0xF1}}},
+		{name: "conditional CPS", input: "CPSIDEQ i", expectedError: true},
+		{name: "no masks", input: "CPSID", expectedError: true},
+		{name: "unknown mask", input: "CPSID x", expectedError: true},
+		{name: "repeated mask", input: "CPSIE ii", expectedError: true},
+		{name: "mode too big", input: "CPS #0x20", expectedError: true},
+		{name: "no mode", input: "CPS", expectedError: true},
+		{name: "mode in a register", input: "CPSIE i, R0", expectedError: true},
+		{name: "no endianness", input: "SETEND", expectedError: true},
+		{name: "unknown endianness", input: "SETEND XE", expectedError: true},
+		{name: "conditional SETEND", input: "SETENDNE BE", expectedError: true},
+	}
 
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				if c.expectedError {
+					return
+				}
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
 			if len(instructions) != len(c.expected) {
 				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
 			}
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(map[string]uint32{})
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+		})
+	}
+}
 
+func TestParserCoprocessor(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		expected      [][]byte
+		expectedError bool
+	}{
+		{name: "MRC", input: "MRC p15, 0, r0, c0, c0, 5", expected: [][]byte{{0xB0, 0x0F, 0x10, 0xEE}}},
+		{name: "MRC by name", input: "MRC R3, MIDR", expected: [][]byte{{0x10, 0x3F, 0x10, 0xEE}}},
+		{name: "MCR", input: "MCR p15, 0, R0, c1, c0, 0", expected: [][]byte{{0x10, 0x0F, 0x01, 0xEE}}},
+		{name: "MCR by name", input: "mcr r1, vbar", expected: [][]byte{{0x10, 0x1F, 0x0C, 0xEE}}},
+		{name: "MCR with condition", input: "MCRNE p15, 0, R2, c7, c5, 0", expected: [][]byte{{0x15, 0x2F, 0x07, 0x1E}}},
+		{name: "opcode 1", input: "MRC p15, #1, R0, c9, c0, #2", expected: [][]byte{{0x50, 0x0F, 0x39, 0xEE}}},
+		{name: "opcode 2 left out", input: "MCR p15, 0, R0, c3, c0", expected: [][]byte{{0x10, 0x0F, 0x03, 0xEE}}},
+		{name: "MCRR", input: "MCRR p15, 0, R0, R1, c2", expected: [][]byte{{0x02, 0x0F, 0x41, 0xEC}}},
+		{name: "MRRC by name", input: "MRRC R2, R3, CNTVCT", expected: [][]byte{{0x1E, 0x2F, 0x53, 0xEC}}},
+		{name: "other coprocessor", input: "MRC p14, 0, R0, c0, c0, 0", expectedError: true},
+		{name: "unknown register", input: "MRC p15, 0, R0, c9, c9, 0", expectedError: true},
+		{name: "unknown name", input: "MRC R0, NOPE", expectedError: true},
+		{name: "write to read only", input: "MCR R0, MIDR", expectedError: true},
+		{name: "read from write only", input: "MRC R0, ICIALLU", expectedError: true},
+		{name: "PC", input: "MRC PC, MPIDR", expectedError: true},
+		{name: "MRRC into one register", input: "MRRC R0, R0, CNTPCT", expectedError: true},
+		{name: "opcode too big", input: "MCR p15, 8, R0, c1, c0, 0", expectedError: true},
+		{name: "register too big", input: "MRC p15, 0, R0, c16, c0, 0", expectedError: true},
+		{name: "32 bit register with MRRC", input: "MRRC R0, R1, MIDR", expectedError: true},
+		{name: "64 bit register with MRC", input: "MRC R0, CNTPCT", expectedError: true},
+		{name: "opcode 2 with MCRR", input: "MCRR p15, 0, R0, R1, c2, 0", expectedError: true},
+		{name: "S suffix", input: "MCRS p15, 0, R0, c1, c0, 0", expectedError: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
 			for i, inst := range instructions {
-				machineCode, err := inst.ToMachineCode(labelMap)
+				machineCode, err := inst.ToMachineCode(map[string]uint32{})
 				if err != nil {
 					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
 				}
@@ -413,4 +835,1159 @@ func TestParserLabels(t *testing.T) {
 			}
 		})
 	}
-}	
\ No newline at end of file
+}
+
+func TestParserCP15Names(t *testing.T) {
+	// Every name assembles to the same word as its operands written out, and is found again from them
+	for _, register := range CP15Registers {
+		read := register.Access != CP15WriteOnly
+		mnemonic, registers, operands := "MCR", "R1", fmt.Sprintf("p15, %d, R1, c%d, c%d, %d", register.Opcode1, register.CRn, register.CRm, register.Opcode2)
+		if read {
+			mnemonic = "MRC"
+		}
+		if register.Wide {
+			mnemonic, registers, operands = "MCRR", "R1, R2", fmt.Sprintf("p15, %d, R1, R2, c%d", register.Opcode1, register.CRm)
+			if read {
+				mnemonic = "MRRC"
+			}
+		}
+		var words [2]string
+		for n, source := range []string{mnemonic + " " + registers + ", " + register.Name, mnemonic + " " + operands} {
+			toks, err := lexer.NewLexer(source).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing %q: %v", source, err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error parsing %q: %v", source, err)
+			}
+			code, err := instructions[0].ToMachineCode(nil)
+			if err != nil {
+				t.Fatalf("unexpected error encoding %q: %v", source, err)
+			}
+			words[n] = fmt.Sprintf("%X", code)
+			if found, ok := instructions[0].(*InstructionCoprocessor).SystemRegister(); !ok || found != register {
+				t.Errorf("%q: expected %s, got %+v", source, register.Name, found)
+			}
+		}
+		if words[0] != words[1] {
+			t.Errorf("%s: %s by name, %s by operands", register.Name, words[0], words[1])
+		}
+	}
+}
+
+func TestParserBarrier(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		expected      [][]byte
+		expectedError bool
+	}{
+		{name: "DMB", input: "DMB", expected: [][]byte{{0x5F, 0xF0, 0x7F, 0xF5}}},
+		{name: "DMB ISH", input: "dmb ish", expected: [][]byte{{0x5B, 0xF0, 0x7F, 0xF5}}},
+		{name: "DSB ST", input: "DSB ST", expected: [][]byte{{0x4E, 0xF0, 0x7F, 0xF5}}},
+		{name: "ISB SY", input: "ISB SY", expected: [][]byte{{0x6F, 0xF0, 0x7F, 0xF5}}},
+		{name: "every option", input: "DMB OSHST\nDMB OSH\nDMB NSHST\nDMB NSH\nDMB ISHST", expected: [][]byte{{0x52, 0xF0, 0x7F, 0xF5}, {0x53, 0xF0, 0x7F, 0xF5}, {0x56, 0xF0, 0x7F, 0xF5}, {0x57, 0xF0, 0x7F, 0xF5}, {0x5A, 0xF0, 0x7F, 0xF5}}},
+		{name: "old names", input: "DSB SH\nDSB SHST\nDSB UN\nDSB UNST", expected: [][]byte{{0x4B, 0xF0, 0x7F, 0xF5}, {0x4A, 0xF0, 0x7F, 0xF5}, {0x47, 0xF0, 0x7F, 0xF5}, {0x46, 0xF0, 0x7F, 0xF5}}},
+		{name: "reserved option", input: "DMB #0x5", expected: [][]byte{{0x55, 0xF0, 0x7F, 0xF5}}},
+		{name: "no option before the next line", input: "DSB\nNOP", expected: [][]byte{{0x4F, 0xF0, 0x7F, 0xF5}, {0x00, 0xF0, 0x20, 0xE3}}},
+		{name: "CLREX", input: "CLREX", expected: [][]byte{{0x1F, 0xF0, 0x7F, 0xF5}}},
+		{name: "hints", input: "NOP\nYIELD\nWFE\nWFI\nSEV", expected: [][]byte{{0x00, 0xF0, 0x20, 0xE3}, {0x01, 0xF0, 0x20, 0xE3}, {0x02, 0xF0, 0x20, 0xE3}, {0x03, 0xF0, 0x20, 0xE3}, {0x04, 0xF0, 0x20, 0xE3}}},
+		{name: "hints with conditions", input: "WFIEQ\nyieldne", expected: [][]byte{{0x03, 0xF0, 0x20, 0x03}, {0x01, 0xF0, 0x20, 0x13}}},
+		{name: "unknown option", input: "DMB LD", expectedError: true},
+		{name: "ISB with another option", input: "ISB ISH", expectedError: true},
+		{name: "option too big", input: "DMB #16", expectedError: true},
+		{name: "conditional barrier", input: "DMBEQ SY", expectedError: true},
+		{name: "conditional CLREX", input: "CLREXNE", expectedError: true},
+		{name: "hint with operand", input: "NOP R0", expectedError: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(map[string]uint32{})
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+		})
+	}
+}
+
+func TestParserExclusive(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		expected      [][]byte
+		expectedError bool
+	}{
+		{name: "LDREX", input: "LDREX R0, [R1]", expected: [][]byte{{0x9F, 0x0F, 0x91, 0xE1}}},
+		{name: "STREX", input: "STREX R2, R3, [R4]", expected: [][]byte{{0x93, 0x2F, 0x84, 0xE1}}},
+		{name: "STREX with condition", input: "strexeq r2, r3, [sp]", expected: [][]byte{{0x93, 0x2F, 0x8D, 0x01}}},
+		{name: "zero offset", input: "LDREXNE R0, [R1, #0]", expected: [][]byte{{0x9F, 0x0F, 0x91, 0x11}}},
+		{name: "LDREX from its base", input: "LDREX R1, [R1]", expected: [][]byte{{0x9F, 0x1F, 0x91, 0xE1}}},
+		{name: "STREX storing its base", input: "STREX R0, R1, [R1]", expected: [][]byte{{0x91, 0x0F, 0x81, 0xE1}}},
+		{name: "offset", input: "LDREX R0, [R1, #4]", expectedError: true},
+		{name: "status is the value", input: "STREX R0, R0, [R1]", expectedError: true},
+		{name: "status is the base", input: "STREX R1, R0, [R1]", expectedError: true},
+		{name: "PC as status", input: "STREX PC, R0, [R1]", expectedError: true},
+		{name: "PC as value", input: "LDREX PC, [R1]", expectedError: true},
+		{name: "PC as base", input: "STREX R0, R1, [PC]", expectedError: true},
+		{name: "writeback", input: "LDREX R0, [R1]!", expectedError: true},
+		{name: "missing status register", input: "STREX R0, [R1]", expectedError: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(map[string]uint32{})
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+		})
+	}
+}
+
+func TestParserMedia(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		expected      [][]byte
+		expectedError bool
+	}{
+		{name: "CLZ", input: "CLZ R0, R1\nclzeq r2, r3", expected: [][]byte{{0x11, 0x0F, 0x6F, 0xE1}, {0x13, 0x2F, 0x6F, 0x01}}},
+		{name: "REV and REV16", input: "REV R0, R1\nREV16 R4, R5", expected: [][]byte{{0x31, 0x0F, 0xBF, 0xE6}, {0xB5, 0x4F, 0xBF, 0xE6}}},
+		{name: "extends", input: "UXTB R0, R1\nSXTH R6, R7", expected: [][]byte{{0x71, 0x00, 0xEF, 0xE6}, {0x77, 0x60, 0xBF, 0xE6}}},
+		{name: "extends with rotation", input: "UXTB R0, R1, ROR #8\nUXTH R2, R3, ror #16\nSXTB R4, R5, ROR #24\nSXTH R0, R1, ROR #0", expected: [][]byte{{0x71, 0x04, 0xEF, 0xE6}, {0x73, 0x28, 0xFF, 0xE6}, {0x75, 0x4C, 0xAF, 0xE6}, {0x71, 0x00, 0xBF, 0xE6}}},
+		{name: "UBFX and SBFX", input: "UBFX R0, R1, #4, #3\nSBFX R2, R3, #0, #32", expected: [][]byte{{0x51, 0x02, 0xE2, 0xE7}, {0x53, 0x20, 0xBF, 0xE7}}},
+		{name: "BFI", input: "BFI R0, R1, #8, #4\nBFI R2, R3, #31, #1", expected: [][]byte{{0x11, 0x04, 0xCB, 0xE7}, {0x93, 0x2F, 0xDF, 0xE7}}},
+		{name: "SDIV and UDIV", input: "SDIV R0, R1, R2\nUDIV R3, R4, R5\nsdivne r8, r9, r10", expected: [][]byte{{0x11, 0xF2, 0x10, 0xE7}, {0x14, 0xF5, 0x33, 0xE7}, {0x19, 0xFA, 0x18, 0x17}}},
+		{name: "immediate source", input: "CLZ R0, #1", expectedError: true},
+		{name: "immediate divisor", input: "UDIV R0, R1, #2", expectedError: true},
+		{name: "PC destination", input: "REV PC, R0", expectedError: true},
+		{name: "PC source", input: "UXTB R0, PC", expectedError: true},
+		{name: "PC divisor", input: "SDIV R0, R1, PC", expectedError: true},
+		{name: "missing divisor", input: "SDIV R0, R1", expectedError: true},
+		{name: "rotation that isn't a byte", input: "UXTB R0, R1, ROR #4", expectedError: true},
+		{name: "rotation without ROR", input: "UXTB R0, R1, #8", expectedError: true},
+		{name: "rotation on CLZ", input: "CLZ R0, R1, ROR #8", expectedError: true},
+		{name: "zero width", input: "UBFX R0, R1, #0, #0", expectedError: true},
+		{name: "field past bit 31", input: "BFI R0, R1, #28, #5", expectedError: true},
+		{name: "lsb past bit 31", input: "SBFX R0, R1, #32, #1", expectedError: true},
+		{name: "register lsb", input: "UBFX R0, R1, R2, #1", expectedError: true},
+		{name: "missing width", input: "BFI R0, R1, #8", expectedError: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(map[string]uint32{})
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+		})
+	}
+}
+
+func TestParserFloat(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		expected      [][]byte
+		expectedError bool
+	}{
+		{name: "arithmetic", input: "VADD.F32 S0, S1, S2\nvsubeq.f64 d0, d17, d2\nVMUL.F32 S31, S30, S29\nVDIV.F64 D31, D1, D16", expected: [][]byte{{0x81, 0x0A, 0x30, 0xEE}, {0xC2, 0x0B, 0x31, 0x0E}, {0x2E, 0xFA, 0x6F, 0xEE}, {0x20, 0xFB, 0xC1, 0xEE}}},
+		{name: "VMOV between extension registers", input: "VMOV.F32 S0, S1\nVMOV.F64 D3, D20\nVMOV S0, S1", expected: [][]byte{{0x60, 0x0A, 0xB0, 0xEE}, {0x64, 0x3B, 0xB0, 0xEE}, {0x60, 0x0A, 0xB0, 0xEE}}},
+		{name: "VMOV to and from ARM registers", input: "VMOV S3, R1\nVMOV R2, S31\nVMOV D17, R1, R2\nVMOVNE R3, R4, D5", expected: [][]byte{{0x90, 0x1A, 0x01, 0xEE}, {0x90, 0x2A, 0x1F, 0xEE}, {0x31, 0x1B, 0x42, 0xEC}, {0x15, 0x3B, 0x54, 0x1C}}},
+		{name: "VCVT between precisions", input: "VCVT.F64.F32 D1, S3\nVCVT.F32.F64 S3, D17", expected: [][]byte{{0xE1, 0x1A, 0xB7, 0xEE}, {0xE1, 0x1B, 0xF7, 0xEE}}},
+		{name: "VCVT to and from integers", input: "VCVT.F32.S32 S0, S1\nVCVT.U32.F64 S0, D1\nVCVT.S32.F32 S0, S1", expected: [][]byte{{0xE0, 0x0A, 0xB8, 0xEE}, {0xC1, 0x0B, 0xBC, 0xEE}, {0xE0, 0x0A, 0xBD, 0xEE}}},
+		{name: "VMRS and VMSR", input: "VMRS APSR_nzcv, FPSCR\nVMRS R0, FPEXC\nVMSR FPEXC, R1\nvmrsgt r2, fpsid", expected: [][]byte{{0x10, 0xFA, 0xF1, 0xEE}, {0x10, 0x0A, 0xF8, 0xEE}, {0x10, 0x1A, 0xE8, 0xEE}, {0x10, 0x2A, 0xF0, 0xCE}}},
+		{name: "VLDR and VSTR", input: "VLDR S3, [R1, #8]\nVSTR D17, [R2]\nVLDR.64 D0, [PC, #1020]", expected: [][]byte{{0x02, 0x1A, 0xD1, 0xED}, {0x00, 0x1B, 0xC2, 0xED}, {0xFF, 0x0B, 0x9F, 0xED}}},
+		{name: "NEON VADD and VSUB", input: "VADD.I8 D0, D1, D2\nVSUB.I64 Q1, Q2, Q15\nVADD.I32 D16, D17, D31", expected: [][]byte{{0x02, 0x08, 0x01, 0xF2}, {0x6E, 0x28, 0x34, 0xF3}, {0xAF, 0x08, 0x61, 0xF2}}},
+		{name: "VLD1 and VST1", input: "VLD1.32 {D0-D2}, [R1]\nVST1.8 {D1}, [R2]!\nVLD1.64 {D4, D5}, [R3], R4\nVST1.16 {D28-D31}, [R0]", expected: [][]byte{{0x8F, 0x06, 0x21, 0xF4}, {0x0D, 0x17, 0x02, 0xF4}, {0xC4, 0x4A, 0x23, 0xF4}, {0x4F, 0xC2, 0x40, 0xF4}}},
+		{name: "missing data type", input: "VADD S0, S1, S2", expectedError: true},
+		{name: "integer VFP arithmetic", input: "VMUL.I32 S0, S1, S2", expectedError: true},
+		{name: "data type doesn't match registers", input: "VADD.F64 S0, S1, S2", expectedError: true},
+		{name: "mixed registers", input: "VADD.F32 S0, D1, S2", expectedError: true},
+		{name: "F16", input: "VADD.F16 S0, S1, S2", expectedError: true},
+		{name: "VCVT with one data type", input: "VCVT.F32 S0, S1", expectedError: true},
+		{name: "VCVT between integers", input: "VCVT.S32.U32 S0, S1", expectedError: true},
+		{name: "VMOV with the PC", input: "VMOV S0, PC", expectedError: true},
+		{name: "VMOV into one register twice", input: "VMOV R0, R0, D1", expectedError: true},
+		{name: "VMOV of a Q register", input: "VMOV Q0, Q1", expectedError: true},
+		{name: "VMOV to ARM with a data type", input: "VMOV.F32 R0, S1", expectedError: true},
+		{name: "S register past 31", input: "VMOV S32, R0", expectedError: true},
+		{name: "APSR_nzcv from FPEXC", input: "VMRS APSR_nzcv, FPEXC", expectedError: true},
+		{name: "unknown system register", input: "VMSR MVFR0, R0", expectedError: true},
+		{name: "VLDR offset not a multiple of 4", input: "VLDR S0, [R1, #2]", expectedError: true},
+		{name: "VLDR offset too far", input: "VLDR S0, [R1, #1024]", expectedError: true},
+		{name: "VLDR size doesn't match", input: "VLDR.32 D0, [R1]", expectedError: true},
+		{name: "conditional NEON", input: "VADDEQ.I32 D0, D1, D2", expectedError: true},
+		{name: "NEON on S registers", input: "VADD.I32 S0, S1, S2", expectedError: true},
+		{name: "mixed D and Q", input: "VADD.I32 Q0, D1, D2", expectedError: true},
+		{name: "VLD1 of five registers", input: "VLD1.32 {D0-D4}, [R0]", expectedError: true},
+		{name: "VLD1 gap in the list", input: "VLD1.32 {D0, D2}, [R0]", expectedError: true},
+		{name: "VLD1 without a data type", input: "VLD1 {D0}, [R0]", expectedError: true},
+		{name: "VST1 from the PC", input: "VST1.32 {D0}, [PC]", expectedError: true},
+		{name: "VST1 SP index", input: "VST1.32 {D0}, [R0], SP", expectedError: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				if c.expectedError {
+					return
+				}
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(map[string]uint32{})
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+		})
+	}
+}
+
+func TestParserThumb(t *testing.T) {
+	far := append([]byte{0x00, 0xF0, 0xB0, 0xBC}, bytes.Repeat([]byte{0xAF, 0xF3, 0x00, 0x80}, 600)...)
+	cases := []struct {
+		name           string
+		input          string
+		expected       []byte
+		expectedLabels map[string]uint32
+		expectedError  bool
+	}{
+		{name: "ADDS narrow", input: ".thumb\nADDS R0, R0, #1\nADDS R1, R2, #3\nADDS R3, R3, #200", expected: []byte{0x40, 0x1C, 0xD1, 0x1C, 0xC8, 0x33, 0x00, 0xBF}},
+		{name: "ADD wide", input: ".thumb\nADD R0, R1, #0x100\nADD R8, R9, #0xFF00FF00\nADD R0, R1, #0xFFF", expected: []byte{0x01, 0xF5, 0x80, 0x70, 0x09, 0xF1, 0xFF, 0x28, 0x01, 0xF6, 0xFF, 0x70}},
+		{name: "SP arithmetic", input: ".thumb\nSUB SP, SP, #16\nADD R2, SP, #8", expected: []byte{0x84, 0xB0, 0x02, 0xAA}},
+		{name: "MOVW and MOVT", input: ".thumb\nMOVW R0, #0x1234\nMOVT R0, #0xABCD", expected: []byte{0x41, 0xF2, 0x34, 0x20, 0xCA, 0xF6, 0xCD, 0x30}},
+		{name: "loads and stores", input: ".thumb\nLDR R0, [R1]\nLDR R0, [SP]\nLDR R8, [R1]\nLDR R0, [R1]!, #4\nSTR R0, [R1], #4", expected: []byte{0x08, 0x68, 0x00, 0x98, 0xD1, 0xF8, 0x00, 0x80, 0x51, 0xF8, 0x04, 0x0D, 0x41, 0xF8, 0x04, 0x0B}},
+		{name: "loads and stores with offsets", input: ".thumb\nLDR R2, [R3, #124]\nLDR R2, [R3, #128]\nSTR R2, [SP, #1020]\nLDR R2, [SP, #1024]\nLDR R0, [R1, #4]!\nSTR R0, [R1, #0x800]", expected: []byte{0xDA, 0x6F, 0xD3, 0xF8, 0x80, 0x20, 0xFF, 0x92, 0xDD, 0xF8, 0x00, 0x24, 0x51, 0xF8, 0x04, 0x0F, 0xC1, 0xF8, 0x00, 0x08}},
+		{name: "load and store multiple", input: ".thumb\nSTM R0!, {R1, R2}\nSTM R8, {R1, R2}\nLDM R0!, {R1, R2, PC}", expected: []byte{0x06, 0xC0, 0x88, 0xE8, 0x06, 0x00, 0x30, 0xE9, 0x06, 0x80, 0x00, 0xBF}},
+		{name: "branch exchange and SVC", input: ".thumb\nBX LR\nBLX R3\nSVC #5", expected: []byte{0x70, 0x47, 0x98, 0x47, 0x05, 0xDF, 0x00, 0xBF}},
+		{name: "system", input: ".thumb\nMRS R0, APSR\nMSR APSR_nzcvq, R0\nCPSID i\nCPSIE if, #0x13\nSETEND BE", expected: []byte{0xEF, 0xF3, 0x00, 0x80, 0x80, 0xF3, 0x00, 0x88, 0x72, 0xB6, 0xAF, 0xF3, 0x73, 0x85, 0x58, 0xB6}},
+		{name: "barriers and hints", input: ".thumb\nDMB ISH\nDSB SY\nISB SY\nCLREX\nNOP\nWFI", expected: []byte{0xBF, 0xF3, 0x5B, 0x8F, 0xBF, 0xF3, 0x4F, 0x8F, 0xBF, 0xF3, 0x6F, 0x8F, 0xBF, 0xF3, 0x2F, 0x8F, 0x00, 0xBF, 0x30, 0xBF}},
+		{name: "exclusives", input: ".thumb\nLDREX R0, [R1]\nSTREX R2, R0, [R1]", expected: []byte{0x51, 0xE8, 0x00, 0x0F, 0x41, 0xE8, 0x00, 0x02}},
+		{name: "media", input: ".thumb\nCLZ R0, R1\nREV R0, R1\nREV16 R8, R1\nUXTB R0, R1\nUXTB R0, R1, ROR #8\nSXTH R0, R9, ROR #8\nUBFX R0, R1, #4, #3\nBFI R2, R3, #31, #1\nUDIV R3, R4, R5", expected: []byte{0xB1, 0xFA, 0x81, 0xF0, 0x08, 0xBA, 0x91, 0xFA, 0x91, 0xF8, 0xC8, 0xB2, 0x5F, 0xFA, 0x91, 0xF0, 0x0F, 0xFA, 0x99, 0xF0, 0xC1, 0xF3, 0x02, 0x10, 0x63, 0xF3, 0xDF, 0x72, 0xB4, 0xFB, 0xF5, 0xF3}},
+		{name: "media in an IT block", input: ".thumb\nITE EQ\nCLZEQ R0, R1\nUDIVNE R3, R4, R5", expected: []byte{0x0C, 0xBF, 0xB1, 0xFA, 0x81, 0xF0, 0xB4, 0xFB, 0xF5, 0xF3, 0x00, 0xBF}},
+		{name: "VFP", input: ".thumb\nVADD.F32 S0, S1, S2\nVMOV R3, R4, D5\nVMRS APSR_nzcv, FPSCR\nVLDR D0, [R1, #8]\nVCVT.S32.F64 S0, D1", expected: []byte{0x30, 0xEE, 0x81, 0x0A, 0x54, 0xEC, 0x15, 0x3B, 0xF1, 0xEE, 0x10, 0xFA, 0x91, 0xED, 0x02, 0x0B, 0xBD, 0xEE, 0xC1, 0x0B}},
+		{name: "VFP in an IT block", input: ".thumb\nIT GT\nVADDGT.F32 S0, S1, S2", expected: []byte{0xC8, 0xBF, 0x30, 0xEE, 0x81, 0x0A, 0x00, 0xBF}},
+		{name: "NEON", input: ".thumb\nVADD.I32 Q0, Q1, Q2\nVLD1.32 {D0, D1}, [R1]!", expected: []byte{0x22, 0xEF, 0x44, 0x08, 0x21, 0xF9, 0x8D, 0x0A}},
+		{name: "qualifiers", input: ".thumb\nNOP.W\nADDS.W R0, R0, #1\nNOP.N", expected: []byte{0xAF, 0xF3, 0x00, 0x80, 0x10, 0xF1, 0x01, 0x00, 0x00, 0xBF, 0x00, 0xBF}},
+		{name: ".code 16 and .syntax unified", input: ".syntax unified\n.code 16\nNOP", expected: []byte{0x00, 0xBF, 0x00, 0xBF}},
+		{name: "IT block", input: ".thumb\nITE EQ\nADDEQ R0, R0, #1\nSUBNE R0, R0, #1", expected: []byte{0x0C, 0xBF, 0x40, 0x1C, 0x40, 0x1E, 0x00, 0xBF}},
+		{name: "four slot IT block", input: ".thumb\nITTTT NE\nADDNE R0, R0, #1\nADDNE R0, R0, #1\nADDNE R0, R0, #1\nBNE start\nstart:", expected: []byte{0x1F, 0xBF, 0x40, 0x1C, 0x40, 0x1C, 0x40, 0x1C, 0xFF, 0xE7, 0x00, 0xBF}},
+		{name: "branches", input: ".thumb\nstart:\nBEQ start\nB start\nBL start\nB.W start\nBNE.W start", expected: []byte{0xFE, 0xD0, 0xFD, 0xE7, 0xFF, 0xF7, 0xFC, 0xFF, 0xFF, 0xF7, 0xFA, 0xBF, 0x7F, 0xF4, 0xF8, 0xAF}, expectedLabels: map[string]uint32{"start": 1}},
+		{name: "far branch is widened", input: ".thumb\nB far\n" + strings.Repeat("NOP.W\n", 600) + "far:", expected: far, expectedLabels: map[string]uint32{"far": 2405}},
+		{name: "ARM calls Thumb", input: ".arm\nBL f\n.thumb\nf:\nBX LR", expected: []byte{0xFF, 0xFF, 0xFF, 0xFA, 0x70, 0x47, 0x00, 0xBF}, expectedLabels: map[string]uint32{"f": 5}},
+		{name: "Thumb calls ARM", input: ".thumb\nBL f\nNOP\n.arm\nf:\nBX LR", expected: []byte{0x00, 0xF0, 0x02, 0xE8, 0x00, 0xBF, 0x00, 0xBF, 0x1E, 0xFF, 0x2F, 0xE1}, expectedLabels: map[string]uint32{"f": 8}},
+		{name: "IT in ARM code", input: "IT EQ\nNOP", expectedError: true},
+		{name: "qualifier in ARM code", input: "NOP.W", expectedError: true},
+		{name: "conditional outside an IT block", input: ".thumb\nADDEQ R0, R0, #1", expectedError: true},
+		{name: "condition doesn't match IT", input: ".thumb\nIT EQ\nADDNE R0, R0, #1", expectedError: true},
+		{name: "unfinished IT block", input: ".thumb\nITT EQ\nADDEQ R0, R0, #1", expectedError: true},
+		{name: "IT block across a directive", input: ".thumb\nITT EQ\nADDEQ R0, R0, #1\n.arm\nNOP", expectedError: true},
+		{name: "nested IT", input: ".thumb\nITT EQ\nIT EQ", expectedError: true},
+		{name: "branch before the end of an IT block", input: ".thumb\nITT EQ\nBEQ start\nADDEQ R0, R0, #1\nstart:", expectedError: true},
+		{name: "IT AL with an else", input: ".thumb\nITE AL\nNOP\nNOP", expectedError: true},
+		{name: "wide IT", input: ".thumb\nIT.W EQ\nNOP", expectedError: true},
+		{name: "narrow BL", input: ".thumb\nstart:\nBL.N start", expectedError: true},
+		{name: "narrow encoding doesn't fit", input: ".thumb\nADD.N R0, R1, #0x100", expectedError: true},
+		{name: "narrow branch out of range", input: ".thumb\nB.N far\n" + strings.Repeat("NOP.W\n", 600) + "far:", expectedError: true},
+		{name: "immediate doesn't fit", input: ".thumb\nADD R0, R1, #0x12345", expectedError: true},
+		{name: "B to ARM code", input: ".thumb\nB f\n.arm\nf:", expectedError: true},
+		{name: "old syntax", input: ".syntax divided", expectedError: true},
+		{name: "media with SP", input: ".thumb\nREV SP, R0", expectedError: true},
+		{name: "narrow CLZ", input: ".thumb\nCLZ.N R0, R1", expectedError: true},
+		{name: "conditional media outside an IT block", input: ".thumb\nCLZEQ R0, R1", expectedError: true},
+		{name: "VMOV with SP", input: ".thumb\nVMOV S0, SP", expectedError: true},
+		{name: "narrow VADD", input: ".thumb\nVADD.F32.N S0, S1, S2", expectedError: true},
+		{name: "conditional VFP outside an IT block", input: ".thumb\nVADDEQ.F32 S0, S1, S2", expectedError: true},
+		{name: "NEON in an IT block", input: ".thumb\nIT EQ\nVADD.I32 D0, D1, D2", expectedError: true},
+		{name: "unknown code size", input: ".code 8", expectedError: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, labels, err := NewParser(toks).Parse()
+			if err == nil {
+				var machineCode []byte
+				for _, inst := range instructions {
+					code, codeErr := inst.ToMachineCode(labels)
+					if codeErr != nil {
+						err = codeErr
+						break
+					}
+					machineCode = append(machineCode, code...)
+				}
+				if err == nil && !c.expectedError && !bytes.Equal(machineCode, c.expected) {
+					t.Errorf("machine code mismatch: expected % X, got % X", c.expected, machineCode)
+				}
+			}
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for name, offset := range c.expectedLabels {
+				if labels[name] != offset {
+					t.Errorf("label %s: expected offset %d, got %d", name, offset, labels[name])
+				}
+			}
+		})
+	}
+}
+
+func TestParserLabels(t *testing.T) {
+	cases := []struct {
+		name string
+		input string
+		expected [][]byte
+		expectedError bool
+	}{
+		{
+			name: "Looping label",
+			input: "loop:\nB loop",
+			expected: [][]byte{{0xFE, 0xFF, 0xFF, 0xEA}},
+			expectedError: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := lexer.NewLexer(c.input)
+			toks, err := l.Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			p := NewParser(toks)
+			instructions, labelMap, err := p.Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(labelMap)
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+		})
+	}
+}
+
+// The label map holds byte offsets rather than instruction numbers, with bit 0 set for labels in Thumb code
+func TestParserLabelOffsets(t *testing.T) {
+	input := `start:
+MOVW R0, #1
+call:
+BLX half
+.thumb
+half:
+ADDS R0, R0, #1
+wide:
+NOP.W
+narrow:
+BX LR
+NOP
+.arm
+back:
+BX LR
+.data
+value:
+.word 7
+`
+	toks, err := lexer.NewLexer(input).Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error tokenizing: %v", err)
+	}
+	instructions, labels, err := NewParser(toks).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	// The ARM code is padded back to a word boundary after the 10 bytes of Thumb code
+	expected := types.LabelMap{"start": 0, "call": 4, "half": 9, "wide": 11, "narrow": 15, "back": 20, "value": 24}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+	var code []byte
+	for _, inst := range instructions {
+		machineCode, err := inst.ToMachineCode(labels)
+		if err != nil {
+			t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+		}
+		code = append(code, machineCode...)
+	}
+	if offset := labels["back"]; len(code) < int(offset)+4 || !bytes.Equal(code[offset:offset+4], []byte{0x1E, 0xFF, 0x2F, 0xE1}) {
+		t.Errorf("expected BX LR at offset %d of % X", offset, code)
+	}
+}
+
+func TestParserDirectives(t *testing.T) {
+	cases := []struct {
+		name             string
+		input            string
+		expected         [][]byte
+		expectedSections []types.Section
+		expectedError    bool
+	}{
+		{
+			name:             "Word values",
+			input:            ".word 0x3F200000, 42",
+			expected:         [][]byte{{0x00, 0x00, 0x20, 0x3F}, {0x2A, 0x00, 0x00, 0x00}},
+			expectedSections: []types.Section{{Name: ".text", Start: 0, End: 2}},
+			expectedError:    false,
+		},
+		{
+			name:             "Sections are grouped",
+			input:            ".data\n.word 1\n.text\nloop:\nB loop\n.data\n.word 2",
+			expected:         [][]byte{{0xFE, 0xFF, 0xFF, 0xEA}, {0x01, 0x00, 0x00, 0x00}, {0x02, 0x00, 0x00, 0x00}},
+			expectedSections: []types.Section{{Name: ".text", Start: 0, End: 1}, {Name: ".data", Start: 1, End: 3, Offset: 4}},
+			expectedError:    false,
+		},
+		{
+			name:             "Named section",
+			input:            ".section .vectors\n.word 1",
+			expected:         [][]byte{{0x01, 0x00, 0x00, 0x00}},
+			expectedSections: []types.Section{{Name: ".vectors", Start: 0, End: 1}},
+			expectedError:    false,
+		},
+		{
+			name:             "Halfword values",
+			input:            ".hword 0x4770, 0xBF00\n.word 1", // Already aligned, so the padding is empty
+			expected:         [][]byte{{0x70, 0x47}, {0x00, 0xBF}, nil, {0x01, 0x00, 0x00, 0x00}},
+			expectedSections: []types.Section{{Name: ".text", Start: 0, End: 4}},
+			expectedError:    false,
+		},
+		{
+			name:             "ARM code after an odd halfword is aligned",
+			input:            ".hword 0x4770\nBX lr",
+			expected:         [][]byte{{0x70, 0x47}, {0x00, 0xBF}, {0x1E, 0xFF, 0x2F, 0xE1}},
+			expectedSections: []types.Section{{Name: ".text", Start: 0, End: 3}},
+			expectedError:    false,
+		},
+		{
+			name:          "Halfword label",
+			input:         "loop:\n.hword loop",
+			expectedError: true,
+		},
+		{
+			name:          "Halfword too big",
+			input:         ".hword 0x10000",
+			expectedError: true,
+		},
+		{
+			name:          "Duplicate label",
+			input:         "loop:\nloop:\nB loop",
+			expectedError: true,
+		},
+		{
+			name:          "Unknown directive",
+			input:         ".bogus",
+			expectedError: true,
+		},
+		{
+			name:          "MOVT with lower half",
+			input:         ".extern x\nMOVT R0, #:lower16:x",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := lexer.NewLexer(c.input)
+			toks, err := l.Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			p := NewParser(toks)
+			instructions, labelMap, err := p.Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+
+			for i, inst := range instructions {
+				machineCode, err := inst.ToMachineCode(labelMap)
+				if err != nil {
+					t.Fatalf("unexpected error converting instruction to machine code: %v", err)
+				}
+				if !bytes.Equal(machineCode, c.expected[i]) {
+					t.Errorf("instruction mismatch at index %d: expected %v, got %v", i, c.expected[i], machineCode)
+				}
+			}
+
+			sections := p.Sections()
+			if len(sections) != len(c.expectedSections) {
+				t.Fatalf("expected %d sections, got %d: %+v", len(c.expectedSections), len(sections), sections)
+			}
+			for i := range sections {
+				if sections[i] != c.expectedSections[i] {
+					t.Errorf("section mismatch at index %d: expected %+v, got %+v", i, c.expectedSections[i], sections[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParserSymbols(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		expected      []types.Symbol
+		expectedError bool
+	}{
+		{
+			name:  "Bindings",
+			input: ".global main\n.weak hook\n.extern delay\nmain:\nlocal:\nBX lr",
+			expected: []types.Symbol{
+				{Name: "main", Section: ".text", Index: 0, Defined: true, Binding: types.BindingGlobal},
+				{Name: "hook", Binding: types.BindingWeak},
+				{Name: "delay", Binding: types.BindingExtern},
+				{Name: "local", Section: ".text", Index: 0, Defined: true, Binding: types.BindingLocal},
+			},
+			expectedError: false,
+		},
+		{
+			name:  "Type and size",
+			input: ".word 0\n.type delay, %function\ndelay:\nMOVW R5, #0xFFFF\nBX lr\n.size delay, .-delay\n.data\nbuf:\n.word 1\n.type buf, %object\n.size buf, 4",
+			expected: []types.Symbol{
+				{Name: "delay", Section: ".text", Index: 1, Offset: 4, Defined: true, Type: types.SymbolFunc, Size: 8},
+				{Name: "buf", Section: ".data", Index: 3, Defined: true, Type: types.SymbolObject, Size: 4},
+			},
+			expectedError: false,
+		},
+		{
+			name:          "Global and weak",
+			input:         ".global main\n.weak main\nmain:",
+			expectedError: true,
+		},
+		{
+			name:          "Defined extern",
+			input:         ".extern main\nmain:",
+			expectedError: true,
+		},
+		{
+			name:  "Thumb function",
+			input: ".global entry\n.word 0\n.thumb_func\nentry:\n.word 0x4770",
+			expected: []types.Symbol{
+				{Name: "entry", Section: ".text", Index: 1, Offset: 4, Defined: true, Binding: types.BindingGlobal, Type: types.SymbolFunc, Thumb: true},
+			},
+			expectedError: false,
+		},
+		{
+			name:          ".thumb_func without a label",
+			input:         ".thumb_func\n.word 0x4770",
+			expectedError: true,
+		},
+		{
+			name:          "Size of undefined label",
+			input:         ".size main, 4",
+			expectedError: true,
+		},
+		{
+			name:          "Size from another section",
+			input:         "main:\n.data\n.size main, .-main",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := lexer.NewLexer(c.input)
+			toks, err := l.Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			p := NewParser(toks)
+			_, _, err = p.Parse()
+			if c.expectedError {
+				if err == nil {
+					t.Fatalf("expected error but got none for input: %s", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+
+			symbols := p.Symbols()
+			if len(symbols) != len(c.expected) {
+				t.Fatalf("expected %d symbols, got %d: %+v", len(c.expected), len(symbols), symbols)
+			}
+			for i, symbol := range symbols {
+				symbol.Token = types.Token{} // Positions are covered by the lexer tests
+				if symbol != c.expected[i] {
+					t.Errorf("symbol mismatch at index %d: expected %+v, got %+v", i, c.expected[i], symbol)
+				}
+			}
+		})
+	}
+}
+
+// TestParserErrorPositions checks errors found after the last token of a line point at the operand at fault rather
+// than at the end of the input.
+func TestParserErrorPositions(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		position string
+	}{
+		{name: "bit field width", input: "UBFX R0, R1, #30, #3", position: "line 1, col 19"},
+		{name: "bit field lsb", input: "SBFX R0, R1, #32, #1", position: "line 1, col 14"},
+		{name: "bit field before another line", input: "MOVW R0, #1\nBFI R0, R1, #28, #5\nMOVW R1, #2", position: "line 2, col 18"},
+		{name: "bare CPSID", input: "MOVW R0, #1\n  CPSID", position: "line 2, col 3"},
+		{name: "bare CPS", input: "CPS", position: "line 1, col 1"},
+		{name: "bare SETEND", input: "SETEND", position: "line 1, col 1"},
+		{name: "instruction", input: "MOVW R0, #1\nCPSID", position: "change state instruction at line 2, col 1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			_, _, err = NewParser(toks).Parse()
+			if err == nil {
+				t.Fatalf("expected error but got none for input: %s", c.input)
+			}
+			if !strings.Contains(err.Error(), c.position) {
+				t.Errorf("expected error at %s, got %v", c.position, err)
+			}
+		})
+	}
+}
+
+func TestParserSourceTokens(t *testing.T) {
+	input := "start:\n  MOVW R0, #1\n  MOV32 R1, #0x3F200000\n.data\n.word 1, 2\n.text\n  B start"
+	expected := []struct {
+		literal string
+		line    int
+		pseudo  bool
+	}{
+		{literal: "MOVW", line: 2},
+		{literal: "MOV32", line: 3, pseudo: true},
+		{literal: "MOV32", line: 3, pseudo: true},
+		{literal: "B", line: 7},
+		{literal: ".word", line: 5},
+		{literal: ".word", line: 5},
+	}
+
+	l := lexer.NewLexer(input)
+	toks, err := l.Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error tokenizing: %v", err)
+	}
+	instructions, _, err := NewParser(toks).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	if len(instructions) != len(expected) {
+		t.Fatalf("expected %d instructions, got %d", len(expected), len(instructions))
+	}
+	for i, instruction := range instructions {
+		token := instruction.SourceToken()
+		if token.Literal != expected[i].literal || token.Line != expected[i].line {
+			t.Errorf("instruction %d: expected %s at line %d, got %s at line %d", i, expected[i].literal, expected[i].line, token.Literal, token.Line)
+		}
+		if IsPseudo(instruction) != expected[i].pseudo {
+			t.Errorf("instruction %d: expected pseudo %v", i, expected[i].pseudo)
+		}
+	}
+}
+
+func TestParserString(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{name: "MOVW", input: "MOVW R5, #0xFFFF", expected: []string{"MOVW R5, #0xFFFF"}},
+		{name: "MOVT with condition", input: "movtpl r4, #0x3f20", expected: []string{"MOVTPL R4, #0x3F20"}},
+		{name: "MOVW label half", input: ".extern table\nMOVW R0, #:lower16:table", expected: []string{"MOVW R0, #:lower16:table"}},
+		{name: "MOV32", input: "MOV32 R0, #0x3F200000", expected: []string{"MOVW R0, #0x0000", "MOVT R0, #0x3F20"}},
+		{name: "SUBS", input: "SUBS R5, R5, #0x01", expected: []string{"SUBS R5, R5, #0x1"}},
+		{name: "ADD with condition", input: "ADDGT R3, R4, #28", expected: []string{"ADDGT R3, R4, #0x1C"}},
+		{name: "ADD with rotated immediate", input: "ADD R0, R0, #0x3F000000", expected: []string{"ADD R0, R0, #0x3F000000"}},
+		{name: "condition aliases", input: "ADDHS R0, R0, #1\nSUBLO R0, R0, #1", expected: []string{"ADDCS R0, R0, #0x1", "SUBCC R0, R0, #0x1"}},
+		{name: "every condition", input: "BEQ #0\nBNE #0\nBCS #0\nBCC #0\nBMI #0\nBPL #0\nBVS #0\nBVC #0\nBHI #0\nBLS #0\nBGE #0\nBLT #0\nBGT #0\nBLE #0\nBAL #0", expected: []string{"BEQ #0x000000", "BNE #0x000000", "BCS #0x000000", "BCC #0x000000", "BMI #0x000000", "BPL #0x000000", "BVS #0x000000", "BVC #0x000000", "BHI #0x000000", "BLS #0x000000", "BGE #0x000000", "BLT #0x000000", "BGT #0x000000", "BLE #0x000000", "B #0x000000"}},
+		{name: "LDR bare", input: "LDR R3, [R2]", expected: []string{"LDR R3, [R2]"}},
+		{name: "LDR with writeback", input: "LDR R6, [sp]!, #4", expected: []string{"LDR R6, [SP]!, #0x4"}},
+		{name: "STR post indexed", input: "STR R5, [sp], #4", expected: []string{"STR R5, [SP], #0x4"}},
+		{name: "LDR with offset", input: "LDR R3, [R2, #8]", expected: []string{"LDR R3, [R2, #0x8]"}},
+		{name: "STR with offset and writeback", input: "STR R5, [sp, #4]!", expected: []string{"STR R5, [SP, #0x4]!"}},
+		{name: "STM", input: "STMEA sp!, {R0-R12, lr}", expected: []string{"STMEA SP!, {R0-R12, LR}"}},
+		{name: "LDM with condition", input: "LDMEQ R1, {R0, R2}", expected: []string{"LDMEQ R1, {R0, R2}"}},
+		{name: "LDM exception return", input: "LDMEA sp!, {R0-R3, pc}^", expected: []string{"LDMEA SP!, {R0-R3, PC}^"}},
+		{name: "STM user bank", input: "STMEA R0, {sp, lr}^", expected: []string{"STMEA R0, {SP, LR}^"}},
+		{name: "branch to label", input: "loop:\nBPL loop", expected: []string{"BPL loop"}},
+		{name: "branch with offset", input: "BL #0xFFFFFE", expected: []string{"BL #0xFFFFFE"}},
+		{name: "BX", input: "BX lr", expected: []string{"BX LR"}},
+		{name: "BLX register", input: "blxeq r12", expected: []string{"BLXEQ R12"}},
+		{name: "BLX offset", input: "BLX #0x6", expected: []string{"BLX #0x0000006"}},
+		{name: "BLX label", input: "BLX thumb\n.thumb_func\nthumb:", expected: []string{"BLX thumb"}},
+		{name: "SWI", input: "SWIEQ #0x123456", expected: []string{"SVCEQ #0x123456"}},
+		{name: "MRS", input: "mrsmi r2, apsr", expected: []string{"MRSMI R2, CPSR"}},
+		{name: "MSR without fields", input: "MSR spsr, r1", expected: []string{"MSR SPSR_fc, R1"}},
+		{name: "MSR APSR", input: "MSR APSR_nzcvqg, #0xF0000000", expected: []string{"MSR CPSR_fs, #0xF0000000"}},
+		{name: "CPSIE", input: "CPSIE fa, #16", expected: []string{"CPSIE af, #0x10"}},
+		{name: "CPS", input: "cps #0x13", expected: []string{"CPS #0x13"}},
+		{name: "SETEND", input: "SETEND be", expected: []string{"SETEND BE"}},
+		{name: "MRC by name", input: "MRC R0, MPIDR", expected: []string{"MRC p15, 0, R0, c0, c0, 5"}},
+		{name: "MRRC", input: "mrrceq r0, r1, cntpct", expected: []string{"MRRCEQ p15, 0, R0, R1, c14"}},
+		{name: "DMB default option", input: "dmb", expected: []string{"DMB SY"}},
+		{name: "DSB old name", input: "DSB UNST", expected: []string{"DSB NSHST"}},
+		{name: "ISB reserved option", input: "ISB #0", expected: []string{"ISB #0x0"}},
+		{name: "CLREX", input: "clrex", expected: []string{"CLREX"}},
+		{name: "WFE with condition", input: "wfeeq", expected: []string{"WFEEQ"}},
+		{name: "LDREX", input: "ldrex r0, [sp, #0]", expected: []string{"LDREX R0, [SP]"}},
+		{name: "STREX", input: "STREXNE R1, R2, [R3]", expected: []string{"STREXNE R1, R2, [R3]"}},
+		{name: "VADD", input: "vaddeq.f32 s0, s1, s2", expected: []string{"VADDEQ.F32 S0, S1, S2"}},
+		{name: "VMOV without a data type", input: "vmov d0, d1", expected: []string{"VMOV.F64 D0, D1"}},
+		{name: "VMOV to ARM registers", input: "vmov r0, r1, d2", expected: []string{"VMOV R0, R1, D2"}},
+		{name: "VCVT", input: "vcvt.s32.f64 s0, d1", expected: []string{"VCVT.S32.F64 S0, D1"}},
+		{name: "VMRS flags", input: "vmrs apsr_nzcv, fpscr", expected: []string{"VMRS APSR_nzcv, FPSCR"}},
+		{name: "VLDR without an offset", input: "vldr.64 d0, [r1, #0]", expected: []string{"VLDR D0, [R1]"}},
+		{name: "NEON VSUB", input: "vsub.i16 q0, q1, q2", expected: []string{"VSUB.I16 Q0, Q1, Q2"}},
+		{name: "VLD1 with a typed size", input: "vld1.f32 {d0, d1, d2, d3}, [r0]!", expected: []string{"VLD1.32 {D0-D3}, [R0]!"}},
+		{name: "words", input: ".extern table\n.word 0x10, table", expected: []string{".word 0x00000010", ".word table"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toks, err := lexer.NewLexer(c.input).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error tokenizing: %v", err)
+			}
+			instructions, _, err := NewParser(toks).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+			if len(instructions) != len(c.expected) {
+				t.Fatalf("expected %d instructions, got %d", len(c.expected), len(instructions))
+			}
+			for i, instruction := range instructions {
+				if instruction.String() != c.expected[i] {
+					t.Errorf("instruction %d: expected %q, got %q", i, c.expected[i], instruction.String())
+				}
+			}
+		})
+	}
+}
+
+func TestParserDecodeErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		word  uint32
+		err   string
+		fails types.Instruction
+		other bool // Another instruction type decodes the word
+	}{
+		{name: "unsupported condition", word: 0xF3004000, err: "unsupported condition", fails: &InstructionMOV{}},
+		{name: "not a MOV", word: 0xE2555001, err: "isn't a MOVW or MOVT", fails: &InstructionMOV{}, other: true},
+		{name: "unsupported opcode", word: 0xE3A00001, err: "unsupported data processing opcode", fails: &InstructionArithmetic{}},
+		{name: "non-canonical immediate", word: 0xE2811F01, err: "non-canonical immediate", fails: &InstructionArithmetic{}},
+		{name: "register offset", word: 0xE7923001, err: "register offsets and byte transfers", fails: &InstructionMemory{}},
+		{name: "unsupported LDR mode", word: 0xE4923004, err: "unsupported LDR addressing mode", fails: &InstructionMemory{}},
+		{name: "unsupported LDM mode", word: 0xE9FD1FFF, err: "unsupported LDM addressing mode", fails: &InstructionMemoryMultiple{}},
+		{name: "user bank LDM writeback", word: 0xE97D1FFF, err: "writeback can't be used", fails: &InstructionMemoryMultiple{}},
+		{name: "unsupported STM mode", word: 0xE92D1FFF, err: "unsupported STM addressing mode", fails: &InstructionMemoryMultiple{}},
+		{name: "not a branch", word: 0xE12FFF1E, err: "isn't a branch", fails: &InstructionBranch{}, other: true},
+		{name: "not a BX", word: 0xE12FFF2E, err: "isn't a BX", fails: &InstructionBranchExchange{}},
+		{name: "BLX to the PC", word: 0xE12FFF3F, err: "branches to the PC", fails: &InstructionBranchExchange{}},
+		{name: "not an SVC", word: 0xEE100FB0, err: "isn't an SVC", fails: &InstructionSupervisorCall{}, other: true},
+		{name: "unconditional SVC space", word: 0xFF000000, err: "unsupported condition", fails: &InstructionSupervisorCall{}},
+		{name: "MSR without fields", word: 0xE120F000, err: "isn't an MRS or MSR", fails: &InstructionStatus{}},
+		{name: "MSR non-canonical immediate", word: 0xE321FF01, err: "non-canonical immediate", fails: &InstructionStatus{}},
+		{name: "unconditional MRS", word: 0xF10F0000, err: "unsupported condition", fails: &InstructionStatus{}},
+		{name: "reserved CPS imod", word: 0xF1040080, err: "reserved CPS imod", fails: &InstructionChangeState{}},
+		{name: "CPS without masks", word: 0xF1080000, err: "no interrupt masks", fails: &InstructionChangeState{}},
+		{name: "CPS changing nothing", word: 0xF1000000, err: "changes nothing", fails: &InstructionChangeState{}},
+		{name: "other coprocessor", word: 0xEE100E10, err: "unsupported coprocessor p14", fails: &InstructionCoprocessor{}},
+		{name: "unknown CP15 register", word: 0xEE190F19, err: "unknown CP15 register", fails: &InstructionCoprocessor{}},
+		{name: "MCR to a read only register", word: 0xEE000F10, err: "MIDR is read only", fails: &InstructionCoprocessor{}},
+		{name: "MRC to the PC", word: 0xEE10FFB0, err: "with the PC", fails: &InstructionCoprocessor{}},
+		{name: "coprocessor data operation", word: 0xEE100F00, err: "isn't a coprocessor register transfer", fails: &InstructionCoprocessor{}},
+		{name: "not a barrier", word: 0xF57FF070, err: "isn't a barrier", fails: &InstructionBarrier{}},
+		{name: "CLREX with an option", word: 0xF57FF01E, err: "isn't a barrier", fails: &InstructionBarrier{}},
+		{name: "unknown hint", word: 0xE320F005, err: "isn't a hint", fails: &InstructionHint{}},
+		{name: "unconditional hint", word: 0xF320F000, err: "unsupported condition", fails: &InstructionHint{}},
+		{name: "not an exclusive", word: 0xE1910F9E, err: "isn't an LDREX or STREX", fails: &InstructionExclusive{}},
+		{name: "LDREX into the PC", word: 0xE191FF9F, err: "uses the PC", fails: &InstructionExclusive{}},
+		{name: "STREX status is the value", word: 0xE1842F92, err: "can't also be the transfer register", fails: &InstructionExclusive{}},
+		{name: "STREX status is the base", word: 0xE1844F93, err: "can't also be the base register", fails: &InstructionExclusive{}},
+		{name: "not a media instruction", word: 0xE6BF0F70, err: "isn't a media instruction", fails: &InstructionMedia{}},
+		{name: "REV of the PC", word: 0xE6BF0F3F, err: "uses the PC", fails: &InstructionMedia{}},
+		{name: "BFC", word: 0xE7C3001F, err: "uses the PC", fails: &InstructionMedia{}},
+		{name: "BFI msb below lsb", word: 0xE7C00211, err: "msb below its lsb", fails: &InstructionMedia{}},
+		{name: "UBFX past bit 31", word: 0xE7FF0FD1, err: "past bit 31", fails: &InstructionMedia{}},
+		{name: "VFP multiply accumulate", word: 0xEE000A00, err: "isn't a VFP data processing instruction", fails: &InstructionFloat{}},
+		{name: "VABS", word: 0xEEB00AC0, err: "isn't a VMOV or VCVT", fails: &InstructionFloat{}},
+		{name: "not a VMOV transfer", word: 0xEE000A30, err: "isn't a VMOV to or from ARM registers", fails: &InstructionFloatTransfer{}},
+		{name: "VMOV to the PC", word: 0xEE10FA10, err: "uses the PC", fails: &InstructionFloatTransfer{}},
+		{name: "VMOV into one register twice", word: 0xEC533B15, err: "both halves into one register", fails: &InstructionFloatTransfer{}},
+		{name: "VMRS of MVFR0", word: 0xEEF70A10, err: "unsupported system register", fails: &InstructionFloatSystem{}},
+		{name: "VMSR from the PC", word: 0xEEE1FA10, err: "uses the PC", fails: &InstructionFloatSystem{}},
+		{name: "VLDR with a negative offset", word: 0xED110A02, err: "subtracts its offset", fails: &InstructionFloatMemory{}},
+		{name: "NEON VMUL", word: 0xF2010912, err: "isn't a NEON VADD or VSUB", fails: &InstructionVector{}},
+		{name: "odd Q register", word: 0xF2200841, err: "half a Q register", fails: &InstructionVector{}},
+		{name: "VLD2", word: 0xF421080F, err: "of whole registers", fails: &InstructionVectorMemory{}},
+		{name: "aligned VLD1", word: 0xF421071F, err: "alignment", fails: &InstructionVectorMemory{}},
+		{name: "VLD1 past D31", word: 0xF461F28F, err: "past D31", fails: &InstructionVectorMemory{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.fails.Decode(c.word)
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error containing %q, got %v", c.err, err)
+			}
+			if _, err := Decode(c.word); (err == nil) != c.other {
+				t.Errorf("expected Decode error for 0x%08X to be %v, got %v", c.word, !c.other, err)
+			}
+		})
+	}
+}
+
+// randomInstruction generates an instruction the parser could have produced, with every field in range.
+func randomInstruction(rng *rand.Rand) types.Instruction {
+	var conditions []types.ConditionType
+	for _, condition := range types.LiteralToCondition {
+		conditions = append(conditions, condition)
+	}
+	sort.Slice(conditions, func(i, j int) bool { return conditions[i] < conditions[j] })
+	condition := conditions[rng.Intn(len(conditions))]
+	reg := func() uint32 { return uint32(rng.Intn(16)) }
+	pick := func(mnemonics ...types.MnemonicType) types.MnemonicType { return mnemonics[rng.Intn(len(mnemonics))] }
+
+	switch rng.Intn(21) {
+	case 0:
+		return &InstructionMOV{Mnemonic: pick(types.MnemonicMOVW, types.MnemonicMOVT), Condition: condition, DestRegister: reg(), Immediate: uint32(rng.Intn(0x10000))}
+	case 1:
+		return &InstructionArithmetic{
+			Mnemonic:     pick(types.MnemonicADD, types.MnemonicSUB, types.MnemonicAND, types.MnemonicORR),
+			Condition:    condition,
+			DestRegister: reg(),
+			BaseRegister: reg(),
+			Immediate:    bits.RotateLeft32(uint32(rng.Intn(0x100)), -2*rng.Intn(16)),
+			SBit:         uint32(rng.Intn(2)),
+		}
+	case 2:
+		instruction := &InstructionMemory{Mnemonic: pick(types.MnemonicLDR, types.MnemonicSTR), Condition: condition, DestRegister: reg(), BaseRegister: reg()}
+		instruction.WBit, instruction.Offset = uint32(rng.Intn(2)), uint32(rng.Intn(0x1000))
+		switch {
+		case rng.Intn(2) == 0:
+			instruction.PBit, instruction.UBit = 1, 1 // [Rn, #imm]
+		case instruction.Mnemonic == types.MnemonicLDR:
+			instruction.PBit = 1
+		default:
+			instruction.UBit, instruction.WBit = 1, 0 // Post indexed always writes back
+		}
+		return instruction
+	case 3:
+		instruction := &InstructionMemoryMultiple{Mnemonic: pick(types.MnemonicLDM, types.MnemonicSTM), Condition: condition, BaseRegister: reg(), WBit: uint32(rng.Intn(2)), Offset: uint32(rng.Intn(0x10000))}
+		if instruction.Mnemonic == types.MnemonicLDM {
+			instruction.PBit = 1
+		} else {
+			instruction.UBit = 1
+		}
+		if checkUserBank(instruction.Mnemonic, instruction.WBit, instruction.Offset) == nil {
+			instruction.SBit = uint32(rng.Intn(2))
+		}
+		return instruction
+	case 4:
+		instruction := &InstructionBranch{Mnemonic: pick(types.MnemonicB, types.MnemonicBL, types.MnemonicBLX), Condition: condition, Offset: uint32(rng.Intn(0x1000000))}
+		switch instruction.Mnemonic {
+		case types.MnemonicBL:
+			instruction.LBit = 1
+		case types.MnemonicBLX:
+			instruction.Condition, instruction.LBit, instruction.HBit = types.ConditionAL, 1, uint32(rng.Intn(2))
+		}
+		return instruction
+	case 5:
+		if rng.Intn(2) == 0 {
+			return &InstructionBranchExchange{Mnemonic: types.MnemonicBLX, Condition: condition, BaseRegister: uint32(rng.Intn(15))}
+		}
+		return &InstructionBranchExchange{Mnemonic: types.MnemonicBX, Condition: condition, BaseRegister: reg()}
+	case 6:
+		return &InstructionSupervisorCall{Mnemonic: types.MnemonicSVC, Condition: condition, Immediate: uint32(rng.Intn(0x1000000))}
+	case 7:
+		instruction := &InstructionStatus{Mnemonic: pick(types.MnemonicMRS, types.MnemonicMSR), Condition: condition, SPSR: uint32(rng.Intn(2)), Register: uint32(rng.Intn(15))}
+		if instruction.Mnemonic == types.MnemonicMSR {
+			instruction.Fields = uint32(1 + rng.Intn(15))
+			if rng.Intn(2) == 0 {
+				instruction.Register, instruction.IBit = 0, 1
+				instruction.Immediate = bits.RotateLeft32(uint32(rng.Intn(0x100)), -2*rng.Intn(16))
+			}
+		}
+		return instruction
+	case 8:
+		if rng.Intn(4) == 0 {
+			return &InstructionChangeState{Mnemonic: types.MnemonicSETEND, BigEndian: uint32(rng.Intn(2))}
+		}
+		instruction := &InstructionChangeState{Mnemonic: types.MnemonicCPS, IMod: []uint32{0, 0b10, 0b11}[rng.Intn(3)], ChangeMode: 1}
+		if instruction.IMod != 0 {
+			instruction.Flags = uint32(1+rng.Intn(7)) << 6
+			instruction.ChangeMode = uint32(rng.Intn(2))
+		}
+		if instruction.ChangeMode == 1 {
+			instruction.Mode = uint32(rng.Intn(32))
+		}
+		return instruction
+	case 9:
+		register := CP15Registers[rng.Intn(len(CP15Registers))]
+		instruction := &InstructionCoprocessor{Condition: condition, Coprocessor: 15, Opcode1: register.Opcode1, Register: uint32(rng.Intn(15)), CRn: register.CRn, CRm: register.CRm, Opcode2: register.Opcode2}
+		read := register.Access == CP15ReadOnly || register.Access == CP15ReadWrite && rng.Intn(2) == 0
+		switch {
+		case register.Wide && read:
+			instruction.Mnemonic, instruction.Register2 = types.MnemonicMRRC, (instruction.Register+1+uint32(rng.Intn(14)))%15
+		case register.Wide:
+			instruction.Mnemonic, instruction.Register2 = types.MnemonicMCRR, uint32(rng.Intn(15))
+		case read:
+			instruction.Mnemonic = types.MnemonicMRC
+		default:
+			instruction.Mnemonic = types.MnemonicMCR
+		}
+		return instruction
+	case 10:
+		mnemonic := pick(types.MnemonicDMB, types.MnemonicDSB, types.MnemonicISB, types.MnemonicCLREX)
+		if mnemonic == types.MnemonicCLREX {
+			return &InstructionBarrier{Mnemonic: mnemonic}
+		}
+		return &InstructionBarrier{Mnemonic: mnemonic, Option: uint32(rng.Intn(16))}
+	case 11:
+		return &InstructionHint{Mnemonic: pick(types.MnemonicNOP, types.MnemonicYIELD, types.MnemonicWFE, types.MnemonicWFI, types.MnemonicSEV), Condition: condition}
+	case 12:
+		instruction := &InstructionExclusive{Mnemonic: pick(types.MnemonicLDREX, types.MnemonicSTREX), Condition: condition, DestRegister: uint32(rng.Intn(15)), BaseRegister: uint32(rng.Intn(15))}
+		if instruction.Mnemonic == types.MnemonicSTREX {
+			for instruction.StatusRegister = uint32(rng.Intn(15)); instruction.StatusRegister == instruction.DestRegister || instruction.StatusRegister == instruction.BaseRegister; {
+				instruction.StatusRegister = uint32(rng.Intn(15))
+			}
+		}
+		return instruction
+	case 13:
+		instruction := &InstructionMedia{Mnemonic: pick(types.MnemonicCLZ, types.MnemonicREV, types.MnemonicREV16, types.MnemonicUXTB, types.MnemonicUXTH, types.MnemonicSXTB, types.MnemonicSXTH, types.MnemonicUBFX, types.MnemonicSBFX, types.MnemonicBFI, types.MnemonicSDIV, types.MnemonicUDIV), Condition: condition, DestRegister: uint32(rng.Intn(15))}
+		switch instruction.Mnemonic {
+		case types.MnemonicUBFX, types.MnemonicSBFX, types.MnemonicBFI:
+			instruction.BaseRegister = uint32(rng.Intn(15))
+			instruction.LSB = uint32(rng.Intn(32))
+			instruction.Width = uint32(rng.Intn(32-int(instruction.LSB))) + 1
+		case types.MnemonicSDIV, types.MnemonicUDIV:
+			instruction.BaseRegister, instruction.OperandRegister = uint32(rng.Intn(15)), uint32(rng.Intn(15))
+		default:
+			instruction.OperandRegister = uint32(rng.Intn(15))
+			if isExtend(instruction.Mnemonic) {
+				instruction.Rotation = uint32(rng.Intn(4)) * 8
+			}
+		}
+		return instruction
+	case 14:
+		instruction := &InstructionFloat{Mnemonic: pick(types.MnemonicVADD, types.MnemonicVSUB, types.MnemonicVMUL, types.MnemonicVDIV, types.MnemonicVMOV), Condition: condition, DataType: types.DataTypeF32}
+		if rng.Intn(2) == 0 {
+			instruction.DataType = types.DataTypeF64
+		}
+		if instruction.Mnemonic != types.MnemonicVMOV {
+			instruction.FirstRegister = uint32(rng.Intn(32))
+		}
+		instruction.DestRegister, instruction.SecondRegister = uint32(rng.Intn(32)), uint32(rng.Intn(32))
+		return instruction
+	case 15:
+		var conversions [][2]types.DataType
+		for conversion := range floatConversions {
+			conversions = append(conversions, conversion)
+		}
+		sort.Slice(conversions, func(i, j int) bool { return floatConversions[conversions[i]] < floatConversions[conversions[j]] })
+		conversion := conversions[rng.Intn(len(conversions))]
+		return &InstructionFloat{Mnemonic: types.MnemonicVCVT, Condition: condition, DataType: conversion[0], SourceType: conversion[1], DestRegister: uint32(rng.Intn(32)), SecondRegister: uint32(rng.Intn(32))}
+	case 16:
+		instruction := &InstructionFloatTransfer{Mnemonic: types.MnemonicVMOV, Condition: condition, ToARM: uint32(rng.Intn(2)), Double: rng.Intn(2) == 0, FPRegister: uint32(rng.Intn(32)), Register: uint32(rng.Intn(15))}
+		if instruction.Double {
+			for instruction.Register2 = uint32(rng.Intn(15)); instruction.ToARM == 1 && instruction.Register2 == instruction.Register; {
+				instruction.Register2 = uint32(rng.Intn(15))
+			}
+		}
+		return instruction
+	case 17:
+		instruction := &InstructionFloatSystem{Mnemonic: pick(types.MnemonicVMRS, types.MnemonicVMSR), Condition: condition, Register: uint32(rng.Intn(15)), System: []uint32{FPSID, FPSCR, FPEXC}[rng.Intn(3)]}
+		if instruction.Mnemonic == types.MnemonicVMRS && instruction.System == FPSCR && rng.Intn(4) == 0 {
+			instruction.Register = 15
+		}
+		return instruction
+	case 18:
+		return &InstructionFloatMemory{Mnemonic: pick(types.MnemonicVLDR, types.MnemonicVSTR), Condition: condition, Double: rng.Intn(2) == 0, FPRegister: uint32(rng.Intn(32)), BaseRegister: reg(), Offset: uint32(rng.Intn(256)) * 4}
+	case 19:
+		instruction := &InstructionVector{Mnemonic: pick(types.MnemonicVADD, types.MnemonicVSUB), DataType: types.DataType{Kind: 'I', Size: 8 << rng.Intn(4)}, Quad: rng.Intn(2) == 0}
+		count := 32
+		if instruction.Quad {
+			count = 16
+		}
+		instruction.DestRegister, instruction.FirstRegister, instruction.SecondRegister = uint32(rng.Intn(count)), uint32(rng.Intn(count)), uint32(rng.Intn(count))
+		return instruction
+	case 20:
+		instruction := &InstructionVectorMemory{Mnemonic: pick(types.MnemonicVLD1, types.MnemonicVST1), DataType: types.DataType{Size: 8 << rng.Intn(4)}, Count: uint32(1 + rng.Intn(4)), BaseRegister: uint32(rng.Intn(15)), IndexRegister: reg()}
+		instruction.FirstRegister = uint32(rng.Intn(33 - int(instruction.Count)))
+		return instruction
+	default:
+		return &InstructionWord{Value: rng.Uint32()}
+	}
+}
+
+func TestParserDecodeRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 5000; run++ {
+		instruction := randomInstruction(rng)
+		code, err := instruction.ToMachineCode(nil)
+		if err != nil {
+			t.Fatalf("unexpected error encoding %s: %v", instruction, err)
+		}
+		word := uint32(code[0]) | uint32(code[1])<<8 | uint32(code[2])<<16 | uint32(code[3])<<24
+
+		// decode(encode(x)) == x
+		decoded := reflect.New(reflect.TypeOf(instruction).Elem()).Interface().(types.Instruction)
+		if err := decoded.Decode(word); err != nil {
+			t.Fatalf("unexpected error decoding %s (0x%08X): %v", instruction, word, err)
+		}
+		if !reflect.DeepEqual(decoded, instruction) {
+			t.Fatalf("decode(encode(x)) != x for 0x%08X\nexpected: %+v\ngot:      %+v", word, instruction, decoded)
+		}
+
+		// Decode picks the right type, except for words which can hold anything
+		if _, isWord := instruction.(*InstructionWord); !isWord {
+			if found, err := Decode(word); err != nil || !reflect.DeepEqual(found, instruction) {
+				t.Fatalf("Decode(0x%08X) = %+v, %v, expected %+v", word, found, err, instruction)
+			}
+		}
+
+		// The printed source parses back into the same word
+		toks, err := lexer.NewLexer(instruction.String()).Tokenize()
+		if err != nil {
+			t.Fatalf("unexpected error tokenizing %q: %v", instruction, err)
+		}
+		parsed, _, err := NewParser(toks).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", instruction, err)
+		}
+		if len(parsed) != 1 {
+			t.Fatalf("expected %q to parse into 1 instruction, got %d", instruction, len(parsed))
+		}
+		reassembled, err := parsed[0].ToMachineCode(nil)
+		if err != nil {
+			t.Fatalf("unexpected error encoding %q: %v", instruction, err)
+		}
+		if !bytes.Equal(reassembled, code) {
+			t.Fatalf("%q assembled to %X, expected %X", instruction, reassembled, code)
+		}
+	}
+}
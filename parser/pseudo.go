@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// parsePseudo parses a pseudo-instruction into the real instructions it stands for. Every instruction in the expansion
+// keeps the pseudo-instruction's mnemonic token, so they can be traced back to it (see IsPseudo).
+func (p *Parser) parsePseudo() ([]types.Instruction, error) {
+	switch p.current().Type {
+	case types.TokenMOV32:
+		return p.parseMOV32()
+	default:
+		return nil, fmt.Errorf("unknown pseudo-instruction %s", p.current().Literal)
+	}
+}
+
+// IsPseudo reports whether an instruction was expanded from a pseudo-instruction.
+func IsPseudo(instruction types.Instruction) bool {
+	return types.MnemonicTokenToCategory[instruction.SourceToken().Type] == types.MnemonicCategoryPseudo
+}
+
+// parseMOV32 parses MOV32 Rd, #imm32 or MOV32 Rd, label into a MOVW of the bottom half and a MOVT of the top half.
+func (p *Parser) parseMOV32() ([]types.Instruction, error) {
+	token := p.current()
+	condition := types.ConditionAL
+	if suffix := strings.ToLower(token.Literal[len("mov32"):]); suffix != "" {
+		var ok bool
+		if condition, ok = types.LiteralToCondition[suffix]; !ok {
+			return nil, fmt.Errorf("invalid MOV32 condition: %s", suffix)
+		}
+	}
+	p.consume() // consume MOV32 token
+
+	// Register
+	if p.current().Type != types.TokenRegister {
+		return nil, fmt.Errorf("expected register after MOV32, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	reg, err := utils.ParseRegister(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing register: %w", err)
+	}
+	p.consume() // consume register token
+
+	// Comma
+	if p.current().Type != types.TokenComma {
+		return nil, fmt.Errorf("expected comma after register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume comma token
+
+	low := &InstructionMOV{Token: token, Mnemonic: types.MnemonicMOVW, Condition: condition, DestRegister: reg}
+	high := &InstructionMOV{Token: token, Mnemonic: types.MnemonicMOVT, Condition: condition, DestRegister: reg}
+
+	switch p.current().Type {
+	case types.TokenImmediate:
+		immediate, err := utils.ParseImmediate(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing immediate value: %w", err)
+		}
+		low.Immediate = immediate & 0xFFFF
+		high.Immediate = immediate >> 16
+	case types.TokenIdentifier:
+		low.Label = p.current()
+		high.Label = p.current()
+	default:
+		return nil, fmt.Errorf("expected immediate value or label after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume immediate or label token
+
+	return []types.Instruction{low, high}, nil
+}
@@ -0,0 +1,450 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// MSR field mask bits, each selecting a byte of the status register
+const (
+	FieldControl   = 1 << 0 // _c, bits 0-7: mode, interrupt masks and T
+	FieldExtension = 1 << 1 // _x, bits 8-15: A and E
+	FieldStatus    = 1 << 2 // _s, bits 16-23: GE
+	FieldFlags     = 1 << 3 // _f, bits 24-31: NZCVQ
+)
+
+// fieldLetters are the field suffix letters in the order they're printed.
+var fieldLetters = []struct {
+	letter byte
+	field  uint32
+}{{'f', FieldFlags}, {'s', FieldStatus}, {'x', FieldExtension}, {'c', FieldControl}}
+
+// apsrFields are the UAL names of the fields of the APSR, the user mode view of the CPSR.
+var apsrFields = map[string]uint32{
+	"nzcvq":  FieldFlags,
+	"g":      FieldStatus,
+	"nzcvqg": FieldFlags | FieldStatus,
+}
+
+type InstructionStatus struct {
+	Mnemonic  types.MnemonicType
+	Condition types.ConditionType
+	Register  uint32      // Rd of MRS, Rn of MSR with a register operand
+	SPSR      uint32      // R bit, 1 for the SPSR of the current mode and 0 for the CPSR
+	Fields    uint32      // Fields MSR writes
+	IBit      uint32      // MSR with an immediate operand
+	Immediate uint32      // The operand value, encoded as an 8 bit value rotated right by an even amount
+	Token     types.Token // Mnemonic token the instruction was parsed from
+}
+
+type InstructionChangeState struct {
+	Mnemonic   types.MnemonicType
+	IMod       uint32 // 0b10 clears the masks in Flags (CPSIE), 0b11 sets them (CPSID), 0 leaves them alone
+	Flags      uint32 // A, I and F masks in bits 8, 7 and 6, like in the CPSR
+	ChangeMode uint32 // M bit, switch to Mode
+	Mode       uint32
+	BigEndian  uint32      // SETEND BE
+	Token      types.Token // Mnemonic token the instruction was parsed from
+}
+
+func (p *Parser) parseStatus() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryStatus {
+		return nil, fmt.Errorf("wrong instruction type! expected status register mnemonic, got %s", p.current().Literal)
+	}
+
+	// Get condition code
+	condition, err := utils.ParseStatusSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing status register suffixes: %w", err)
+	}
+	p.consume() // consume MRS or MSR token
+
+	instruction := &InstructionStatus{Token: token, Mnemonic: mnemonic, Condition: condition}
+	if mnemonic == types.MnemonicMRS {
+		// Destination register
+		if p.current().Type != types.TokenRegister {
+			return nil, fmt.Errorf("expected register after MRS, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		reg, err := utils.ParseRegister(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing destination register: %w", err)
+		}
+		if reg == 15 {
+			return nil, fmt.Errorf("MRS can't write the PC at line %d, col %d", p.current().Line, p.current().Col)
+		}
+		instruction.Register = reg
+		p.consume() // consume destination register token
+
+		// Comma
+		if p.current().Type != types.TokenComma {
+			return nil, fmt.Errorf("expected comma after destination register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		p.consume() // consume comma token
+
+		// Status register, without fields
+		if p.current().Type != types.TokenIdentifier || strings.Contains(p.current().Literal, "_") {
+			return nil, fmt.Errorf("expected CPSR, SPSR or APSR after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		instruction.SPSR, _, err = parseStatusRegister(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("%w at line %d, col %d", err, p.current().Line, p.current().Col)
+		}
+		p.consume() // consume status register token
+		return instruction, nil
+	}
+
+	// Status register and fields
+	if p.current().Type != types.TokenIdentifier {
+		return nil, fmt.Errorf("expected status register after MSR, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	instruction.SPSR, instruction.Fields, err = parseStatusRegister(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("%w at line %d, col %d", err, p.current().Line, p.current().Col)
+	}
+	if instruction.Fields == 0 {
+		return nil, fmt.Errorf("expected APSR_nzcvq, APSR_g or APSR_nzcvqg, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume status register token
+
+	// Comma
+	if p.current().Type != types.TokenComma {
+		return nil, fmt.Errorf("expected comma after status register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume comma token
+
+	// Register or immediate
+	switch p.current().Type {
+	case types.TokenRegister:
+		reg, err := utils.ParseRegister(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing source register: %w", err)
+		}
+		if reg == 15 {
+			return nil, fmt.Errorf("MSR can't read the PC at line %d, col %d", p.current().Line, p.current().Col)
+		}
+		instruction.Register = reg
+	case types.TokenImmediate:
+		immediate, err := utils.ParseImmediate(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing immediate value: %w", err)
+		}
+		if _, ok := encodeImmediate(immediate); !ok {
+			return nil, fmt.Errorf("immediate value %s can't be encoded as an 8 bit value rotated by an even amount at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		instruction.IBit, instruction.Immediate = 1, immediate
+	default:
+		return nil, fmt.Errorf("expected register or immediate value after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume register or immediate token
+
+	return instruction, nil
+}
+
+// parseStatusRegister parses CPSR or SPSR with an optional underscore and fields in any order, e.g. cpsr_fc, or
+// APSR with its UAL field names, which MSR needs. Without fields MSR writes the flags and control fields, like it does in GNU as.
+func parseStatusRegister(literal string) (spsr uint32, fields uint32, err error) {
+	name, suffix, hasFields := strings.Cut(strings.ToLower(literal), "_")
+	switch name {
+	case "cpsr":
+	case "spsr":
+		spsr = 1
+	case "apsr":
+		if !hasFields {
+			return 0, 0, nil // Only valid for MRS, MSR to the APSR names its fields
+		}
+		fields, ok := apsrFields[suffix]
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid APSR fields %q, expected nzcvq, g or nzcvqg", suffix)
+		}
+		return 0, fields, nil
+	default:
+		return 0, 0, fmt.Errorf("expected CPSR, SPSR or APSR, got %s", literal)
+	}
+	if !hasFields {
+		return spsr, FieldFlags | FieldControl, nil
+	}
+	if suffix == "" {
+		return 0, 0, fmt.Errorf("missing fields after %s_", name)
+	}
+	for n := 0; n < len(suffix); n++ {
+		field := uint32(0)
+		for _, f := range fieldLetters {
+			if f.letter == suffix[n] {
+				field = f.field
+			}
+		}
+		if field == 0 {
+			return 0, 0, fmt.Errorf("invalid field %q in %s, expected f, s, x or c", suffix[n], literal)
+		}
+		if fields&field != 0 {
+			return 0, 0, fmt.Errorf("field %q given twice in %s", suffix[n], literal)
+		}
+		fields |= field
+	}
+	return spsr, fields, nil
+}
+
+func (p *Parser) parseChangeState() (types.Instruction, error) {
+	// Mnemonic, which can't be conditional. Missing operands are reported at its position, as there may be nothing after it
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryChangeState {
+		return nil, fmt.Errorf("wrong instruction type! expected CPS or SETEND, got %s", p.current().Literal)
+	}
+	instruction := &InstructionChangeState{Token: token, Mnemonic: mnemonic}
+	switch strings.ToLower(p.current().Literal) {
+	case "setend", "cps":
+	case "cpsie":
+		instruction.IMod = 0b10
+	case "cpsid":
+		instruction.IMod = 0b11
+	default:
+		return nil, fmt.Errorf("%s can't have a condition or suffix", p.current().Literal)
+	}
+	p.consume() // consume CPS or SETEND token
+
+	if mnemonic == types.MnemonicSETEND {
+		// Endianness
+		switch strings.ToLower(p.current().Literal) {
+		case "be":
+			instruction.BigEndian = 1
+		case "le":
+		default:
+			return nil, fmt.Errorf("expected BE or LE after SETEND at line %d, col %d, got %s", token.Line, token.Col, p.current().Literal)
+		}
+		p.consume() // consume endianness token
+		return instruction, nil
+	}
+
+	if instruction.IMod != 0 {
+		// Interrupt masks
+		if p.current().Type != types.TokenIdentifier {
+			return nil, fmt.Errorf("expected some of a, i and f after %s at line %d, col %d, got %s", token.Literal, token.Line, token.Col, p.current().Literal)
+		}
+		flags, err := parseInterruptFlags(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("%w at line %d, col %d", err, p.current().Line, p.current().Col)
+		}
+		instruction.Flags = flags
+		p.consume() // consume interrupt masks token
+
+		// The mode is optional
+		if p.current().Type != types.TokenComma {
+			return instruction, nil
+		}
+		p.consume() // consume comma token
+	}
+
+	// Mode
+	if p.current().Type != types.TokenImmediate {
+		return nil, fmt.Errorf("expected mode immediate after %s at line %d, col %d, got %s", token.Literal, token.Line, token.Col, p.current().Literal)
+	}
+	mode, err := utils.ParseImmediate(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing mode: %w", err)
+	}
+	if mode > 0x1F {
+		return nil, fmt.Errorf("mode 0x%X doesn't fit in 5 bits at line %d, col %d", mode, p.current().Line, p.current().Col)
+	}
+	instruction.ChangeMode, instruction.Mode = 1, mode
+	p.consume() // consume mode token
+
+	return instruction, nil
+}
+
+// interruptFlags are the CPS interrupt mask letters and their CPSR bits, in the order they're printed.
+var interruptFlags = []struct {
+	letter byte
+	bit    uint32
+}{{'a', 1 << 8}, {'i', 1 << 7}, {'f', 1 << 6}}
+
+// parseInterruptFlags parses the interrupt masks CPSIE and CPSID change, some of a, i and f in any order.
+func parseInterruptFlags(literal string) (uint32, error) {
+	literal = strings.ToLower(literal)
+	var flags uint32
+	for n := 0; n < len(literal); n++ {
+		bit := uint32(0)
+		for _, f := range interruptFlags {
+			if f.letter == literal[n] {
+				bit = f.bit
+			}
+		}
+		if bit == 0 {
+			return 0, fmt.Errorf("invalid interrupt mask %q, expected a, i or f", literal[n])
+		}
+		if flags&bit != 0 {
+			return 0, fmt.Errorf("interrupt mask %q given twice", literal[n])
+		}
+		flags |= bit
+	}
+	return flags, nil
+}
+
+func (i *InstructionStatus) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionStatus) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= types.MnemonicToBits[i.Mnemonic]         // Fixed bits
+	binary |= i.SPSR << 22                             // R bit
+	if i.Mnemonic == types.MnemonicMRS {
+		binary |= i.Register << 12 // Rd
+		return utils.BitsToBytes(binary), nil
+	}
+
+	binary |= i.Fields << 16 // Field mask
+	if i.IBit == 0 {
+		binary |= i.Register // Rn
+		return utils.BitsToBytes(binary), nil
+	}
+	immediate, ok := encodeImmediate(i.Immediate)
+	if !ok {
+		return nil, fmt.Errorf("immediate value 0x%X can't be encoded as an 8 bit value rotated by an even amount at line %d, col %d", i.Immediate, i.Token.Line, i.Token.Col)
+	}
+	binary |= 1 << 25   // I bit
+	binary |= immediate // Rotate and imm8
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in an MRS or MSR from a machine word.
+func (i *InstructionStatus) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	spsr, fields := word>>22&1, word>>16&0xF
+	switch {
+	case word&0x0FBF0FFF == types.MnemonicToBits[types.MnemonicMRS] && word>>12&0xF != 15:
+		*i = InstructionStatus{Mnemonic: types.MnemonicMRS, Condition: condition, SPSR: spsr, Register: word >> 12 & 0xF}
+	case word&0x0FB0FFF0 == types.MnemonicToBits[types.MnemonicMSR] && fields != 0 && word&0xF != 15:
+		*i = InstructionStatus{Mnemonic: types.MnemonicMSR, Condition: condition, SPSR: spsr, Fields: fields, Register: word & 0xF}
+	case word&0x0FB0F000 == types.MnemonicToBits[types.MnemonicMSR]|1<<25 && fields != 0:
+		// Only the smallest rotation is ever assembled, anything else wouldn't survive a round trip
+		immediate := decodeImmediate(word & 0xFFF)
+		if encoded, _ := encodeImmediate(immediate); encoded != word&0xFFF {
+			return fmt.Errorf("non-canonical immediate encoding 0x%03X in 0x%08X", word&0xFFF, word)
+		}
+		*i = InstructionStatus{Mnemonic: types.MnemonicMSR, Condition: condition, SPSR: spsr, Fields: fields, IBit: 1, Immediate: immediate}
+	default:
+		return fmt.Errorf("0x%08X isn't an MRS or MSR", word)
+	}
+	return nil
+}
+
+// ByteMask returns the bits of the status register the fields of an MSR cover.
+func (i *InstructionStatus) ByteMask() uint32 {
+	var mask uint32
+	for n := 0; n < 4; n++ {
+		if i.Fields&(1<<n) != 0 {
+			mask |= 0xFF << (8 * n)
+		}
+	}
+	return mask
+}
+
+func (i *InstructionStatus) String() string {
+	register := "CPSR"
+	if i.SPSR == 1 {
+		register = "SPSR"
+	}
+	if i.Mnemonic == types.MnemonicMRS {
+		return fmt.Sprintf("%s %s, %s", mnemonic(i.Mnemonic, "", i.Condition), registerName(i.Register), register)
+	}
+
+	register += "_"
+	for _, f := range fieldLetters {
+		if i.Fields&f.field != 0 {
+			register += string(f.letter)
+		}
+	}
+	if i.IBit == 1 {
+		return fmt.Sprintf("%s %s, #0x%X", mnemonic(i.Mnemonic, "", i.Condition), register, i.Immediate)
+	}
+	return fmt.Sprintf("%s %s, %s", mnemonic(i.Mnemonic, "", i.Condition), register, registerName(i.Register))
+}
+
+func (i *InstructionChangeState) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionChangeState) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	binary := types.MnemonicToBits[i.Mnemonic] // Fixed bits, including the 0b1111 condition
+	if i.Mnemonic == types.MnemonicSETEND {
+		binary |= i.BigEndian << 9 // E bit
+		return utils.BitsToBytes(binary), nil
+	}
+
+	binary |= i.IMod << 18       // Enable or disable
+	binary |= i.ChangeMode << 17 // M bit
+	binary |= i.Flags & 0x1C0    // A, I and F
+	binary |= i.Mode & 0x1F      // Mode
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a CPS or SETEND from a machine word.
+func (i *InstructionChangeState) Decode(word uint32) error {
+	if word&^0x200 == types.MnemonicToBits[types.MnemonicSETEND] {
+		*i = InstructionChangeState{Mnemonic: types.MnemonicSETEND, BigEndian: word >> 9 & 1}
+		return nil
+	}
+	if word&0xFFF1FE20 != types.MnemonicToBits[types.MnemonicCPS] {
+		return fmt.Errorf("0x%08X isn't a CPS or SETEND", word)
+	}
+
+	decoded := InstructionChangeState{
+		Mnemonic:   types.MnemonicCPS,
+		IMod:       word >> 18 & 3,
+		ChangeMode: word >> 17 & 1,
+		Flags:      word & 0x1C0,
+		Mode:       word & 0x1F,
+	}
+	// Only the combinations the syntax can express
+	switch {
+	case decoded.IMod == 0b01:
+		return fmt.Errorf("reserved CPS imod 0b01 in 0x%08X", word)
+	case decoded.IMod == 0 && (decoded.ChangeMode == 0 || decoded.Flags != 0):
+		return fmt.Errorf("CPS in 0x%08X changes nothing or has masks without imod", word)
+	case decoded.IMod != 0 && decoded.Flags == 0:
+		return fmt.Errorf("CPS in 0x%08X has no interrupt masks", word)
+	case decoded.ChangeMode == 0 && decoded.Mode != 0:
+		return fmt.Errorf("CPS in 0x%08X has a mode without the M bit", word)
+	}
+	*i = decoded
+	return nil
+}
+
+func (i *InstructionChangeState) String() string {
+	if i.Mnemonic == types.MnemonicSETEND {
+		if i.BigEndian == 1 {
+			return "SETEND BE"
+		}
+		return "SETEND LE"
+	}
+
+	var operands []string
+	name := "CPS"
+	if i.IMod != 0 {
+		name = map[uint32]string{0b10: "CPSIE", 0b11: "CPSID"}[i.IMod]
+		flags := ""
+		for _, f := range interruptFlags {
+			if i.Flags&f.bit != 0 {
+				flags += string(f.letter)
+			}
+		}
+		operands = append(operands, flags)
+	}
+	if i.ChangeMode == 1 {
+		operands = append(operands, fmt.Sprintf("#0x%X", i.Mode))
+	}
+	return name + " " + strings.Join(operands, ", ")
+}
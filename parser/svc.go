@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+type InstructionSupervisorCall struct {
+	Mnemonic  types.MnemonicType
+	Condition types.ConditionType
+	Immediate uint32      // 24 bit number, ignored by the core and left for the handler to look at
+	Token     types.Token // Mnemonic token the instruction was parsed from
+}
+
+func (p *Parser) parseSupervisorCall() (types.Instruction, error) {
+	// Mnemonic, SWI is the old name and assembles the same
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategorySupervisorCall {
+		return nil, fmt.Errorf("wrong instruction type! expected supervisor call mnemonic, got %s", p.current().Literal)
+	}
+
+	// Get condition code
+	condition, err := utils.ParseSupervisorCallSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing supervisor call suffixes: %w", err)
+	}
+	p.consume() // consume SVC mnemonic token
+
+	// Immediate
+	if p.current().Type != types.TokenImmediate {
+		return nil, fmt.Errorf("expected immediate value after supervisor call mnemonic, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	immediate, err := utils.ParseImmediate(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing immediate value: %w", err)
+	}
+	if immediate > 0xFFFFFF {
+		return nil, fmt.Errorf("supervisor call number 0x%X doesn't fit in 24 bits at line %d, col %d", immediate, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume immediate token
+
+	instruction := &InstructionSupervisorCall{
+		Token:     token,
+		Mnemonic:  mnemonic,
+		Condition: condition,
+		Immediate: immediate,
+	}
+
+	return instruction, nil
+}
+
+func (i *InstructionSupervisorCall) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionSupervisorCall) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Set condition bits
+	binary |= types.MnemonicToBits[i.Mnemonic] << 24
+	binary |= i.Immediate & 0xFFFFFF
+
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in an SVC from a machine word.
+func (i *InstructionSupervisorCall) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	if word>>24&0xF != types.MnemonicToBits[types.MnemonicSVC] {
+		return fmt.Errorf("0x%08X isn't an SVC", word)
+	}
+
+	*i = InstructionSupervisorCall{
+		Mnemonic:  types.MnemonicSVC,
+		Condition: condition,
+		Immediate: word & 0xFFFFFF,
+	}
+	return nil
+}
+
+func (i *InstructionSupervisorCall) String() string {
+	return fmt.Sprintf("%s #0x%X", mnemonic(i.Mnemonic, "", i.Condition), i.Immediate)
+}
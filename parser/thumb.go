@@ -0,0 +1,367 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// InstructionThumb is an instruction assembled to a 16 or 32 bit Thumb encoding instead of an ARM word. The wrapped
+// instruction is parsed the same way as in ARM code, its condition comes from the IT block it's in.
+type InstructionThumb struct {
+	Instruction types.Instruction
+	Width       uint32 // 2 for .N, 4 for .W, 0 for the smallest encoding that fits
+	InITBlock   bool   // 16 bit data processing instructions only set the flags outside IT blocks
+	Address     uint32 // Byte offset in the label map's address space, set by layoutSections
+	size        uint32
+}
+
+// InstructionIfThen is IT, which makes up to four Thumb instructions after it conditional.
+type InstructionIfThen struct {
+	Condition types.ConditionType // Condition of the first instruction
+	Pattern   string              // T or E for each instruction after the first, T for the same condition, E for its inverse
+	Token     types.Token         // Mnemonic token the instruction was parsed from
+}
+
+// InstructionAlign pads Thumb code out to a word boundary with a Thumb NOP when ARM code or data comes after it.
+type InstructionAlign struct {
+	Address uint32      // Byte offset in the label map's address space, set by layoutSections
+	Token   types.Token // Token of whatever needed the alignment
+}
+
+// located is implemented by instructions that need to know their own offset to be encoded.
+type located interface {
+	locate(address uint32)
+}
+
+// IsThumb reports whether an instruction is Thumb code, which is made of halfwords rather than words.
+func IsThumb(instruction types.Instruction) bool {
+	switch instruction.(type) {
+	case *InstructionThumb, *InstructionIfThen, *InstructionAlign:
+		return true
+	}
+	return false
+}
+
+// thumbify wraps the instructions parsed since start in their Thumb encodings, checking each one against the IT block
+// it's in.
+func (p *Parser) thumbify(start int, width uint32) error {
+	for n := start; n < len(p.instructions); n++ {
+		if _, ok := p.instructions[n].(*InstructionIfThen); ok {
+			if width == 4 {
+				return fmt.Errorf("IT has no 32 bit encoding")
+			}
+			continue
+		}
+		instruction := &InstructionThumb{Instruction: p.instructions[n], Width: width, InITBlock: len(p.itBlock) > 0}
+		if err := p.checkITBlock(instruction.Instruction); err != nil {
+			return err
+		}
+		if err := instruction.settle(); err != nil {
+			return err
+		}
+		p.instructions[n] = instruction
+		p.unaligned[p.section] = true
+	}
+	return nil
+}
+
+// checkITBlock makes sure an instruction has the condition the IT block it's in gives it, and takes it off the block.
+// Outside IT blocks only B can be conditional.
+func (p *Parser) checkITBlock(instruction types.Instruction) error {
+	condition := conditionOf(instruction)
+	name := strings.ToUpper(instruction.SourceToken().Literal)
+	if len(p.itBlock) == 0 {
+		if branch, ok := instruction.(*InstructionBranch); condition != types.ConditionAL && (!ok || branch.Mnemonic != types.MnemonicB) {
+			return fmt.Errorf("%s has to be in an IT block to be conditional in Thumb code", name)
+		}
+		return nil
+	}
+
+	expected := p.itBlock[0]
+	p.itBlock = p.itBlock[1:]
+	switch instruction := instruction.(type) {
+	case *InstructionChangeState:
+		return fmt.Errorf("%s can't be in an IT block", name)
+	case *InstructionBarrier:
+		if instruction.Mnemonic == types.MnemonicCLREX {
+			return fmt.Errorf("%s can't be in an IT block", name)
+		}
+	}
+	if condition != expected {
+		return fmt.Errorf("%s doesn't match the IT block at line %d, which expects condition %s", name, p.itToken.Line, strings.ToUpper(types.ConditionToLiteral[expected]))
+	}
+	if branches(instruction) && len(p.itBlock) > 0 {
+		return fmt.Errorf("%s changes the PC, so it has to be the last instruction in its IT block", name)
+	}
+	return nil
+}
+
+// endITBlock reports an IT block that's still waiting for some of its instructions.
+func (p *Parser) endITBlock() error {
+	if len(p.itBlock) == 0 {
+		return nil
+	}
+	return fmt.Errorf("IT block at line %d, col %d is missing %d instructions", p.itToken.Line, p.itToken.Col, len(p.itBlock))
+}
+
+// alignARM pads the current section out to a word boundary if Thumb code might have left it halfway through one.
+// It's called before anything that has to be word aligned is added in ARM mode.
+func (p *Parser) alignARM(token types.Token) {
+	if p.unaligned[p.section] {
+		p.instructions = append(p.instructions, &InstructionAlign{Token: token})
+		p.unaligned[p.section] = false
+	}
+}
+
+// conditionOf returns the condition an instruction was written with, AL for the ones that can't have one.
+func conditionOf(instruction types.Instruction) types.ConditionType {
+	switch instruction := instruction.(type) {
+	case *InstructionMOV:
+		return instruction.Condition
+	case *InstructionArithmetic:
+		return instruction.Condition
+	case *InstructionMemory:
+		return instruction.Condition
+	case *InstructionMemoryMultiple:
+		return instruction.Condition
+	case *InstructionBranch:
+		return instruction.Condition
+	case *InstructionBranchExchange:
+		return instruction.Condition
+	case *InstructionSupervisorCall:
+		return instruction.Condition
+	case *InstructionStatus:
+		return instruction.Condition
+	case *InstructionCoprocessor:
+		return instruction.Condition
+	case *InstructionHint:
+		return instruction.Condition
+	case *InstructionExclusive:
+		return instruction.Condition
+	case *InstructionMedia:
+		return instruction.Condition
+	case *InstructionFloat:
+		return instruction.Condition
+	case *InstructionFloatTransfer:
+		return instruction.Condition
+	case *InstructionFloatSystem:
+		return instruction.Condition
+	case *InstructionFloatMemory:
+		return instruction.Condition
+	}
+	return types.ConditionAL
+}
+
+// branches reports whether an instruction writes the PC.
+func branches(instruction types.Instruction) bool {
+	switch instruction := instruction.(type) {
+	case *InstructionBranch, *InstructionBranchExchange:
+		return true
+	case *InstructionMemory:
+		return instruction.Mnemonic == types.MnemonicLDR && instruction.DestRegister == 15
+	case *InstructionMemoryMultiple:
+		return instruction.Mnemonic == types.MnemonicLDM && instruction.Offset&(1<<15) != 0
+	}
+	return false
+}
+
+// parseIfThen parses IT{T|E}{T|E}{T|E} cond, and opens the IT block for the instructions after it.
+func (p *Parser) parseIfThen() (types.Instruction, error) {
+	token := p.current()
+	if !p.thumb {
+		return nil, fmt.Errorf("IT is only valid in Thumb code")
+	}
+	if len(p.itBlock) > 0 {
+		return nil, fmt.Errorf("IT can't be inside another IT block")
+	}
+	pattern := strings.ToUpper(token.Literal[2:]) // The lexer only accepts T and E here
+	p.consume()                                   // consume IT token
+
+	// Condition, which is a bare word like EQ
+	if p.current().Type != types.TokenIdentifier || p.current().Line != token.Line {
+		return nil, fmt.Errorf("expected condition after IT, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	condition, ok := types.LiteralToCondition[strings.ToLower(p.current().Literal)]
+	if !ok {
+		return nil, fmt.Errorf("invalid IT condition %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	if condition == types.ConditionAL && strings.Contains(pattern, "E") {
+		return nil, fmt.Errorf("IT AL can't have E slots, there's no inverse of always")
+	}
+	p.consume() // consume condition token
+
+	p.itToken = token
+	p.itBlock = []types.ConditionType{condition}
+	for _, slot := range pattern {
+		if slot == 'T' {
+			p.itBlock = append(p.itBlock, condition)
+		} else {
+			p.itBlock = append(p.itBlock, condition^1) // Conditions come in pairs that only differ in bit 0
+		}
+	}
+
+	return &InstructionIfThen{Token: token, Condition: condition, Pattern: pattern}, nil
+}
+
+func (i *InstructionIfThen) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionIfThen) Size() uint32 {
+	return 2
+}
+
+func (i *InstructionIfThen) IsThumb() bool {
+	return true
+}
+
+// ToMachineCode for IT. Every slot after the first gets a mask bit that matches bit 0 of the first condition for T
+// and not for E, and a 1 follows the last one to mark where the block ends.
+func (i *InstructionIfThen) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	first := types.ConditionToBits[i.Condition]
+	mask, bit := uint32(0), uint32(8)
+	for _, slot := range i.Pattern {
+		if (slot == 'T') == (first&1 == 1) {
+			mask |= bit
+		}
+		bit >>= 1
+	}
+	mask |= bit
+
+	binary := types.MnemonicToBits[types.MnemonicIT] | first<<4 | mask
+	return utils.ThumbToBytes(binary, 2), nil
+}
+
+// Decode can't fill in Thumb instructions, machine words are always ARM code.
+func (i *InstructionIfThen) Decode(word uint32) error {
+	return fmt.Errorf("0x%08X can't be decoded as Thumb code", word)
+}
+
+func (i *InstructionIfThen) String() string {
+	return fmt.Sprintf("IT%s %s", i.Pattern, strings.ToUpper(types.ConditionToLiteral[i.Condition]))
+}
+
+func (i *InstructionAlign) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionAlign) locate(address uint32) {
+	i.Address = address
+}
+
+func (i *InstructionAlign) Size() uint32 {
+	return (4 - i.Address%4) % 4
+}
+
+func (i *InstructionAlign) IsThumb() bool {
+	return true
+}
+
+func (i *InstructionAlign) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	if i.Size() == 0 {
+		return nil, nil
+	}
+	return utils.ThumbToBytes(types.MnemonicToBits[types.MnemonicIT], 2), nil // IT with an empty mask is a NOP
+}
+
+// Decode can't fill in padding, it isn't an instruction of its own.
+func (i *InstructionAlign) Decode(word uint32) error {
+	return fmt.Errorf("0x%08X can't be decoded as padding", word)
+}
+
+func (i *InstructionAlign) String() string {
+	return ".arm"
+}
+
+func (i *InstructionThumb) SourceToken() types.Token {
+	return i.Instruction.SourceToken()
+}
+
+func (i *InstructionThumb) locate(address uint32) {
+	i.Address = address
+}
+
+func (i *InstructionThumb) Size() uint32 {
+	return i.size
+}
+
+func (i *InstructionThumb) IsThumb() bool {
+	return true
+}
+
+// settle picks the size of the encoding. Branches to labels start out as small as they can be and are widened by
+// layoutSections if the label turns out to be out of reach. Everything else is encoded straight away, which also
+// checks the operands have a Thumb encoding.
+func (i *InstructionThumb) settle() error {
+	if branch, ok := i.Instruction.(*InstructionBranch); ok {
+		switch {
+		case branch.Label.Literal == "":
+			return fmt.Errorf("Thumb branches need a label, not an offset")
+		case branch.Mnemonic != types.MnemonicB && i.Width == 2:
+			return fmt.Errorf("%s has no 16 bit encoding", types.MnemonicToLiteral[branch.Mnemonic])
+		case branch.Mnemonic != types.MnemonicB || i.Width == 4:
+			i.size = 4
+		default:
+			i.size = 2
+		}
+		return nil
+	}
+	_, size, err := i.encode(nil)
+	i.size = size
+	return err
+}
+
+// reaches reports whether a 16 bit branch can jump offset bytes from the PC.
+func (i *InstructionThumb) reaches(offset int64) bool {
+	if i.Instruction.(*InstructionBranch).Condition != types.ConditionAL && !i.InITBlock {
+		return offset >= -256 && offset <= 254
+	}
+	return offset >= -2048 && offset <= 2046
+}
+
+func (i *InstructionThumb) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	binary, size, err := i.encode(labels)
+	if err != nil {
+		return nil, err
+	}
+	if size != i.size {
+		return nil, fmt.Errorf("%s changed size from %d to %d bytes after layout", i.String(), i.size, size)
+	}
+	return utils.ThumbToBytes(binary, size), nil
+}
+
+func (i *InstructionThumb) Relocation() (types.Token, types.RelocationType) {
+	relocatable, ok := i.Instruction.(types.Relocatable)
+	if !ok {
+		return types.Token{}, types.RelocationThumbBranch
+	}
+	token, relocationType := relocatable.Relocation()
+	switch relocationType {
+	case types.RelocationBranch:
+		relocationType = types.RelocationThumbBranch
+	case types.RelocationMOVW:
+		relocationType = types.RelocationThumbMOVW
+	case types.RelocationMOVT:
+		relocationType = types.RelocationThumbMOVT
+	}
+	return token, relocationType
+}
+
+// Decode can't fill in Thumb instructions, machine words are always ARM code.
+func (i *InstructionThumb) Decode(word uint32) error {
+	return fmt.Errorf("0x%08X can't be decoded as Thumb code", word)
+}
+
+// String prints the wrapped instruction, with the .N or .W it was given after the mnemonic.
+func (i *InstructionThumb) String() string {
+	source := i.Instruction.String()
+	qualifier := map[uint32]string{2: ".N", 4: ".W"}[i.Width]
+	if qualifier == "" {
+		return source
+	}
+	mnemonic, operands, _ := strings.Cut(source, " ")
+	return strings.TrimSpace(mnemonic + qualifier + " " + operands)
+}
@@ -0,0 +1,437 @@
+package parser
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// encode returns the Thumb encoding of the wrapped instruction and its size. 32 bit encodings have the first
+// halfword in the top half.
+func (i *InstructionThumb) encode(labels map[string]uint32) (uint32, uint32, error) {
+	switch instruction := i.Instruction.(type) {
+	case *InstructionMOV:
+		return i.encodeMOV(instruction)
+	case *InstructionArithmetic:
+		return i.encodeArithmetic(instruction)
+	case *InstructionMemory:
+		return i.encodeMemory(instruction)
+	case *InstructionMemoryMultiple:
+		return i.encodeMemoryMultiple(instruction)
+	case *InstructionBranch:
+		return i.encodeBranch(instruction, labels)
+	case *InstructionBranchExchange:
+		binary := uint32(0x4700) | instruction.BaseRegister<<3
+		if instruction.Mnemonic == types.MnemonicBLX {
+			binary = 0x4780 | instruction.BaseRegister<<3
+		}
+		return i.pick(binary, true, 0, fmt.Errorf("%s has no 32 bit encoding", types.MnemonicToLiteral[instruction.Mnemonic]))
+	case *InstructionSupervisorCall:
+		if instruction.Immediate > 0xFF {
+			return 0, 0, fmt.Errorf("SVC number 0x%X doesn't fit in 8 bits in Thumb code", instruction.Immediate)
+		}
+		return i.pick(0xDF00|instruction.Immediate, true, 0, fmt.Errorf("SVC has no 32 bit encoding"))
+	case *InstructionStatus:
+		return i.encodeStatus(instruction)
+	case *InstructionChangeState:
+		return i.encodeChangeState(instruction)
+	case *InstructionCoprocessor:
+		// The same as the ARM encoding with the condition field set to 0b1110
+		arm := *instruction
+		arm.Condition = types.ConditionAL
+		return i.pickARM(&arm, labels, nil)
+	case *InstructionBarrier:
+		binary := map[types.MnemonicType]uint32{
+			types.MnemonicDMB:   0xF3BF8F50,
+			types.MnemonicDSB:   0xF3BF8F40,
+			types.MnemonicISB:   0xF3BF8F60,
+			types.MnemonicCLREX: 0xF3BF8F2F,
+		}[instruction.Mnemonic]
+		if instruction.Mnemonic != types.MnemonicCLREX {
+			binary |= instruction.Option & 0xF
+		}
+		return i.pick(0, false, binary, nil)
+	case *InstructionHint:
+		hint := types.MnemonicToBits[instruction.Mnemonic] & 0xFF
+		return i.pick(0xBF00|hint<<4, true, 0xF3AF8000|hint, nil)
+	case *InstructionExclusive:
+		return i.encodeExclusive(instruction)
+	case *InstructionMedia:
+		return i.encodeMedia(instruction)
+	case *InstructionFloat:
+		// VFP instructions are encoded like the coprocessor instructions they are
+		arm := *instruction
+		arm.Condition = types.ConditionAL
+		return i.pickARM(&arm, labels, nil)
+	case *InstructionFloatTransfer:
+		arm := *instruction
+		arm.Condition = types.ConditionAL
+		return i.pickARM(&arm, labels, noSP(instruction.Mnemonic, instruction.Register, instruction.Register2))
+	case *InstructionFloatSystem:
+		arm := *instruction
+		arm.Condition = types.ConditionAL
+		return i.pickARM(&arm, labels, noSP(instruction.Mnemonic, instruction.Register))
+	case *InstructionFloatMemory:
+		arm := *instruction
+		arm.Condition = types.ConditionAL
+		return i.pickARM(&arm, labels, nil)
+	case *InstructionVector:
+		// The U bit moves from bit 24 up to bit 28
+		word, err := armWord(instruction, labels)
+		if err != nil {
+			return 0, 0, err
+		}
+		return i.pick(0, false, 0xEF000000|word>>24&1<<28|word&0x00FFFFFF, nil)
+	case *InstructionVectorMemory:
+		word, err := armWord(instruction, labels)
+		if err != nil {
+			return 0, 0, err
+		}
+		return i.pick(0, false, 0xF9000000|word&0x00FFFFFF, nil)
+	}
+	return 0, 0, fmt.Errorf("%s has no Thumb encoding", i.Instruction.String())
+}
+
+// armWord returns the ARM encoding of an instruction as a number, for the 32 bit Thumb encodings that are the same
+// but for the top byte.
+func armWord(instruction types.Instruction, labels map[string]uint32) (uint32, error) {
+	code, err := instruction.ToMachineCode(labels)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(code[0]) | uint32(code[1])<<8 | uint32(code[2])<<16 | uint32(code[3])<<24, nil
+}
+
+// pickARM picks the ARM encoding of instruction, which has to be unconditional, as its only Thumb encoding. wideErr
+// is for ARM registers the Thumb encoding can't use.
+func (i *InstructionThumb) pickARM(instruction types.Instruction, labels map[string]uint32, wideErr error) (uint32, uint32, error) {
+	word, err := armWord(instruction, labels)
+	if err != nil {
+		return 0, 0, err
+	}
+	return i.pick(0, false, word, wideErr)
+}
+
+// noSP returns an error if any of an instruction's ARM registers is SP, which 32 bit Thumb encodings mostly can't use.
+func noSP(mnemonic types.MnemonicType, registers ...uint32) error {
+	for _, r := range registers {
+		if r == 13 {
+			return fmt.Errorf("%s can't use SP in Thumb code", types.MnemonicToLiteral[mnemonic])
+		}
+	}
+	return nil
+}
+
+// pick chooses between the 16 and 32 bit encodings of an instruction, going by .N or .W if it was given one. The 16
+// bit encoding can only be used if narrowOK, and the 32 bit one if wideErr is nil.
+func (i *InstructionThumb) pick(narrow uint32, narrowOK bool, wide uint32, wideErr error) (uint32, uint32, error) {
+	switch {
+	case narrowOK && i.Width != 4:
+		return narrow, 2, nil
+	case i.Width == 2:
+		return 0, 0, fmt.Errorf("%s has no 16 bit encoding", i.Instruction.String())
+	case wideErr != nil:
+		return 0, 0, wideErr
+	}
+	return wide, 4, nil
+}
+
+// encodeThumbImmediate finds the 12 bit encoding of a Thumb modified immediate: a byte, a byte repeated in alternate
+// bytes or all four bytes of the word, or a byte with its top bit set rotated right by 8 to 31.
+func encodeThumbImmediate(value uint32) (uint32, bool) {
+	b := value & 0xFF
+	switch value {
+	case b:
+		return b, true
+	case b<<16 | b:
+		return 0x100 | b, true
+	case b<<24 | b<<16 | b<<8 | b:
+		return 0x300 | b, true
+	}
+	if b = value >> 8 & 0xFF; value == b<<24|b<<8 {
+		return 0x200 | b, true
+	}
+	for rotate := uint32(8); rotate < 32; rotate++ {
+		unrotated := bits.RotateLeft32(value, int(rotate))
+		if unrotated >= 0x80 && unrotated <= 0xFF {
+			return rotate<<7 | unrotated&0x7F, true
+		}
+	}
+	return 0, false
+}
+
+// encodeMOV encodes MOVW and MOVT, which only have 32 bit encodings with the immediate split up differently than in
+// ARM code.
+func (i *InstructionThumb) encodeMOV(mov *InstructionMOV) (uint32, uint32, error) {
+	if mov.DestRegister == 13 || mov.DestRegister == 15 {
+		return 0, 0, fmt.Errorf("%s can't write %s in Thumb code", types.MnemonicToLiteral[mov.Mnemonic], registerName(mov.DestRegister))
+	}
+	binary := uint32(0xF240)<<16 | mov.DestRegister<<8
+	if mov.Mnemonic == types.MnemonicMOVT {
+		binary = uint32(0xF2C0)<<16 | mov.DestRegister<<8
+	}
+	return i.pick(0, false, utils.ThumbMOVImmediate(binary, mov.Immediate), nil)
+}
+
+// arithmeticOps are the op fields of the 32 bit ADD, SUB, AND and ORR with a modified immediate.
+var arithmeticOps = map[types.MnemonicType]uint32{
+	types.MnemonicAND: 0b0000,
+	types.MnemonicORR: 0b0010,
+	types.MnemonicADD: 0b1000,
+	types.MnemonicSUB: 0b1101,
+}
+
+// encodeArithmetic encodes ADD, SUB, AND and ORR with an immediate. ADD and SUB have 16 bit encodings for low
+// registers, as long as they set the flags outside an IT block and don't inside one, and for adjusting SP.
+func (i *InstructionThumb) encodeArithmetic(a *InstructionArithmetic) (uint32, uint32, error) {
+	rd, rn, imm := a.DestRegister, a.BaseRegister, a.Immediate
+	sub := uint32(0)
+	if a.Mnemonic == types.MnemonicSUB {
+		sub = 1
+	}
+	addSub := a.Mnemonic == types.MnemonicADD || a.Mnemonic == types.MnemonicSUB
+	flags := (a.SBit == 1) != i.InITBlock
+	low := rd < 8 && rn < 8
+
+	narrow, narrowOK := uint32(0), true
+	switch {
+	case addSub && low && flags && imm <= 7:
+		narrow = 0x1C00 | sub<<9 | imm<<6 | rn<<3 | rd
+	case addSub && low && flags && rd == rn && imm <= 0xFF:
+		narrow = 0x3000 | sub<<11 | rd<<8 | imm
+	case addSub && rd == 13 && rn == 13 && a.SBit == 0 && imm%4 == 0 && imm <= 508:
+		narrow = 0xB000 | sub<<7 | imm>>2
+	case a.Mnemonic == types.MnemonicADD && rd < 8 && rn == 13 && a.SBit == 0 && imm%4 == 0 && imm <= 1020:
+		narrow = 0xA800 | rd<<8 | imm>>2
+	default:
+		narrowOK = false
+	}
+	wide, err := wideArithmetic(a)
+	return i.pick(narrow, narrowOK, wide, err)
+}
+
+// wideArithmetic encodes the 32 bit ADD, SUB, AND or ORR with a modified immediate, or ADDW and SUBW for values up
+// to 12 bits that aren't one.
+func wideArithmetic(a *InstructionArithmetic) (uint32, error) {
+	rd, rn, imm := a.DestRegister, a.BaseRegister, a.Immediate
+	name := types.MnemonicToLiteral[a.Mnemonic]
+	addSub := a.Mnemonic == types.MnemonicADD || a.Mnemonic == types.MnemonicSUB
+	switch {
+	case rd == 15 || rn == 15:
+		return 0, fmt.Errorf("%s can't use the PC in Thumb code", name)
+	case !addSub && (rd == 13 || rn == 13):
+		return 0, fmt.Errorf("%s can't use SP in Thumb code", name)
+	case rd == 13 && rn != 13:
+		return 0, fmt.Errorf("%s can only write SP in Thumb code if it's adjusting SP", name)
+	}
+
+	if encoded, ok := encodeThumbImmediate(imm); ok {
+		first := 0xF000 | encoded>>11<<10 | arithmeticOps[a.Mnemonic]<<5 | a.SBit<<4 | rn
+		return first<<16 | encoded>>8&7<<12 | rd<<8 | encoded&0xFF, nil
+	}
+	if addSub && a.SBit == 0 && imm <= 0xFFF {
+		first := uint32(0xF200) | imm>>11<<10 | rn // ADDW
+		if a.Mnemonic == types.MnemonicSUB {
+			first |= 0xA0 // SUBW
+		}
+		return first<<16 | imm>>8&7<<12 | rd<<8 | imm&0xFF, nil
+	}
+	return 0, fmt.Errorf("immediate value 0x%X has no Thumb encoding for %s", imm, name)
+}
+
+// encodeMemory encodes LDR and STR. [Rn] and [Rn, #imm] have 16 bit encodings for low registers and SP with small
+// word aligned offsets and a 32 bit one with a 12 bit offset, the other forms are always 32 bits, with the same P, U
+// and W bits as in ARM code.
+func (i *InstructionThumb) encodeMemory(m *InstructionMemory) (uint32, uint32, error) {
+	rt, rn := m.DestRegister, m.BaseRegister
+	load := uint32(0)
+	if m.Mnemonic == types.MnemonicLDR {
+		load = 1
+	}
+	name := types.MnemonicToLiteral[m.Mnemonic]
+	switch {
+	case rn == 15:
+		return 0, 0, fmt.Errorf("%s can't use the PC as the base register in Thumb code", name)
+	case load == 0 && rt == 15:
+		return 0, 0, fmt.Errorf("STR can't store the PC in Thumb code")
+	}
+
+	if m.PBit == 1 && m.UBit == 1 && m.WBit == 0 {
+		narrow, narrowOK := 0x6000|load<<11|m.Offset>>2<<6|rn<<3|rt, rt < 8 && rn < 8 && m.Offset%4 == 0 && m.Offset <= 124
+		if rn == 13 && rt < 8 {
+			narrow, narrowOK = 0x9000|load<<11|rt<<8|m.Offset>>2, m.Offset%4 == 0 && m.Offset <= 1020
+		}
+		return i.pick(narrow, narrowOK, (0xF8C0|load<<4|rn)<<16|rt<<12|m.Offset, nil)
+	}
+
+	writeback := m.WBit | (1 - m.PBit) // Post indexed transfers always write back
+	switch {
+	case m.Offset > 0xFF:
+		return 0, 0, fmt.Errorf("%s offset %d doesn't fit in 8 bits in Thumb code", name, m.Offset)
+	case writeback == 1 && rn == rt:
+		return 0, 0, fmt.Errorf("%s can't write back to the register it transfers", name)
+	}
+	return i.pick(0, false, (0xF840|load<<4|rn)<<16|rt<<12|0x800|m.PBit<<10|m.UBit<<9|writeback<<8|m.Offset, nil)
+}
+
+// encodeMemoryMultiple encodes LDM and STM, which only have 16 bit encodings for the increment after forms.
+func (i *InstructionThumb) encodeMemoryMultiple(m *InstructionMemoryMultiple) (uint32, uint32, error) {
+	list, rn := m.Offset, m.BaseRegister
+	load := uint32(0)
+	if m.Mnemonic == types.MnemonicLDM {
+		load = 1
+	}
+	name := types.MnemonicToLiteral[m.Mnemonic]
+	switch {
+	case m.SBit == 1:
+		return 0, 0, fmt.Errorf("%s can't transfer user bank registers in Thumb code", name)
+	case m.PBit == m.UBit:
+		return 0, 0, fmt.Errorf("%s has no Thumb encoding for this addressing mode", name)
+	case rn == 15:
+		return 0, 0, fmt.Errorf("%s can't use the PC as the base register in Thumb code", name)
+	case bits.OnesCount32(list) < 2:
+		return 0, 0, fmt.Errorf("%s needs at least two registers in Thumb code", name)
+	case list&(1<<13) != 0:
+		return 0, 0, fmt.Errorf("%s can't transfer SP in Thumb code", name)
+	case load == 0 && list&(1<<15) != 0:
+		return 0, 0, fmt.Errorf("STM can't store the PC in Thumb code")
+	case load == 1 && list&(1<<15) != 0 && list&(1<<14) != 0:
+		return 0, 0, fmt.Errorf("LDM can't load both LR and the PC in Thumb code")
+	case m.WBit == 1 && list&(1<<rn) != 0:
+		return 0, 0, fmt.Errorf("%s can't write back to a register in its list", name)
+	}
+
+	mode := uint32(0x100) // Decrement before
+	if m.UBit == 1 {
+		mode = 0x080 // Increment after
+	}
+	wide := (0xE800|mode|m.WBit<<5|load<<4|rn)<<16 | list
+	// The 16 bit increment after forms write back unless a load overwrites the base
+	narrowOK := m.UBit == 1 && rn < 8 && list < 0x100 && m.WBit == 1-load*(list>>rn&1)
+	return i.pick(0xC000|load<<11|rn<<8|list, narrowOK, wide, nil)
+}
+
+// encodeBranch encodes B, BL and BLX to a label, relative to the PC which in Thumb code is 4 bytes ahead. BL to ARM
+// code is turned into BLX, which switches instruction set, but B can't switch. Conditional branches outside IT
+// blocks have their own encodings, inside one the condition comes from the block.
+func (i *InstructionThumb) encodeBranch(b *InstructionBranch, labels map[string]uint32) (uint32, uint32, error) {
+	target, ok := labels[b.Label.Literal]
+	if !ok {
+		return 0, 0, fmt.Errorf("label %s not found", b.Label.Literal)
+	}
+	pc := i.Address + 4
+
+	if target&1 == 0 {
+		if b.Mnemonic == types.MnemonicB {
+			return 0, 0, fmt.Errorf("B can't branch to ARM code at %s, call it with BL or BLX or branch with BX", b.Label.Literal)
+		}
+		binary, err := utils.ThumbBranch(utils.ThumbBranchBLX, int64(target)-int64(pc&^3))
+		return binary, 4, err
+	}
+
+	offset := int64(target&^1) - int64(pc)
+	conditional := b.Condition != types.ConditionAL && !i.InITBlock
+	switch {
+	case b.Mnemonic != types.MnemonicB:
+		binary, err := utils.ThumbBranch(utils.ThumbBranchBL, offset)
+		return binary, 4, err
+	case i.size == 2 && !i.reaches(offset):
+		return 0, 0, fmt.Errorf("label %s is out of range of a 16 bit branch", b.Label.Literal)
+	case i.size == 2 && conditional:
+		return 0xD000 | types.ConditionToBits[b.Condition]<<8 | uint32(offset>>1)&0xFF, 2, nil
+	case i.size == 2:
+		return 0xE000 | uint32(offset>>1)&0x7FF, 2, nil
+	case conditional:
+		binary, err := utils.ThumbConditionalBranch(types.ConditionToBits[b.Condition], offset)
+		return binary, 4, err
+	}
+	binary, err := utils.ThumbBranch(utils.ThumbBranchB, offset)
+	return binary, 4, err
+}
+
+// encodeStatus encodes MRS and MSR with a register. Thumb code has no MSR with an immediate.
+func (i *InstructionThumb) encodeStatus(s *InstructionStatus) (uint32, uint32, error) {
+	name := types.MnemonicToLiteral[s.Mnemonic]
+	switch {
+	case s.Mnemonic == types.MnemonicMSR && s.IBit == 1:
+		return 0, 0, fmt.Errorf("MSR can't take an immediate in Thumb code")
+	case s.Register == 13 || s.Register == 15:
+		return 0, 0, fmt.Errorf("%s can't use %s in Thumb code", name, registerName(s.Register))
+	}
+	if s.Mnemonic == types.MnemonicMRS {
+		return i.pick(0, false, (0xF3EF|s.SPSR<<4)<<16|0x8000|s.Register<<8, nil)
+	}
+	return i.pick(0, false, (0xF380|s.SPSR<<4|s.Register)<<16|0x8000|s.Fields<<8, nil)
+}
+
+// encodeChangeState encodes SETEND, and CPS, which has a 16 bit encoding as long as it doesn't change mode.
+func (i *InstructionThumb) encodeChangeState(c *InstructionChangeState) (uint32, uint32, error) {
+	if c.Mnemonic == types.MnemonicSETEND {
+		return i.pick(0xB650|c.BigEndian<<3, true, 0, fmt.Errorf("SETEND has no 32 bit encoding"))
+	}
+	narrow := 0xB660 | c.IMod&1<<4 | c.Flags>>6&7
+	wide := uint32(0xF3AF8000) | c.IMod<<9 | c.ChangeMode<<8 | c.Flags>>1&0xE0 | c.Mode&0x1F
+	return i.pick(narrow, c.IMod != 0 && c.ChangeMode == 0, wide, nil)
+}
+
+// encodeExclusive encodes LDREX and STREX, with a zero offset.
+func (i *InstructionThumb) encodeExclusive(e *InstructionExclusive) (uint32, uint32, error) {
+	registers := []uint32{e.DestRegister}
+	if e.Mnemonic == types.MnemonicSTREX {
+		registers = append(registers, e.StatusRegister)
+	}
+	for _, r := range registers {
+		if r == 13 || r == 15 {
+			return 0, 0, fmt.Errorf("%s can't use %s in Thumb code", types.MnemonicToLiteral[e.Mnemonic], registerName(r))
+		}
+	}
+	if e.BaseRegister == 15 {
+		return 0, 0, fmt.Errorf("%s can't use the PC as the base register in Thumb code", types.MnemonicToLiteral[e.Mnemonic])
+	}
+	if e.Mnemonic == types.MnemonicLDREX {
+		return i.pick(0, false, (0xE850|e.BaseRegister)<<16|e.DestRegister<<12|0xF00, nil)
+	}
+	return i.pick(0, false, (0xE840|e.BaseRegister)<<16|e.DestRegister<<12|e.StatusRegister<<8, nil)
+}
+
+// encodeMedia encodes CLZ, REV, REV16, the extends, the bit field instructions, SDIV and UDIV. None of them can use SP
+// in Thumb code.
+func (i *InstructionThumb) encodeMedia(m *InstructionMedia) (uint32, uint32, error) {
+	rd, rn, rm := m.DestRegister, m.BaseRegister, m.OperandRegister
+	if rd == 13 || rn == 13 || rm == 13 {
+		return 0, 0, fmt.Errorf("%s can't use SP in Thumb code", types.MnemonicToLiteral[m.Mnemonic])
+	}
+	low := rd < 8 && rm < 8
+	switch m.Mnemonic {
+	case types.MnemonicCLZ:
+		return i.pick(0, false, (0xFAB0|rm)<<16|0xF080|rd<<8|rm, nil)
+	case types.MnemonicREV:
+		return i.pick(0xBA00|rm<<3|rd, low, (0xFA90|rm)<<16|0xF080|rd<<8|rm, nil)
+	case types.MnemonicREV16:
+		return i.pick(0xBA40|rm<<3|rd, low, (0xFA90|rm)<<16|0xF090|rd<<8|rm, nil)
+	case types.MnemonicUBFX, types.MnemonicSBFX, types.MnemonicBFI:
+		op, field := uint32(0xF3C0), m.Width-1
+		switch m.Mnemonic {
+		case types.MnemonicSBFX:
+			op = 0xF340
+		case types.MnemonicBFI:
+			op, field = 0xF360, m.LSB+m.Width-1
+		}
+		return i.pick(0, false, (op|rn)<<16|m.LSB>>2<<12|rd<<8|m.LSB&3<<6|field, nil)
+	case types.MnemonicSDIV:
+		return i.pick(0, false, (0xFB90|rn)<<16|0xF0F0|rd<<8|rm, nil)
+	case types.MnemonicUDIV:
+		return i.pick(0, false, (0xFBB0|rn)<<16|0xF0F0|rd<<8|rm, nil)
+	}
+
+	// The extends, with the first halfword of the 32 bit encoding in the top half
+	ops := map[types.MnemonicType]uint32{
+		types.MnemonicSXTH: 0xB200FA0F,
+		types.MnemonicSXTB: 0xB240FA4F,
+		types.MnemonicUXTH: 0xB280FA1F,
+		types.MnemonicUXTB: 0xB2C0FA5F,
+	}[m.Mnemonic]
+	return i.pick(ops>>16|rm<<3|rd, low && m.Rotation == 0, (ops&0xFFFF)<<16|0xF080|rd<<8|m.Rotation/8<<4|rm, nil)
+}
@@ -0,0 +1,727 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/types"
+	"github.com/robertjshirts/rogasmic/utils"
+)
+
+// InstructionFloat is a VFP data processing instruction: VADD, VSUB, VMUL, VDIV, VMOV from one extension register to
+// another, or VCVT. The 32 bit types live in S registers and F64 in D registers.
+type InstructionFloat struct {
+	Mnemonic       types.MnemonicType
+	Condition      types.ConditionType
+	DataType       types.DataType // F32 or F64, or for VCVT the type converted to
+	SourceType     types.DataType // VCVT only, the type converted from
+	DestRegister   uint32         // Sd or Dd
+	FirstRegister  uint32         // Sn or Dn, unused by VMOV and VCVT
+	SecondRegister uint32         // Sm or Dm
+	Token          types.Token    // Mnemonic token the instruction was parsed from
+}
+
+// InstructionFloatTransfer is VMOV between an ARM register and an S register, or two ARM registers and a D register.
+type InstructionFloatTransfer struct {
+	Mnemonic   types.MnemonicType // Always VMOV
+	Condition  types.ConditionType
+	ToARM      uint32      // 1 for moves into the ARM registers
+	Double     bool        // Rt and Rt2 are the low and high words of a D register
+	FPRegister uint32      // Sn or Dm
+	Register   uint32      // Rt
+	Register2  uint32      // Rt2, D registers only
+	Token      types.Token // Mnemonic token the instruction was parsed from
+}
+
+// InstructionFloatSystem is VMRS or VMSR, which move an ARM register from or to FPSID, FPSCR or FPEXC.
+type InstructionFloatSystem struct {
+	Mnemonic  types.MnemonicType
+	Condition types.ConditionType
+	Register  uint32      // Rt, or for VMRS 15 for APSR_nzcv, which copies the FPSCR flags to the CPSR
+	System    uint32      // FPSID, FPSCR or FPEXC
+	Token     types.Token // Mnemonic token the instruction was parsed from
+}
+
+// InstructionFloatMemory is VLDR or VSTR of an S or D register, at an offset from an ARM register.
+type InstructionFloatMemory struct {
+	Mnemonic     types.MnemonicType
+	Condition    types.ConditionType
+	Double       bool
+	FPRegister   uint32
+	BaseRegister uint32
+	Offset       uint32      // Bytes added to the base register, a multiple of 4 up to 1020
+	Token        types.Token // Mnemonic token the instruction was parsed from
+}
+
+// VFP system registers, by the number VMRS and VMSR encode them with
+const (
+	FPSID uint32 = 0
+	FPSCR uint32 = 1
+	FPEXC uint32 = 8
+)
+
+var floatSystemNames = map[uint32]string{FPSID: "FPSID", FPSCR: "FPSCR", FPEXC: "FPEXC"}
+
+// Fixed bits of the VMOV forms that move between ARM and extension registers
+const (
+	vmovSingle = 0x0E000A10
+	vmovDouble = 0x0C400B10
+)
+
+// floatConversions are the VCVT data type pairs, to then from, and the bits they set in bits 16-19, 8 and 7. Floats
+// are converted to integers rounding towards zero.
+var floatConversions = map[[2]types.DataType]uint32{
+	{types.DataTypeF64, types.DataTypeF32}: 0x00070080,
+	{types.DataTypeF32, types.DataTypeF64}: 0x00070180,
+	{types.DataTypeF32, types.DataTypeS32}: 0x00080080,
+	{types.DataTypeF32, types.DataTypeU32}: 0x00080000,
+	{types.DataTypeF64, types.DataTypeS32}: 0x00080180,
+	{types.DataTypeF64, types.DataTypeU32}: 0x00080100,
+	{types.DataTypeS32, types.DataTypeF32}: 0x000D0080,
+	{types.DataTypeU32, types.DataTypeF32}: 0x000C0080,
+	{types.DataTypeS32, types.DataTypeF64}: 0x000D0180,
+	{types.DataTypeU32, types.DataTypeF64}: 0x000C0180,
+}
+
+// fpD, fpN and fpM place an S or D register in the Vd, Vn or Vm field of an encoding and its extra bit. S registers
+// keep their lowest bit apart, D registers their highest.
+func fpD(r uint32, double bool) uint32 {
+	if double {
+		return r&0xF<<12 | r>>4<<22
+	}
+	return r>>1<<12 | r&1<<22
+}
+
+func fpN(r uint32, double bool) uint32 {
+	if double {
+		return r&0xF<<16 | r>>4<<7
+	}
+	return r>>1<<16 | r&1<<7
+}
+
+func fpM(r uint32, double bool) uint32 {
+	if double {
+		return r&0xF | r>>4<<5
+	}
+	return r>>1 | r&1<<5
+}
+
+// fpRegister joins a register field and its extra bit back together.
+func fpRegister(field uint32, bit uint32, double bool) uint32 {
+	if double {
+		return bit<<4 | field
+	}
+	return field<<1 | bit
+}
+
+func fpRegisterName(r uint32, double bool) string {
+	if double {
+		return fmt.Sprintf("D%d", r)
+	}
+	return fmt.Sprintf("S%d", r)
+}
+
+// parseDataTypes takes the data types off the front of the operands. VCVT has two, everything else one or none.
+func (p *Parser) parseDataTypes() ([]types.DataType, error) {
+	var dataTypes []types.DataType
+	for p.current().Type == types.TokenDataType {
+		dataType, err := utils.ParseDataType(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("%w at line %d, col %d", err, p.current().Line, p.current().Col)
+		}
+		dataTypes = append(dataTypes, dataType)
+		p.consume() // consume data type token
+	}
+	return dataTypes, nil
+}
+
+// parseFPRegister parses an S, D or Q register, going by kind.
+func (p *Parser) parseFPRegister(kind byte, role string) (uint32, error) {
+	if p.current().Type != types.TokenFPRegister {
+		return 0, fmt.Errorf("expected %s register to be a %c register, got %s at line %d, col %d", role, kind, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	got, reg, err := utils.ParseFPRegister(p.current().Literal)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %s register: %w", role, err)
+	}
+	if got != kind {
+		return 0, fmt.Errorf("expected %s register to be a %c register, got %s at line %d, col %d", role, kind, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume register token
+	return reg, nil
+}
+
+// fpKind returns the letter of the registers that hold a data type.
+func fpKind(dataType types.DataType) byte {
+	if dataType.Size == 64 {
+		return 'D'
+	}
+	return 'S'
+}
+
+// parseFloat parses VADD, VSUB, VMUL and VDIV, VMOV in any of its forms and VCVT. VADD and VSUB with an integer data
+// type are NEON instructions.
+func (p *Parser) parseFloat() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryFloat {
+		return nil, fmt.Errorf("wrong instruction type! expected floating-point mnemonic, got %s", p.current().Literal)
+	}
+
+	// Get condition code and data types
+	condition, err := utils.ParseFloatSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing floating-point suffixes: %w", err)
+	}
+	p.consume() // consume floating-point mnemonic token
+	dataTypes, err := p.parseDataTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	switch mnemonic {
+	case types.MnemonicVMOV:
+		return p.parseFloatMove(token, condition, dataTypes)
+	case types.MnemonicVCVT:
+		if len(dataTypes) != 2 {
+			return nil, fmt.Errorf("VCVT needs the data types converted to and from, as in VCVT.S32.F32, at line %d, col %d", token.Line, token.Col)
+		}
+		if _, ok := floatConversions[[2]types.DataType{dataTypes[0], dataTypes[1]}]; !ok {
+			return nil, fmt.Errorf("VCVT can't convert %s to %s at line %d, col %d", dataTypes[1], dataTypes[0], token.Line, token.Col)
+		}
+	case types.MnemonicVADD, types.MnemonicVSUB:
+		if len(dataTypes) == 1 && dataTypes[0].Kind == 'I' {
+			return p.parseVector(token, mnemonic, condition, dataTypes[0])
+		}
+	}
+	if mnemonic != types.MnemonicVCVT && (len(dataTypes) != 1 || dataTypes[0].Kind != 'F') {
+		return nil, fmt.Errorf("%s needs a .F32 or .F64 data type at line %d, col %d", types.MnemonicToLiteral[mnemonic], token.Line, token.Col)
+	}
+
+	instruction := &InstructionFloat{Token: token, Mnemonic: mnemonic, Condition: condition, DataType: dataTypes[0]}
+	if mnemonic == types.MnemonicVCVT {
+		instruction.SourceType = dataTypes[1]
+	}
+	if instruction.DestRegister, err = p.parseFPRegister(fpKind(instruction.DataType), "destination"); err != nil {
+		return nil, err
+	}
+	if err := p.expectComma("destination register"); err != nil {
+		return nil, err
+	}
+	if mnemonic != types.MnemonicVCVT {
+		if instruction.FirstRegister, err = p.parseFPRegister(fpKind(instruction.DataType), "first operand"); err != nil {
+			return nil, err
+		}
+		if err := p.expectComma("first operand register"); err != nil {
+			return nil, err
+		}
+	}
+	if instruction.SecondRegister, err = p.parseFPRegister(fpKind(instruction.sourceType()), "source"); err != nil {
+		return nil, err
+	}
+
+	return instruction, nil
+}
+
+// parseFloatMove parses the forms of VMOV: VMOV{.F32|.F64} between two S or D registers, VMOV Sn, Rt and VMOV Rt, Sn,
+// and VMOV Dm, Rt, Rt2 and VMOV Rt, Rt2, Dm.
+func (p *Parser) parseFloatMove(token types.Token, condition types.ConditionType, dataTypes []types.DataType) (types.Instruction, error) {
+	if p.current().Type == types.TokenFPRegister && p.peek().Type == types.TokenComma && p.tokens[p.pos+2].Type == types.TokenFPRegister {
+		// Between extension registers, where the data type is optional
+		kind, _, err := utils.ParseFPRegister(p.current().Literal)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing destination register: %w", err)
+		}
+		if kind == 'Q' {
+			return nil, fmt.Errorf("VMOV between Q registers isn't supported at line %d, col %d", token.Line, token.Col)
+		}
+		dataType := types.DataTypeF32
+		if kind == 'D' {
+			dataType = types.DataTypeF64
+		}
+		if len(dataTypes) > 1 || len(dataTypes) == 1 && dataTypes[0] != dataType {
+			return nil, fmt.Errorf("VMOV between %c registers can only be %s at line %d, col %d", kind, dataType, token.Line, token.Col)
+		}
+		instruction := &InstructionFloat{Token: token, Mnemonic: types.MnemonicVMOV, Condition: condition, DataType: dataType}
+		instruction.DestRegister, _ = p.parseFPRegister(kind, "destination")
+		p.consume() // consume comma token
+		if instruction.SecondRegister, err = p.parseFPRegister(kind, "source"); err != nil {
+			return nil, err
+		}
+		return instruction, nil
+	}
+	if len(dataTypes) > 0 {
+		return nil, fmt.Errorf("VMOV to or from ARM registers doesn't take a data type at line %d, col %d", token.Line, token.Col)
+	}
+
+	instruction := &InstructionFloatTransfer{Token: token, Mnemonic: types.MnemonicVMOV, Condition: condition}
+	var err error
+	if p.current().Type == types.TokenRegister {
+		// Rt{, Rt2}, Sn|Dm
+		instruction.ToARM = 1
+		if instruction.Register, err = p.parseRegisterNotPC("destination"); err != nil {
+			return nil, err
+		}
+		if err := p.expectComma("destination register"); err != nil {
+			return nil, err
+		}
+		if p.current().Type == types.TokenRegister {
+			instruction.Double = true
+			if instruction.Register2, err = p.parseRegisterNotPC("second destination"); err != nil {
+				return nil, err
+			}
+			if instruction.Register2 == instruction.Register {
+				return nil, fmt.Errorf("VMOV can't load both halves of a D register into %s at line %d, col %d", registerName(instruction.Register), token.Line, token.Col)
+			}
+			if err := p.expectComma("second destination register"); err != nil {
+				return nil, err
+			}
+		}
+		kind := byte('S')
+		if instruction.Double {
+			kind = 'D'
+		}
+		if instruction.FPRegister, err = p.parseFPRegister(kind, "source"); err != nil {
+			return nil, err
+		}
+		return instruction, nil
+	}
+
+	// Sn|Dm, Rt{, Rt2}
+	if p.current().Type != types.TokenFPRegister {
+		return nil, fmt.Errorf("expected register after VMOV, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	kind, _, err := utils.ParseFPRegister(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing destination register: %w", err)
+	}
+	if kind == 'Q' {
+		return nil, fmt.Errorf("VMOV between Q registers and ARM registers isn't supported at line %d, col %d", token.Line, token.Col)
+	}
+	instruction.Double = kind == 'D'
+	if instruction.FPRegister, err = p.parseFPRegister(kind, "destination"); err != nil {
+		return nil, err
+	}
+	if err := p.expectComma("destination register"); err != nil {
+		return nil, err
+	}
+	if instruction.Register, err = p.parseRegisterNotPC("source"); err != nil {
+		return nil, err
+	}
+	if instruction.Double {
+		if err := p.expectComma("source register"); err != nil {
+			return nil, err
+		}
+		if instruction.Register2, err = p.parseRegisterNotPC("second source"); err != nil {
+			return nil, err
+		}
+	}
+	return instruction, nil
+}
+
+// parseFloatSystem parses VMRS{cond} Rt, <system register> and VMSR{cond} <system register>, Rt, and VMRS{cond}
+// APSR_nzcv, FPSCR, which copies the FPSCR flags to the CPSR.
+func (p *Parser) parseFloatSystem() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryFloatSystem {
+		return nil, fmt.Errorf("wrong instruction type! expected VMRS or VMSR, got %s", p.current().Literal)
+	}
+
+	// Get condition code
+	condition, err := utils.ParseFloatSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing floating-point suffixes: %w", err)
+	}
+	p.consume() // consume VMRS or VMSR token
+
+	instruction := &InstructionFloatSystem{Token: token, Mnemonic: mnemonic, Condition: condition}
+	parseSystem := func() error {
+		for system, name := range floatSystemNames {
+			if p.current().Type == types.TokenIdentifier && strings.EqualFold(p.current().Literal, name) {
+				instruction.System = system
+				p.consume() // consume system register token
+				return nil
+			}
+		}
+		return fmt.Errorf("expected FPSID, FPSCR or FPEXC, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+
+	if mnemonic == types.MnemonicVMSR {
+		if err := parseSystem(); err != nil {
+			return nil, err
+		}
+		if err := p.expectComma("system register"); err != nil {
+			return nil, err
+		}
+		if instruction.Register, err = p.parseRegisterNotPC("source"); err != nil {
+			return nil, err
+		}
+		return instruction, nil
+	}
+
+	if p.current().Type == types.TokenIdentifier && strings.EqualFold(p.current().Literal, "APSR_nzcv") {
+		instruction.Register = 15
+		p.consume() // consume APSR_nzcv token
+	} else if instruction.Register, err = p.parseRegisterNotPC("destination"); err != nil {
+		return nil, err
+	}
+	if err := p.expectComma("destination register"); err != nil {
+		return nil, err
+	}
+	if err := parseSystem(); err != nil {
+		return nil, err
+	}
+	if instruction.Register == 15 && instruction.System != FPSCR {
+		return nil, fmt.Errorf("only the FPSCR flags can be copied to APSR_nzcv at line %d, col %d", token.Line, token.Col)
+	}
+	return instruction, nil
+}
+
+// parseFloatMemory parses VLDR{cond}{.32|.64} Sd|Dd, [Rn{, #offset}] and the same for VSTR.
+func (p *Parser) parseFloatMemory() (types.Instruction, error) {
+	// Mnemonic
+	token := p.current()
+	mnemonic := types.TokenToMnemonic[p.current().Type]
+	category, ok := types.MnemonicToCategory[mnemonic]
+	if !ok || category != types.MnemonicCategoryFloatMemory {
+		return nil, fmt.Errorf("wrong instruction type! expected VLDR or VSTR, got %s", p.current().Literal)
+	}
+
+	// Get condition code and data type
+	condition, err := utils.ParseFloatSuffixes(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing floating-point suffixes: %w", err)
+	}
+	p.consume() // consume VLDR or VSTR token
+	dataTypes, err := p.parseDataTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	// Register
+	if p.current().Type != types.TokenFPRegister {
+		return nil, fmt.Errorf("expected S or D register after %s, got %s at line %d, col %d", token.Literal, p.current().Literal, p.current().Line, p.current().Col)
+	}
+	kind, reg, err := utils.ParseFPRegister(p.current().Literal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing register: %w", err)
+	}
+	if kind == 'Q' {
+		return nil, fmt.Errorf("%s can't transfer a Q register at line %d, col %d", types.MnemonicToLiteral[mnemonic], p.current().Line, p.current().Col)
+	}
+	size := uint32(32)
+	if kind == 'D' {
+		size = 64
+	}
+	if len(dataTypes) > 1 || len(dataTypes) == 1 && dataTypes[0].Size != size {
+		return nil, fmt.Errorf("%s of a %c register can only be .%d at line %d, col %d", types.MnemonicToLiteral[mnemonic], kind, size, token.Line, token.Col)
+	}
+	p.consume() // consume register token
+	instruction := &InstructionFloatMemory{Token: token, Mnemonic: mnemonic, Condition: condition, Double: kind == 'D', FPRegister: reg}
+	if err := p.expectComma("register"); err != nil {
+		return nil, err
+	}
+
+	// [Rn{, #offset}]
+	if p.current().Type != types.TokenLBracket {
+		return nil, fmt.Errorf("expected '[' for base register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume LBracket token
+	if p.current().Type != types.TokenRegister {
+		return nil, fmt.Errorf("expected base register after '[', got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	if instruction.BaseRegister, err = utils.ParseRegister(p.current().Literal); err != nil {
+		return nil, fmt.Errorf("error parsing base register: %w", err)
+	}
+	p.consume() // consume base register token
+	if p.current().Type == types.TokenComma {
+		p.consume() // consume comma token
+		if p.current().Type != types.TokenImmediate {
+			return nil, fmt.Errorf("expected immediate offset after comma, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		if instruction.Offset, err = utils.ParseImmediate(p.current().Literal); err != nil {
+			return nil, fmt.Errorf("error parsing immediate offset: %w", err)
+		}
+		if instruction.Offset%4 != 0 || instruction.Offset > 1020 {
+			return nil, fmt.Errorf("offset %s isn't a multiple of 4 up to 1020 at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+		}
+		p.consume() // consume immediate token
+	}
+	if p.current().Type != types.TokenRBracket {
+		return nil, fmt.Errorf("expected ']' after base register, got %s at line %d, col %d", p.current().Literal, p.current().Line, p.current().Col)
+	}
+	p.consume() // consume RBracket token
+
+	return instruction, nil
+}
+
+// sourceType is the type of the source operands, which is the instruction's data type for anything but VCVT.
+func (i *InstructionFloat) sourceType() types.DataType {
+	if i.Mnemonic == types.MnemonicVCVT {
+		return i.SourceType
+	}
+	return i.DataType
+}
+
+func (i *InstructionFloat) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionFloat) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= types.MnemonicToBits[i.Mnemonic]         // Fixed bits
+	if i.Mnemonic == types.MnemonicVCVT {
+		binary |= floatConversions[[2]types.DataType{i.DataType, i.SourceType}]
+	} else if i.DataType.Size == 64 {
+		binary |= 1 << 8 // sz, double precision
+	}
+	binary |= fpD(i.DestRegister, i.DataType.Size == 64)
+	if i.Mnemonic != types.MnemonicVMOV && i.Mnemonic != types.MnemonicVCVT {
+		binary |= fpN(i.FirstRegister, i.DataType.Size == 64)
+	}
+	binary |= fpM(i.SecondRegister, i.sourceType().Size == 64)
+
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a VADD, VSUB, VMUL, VDIV, VMOV between extension registers or VCVT from a machine word.
+func (i *InstructionFloat) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	decoded := InstructionFloat{Condition: condition, DataType: types.DataTypeF32}
+	if word>>8&1 == 1 {
+		decoded.DataType = types.DataTypeF64
+	}
+	fixed := word & 0x0FB00E50 // Everything but the registers and sz
+	switch fixed {
+	case types.MnemonicToBits[types.MnemonicVADD]:
+		decoded.Mnemonic = types.MnemonicVADD
+	case types.MnemonicToBits[types.MnemonicVSUB]:
+		decoded.Mnemonic = types.MnemonicVSUB
+	case types.MnemonicToBits[types.MnemonicVMUL]:
+		decoded.Mnemonic = types.MnemonicVMUL
+	case types.MnemonicToBits[types.MnemonicVDIV]:
+		decoded.Mnemonic = types.MnemonicVDIV
+	case types.MnemonicToBits[types.MnemonicVMOV]:
+		decoded.Mnemonic = types.MnemonicVMOV
+		if fields := word & 0x000F0080; fields != 0 {
+			found := false
+			for dataTypes, bits := range floatConversions {
+				if bits == fields|word&0x100 {
+					decoded.Mnemonic, decoded.DataType, decoded.SourceType = types.MnemonicVCVT, dataTypes[0], dataTypes[1]
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("0x%08X isn't a VMOV or VCVT rogasmic can assemble", word)
+			}
+		}
+	default:
+		return fmt.Errorf("0x%08X isn't a VFP data processing instruction", word)
+	}
+
+	decoded.DestRegister = fpRegister(word>>12&0xF, word>>22&1, decoded.DataType.Size == 64)
+	if decoded.Mnemonic != types.MnemonicVMOV && decoded.Mnemonic != types.MnemonicVCVT {
+		decoded.FirstRegister = fpRegister(word>>16&0xF, word>>7&1, decoded.DataType.Size == 64)
+	}
+	decoded.SecondRegister = fpRegister(word&0xF, word>>5&1, decoded.sourceType().Size == 64)
+
+	*i = decoded
+	return nil
+}
+
+func (i *InstructionFloat) String() string {
+	name := mnemonic(i.Mnemonic, "", i.Condition) + i.DataType.String()
+	dest := fpRegisterName(i.DestRegister, i.DataType.Size == 64)
+	source := fpRegisterName(i.SecondRegister, i.sourceType().Size == 64)
+	switch i.Mnemonic {
+	case types.MnemonicVCVT:
+		return fmt.Sprintf("%s%s %s, %s", name, i.SourceType, dest, source)
+	case types.MnemonicVMOV:
+		return fmt.Sprintf("%s %s, %s", name, dest, source)
+	}
+	return fmt.Sprintf("%s %s, %s, %s", name, dest, fpRegisterName(i.FirstRegister, i.DataType.Size == 64), source)
+}
+
+func (i *InstructionFloatTransfer) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionFloatTransfer) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= i.ToARM << 20                            // op, the direction
+	binary |= i.Register << 12
+	if i.Double {
+		binary |= vmovDouble
+		binary |= i.Register2 << 16
+		binary |= fpM(i.FPRegister, true)
+	} else {
+		binary |= vmovSingle
+		binary |= fpN(i.FPRegister, false)
+	}
+
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a VMOV between ARM registers and an S or D register from a machine word.
+func (i *InstructionFloatTransfer) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	decoded := InstructionFloatTransfer{Mnemonic: types.MnemonicVMOV, Condition: condition, ToARM: word >> 20 & 1, Register: word >> 12 & 0xF}
+	switch {
+	case word&0x0FE00F7F == vmovSingle:
+		decoded.FPRegister = fpRegister(word>>16&0xF, word>>7&1, false)
+	case word&0x0FE00FD0 == vmovDouble:
+		decoded.Double = true
+		decoded.Register2 = word >> 16 & 0xF
+		decoded.FPRegister = fpRegister(word&0xF, word>>5&1, true)
+		if decoded.Register2 == 15 {
+			return fmt.Errorf("VMOV in 0x%08X uses the PC", word)
+		}
+		if decoded.ToARM == 1 && decoded.Register2 == decoded.Register {
+			return fmt.Errorf("VMOV in 0x%08X loads both halves into one register", word)
+		}
+	default:
+		return fmt.Errorf("0x%08X isn't a VMOV to or from ARM registers", word)
+	}
+	if decoded.Register == 15 {
+		return fmt.Errorf("VMOV in 0x%08X uses the PC", word)
+	}
+
+	*i = decoded
+	return nil
+}
+
+func (i *InstructionFloatTransfer) String() string {
+	name := mnemonic(i.Mnemonic, "", i.Condition)
+	registers := registerName(i.Register)
+	if i.Double {
+		registers += ", " + registerName(i.Register2)
+	}
+	if i.ToARM == 1 {
+		return fmt.Sprintf("%s %s, %s", name, registers, fpRegisterName(i.FPRegister, i.Double))
+	}
+	return fmt.Sprintf("%s %s, %s", name, fpRegisterName(i.FPRegister, i.Double), registers)
+}
+
+func (i *InstructionFloatSystem) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionFloatSystem) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= types.MnemonicToBits[i.Mnemonic]         // Fixed bits
+	binary |= i.System << 16
+	binary |= i.Register << 12
+
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a VMRS or VMSR of FPSID, FPSCR or FPEXC from a machine word.
+func (i *InstructionFloatSystem) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	var mnemonic types.MnemonicType
+	switch word & 0x0FF00FFF {
+	case types.MnemonicToBits[types.MnemonicVMRS]:
+		mnemonic = types.MnemonicVMRS
+	case types.MnemonicToBits[types.MnemonicVMSR]:
+		mnemonic = types.MnemonicVMSR
+	default:
+		return fmt.Errorf("0x%08X isn't a VMRS or VMSR", word)
+	}
+	decoded := InstructionFloatSystem{Mnemonic: mnemonic, Condition: condition, Register: word >> 12 & 0xF, System: word >> 16 & 0xF}
+	if _, ok := floatSystemNames[decoded.System]; !ok {
+		return fmt.Errorf("%s in 0x%08X has an unsupported system register", types.MnemonicToLiteral[mnemonic], word)
+	}
+	if decoded.Register == 15 && (mnemonic == types.MnemonicVMSR || decoded.System != FPSCR) {
+		return fmt.Errorf("%s in 0x%08X uses the PC", types.MnemonicToLiteral[mnemonic], word)
+	}
+
+	*i = decoded
+	return nil
+}
+
+func (i *InstructionFloatSystem) String() string {
+	name := mnemonic(i.Mnemonic, "", i.Condition)
+	if i.Mnemonic == types.MnemonicVMSR {
+		return fmt.Sprintf("%s %s, %s", name, floatSystemNames[i.System], registerName(i.Register))
+	}
+	if i.Register == 15 {
+		return fmt.Sprintf("%s APSR_nzcv, %s", name, floatSystemNames[i.System])
+	}
+	return fmt.Sprintf("%s %s, %s", name, registerName(i.Register), floatSystemNames[i.System])
+}
+
+func (i *InstructionFloatMemory) SourceToken() types.Token {
+	return i.Token
+}
+
+func (i *InstructionFloatMemory) ToMachineCode(labels map[string]uint32) ([]byte, error) {
+	var binary uint32
+	binary |= types.ConditionToBits[i.Condition] << 28 // Condition code
+	binary |= types.MnemonicToBits[i.Mnemonic]         // Fixed bits
+	binary |= 1 << 23                                  // U bit, the offset is always added
+	binary |= i.BaseRegister << 16
+	binary |= fpD(i.FPRegister, i.Double)
+	if i.Double {
+		binary |= 1 << 8 // Double precision
+	}
+	binary |= i.Offset / 4
+
+	return utils.BitsToBytes(binary), nil
+}
+
+// Decode fills in a VLDR or VSTR with a positive offset from a machine word.
+func (i *InstructionFloatMemory) Decode(word uint32) error {
+	condition, err := decodeCondition(word)
+	if err != nil {
+		return err
+	}
+	var mnemonic types.MnemonicType
+	switch word & 0x0F300E00 {
+	case types.MnemonicToBits[types.MnemonicVLDR]:
+		mnemonic = types.MnemonicVLDR
+	case types.MnemonicToBits[types.MnemonicVSTR]:
+		mnemonic = types.MnemonicVSTR
+	default:
+		return fmt.Errorf("0x%08X isn't a VLDR or VSTR", word)
+	}
+	if word>>23&1 == 0 {
+		return fmt.Errorf("%s in 0x%08X subtracts its offset", types.MnemonicToLiteral[mnemonic], word)
+	}
+	double := word>>8&1 == 1
+
+	*i = InstructionFloatMemory{
+		Mnemonic:     mnemonic,
+		Condition:    condition,
+		Double:       double,
+		FPRegister:   fpRegister(word>>12&0xF, word>>22&1, double),
+		BaseRegister: word >> 16 & 0xF,
+		Offset:       word & 0xFF * 4,
+	}
+	return nil
+}
+
+func (i *InstructionFloatMemory) String() string {
+	name := mnemonic(i.Mnemonic, "", i.Condition)
+	if i.Offset == 0 {
+		return fmt.Sprintf("%s %s, [%s]", name, fpRegisterName(i.FPRegister, i.Double), registerName(i.BaseRegister))
+	}
+	return fmt.Sprintf("%s %s, [%s, #%d]", name, fpRegisterName(i.FPRegister, i.Double), registerName(i.BaseRegister), i.Offset)
+}
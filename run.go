@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/assembler"
+	"github.com/robertjshirts/rogasmic/bcm"
+	"github.com/robertjshirts/rogasmic/debugger"
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/format"
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/object"
+)
+
+// runRun implements "rogasmic run [-T script.ld] [-base addr] [-steps n] [-halt addr] [-fast] [-cores n] [-seed n]
+// [-gpio] [-serial-in file] [-input text] [-serial-out file] program". The program can be an assembly source, an object
+// file, an ELF file or an image in any output format. It runs with the Raspberry Pi peripherals mapped in, the UARTs
+// connected to stdout and whatever input is given.
+func runRun(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	scriptFile := flags.String("T", "", "linker script for sources and objects (default places everything at 0x8000)")
+	base := flags.Uint("base", 0x8000, "load address of a flat binary")
+	steps := flags.Uint64("steps", 10000000, "stop after this many instructions, 0 for no limit")
+	haltAt := flags.Uint("halt", 0, "stop before executing this address")
+	fast := flags.Bool("fast", false, "skip countdown delay loops, they still count towards -steps and the clock")
+	cores := flags.Int("cores", 1, "cores to start at the entry point, up to 4")
+	seed := flags.Int64("seed", 0, "interleave the cores at random from this seed instead of in turn")
+	gpio := flags.Bool("gpio", false, "print every GPIO pin transition")
+	serialIn := flags.String("serial-in", "", "file the UARTs receive from, - for stdin")
+	input := flags.String("input", "", "text the UARTs receive, Go escapes like \\n work")
+	serialOut := flags.String("serial-out", "-", "file the UARTs transmit to, - for stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: rogasmic run [-T script.ld] [-base addr] [-steps n] [-halt addr] [-fast] [-cores n] [-seed n] [-gpio] [-serial-in file] [-input text] [-serial-out file] program")
+	}
+
+	machine := bcm.NewMachine()
+	prog, err := loadProgram(machine.CPU, flags.Arg(0), *scriptFile, uint32(*base))
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	if err := startCores(machine, *cores, *seed); err != nil {
+		return err
+	}
+	for _, cpu := range machine.Cores[:*cores] {
+		cpu.FastForward = *fast
+		prog.reportTraps(cpu, os.Stdout)
+		if *haltAt != 0 {
+			cpu.HaltAt(uint32(*haltAt))
+		}
+	}
+	closeSerial, err := connectSerial(machine.Terminal, *serialIn, *input, *serialOut)
+	if err != nil {
+		return err
+	}
+	defer closeSerial()
+
+	err = machine.Run(*steps)
+	for _, cpu := range machine.Cores[:*cores] {
+		if *cores > 1 {
+			fmt.Printf("Core %d: ", cpu.ID)
+		}
+		if cpu.Halted {
+			fmt.Printf("Halted after %d steps (%d us): %s\n", cpu.Steps, machine.Ticks(bcm.SystemTimerClock), cpu.HaltReason)
+		} else if err != nil {
+			fmt.Printf("Stopped after %d steps (%d us): %s\n", cpu.Steps, machine.Ticks(bcm.SystemTimerClock), prog.stopReason(cpu, err))
+		}
+		cpu.WriteRegisters(os.Stdout)
+	}
+	if *gpio {
+		for _, transition := range machine.GPIO.Trace() {
+			fmt.Println(transition)
+		}
+	}
+	if err == emu.ErrStepLimit {
+		return nil
+	}
+	return err
+}
+
+// startCores starts the secondary cores at core 0's entry point, and has the machine interleave them at random when
+// seed isn't 0.
+func startCores(machine *bcm.Machine, cores int, seed int64) error {
+	if cores < 1 || cores > len(machine.Cores) {
+		return fmt.Errorf("-cores must be between 1 and %d", len(machine.Cores))
+	}
+	for n := 1; n < cores; n++ {
+		machine.Start(n, machine.CPU.R[emu.PC])
+	}
+	if seed != 0 {
+		machine.Rand = rand.New(rand.NewSource(seed))
+	}
+	return nil
+}
+
+// connectSerial types the scripted input and then the input file into the terminal, and points its output at a file
+// or stdout. The returned function closes the files.
+func connectSerial(terminal *bcm.Terminal, serialIn string, input string, serialOut string) (func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+
+	if input != "" {
+		text, err := strconv.Unquote(`"` + strings.ReplaceAll(input, `"`, `\"`) + `"`)
+		if err != nil {
+			return nil, fmt.Errorf("bad -input text: %w", err)
+		}
+		terminal.Type([]byte(text))
+	}
+	switch serialIn {
+	case "":
+	case "-":
+		terminal.Attach(os.Stdin)
+	default:
+		data, err := os.ReadFile(serialIn)
+		if err != nil {
+			return nil, err
+		}
+		terminal.Type(data)
+	}
+
+	switch serialOut {
+	case "-":
+		terminal.SetOutput(os.Stdout)
+	default:
+		file, err := os.Create(serialOut)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		terminal.SetOutput(file)
+	}
+	return closeAll, nil
+}
+
+// program is what's known about a loaded program beyond its bytes: the symbols of sources and objects, which are
+// linked when they're loaded, and the lines of sources.
+type program struct {
+	symbols []linker.ImageSymbol
+	source  *debugger.Source
+}
+
+// loadProgram loads a source, object, ELF or image file into the CPU and points the PC at its entry point. Sources and
+// objects are linked with scriptFile, or the default script if it's "", and flat binaries are loaded at base.
+func loadProgram(cpu *emu.CPU, path string, scriptFile string, base uint32) (*program, error) {
+	if linkable(path) {
+		a, image, err := linkFile(path, scriptFile)
+		if err != nil {
+			return nil, err
+		}
+		cpu.LoadLinked(image)
+		p := &program{symbols: image.Symbols}
+		if a != nil {
+			p.source = debugger.NewSource(a.File, a.Text, a.Instructions, a.Sections, image)
+		}
+		return p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if emu.IsELF(data) {
+		return &program{}, cpu.LoadELF(data)
+	}
+	in := format.Detect(data)
+	image, err := format.Read(bytes.NewReader(data), in)
+	if err != nil {
+		return nil, err
+	}
+	if in == format.FormatBinary {
+		image.Address = base
+	}
+	cpu.LoadImage(image.Data, image.Address)
+	if image.HasEntry {
+		cpu.R[emu.PC] = image.Entry
+	}
+	return &program{}, nil
+}
+
+// linkable reports whether path is a source or object file, which has to be linked before it can be loaded.
+func linkable(path string) bool {
+	return strings.HasSuffix(path, ".asm") || strings.HasSuffix(path, ".s") || strings.HasSuffix(path, ".o")
+}
+
+// linkFile assembles a source file, or reads an object file, and links it on its own with scriptFile, or the default
+// script if it's "". The source is nil for object files.
+func linkFile(path string, scriptFile string) (*assembler.Source, *linker.Image, error) {
+	var a *assembler.Source
+	var obj *object.Object
+	var err error
+	if strings.HasSuffix(path, ".o") {
+		obj, err = object.ReadFile(path)
+	} else if a, err = assembleSource(path, false); err == nil {
+		obj = a.Object
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	script, err := loadScript(scriptFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	image, err := linker.NewLinker([]*object.Object{obj}, script).Link()
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, image, nil
+}
+
+// loadScript parses a linker script file, or the default script if file is "".
+func loadScript(file string) (*linker.Script, error) {
+	if file == "" {
+		return linker.ParseScript(linker.DefaultScript)
+	}
+	return linker.ParseScriptFile(file)
+}
+
+// location describes the source line an address was assembled from, e.g. " (blink.asm:12: LDR R0, [R1])", or
+// returns "" when there's no source to go on.
+func (p *program) location(address uint32) string {
+	if p.source == nil {
+		return ""
+	}
+	line, ok := p.source.Line(address)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" (%s:%d: %s)", p.source.File, line, p.source.Text(line))
+}
+
+// reportTraps prints every undefined instruction and abort the program's handlers are given, with the line that
+// raised it.
+func (p *program) reportTraps(cpu *emu.CPU, w io.Writer) {
+	cpu.ExceptionHook = func(e emu.Exception, address uint32) {
+		switch e {
+		case emu.ExceptionUndefined, emu.ExceptionPrefetchAbort:
+			fmt.Fprintf(w, "Trap: %s at 0x%08X%s\n", e, address, p.location(address))
+		case emu.ExceptionDataAbort:
+			fmt.Fprintf(w, "Trap: %s accessing 0x%08X at 0x%08X%s\n", e, cpu.FaultAddress, address, p.location(address))
+		}
+	}
+}
+
+// stopReason says why a core that didn't halt stopped, when running the machine returned err.
+func (p *program) stopReason(cpu *emu.CPU, err error) string {
+	var coreErr *bcm.CoreError
+	if errors.As(err, &coreErr) {
+		if coreErr.Core != int(cpu.ID) {
+			return fmt.Sprintf("core %d faulted", coreErr.Core)
+		}
+		err = coreErr.Err
+	}
+	return p.describeStop(err)
+}
+
+// describeStop adds the source line of a fault to its message.
+func (p *program) describeStop(err error) string {
+	var fault *emu.Fault
+	if errors.As(err, &fault) {
+		return err.Error() + p.location(fault.Address)
+	}
+	return err.Error()
+}
@@ -0,0 +1,136 @@
+// Package semihost runs programs with their supervisor calls handled in Go. Out of the box it answers the ARM
+// semihosting calls to write a character or string, read a character and exit, so a test program can print its
+// results and exit with a status a Go test checks, without a UART or a vector table.
+package semihost
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/emu"
+)
+
+// Number is the SVC number semihosting calls use in ARM state.
+const Number = 0x123456
+
+// Semihosting operations, passed in R0 with their parameter in R1
+const (
+	SysWriteC       = 0x03 // R1 points at the character to write
+	SysWrite0       = 0x04 // R1 points at a NUL terminated string to write
+	SysReadC        = 0x07 // Reads a character into R0, 0xFFFFFFFF at the end of the input
+	SysExit         = 0x18 // R1 is ApplicationExit to exit with 0, any other reason exits with 1
+	SysExitExtended = 0x20 // R1 points at the reason and then the exit code, used with ApplicationExit
+)
+
+// ApplicationExit is the SysExit reason for a program that finished normally.
+const ApplicationExit = 0x20026
+
+// maxString is the longest string SysWrite0 writes, in case R1 points at something that isn't NUL terminated.
+const maxString = 0x10000
+
+// Handler handles a supervisor call. The number it was given is in the table it's registered under, the arguments
+// are in the registers and anything it returns goes in them too. The PC is still the address of the SVC, the core
+// moves past it afterwards.
+type Handler func(cpu *emu.CPU) error
+
+// Runner runs a program on a core with supervisor calls going to its handler table, keyed by SVC number. Numbers
+// without a handler are taken as exceptions like they would be without the runner.
+type Runner struct {
+	CPU      *emu.CPU
+	Handlers map[uint32]Handler
+	Output   io.Writer // Where SysWriteC and SysWrite0 write to
+	Input    io.Reader // Where SysReadC reads from, nil for no input
+
+	Exited   bool // Set once the program exits with SysExit or SysExitExtended
+	ExitCode int
+
+	input *bufio.Reader
+}
+
+// New creates a runner for cpu that answers semihosting calls on Number, writing to output and reading from input.
+func New(cpu *emu.CPU, output io.Writer, input io.Reader) *Runner {
+	r := &Runner{CPU: cpu, Handlers: make(map[uint32]Handler), Output: output, Input: input}
+	r.Handle(Number, r.semihosting)
+	cpu.SupervisorCall = r.supervisorCall
+	return r
+}
+
+// Handle registers the handler for an SVC number, replacing any there was.
+func (r *Runner) Handle(number uint32, handler Handler) {
+	r.Handlers[number] = handler
+}
+
+// Run runs the program until it exits, halts, faults or executes limit more instructions, like emu.CPU.Run. Exiting
+// halts the core, so Run returns nil and Exited and ExitCode say how it went.
+func (r *Runner) Run(limit uint64) error {
+	return r.CPU.Run(limit)
+}
+
+// Exit stops the program with an exit code, as a handler would on the program's behalf.
+func (r *Runner) Exit(code int) {
+	r.Exited, r.ExitCode = true, code
+	r.CPU.Halt(fmt.Sprintf("exited with %d", code))
+}
+
+func (r *Runner) supervisorCall(number uint32) (bool, error) {
+	handler, ok := r.Handlers[number]
+	if !ok {
+		return false, nil
+	}
+	return true, handler(r.CPU)
+}
+
+// semihosting runs the semihosting operation in R0.
+func (r *Runner) semihosting(cpu *emu.CPU) error {
+	parameter := cpu.R[1]
+	switch cpu.R[0] {
+	case SysWriteC:
+		_, err := r.Output.Write([]byte{cpu.Memory.Read8(parameter)})
+		return err
+	case SysWrite0:
+		var text strings.Builder
+		for address := parameter; address-parameter < maxString; address++ {
+			c := cpu.Memory.Read8(address)
+			if c == 0 {
+				break
+			}
+			text.WriteByte(c)
+		}
+		_, err := io.WriteString(r.Output, text.String())
+		return err
+	case SysReadC:
+		cpu.R[0] = r.readChar()
+	case SysExit:
+		code := 1
+		if parameter == ApplicationExit {
+			code = 0
+		}
+		r.Exit(code)
+	case SysExitExtended:
+		code := 1
+		if cpu.Memory.Read32(parameter) == ApplicationExit {
+			code = int(int32(cpu.Memory.Read32(parameter + 4)))
+		}
+		r.Exit(code)
+	default:
+		return fmt.Errorf("unsupported semihosting operation 0x%X at 0x%08X", cpu.R[0], cpu.R[emu.PC])
+	}
+	return nil
+}
+
+// readChar reads the next byte of the input, or returns 0xFFFFFFFF once there's none left.
+func (r *Runner) readChar() uint32 {
+	if r.Input == nil {
+		return 0xFFFFFFFF
+	}
+	if r.input == nil {
+		r.input = bufio.NewReader(r.Input)
+	}
+	c, err := r.input.ReadByte()
+	if err != nil {
+		return 0xFFFFFFFF
+	}
+	return uint32(c)
+}
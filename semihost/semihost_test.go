@@ -0,0 +1,133 @@
+package semihost
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/internal/emutest"
+)
+
+// echo writes back every character it reads, then exits with 3.
+const echo = `MOV32 R2, #0x9000
+loop:
+MOVW R0, #0x07
+SVC #0x123456
+ADDS R3, R0, #1
+BEQ done
+STR R0, [R2]
+MOVW R0, #0x03
+ADD R1, R2, #0
+SVC #0x123456
+B loop
+done:
+MOV32 R1, block
+MOVW R0, #0x20
+SVC #0x123456
+end: B end
+block:
+.word 0x20026, 3
+`
+
+func TestRunner(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		input  string
+		output string
+		exited bool
+		code   int
+		err    string
+	}{
+		{
+			name:   "write string and exit",
+			source: "MOV32 R1, hello\nMOVW R0, #0x04\nSVC #0x123456\nMOVW R0, #0x18\nMOV32 R1, #0x20026\nSVC #0x123456\nMOVW R5, #1\nhello:\n.word 0x0A216948, 0",
+			output: "Hi!\n",
+			exited: true,
+		},
+		{
+			name:   "echo",
+			source: echo,
+			input:  "abc",
+			output: "abc",
+			exited: true,
+			code:   3,
+		},
+		{
+			name:   "abnormal exit",
+			source: "MOVW R0, #0x18\nMOVW R1, #0x23\nSVC #0x123456",
+			exited: true,
+			code:   1,
+		},
+		{
+			name:   "conditional call skipped",
+			source: "MOVW R0, #0x18\nSVCEQ #0x123456\nend: B end",
+		},
+		{
+			name:   "unsupported operation",
+			source: "MOVW R0, #0x99\nSWI #0x123456",
+			err:    "unsupported semihosting operation 0x99 at 0x00008004",
+		},
+		{
+			name:   "other numbers trap",
+			source: "SVC #0x42",
+			err:    "supervisor call",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var output bytes.Buffer
+			cpu, _ := emutest.Load(t, c.source)
+			r := New(cpu, &output, strings.NewReader(c.input))
+			err := r.Run(1000)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error containing %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error running: %v", err)
+			}
+			if output.String() != c.output {
+				t.Errorf("expected output %q, got %q", c.output, output.String())
+			}
+			if r.Exited != c.exited || r.ExitCode != c.code {
+				t.Errorf("expected exited %v with %d, got %v with %d", c.exited, c.code, r.Exited, r.ExitCode)
+			}
+			if r.CPU.R[5] != 0 {
+				t.Errorf("expected nothing to run after exiting")
+			}
+		})
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	cpu, _ := emutest.Load(t, "MOVW R0, #2\nSVC #0x10\nSVC #0x10\nSVC #0x11\nend: B end")
+	r := New(cpu, nil, nil)
+	r.Handle(0x10, func(cpu *emu.CPU) error {
+		cpu.R[0] *= 3
+		return nil
+	})
+	stop := errors.New("stop")
+	r.Handle(0x11, func(*emu.CPU) error { return stop })
+	if err := r.Run(100); err != stop {
+		t.Fatalf("expected the handler's error, got %v", err)
+	}
+	if cpu.R[0] != 18 || cpu.R[emu.PC] != 0x8010 {
+		t.Errorf("expected R0 18 after the SVCs, got %d at 0x%08X", cpu.R[0], cpu.R[emu.PC])
+	}
+
+	cpu, _ = emutest.Load(t, "SVC #0x12\nend: B end")
+	r = New(cpu, nil, nil)
+	r.Handle(0x12, func(*emu.CPU) error {
+		r.Exit(7)
+		return nil
+	})
+	if err := r.Run(100); err != nil || !r.Exited || r.ExitCode != 7 || cpu.HaltReason != "exited with 7" {
+		t.Errorf("expected to exit with 7, got %v, %v and %d (%q)", err, r.Exited, r.ExitCode, cpu.HaltReason)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/bcm"
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/trace"
+)
+
+// runTrace implements "rogasmic trace [-o file] [-format text|binary|none] [-profile file] [-steps n] [-T script.ld]
+// [-base addr] [-cores n] [-seed n] [-serial-in file] [-input text] [-serial-out file] program", and "rogasmic trace
+// -decode file" to print a binary trace as text. The program runs like it does for run, one instruction at a time.
+// With more than one core each gets its own trace file, named after the -o file with .coreN before the extension.
+func runTrace(args []string) error {
+	flags := flag.NewFlagSet("trace", flag.ExitOnError)
+	outputFile := flags.String("o", "-", "file to write the trace to, - for stdout")
+	outputFormat := flags.String("format", "text", "trace format: text, binary, or none for just the profile")
+	profileFile := flags.String("profile", "", "write a profile report to a file, - for stdout")
+	steps := flags.Uint64("steps", 10000000, "stop after this many instructions, 0 for no limit")
+	scriptFile := flags.String("T", "", "linker script for sources and objects (default places everything at 0x8000)")
+	base := flags.Uint("base", 0x8000, "load address of a flat binary")
+	cores := flags.Int("cores", 1, "cores to start at the entry point, up to 4")
+	seed := flags.Int64("seed", 0, "interleave the cores at random from this seed instead of in turn")
+	decode := flags.String("decode", "", "print a binary trace as text instead of running anything")
+	serialIn := flags.String("serial-in", "", "file the UARTs receive from, - for stdin")
+	input := flags.String("input", "", "text the UARTs receive, Go escapes like \\n work")
+	serialOut := flags.String("serial-out", "-", "file the UARTs transmit to, - for stdout")
+	flags.Parse(args)
+
+	if *decode != "" {
+		return decodeTrace(*decode, *outputFile)
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: rogasmic trace [-o file] [-format text|binary|none] [-profile file] [-steps n] [-T script.ld] [-base addr] [-cores n] [-seed n] [-serial-in file] [-input text] [-serial-out file] program")
+	}
+
+	machine := bcm.NewMachine()
+	prog, err := loadProgram(machine.CPU, flags.Arg(0), *scriptFile, uint32(*base))
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	if err := startCores(machine, *cores, *seed); err != nil {
+		return err
+	}
+	for _, cpu := range machine.Cores[:*cores] {
+		prog.reportTraps(cpu, os.Stderr)
+	}
+	symbols := trace.NewSymbols(prog.symbols)
+	closeSerial, err := connectSerial(machine.Terminal, *serialIn, *input, *serialOut)
+	if err != nil {
+		return err
+	}
+	defer closeSerial()
+
+	writers := make([]trace.Writer, *cores)
+	if *outputFormat != "none" {
+		if *cores > 1 && *outputFile == "-" {
+			return fmt.Errorf("tracing more than one core needs -o with a file name")
+		}
+		for n := range writers {
+			path := *outputFile
+			if *cores > 1 {
+				path = coreFile(path, n)
+			}
+			out, closeOutput, err := createOutput(path)
+			if err != nil {
+				return err
+			}
+			defer closeOutput()
+			switch *outputFormat {
+			case "text":
+				writers[n] = trace.NewTextWriter(out, symbols)
+			case "binary":
+				writers[n] = trace.NewBinaryWriter(out)
+			default:
+				return fmt.Errorf("unknown trace format %q", *outputFormat)
+			}
+		}
+	}
+
+	var profile *trace.Profile
+	if *profileFile != "" {
+		profile = trace.NewProfile()
+	}
+	current := machine.CPU
+	next := func() *emu.CPU {
+		if cpu := machine.Next(); cpu != nil {
+			current = cpu
+			return cpu
+		}
+		return nil
+	}
+	runErr := trace.RunCores(next, *steps, func(core int, e trace.Entry) error {
+		if profile != nil {
+			profile.Add(e)
+		}
+		if writers[core] != nil {
+			return writers[core].Write(e)
+		}
+		return nil
+	})
+	var fault *emu.Fault
+	if *cores > 1 && errors.As(runErr, &fault) {
+		runErr = &bcm.CoreError{Core: int(current.ID), Err: runErr}
+	}
+	for _, writer := range writers {
+		if writer == nil {
+			continue
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, cpu := range machine.Cores[:*cores] {
+		if *cores > 1 {
+			fmt.Fprintf(os.Stderr, "Core %d: ", cpu.ID)
+		}
+		if cpu.Halted {
+			fmt.Fprintf(os.Stderr, "Halted after %d steps: %s\n", cpu.Steps, cpu.HaltReason)
+		} else if runErr != nil {
+			fmt.Fprintf(os.Stderr, "Stopped after %d steps: %s\n", cpu.Steps, prog.stopReason(cpu, runErr))
+		}
+	}
+	if profile != nil {
+		out, closeProfile, err := createOutput(*profileFile)
+		if err != nil {
+			return err
+		}
+		defer closeProfile()
+		if err := profile.Write(out, symbols, machine.CPUClock); err != nil {
+			return err
+		}
+	}
+	if errors.Is(runErr, emu.ErrStepLimit) {
+		return nil
+	}
+	return runErr
+}
+
+// decodeTrace prints a binary trace as text.
+func decodeTrace(inputFile string, outputFile string) error {
+	file, err := os.Open(inputFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := trace.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", inputFile, err)
+	}
+	out, closeOutput, err := createOutput(outputFile)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	writer := trace.NewTextWriter(out, nil)
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writer.Flush()
+			return fmt.Errorf("%s: %w", inputFile, err)
+		}
+		if err := writer.Write(entry); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// coreFile names the trace file of a core after the -o file, e.g. trace.core1.txt for trace.txt.
+func coreFile(path string, core int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.core%d%s", strings.TrimSuffix(path, ext), core, ext)
+}
+
+// createOutput opens a file to write to, or stdout for -. The returned function closes it.
+func createOutput(path string) (io.Writer, func(), error) {
+	if path == "-" {
+		return os.Stdout, func() {}, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
@@ -0,0 +1,164 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The binary format starts with a header of the magic, a version byte and the number of the first step as a uvarint.
+// Each instruction is then a record of:
+//
+//	flags      byte: bit 0 set if the condition failed, bit 1 set if the address follows the last one
+//	address    uint32 little-endian, left out when bit 1 is set
+//	word       uint32 little-endian
+//	changed    uvarint mask of the registers that changed, bit 16 for the CPSR
+//	values     uvarint per set bit of the mask, lowest first
+//
+// A delay loop comes to around 12 bytes per instruction.
+const (
+	binaryMagic   = "RGTR"
+	binaryVersion = 1
+)
+
+const (
+	recordSkipped    = 1 << 0
+	recordSequential = 1 << 1
+)
+
+// BinaryWriter writes the compact binary trace format.
+type BinaryWriter struct {
+	out     *bufio.Writer
+	started bool
+	next    uint32 // Address that counts as sequential for the next record
+	buf     []byte
+}
+
+func NewBinaryWriter(w io.Writer) *BinaryWriter {
+	return &BinaryWriter{out: bufio.NewWriter(w)}
+}
+
+func (b *BinaryWriter) Write(e Entry) error {
+	buf := b.buf[:0]
+	if !b.started {
+		buf = append(buf, binaryMagic...)
+		buf = append(buf, binaryVersion)
+		buf = binary.AppendUvarint(buf, e.Step)
+		b.started = true
+	}
+
+	var flags byte
+	if !e.Executed {
+		flags |= recordSkipped
+	}
+	sequential := e.Address == b.next
+	if sequential {
+		flags |= recordSequential
+	}
+	buf = append(buf, flags)
+	if !sequential {
+		buf = binary.LittleEndian.AppendUint32(buf, e.Address)
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, e.Word)
+	var mask uint64
+	for _, change := range e.Changed {
+		mask |= 1 << change.Register
+	}
+	buf = binary.AppendUvarint(buf, mask)
+	for _, change := range e.Changed {
+		buf = binary.AppendUvarint(buf, uint64(change.Value))
+	}
+
+	b.next = e.Address + 4
+	if pc, ok := e.Wrote(15); ok {
+		b.next = pc
+	}
+	b.buf = buf
+	_, err := b.out.Write(buf)
+	return err
+}
+
+func (b *BinaryWriter) Flush() error {
+	return b.out.Flush()
+}
+
+// Reader reads back a binary trace.
+type Reader struct {
+	in   *bufio.Reader
+	step uint64
+	next uint32
+}
+
+// NewReader checks the header of a binary trace.
+func NewReader(r io.Reader) (*Reader, error) {
+	in := bufio.NewReader(r)
+	header := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, fmt.Errorf("reading trace header: %w", err)
+	}
+	if string(header[:len(binaryMagic)]) != binaryMagic {
+		return nil, fmt.Errorf("not a binary trace")
+	}
+	if header[len(binaryMagic)] != binaryVersion {
+		return nil, fmt.Errorf("unsupported trace version %d", header[len(binaryMagic)])
+	}
+	step, err := binary.ReadUvarint(in)
+	if err != nil {
+		return nil, fmt.Errorf("reading trace header: %w", err)
+	}
+	return &Reader{in: in, step: step}, nil
+}
+
+// Next returns the next entry, or io.EOF after the last one.
+func (r *Reader) Next() (Entry, error) {
+	flags, err := r.in.ReadByte()
+	if err != nil {
+		return Entry{}, err
+	}
+	e := Entry{Step: r.step, Address: r.next, Executed: flags&recordSkipped == 0}
+	var word [4]byte
+	if flags&recordSequential == 0 {
+		if _, err := io.ReadFull(r.in, word[:]); err != nil {
+			return Entry{}, truncated(err)
+		}
+		e.Address = binary.LittleEndian.Uint32(word[:])
+	}
+	if _, err := io.ReadFull(r.in, word[:]); err != nil {
+		return Entry{}, truncated(err)
+	}
+	e.Word = binary.LittleEndian.Uint32(word[:])
+	mask, err := binary.ReadUvarint(r.in)
+	if err != nil {
+		return Entry{}, truncated(err)
+	}
+	if mask >= 1<<(CPSR+1) {
+		return Entry{}, fmt.Errorf("invalid register mask 0x%X at step %d", mask, r.step)
+	}
+	for register := 0; register <= CPSR; register++ {
+		if mask&(1<<register) == 0 {
+			continue
+		}
+		value, err := binary.ReadUvarint(r.in)
+		if err != nil {
+			return Entry{}, truncated(err)
+		}
+		e.Changed = append(e.Changed, Change{Register: register, Value: uint32(value)})
+	}
+
+	r.step++
+	r.next = e.Address + 4
+	if pc, ok := e.Wrote(15); ok {
+		r.next = pc
+	}
+	return e, nil
+}
+
+// truncated turns running out of data in the middle of a record into an error.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("trace ends in the middle of a record: %w", io.ErrUnexpectedEOF)
+	}
+	return err
+}
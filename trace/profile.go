@@ -0,0 +1,211 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// Estimated cycle costs, for a simple in-order pipeline without branch prediction: one cycle per instruction, two for
+// single loads and stores, one plus one per pair of registers for LDM and STM, and a refill penalty whenever the PC
+// is written. Instructions whose condition fails take a single cycle.
+const (
+	cyclesBase           = 1
+	cyclesMemory         = 2
+	cyclesPipelineRefill = 2
+)
+
+// Cycles estimates the cycles an instruction took.
+func Cycles(instruction types.Instruction, e Entry) uint64 {
+	if !e.Executed {
+		return cyclesBase
+	}
+	cycles := uint64(cyclesBase)
+	switch i := instruction.(type) {
+	case *parser.InstructionMemory:
+		cycles = cyclesMemory
+	case *parser.InstructionMemoryMultiple:
+		cycles = cyclesBase + uint64(bits.OnesCount32(i.Offset&0xFFFF)+1)/2
+	}
+	if _, ok := e.Wrote(15); ok {
+		cycles += cyclesPipelineRefill
+	}
+	return cycles
+}
+
+// Profile counts executed instructions per address and the iterations of every loop closed by a backward branch.
+type Profile struct {
+	Instructions uint64
+	Cycles       uint64
+
+	addresses map[uint32]*count
+	loops     map[uint32]*loop // By the address of the branch that closes the loop
+	decoded   map[uint32]types.Instruction
+}
+
+type count struct {
+	instructions uint64
+	cycles       uint64
+}
+
+// loop is the range from a branch target back up to the branch. Every time the branch runs is an iteration, and every
+// time it falls through the loop has finished a run.
+type loop struct {
+	start, end uint32
+	iterations uint64
+	current    uint64 // Iterations of the run in progress
+	runs       uint64 // Finished runs, and the fewest, most and total iterations they took
+	fewest     uint64
+	most       uint64
+	finished   uint64
+}
+
+func NewProfile() *Profile {
+	return &Profile{
+		addresses: make(map[uint32]*count),
+		loops:     make(map[uint32]*loop),
+		decoded:   make(map[uint32]types.Instruction),
+	}
+}
+
+// Add counts an executed instruction.
+func (p *Profile) Add(e Entry) {
+	instruction, ok := p.decoded[e.Word]
+	if !ok {
+		instruction, _ = parser.Decode(e.Word)
+		p.decoded[e.Word] = instruction
+	}
+	cycles := Cycles(instruction, e)
+	p.Instructions++
+	p.Cycles += cycles
+	c := p.addresses[e.Address]
+	if c == nil {
+		c = &count{}
+		p.addresses[e.Address] = c
+	}
+	c.instructions++
+	c.cycles += cycles
+
+	branch, ok := instruction.(*parser.InstructionBranch)
+	if !ok || branch.LBit != 0 {
+		return
+	}
+	target := branch.Target(e.Address)
+	if target >= e.Address {
+		return // Forward branches and idle loops don't loop
+	}
+	l := p.loops[e.Address]
+	if l == nil {
+		l = &loop{start: target, end: e.Address}
+		p.loops[e.Address] = l
+	}
+	l.iterations++
+	l.current++
+	if pc, ok := e.Wrote(15); ok && pc == target {
+		return
+	}
+	if l.runs == 0 || l.current < l.fewest {
+		l.fewest = l.current
+	}
+	l.most = max(l.most, l.current)
+	l.finished += l.current
+	l.runs++
+	l.current = 0
+}
+
+// Write writes the report: totals, then instructions and cycles per label, then the loops that took the most cycles
+// with their iterations per run. Times are worked out from the estimated cycles at a clock of hz.
+func (p *Profile) Write(w io.Writer, symbols *Symbols, hz uint64) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "%d instructions, %d estimated cycles, %v at %d MHz\n", p.Instructions, p.Cycles, duration(p.Cycles, hz), hz/1000000)
+
+	type row struct {
+		name   string
+		counts count
+	}
+	byLabel := make(map[string]*row)
+	for address, c := range p.addresses {
+		name, _, ok := symbols.Label(address)
+		if !ok {
+			name = "(no label)"
+		}
+		r := byLabel[name]
+		if r == nil {
+			r = &row{name: name}
+			byLabel[name] = r
+		}
+		r.counts.instructions += c.instructions
+		r.counts.cycles += c.cycles
+	}
+	rows := make([]*row, 0, len(byLabel))
+	for _, r := range byLabel {
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].counts.cycles != rows[j].counts.cycles {
+			return rows[i].counts.cycles > rows[j].counts.cycles
+		}
+		return rows[i].name < rows[j].name
+	})
+	fmt.Fprintf(out, "\n%-24s %14s %7s %14s %7s\n", "Label", "Instructions", "%", "Cycles", "%")
+	for _, r := range rows {
+		fmt.Fprintf(out, "%-24s %14d %6.2f%% %14d %6.2f%%\n", r.name, r.counts.instructions, percent(r.counts.instructions, p.Instructions),
+			r.counts.cycles, percent(r.counts.cycles, p.Cycles))
+	}
+
+	if len(p.loops) == 0 {
+		return out.Flush()
+	}
+	type hot struct {
+		*loop
+		cycles uint64
+	}
+	var loops []hot
+	for _, l := range p.loops {
+		h := hot{loop: l}
+		for address := l.start; address >= l.start && address <= l.end; address += 4 {
+			if c := p.addresses[address]; c != nil {
+				h.cycles += c.cycles
+			}
+		}
+		loops = append(loops, h)
+	}
+	sort.Slice(loops, func(i, j int) bool {
+		if loops[i].cycles != loops[j].cycles {
+			return loops[i].cycles > loops[j].cycles
+		}
+		return loops[i].start < loops[j].start
+	})
+	fmt.Fprintf(out, "\n%-32s %12s %6s %10s %10s %10s %14s %14s\n", "Loop", "Iterations", "Runs", "Fewest", "Most", "Average",
+		"Cycles per run", "Time per run")
+	for _, l := range loops {
+		// Runs still going when the trace stopped only count if there's nothing else to go on
+		runs, fewest, most, finished := l.runs, l.fewest, l.most, l.finished
+		if runs == 0 {
+			runs, fewest, most, finished = 1, l.current, l.current, l.current
+		}
+		average := finished / runs
+		cycles := uint64(float64(l.cycles) / float64(l.iterations) * float64(finished) / float64(runs))
+		fmt.Fprintf(out, "%-32s %12d %6d %10d %10d %10d %14d %14v\n", symbols.describeRange(l.start, l.end), l.iterations,
+			l.runs, fewest, most, average, cycles, duration(cycles, hz))
+	}
+	return out.Flush()
+}
+
+func percent(part uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// duration converts cycles at hz into time.
+func duration(cycles uint64, hz uint64) time.Duration {
+	return time.Duration(float64(cycles) / float64(hz) * float64(time.Second))
+}
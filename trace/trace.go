@@ -0,0 +1,261 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/linker"
+	"github.com/robertjshirts/rogasmic/parser"
+	"github.com/robertjshirts/rogasmic/types"
+)
+
+// CPSR is the register number changes to the CPSR are recorded under, after R0-R15.
+const CPSR = 16
+
+// Entry is a single executed instruction and what it changed. The first instruction of an interrupt handler also
+// carries what taking the interrupt changed.
+type Entry struct {
+	Step     uint64 // Number of the instruction in the run, counting from 1
+	Address  uint32
+	Word     uint32
+	Executed bool     // False if its condition failed
+	Changed  []Change // In register order. The PC is only included when the instruction wrote it
+}
+
+// Change is the new value of a register, or of the CPSR.
+type Change struct {
+	Register int
+	Value    uint32
+}
+
+// Wrote reports whether the instruction changed a register, and the value it left there.
+func (e Entry) Wrote(register int) (uint32, bool) {
+	for _, change := range e.Changed {
+		if change.Register == register {
+			return change.Value, true
+		}
+	}
+	return 0, false
+}
+
+// Run single steps the CPU, passing every instruction it executes to record, until it halts, faults or has executed
+// limit instructions. A limit of 0 means no limit. Like emu.CPU.Run it returns nil when the CPU halts,
+// emu.ErrStepLimit when it runs out of steps and a *emu.Fault otherwise. Fast forwarding is never used, every
+// iteration of a delay loop is recorded.
+func Run(cpu *emu.CPU, limit uint64, record func(Entry) error) error {
+	next := func() *emu.CPU {
+		if cpu.Halted {
+			return nil
+		}
+		return cpu
+	}
+	return RunCores(next, limit, func(_ int, e Entry) error { return record(e) })
+}
+
+// RunCores is Run for several cores sharing memory. Before every instruction next picks the core to execute it,
+// returning nil once there's nothing left to run. Entries go to record along with the number of the core, their steps
+// counted per core, and it stops with emu.ErrStepLimit as soon as the core picked has executed limit instructions.
+func RunCores(next func() *emu.CPU, limit uint64, record func(core int, e Entry) error) error {
+	start := make(map[*emu.CPU]uint64)
+	for {
+		cpu := next()
+		if cpu == nil {
+			return nil
+		}
+		if _, ok := start[cpu]; !ok {
+			start[cpu] = cpu.Steps
+		}
+		if limit != 0 && cpu.Steps-start[cpu] >= limit {
+			return emu.ErrStepLimit
+		}
+		entry, err := step(cpu, start[cpu])
+		if err != nil {
+			return err
+		}
+		if err := record(int(cpu.ID), entry); err != nil {
+			return err
+		}
+	}
+}
+
+// step executes one instruction and returns what it did, numbering it from start.
+func step(cpu *emu.CPU, start uint64) (Entry, error) {
+	registers, cpsr := cpu.R, cpu.CPSR
+	cpu.TakeInterrupt()
+	address := cpu.R[emu.PC]
+	word := cpu.Memory.Read32(address)
+	executed := cpu.ConditionPassed(types.ConditionType(word >> 28))
+	if err := cpu.Step(); err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{Step: cpu.Steps - start, Address: address, Word: word, Executed: executed}
+	for r, value := range cpu.R {
+		if value != registers[r] && (r != emu.PC || value != address+4) {
+			entry.Changed = append(entry.Changed, Change{Register: r, Value: value})
+		}
+	}
+	if cpu.CPSR != cpsr {
+		entry.Changed = append(entry.Changed, Change{Register: CPSR, Value: cpu.CPSR})
+	}
+	return entry, nil
+}
+
+// Writer writes trace entries in one of the trace formats.
+type Writer interface {
+	Write(e Entry) error
+	Flush() error
+}
+
+var registerNames = []string{"R0", "R1", "R2", "R3", "R4", "R5", "R6", "R7", "R8", "R9", "R10", "R11", "R12", "SP", "LR", "PC", "CPSR"}
+
+// TextWriter writes a line per instruction: the step, address, closest label, disassembly and the registers and
+// flags it changed, e.g.
+//
+//	18  0x00008070  loop            SUBS R6, R6, #0x01            R6=0x003D08FF CPSR=nzCv
+type TextWriter struct {
+	out     *bufio.Writer
+	symbols *Symbols
+	decoded map[uint32]string
+
+	cpsr     uint32 // Last CPSR written, to tell when only the flags change
+	knowCPSR bool
+}
+
+const nzcv = emu.FlagN | emu.FlagZ | emu.FlagC | emu.FlagV
+
+func NewTextWriter(w io.Writer, symbols *Symbols) *TextWriter {
+	return &TextWriter{out: bufio.NewWriter(w), symbols: symbols, decoded: make(map[uint32]string)}
+}
+
+func (t *TextWriter) Write(e Entry) error {
+	text, ok := t.decoded[e.Word]
+	if !ok {
+		text = Disassemble(e.Word)
+		t.decoded[e.Word] = text
+	}
+	if target, ok := branchTarget(e); ok {
+		// Show where a branch goes rather than its offset
+		mnemonic, _, _ := strings.Cut(text, " ")
+		text = fmt.Sprintf("%s 0x%08X", mnemonic, target)
+		if name := t.symbols.Name(target); name != "" {
+			text += " <" + name + ">"
+		}
+	}
+
+	line := fmt.Sprintf("%8d  0x%08X  %-14s  %-28s", e.Step, e.Address, t.symbols.Name(e.Address), text)
+	if !e.Executed {
+		line += "  (condition failed)"
+	}
+	for i, change := range e.Changed {
+		if i == 0 {
+			line += " "
+		}
+		line += " " + t.formatChange(change)
+	}
+	t.out.WriteString(strings.TrimRight(line, " "))
+	return t.out.WriteByte('\n')
+}
+
+func (t *TextWriter) Flush() error {
+	return t.out.Flush()
+}
+
+// formatChange shows a register as NAME=0x.... The CPSR is shown as just its flags when nothing else in it changed
+// since the last change, and in full with its flags otherwise.
+func (t *TextWriter) formatChange(change Change) string {
+	if change.Register != CPSR {
+		return fmt.Sprintf("%s=0x%08X", registerNames[change.Register], change.Value)
+	}
+	flagsOnly := t.knowCPSR && (change.Value^t.cpsr)&^nzcv == 0
+	t.cpsr, t.knowCPSR = change.Value, true
+	if flagsOnly {
+		return "CPSR=" + emu.Flags(change.Value)
+	}
+	return fmt.Sprintf("CPSR=0x%08X(%s)", change.Value, emu.Flags(change.Value))
+}
+
+// branchTarget returns the target of a B or BL.
+func branchTarget(e Entry) (uint32, bool) {
+	if e.Word>>25&0b111 != 0b101 || e.Word>>28 == 0xF {
+		return 0, false
+	}
+	instruction, err := parser.Decode(e.Word)
+	if err != nil {
+		return 0, false
+	}
+	branch, ok := instruction.(*parser.InstructionBranch)
+	if !ok {
+		return 0, false
+	}
+	return branch.Target(e.Address), true
+}
+
+// Disassemble decodes a word, falling back to .word for anything that doesn't decode.
+func Disassemble(word uint32) string {
+	instruction, err := parser.Decode(word)
+	if err != nil {
+		return fmt.Sprintf(".word 0x%08X", word)
+	}
+	return instruction.String()
+}
+
+// Symbols names addresses after the closest label at or below them.
+type Symbols struct {
+	symbols []linker.ImageSymbol
+}
+
+// NewSymbols keeps the symbols of an image that belong to its input files. Symbols assigned in the linker script
+// mark the ends of regions rather than code, so they're left out.
+func NewSymbols(symbols []linker.ImageSymbol) *Symbols {
+	s := &Symbols{}
+	for _, symbol := range symbols {
+		if symbol.File != "" {
+			s.symbols = append(s.symbols, symbol)
+		}
+	}
+	sort.SliceStable(s.symbols, func(i, j int) bool { return s.symbols[i].Address < s.symbols[j].Address })
+	return s
+}
+
+// Label returns the closest label at or below an address and its address, or false if there isn't one.
+func (s *Symbols) Label(address uint32) (string, uint32, bool) {
+	if s == nil {
+		return "", 0, false
+	}
+	i := sort.Search(len(s.symbols), func(i int) bool { return s.symbols[i].Address > address })
+	if i == 0 {
+		return "", 0, false
+	}
+	// Prefer the first of several names for the same address
+	for i > 1 && s.symbols[i-2].Address == s.symbols[i-1].Address {
+		i--
+	}
+	return s.symbols[i-1].Name, s.symbols[i-1].Address, true
+}
+
+// Name names an address like loop or loop+0x4, or returns "" if there's no label below it.
+func (s *Symbols) Name(address uint32) string {
+	label, at, ok := s.Label(address)
+	switch {
+	case !ok:
+		return ""
+	case at == address:
+		return label
+	default:
+		return fmt.Sprintf("%s+0x%X", label, address-at)
+	}
+}
+
+// describeRange names a range of addresses, e.g. "loop (0x00008070-0x00008074)".
+func (s *Symbols) describeRange(from uint32, to uint32) string {
+	text := fmt.Sprintf("0x%08X-0x%08X", from, to)
+	if name := s.Name(from); name != "" {
+		return name + " (" + text + ")"
+	}
+	return text
+}
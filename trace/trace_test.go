@@ -0,0 +1,272 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robertjshirts/rogasmic/emu"
+	"github.com/robertjshirts/rogasmic/internal/emutest"
+)
+
+// record runs a program to the end and returns everything it executed.
+func record(t *testing.T, source string) ([]Entry, *Symbols) {
+	t.Helper()
+	cpu, image := emutest.Load(t, source)
+	var entries []Entry
+	err := Run(cpu, 1000, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error running: %v", err)
+	}
+	return entries, NewSymbols(image.Symbols)
+}
+
+const program = `MOVW R0, #2
+MOV32 R1, #0x9000
+loop:
+STR R0, [R1]
+SUBS R0, R0, #1
+MOVWEQ R2, #7
+BNE loop
+BL done
+end: B end
+done:
+BX lr
+`
+
+func TestRun(t *testing.T) {
+	entries, _ := record(t, program)
+	expected := []Entry{
+		{Step: 1, Address: 0x8000, Executed: true, Changed: []Change{{0, 2}}},
+		{Step: 2, Address: 0x8004, Executed: true, Changed: []Change{{1, 0x9000}}},
+		{Step: 3, Address: 0x8008, Executed: true},
+		{Step: 4, Address: 0x800C, Executed: true},
+		{Step: 5, Address: 0x8010, Executed: true, Changed: []Change{{0, 1}, {CPSR, 0x200000D3}}},
+		{Step: 6, Address: 0x8014, Executed: false},
+		{Step: 7, Address: 0x8018, Executed: true, Changed: []Change{{15, 0x800C}}},
+		{Step: 8, Address: 0x800C, Executed: true},
+		{Step: 9, Address: 0x8010, Executed: true, Changed: []Change{{0, 0}, {CPSR, 0x600000D3}}},
+		{Step: 10, Address: 0x8014, Executed: true, Changed: []Change{{2, 7}}},
+		{Step: 11, Address: 0x8018, Executed: false},
+		{Step: 12, Address: 0x801C, Executed: true, Changed: []Change{{14, 0x8020}, {15, 0x8024}}},
+		{Step: 13, Address: 0x8024, Executed: true, Changed: []Change{{15, 0x8020}}},
+		{Step: 14, Address: 0x8020, Executed: true},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(expected), len(entries), entries)
+	}
+	for i, entry := range entries {
+		entry.Word = 0 // Checked by the disassembly in TestTextWriter
+		if !reflect.DeepEqual(entry, expected[i]) {
+			t.Errorf("entry %d: expected %+v, got %+v", i, expected[i], entry)
+		}
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	cpu, _ := emutest.Load(t, "loop: B loop2\nloop2: B loop")
+	count := 0
+	if err := Run(cpu, 10, func(Entry) error { count++; return nil }); !errors.Is(err, emu.ErrStepLimit) || count != 10 {
+		t.Errorf("expected the step limit after 10 entries, got %v after %d", err, count)
+	}
+
+	cpu, _ = emutest.Load(t, ".word 0xFFFFFFFF")
+	var fault *emu.Fault
+	if err := Run(cpu, 10, func(Entry) error { return nil }); !errors.As(err, &fault) {
+		t.Errorf("expected a fault, got %v", err)
+	}
+
+	cpu, _ = emutest.Load(t, "MOVW R0, #1\nMOVW R0, #2")
+	stop := errors.New("stop")
+	if err := Run(cpu, 10, func(Entry) error { return stop }); err != stop || cpu.Steps != 1 {
+		t.Errorf("expected the record error after 1 step, got %v after %d", err, cpu.Steps)
+	}
+}
+
+func TestRunCores(t *testing.T) {
+	first, _ := emutest.Load(t, "MOVW R0, #1\nMOVW R1, #2\nend: B end")
+	second := emu.New(first.Memory)
+	second.ID = 1
+	second.R[emu.PC] = 0x8004
+	next := func() *emu.CPU {
+		switch {
+		case first.Halted && second.Halted:
+			return nil
+		case second.Halted || !first.Halted && first.Steps <= second.Steps:
+			return first
+		default:
+			return second
+		}
+	}
+	type step struct {
+		core    int
+		step    uint64
+		address uint32
+	}
+	var got []step
+	err := RunCores(next, 10, func(core int, e Entry) error {
+		got = append(got, step{core, e.Step, e.Address})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error running: %v", err)
+	}
+	expected := []step{{0, 1, 0x8000}, {1, 1, 0x8004}, {0, 2, 0x8004}, {1, 2, 0x8008}, {0, 3, 0x8008}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	first, _ = emutest.Load(t, "loop: B loop2\nloop2: B loop")
+	second = emu.New(first.Memory)
+	second.R[emu.PC] = 0x8000
+	count := 0
+	next = func() *emu.CPU {
+		if count%2 == 0 {
+			return first
+		}
+		return second
+	}
+	if err := RunCores(next, 3, func(int, Entry) error { count++; return nil }); !errors.Is(err, emu.ErrStepLimit) || count != 6 {
+		t.Errorf("expected the step limit after 6 entries, got %v after %d", err, count)
+	}
+}
+
+func TestTextWriter(t *testing.T) {
+	entries, symbols := record(t, program)
+	var out bytes.Buffer
+	w := NewTextWriter(&out, symbols)
+	for _, entry := range entries {
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"       1  0x00008000                  MOVW R0, #0x0002              R0=0x00000002",
+		"       2  0x00008004                  MOVW R1, #0x9000              R1=0x00009000",
+		"       3  0x00008008                  MOVT R1, #0x0000",
+		"       4  0x0000800C  loop            STR R0, [R1]",
+		"       5  0x00008010  loop+0x4        SUBS R0, R0, #0x1             R0=0x00000001 CPSR=0x200000D3(nzCv)",
+		"       6  0x00008014  loop+0x8        MOVWEQ R2, #0x0007            (condition failed)",
+		"       7  0x00008018  loop+0xC        BNE 0x0000800C <loop>         PC=0x0000800C",
+		"       9  0x00008010  loop+0x4        SUBS R0, R0, #0x1             R0=0x00000000 CPSR=nZCv",
+		"      12  0x0000801C  loop+0x10       BL 0x00008024 <done>          LR=0x00008020 PC=0x00008024",
+		"      13  0x00008024  done            BX LR                         PC=0x00008020",
+		"      14  0x00008020  end             B 0x00008020 <end>",
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(entries) {
+		t.Fatalf("expected %d lines, got:\n%s", len(entries), out.String())
+	}
+	for _, line := range expected {
+		found := false
+		for _, got := range lines {
+			found = found || got == line
+		}
+		if !found {
+			t.Errorf("expected line %q in:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	entries, _ := record(t, program)
+	var out bytes.Buffer
+	w := NewBinaryWriter(&out)
+	for _, entry := range entries {
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := out.Bytes()
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var read []Entry
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		read = append(read, entry)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("expected %+v, got %+v", entries, read)
+	}
+
+	// Cutting a record short is an error, cutting between records isn't
+	r, _ = NewReader(bytes.NewReader(data[:len(data)-2]))
+	for err = nil; err == nil; _, err = r.Next() {
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF, got %v", err)
+	}
+	if _, err := NewReader(strings.NewReader("RGTX\x01\x01")); err == nil || err.Error() != "not a binary trace" {
+		t.Errorf("expected a bad magic error, got %v", err)
+	}
+	if _, err := NewReader(strings.NewReader("RGTR\x02\x01")); err == nil || err.Error() != "unsupported trace version 2" {
+		t.Errorf("expected a version error, got %v", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	source := `start:
+MOVW R6, #3
+BL delay
+MOVW R6, #5
+BL delay
+end: B end
+
+delay:
+SUBS R6, R6, #1
+BPL delay
+BX lr
+`
+	entries, symbols := record(t, source)
+	p := NewProfile()
+	for _, entry := range entries {
+		p.Add(entry)
+	}
+	// 4 instructions in start, 1 in end, 4+6 iterations of 2 in delay and 2 BX
+	if p.Instructions != 5+2*(4+6)+2 {
+		t.Errorf("expected 27 instructions, got %d", p.Instructions)
+	}
+	// BL, taken BPL and BX refill the pipeline
+	if expected := uint64(27 + 2*2 + 2*(3+5) + 2*2); p.Cycles != expected {
+		t.Errorf("expected %d cycles, got %d", expected, p.Cycles)
+	}
+
+	var out bytes.Buffer
+	if err := p.Write(&out, symbols, 1000000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report := out.String()
+	for _, expected := range []string{
+		"27 instructions, 51 estimated cycles, 51µs at 1 MHz",
+		"delay                                22  81.48%             42  82.35%",
+		"start                                 4  14.81%              8  15.69%",
+		"end                                   1   3.70%              1   1.96%",
+		"delay (0x00008014-0x00008018)              10      2          4          6          5             18           18µs",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected %q in the report:\n%s", expected, report)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package types
+
+import "fmt"
+
+// DataType is the suffix of a VFP or NEON mnemonic saying what the operands hold, such as .F32 for single precision
+// floats, .S32 for signed words or .I8 for bytes of either sign. Loads and stores only care about the size, as in .32.
+type DataType struct {
+	Kind byte   // 'F', 'S', 'U' or 'I', or 0 for just a size
+	Size uint32 // In bits: 8, 16, 32 or 64
+}
+
+// Common data types
+var (
+	DataTypeF32 = DataType{Kind: 'F', Size: 32}
+	DataTypeF64 = DataType{Kind: 'F', Size: 64}
+	DataTypeS32 = DataType{Kind: 'S', Size: 32}
+	DataTypeU32 = DataType{Kind: 'U', Size: 32}
+)
+
+func (d DataType) String() string {
+	if d.Kind == 0 {
+		return fmt.Sprintf(".%d", d.Size)
+	}
+	return fmt.Sprintf(".%c%d", d.Kind, d.Size)
+}
@@ -2,6 +2,30 @@ package types
 
 type Instruction interface {
 	ToMachineCode(labels map[string]uint32) ([]byte, error)
+	SourceToken() Token       // The mnemonic or directive token the instruction was parsed from
+	Decode(word uint32) error // Fills in the instruction from a machine word, if the assembler could have produced it
+	String() string           // Canonical source, assembles back into the same word
 }
 
+// Sized is implemented by instructions that don't assemble to a single 4 byte word, like Thumb instructions.
+type Sized interface {
+	Size() uint32
+}
+
+// Size returns how many bytes an instruction assembles to.
+func Size(instruction Instruction) uint32 {
+	if sized, ok := instruction.(Sized); ok {
+		return sized.Size()
+	}
+	return 4
+}
+
+// ThumbCode is implemented by instructions that assemble to Thumb code, so objects can record which bytes are Thumb
+// and which are ARM.
+type ThumbCode interface {
+	IsThumb() bool
+}
+
+// LabelMap maps label names to their byte offset in the laid out instructions. Labels in Thumb code have bit 0 set,
+// like Thumb addresses do, so branches know whether they have to switch instruction set.
 type LabelMap map[string]uint32
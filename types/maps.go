@@ -1,111 +1,451 @@
 package types
 
 var LiteralToMnemonicToken = map[string]TokenType{
-	"movw": TokenMOVW,
-	"movt": TokenMOVT,
-	"ldr":  TokenLDR,
-	"str":  TokenSTR,
-	"ldm":  TokenLDM,
-	"stm":  TokenSTM,
-	"add":  TokenADD,
-	"sub":  TokenSUB,
-	"and":  TokenAND,
-	"orr":  TokenORR,
-	"b":    TokenB,
-	"bl":   TokenBL,
-	"bx":   TokenBX,
+	"movw":   TokenMOVW,
+	"movt":   TokenMOVT,
+	"ldr":    TokenLDR,
+	"str":    TokenSTR,
+	"ldm":    TokenLDM,
+	"stm":    TokenSTM,
+	"add":    TokenADD,
+	"sub":    TokenSUB,
+	"and":    TokenAND,
+	"orr":    TokenORR,
+	"b":      TokenB,
+	"bl":     TokenBL,
+	"bx":     TokenBX,
+	"blx":    TokenBLX,
+	"svc":    TokenSVC,
+	"swi":    TokenSWI,
+	"mrs":    TokenMRS,
+	"msr":    TokenMSR,
+	"cps":    TokenCPS,
+	"cpsie":  TokenCPS,
+	"cpsid":  TokenCPS,
+	"setend": TokenSETEND,
+	"mcr":    TokenMCR,
+	"mrc":    TokenMRC,
+	"mcrr":   TokenMCRR,
+	"mrrc":   TokenMRRC,
+	"dmb":    TokenDMB,
+	"dsb":    TokenDSB,
+	"isb":    TokenISB,
+	"clrex":  TokenCLREX,
+	"nop":    TokenNOP,
+	"yield":  TokenYIELD,
+	"wfe":    TokenWFE,
+	"wfi":    TokenWFI,
+	"sev":    TokenSEV,
+	"ldrex":  TokenLDREX,
+	"strex":  TokenSTREX,
+	"it":     TokenIT,
+	"itt":    TokenIT,
+	"ite":    TokenIT,
+	"ittt":   TokenIT,
+	"itte":   TokenIT,
+	"itet":   TokenIT,
+	"itee":   TokenIT,
+	"itttt":  TokenIT,
+	"ittte":  TokenIT,
+	"ittet":  TokenIT,
+	"ittee":  TokenIT,
+	"itett":  TokenIT,
+	"itete":  TokenIT,
+	"iteet":  TokenIT,
+	"iteee":  TokenIT,
+	"clz":    TokenCLZ,
+	"rev":    TokenREV,
+	"rev16":  TokenREV16,
+	"uxtb":   TokenUXTB,
+	"uxth":   TokenUXTH,
+	"sxtb":   TokenSXTB,
+	"sxth":   TokenSXTH,
+	"ubfx":   TokenUBFX,
+	"sbfx":   TokenSBFX,
+	"bfi":    TokenBFI,
+	"sdiv":   TokenSDIV,
+	"udiv":   TokenUDIV,
+	"vmov":   TokenVMOV,
+	"vldr":   TokenVLDR,
+	"vstr":   TokenVSTR,
+	"vadd":   TokenVADD,
+	"vsub":   TokenVSUB,
+	"vmul":   TokenVMUL,
+	"vdiv":   TokenVDIV,
+	"vcvt":   TokenVCVT,
+	"vmrs":   TokenVMRS,
+	"vmsr":   TokenVMSR,
+	"vld1":   TokenVLD1,
+	"vst1":   TokenVST1,
+	"mov32":  TokenMOV32,
 }
 
 var TokenToMnemonic = map[TokenType]MnemonicType{
-	TokenMOVW: MnemonicMOVW,
-	TokenMOVT: MnemonicMOVT,
-	TokenLDR:  MnemonicLDR,
-	TokenSTR:  MnemonicSTR,
-	TokenLDM:  MnemonicLDM,
-	TokenSTM:  MnemonicSTM,
-	TokenADD:  MnemonicADD,
-	TokenSUB:  MnemonicSUB,
-	TokenAND:  MnemonicAND,
-	TokenORR:  MnemonicORR,
-	TokenBX:   MnemonicBX,
-	TokenB:    MnemonicB,
-	TokenBL:   MnemonicBL,
+	TokenMOVW:   MnemonicMOVW,
+	TokenMOVT:   MnemonicMOVT,
+	TokenLDR:    MnemonicLDR,
+	TokenSTR:    MnemonicSTR,
+	TokenLDM:    MnemonicLDM,
+	TokenSTM:    MnemonicSTM,
+	TokenADD:    MnemonicADD,
+	TokenSUB:    MnemonicSUB,
+	TokenAND:    MnemonicAND,
+	TokenORR:    MnemonicORR,
+	TokenBX:     MnemonicBX,
+	TokenBLX:    MnemonicBLX,
+	TokenB:      MnemonicB,
+	TokenBL:     MnemonicBL,
+	TokenSVC:    MnemonicSVC,
+	TokenSWI:    MnemonicSVC,
+	TokenMRS:    MnemonicMRS,
+	TokenMSR:    MnemonicMSR,
+	TokenCPS:    MnemonicCPS,
+	TokenSETEND: MnemonicSETEND,
+	TokenMCR:    MnemonicMCR,
+	TokenMRC:    MnemonicMRC,
+	TokenMCRR:   MnemonicMCRR,
+	TokenMRRC:   MnemonicMRRC,
+	TokenDMB:    MnemonicDMB,
+	TokenDSB:    MnemonicDSB,
+	TokenISB:    MnemonicISB,
+	TokenCLREX:  MnemonicCLREX,
+	TokenNOP:    MnemonicNOP,
+	TokenYIELD:  MnemonicYIELD,
+	TokenWFE:    MnemonicWFE,
+	TokenWFI:    MnemonicWFI,
+	TokenSEV:    MnemonicSEV,
+	TokenLDREX:  MnemonicLDREX,
+	TokenSTREX:  MnemonicSTREX,
+	TokenIT:     MnemonicIT,
+	TokenCLZ:    MnemonicCLZ,
+	TokenREV:    MnemonicREV,
+	TokenREV16:  MnemonicREV16,
+	TokenUXTB:   MnemonicUXTB,
+	TokenUXTH:   MnemonicUXTH,
+	TokenSXTB:   MnemonicSXTB,
+	TokenSXTH:   MnemonicSXTH,
+	TokenUBFX:   MnemonicUBFX,
+	TokenSBFX:   MnemonicSBFX,
+	TokenBFI:    MnemonicBFI,
+	TokenSDIV:   MnemonicSDIV,
+	TokenUDIV:   MnemonicUDIV,
+	TokenVMOV:   MnemonicVMOV,
+	TokenVLDR:   MnemonicVLDR,
+	TokenVSTR:   MnemonicVSTR,
+	TokenVADD:   MnemonicVADD,
+	TokenVSUB:   MnemonicVSUB,
+	TokenVMUL:   MnemonicVMUL,
+	TokenVDIV:   MnemonicVDIV,
+	TokenVCVT:   MnemonicVCVT,
+	TokenVMRS:   MnemonicVMRS,
+	TokenVMSR:   MnemonicVMSR,
+	TokenVLD1:   MnemonicVLD1,
+	TokenVST1:   MnemonicVST1,
+}
+
+var MnemonicToLiteral = map[MnemonicType]string{
+	MnemonicMOVW:   "MOVW",
+	MnemonicMOVT:   "MOVT",
+	MnemonicLDR:    "LDR",
+	MnemonicSTR:    "STR",
+	MnemonicLDM:    "LDM",
+	MnemonicSTM:    "STM",
+	MnemonicADD:    "ADD",
+	MnemonicSUB:    "SUB",
+	MnemonicAND:    "AND",
+	MnemonicORR:    "ORR",
+	MnemonicBX:     "BX",
+	MnemonicBLX:    "BLX",
+	MnemonicB:      "B",
+	MnemonicBL:     "BL",
+	MnemonicSVC:    "SVC",
+	MnemonicMRS:    "MRS",
+	MnemonicMSR:    "MSR",
+	MnemonicCPS:    "CPS",
+	MnemonicSETEND: "SETEND",
+	MnemonicMCR:    "MCR",
+	MnemonicMRC:    "MRC",
+	MnemonicMCRR:   "MCRR",
+	MnemonicMRRC:   "MRRC",
+	MnemonicDMB:    "DMB",
+	MnemonicDSB:    "DSB",
+	MnemonicISB:    "ISB",
+	MnemonicCLREX:  "CLREX",
+	MnemonicNOP:    "NOP",
+	MnemonicYIELD:  "YIELD",
+	MnemonicWFE:    "WFE",
+	MnemonicWFI:    "WFI",
+	MnemonicSEV:    "SEV",
+	MnemonicLDREX:  "LDREX",
+	MnemonicSTREX:  "STREX",
+	MnemonicIT:     "IT",
+	MnemonicCLZ:    "CLZ",
+	MnemonicREV:    "REV",
+	MnemonicREV16:  "REV16",
+	MnemonicUXTB:   "UXTB",
+	MnemonicUXTH:   "UXTH",
+	MnemonicSXTB:   "SXTB",
+	MnemonicSXTH:   "SXTH",
+	MnemonicUBFX:   "UBFX",
+	MnemonicSBFX:   "SBFX",
+	MnemonicBFI:    "BFI",
+	MnemonicSDIV:   "SDIV",
+	MnemonicUDIV:   "UDIV",
+	MnemonicVMOV:   "VMOV",
+	MnemonicVLDR:   "VLDR",
+	MnemonicVSTR:   "VSTR",
+	MnemonicVADD:   "VADD",
+	MnemonicVSUB:   "VSUB",
+	MnemonicVMUL:   "VMUL",
+	MnemonicVDIV:   "VDIV",
+	MnemonicVCVT:   "VCVT",
+	MnemonicVMRS:   "VMRS",
+	MnemonicVMSR:   "VMSR",
+	MnemonicVLD1:   "VLD1",
+	MnemonicVST1:   "VST1",
 }
 
 var LiteralToCondition = map[string]ConditionType{
 	"eq": ConditionEQ,
+	"ne": ConditionNE,
+	"cs": ConditionCS,
+	"hs": ConditionCS, // Unsigned higher or same, same as carry set
+	"cc": ConditionCC,
+	"lo": ConditionCC, // Unsigned lower, same as carry clear
+	"mi": ConditionMI,
 	"pl": ConditionPL,
-	"al": ConditionAL,
+	"vs": ConditionVS,
+	"vc": ConditionVC,
+	"hi": ConditionHI,
+	"ls": ConditionLS,
+	"ge": ConditionGE,
+	"lt": ConditionLT,
 	"gt": ConditionGT,
-	// Only supporting a few conditions for now
+	"le": ConditionLE,
+	"al": ConditionAL,
+}
+
+// ConditionToLiteral is the canonical suffix of every condition
+var ConditionToLiteral = map[ConditionType]string{
+	ConditionEQ: "eq",
+	ConditionNE: "ne",
+	ConditionCS: "cs",
+	ConditionCC: "cc",
+	ConditionMI: "mi",
+	ConditionPL: "pl",
+	ConditionVS: "vs",
+	ConditionVC: "vc",
+	ConditionHI: "hi",
+	ConditionLS: "ls",
+	ConditionGE: "ge",
+	ConditionLT: "lt",
+	ConditionGT: "gt",
+	ConditionLE: "le",
+	ConditionAL: "al",
 }
 
 var ConditionToBits = map[ConditionType]uint32{
 	ConditionEQ: 0b0000,
-	ConditionAL: 0b1110,
+	ConditionNE: 0b0001,
+	ConditionCS: 0b0010,
+	ConditionCC: 0b0011,
+	ConditionMI: 0b0100,
 	ConditionPL: 0b0101,
+	ConditionVS: 0b0110,
+	ConditionVC: 0b0111,
+	ConditionHI: 0b1000,
+	ConditionLS: 0b1001,
+	ConditionGE: 0b1010,
+	ConditionLT: 0b1011,
 	ConditionGT: 0b1100,
-	// "eq": 0b0000,
-	// "ne": 0b0001,
-	// "cs": 0b0010,
-	// "cc": 0b0011,
-	// "mi": 0b0100,
-	// "pl": 0b0101,
-	// "vs": 0b0110,
-	// "vc": 0b0111,
-	// "hi": 0b1000,
-	// "ls": 0b1001,
-	// "ge": 0b1010,
-	// "lt": 0b1011,
-	// "gt": 0b1100,
-	// "le": 0b1101,
-	// "al": 0b1110,
+	ConditionLE: 0b1101,
+	ConditionAL: 0b1110,
 }
 
 var MnemonicToBits = map[MnemonicType]uint32{
-	MnemonicMOVW: 0b0011_0000,
-	MnemonicMOVT: 0b0011_0100,
-	MnemonicLDR:  0b1,
-	MnemonicSTR:  0b0,
-	MnemonicLDM:  0b1,
-	MnemonicSTM:  0b0,
-	MnemonicADD:  0b0100,
-	MnemonicSUB:  0b0010,
-	MnemonicAND:  0b0000,
-	MnemonicORR:  0b1100,
-	MnemonicBX:   0b0001_0010_1111_1111_1111_0001,
-	MnemonicB:    0b101,
-	MnemonicBL:   0b101,
+	MnemonicMOVW:   0b0011_0000,
+	MnemonicMOVT:   0b0011_0100,
+	MnemonicLDR:    0b1,
+	MnemonicSTR:    0b0,
+	MnemonicLDM:    0b1,
+	MnemonicSTM:    0b0,
+	MnemonicADD:    0b0100,
+	MnemonicSUB:    0b0010,
+	MnemonicAND:    0b0000,
+	MnemonicORR:    0b1100,
+	MnemonicBX:     0b0001_0010_1111_1111_1111_0001,
+	MnemonicBLX:    0b0001_0010_1111_1111_1111_0011, // BLX Rm, BLX label is a B with the condition bits set
+	MnemonicB:      0b101,
+	MnemonicBL:     0b101,
+	MnemonicSVC:    0b1111,
+	MnemonicMRS:    0x010F0000, // The whole word apart from the condition, R bit and Rd
+	MnemonicMSR:    0x0120F000, // Register form, the immediate form sets bit 25 too
+	MnemonicCPS:    0xF1000000,
+	MnemonicSETEND: 0xF1010000,
+	MnemonicMCR:    0x0E000010, // With the L bit (20) clear for a write to the coprocessor
+	MnemonicMRC:    0x0E100010,
+	MnemonicMCRR:   0x0C400000,
+	MnemonicMRRC:   0x0C500000,
+	MnemonicDMB:    0xF57FF050, // The option goes in bits 0-3
+	MnemonicDSB:    0xF57FF040,
+	MnemonicISB:    0xF57FF060,
+	MnemonicCLREX:  0xF57FF01F,
+	MnemonicNOP:    0x0320F000, // MSR with no fields, the hint in bits 0-7
+	MnemonicYIELD:  0x0320F001,
+	MnemonicWFE:    0x0320F002,
+	MnemonicWFI:    0x0320F003,
+	MnemonicSEV:    0x0320F004,
+	MnemonicLDREX:  0x01900F9F,
+	MnemonicSTREX:  0x01800F90,
+	MnemonicIT:     0xBF00,     // Thumb only, the first condition goes in bits 4-7 and the mask in bits 0-3
+	MnemonicCLZ:    0x016F0F10, // Rd in bits 12-15, Rm in bits 0-3
+	MnemonicREV:    0x06BF0F30,
+	MnemonicREV16:  0x06BF0FB0,
+	MnemonicUXTB:   0x06EF0070, // The rotation goes in bits 10-11
+	MnemonicUXTH:   0x06FF0070,
+	MnemonicSXTB:   0x06AF0070,
+	MnemonicSXTH:   0x06BF0070,
+	MnemonicUBFX:   0x07E00050, // Width - 1 in bits 16-20, lsb in bits 7-11
+	MnemonicSBFX:   0x07A00050,
+	MnemonicBFI:    0x07C00010, // msb in bits 16-20, lsb in bits 7-11
+	MnemonicSDIV:   0x0710F010, // Rd in bits 16-19, Rm in bits 8-11, Rn in bits 0-3
+	MnemonicUDIV:   0x0730F010,
+	MnemonicVMOV:   0x0EB00A40, // Between extension registers, the transfers to and from ARM registers are 0x0E000A10 and 0x0C400B10
+	MnemonicVLDR:   0x0D100A00, // U bit 23, the offset in words in bits 0-7
+	MnemonicVSTR:   0x0D000A00,
+	MnemonicVADD:   0x0E300A00, // Sd/Dd in bits 12-15 and 22, Sn/Dn in 16-19 and 7, Sm/Dm in 0-3 and 5, bit 8 set for F64
+	MnemonicVSUB:   0x0E300A40,
+	MnemonicVMUL:   0x0E200A00,
+	MnemonicVDIV:   0x0E800A00,
+	MnemonicVCVT:   0x0EB00A40, // The data types pick bits 16-19, 8 and 7
+	MnemonicVMRS:   0x0EF00A10, // The system register goes in bits 16-19
+	MnemonicVMSR:   0x0EE00A10,
+	MnemonicVLD1:   0xF4200000, // The number of registers picks the type in bits 8-11, the element size goes in bits 6-7
+	MnemonicVST1:   0xF4000000,
 }
 
 var MnemonicToCategory = map[MnemonicType]MnemonicCategory{
-	MnemonicMOVW: MnemonicCategoryMOV,
-	MnemonicMOVT: MnemonicCategoryMOV,
-	MnemonicLDR:  MnemonicCategoryLoadStore,
-	MnemonicSTR:  MnemonicCategoryLoadStore,
-	MnemonicLDM:  MnemonicCategoryLoadStoreMultiple,
-	MnemonicSTM:  MnemonicCategoryLoadStoreMultiple,
-	MnemonicADD:  MnemonicCategoryArithmetic,
-	MnemonicSUB:  MnemonicCategoryArithmetic,
-	MnemonicAND:  MnemonicCategoryArithmetic,
-	MnemonicORR:  MnemonicCategoryArithmetic,
-	MnemonicBX:   MnemonicCategoryBranchExchange,
-	MnemonicB:    MnemonicCategoryBranch,
-	MnemonicBL:   MnemonicCategoryBranch,
+	MnemonicMOVW:   MnemonicCategoryMOV,
+	MnemonicMOVT:   MnemonicCategoryMOV,
+	MnemonicLDR:    MnemonicCategoryLoadStore,
+	MnemonicSTR:    MnemonicCategoryLoadStore,
+	MnemonicLDM:    MnemonicCategoryLoadStoreMultiple,
+	MnemonicSTM:    MnemonicCategoryLoadStoreMultiple,
+	MnemonicADD:    MnemonicCategoryArithmetic,
+	MnemonicSUB:    MnemonicCategoryArithmetic,
+	MnemonicAND:    MnemonicCategoryArithmetic,
+	MnemonicORR:    MnemonicCategoryArithmetic,
+	MnemonicBX:     MnemonicCategoryBranchExchange,
+	MnemonicBLX:    MnemonicCategoryBranchExchange,
+	MnemonicB:      MnemonicCategoryBranch,
+	MnemonicBL:     MnemonicCategoryBranch,
+	MnemonicSVC:    MnemonicCategorySupervisorCall,
+	MnemonicMRS:    MnemonicCategoryStatus,
+	MnemonicMSR:    MnemonicCategoryStatus,
+	MnemonicCPS:    MnemonicCategoryChangeState,
+	MnemonicSETEND: MnemonicCategoryChangeState,
+	MnemonicMCR:    MnemonicCategoryCoprocessor,
+	MnemonicMRC:    MnemonicCategoryCoprocessor,
+	MnemonicMCRR:   MnemonicCategoryCoprocessor,
+	MnemonicMRRC:   MnemonicCategoryCoprocessor,
+	MnemonicDMB:    MnemonicCategoryBarrier,
+	MnemonicDSB:    MnemonicCategoryBarrier,
+	MnemonicISB:    MnemonicCategoryBarrier,
+	MnemonicCLREX:  MnemonicCategoryBarrier,
+	MnemonicNOP:    MnemonicCategoryHint,
+	MnemonicYIELD:  MnemonicCategoryHint,
+	MnemonicWFE:    MnemonicCategoryHint,
+	MnemonicWFI:    MnemonicCategoryHint,
+	MnemonicSEV:    MnemonicCategoryHint,
+	MnemonicLDREX:  MnemonicCategoryExclusive,
+	MnemonicSTREX:  MnemonicCategoryExclusive,
+	MnemonicIT:     MnemonicCategoryIfThen,
+	MnemonicCLZ:    MnemonicCategoryMedia,
+	MnemonicREV:    MnemonicCategoryMedia,
+	MnemonicREV16:  MnemonicCategoryMedia,
+	MnemonicUXTB:   MnemonicCategoryMedia,
+	MnemonicUXTH:   MnemonicCategoryMedia,
+	MnemonicSXTB:   MnemonicCategoryMedia,
+	MnemonicSXTH:   MnemonicCategoryMedia,
+	MnemonicUBFX:   MnemonicCategoryMedia,
+	MnemonicSBFX:   MnemonicCategoryMedia,
+	MnemonicBFI:    MnemonicCategoryMedia,
+	MnemonicSDIV:   MnemonicCategoryMedia,
+	MnemonicUDIV:   MnemonicCategoryMedia,
+	MnemonicVMOV:   MnemonicCategoryFloat,
+	MnemonicVLDR:   MnemonicCategoryFloatMemory,
+	MnemonicVSTR:   MnemonicCategoryFloatMemory,
+	MnemonicVADD:   MnemonicCategoryFloat,
+	MnemonicVSUB:   MnemonicCategoryFloat,
+	MnemonicVMUL:   MnemonicCategoryFloat,
+	MnemonicVDIV:   MnemonicCategoryFloat,
+	MnemonicVCVT:   MnemonicCategoryFloat,
+	MnemonicVMRS:   MnemonicCategoryFloatSystem,
+	MnemonicVMSR:   MnemonicCategoryFloatSystem,
+	MnemonicVLD1:   MnemonicCategoryVector,
+	MnemonicVST1:   MnemonicCategoryVector,
 }
 
 var MnemonicTokenToCategory = map[TokenType]MnemonicCategory{
-	TokenMOVW: MnemonicCategoryMOV,
-	TokenMOVT: MnemonicCategoryMOV,
-	TokenLDR:  MnemonicCategoryLoadStore,
-	TokenSTR:  MnemonicCategoryLoadStore,
-	TokenLDM:  MnemonicCategoryLoadStoreMultiple,
-	TokenSTM:  MnemonicCategoryLoadStoreMultiple,
-	TokenADD:  MnemonicCategoryArithmetic,
-	TokenSUB:  MnemonicCategoryArithmetic,
-	TokenAND:  MnemonicCategoryArithmetic,
-	TokenORR:  MnemonicCategoryArithmetic,
-	TokenBX:   MnemonicCategoryBranchExchange,
-	TokenB:    MnemonicCategoryBranch,
-	TokenBL:   MnemonicCategoryBranch,
+	TokenMOVW:   MnemonicCategoryMOV,
+	TokenMOVT:   MnemonicCategoryMOV,
+	TokenLDR:    MnemonicCategoryLoadStore,
+	TokenSTR:    MnemonicCategoryLoadStore,
+	TokenLDM:    MnemonicCategoryLoadStoreMultiple,
+	TokenSTM:    MnemonicCategoryLoadStoreMultiple,
+	TokenADD:    MnemonicCategoryArithmetic,
+	TokenSUB:    MnemonicCategoryArithmetic,
+	TokenAND:    MnemonicCategoryArithmetic,
+	TokenORR:    MnemonicCategoryArithmetic,
+	TokenBX:     MnemonicCategoryBranchExchange,
+	TokenBLX:    MnemonicCategoryBranchExchange,
+	TokenB:      MnemonicCategoryBranch,
+	TokenBL:     MnemonicCategoryBranch,
+	TokenSVC:    MnemonicCategorySupervisorCall,
+	TokenSWI:    MnemonicCategorySupervisorCall,
+	TokenMRS:    MnemonicCategoryStatus,
+	TokenMSR:    MnemonicCategoryStatus,
+	TokenCPS:    MnemonicCategoryChangeState,
+	TokenSETEND: MnemonicCategoryChangeState,
+	TokenMCR:    MnemonicCategoryCoprocessor,
+	TokenMRC:    MnemonicCategoryCoprocessor,
+	TokenMCRR:   MnemonicCategoryCoprocessor,
+	TokenMRRC:   MnemonicCategoryCoprocessor,
+	TokenDMB:    MnemonicCategoryBarrier,
+	TokenDSB:    MnemonicCategoryBarrier,
+	TokenISB:    MnemonicCategoryBarrier,
+	TokenCLREX:  MnemonicCategoryBarrier,
+	TokenNOP:    MnemonicCategoryHint,
+	TokenYIELD:  MnemonicCategoryHint,
+	TokenWFE:    MnemonicCategoryHint,
+	TokenWFI:    MnemonicCategoryHint,
+	TokenSEV:    MnemonicCategoryHint,
+	TokenLDREX:  MnemonicCategoryExclusive,
+	TokenSTREX:  MnemonicCategoryExclusive,
+	TokenIT:     MnemonicCategoryIfThen,
+	TokenCLZ:    MnemonicCategoryMedia,
+	TokenREV:    MnemonicCategoryMedia,
+	TokenREV16:  MnemonicCategoryMedia,
+	TokenUXTB:   MnemonicCategoryMedia,
+	TokenUXTH:   MnemonicCategoryMedia,
+	TokenSXTB:   MnemonicCategoryMedia,
+	TokenSXTH:   MnemonicCategoryMedia,
+	TokenUBFX:   MnemonicCategoryMedia,
+	TokenSBFX:   MnemonicCategoryMedia,
+	TokenBFI:    MnemonicCategoryMedia,
+	TokenSDIV:   MnemonicCategoryMedia,
+	TokenUDIV:   MnemonicCategoryMedia,
+	TokenVMOV:   MnemonicCategoryFloat,
+	TokenVLDR:   MnemonicCategoryFloatMemory,
+	TokenVSTR:   MnemonicCategoryFloatMemory,
+	TokenVADD:   MnemonicCategoryFloat,
+	TokenVSUB:   MnemonicCategoryFloat,
+	TokenVMUL:   MnemonicCategoryFloat,
+	TokenVDIV:   MnemonicCategoryFloat,
+	TokenVCVT:   MnemonicCategoryFloat,
+	TokenVMRS:   MnemonicCategoryFloatSystem,
+	TokenVMSR:   MnemonicCategoryFloatSystem,
+	TokenVLD1:   MnemonicCategoryVector,
+	TokenVST1:   MnemonicCategoryVector,
+	TokenMOV32:  MnemonicCategoryPseudo,
 }
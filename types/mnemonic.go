@@ -14,8 +14,54 @@ const (
 	MnemonicAND
 	MnemonicORR
 	MnemonicBX
+	MnemonicBLX
 	MnemonicB
 	MnemonicBL
+	MnemonicSVC
+	MnemonicMRS
+	MnemonicMSR
+	MnemonicCPS
+	MnemonicSETEND
+	MnemonicMCR
+	MnemonicMRC
+	MnemonicMCRR
+	MnemonicMRRC
+	MnemonicDMB
+	MnemonicDSB
+	MnemonicISB
+	MnemonicCLREX
+	MnemonicNOP
+	MnemonicYIELD
+	MnemonicWFE
+	MnemonicWFI
+	MnemonicSEV
+	MnemonicLDREX
+	MnemonicSTREX
+	MnemonicIT
+	MnemonicCLZ
+	MnemonicREV
+	MnemonicREV16
+	MnemonicUXTB
+	MnemonicUXTH
+	MnemonicSXTB
+	MnemonicSXTH
+	MnemonicUBFX
+	MnemonicSBFX
+	MnemonicBFI
+	MnemonicSDIV
+	MnemonicUDIV
+	MnemonicVMOV
+	MnemonicVLDR
+	MnemonicVSTR
+	MnemonicVADD
+	MnemonicVSUB
+	MnemonicVMUL
+	MnemonicVDIV
+	MnemonicVCVT
+	MnemonicVMRS
+	MnemonicVMSR
+	MnemonicVLD1
+	MnemonicVST1
 )
 
 type MnemonicCategory uint32
@@ -27,4 +73,18 @@ const (
 	MnemonicCategoryArithmetic
 	MnemonicCategoryBranch
 	MnemonicCategoryBranchExchange
+	MnemonicCategorySupervisorCall
+	MnemonicCategoryStatus      // Moves to and from the CPSR and SPSR
+	MnemonicCategoryChangeState // Unconditional changes to the CPSR
+	MnemonicCategoryCoprocessor // Register transfers to and from a coprocessor
+	MnemonicCategoryBarrier     // Unconditional memory barriers and CLREX
+	MnemonicCategoryHint        // NOP and the instructions that wait for or signal other cores
+	MnemonicCategoryExclusive   // LDREX and STREX
+	MnemonicCategoryIfThen      // IT, which makes the Thumb instructions after it conditional
+	MnemonicCategoryMedia       // CLZ, byte reversal, extension, bit fields and division, all on registers
+	MnemonicCategoryFloat       // VFP arithmetic, conversions and moves, and NEON integer addition and subtraction
+	MnemonicCategoryFloatMemory // VLDR and VSTR
+	MnemonicCategoryFloatSystem // VMRS and VMSR, moves to and from the VFP system registers
+	MnemonicCategoryVector      // VLD1 and VST1, NEON loads and stores of whole registers
+	MnemonicCategoryPseudo      // Expands to one or more real instructions
 )
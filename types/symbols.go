@@ -0,0 +1,165 @@
+package types
+
+import "fmt"
+
+// Section is a named, contiguous run of parsed instructions. Start and End index into the
+// instruction slice returned by the parser (End is exclusive).
+type Section struct {
+	Name   string
+	Start  uint32
+	End    uint32
+	Offset uint32 // Byte offset of the first instruction in the label map's address space, always word aligned
+}
+
+// Range is a run of bytes from Start up to, but not including, End.
+type Range struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+type SymbolBinding uint32
+
+const (
+	BindingLocal  SymbolBinding = iota // Only visible inside its own file
+	BindingGlobal                      // Set by .global, visible to other files at link time
+	BindingWeak                        // Set by .weak, global but a non weak definition elsewhere wins
+	BindingExtern                      // Set by .extern, defined in another file
+)
+
+var BindingToLiteral = map[SymbolBinding]string{
+	BindingLocal:  "local",
+	BindingGlobal: "global",
+	BindingWeak:   "weak",
+	BindingExtern: "extern",
+}
+
+type SymbolType uint32
+
+const (
+	SymbolNoType SymbolType = iota
+	SymbolFunc              // Set by .type name, %function
+	SymbolObject            // Set by .type name, %object
+)
+
+var SymbolTypeToLiteral = map[SymbolType]string{
+	SymbolNoType: "notype",
+	SymbolFunc:   "func",
+	SymbolObject: "object",
+}
+
+// Symbol is a label defined in, or a name declared by, a single source file.
+type Symbol struct {
+	Name    string
+	Section string // Section the label was defined in, empty for undefined symbols
+	Index   uint32 // Instruction number the label points at
+	Offset  uint32 // Byte offset of the label within its section
+	Defined bool   // False for names that are only declared with .extern or .weak
+	Binding SymbolBinding
+	Type    SymbolType
+	Size    uint32 // Size in bytes, set by .size
+	Thumb   bool   // Set by .thumb_func, or .type %function in Thumb code, the label is the entry point of Thumb code
+	Token   Token  // Label or directive token, used for diagnostics
+}
+
+// IsGlobal checks if the symbol can be referenced from or resolved by other files.
+func (s Symbol) IsGlobal() bool {
+	return s.Binding == BindingGlobal || s.Binding == BindingWeak
+}
+
+type RelocationType uint32
+
+const (
+	RelocationBranch      RelocationType = iota // 24 bit word offset relative to PC+8 (B/BL/BLX), BL becomes BLX for Thumb targets
+	RelocationAbs32                             // Absolute 32 bit address (.word)
+	RelocationMOVW                              // Bottom 16 bits of the address (MOVW)
+	RelocationMOVT                              // Top 16 bits of the address (MOVT)
+	RelocationThumbBranch                       // Thumb B.W, B<c>.W, BL or BLX, BL and BLX switch to match the target
+	RelocationThumbMOVW                         // Bottom 16 bits of the address, in a Thumb MOVW
+	RelocationThumbMOVT                         // Top 16 bits of the address, in a Thumb MOVT
+)
+
+var RelocationToLiteral = map[RelocationType]string{
+	RelocationBranch: "branch",
+	RelocationAbs32:  "abs32",
+	RelocationMOVW:   "movw",
+	RelocationMOVT:   "movt",
+
+	RelocationThumbBranch: "thumb-branch",
+	RelocationThumbMOVW:   "thumb-movw",
+	RelocationThumbMOVT:   "thumb-movt",
+}
+
+// Relocatable is implemented by instructions whose encoding depends on the address of a symbol. The returned token
+// is the symbol operand, its literal is empty when the instruction doesn't reference a symbol.
+type Relocatable interface {
+	Relocation() (symbol Token, relocationType RelocationType)
+}
+
+func (r RelocationType) String() string {
+	if literal, ok := RelocationToLiteral[r]; ok {
+		return literal
+	}
+	return fmt.Sprintf("RelocationType(%d)", uint32(r))
+}
+
+func (r RelocationType) MarshalText() ([]byte, error) {
+	literal, ok := RelocationToLiteral[r]
+	if !ok {
+		return nil, fmt.Errorf("unknown relocation type %d", uint32(r))
+	}
+	return []byte(literal), nil
+}
+
+func (r *RelocationType) UnmarshalText(text []byte) error {
+	for relocationType, literal := range RelocationToLiteral {
+		if literal == string(text) {
+			*r = relocationType
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown relocation type %q", string(text))
+}
+
+func (b SymbolBinding) String() string {
+	return BindingToLiteral[b]
+}
+
+func (b SymbolBinding) MarshalText() ([]byte, error) {
+	literal, ok := BindingToLiteral[b]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol binding %d", uint32(b))
+	}
+	return []byte(literal), nil
+}
+
+func (b *SymbolBinding) UnmarshalText(text []byte) error {
+	for binding, literal := range BindingToLiteral {
+		if literal == string(text) {
+			*b = binding
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown symbol binding %q", string(text))
+}
+
+func (t SymbolType) String() string {
+	return SymbolTypeToLiteral[t]
+}
+
+func (t SymbolType) MarshalText() ([]byte, error) {
+	literal, ok := SymbolTypeToLiteral[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol type %d", uint32(t))
+	}
+	return []byte(literal), nil
+}
+
+func (t *SymbolType) UnmarshalText(text []byte) error {
+	for symbolType, literal := range SymbolTypeToLiteral {
+		if literal == string(text) {
+			*t = symbolType
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown symbol type %q", string(text))
+}
@@ -16,15 +16,21 @@ const (
 	TokenComma
 	TokenLBracket
 	TokenRBracket
-	TokenBang // For write back bit on registers (specifically for LDM/STM)
+	TokenBang  // For write back bit on registers (specifically for LDM/STM)
+	TokenCaret // For the S bit of LDM/STM, user bank transfers and exception returns
 	TokenLBrace
 	TokenRBrace
 	TokenDash
 
 	TokenIdentifier
 	TokenLabel
+	TokenDirective  // Assembler directive, literal includes the leading '.'
+	TokenRelocation // Relocation specifier such as :lower16: or :upper16:, literal excludes the colons
+	TokenQualifier  // .N or .W right after a mnemonic, picking a 16 or 32 bit Thumb encoding
+	TokenDataType   // Data type right after a VFP or NEON mnemonic, such as .F32, .I32 or .32
 
 	TokenRegister
+	TokenFPRegister // S0-S31, D0-D31 and Q0-Q15, the floating-point and NEON registers
 	TokenImmediate
 
 	TokenMOVW
@@ -38,8 +44,56 @@ const (
 	TokenAND
 	TokenORR
 	TokenBX
+	TokenBLX
 	TokenB
 	TokenBL
+	TokenSVC
+	TokenSWI // Old name for SVC
+	TokenMRS
+	TokenMSR
+	TokenCPS // CPS, CPSIE and CPSID
+	TokenSETEND
+	TokenMCR
+	TokenMRC
+	TokenMCRR
+	TokenMRRC
+	TokenDMB
+	TokenDSB
+	TokenISB
+	TokenCLREX
+	TokenNOP
+	TokenYIELD
+	TokenWFE
+	TokenWFI
+	TokenSEV
+	TokenLDREX
+	TokenSTREX
+	TokenIT // IT and its T and E forms, up to ITTTT
+	TokenCLZ
+	TokenREV
+	TokenREV16
+	TokenUXTB
+	TokenUXTH
+	TokenSXTB
+	TokenSXTH
+	TokenUBFX
+	TokenSBFX
+	TokenBFI
+	TokenSDIV
+	TokenUDIV
+	TokenVMOV
+	TokenVLDR
+	TokenVSTR
+	TokenVADD
+	TokenVSUB
+	TokenVMUL
+	TokenVDIV
+	TokenVCVT
+	TokenVMRS
+	TokenVMSR
+	TokenVLD1
+	TokenVST1
+	TokenMOV32 // Pseudo-instruction, expands to MOVW and MOVT
 
 	TokenS
 
@@ -54,9 +108,19 @@ var TokenToLiteral = map[TokenType]string{
 	TokenComma:      "COMMA",
 	TokenLBracket:   "LBRACKET",
 	TokenRBracket:   "RBRACKET",
+	TokenBang:       "BANG",
+	TokenCaret:      "CARET",
+	TokenLBrace:     "LBRACE",
+	TokenRBrace:     "RBRACE",
+	TokenDash:       "DASH",
 	TokenIdentifier: "IDENTIFIER",
 	TokenLabel:      "LABEL",
+	TokenDirective:  "DIRECTIVE",
+	TokenRelocation: "RELOCATION",
+	TokenQualifier:  "QUALIFIER",
+	TokenDataType:   "DATATYPE",
 	TokenRegister:   "REGISTER",
+	TokenFPRegister: "FP_REGISTER",
 	TokenImmediate:  "IMMEDIATE",
 	TokenMOVW:       "MOVW",
 	TokenMOVT:       "MOVT",
@@ -69,6 +133,54 @@ var TokenToLiteral = map[TokenType]string{
 	TokenAND:        "AND",
 	TokenORR:        "ORR",
 	TokenBX:         "BX",
+	TokenBLX:        "BLX",
 	TokenB:          "B",
 	TokenBL:         "BL",
+	TokenSVC:        "SVC",
+	TokenSWI:        "SWI",
+	TokenMRS:        "MRS",
+	TokenMSR:        "MSR",
+	TokenCPS:        "CPS",
+	TokenSETEND:     "SETEND",
+	TokenMCR:        "MCR",
+	TokenMRC:        "MRC",
+	TokenMCRR:       "MCRR",
+	TokenMRRC:       "MRRC",
+	TokenDMB:        "DMB",
+	TokenDSB:        "DSB",
+	TokenISB:        "ISB",
+	TokenCLREX:      "CLREX",
+	TokenNOP:        "NOP",
+	TokenYIELD:      "YIELD",
+	TokenWFE:        "WFE",
+	TokenWFI:        "WFI",
+	TokenSEV:        "SEV",
+	TokenLDREX:      "LDREX",
+	TokenSTREX:      "STREX",
+	TokenIT:         "IT",
+	TokenCLZ:        "CLZ",
+	TokenREV:        "REV",
+	TokenREV16:      "REV16",
+	TokenUXTB:       "UXTB",
+	TokenUXTH:       "UXTH",
+	TokenSXTB:       "SXTB",
+	TokenSXTH:       "SXTH",
+	TokenUBFX:       "UBFX",
+	TokenSBFX:       "SBFX",
+	TokenBFI:        "BFI",
+	TokenSDIV:       "SDIV",
+	TokenUDIV:       "UDIV",
+	TokenVMOV:       "VMOV",
+	TokenVLDR:       "VLDR",
+	TokenVSTR:       "VSTR",
+	TokenVADD:       "VADD",
+	TokenVSUB:       "VSUB",
+	TokenVMUL:       "VMUL",
+	TokenVDIV:       "VDIV",
+	TokenVCVT:       "VCVT",
+	TokenVMRS:       "VMRS",
+	TokenVMSR:       "VMSR",
+	TokenVLD1:       "VLD1",
+	TokenVST1:       "VST1",
+	TokenMOV32:      "MOV32",
 }
@@ -22,6 +22,9 @@ func IsImmediate(lit string) bool {
 		lit = lit[2:] // trim 0x
 		validChars = unicode.Hex_Digit
 	}
+	if lit == "" {
+		return false
+	}
 
 	for _, c := range lit {
 		if !unicode.Is(validChars, c) {
@@ -50,6 +53,31 @@ func IsRegister(lit string) bool {
 	return true
 }
 
+// IsFPRegister checks if a string names a floating-point or NEON register: S0-S31, D0-D31 or Q0-Q15.
+func IsFPRegister(lit string) bool {
+	if len(lit) < 2 || len(lit) > 3 || strings.IndexByte("sdqSDQ", lit[0]) < 0 {
+		return false
+	}
+	register, err := strconv.ParseUint(lit[1:], 10, 32)
+	if err != nil || len(lit) == 3 && lit[1] == '0' {
+		return false
+	}
+	limit := uint64(31)
+	if lit[0] == 'q' || lit[0] == 'Q' {
+		limit = 15
+	}
+	return register <= limit
+}
+
+// IsDataType checks if a string, without its leading '.', is a data type suffix like F32, I8 or 32.
+func IsDataType(lit string) bool {
+	lit = strings.ToUpper(lit)
+	if lit != "" && strings.IndexByte("FSUI", lit[0]) >= 0 {
+		lit = lit[1:]
+	}
+	return lit == "8" || lit == "16" || lit == "32" || lit == "64"
+}
+
 func NormalizeRegister(lit string) string {
 	if strings.ToLower(lit) == "sp" {
 		return "r13"
@@ -72,7 +100,9 @@ func IsOperation(lit string) bool {
 }
 
 /*
-GetMnemonicTokenType returns the TokenType for an operation literal. Matches the longest mnemonic first.
+GetMnemonicTokenType returns the TokenType for an operation literal. Matches the longest mnemonic first, but prefers
+a mnemonic followed by exactly a condition code (so "bls" is B with LS rather than BL with S). Anything left after the
+mnemonic has to be made of known suffixes, so identifiers like "blink" aren't mistaken for operations.
 */
 func GetMnemonicTokenType(lit string) types.TokenType {
 	if len(lit) == 0 {
@@ -81,9 +111,18 @@ func GetMnemonicTokenType(lit string) types.TokenType {
 
 	lit = strings.ToLower(lit)
 
-	// Iterate backwards through the literal to find longest operation
+	// Iterate backwards through the literal to find a mnemonic followed by nothing but a condition
 	for i := len(lit); i > 0; i-- {
 		if tokenType, ok := types.LiteralToMnemonicToken[lit[0:i]]; ok {
+			if _, isCondition := types.LiteralToCondition[lit[i:]]; isCondition || i == len(lit) {
+				return tokenType
+			}
+		}
+	}
+
+	// Iterate backwards through the literal to find longest operation with valid suffixes
+	for i := len(lit); i > 0; i-- {
+		if tokenType, ok := types.LiteralToMnemonicToken[lit[0:i]]; ok && isSuffixChain(lit[i:]) {
 			return tokenType
 		}
 	}
@@ -91,3 +130,48 @@ func GetMnemonicTokenType(lit string) types.TokenType {
 	// We didn't find an operation
 	return types.TokenError
 }
+
+// mnemonicSuffixes are the non-condition suffixes that can follow a mnemonic. The parser decides which are valid
+// for a given instruction.
+var mnemonicSuffixes = []string{"s", "b", "ea", "ia", "ib", "da", "db", "fd", "fa", "ed"}
+
+// isSuffixChain checks if a string is made up entirely of condition codes and mnemonic suffixes.
+func isSuffixChain(lit string) bool {
+	if lit == "" {
+		return true
+	}
+	for i := 1; i <= len(lit); i++ {
+		if !isSuffix(lit[:i]) {
+			continue
+		}
+		if isSuffixChain(lit[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSuffix(lit string) bool {
+	if _, ok := types.LiteralToCondition[lit]; ok {
+		return true
+	}
+	for _, suffix := range mnemonicSuffixes {
+		if lit == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIdentifier checks if a string can be used as a label or symbol name. Identifiers can't start with a digit.
+func IsIdentifier(lit string) bool {
+	if lit == "" || unicode.IsDigit(rune(lit[0])) {
+		return false
+	}
+	for i := 0; i < len(lit); i++ {
+		if !IsLiteralChar(lit[i]) {
+			return false
+		}
+	}
+	return true
+}
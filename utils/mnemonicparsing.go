@@ -23,10 +23,22 @@ func ParseRegister(registerLiteral string) (uint32, error) {
 	return uint32(reg), nil
 }
 
+// ParseFPRegister parses S0-S31, D0-D31 or Q0-Q15, returning the upper case letter and the number.
+func ParseFPRegister(registerLiteral string) (byte, uint32, error) {
+	if !IsFPRegister(registerLiteral) {
+		return 0, 0, fmt.Errorf("invalid floating-point register: %s", registerLiteral)
+	}
+	reg, _ := strconv.ParseUint(registerLiteral[1:], 10, 32)
+	return registerLiteral[0] &^ 0x20, uint32(reg), nil
+}
+
 func ParseImmediate(immediateLiteral string) (uint32, error) {
+	if immediateLiteral == "" {
+		return 0, fmt.Errorf("missing immediate value")
+	}
 	value, err := strconv.ParseUint(immediateLiteral, 0, 32)
 	if err != nil {
-		return 0, fmt.Errorf("invalid immediate value: %s", immediateLiteral[1:])
+		return 0, fmt.Errorf("invalid immediate value: %s", immediateLiteral)
 	}
 	return uint32(value), nil
 }
@@ -114,7 +126,8 @@ func ParseBranchSuffixes(mnemonicLiteral string) (types.ConditionType, uint32, e
 	mnemonicLiteral = strings.ToLower(mnemonicLiteral[1:]) // Remove the B
 
 	var lBit uint32
-	if len(mnemonicLiteral) > 0 {
+	if _, isCondition := types.LiteralToCondition[mnemonicLiteral]; !isCondition && len(mnemonicLiteral) > 0 {
+		// BLT, BLE, BLS and BLO are B with a condition, not BL
 		if mnemonicLiteral[0] == 'l' {
 			lBit = 1
 			mnemonicLiteral = mnemonicLiteral[1:]
@@ -135,10 +148,15 @@ func ParseBranchSuffixes(mnemonicLiteral string) (types.ConditionType, uint32, e
 }
 
 func ParseBranchExchangeSuffixes(mnemonicLiteral string) (types.ConditionType, error) {
-	if len(mnemonicLiteral) < 2 || len(mnemonicLiteral) > 4 {
+	if len(mnemonicLiteral) < 2 || len(mnemonicLiteral) > 5 {
 		return types.ConditionAL, fmt.Errorf("invalid branch exchange mnemonic length: %s", mnemonicLiteral)
 	}
-	mnemonicLiteral = strings.ToLower(mnemonicLiteral[2:]) // Remove BX
+	mnemonicLiteral = strings.ToLower(mnemonicLiteral)
+	if strings.HasPrefix(mnemonicLiteral, "blx") {
+		mnemonicLiteral = mnemonicLiteral[3:] // Remove BLX
+	} else {
+		mnemonicLiteral = mnemonicLiteral[2:] // Remove BX
+	}
 
 	if len(mnemonicLiteral) == 0 {
 		return types.ConditionAL, nil // No condition specified, default to AL
@@ -153,6 +171,179 @@ func ParseBranchExchangeSuffixes(mnemonicLiteral string) (types.ConditionType, e
 	return condition, nil
 }
 
+// ParseSupervisorCallSuffixes returns the condition of an SVC or SWI.
+func ParseSupervisorCallSuffixes(mnemonicLiteral string) (types.ConditionType, error) {
+	if len(mnemonicLiteral) < 3 || len(mnemonicLiteral) > 5 {
+		return types.ConditionAL, fmt.Errorf("invalid supervisor call mnemonic length: %s", mnemonicLiteral)
+	}
+	mnemonicLiteral = strings.ToLower(mnemonicLiteral[3:]) // Remove SVC/SWI
+
+	if len(mnemonicLiteral) == 0 {
+		return types.ConditionAL, nil // No condition specified, default to AL
+	}
+
+	condition, ok := types.LiteralToCondition[mnemonicLiteral]
+	if !ok {
+		return types.ConditionAL, fmt.Errorf("invalid supervisor call condition: %s", mnemonicLiteral)
+	}
+
+	return condition, nil
+}
+
+// ParseStatusSuffixes returns the condition of an MRS or MSR.
+func ParseStatusSuffixes(mnemonicLiteral string) (types.ConditionType, error) {
+	if len(mnemonicLiteral) < 3 || len(mnemonicLiteral) > 5 {
+		return types.ConditionAL, fmt.Errorf("invalid status register mnemonic length: %s", mnemonicLiteral)
+	}
+	mnemonicLiteral = strings.ToLower(mnemonicLiteral[3:]) // Remove MRS/MSR
+
+	if len(mnemonicLiteral) == 0 {
+		return types.ConditionAL, nil // No condition specified, default to AL
+	}
+
+	condition, ok := types.LiteralToCondition[mnemonicLiteral]
+	if !ok {
+		return types.ConditionAL, fmt.Errorf("invalid status register condition: %s", mnemonicLiteral)
+	}
+
+	return condition, nil
+}
+
+// ParseCoprocessorSuffixes returns the condition of an MCR, MRC, MCRR or MRRC.
+func ParseCoprocessorSuffixes(mnemonicLiteral string) (types.ConditionType, error) {
+	if len(mnemonicLiteral) < 3 || len(mnemonicLiteral) > 6 {
+		return types.ConditionAL, fmt.Errorf("invalid coprocessor mnemonic length: %s", mnemonicLiteral)
+	}
+	mnemonicLiteral = strings.ToLower(mnemonicLiteral)
+	if strings.HasPrefix(mnemonicLiteral, "mcrr") || strings.HasPrefix(mnemonicLiteral, "mrrc") {
+		mnemonicLiteral = mnemonicLiteral[4:] // Remove MCRR/MRRC
+	} else {
+		mnemonicLiteral = mnemonicLiteral[3:] // Remove MCR/MRC
+	}
+
+	if len(mnemonicLiteral) == 0 {
+		return types.ConditionAL, nil // No condition specified, default to AL
+	}
+
+	condition, ok := types.LiteralToCondition[mnemonicLiteral]
+	if !ok {
+		return types.ConditionAL, fmt.Errorf("invalid coprocessor condition: %s", mnemonicLiteral)
+	}
+
+	return condition, nil
+}
+
+// ParseHintSuffixes returns the condition of a NOP, YIELD, WFE, WFI or SEV.
+func ParseHintSuffixes(mnemonicLiteral string) (types.ConditionType, error) {
+	if len(mnemonicLiteral) < 3 || len(mnemonicLiteral) > 7 {
+		return types.ConditionAL, fmt.Errorf("invalid hint mnemonic length: %s", mnemonicLiteral)
+	}
+	mnemonicLiteral = strings.ToLower(mnemonicLiteral)
+	if strings.HasPrefix(mnemonicLiteral, "yield") {
+		mnemonicLiteral = mnemonicLiteral[5:] // Remove YIELD
+	} else {
+		mnemonicLiteral = mnemonicLiteral[3:] // Remove NOP/WFE/WFI/SEV
+	}
+
+	if len(mnemonicLiteral) == 0 {
+		return types.ConditionAL, nil // No condition specified, default to AL
+	}
+
+	condition, ok := types.LiteralToCondition[mnemonicLiteral]
+	if !ok {
+		return types.ConditionAL, fmt.Errorf("invalid hint condition: %s", mnemonicLiteral)
+	}
+
+	return condition, nil
+}
+
+// ParseExclusiveSuffixes returns the condition of an LDREX or STREX.
+func ParseExclusiveSuffixes(mnemonicLiteral string) (types.ConditionType, error) {
+	if len(mnemonicLiteral) < 5 || len(mnemonicLiteral) > 7 {
+		return types.ConditionAL, fmt.Errorf("invalid exclusive mnemonic length: %s", mnemonicLiteral)
+	}
+	mnemonicLiteral = strings.ToLower(mnemonicLiteral[5:]) // Remove LDREX/STREX
+
+	if len(mnemonicLiteral) == 0 {
+		return types.ConditionAL, nil // No condition specified, default to AL
+	}
+
+	condition, ok := types.LiteralToCondition[mnemonicLiteral]
+	if !ok {
+		return types.ConditionAL, fmt.Errorf("invalid exclusive condition: %s", mnemonicLiteral)
+	}
+
+	return condition, nil
+}
+
+// ParseMediaSuffixes returns the condition of a CLZ, REV, REV16, extend, bit field or divide instruction.
+func ParseMediaSuffixes(mnemonicLiteral string) (types.ConditionType, error) {
+	if len(mnemonicLiteral) < 3 || len(mnemonicLiteral) > 7 {
+		return types.ConditionAL, fmt.Errorf("invalid media mnemonic length: %s", mnemonicLiteral)
+	}
+	mnemonicLiteral = strings.ToLower(mnemonicLiteral)
+	switch {
+	case strings.HasPrefix(mnemonicLiteral, "rev16"):
+		mnemonicLiteral = mnemonicLiteral[5:] // Remove REV16
+	case strings.HasPrefix(mnemonicLiteral, "clz"), strings.HasPrefix(mnemonicLiteral, "rev"), strings.HasPrefix(mnemonicLiteral, "bfi"):
+		mnemonicLiteral = mnemonicLiteral[3:] // Remove CLZ/REV/BFI
+	default:
+		mnemonicLiteral = mnemonicLiteral[4:] // Remove the extends, UBFX/SBFX and SDIV/UDIV
+	}
+
+	if len(mnemonicLiteral) == 0 {
+		return types.ConditionAL, nil // No condition specified, default to AL
+	}
+
+	condition, ok := types.LiteralToCondition[mnemonicLiteral]
+	if !ok {
+		return types.ConditionAL, fmt.Errorf("invalid media condition: %s", mnemonicLiteral)
+	}
+
+	return condition, nil
+}
+
+// ParseFloatSuffixes returns the condition of a VFP or NEON instruction, all of whose mnemonics are 4 letters long.
+func ParseFloatSuffixes(mnemonicLiteral string) (types.ConditionType, error) {
+	if len(mnemonicLiteral) != 4 && len(mnemonicLiteral) != 6 {
+		return types.ConditionAL, fmt.Errorf("invalid floating-point mnemonic length: %s", mnemonicLiteral)
+	}
+	mnemonicLiteral = strings.ToLower(mnemonicLiteral[4:]) // Remove VADD/VLDR/VLD1 etc
+	if mnemonicLiteral == "" {
+		return types.ConditionAL, nil
+	}
+	condition, ok := types.LiteralToCondition[mnemonicLiteral]
+	if !ok {
+		return types.ConditionAL, fmt.Errorf("invalid floating-point condition: %s", mnemonicLiteral)
+	}
+	return condition, nil
+}
+
+// ParseDataType parses a data type suffix, with or without its leading '.'.
+func ParseDataType(literal string) (types.DataType, error) {
+	lit := strings.ToUpper(strings.TrimPrefix(literal, "."))
+	var dataType types.DataType
+	if lit != "" && strings.IndexByte("FSUI", lit[0]) >= 0 {
+		dataType.Kind, lit = lit[0], lit[1:]
+	}
+	switch lit {
+	case "8":
+		dataType.Size = 8
+	case "16":
+		dataType.Size = 16
+	case "32":
+		dataType.Size = 32
+	case "64":
+		dataType.Size = 64
+	default:
+		return types.DataType{}, fmt.Errorf("invalid data type %s", literal)
+	}
+	if dataType.Kind == 'F' && dataType.Size < 32 {
+		return types.DataType{}, fmt.Errorf("invalid data type %s, floats are 32 or 64 bits", literal)
+	}
+	return dataType, nil
+}
+
 // Little endian
 func BitsToBytes(bits uint32) []byte {
 	bytes := make([]byte, 4)
@@ -0,0 +1,67 @@
+package utils
+
+import "fmt"
+
+// The fixed bits of the second halfword of the 32 bit Thumb branches, which tell them apart
+const (
+	ThumbBranchConditional = 0x8000 // B<c>.W
+	ThumbBranchB           = 0x9000 // B.W
+	ThumbBranchBLX         = 0xC000
+	ThumbBranchBL          = 0xD000
+)
+
+// ThumbToBytes splits a Thumb instruction into bytes. 32 bit instructions are two little endian halfwords, the first
+// one (in the top half of value) at the lower address.
+func ThumbToBytes(value uint32, size uint32) []byte {
+	if size == 2 {
+		return []byte{byte(value), byte(value >> 8)}
+	}
+	return []byte{byte(value >> 16), byte(value >> 24), byte(value), byte(value >> 8)}
+}
+
+// ThumbFromBytes joins the two halfwords of a 32 bit Thumb instruction, the first one in the top half.
+func ThumbFromBytes(bytes []byte) uint32 {
+	return uint32(bytes[0])<<16 | uint32(bytes[1])<<24 | uint32(bytes[2]) | uint32(bytes[3])<<8
+}
+
+// ThumbBranchKind returns which 32 bit branch a Thumb instruction is, one of the ThumbBranch constants.
+func ThumbBranchKind(value uint32) uint32 {
+	if value&0x5000 == 0 {
+		return ThumbBranchConditional
+	}
+	return value & 0xD000
+}
+
+// ThumbBranch encodes a 32 bit Thumb B, BL or BLX (kind is ThumbBranchB, ThumbBranchBL or ThumbBranchBLX) with an
+// offset in bytes from the PC, which for BLX is the PC rounded down to a word.
+func ThumbBranch(kind uint32, offset int64) (uint32, error) {
+	if offset < -(1<<24) || offset >= 1<<24 {
+		return 0, fmt.Errorf("offset %d is out of range of a 32 bit Thumb branch", offset)
+	}
+	if offset%2 != 0 || kind == ThumbBranchBLX && offset%4 != 0 {
+		return 0, fmt.Errorf("offset %d isn't aligned", offset)
+	}
+	imm := uint32(offset)
+	s := imm >> 24 & 1
+	j1 := ^(imm>>23 ^ s) & 1
+	j2 := ^(imm>>22 ^ s) & 1
+	return (0xF000|s<<10|imm>>12&0x3FF)<<16 | kind | j1<<13 | j2<<11 | imm>>1&0x7FF, nil
+}
+
+// ThumbConditionalBranch encodes a 32 bit Thumb B<c>.W with an offset in bytes from the PC.
+func ThumbConditionalBranch(condition uint32, offset int64) (uint32, error) {
+	if offset < -(1<<20) || offset >= 1<<20 {
+		return 0, fmt.Errorf("offset %d is out of range of a 32 bit conditional Thumb branch", offset)
+	}
+	if offset%2 != 0 {
+		return 0, fmt.Errorf("offset %d isn't aligned", offset)
+	}
+	imm := uint32(offset)
+	return (0xF000|imm>>20&1<<10|condition<<6|imm>>12&0x3F)<<16 | ThumbBranchConditional | imm>>18&1<<13 | imm>>19&1<<11 | imm>>1&0x7FF, nil
+}
+
+// ThumbMOVImmediate puts a 16 bit immediate into a Thumb MOVW or MOVT, where it's split into imm4:i:imm3:imm8.
+func ThumbMOVImmediate(value uint32, immediate uint32) uint32 {
+	value &^= 0x040F70FF
+	return value | immediate>>12&0xF<<16 | immediate>>11&1<<26 | immediate>>8&0x7<<12 | immediate&0xFF
+}